@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// Formats /search can return chunk results in.
+const (
+	formatJSON     = "json"
+	formatCSV      = "csv"
+	formatJSONL    = "jsonl"
+	formatMarkdown = "markdown"
+)
+
+// formatTypes maps each format to its media type.
+var formatTypes = map[string]string{
+	formatJSON:     "application/json",
+	formatCSV:      "text/csv; charset=utf-8",
+	formatJSONL:    "application/x-ndjson",
+	formatMarkdown: "text/markdown; charset=utf-8",
+}
+
+// resultFormat picks the format of a /search response: the format parameter
+// when set, otherwise the first supported type in the Accept header, and
+// JSON when neither names one.
+func resultFormat(r *http.Request) (string, error) {
+	if f := strings.ToLower(r.URL.Query().Get("format")); f != "" {
+		if f == "md" {
+			f = formatMarkdown
+		}
+		if _, ok := formatTypes[f]; !ok {
+			return "", errors.New("format must be one of json, csv, jsonl or markdown")
+		}
+		return f, nil
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mt {
+		case "application/json", "*/*":
+			return formatJSON, nil
+		case "text/csv":
+			return formatCSV, nil
+		case "application/x-ndjson", "application/jsonl":
+			return formatJSONL, nil
+		case "text/markdown":
+			return formatMarkdown, nil
+		}
+	}
+	return formatJSON, nil
+}
+
+// resultFields maps each field the fields parameter can select to its value
+// in a chunk result. Names match the JSON of SearchResult and its chunk.
+var resultFields = map[string]func(models.SearchResult) any{
+	"id":         func(r models.SearchResult) any { return r.Chunk.ID },
+	"repository": func(r models.SearchResult) any { return r.Chunk.Repository },
+	"ref":        func(r models.SearchResult) any { return r.Chunk.Ref },
+	"path":       func(r models.SearchResult) any { return r.Chunk.Path },
+	"language":   func(r models.SearchResult) any { return r.Chunk.Language },
+	"line_start": func(r models.SearchResult) any { return r.Chunk.LineStart },
+	"line_end":   func(r models.SearchResult) any { return r.Chunk.LineEnd },
+	"summary":    func(r models.SearchResult) any { return r.Chunk.Summary },
+	"content":    func(r models.SearchResult) any { return r.Chunk.Content },
+	"score":      func(r models.SearchResult) any { return r.Score },
+	"permalink":  func(r models.SearchResult) any { return r.Permalink },
+	"snippet":    func(r models.SearchResult) any { return r.Snippet },
+	"highlights": func(r models.SearchResult) any { return r.Highlights },
+	"before":     func(r models.SearchResult) any { return r.Before },
+	"after":      func(r models.SearchResult) any { return r.After },
+	"explain":    func(r models.SearchResult) any { return r.Explain },
+}
+
+// parseFields parses a comma-separated list of result fields, e.g.
+// "path,score,summary". An empty list returns nil, which selects whole
+// results.
+func parseFields(s string) ([]string, error) {
+	var fields []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" || slices.Contains(fields, f) {
+			continue
+		}
+		if _, ok := resultFields[f]; !ok {
+			names := make([]string, 0, len(resultFields))
+			for name := range resultFields {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			return nil, fmt.Errorf("unknown field %q (expected any of %s)", f, strings.Join(names, ", "))
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// fieldsNeedContent reports whether the selected fields show chunk content,
+// or snippets of it, so that it need not be fetched otherwise.
+func fieldsNeedContent(fields []string) bool {
+	return fields == nil || slices.ContainsFunc(fields, func(f string) bool {
+		return f == "content" || f == "before" || f == "after" || f == "snippet" || f == "highlights"
+	})
+}
+
+// selectFields returns r as an object holding only the given fields.
+func selectFields(r models.SearchResult, fields []string) map[string]any {
+	out := make(map[string]any, len(fields))
+	for _, f := range fields {
+		out[f] = resultFields[f](r)
+	}
+	return out
+}
+
+// projectResults returns res unchanged when fields is nil, and otherwise
+// each result reduced to the given fields.
+func projectResults(res []models.SearchResult, fields []string) any {
+	if fields == nil {
+		return res
+	}
+	out := make([]map[string]any, len(res))
+	for i, r := range res {
+		out[i] = selectFields(r, fields)
+	}
+	return out
+}
+
+// writeResults writes chunk results as CSV, JSON lines or a Markdown table.
+// CSV and Markdown have one row per result with its location, score and
+// summary; JSON lines have one SearchResult per line, reduced to fields when
+// it is not nil.
+func writeResults(w http.ResponseWriter, format string, res []models.SearchResult, fields []string) error {
+	w.Header().Set("Content-Type", formatTypes[format])
+	switch format {
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"repository", "ref", "path", "line_start", "line_end", "score", "summary"})
+		for _, r := range res {
+			c := r.Chunk
+			_ = cw.Write([]string{c.Repository, c.Ref, c.Path, strconv.Itoa(c.LineStart), strconv.Itoa(c.LineEnd),
+				strconv.FormatFloat(r.Score, 'f', 4, 64), c.Summary})
+		}
+		cw.Flush()
+		return cw.Error()
+	case formatJSONL:
+		enc := json.NewEncoder(w)
+		for _, r := range res {
+			var v any = r
+			if fields != nil {
+				v = selectFields(r, fields)
+			}
+			if err := enc.Encode(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	case formatMarkdown:
+		return writeMarkdown(w, res)
+	}
+	return fmt.Errorf("unsupported format %q", format)
+}
+
+func writeMarkdown(w io.Writer, res []models.SearchResult) error {
+	cell := strings.NewReplacer("|", `\|`, "\r\n", " ", "\n", " ", "\r", " ")
+	if _, err := io.WriteString(w, "| Repository | Path | Lines | Score | Summary |\n| --- | --- | --- | ---: | --- |\n"); err != nil {
+		return err
+	}
+	for _, r := range res {
+		c := r.Chunk
+		repo := c.Repository
+		if c.Ref != "" {
+			repo += "@" + c.Ref
+		}
+		if _, err := fmt.Fprintf(w, "| %s | `%s` | %d-%d | %.3f | %s |\n",
+			cell.Replace(repo), cell.Replace(c.Path), c.LineStart, c.LineEnd, r.Score, cell.Replace(c.Summary)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/seanblong/reposearch/internal/auth"
+	"github.com/seanblong/reposearch/internal/config"
+	"github.com/seanblong/reposearch/internal/graphql"
+	"github.com/seanblong/reposearch/internal/search"
+	"github.com/seanblong/reposearch/internal/source"
+	"github.com/seanblong/reposearch/internal/store"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// graphqlSDL is the schema served at /graphql.
+const graphqlSDL = `schema {
+  query: Query
+}
+
+type Query {
+  repositories: [Repository!]!
+  """An indexed repository, or null."""
+  repository(name: String!): Repository
+  chunk(id: ID!): Chunk
+  """Ranks chunks for q like /search."""
+  search(q: String!, k: Int, offset: Int, repository: [String!], language: [String!], ref: String, path: String, pathContains: String, pathNotContains: [String!], pathRegex: String): [SearchResult!]!
+}
+
+type Repository {
+  name: String!
+  refs: [Ref!]!
+  ref(name: String!): Ref
+}
+
+"""An indexed branch or tag of a repository."""
+type Ref {
+  name: String!
+  repository: String!
+  """The chunks of a file, ordered by line range; empty for a file that isn't indexed."""
+  file(path: String!): [Chunk!]!
+}
+
+"""An indexed span of lines of a file."""
+type Chunk {
+  id: ID!
+  repository: String!
+  ref: String!
+  path: String!
+  language: String!
+  summary: String!
+  """The chunk's source, fetched from the repository host when the index doesn't store it."""
+  content: String!
+  lineStart: Int!
+  lineEnd: Int!
+  """RFC 3339 time the chunk was indexed."""
+  createdAt: String!
+  """Link to the chunk's lines on the repository's host, when the host is known."""
+  permalink: String
+}
+
+type SearchResult {
+  chunk: Chunk!
+  score: Float!
+  """A few lines of the chunk around the best matching query terms."""
+  snippet: String
+  """The matches in snippet."""
+  highlights: [Highlight!]
+}
+
+"""A match in a snippet, as byte offsets."""
+type Highlight {
+  start: Int!
+  end: Int!
+}
+`
+
+// graphqlSchema exposes repositories, refs, chunks and search over
+// /graphql. Chunk content is only fetched when it is selected, so results
+// without content cost no more than /search with content=false.
+func graphqlSchema(cfg config.Specification, authn *auth.Service, st store.Backend, svc *search.Service, sources source.Fetcher, quotas *quotas) (*graphql.Schema, error) {
+	return graphql.NewSchema(graphqlSDL, &graphqlQuery{cfg: cfg, authn: authn, st: st, svc: svc, sources: sources, quotas: quotas})
+}
+
+// graphqlQuery resolves the Query type; the types below resolve the others.
+type graphqlQuery struct {
+	cfg     config.Specification
+	authn   *auth.Service
+	st      store.Backend
+	svc     *search.Service
+	sources source.Fetcher
+	quotas  *quotas
+}
+
+func (q *graphqlQuery) Repositories(ctx context.Context) ([]*graphqlRepository, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.cfg.Server.LookupTimeout)
+	defer cancel()
+	names, err := q.st.GetRepositories(ctx)
+	if r, ok := graphql.HTTPRequest(ctx); ok && err == nil {
+		names, err = visibleRepositories(ctx, q.authn, q.st, r, names)
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*graphqlRepository, len(names))
+	for i, name := range names {
+		out[i] = &graphqlRepository{q: q, name: name}
+	}
+	return out, nil
+}
+
+func (q *graphqlQuery) Repository(ctx context.Context, args struct{ Name string }) (*graphqlRepository, error) {
+	if ok, err := q.readable(ctx, args.Name); err != nil || !ok {
+		return nil, err
+	}
+	refs, err := q.refs(ctx, args.Name)
+	if err != nil || len(refs) == 0 {
+		return nil, err
+	}
+	return &graphqlRepository{q: q, name: args.Name}, nil
+}
+
+func (q *graphqlQuery) Chunk(ctx context.Context, args struct{ ID graphql.ID }) (*graphqlChunk, error) {
+	lctx, cancel := context.WithTimeout(ctx, q.cfg.Server.LookupTimeout)
+	defer cancel()
+	c, ok, err := q.st.GetChunkByID(lctx, string(args.ID))
+	if ok {
+		ok, err = q.readable(ctx, c.Repository)
+	}
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &graphqlChunk{q: q, c: c}, nil
+}
+
+// graphqlSearchArgs are the arguments of Query.search.
+type graphqlSearchArgs struct {
+	Q               string
+	K               *int32
+	Offset          *int32
+	Repository      *[]string
+	Language        *[]string
+	Ref             *string
+	Path            *string
+	PathContains    *string
+	PathNotContains *[]string
+	PathRegex       *string
+}
+
+func (q *graphqlQuery) Search(ctx context.Context, args graphqlSearchArgs) ([]*graphqlResult, error) {
+	start := time.Now()
+	k := q.cfg.SearchDefaultK
+	if args.K != nil {
+		if *args.K < 1 || int(*args.K) > q.cfg.SearchMaxK {
+			return nil, fmt.Errorf("k must be between 1 and %d", q.cfg.SearchMaxK)
+		}
+		k = int(*args.K)
+	}
+	str := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	list := func(l *[]string) []string {
+		if l == nil {
+			return nil
+		}
+		return *l
+	}
+	opt, err := filterOpts(models.SearchFilters{
+		Repositories:    list(args.Repository),
+		Languages:       list(args.Language),
+		Ref:             str(args.Ref),
+		Path:            str(args.Path),
+		PathContains:    str(args.PathContains),
+		PathNotContains: list(args.PathNotContains),
+		PathRegex:       str(args.PathRegex),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if args.Offset != nil {
+		if *args.Offset < 0 {
+			return nil, fmt.Errorf("offset must be a non-negative integer")
+		}
+		opt.Offset = int(*args.Offset)
+	}
+	r, fromHTTP := graphql.HTTPRequest(ctx)
+	if fromHTTP {
+		opt.Principals = principals(q.authn, r)
+		opt.AllowedRepositories = allowedRepositories(r)
+		if err := charge(nil, r, q.st, q.quotas, quotaSearch, 1); err != nil {
+			return nil, err
+		}
+	}
+
+	qctx, cancel := context.WithTimeout(ctx, q.cfg.Server.RequestTimeout)
+	defer cancel()
+	res, err := q.svc.Query(qctx, args.Q, k, opt)
+	if err != nil {
+		return nil, err
+	}
+	if fromHTTP {
+		if q.cfg.QueryLog {
+			writeQueryLog(q.st, r, store.QueryLog{Query: args.Q, KeepText: q.cfg.QueryLogText, Filters: filterValues(opt).Encode(), K: k, Latency: time.Since(start), Results: len(res), At: start})
+		}
+		if q.cfg.Auth.Enabled {
+			auditSearch(q.st, r, args.Q, opt, len(res), resultRepositories(res))
+		}
+	}
+	out := make([]*graphqlResult, len(res))
+	for i := range res {
+		if math.IsNaN(res[i].Score) || math.IsInf(res[i].Score, 0) {
+			res[i].Score = 0
+		}
+		out[i] = &graphqlResult{q: q, r: res[i], query: args.Q}
+	}
+	return out, nil
+}
+
+// readable reports whether the user of the HTTP request behind ctx may read
+// a repository.
+func (q *graphqlQuery) readable(ctx context.Context, repository string) (bool, error) {
+	r, ok := graphql.HTTPRequest(ctx)
+	if !ok {
+		return true, nil
+	}
+	role, err := repositoryRole(ctx, q.authn, q.st, r, repository)
+	return role != "", err
+}
+
+func (q *graphqlQuery) refs(ctx context.Context, repository string) ([]*graphqlRef, error) {
+	ctx, cancel := context.WithTimeout(ctx, q.cfg.Server.LookupTimeout)
+	defer cancel()
+	names, err := q.st.GetRefs(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*graphqlRef, len(names))
+	for i, name := range names {
+		out[i] = &graphqlRef{q: q, repository: repository, name: name}
+	}
+	return out, nil
+}
+
+type graphqlRepository struct {
+	q    *graphqlQuery
+	name string
+}
+
+func (r *graphqlRepository) Name() string { return r.name }
+
+func (r *graphqlRepository) Refs(ctx context.Context) ([]*graphqlRef, error) {
+	return r.q.refs(ctx, r.name)
+}
+
+func (r *graphqlRepository) Ref(ctx context.Context, args struct{ Name string }) (*graphqlRef, error) {
+	refs, err := r.q.refs(ctx, r.name)
+	for _, ref := range refs {
+		if ref.name == args.Name {
+			return ref, nil
+		}
+	}
+	return nil, err
+}
+
+type graphqlRef struct {
+	q                *graphqlQuery
+	repository, name string
+}
+
+func (r *graphqlRef) Name() string       { return r.name }
+func (r *graphqlRef) Repository() string { return r.repository }
+
+func (r *graphqlRef) File(ctx context.Context, args struct{ Path string }) ([]*graphqlChunk, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.q.cfg.Server.LookupTimeout)
+	defer cancel()
+	chunks, err := r.q.st.GetFileChunks(ctx, r.repository, r.name, args.Path)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*graphqlChunk, len(chunks))
+	for i, c := range chunks {
+		out[i] = &graphqlChunk{q: r.q, c: c}
+	}
+	return out, nil
+}
+
+type graphqlChunk struct {
+	q *graphqlQuery
+	c models.Chunk
+}
+
+func (c *graphqlChunk) ID() graphql.ID     { return graphql.ID(c.c.ID) }
+func (c *graphqlChunk) Repository() string { return c.c.Repository }
+func (c *graphqlChunk) Ref() string        { return c.c.Ref }
+func (c *graphqlChunk) Path() string       { return c.c.Path }
+func (c *graphqlChunk) Language() string   { return c.c.Language }
+func (c *graphqlChunk) Summary() string    { return c.c.Summary }
+func (c *graphqlChunk) LineStart() int32   { return int32(c.c.LineStart) }
+func (c *graphqlChunk) LineEnd() int32     { return int32(c.c.LineEnd) }
+func (c *graphqlChunk) CreatedAt() string  { return c.c.CreatedAt.Format(time.RFC3339Nano) }
+
+func (c *graphqlChunk) Content(ctx context.Context) string {
+	fillContent(ctx, c.q.sources, &c.c)
+	return c.c.Content
+}
+
+func (c *graphqlChunk) Permalink() *string {
+	if p := chunkPermalink(c.c); p != "" {
+		return &p
+	}
+	return nil
+}
+
+type graphqlResult struct {
+	q       *graphqlQuery
+	r       models.SearchResult
+	query   string
+	snippet sync.Once
+}
+
+func (r *graphqlResult) Chunk() *graphqlChunk { return &graphqlChunk{q: r.q, c: r.r.Chunk} }
+func (r *graphqlResult) Score() float64       { return r.r.Score }
+
+// fillSnippet computes the snippet of a result whose content the store does
+// not hold, fetching the content first. Snippet and highlights may be
+// resolved concurrently, so it runs once.
+func (r *graphqlResult) fillSnippet(ctx context.Context) {
+	r.snippet.Do(func() {
+		if r.r.Snippet != "" || r.q.sources == nil {
+			return
+		}
+		res := []models.SearchResult{{Chunk: r.r.Chunk}}
+		fillContent(ctx, r.q.sources, &res[0].Chunk)
+		fillSnippets(r.q.sources, r.query, res)
+		r.r.Snippet, r.r.Highlights = res[0].Snippet, res[0].Highlights
+	})
+}
+
+func (r *graphqlResult) Snippet(ctx context.Context) *string {
+	r.fillSnippet(ctx)
+	if r.r.Snippet == "" {
+		return nil
+	}
+	return &r.r.Snippet
+}
+
+func (r *graphqlResult) Highlights(ctx context.Context) *[]*graphqlHighlight {
+	r.fillSnippet(ctx)
+	if r.r.Highlights == nil {
+		return nil
+	}
+	out := make([]*graphqlHighlight, len(r.r.Highlights))
+	for i, h := range r.r.Highlights {
+		out[i] = &graphqlHighlight{h}
+	}
+	return &out
+}
+
+type graphqlHighlight struct{ h models.Highlight }
+
+func (h *graphqlHighlight) Start() int32 { return int32(h.h.Start) }
+func (h *graphqlHighlight) End() int32   { return int32(h.h.End) }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+	"github.com/seanblong/reposearch/internal/ai"
+	"github.com/seanblong/reposearch/internal/store"
+)
+
+// Settings of /readyz.
+const (
+	readyTimeout      = 3 * time.Second
+	providerCheckTTL  = time.Minute // how long a provider check result is reused
+	providerCheckText = "readiness check"
+)
+
+// Readiness is the response of /readyz: "ok" or an error for each check.
+type Readiness struct {
+	Status string            `json:"status"` // "ok" or "unavailable"
+	Checks map[string]string `json:"checks"`
+}
+
+// providerCheck embeds a short text to verify that the AI provider answers.
+// Results are cached for providerCheckTTL so that frequent probes don't
+// turn into a stream of paid API calls.
+type providerCheck struct {
+	client ai.Client
+
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+func (p *providerCheck) check(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.checked.IsZero() && time.Since(p.checked) < providerCheckTTL {
+		return p.err
+	}
+	// Embed takes no context, so the timeout only bounds the wait.
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.client.Embed(providerCheckText)
+		done <- err
+	}()
+	select {
+	case p.err = <-done:
+	case <-ctx.Done():
+		p.err = fmt.Errorf("no response: %w", ctx.Err())
+	}
+	p.checked = time.Now()
+	return p.err
+}
+
+// readyz reports whether the server can serve searches: the database must
+// answer and, when provider is set, so must the AI provider. It replies 503
+// when a check fails so that load balancers stop routing to the server.
+func readyz(st store.Backend, provider *providerCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+
+		res := Readiness{Status: "ok", Checks: map[string]string{}}
+		record := func(name string, err error) {
+			if err != nil {
+				hlog.FromRequest(r).Warn().Err(err).Str("check", name).Msg("readiness check failed")
+				res.Status = "unavailable"
+				res.Checks[name] = err.Error()
+				return
+			}
+			res.Checks[name] = "ok"
+		}
+		record("database", st.Ping(ctx))
+		if provider != nil {
+			record("provider", provider.check(ctx))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if res.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
+
+// livez reports that the process is up and serving HTTP. It checks no
+// dependencies, so an outage of the database doesn't get pods restarted.
+func livez(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
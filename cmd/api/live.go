@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/hlog"
+	"github.com/seanblong/reposearch/internal/search"
+	"github.com/seanblong/reposearch/internal/store"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// Settings of /search/live.
+const (
+	liveDebounce = 250 * time.Millisecond // quiet period before a query runs
+	liveDefaultK = 5
+	liveMaxK     = 20
+	liveMaxQuery = 4096 // bytes per message
+)
+
+// LiveQuery is a message sent by a /search/live client: the query text as
+// typed so far. Seq is echoed in the response so the client can match it.
+type LiveQuery struct {
+	Seq int    `json:"seq,omitempty"`
+	Q   string `json:"q"`
+	K   int    `json:"k,omitempty"`
+}
+
+// LiveResults is sent by /search/live for each query that ran. Chunk content
+// is omitted; results carry a snippet.
+type LiveResults struct {
+	Seq     int                   `json:"seq,omitempty"`
+	Q       string                `json:"q"`
+	Results []models.SearchResult `json:"results"`
+	Error   string                `json:"error,omitempty"`
+}
+
+// liveUpgrader rejects cross-origin connections, as browsers send cookies
+// with WebSocket handshakes regardless of CORS.
+var liveUpgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 4096}
+
+// liveSearch serves a /search/live connection. Queries are run once the
+// client has stopped sending for liveDebounce, and a search still running
+// when a newer query settles is cancelled, so each burst of keystrokes costs
+// at most one search. Each search may take up to timeout, and is first
+// charged with meter, whose error is sent instead of results. When audit is
+// set, it is called with the results of every search sent to the client.
+func liveSearch(w http.ResponseWriter, r *http.Request, svc *search.Service, opt store.QueryOpts, timeout time.Duration, meter func() error, audit func(LiveResults)) {
+	conn, err := liveUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // the upgrader has already replied
+	}
+	defer func() { _ = conn.Close() }()
+	conn.SetReadLimit(liveMaxQuery)
+	logger := hlog.FromRequest(r)
+
+	queries := make(chan LiveQuery)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(queries)
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var q LiveQuery
+			if err := json.Unmarshal(msg, &q); err != nil {
+				// Close may be sent while the other goroutine writes.
+				_ = conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseUnsupportedData, "invalid query: "+err.Error()),
+					time.Now().Add(time.Second))
+				return
+			}
+			select {
+			case queries <- q:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	var (
+		pending  *LiveQuery
+		debounce = time.NewTimer(liveDebounce)
+		results  = make(chan LiveResults, 1)
+		stop     = func() {}
+	)
+	debounce.Stop()
+	defer func() { stop() }()
+	for {
+		select {
+		case q, ok := <-queries:
+			if !ok {
+				return
+			}
+			pending = &q
+			debounce.Reset(liveDebounce)
+		case <-debounce.C:
+			q := *pending
+			stop()
+			qctx, qcancel := context.WithTimeout(ctx, timeout)
+			stop = qcancel
+			go func() {
+				res := runLiveQuery(qctx, svc, q, opt, meter)
+				if qctx.Err() == context.Canceled {
+					return // superseded by a newer query
+				}
+				select {
+				case results <- res:
+				case <-ctx.Done():
+				}
+			}()
+		case res := <-results:
+			if err := conn.WriteJSON(res); err != nil {
+				logger.Debug().Err(err).Msg("live search client gone")
+				return
+			}
+			if audit != nil && res.Error == "" && strings.TrimSpace(res.Q) != "" {
+				audit(res)
+			}
+		}
+	}
+}
+
+func runLiveQuery(ctx context.Context, svc *search.Service, q LiveQuery, opt store.QueryOpts, meter func() error) LiveResults {
+	out := LiveResults{Seq: q.Seq, Q: q.Q, Results: []models.SearchResult{}}
+	k := liveDefaultK
+	if q.K != 0 {
+		k = min(max(q.K, 1), liveMaxK)
+	}
+	text := strings.TrimSpace(q.Q)
+	if text == "" {
+		return out
+	}
+	if err := meter(); err != nil {
+		out.Error = err.Error()
+		return out
+	}
+	res, err := svc.Query(ctx, text, k, opt)
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+	for i := range res {
+		res[i].Chunk.Content = ""
+		if math.IsNaN(res[i].Score) || math.IsInf(res[i].Score, 0) {
+			res[i].Score = 0
+		}
+	}
+	out.Results = res
+	return out
+}
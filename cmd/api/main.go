@@ -2,15 +2,22 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"net/http"
 	"net/url"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -18,10 +25,17 @@ import (
 	"github.com/seanblong/reposearch/internal/ai"
 	"github.com/seanblong/reposearch/internal/auth"
 	"github.com/seanblong/reposearch/internal/config"
+	"github.com/seanblong/reposearch/internal/graphql"
+	"github.com/seanblong/reposearch/internal/httpcompress"
+	"github.com/seanblong/reposearch/internal/indexer"
 	"github.com/seanblong/reposearch/internal/search"
+	"github.com/seanblong/reposearch/internal/source"
 	"github.com/seanblong/reposearch/internal/store"
+	"github.com/seanblong/reposearch/internal/storeconfig"
+	"github.com/seanblong/reposearch/internal/webui"
 	"github.com/seanblong/reposearch/pkg/models"
 	"github.com/spf13/pflag"
+	"golang.org/x/sync/errgroup"
 )
 
 type Simple struct {
@@ -34,6 +48,7 @@ type Simple struct {
 	Summary    string  `json:"summary,omitempty"`
 	Ref        string  `json:"ref,omitempty"`
 	Repository string  `json:"repository,omitempty"`
+	Permalink  string  `json:"permalink,omitempty"`
 }
 
 func output(res []models.SearchResult) (out []Simple) {
@@ -58,73 +73,702 @@ func output(res []models.SearchResult) (out []Simple) {
 			Summary:    r.Chunk.Summary,
 			Ref:        r.Chunk.Ref,
 			Repository: r.Chunk.Repository,
+			Permalink:  chunkPermalink(r.Chunk),
 		})
 	}
 	return out
 }
 
+// chunkPermalink links to the lines of c on its repository's host.
+func chunkPermalink(c models.Chunk) string {
+	return source.Permalink(c.Repository, c.Ref, c.Path, c.LineStart, c.LineEnd)
+}
+
+// DeleteResponse reports the outcome of DELETE /repositories/{repo}.
+type DeleteResponse struct {
+	Repository    string `json:"repository"`
+	ChunksDeleted int64  `json:"chunks_deleted"`
+}
+
+// AskRequest is the body of POST /ask.
+type AskRequest struct {
+	Question     string   `json:"question"`
+	K            int      `json:"k,omitempty"` // chunks given to the model, default defaultAskK
+	Repositories []string `json:"repositories,omitempty"`
+	Languages    []string `json:"languages,omitempty"`
+	Ref          string   `json:"ref,omitempty"`
+	PathContains string   `json:"path_contains,omitempty"`
+}
+
+// Bounds on the number of chunks /ask gives to the model.
+const (
+	defaultAskK = 8
+	maxAskK     = 20
+)
+
+// ChatRequest is the body of POST /chat. Without a session id a new
+// conversation is started.
+type ChatRequest struct {
+	SessionID    string   `json:"session_id,omitempty"`
+	Message      string   `json:"message"`
+	K            int      `json:"k,omitempty"` // chunks given to the model, default defaultAskK
+	Repositories []string `json:"repositories,omitempty"`
+	Languages    []string `json:"languages,omitempty"`
+	Ref          string   `json:"ref,omitempty"`
+	PathContains string   `json:"path_contains,omitempty"`
+}
+
+// ChatSources is the first event of a /chat stream.
+type ChatSources struct {
+	SessionID string            `json:"session_id"`
+	Query     string            `json:"query"` // the message rewritten as a standalone search
+	Sources   []models.Citation `json:"sources"`
+}
+
+// ChatSession is the response of GET /chat/{session}.
+type ChatSession struct {
+	SessionID string            `json:"session_id"`
+	Turns     []models.ChatTurn `json:"turns"`
+}
+
+// errSessionNotFound is returned for missing chat sessions and for sessions
+// started by another user, so that session ids cannot be probed.
+var errSessionNotFound = errors.New("chat session not found")
+
+// chatHistory returns the turns of a chat session that the user of r may
+// continue.
+func chatHistory(ctx context.Context, st store.Backend, r *http.Request, sessionID string) ([]models.ChatTurn, error) {
+	turns, owner, err := st.ChatHistory(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if owner != "" {
+		if u := auth.GetUserFromContext(r); u == nil || u.Login != owner {
+			return nil, errSessionNotFound
+		}
+	}
+	return turns, nil
+}
+
+// notModified sets validators on a listing derived from the index, such as
+// the repositories or refs, and replies 304 when the client's copy is still
+// current. The ETag is the index version, read before the listing so that a
+// concurrent write can only make the ETag older than the data, followed by
+// variant when the listing also depends on the caller. Listings are served
+// without validators when the version cannot be read. As listings may be
+// filtered by what the caller can see, shared caches must not store them.
+func notModified(ctx context.Context, w http.ResponseWriter, r *http.Request, st store.Backend, variant string) bool {
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("Vary", "Authorization, Cookie")
+	v, err := st.IndexVersion(ctx)
+	if err != nil {
+		hlog.FromRequest(r).Debug().Err(err).Msg("index version unavailable")
+		return false
+	}
+	etag := fmt.Sprintf(`W/"%d"`, v)
+	if variant != "" {
+		etag = fmt.Sprintf(`W/"%d-%s"`, v, variant)
+	}
+	w.Header().Set("ETag", etag)
+	for _, m := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		if m = strings.TrimSpace(m); m == "*" || strings.TrimPrefix(m, "W/") == strings.TrimPrefix(etag, "W/") {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// visibilityVariant returns a short hash of what limits the repositories
+// the request's caller sees: its grant principals and the repositories its
+// API key or GitHub access allows.
+func visibilityVariant(authn *auth.Service, r *http.Request) string {
+	h := sha256.New()
+	for _, set := range [][]string{principals(authn, r), allowedRepositories(r)} {
+		if set == nil {
+			fmt.Fprint(h, "*\x00")
+			continue
+		}
+		set = slices.Sorted(slices.Values(set))
+		fmt.Fprintf(h, "%d\x00%s\x00", len(set), strings.Join(set, "\x00"))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// principals returns the grant principals of the request's user, which
+// limit the private repositories it sees, or nil when authn is disabled and
+// every repository is open.
+func principals(authn *auth.Service, r *http.Request) []string {
+	if !authn.IsAuthEnabled() {
+		return nil
+	}
+	user := auth.GetUserFromContext(r)
+	if user == nil {
+		return []string{}
+	}
+	p := []string{user.Login}
+	for _, t := range user.Teams {
+		p = append(p, teamPrincipal(t))
+	}
+	return p
+}
+
+// allowedRepositories returns the repositories the request is limited to,
+// by its API key or by the user's GitHub access, or nil when it is not
+// limited.
+func allowedRepositories(r *http.Request) []string {
+	if user := auth.GetUserFromContext(r); user != nil {
+		return user.Repositories
+	}
+	return nil
+}
+
+// repositoryAllowed reports whether the request is not limited to other
+// repositories than repository.
+func repositoryAllowed(r *http.Request, repository string) bool {
+	repos := allowedRepositories(r)
+	return repos == nil || slices.Contains(repos, repository)
+}
+
+// configGrantor is the GrantedBy of the grants made by configuration, which
+// are replaced at each start.
+const configGrantor = "config"
+
+// teamPrincipal is the grant principal of a GitHub team ("org/team-slug").
+func teamPrincipal(team string) string {
+	return "team:" + strings.ToLower(team)
+}
+
+// isTeamPrincipal reports whether a grant or admin principal is a GitHub
+// team.
+func isTeamPrincipal(principal string) bool {
+	return strings.HasPrefix(principal, "team:")
+}
+
+// teamGrants returns read grants for the repositories configured for each
+// GitHub team.
+func teamGrants(teams map[string]string, at time.Time) []models.RepositoryGrant {
+	grants := []models.RepositoryGrant{}
+	for team, repos := range teams {
+		for _, repo := range strings.Fields(repos) {
+			grants = append(grants, models.RepositoryGrant{Repository: repo, Principal: teamPrincipal(team), Role: models.RoleRead, GrantedBy: configGrantor, GrantedAt: at})
+		}
+	}
+	return grants
+}
+
+// repositoryRole returns the role of the request's user on a repository:
+// admin on an open repository, else the best role granted to one of its
+// principals, or "" when it has none and may not even read it. A request
+// limited to other repositories has no role on it.
+func repositoryRole(ctx context.Context, authn *auth.Service, st store.Backend, r *http.Request, repository string) (string, error) {
+	if !repositoryAllowed(r, repository) {
+		return "", nil
+	}
+	p := principals(authn, r)
+	if p == nil {
+		return models.RoleAdmin, nil
+	}
+	grants, err := st.RepositoryGrants(ctx, repository)
+	if err != nil || len(grants) == 0 {
+		return models.RoleAdmin, err
+	}
+	role := ""
+	for _, g := range grants {
+		if slices.Contains(p, g.Principal) && role != models.RoleAdmin {
+			role = g.Role
+		}
+	}
+	return role, nil
+}
+
+// repositoryAccess wraps a handler of a /repositories/{repo} route so that
+// it only runs when the user has role on the repository. A private
+// repository the user cannot read is not found, as if it did not exist.
+func repositoryAccess(authn *auth.Service, st store.Backend, role string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got, err := repositoryRole(r.Context(), authn, st, r, r.PathValue("repo"))
+		switch {
+		case err != nil:
+			http.Error(w, err.Error(), 500)
+		case got == "":
+			http.Error(w, "Repository not found", http.StatusNotFound)
+		case role == models.RoleAdmin && got != models.RoleAdmin:
+			http.Error(w, "This requires the admin role on the repository", http.StatusForbidden)
+		default:
+			h(w, r)
+		}
+	}
+}
+
+// canReadChunk reports whether the request's user may read the repository
+// of c.
+func canReadChunk(ctx context.Context, authn *auth.Service, st store.Backend, r *http.Request, c models.Chunk) (bool, error) {
+	role, err := repositoryRole(ctx, authn, st, r, c.Repository)
+	return role != "", err
+}
+
+// visibleRepositories drops the private repositories the request's user
+// cannot read, and those the request is not limited to, from repos.
+func visibleRepositories(ctx context.Context, authn *auth.Service, st store.Backend, r *http.Request, repos []string) ([]string, error) {
+	if allowedRepositories(r) != nil {
+		allowed := make([]string, 0, len(repos))
+		for _, repo := range repos {
+			if repositoryAllowed(r, repo) {
+				allowed = append(allowed, repo)
+			}
+		}
+		repos = allowed
+	}
+	p := principals(authn, r)
+	if p == nil {
+		return repos, nil
+	}
+	hidden, err := st.HiddenRepositories(ctx, p)
+	if err != nil || len(hidden) == 0 {
+		return repos, err
+	}
+	out := make([]string, 0, len(repos))
+	for _, repo := range repos {
+		if !slices.Contains(hidden, repo) {
+			out = append(out, repo)
+		}
+	}
+	return out, nil
+}
+
+// newID returns a random identifier for a chat session or saved search.
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// maxSavedSearchName caps the length of a saved search's name.
+const maxSavedSearchName = 200
+
+// APIKeyRequest is the body of POST /admin/api-keys. Scopes default to
+// search only; a key without Repositories sees every repository, and one
+// without ExpiresAt never expires.
+type APIKeyRequest struct {
+	Name         string     `json:"name"`                   // what the key is for, e.g. "CI"
+	Scopes       []string   `json:"scopes"`                 // search, index and/or admin
+	Repositories []string   `json:"repositories,omitempty"` // e.g. ["org/api", "org/docs"]
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+}
+
+// GrantRequest is the body of PUT /repositories/{repo}/grants/{principal}.
+type GrantRequest struct {
+	Role string `json:"role"` // read or admin
+}
+
+// checkGrants returns an error when grants, the grants a private repository
+// would be left with, have no admin to manage them.
+func checkGrants(grants []models.RepositoryGrant) error {
+	if len(grants) == 0 {
+		return nil
+	}
+	for _, g := range grants {
+		if g.Role == models.RoleAdmin {
+			return nil
+		}
+	}
+	return errors.New("a private repository needs at least one admin")
+}
+
+// NewAPIKey is the response of POST /admin/api-keys. Key is the secret to
+// send in the X-API-Key header; it cannot be retrieved again.
+type NewAPIKey struct {
+	APIKey models.APIKey `json:"api_key"`
+	Key    string        `json:"key"`
+}
+
+// EnqueueJobRequest is the body of POST /admin/jobs. Params holds the kind's
+// parameters; index jobs take an indexer.JobRequest, the others none.
+type EnqueueJobRequest struct {
+	Kind   string          `json:"kind"` // index, resummarize, reembed or vacuum
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// maxAPIKeyName caps the length of an API key's name.
+const maxAPIKeyName = 100
+
+// apiKeyUser resolves an API key hash to the user its requests act as, named
+// after the key so that they are not mistaken for its creator.
+func apiKeyUser(st store.Backend) auth.APIKeyLookup {
+	return func(ctx context.Context, hash string) (*auth.GithubUser, []string, error) {
+		k, ok, err := st.LookupAPIKey(ctx, hash)
+		if err != nil || !ok {
+			return nil, nil, err
+		}
+		return &auth.GithubUser{Login: "api-key/" + k.ID, Name: k.Name, Repositories: k.Repositories}, k.Scopes, nil
+	}
+}
+
+// githubRepositories limits signed-in users to the indexed repositories they
+// can see on GitHub. Users who did not sign in with GitHub have no GitHub
+// login to check, so they only see public repositories and those hosted
+// elsewhere. Repositories whose check fails are hidden until it succeeds.
+// Each user's list is cached for the access TTL.
+func githubRepositories(st store.Backend, access *source.GitHubAccess) auth.RepositoryFilter {
+	return func(ctx context.Context, user *auth.GithubUser) ([]string, error) {
+		login := ""
+		if user.Provider == auth.ProviderGithub {
+			login = user.Login
+		}
+		visible, err := access.Repositories(ctx, login, st.GetRepositories)
+		if visible == nil && err != nil { // listing the repositories failed
+			return nil, err
+		}
+		if err != nil {
+			log.Printf("failed to check GitHub access of %s: %v", user.Login, err)
+		}
+		return visible, nil
+	}
+}
+
+// checkSavedSearch validates the user-supplied fields of a saved search.
+func checkSavedSearch(ss models.SavedSearch) error {
+	switch {
+	case strings.TrimSpace(ss.Name) == "":
+		return errors.New("name is required")
+	case len(ss.Name) > maxSavedSearchName:
+		return fmt.Errorf("name must be at most %d bytes", maxSavedSearchName)
+	case strings.TrimSpace(ss.Query) == "":
+		return errors.New("query is required")
+	case ss.K < 0:
+		return errors.New("k must not be negative")
+	}
+	if _, err := regexp.Compile(ss.Filters.PathRegex); err != nil {
+		return fmt.Errorf("invalid path_regex: %w", err)
+	}
+	return nil
+}
+
+// Bounds on the suggestions /suggest returns of each kind.
+const (
+	defaultSuggestLimit = 5
+	maxSuggestLimit     = 20
+)
+
+// BatchSearchRequest is the body of POST /search/batch.
+type BatchSearchRequest struct {
+	Queries []string             `json:"queries"`
+	K       int                  `json:"k,omitempty"` // results per query, default 5
+	Filters models.SearchFilters `json:"filters"`
+	Content bool                 `json:"content,omitempty"` // include chunk content
+}
+
+// BatchResult holds the results of one query of a /search/batch request.
+type BatchResult struct {
+	Query   string                `json:"query"`
+	Results []models.SearchResult `json:"results"`
+}
+
+// Bounds on /search/batch requests.
+const (
+	maxBatchQueries = 10
+	maxBatchK       = 50
+)
+
+// maxExpand caps the neighbouring chunks returned on each side of a hit.
+const maxExpand = 5
+
+// contentFetches caps the files fillContent fetches at once.
+const contentFetches = 8
+
+// fillContent fetches the content of chunks indexed without it. f is nil
+// when content is stored in the database.
+func fillContent(ctx context.Context, f source.Fetcher, chunks ...*models.Chunk) {
+	if f == nil {
+		return
+	}
+	files := source.NewCache(f)
+	var g errgroup.Group
+	g.SetLimit(contentFetches)
+	for _, c := range chunks {
+		if c.Content != "" {
+			continue
+		}
+		g.Go(func() error {
+			content, err := files.Content(ctx, *c)
+			if err != nil {
+				log.Printf("failed to fetch content of %s: %v", c.Path, err)
+				return nil
+			}
+			c.Content = content
+			return nil
+		})
+	}
+	_ = g.Wait()
+}
+
+// fillSnippets computes the snippets of results for query q once their
+// content has been fetched by fillContent; the store leaves them empty when
+// it does not hold the content. f is nil when content is stored.
+func fillSnippets(f source.Fetcher, q string, results []models.SearchResult) {
+	if f == nil {
+		return
+	}
+	q, _ = search.ParseQuery(q, store.QueryOpts{})
+	for i := range results {
+		if results[i].Snippet == "" {
+			results[i].Snippet, results[i].Highlights = store.Snippet(results[i].Chunk.Content, q)
+		}
+	}
+}
+
+// defaultAnalyticsWindow is how far back /analytics/queries looks by default.
+const defaultAnalyticsWindow = 7 * 24 * time.Hour
+
+// Bounds on the number of records returned by /admin/audit.
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// auditTime parses a time bound of /admin/audit: empty, an RFC 3339 time,
+// or a positive duration before now.
+func auditTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil && d > 0 {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, errors.New("must be an RFC 3339 time or a positive duration, e.g. 24h")
+	}
+	return t, nil
+}
+
+// logQuery records a served search in the background so that a slow or
+// failing write never delays or fails the search itself.
+func logQuery(st store.Backend, r *http.Request, keepText bool, q string, k, results int, start time.Time) {
+	params := r.URL.Query()
+	params.Del("q")
+	params.Del("k")
+	writeQueryLog(st, r, store.QueryLog{Query: q, KeepText: keepText, Filters: params.Encode(), K: k, Latency: time.Since(start), Results: results, At: start})
+}
+
+// writeQueryLog records l, made by the user of r, in the background.
+func writeQueryLog(st store.Backend, r *http.Request, l store.QueryLog) {
+	if u := auth.GetUserFromContext(r); u != nil {
+		l.User = u.Login
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := st.LogQuery(ctx, l); err != nil {
+			log.Printf("failed to log query: %v", err)
+		}
+	}()
+}
+
+// auditSearch appends a served search to the audit trail, in the
+// background like logQuery. repos are the repositories the results came
+// from.
+func auditSearch(st store.Backend, r *http.Request, q string, opt store.QueryOpts, results int, repos []string) {
+	a := models.SearchAudit{Path: r.URL.Path, Query: q, Filters: filterValues(opt).Encode(), Repositories: repos, Results: results, At: time.Now()}
+	if u := auth.GetUserFromContext(r); u != nil {
+		a.User = u.Login
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := st.RecordSearchAudit(ctx, a); err != nil {
+			log.Printf("failed to record search audit: %v", err)
+		}
+	}()
+}
+
+// resultRepositories returns the distinct repositories of res, sorted.
+func resultRepositories(res []models.SearchResult) []string {
+	var repos []string
+	for _, r := range res {
+		repos = append(repos, r.Chunk.Repository)
+	}
+	slices.Sort(repos)
+	return slices.Compact(repos)
+}
+
+// citedRepositories returns the distinct repositories of sources, sorted.
+func citedRepositories(sources []models.Citation) []string {
+	var repos []string
+	for _, c := range sources {
+		repos = append(repos, c.Repository)
+	}
+	slices.Sort(repos)
+	return slices.Compact(repos)
+}
+
+// authStatus is the body of /auth/status.
+type authStatus struct {
+	Enabled   bool     `json:"enabled"`
+	Providers []string `json:"providers"`
+}
+
+// oauthStart begins an OAuth login: it stores a state in a cookie and
+// redirects to the provider's login URL for it.
+func oauthStart(authn *auth.Service, loginURL func(state string) string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state := auth.GenerateState()
+
+		// Store state in cookie for validation
+		http.SetCookie(w, authn.Cookie(r, "oauth_state", state, "", 600)) // 10 minutes
+
+		http.Redirect(w, r, loginURL(state), http.StatusTemporaryRedirect)
+	}
+}
+
+// oauthCallback completes an OAuth login begun by oauthStart, signing the
+// provider's user in with a new session.
+func oauthCallback(authn *auth.Service, st store.Backend, exchange func(code string) (string, error), getUser func(accessToken string) (*auth.GithubUser, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code := r.URL.Query().Get("code")
+		state := r.URL.Query().Get("state")
+
+		// Validate state
+		stateCookie, err := r.Cookie("oauth_state")
+		if err != nil || stateCookie.Value != state {
+			authn.RecordEvent(r, auth.EventLogin, "", errors.New("invalid state parameter"))
+			http.Error(w, "Invalid state parameter", http.StatusBadRequest)
+			return
+		}
+
+		// Clear state cookie
+		http.SetCookie(w, authn.Cookie(r, "oauth_state", "", "", -1))
+
+		if code == "" {
+			http.Error(w, "Missing code parameter", http.StatusBadRequest)
+			return
+		}
+
+		// Exchange code for token
+		accessToken, err := exchange(code)
+		if err != nil {
+			authn.RecordEvent(r, auth.EventLogin, "", fmt.Errorf("exchange code: %w", err))
+			http.Error(w, "Failed to exchange code for token", http.StatusInternalServerError)
+			return
+		}
+
+		// Get user info
+		user, err := getUser(accessToken)
+		if err != nil {
+			authn.RecordEvent(r, auth.EventLogin, "", err)
+			http.Error(w, "Failed to get user info: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Sign the user in with a new session
+		token, err := issueSession(r.Context(), w, r, authn, st, user, newID(), time.Time{})
+		authn.RecordEvent(r, auth.EventLogin, user.Login, err)
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+
+		// Return user info and token
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(auth.AuthResponse{
+			User:  *user,
+			Token: token,
+		})
+		if err != nil {
+			http.Error(w, "Failed to encode response", 500)
+		}
+	}
+}
+
 func main() {
 	// Create flagset for configuration
 	fs := pflag.NewFlagSet("reposearch-api", pflag.ExitOnError)
 
 	// Load configuration
-	cfg, err := config.Load("", fs)
+	cfg, err := config.LoadAPI("", fs)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 	fs.Usage = cfg.Usage
+	cfg.RenewVaultLeases(context.Background(), log.Printf)
 
 	// Set up logging
 	level, err := zerolog.ParseLevel(cfg.LogLevel)
 	if err != nil {
 		log.Fatalf("Invalid log level '%s': %v", cfg.LogLevel, err)
 	}
-	logger := zerolog.New(os.Stdout).Level(level).With().Timestamp().Logger()
+	// The level is global so that reloading the configuration can change it.
+	zerolog.SetGlobalLevel(level)
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
 	logger.Info().Str("provider", cfg.Provider).Str("log_level", cfg.LogLevel).Bool("auth_enabled", cfg.Auth.Enabled).Msg("starting reposearch api")
 
 	// Create AI client configuration
-	var clientConfig *ai.ClientConfig
-	switch strings.ToLower(cfg.Provider) {
-	case "openai":
-		clientConfig = &ai.ClientConfig{
-			APIKey:       cfg.APIKey,
-			EmbedModel:   cfg.EmbedModel,
-			SummaryModel: cfg.SummaryModel,
-			Dim:          cfg.Dim,
-			ProjectID:    cfg.ProjectID,
-			Provider:     ai.ProviderOpenAI,
-		}
-	case "vertexai", "google":
-		clientConfig = &ai.ClientConfig{
-			APIKey:       cfg.APIKey,
-			EmbedModel:   cfg.EmbedModel,
-			SummaryModel: cfg.SummaryModel,
-			Dim:          cfg.Dim,
-			ProjectID:    cfg.ProjectID,
-			Location:     cfg.Location,
-			Provider:     ai.ProviderVertexAI,
-		}
-	case "stub":
-		clientConfig = &ai.ClientConfig{
-			Dim:      cfg.Dim,
-			Provider: ai.ProviderStub,
-		}
-	default:
-		log.Fatalf("unsupported provider: %s", cfg.Provider)
-	}
-
-	// Initialize auth with configuration
-	auth.InitializeAuth(
-		cfg.Auth.JwtSecret,
-		cfg.Auth.GithubClientID,
-		cfg.Auth.GithubClientSecret,
-		cfg.Auth.GithubRedirectURL,
-		cfg.Auth.GithubAllowedOrg,
-		cfg.Auth.Enabled,
-	)
+	clientConfig, err := ai.ClientConfigFor(cfg)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// Set up auth with configuration
+	authn := auth.NewService(auth.AuthConfig{
+		JwtSecret:     []byte(cfg.Auth.JwtSecret),
+		ClientID:      cfg.Auth.GithubClientID,
+		ClientSecret:  cfg.Auth.GithubClientSecret,
+		RedirectURL:   cfg.Auth.GithubRedirectURL,
+		AllowedOrg:    cfg.Auth.GithubAllowedOrg,
+		AllowedOrgs:   cfg.Auth.GithubAllowedOrgs,
+		RequiredTeams: cfg.Auth.GithubRequiredTeams,
+		GithubTeams:   cfg.Auth.GithubTeams || len(cfg.Auth.GithubTeamRepositories) > 0 || slices.ContainsFunc(cfg.Auth.Admins, isTeamPrincipal),
+		Enabled:       cfg.Auth.Enabled,
+		Gitlab: auth.GitlabConfig{
+			URL:          cfg.Auth.GitlabURL,
+			ClientID:     cfg.Auth.GitlabClientID,
+			ClientSecret: cfg.Auth.GitlabClientSecret,
+			RedirectURL:  cfg.Auth.GitlabRedirectURL,
+			AllowedGroup: cfg.Auth.GitlabAllowedGroup,
+		},
+		Google: auth.GoogleConfig{
+			ClientID:      cfg.Auth.GoogleClientID,
+			ClientSecret:  cfg.Auth.GoogleClientSecret,
+			RedirectURL:   cfg.Auth.GoogleRedirectURL,
+			AllowedDomain: cfg.Auth.GoogleAllowedDomain,
+		},
+		SAML: auth.SAMLConfig{
+			IDPMetadataURL:  cfg.Auth.SamlIDPMetadataURL,
+			RootURL:         cfg.Auth.SamlRootURL,
+			EntityID:        cfg.Auth.SamlEntityID,
+			CertFile:        cfg.Auth.SamlCertFile,
+			KeyFile:         cfg.Auth.SamlKeyFile,
+			LoginAttribute:  cfg.Auth.SamlLoginAttribute,
+			NameAttribute:   cfg.Auth.SamlNameAttribute,
+			EmailAttribute:  cfg.Auth.SamlEmailAttribute,
+			GroupsAttribute: cfg.Auth.SamlGroupsAttribute,
+		},
+		Lifetimes: auth.Lifetimes{
+			AccessToken:  cfg.Auth.AccessTokenTTL,
+			RefreshToken: cfg.Auth.RefreshTokenTTL,
+			Session:      cfg.Auth.SessionMaxAge,
+			ClockSkew:    cfg.Auth.ClockSkew,
+		},
+		Admins:         cfg.Auth.Admins,
+		AllowAllAdmins: cfg.Auth.AllowAllAdmins,
+		Cookie:         cookieConfig(cfg.Cookie),
+	})
+	if cfg.Auth.Enabled {
+		switch {
+		case len(cfg.Auth.Admins) > 0:
+		case cfg.Auth.AllowAllAdmins:
+			log.Println("WARNING: auth.allowAllAdmins is set and no auth.admins are configured - every signed-in user is an admin")
+		default:
+			log.Println("WARNING: no auth.admins are configured - admin endpoints only accept API keys with the admin scope")
+		}
+		if err := authn.EnableSAML(context.Background()); err != nil {
+			log.Fatalf("Failed to set up SAML login: %v", err)
+		}
+	}
 
 	ctx := context.Background()
-	st, err := store.New(ctx, cfg.Database)
+	st, err := storeconfig.Open(ctx, cfg)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
@@ -142,130 +786,83 @@ func main() {
 	if err := st.Migrate(ctx, dim); err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
+	if cfg.Auth.Enabled {
+		grants := teamGrants(cfg.Auth.GithubTeamRepositories, time.Now().UTC())
+		if err := st.ReplaceRepositoryGrants(ctx, configGrantor, grants); err != nil {
+			log.Fatalf("Failed to apply GitHub team repositories: %v", err)
+		}
+		logger.Info().Int("grants", len(grants)).Msg("GitHub team repositories applied")
+	}
 
 	svc := search.NewService(c, st)
+	authn.SetAPIKeyLookup(apiKeyUser(st))
+	authn.SetRevocationCheck(st.TokenRevoked)
+	authn.SetEventRecorder(authEventRecorder(st))
+	if cfg.Auth.GithubRepositoryAccess {
+		authn.SetRepositoryFilter(githubRepositories(st, source.NewGitHubAccess(cfg.GithubToken, cfg.Auth.GithubRepositoryAccessTTL)))
+	}
+
+	// In summary-only mode chunk content is fetched from GitHub on demand.
+	var sources source.Fetcher
+	if !cfg.StoreContent {
+		gh := source.NewGitHub(cfg.GithubToken)
+		gh.MaxSize = int64(cfg.MaxFileSize)
+		sources = gh
+	}
+	svc.Content = func(ctx context.Context, chunks []*models.Chunk) { fillContent(ctx, sources, chunks...) }
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(200) })
+	mux.HandleFunc("GET /healthz", livez) // kept for existing probes
+	mux.HandleFunc("GET /livez", livez)
+	var provider *providerCheck
+	if cfg.ReadyzProvider {
+		provider = &providerCheck{client: c}
+	}
+	mux.HandleFunc("GET /readyz", readyz(st, provider))
+	registerDocs(mux, authn)
 
 	// Auth status endpoint (always available)
-	mux.HandleFunc("/auth/status", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("GET /auth/status", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		err := json.NewEncoder(w).Encode(map[string]bool{"enabled": auth.IsAuthEnabled()})
+		err := json.NewEncoder(w).Encode(authStatus{Enabled: authn.IsAuthEnabled(), Providers: authn.Providers()})
 		if err != nil {
 			http.Error(w, "Failed to encode response", 500)
 		}
 	})
 
 	// Authentication endpoints (only if auth is enabled)
-	if auth.IsAuthEnabled() {
+	if authn.IsAuthEnabled() {
 		log.Println("Authentication is ENABLED")
 
-		mux.HandleFunc("/auth/github", func(w http.ResponseWriter, r *http.Request) {
-			state := auth.GenerateState()
-
-			// Store state in cookie for validation
-			http.SetCookie(w, &http.Cookie{
-				Name:     "oauth_state",
-				Value:    state,
-				Path:     "/",
-				MaxAge:   600, // 10 minutes
-				HttpOnly: true,
-				Secure:   strings.HasPrefix(r.Header.Get("X-Forwarded-Proto"), "https"),
-				SameSite: http.SameSiteLaxMode,
-			})
-
-			loginURL := auth.GetGithubLoginURL(state)
-			http.Redirect(w, r, loginURL, http.StatusTemporaryRedirect)
-		})
-
-		mux.HandleFunc("/auth/callback", func(w http.ResponseWriter, r *http.Request) {
-			code := r.URL.Query().Get("code")
-			state := r.URL.Query().Get("state")
-
-			// Validate state
-			stateCookie, err := r.Cookie("oauth_state")
-			if err != nil || stateCookie.Value != state {
-				http.Error(w, "Invalid state parameter", http.StatusBadRequest)
-				return
-			}
-
-			// Clear state cookie
-			http.SetCookie(w, &http.Cookie{
-				Name:   "oauth_state",
-				Value:  "",
-				Path:   "/",
-				MaxAge: -1,
-			})
-
-			if code == "" {
-				http.Error(w, "Missing code parameter", http.StatusBadRequest)
-				return
-			}
-
-			// Exchange code for token
-			accessToken, err := auth.ExchangeCodeForToken(code)
-			if err != nil {
-				http.Error(w, "Failed to exchange code for token", http.StatusInternalServerError)
-				return
-			}
-
-			// Get user info
-			user, err := auth.GetGithubUser(accessToken)
-			if err != nil {
-				http.Error(w, "Failed to get user info: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-
-			// Generate JWT
-			token, err := auth.GenerateJWT(user)
-			if err != nil {
-				http.Error(w, "Failed to generate token", http.StatusInternalServerError)
-				return
-			}
-
-			// Set cookie
-			http.SetCookie(w, &http.Cookie{
-				Name:     "auth_token",
-				Value:    token,
-				Path:     "/",
-				MaxAge:   86400, // 24 hours
-				HttpOnly: true,
-				Secure:   strings.HasPrefix(r.Header.Get("X-Forwarded-Proto"), "https"),
-				SameSite: http.SameSiteLaxMode,
-			})
-
-			// Return user info and token
-			w.Header().Set("Content-Type", "application/json")
-			err = json.NewEncoder(w).Encode(auth.AuthResponse{
-				User:  *user,
-				Token: token,
-			})
-			if err != nil {
-				http.Error(w, "Failed to encode response", 500)
-			}
-		})
+		if authn.GithubEnabled() {
+			mux.HandleFunc("GET /auth/github", oauthStart(authn, authn.GetGithubLoginURL))
+			mux.HandleFunc("GET /auth/callback", oauthCallback(authn, st, authn.ExchangeCodeForToken, authn.GetGithubUser))
+		}
+		if authn.GitlabEnabled() {
+			mux.HandleFunc("GET /auth/gitlab", oauthStart(authn, authn.GetGitlabLoginURL))
+			mux.HandleFunc("GET /auth/gitlab/callback", oauthCallback(authn, st, authn.ExchangeGitlabCode, authn.GetGitlabUser))
+		}
+		if authn.GoogleEnabled() {
+			mux.HandleFunc("GET /auth/google", oauthStart(authn, authn.GetGoogleLoginURL))
+			mux.HandleFunc("GET /auth/google/callback", oauthCallback(authn, st, authn.ExchangeGoogleCode, authn.GetGoogleUser))
+		}
+		if authn.SAMLEnabled() {
+			mux.HandleFunc("GET /auth/saml", samlStart(authn))
+			mux.HandleFunc("GET "+auth.SAMLMetadataPath, samlMetadata(authn))
+			mux.HandleFunc("POST "+auth.SAMLACSPath, samlACS(authn, st, cfg.Auth.SamlReturnURL))
+		}
 
-		mux.HandleFunc("/auth/me", func(w http.ResponseWriter, r *http.Request) {
+		mux.HandleFunc("GET /auth/me", func(w http.ResponseWriter, r *http.Request) {
 			// Extract token from Authorization header or cookie
-			var tokenString string
-
-			authHeader := r.Header.Get("Authorization")
-			if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
-				tokenString = strings.TrimPrefix(authHeader, "Bearer ")
-			} else {
-				if cookie, err := r.Cookie("auth_token"); err == nil {
-					tokenString = cookie.Value
-				}
-			}
-
+			tokenString := authn.TokenFromRequest(r)
 			if tokenString == "" {
 				http.Error(w, "No authentication token", http.StatusUnauthorized)
 				return
 			}
 
-			user, err := auth.ValidateJWT(tokenString)
+			user, err := authn.ValidateJWT(r.Context(), tokenString)
 			if err != nil {
+				authn.RecordEvent(r, auth.EventValidation, "", err)
 				http.Error(w, "Invalid token", http.StatusUnauthorized)
 				return
 			}
@@ -280,100 +877,1657 @@ func main() {
 			}
 		})
 
-		mux.HandleFunc("/auth/logout", func(w http.ResponseWriter, r *http.Request) {
-			if r.Method != "POST" {
-				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-				return
-			}
-
-			// Clear cookie
-			http.SetCookie(w, &http.Cookie{
-				Name:   "auth_token",
-				Value:  "",
-				Path:   "/",
-				MaxAge: -1,
-			})
-
-			w.WriteHeader(http.StatusOK)
-		})
+		mux.HandleFunc("POST /auth/refresh", refreshSession(authn, st))
+		mux.HandleFunc("POST /auth/logout", logout(authn, st))
 	} else {
 		log.Println("Authentication is DISABLED - running in open mode")
 	}
 
-	mux.HandleFunc("/repositories", auth.OptionalAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	mux.HandleFunc("GET /repositories", authn.OptionalAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.LookupTimeout)
 		defer cancel()
+		if notModified(ctx, w, r, st, visibilityVariant(authn, r)) {
+			return
+		}
 
 		repos, err := st.GetRepositories(ctx)
+		if err == nil {
+			repos, err = visibleRepositories(ctx, authn, st, r, repos)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(repos); err != nil {
+			http.Error(w, "Failed to encode repositories", 500)
+		}
+	}))
+	mux.HandleFunc("GET /stats", authn.OptionalAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.BulkTimeout)
+		defer cancel()
+
+		stats, err := st.Stats(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		var hidden []string
+		if p := principals(authn, r); p != nil {
+			hidden, err = st.HiddenRepositories(ctx, p)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+		}
+		if len(hidden) > 0 || allowedRepositories(r) != nil {
+			visible := stats.Repositories[:0]
+			stats.Chunks, stats.Files = 0, 0
+			for _, rs := range stats.Repositories {
+				if !slices.Contains(hidden, rs.Repository) && repositoryAllowed(r, rs.Repository) {
+					visible = append(visible, rs)
+					stats.Chunks += rs.Chunks
+					stats.Files += rs.Files
+				}
+			}
+			stats.Repositories = visible
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(stats); err != nil {
+			http.Error(w, "Failed to encode stats", 500)
+		}
+	}))
+	// POST /admin/reload reloads the configuration, like SIGHUP, and applies
+	// the settings that can change without a restart.
+	limits := newQuotas(cfg.Quota)
+	reloadOnSIGHUP(context.Background(), logger, limits)
+	mux.HandleFunc("POST /admin/reload", authn.AdminAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		res, err := reloadConfig(limits)
+		if err != nil {
+			hlog.FromRequest(r).Error().Err(err).Msg("config reload failed; keeping the current settings")
+			http.Error(w, "Failed to reload configuration: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		hlog.FromRequest(r).Info().Str("log_level", res.LogLevel).Msg("config reloaded")
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			log.Printf("failed to encode reload result: %v", err)
+		}
+	}))
+	// POST /admin/optimize refreshes table statistics in the background, and
+	// rebuilds the vector indexes with reindex=true, e.g. after a large
+	// indexing run. Only one optimization runs at a time.
+	var optimizing atomic.Bool
+	mux.HandleFunc("POST /admin/optimize", authn.AdminAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		reindex := r.URL.Query().Get("reindex") == "true"
+		if !optimizing.CompareAndSwap(false, true) {
+			http.Error(w, "An optimization is already running", http.StatusConflict)
+			return
+		}
+		var by string
+		if u := auth.GetUserFromContext(r); u != nil {
+			by = u.Login
+		}
+		l := hlog.FromRequest(r).With().Bool("reindex", reindex).Str("user", by).Logger()
+		go func() {
+			defer optimizing.Store(false)
+			start := time.Now()
+			if err := st.Optimize(context.Background(), reindex); err != nil {
+				l.Error().Err(err).Msg("optimize failed")
+				return
+			}
+			l.Info().Dur("dur", time.Since(start)).Msg("optimized")
+		}()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	// The job queue runs indexing and maintenance jobs in the background of
+	// the API process. POST /admin/jobs enqueues a job, GET /admin/jobs lists
+	// recent ones, GET /admin/jobs/{id} and /admin/jobs/{id}/logs show one and
+	// POST /admin/jobs/{id}/cancel stops it. /admin/index is kept for index
+	// jobs alone.
+	lfsMode, err := indexer.ParseLFSMode(cfg.LFSMode)
+	if err != nil {
+		log.Fatalf("Invalid LFS mode: %v", err)
+	}
+	if err := indexer.CheckRepoConfigFields(cfg.RepoConfigFields); err != nil {
+		log.Fatalf("Invalid repository config fields: %v", err)
+	}
+	jobs := &indexer.Jobs{
+		Store:        st,
+		Queue:        st,
+		Client:       clientConfig,
+		Token:        cfg.GithubToken,
+		DefaultRef:   cfg.GitRef,
+		BatchSize:    cfg.BatchSize,
+		CloneTimeout: cfg.CloneTimeout,
+		Configure: func(ix *indexer.Indexer) {
+			ix.LFSMode = lfsMode
+			ix.MaxLFSObjectSize = int64(cfg.MaxLFSObjectSize)
+			ix.RepoConfigFields = cfg.RepoConfigFields
+			ix.Dedup = cfg.Dedup
+			ix.DirSummaries = cfg.DirSummaries
+			ix.SummaryOnly = !cfg.StoreContent
+			ix.WriteBatchSize = cfg.BatchSize
+			if lfsMode == indexer.LFSModeFetch {
+				ix.LFSFetcher = indexer.NewHTTPLFSFetcher(ix.Repository, cfg.GithubToken)
+			}
+		},
+	}
+	go jobs.Run(context.Background())
+	enqueue := func(w http.ResponseWriter, r *http.Request, kind indexer.Mode, params json.RawMessage, location string) {
+		var by string
+		if u := auth.GetUserFromContext(r); u != nil {
+			by = u.Login
+		}
+		job, err := jobs.Enqueue(r.Context(), kind, params, by)
+		switch {
+		case errors.Is(err, indexer.ErrJobRunning):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, indexer.ErrInvalidJob):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		hlog.FromRequest(r).Info().Str("job", job.ID).Str("kind", job.Kind).Str("user", by).Msg("job enqueued")
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", location+job.ID)
+		w.WriteHeader(http.StatusAccepted)
+		if err := json.NewEncoder(w).Encode(job); err != nil {
+			log.Printf("failed to encode job: %v", err)
+		}
+	}
+	listJobs := func(w http.ResponseWriter, r *http.Request, kind indexer.Mode) {
+		list, err := jobs.List(r.Context(), kind)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(list); err != nil {
+			http.Error(w, "Failed to encode jobs", 500)
+		}
+	}
+	getJob := func(w http.ResponseWriter, r *http.Request, id string) {
+		job, ok, err := jobs.Get(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if !ok {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(job); err != nil {
+			http.Error(w, "Failed to encode job", 500)
+		}
+	}
+	mux.HandleFunc("GET /admin/jobs", authn.AdminAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		listJobs(w, r, indexer.Mode(r.URL.Query().Get("kind")))
+	}))
+	mux.HandleFunc("POST /admin/jobs", authn.AdminAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		var req EnqueueJobRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		enqueue(w, r, indexer.Mode(req.Kind), req.Params, "/admin/jobs/")
+	}))
+	mux.HandleFunc("GET /admin/jobs/{id}", authn.AdminAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		getJob(w, r, r.PathValue("id"))
+	}))
+	mux.HandleFunc("GET /admin/jobs/{id}/logs", authn.AdminAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if _, ok, err := jobs.Get(r.Context(), id); err != nil || !ok {
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+			} else {
+				http.Error(w, "Job not found", http.StatusNotFound)
+			}
+			return
+		}
+		logs, err := jobs.Logs(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(logs); err != nil {
+			http.Error(w, "Failed to encode logs", 500)
+		}
+	}))
+	mux.HandleFunc("POST /admin/jobs/{id}/cancel", authn.AdminAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		job, ok, err := jobs.Cancel(r.Context(), r.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if !ok {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		if job.Status.Finished() && !job.CancelRequested {
+			http.Error(w, "Job already finished", http.StatusConflict)
+			return
+		}
+		hlog.FromRequest(r).Info().Str("job", job.ID).Str("status", string(job.Status)).Msg("job cancel requested")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		if err := json.NewEncoder(w).Encode(job); err != nil {
+			log.Printf("failed to encode job: %v", err)
+		}
+	}))
+	mux.HandleFunc("GET /admin/index", authn.AdminScopeMiddleware(auth.ScopeIndex, func(w http.ResponseWriter, r *http.Request) {
+		listJobs(w, r, indexer.ModeIndex)
+	}))
+	mux.HandleFunc("POST /admin/index", authn.AdminScopeMiddleware(auth.ScopeIndex, func(w http.ResponseWriter, r *http.Request) {
+		var req indexer.JobRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		params, _ := json.Marshal(req)
+		enqueue(w, r, indexer.ModeIndex, params, "/admin/index/")
+	}))
+	mux.HandleFunc("GET /admin/index/{id}", authn.AdminScopeMiddleware(auth.ScopeIndex, func(w http.ResponseWriter, r *http.Request) {
+		getJob(w, r, r.PathValue("id"))
+	}))
+	// /admin/api-keys lists (GET) and creates (POST) long-lived API keys for
+	// CI jobs and bots, which send them in the X-API-Key header;
+	// DELETE /admin/api-keys/{id} revokes one. Keys are only shown when they
+	// are created, and cannot be used to manage keys. A key's scopes decide
+	// what else it can call: search the endpoints open to any user, index
+	// /admin/index, and admin the other authenticated endpoints. A key may
+	// also be limited to some repositories, e.g. for a chat bot that should
+	// only search two of them.
+	manageKeys := func(h http.HandlerFunc) http.HandlerFunc {
+		return authn.AdminAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+			if auth.IsAPIKeyRequest(r) {
+				http.Error(w, "API keys cannot manage API keys", http.StatusForbidden)
+				return
+			}
+			h(w, r)
+		})
+	}
+	mux.HandleFunc("GET /admin/api-keys", manageKeys(func(w http.ResponseWriter, r *http.Request) {
+		keys, err := st.ListAPIKeys(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(keys); err != nil {
+			log.Printf("failed to encode API keys: %v", err)
+		}
+	}))
+	mux.HandleFunc("POST /admin/api-keys", manageKeys(func(w http.ResponseWriter, r *http.Request) {
+		var req APIKeyRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" || len(req.Name) > maxAPIKeyName {
+			http.Error(w, fmt.Sprintf("name is required and at most %d bytes", maxAPIKeyName), http.StatusBadRequest)
+			return
+		}
+		if len(req.Scopes) == 0 {
+			req.Scopes = []string{auth.ScopeSearch}
+		}
+		for _, scope := range req.Scopes {
+			if !slices.Contains(auth.Scopes, scope) {
+				http.Error(w, fmt.Sprintf("unknown scope %q; scopes are %s", scope, strings.Join(auth.Scopes, ", ")), http.StatusBadRequest)
+				return
+			}
+		}
+		slices.Sort(req.Scopes)
+		req.Scopes = slices.Compact(req.Scopes)
+		for i, repo := range req.Repositories {
+			req.Repositories[i] = strings.TrimSpace(repo)
+			if req.Repositories[i] == "" || strings.Contains(repo, ",") {
+				http.Error(w, fmt.Sprintf("invalid repository %q", repo), http.StatusBadRequest)
+				return
+			}
+		}
+		slices.Sort(req.Repositories)
+		req.Repositories = slices.Compact(req.Repositories)
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+			return
+		}
+		key, hash, err := auth.NewAPIKey()
+		if err != nil {
+			http.Error(w, "Failed to generate API key", 500)
+			return
+		}
+		k := models.APIKey{
+			ID:           newID(),
+			Name:         req.Name,
+			Prefix:       key[:auth.APIKeyPrefixLen],
+			Scopes:       req.Scopes,
+			Repositories: req.Repositories,
+			CreatedBy:    auth.GetUserFromContext(r).Login,
+			CreatedAt:    time.Now().UTC(),
+		}
+		if req.ExpiresAt != nil {
+			expires := req.ExpiresAt.UTC()
+			k.ExpiresAt = &expires
+		}
+		if err := st.CreateAPIKey(r.Context(), k, hash); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		hlog.FromRequest(r).Info().Str("user", k.CreatedBy).Str("id", k.ID).Str("name", k.Name).Strs("scopes", k.Scopes).Strs("repositories", k.Repositories).Msg("API key created")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(NewAPIKey{APIKey: k, Key: key}); err != nil {
+			log.Printf("failed to encode API key: %v", err)
+		}
+	}))
+	mux.HandleFunc("DELETE /admin/api-keys/{id}", manageKeys(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		ok, err := st.RevokeAPIKey(r.Context(), id, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if !ok {
+			http.Error(w, "API key not found or already revoked", http.StatusNotFound)
+			return
+		}
+		hlog.FromRequest(r).Info().Str("user", auth.GetUserFromContext(r).Login).Str("id", id).Msg("API key revoked")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	// DELETE /admin/users/{login}/sessions signs a user out everywhere: every
+	// access and refresh token issued to the user so far stops working.
+	mux.HandleFunc("DELETE /admin/users/{login}/sessions", authn.AdminAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		login := r.PathValue("login")
+		err := st.RevokeSessions(r.Context(), login, time.Now())
+		authn.RecordEvent(r, auth.EventRevocation, login, err)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		hlog.FromRequest(r).Info().Str("user", auth.GetUserFromContext(r).Login).Str("login", login).Msg("user sessions revoked")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	// GET /analytics/queries?since=24h summarizes the searches served over
+	// the given window: volume, latency, the most frequent queries and those
+	// that returned nothing.
+	mux.HandleFunc("GET /analytics/queries", authn.AdminAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		window := defaultAnalyticsWindow
+		if v := r.URL.Query().Get("since"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				http.Error(w, "since must be a positive duration, e.g. 24h", http.StatusBadRequest)
+				return
+			}
+			window = d
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.BulkTimeout)
+		defer cancel()
+
+		stats, err := st.QueryStats(ctx, time.Now().Add(-window))
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(stats); err != nil {
+			http.Error(w, "Failed to encode query stats", 500)
+		}
+	}))
+	// GET /admin/audit returns the search audit trail, newest first. It can
+	// be narrowed to a user, a repository the results came from, and a time
+	// range: since and until take a time (RFC 3339) or a duration before
+	// now, e.g. 24h. Searches are only audited when auth is enabled.
+	mux.HandleFunc("GET /admin/audit", authn.AdminAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		f := store.AuditFilter{
+			User:       r.URL.Query().Get("user"),
+			Repository: r.URL.Query().Get("repository"),
+			Limit:      defaultAuditLimit,
+		}
+		var err error
+		if f.Since, err = auditTime(r.URL.Query().Get("since")); err != nil {
+			http.Error(w, "since: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if f.Until, err = auditTime(r.URL.Query().Get("until")); err != nil {
+			http.Error(w, "until: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxAuditLimit {
+				http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxAuditLimit), http.StatusBadRequest)
+				return
+			}
+			f.Limit = n
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.BulkTimeout)
+		defer cancel()
+		records, err := st.SearchAudit(ctx, f)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(records); err != nil {
+			http.Error(w, "Failed to encode audit records", 500)
+		}
+	}))
+	// GET /admin/auth-events returns the auth event log, newest first: logins,
+	// refused tokens, refreshes, logouts and revocations, with the client's
+	// address and user agent. It takes the filters of /admin/audit, with
+	// event and outcome in place of repository.
+	mux.HandleFunc("GET /admin/auth-events", authn.AdminAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		f := store.AuthEventFilter{
+			User:    r.URL.Query().Get("user"),
+			Event:   r.URL.Query().Get("event"),
+			Outcome: r.URL.Query().Get("outcome"),
+			Limit:   defaultAuditLimit,
+		}
+		var err error
+		if f.Since, err = auditTime(r.URL.Query().Get("since")); err != nil {
+			http.Error(w, "since: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if f.Until, err = auditTime(r.URL.Query().Get("until")); err != nil {
+			http.Error(w, "until: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxAuditLimit {
+				http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxAuditLimit), http.StatusBadRequest)
+				return
+			}
+			f.Limit = n
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.BulkTimeout)
+		defer cancel()
+		events, err := st.AuthEvents(ctx, f)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(events); err != nil {
+			http.Error(w, "Failed to encode auth events", 500)
+		}
+	}))
+	// Repository routes take the repository name, and ref names, as a single
+	// path segment, URL-encoded when they contain '/', e.g. owner%2Frepo.
+	mux.HandleFunc("GET /repositories/{repo}/refs", authn.OptionalAuthMiddleware(repositoryAccess(authn, st, models.RoleRead, func(w http.ResponseWriter, r *http.Request) {
+		repoName := r.PathValue("repo")
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.LookupTimeout)
+		defer cancel()
+		if notModified(ctx, w, r, st, "") {
+			return
+		}
+		refs, err := st.GetRefs(ctx, repoName)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(refs); err != nil {
+			http.Error(w, "Failed to encode refs", 500)
+		}
+	})))
+	// GET /repositories/{repo}/status returns, for each ref, its chunk count
+	// and its last indexing runs, so clients can flag stale indexes.
+	mux.HandleFunc("GET /repositories/{repo}/status", authn.OptionalAuthMiddleware(repositoryAccess(authn, st, models.RoleRead, func(w http.ResponseWriter, r *http.Request) {
+		repoName := r.PathValue("repo")
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.LookupTimeout)
+		defer cancel()
+		status, err := st.RepositoryStatus(ctx, repoName)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if len(status.Refs) == 0 {
+			http.Error(w, "Repository not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			http.Error(w, "Failed to encode status", 500)
+		}
+	})))
+	// GET /repositories/{repo}/files/search?ref=...&path=...&q=... ranks
+	// only the chunks of one file, e.g. to find where in it something is
+	// configured. It takes the /search parameters.
+	mux.HandleFunc("GET /repositories/{repo}/files/search", authn.OptionalAuthMiddleware(repositoryAccess(authn, st, models.RoleRead, quota(st, limits, quotaSearch, func(w http.ResponseWriter, r *http.Request) {
+		repoName := r.PathValue("repo")
+		q, k, opt, expand, err := searchParams(authn, r, cfg.SearchDefaultK, cfg.SearchMaxK)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if opt.Ref == "" || opt.Path == "" {
+			http.Error(w, "ref and path are required", http.StatusBadRequest)
+			return
+		}
+		opt.Repositories = []string{repoName}
+		fields, err := parseFields(r.URL.Query().Get("fields"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.RequestTimeout)
+		defer cancel()
+		res, err := svc.Query(ctx, q, k, opt)
+		if err != nil {
+			searchError(w, err)
+			return
+		}
+		var ptrs []*models.Chunk
+		for i := range res {
+			if expand > 0 {
+				if res[i].Before, res[i].After, err = st.GetNeighbors(ctx, res[i].Chunk, expand); err != nil {
+					http.Error(w, err.Error(), 500)
+					return
+				}
+			}
+			if math.IsNaN(res[i].Score) || math.IsInf(res[i].Score, 0) {
+				res[i].Score = 0
+			}
+			res[i].Permalink = chunkPermalink(res[i].Chunk)
+			ptrs = append(ptrs, &res[i].Chunk)
+			for j := range res[i].Before {
+				ptrs = append(ptrs, &res[i].Before[j])
+			}
+			for j := range res[i].After {
+				ptrs = append(ptrs, &res[i].After[j])
+			}
+		}
+		if r.URL.Query().Get("content") == "false" || !fieldsNeedContent(fields) {
+			for _, c := range ptrs {
+				c.Content = ""
+			}
+		} else {
+			fillContent(ctx, sources, ptrs...)
+			fillSnippets(sources, q, res)
+		}
+		if res == nil {
+			res = []models.SearchResult{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(projectResults(res, fields)); err != nil {
+			log.Printf("failed to encode response: %v", err)
+		}
+		hlog.FromRequest(r).Info().Str("path", "/repositories/files/search").Str("q", q).Int("k", k).Dur("dur", time.Since(start)).Msg("served")
+		if cfg.QueryLog {
+			logQuery(st, r, cfg.QueryLogText, q, k, len(res), start)
+		}
+		if cfg.Auth.Enabled {
+			auditSearch(st, r, q, opt, len(res), resultRepositories(res))
+		}
+	}))))
+	// GET /repositories/{repo}/files?ref=...&path=... returns every chunk of
+	// a file ordered by line range.
+	mux.HandleFunc("GET /repositories/{repo}/files", authn.OptionalAuthMiddleware(repositoryAccess(authn, st, models.RoleRead, func(w http.ResponseWriter, r *http.Request) {
+		repoName := r.PathValue("repo")
+		ref, path := r.URL.Query().Get("ref"), r.URL.Query().Get("path")
+		if ref == "" || path == "" {
+			http.Error(w, "ref and path are required", http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.LookupTimeout)
+		defer cancel()
+		chunks, err := st.GetFileChunks(ctx, repoName, ref, path)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if len(chunks) == 0 {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		ptrs := make([]*models.Chunk, len(chunks))
+		for i := range chunks {
+			ptrs[i] = &chunks[i]
+		}
+		fillContent(ctx, sources, ptrs...)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(chunks); err != nil {
+			http.Error(w, "Failed to encode chunks", 500)
+		}
+	})))
+	// GET /repositories/{repo}/file?ref=...&path=... returns the whole file
+	// assembled from its chunks for a file view, or just its text with
+	// raw=true.
+	mux.HandleFunc("GET /repositories/{repo}/file", authn.OptionalAuthMiddleware(repositoryAccess(authn, st, models.RoleRead, func(w http.ResponseWriter, r *http.Request) {
+		repoName := r.PathValue("repo")
+		ref, path := r.URL.Query().Get("ref"), r.URL.Query().Get("path")
+		if ref == "" || path == "" {
+			http.Error(w, "ref and path are required", http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.RequestTimeout)
+		defer cancel()
+		chunks, err := st.GetFileChunks(ctx, repoName, ref, path)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if len(chunks) == 0 {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		ptrs := make([]*models.Chunk, len(chunks))
+		for i := range chunks {
+			ptrs[i] = &chunks[i]
+		}
+		fillContent(ctx, sources, ptrs...)
+		content := source.Assemble(chunks)
+		if r.URL.Query().Get("raw") == "true" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = w.Write([]byte(content))
+			return
+		}
+		file := models.File{
+			Repository: repoName,
+			Ref:        ref,
+			Path:       path,
+			Language:   chunks[0].Language,
+			Content:    content,
+			Lines:      strings.Count(content, "\n") + 1,
+			Chunks:     chunks,
+		}
+		for i := range file.Chunks {
+			file.Chunks[i].Content = ""
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(file); err != nil {
+			http.Error(w, "Failed to encode file", 500)
+		}
+	})))
+	// POST /repositories/{repo}/restore and /repositories/{repo}/refs/{ref}/restore
+	// undo a delete that has not been vacuumed yet.
+	restoreHandler := func(w http.ResponseWriter, r *http.Request) {
+		restore := func(ctx context.Context, repoName, refName string) (int64, error) {
+			if refName != "" {
+				return st.RestoreRef(ctx, repoName, refName)
+			}
+			return st.RestoreRepository(ctx, repoName)
+		}
+		repoName, refName := r.PathValue("repo"), r.PathValue("ref")
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.BulkTimeout)
+		defer cancel()
+		n, err := restore(ctx, repoName, refName)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if n == 0 {
+			http.Error(w, "Nothing to restore", http.StatusNotFound)
+			return
+		}
+		var by string
+		if u := auth.GetUserFromContext(r); u != nil {
+			by = u.Login
+		}
+		hlog.FromRequest(r).Info().Str("repository", repoName).Str("ref", refName).Int64("chunks", n).Str("user", by).Msg("restored")
+		w.WriteHeader(http.StatusNoContent)
+	}
+	mux.HandleFunc("POST /repositories/{repo}/restore", authn.AdminAuthMiddleware(repositoryAccess(authn, st, models.RoleAdmin, restoreHandler)))
+	mux.HandleFunc("POST /repositories/{repo}/refs/{ref}/restore", authn.AdminAuthMiddleware(repositoryAccess(authn, st, models.RoleAdmin, restoreHandler)))
+	// DELETE /repositories/{repo}/refs/{ref} removes a single ref, e.g. a
+	// deleted branch. Deletes are soft until the indexer runs in vacuum mode.
+	mux.HandleFunc("DELETE /repositories/{repo}/refs/{ref}", authn.AdminAuthMiddleware(repositoryAccess(authn, st, models.RoleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		repoName, refName := r.PathValue("repo"), r.PathValue("ref")
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.BulkTimeout)
+		defer cancel()
+		n, err := st.DeleteRef(ctx, repoName, refName)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if n == 0 {
+			http.Error(w, "Ref not found", http.StatusNotFound)
+			return
+		}
+		var by string
+		if u := auth.GetUserFromContext(r); u != nil {
+			by = u.Login
+		}
+		hlog.FromRequest(r).Info().Str("repository", repoName).Str("ref", refName).Int64("chunks", n).Str("user", by).Msg("ref deleted")
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	// DELETE /repositories/{repo} removes every indexed ref of the
+	// repository and returns the number of chunks deleted.
+	mux.HandleFunc("DELETE /repositories/{repo}", authn.AdminAuthMiddleware(repositoryAccess(authn, st, models.RoleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		repoName := r.PathValue("repo")
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.BulkTimeout)
+		defer cancel()
+		n, err := st.DeleteRepository(ctx, repoName)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if n == 0 {
+			http.Error(w, "Repository not found", http.StatusNotFound)
+			return
+		}
+		var by string
+		if u := auth.GetUserFromContext(r); u != nil {
+			by = u.Login
+		}
+		hlog.FromRequest(r).Info().Str("repository", repoName).Int64("chunks", n).Str("user", by).Msg("repository deleted")
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(DeleteResponse{Repository: repoName, ChunksDeleted: n}); err != nil {
+			log.Printf("failed to encode response: %v", err)
+		}
+	})))
+	if cfg.Auth.Enabled {
+		// Grants make a repository private to its grantees. Admins of a
+		// repository manage its grants; on an open repository any user may,
+		// but the first grant must make them its admin so that they keep
+		// access.
+		mux.HandleFunc("GET /repositories/{repo}/grants", authn.RequireAuthMiddleware(repositoryAccess(authn, st, models.RoleAdmin, func(w http.ResponseWriter, r *http.Request) {
+			grants, err := st.RepositoryGrants(r.Context(), r.PathValue("repo"))
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(grants); err != nil {
+				log.Printf("failed to encode grants: %v", err)
+			}
+		})))
+		mux.HandleFunc("PUT /repositories/{repo}/grants/{principal...}", authn.RequireAuthMiddleware(repositoryAccess(authn, st, models.RoleAdmin, func(w http.ResponseWriter, r *http.Request) {
+			var req GrantRequest
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if req.Role != models.RoleRead && req.Role != models.RoleAdmin {
+				http.Error(w, fmt.Sprintf("role must be %q or %q", models.RoleRead, models.RoleAdmin), http.StatusBadRequest)
+				return
+			}
+			g := models.RepositoryGrant{
+				Repository: r.PathValue("repo"),
+				Principal:  r.PathValue("principal"),
+				Role:       req.Role,
+				GrantedBy:  auth.GetUserFromContext(r).Login,
+				GrantedAt:  time.Now().UTC(),
+			}
+			if g.Principal == "" {
+				http.Error(w, "principal is required", http.StatusBadRequest)
+				return
+			}
+			grants, err := st.RepositoryGrants(r.Context(), g.Repository)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			if len(grants) == 0 && (g.Role != models.RoleAdmin || !slices.Contains(principals(authn, r), g.Principal)) {
+				http.Error(w, "The first grant on a repository must make you its admin", http.StatusBadRequest)
+				return
+			}
+			grants = slices.DeleteFunc(grants, func(e models.RepositoryGrant) bool { return e.Principal == g.Principal })
+			if err := checkGrants(append(grants, g)); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			if err := st.GrantRepository(r.Context(), g); err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			hlog.FromRequest(r).Info().Str("repository", g.Repository).Str("principal", g.Principal).Str("role", g.Role).Str("user", g.GrantedBy).Msg("repository granted")
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(g); err != nil {
+				log.Printf("failed to encode grant: %v", err)
+			}
+		})))
+		mux.HandleFunc("DELETE /repositories/{repo}/grants/{principal...}", authn.RequireAuthMiddleware(repositoryAccess(authn, st, models.RoleAdmin, func(w http.ResponseWriter, r *http.Request) {
+			repoName, principal := r.PathValue("repo"), r.PathValue("principal")
+			grants, err := st.RepositoryGrants(r.Context(), repoName)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			left := slices.DeleteFunc(slices.Clone(grants), func(e models.RepositoryGrant) bool { return e.Principal == principal })
+			if len(left) == len(grants) {
+				http.Error(w, "Grant not found", http.StatusNotFound)
+				return
+			}
+			if err := checkGrants(left); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			if _, err := st.RevokeRepositoryGrant(r.Context(), repoName, principal); err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			hlog.FromRequest(r).Info().Str("repository", repoName).Str("principal", principal).Str("user", auth.GetUserFromContext(r).Login).Msg("repository grant revoked")
+			w.WriteHeader(http.StatusNoContent)
+		})))
+	}
+	// GET /chunks/{id} returns a single chunk, e.g. to deep-link a search result.
+	mux.HandleFunc("GET /chunks/{id}", authn.OptionalAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.LookupTimeout)
+		defer cancel()
+		c, ok, err := st.GetChunkByID(ctx, id)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if ok {
+			ok, err = canReadChunk(ctx, authn, st, r, c)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if !ok {
+			http.Error(w, "Chunk not found", http.StatusNotFound)
+			return
+		}
+		fillContent(ctx, sources, &c)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(c); err != nil {
+			http.Error(w, "Failed to encode chunk", 500)
+		}
+	}))
+	// GET /chunks/{id}/similar ("more like this") ranks other chunks, in any
+	// repository, by similarity to the chunk's summary. The repository,
+	// language and ref filters of /search apply.
+	mux.HandleFunc("GET /chunks/{id}/similar", authn.OptionalAuthMiddleware(quota(st, limits, quotaSearch, func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		k := 10
+		if v := r.URL.Query().Get("k"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 100 {
+				http.Error(w, "k must be between 1 and 100", http.StatusBadRequest)
+				return
+			}
+			k = n
+		}
+		opt := store.QueryOpts{
+			Repositories:        queryList(r, "repository"),
+			Languages:           queryList(r, "language"),
+			Ref:                 r.URL.Query().Get("ref"),
+			Principals:          principals(authn, r),
+			AllowedRepositories: allowedRepositories(r),
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.RequestTimeout)
+		defer cancel()
+		c, ok, err := st.GetChunkByID(ctx, id)
+		if ok {
+			ok, err = canReadChunk(ctx, authn, st, r, c)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if !ok {
+			http.Error(w, "Chunk not found or not embedded", http.StatusNotFound)
+			return
+		}
+		res, ok, err := st.SimilarChunks(ctx, id, k, opt)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if !ok {
+			http.Error(w, "Chunk not found or not embedded", http.StatusNotFound)
+			return
+		}
+		ptrs := make([]*models.Chunk, len(res))
+		for i := range res {
+			if math.IsNaN(res[i].Score) || math.IsInf(res[i].Score, 0) {
+				res[i].Score = 0
+			}
+			ptrs[i] = &res[i].Chunk
+		}
+		fillContent(ctx, sources, ptrs...)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			http.Error(w, "Failed to encode results", 500)
+		}
+	})))
+	// GET /chunks/{id}/siblings returns the other chunks of the chunk's file
+	// ordered by line range; content=false leaves out their content.
+	mux.HandleFunc("GET /chunks/{id}/siblings", authn.OptionalAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.LookupTimeout)
+		defer cancel()
+		c, ok, err := st.GetChunkByID(ctx, id)
+		if ok {
+			ok, err = canReadChunk(ctx, authn, st, r, c)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if !ok {
+			http.Error(w, "Chunk not found", http.StatusNotFound)
+			return
+		}
+		chunks, err := st.GetFileChunks(ctx, c.Repository, c.Ref, c.Path)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		out := make([]models.Chunk, 0, len(chunks))
+		for _, s := range chunks {
+			if s.ID != c.ID {
+				out = append(out, s)
+			}
+		}
+		ptrs := make([]*models.Chunk, len(out))
+		for i := range out {
+			ptrs[i] = &out[i]
+		}
+		if r.URL.Query().Get("content") == "false" {
+			for _, s := range ptrs {
+				s.Content = ""
+			}
+		} else {
+			fillContent(ctx, sources, ptrs...)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(out); err != nil {
+			http.Error(w, "Failed to encode chunks", 500)
+		}
+	}))
+	// /graphql serves repositories, refs, chunks and search as GraphQL, so
+	// that clients fetch exactly the fields they need in one request. GET
+	// without a query returns the schema.
+	gql, err := graphqlSchema(cfg, authn, st, svc, sources, limits)
+	if err != nil {
+		log.Fatalf("Failed to build the GraphQL schema: %v", err)
+	}
+	graphqlHandler := authn.OptionalAuthMiddleware(graphql.Handler(gql).ServeHTTP)
+	mux.HandleFunc("GET /graphql", graphqlHandler)
+	mux.HandleFunc("POST /graphql", graphqlHandler)
+	// GET /search/facets counts the chunks matching q and the /search filters
+	// by repository, language, ref and top-level directory, for filter
+	// sidebars.
+	mux.HandleFunc("GET /search/facets", authn.OptionalAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		opt, err := queryFilters(authn, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, opt = search.ParseQuery(r.URL.Query().Get("q"), opt)
+
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.RequestTimeout)
+		defer cancel()
+		facets, err := st.Facets(ctx, opt)
+		if err != nil {
+			searchError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(facets); err != nil {
+			http.Error(w, "Failed to encode facets", 500)
+		}
+	}))
+	// /saved-searches lists (GET) and creates (POST) the saved searches of
+	// the signed-in user; /saved-searches/{id} reads (GET), replaces (PUT)
+	// and deletes (DELETE) one. Other users' saved searches are not found.
+	mux.HandleFunc("GET /saved-searches", authn.RequireAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUserFromContext(r).Login
+		list, err := st.ListSavedSearches(r.Context(), user)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(list); err != nil {
+			log.Printf("failed to encode saved searches: %v", err)
+		}
+	}))
+	mux.HandleFunc("POST /saved-searches", authn.RequireAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUserFromContext(r).Login
+		var ss models.SavedSearch
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&ss); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := checkSavedSearch(ss); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ss.ID = newID()
+		ss.CreatedAt = time.Now().UTC()
+		ss.UpdatedAt = ss.CreatedAt
+		if err := st.CreateSavedSearch(r.Context(), user, ss); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		hlog.FromRequest(r).Info().Str("user", user).Str("id", ss.ID).Msg("saved search created")
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/saved-searches/"+ss.ID)
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(ss); err != nil {
+			log.Printf("failed to encode saved search: %v", err)
+		}
+	}))
+	mux.HandleFunc("GET /saved-searches/{id}", authn.RequireAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUserFromContext(r).Login
+		id := r.PathValue("id")
+		ss, ok, err := st.GetSavedSearch(r.Context(), user, id)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if !ok {
+			http.Error(w, "saved search not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(ss); err != nil {
+			log.Printf("failed to encode saved search: %v", err)
+		}
+	}))
+	mux.HandleFunc("PUT /saved-searches/{id}", authn.RequireAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUserFromContext(r).Login
+		id := r.PathValue("id")
+		var ss models.SavedSearch
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&ss); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := checkSavedSearch(ss); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ss.ID = id
+		ss.UpdatedAt = time.Now().UTC()
+		ok, err := st.UpdateSavedSearch(r.Context(), user, ss)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if !ok {
+			http.Error(w, "saved search not found", http.StatusNotFound)
+			return
+		}
+		// Return the stored search, including its creation time.
+		if ss, ok, err = st.GetSavedSearch(r.Context(), user, id); err != nil || !ok {
+			http.Error(w, "failed to read saved search", 500)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(ss); err != nil {
+			log.Printf("failed to encode saved search: %v", err)
+		}
+	}))
+	mux.HandleFunc("DELETE /saved-searches/{id}", authn.RequireAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUserFromContext(r).Login
+		id := r.PathValue("id")
+		ok, err := st.DeleteSavedSearch(r.Context(), user, id)
 		if err != nil {
 			http.Error(w, err.Error(), 500)
 			return
 		}
+		if !ok {
+			http.Error(w, "saved search not found", http.StatusNotFound)
+			return
+		}
+		hlog.FromRequest(r).Info().Str("user", user).Str("id", id).Msg("saved search deleted")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	// GET /suggest completes a partially typed query with matching paths,
+	// symbol names and the caller's earlier searches, for type-ahead in the
+	// search box. The /search filters narrow the paths and symbols.
+	mux.HandleFunc("GET /suggest", authn.OptionalAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		opt, err := queryFilters(authn, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit := defaultSuggestLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxSuggestLimit {
+				http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxSuggestLimit), http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
 
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.LookupTimeout)
+		defer cancel()
+		var user string
+		if u := auth.GetUserFromContext(r); u != nil {
+			user = u.Login
+		}
+		suggestions, err := st.Suggest(ctx, r.URL.Query().Get("q"), limit, user, opt)
+		if err != nil {
+			searchError(w, err)
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(repos); err != nil {
-			http.Error(w, "Failed to encode repositories", 500)
+		if err := json.NewEncoder(w).Encode(suggestions); err != nil {
+			http.Error(w, "Failed to encode suggestions", 500)
 		}
 	}))
-	mux.HandleFunc("/repositories/", auth.OptionalAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		// Support paths like /repositories/{repo}/refs where {repo} may contain '/'
-		// e.g. repo encoded as owner%2Frepo by the frontend.
-		rel := strings.TrimPrefix(r.URL.Path, "/repositories/")
-		// Normalize trailing slash
-		rel = strings.TrimSuffix(rel, "/")
-
-		// If URL ends with /refs, extract the repo portion before it.
-		if strings.HasSuffix(rel, "/refs") {
-			repoPart := strings.TrimSuffix(rel, "/refs")
-			// Remove any leading slash remaining
-			repoPart = strings.TrimPrefix(repoPart, "/")
-			repoName, err := url.PathUnescape(repoPart)
-			if err != nil {
-				http.Error(w, "Invalid repository path", http.StatusBadRequest)
+	// GET /search/live is a WebSocket for type-ahead search: the client
+	// sends LiveQuery messages as the user types and receives LiveResults
+	// for each query once typing pauses. Filters are taken from the URL
+	// and apply to every query on the connection.
+	mux.HandleFunc("GET /search/live", authn.OptionalAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		opt, err := queryFilters(authn, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var audit func(LiveResults)
+		if cfg.Auth.Enabled {
+			audit = func(res LiveResults) {
+				auditSearch(st, r, res.Q, opt, len(res.Results), resultRepositories(res.Results))
+			}
+		}
+		meter := func() error { return charge(nil, r, st, limits, quotaSearch, 1) }
+		liveSearch(w, r, svc, opt, cfg.Server.RequestTimeout, meter, audit)
+	}))
+	// POST /search/batch runs several queries with shared filters, e.g.
+	// reformulations fanned out by an agent, embedding them in one provider
+	// call. Results omit chunk content unless content is true. Batches are
+	// not recorded in the query log, so that fan-out doesn't skew analytics.
+	mux.HandleFunc("POST /search/batch", authn.OptionalAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		var req BatchSearchRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(req.Queries) == 0 || len(req.Queries) > maxBatchQueries {
+			http.Error(w, fmt.Sprintf("queries must hold between 1 and %d queries", maxBatchQueries), http.StatusBadRequest)
+			return
+		}
+		for _, q := range req.Queries {
+			if strings.TrimSpace(q) == "" {
+				http.Error(w, "queries must not be empty", http.StatusBadRequest)
 				return
 			}
+		}
+		k := req.K
+		if k == 0 {
+			k = 5
+		}
+		if k < 1 || k > maxBatchK {
+			http.Error(w, fmt.Sprintf("k must be between 1 and %d", maxBatchK), http.StatusBadRequest)
+			return
+		}
+		opt, err := filterOpts(req.Filters)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opt.Principals = principals(authn, r)
+		opt.AllowedRepositories = allowedRepositories(r)
+		if err := charge(w, r, st, limits, quotaSearch, len(req.Queries)); err != nil {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
 
-			ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-			defer cancel()
-			refs, err := st.GetRefs(ctx, repoName)
-			if err != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.BulkTimeout)
+		defer cancel()
+		results, err := svc.QueryBatch(ctx, req.Queries, k, opt)
+		if err != nil {
+			searchError(w, err)
+			return
+		}
+		out := make([]BatchResult, len(req.Queries))
+		var ptrs []*models.Chunk
+		for i, res := range results {
+			for j := range res {
+				if math.IsNaN(res[j].Score) || math.IsInf(res[j].Score, 0) {
+					res[j].Score = 0
+				}
+				res[j].Permalink = chunkPermalink(res[j].Chunk)
+				ptrs = append(ptrs, &res[j].Chunk)
+			}
+			out[i] = BatchResult{Query: req.Queries[i], Results: res}
+		}
+		if req.Content {
+			fillContent(ctx, sources, ptrs...)
+			for i := range out {
+				fillSnippets(sources, out[i].Query, out[i].Results)
+			}
+		} else {
+			for _, c := range ptrs {
+				c.Content = ""
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(out); err != nil {
+			log.Printf("failed to encode batch results: %v", err)
+		}
+		hlog.FromRequest(r).Info().Str("path", "/search/batch").Int("queries", len(req.Queries)).Int("k", k).Dur("dur", time.Since(start)).Msg("served")
+		if cfg.Auth.Enabled {
+			for _, b := range out {
+				auditSearch(st, r, b.Query, opt, len(b.Results), resultRepositories(b.Results))
+			}
+		}
+	}))
+	// GET /search/stream runs a chunk search like /search and sends it as
+	// Server-Sent Events: a "meta" event with the total and next cursor, one
+	// "result" event per hit in rank order, sent as soon as its content and
+	// context are ready, and a final "done" event. Failures after the stream
+	// has started are sent as an "error" event.
+	mux.HandleFunc("GET /search/stream", authn.OptionalAuthMiddleware(quota(st, limits, quotaSearch, func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		q, k, opt, expand, err := searchParams(authn, r, cfg.SearchDefaultK, cfg.SearchMaxK)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if level := r.URL.Query().Get("level"); level != "" && level != "chunk" {
+			http.Error(w, "level must be chunk", http.StatusBadRequest)
+			return
+		}
+		fields, err := parseFields(r.URL.Query().Get("fields"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.BulkTimeout)
+		defer cancel()
+		page, err := svc.QueryPage(ctx, q, k, r.URL.Query().Get("cursor"), opt)
+		if errors.Is(err, search.ErrInvalidCursor) || errors.Is(err, search.ErrPagingUnsupported) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			searchError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no") // disable proxy buffering
+		send := func(event string, v any) bool {
+			if err := writeEvent(w, event, v); err != nil {
+				return false
+			}
+			flusher.Flush()
+			return true
+		}
+		if !send("meta", map[string]any{"total": page.Total, "next_cursor": page.NextCursor}) {
+			return
+		}
+		withContent := r.URL.Query().Get("content") != "false" && fieldsNeedContent(fields)
+		for i := range page.Results {
+			res := &page.Results[i]
+			if math.IsNaN(res.Score) || math.IsInf(res.Score, 0) {
+				res.Score = 0
+			}
+			if expand > 0 {
+				if res.Before, res.After, err = st.GetNeighbors(ctx, res.Chunk, expand); err != nil {
+					send("error", map[string]string{"error": err.Error()})
+					return
+				}
+			}
+			ptrs := []*models.Chunk{&res.Chunk}
+			for j := range res.Before {
+				ptrs = append(ptrs, &res.Before[j])
+			}
+			for j := range res.After {
+				ptrs = append(ptrs, &res.After[j])
+			}
+			if withContent {
+				fillContent(ctx, sources, ptrs...)
+				fillSnippets(sources, q, page.Results[i:i+1])
+			} else {
+				for _, c := range ptrs {
+					c.Content = ""
+				}
+			}
+			var v any = res
+			if fields != nil {
+				v = selectFields(*res, fields)
+			}
+			if !send("result", v) {
+				return
+			}
+		}
+		send("done", map[string]int{"count": len(page.Results)})
+
+		hlog.FromRequest(r).Info().Str("path", "/search/stream").Str("q", q).Int("k", k).Dur("dur", time.Since(start)).Msg("served")
+		if cfg.QueryLog {
+			logQuery(st, r, cfg.QueryLogText, q, k, page.Total, start)
+		}
+		if cfg.Auth.Enabled {
+			auditSearch(st, r, q, opt, len(page.Results), resultRepositories(page.Results))
+		}
+	})))
+	// POST /ask answers a question from the top chunks of a search for it,
+	// with every sentence citing the chunks it was drawn from.
+	mux.HandleFunc("POST /ask", authn.OptionalAuthMiddleware(quota(st, limits, quotaAsk, func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		var req AskRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(req.Question) == "" {
+			http.Error(w, "question is required", http.StatusBadRequest)
+			return
+		}
+		if req.K == 0 {
+			req.K = defaultAskK
+		}
+		if req.K < 1 || req.K > maxAskK {
+			http.Error(w, fmt.Sprintf("k must be between 1 and %d", maxAskK), http.StatusBadRequest)
+			return
+		}
+		opt := store.QueryOpts{
+			Repositories:        req.Repositories,
+			Languages:           req.Languages,
+			Ref:                 req.Ref,
+			PathContains:        req.PathContains,
+			Principals:          principals(authn, r),
+			AllowedRepositories: allowedRepositories(r),
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.AskTimeout)
+		defer cancel()
+		answer, err := svc.Ask(ctx, req.Question, req.K, opt)
+		if errors.Is(err, search.ErrAskUnsupported) {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(answer); err != nil {
+			log.Printf("failed to encode answer: %v", err)
+		}
+		hlog.FromRequest(r).Info().Str("path", "/ask").Int("k", req.K).Int("sources", len(answer.Sources)).Dur("dur", time.Since(start)).Msg("answered")
+		if cfg.Auth.Enabled {
+			auditSearch(st, r, req.Question, opt, len(answer.Sources), citedRepositories(answer.Sources))
+		}
+	})))
+	// POST /chat answers the next message of a conversation like /ask,
+	// rewriting follow-up questions into standalone searches using the
+	// earlier turns of the session. The answer is sent as Server-Sent Events:
+	// a "sources" event with the session id, the query searched for and the
+	// citable sources, "delta" events with the text as it is generated, and a
+	// "done" event with the cited answer. Both turns are then saved to the
+	// session. Sessions started by a signed-in user can only be continued by
+	// that user.
+	mux.HandleFunc("POST /chat", authn.OptionalAuthMiddleware(quota(st, limits, quotaAsk, func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		var req ChatRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Message = strings.TrimSpace(req.Message)
+		if req.Message == "" {
+			http.Error(w, "message is required", http.StatusBadRequest)
+			return
+		}
+		if req.K == 0 {
+			req.K = defaultAskK
+		}
+		if req.K < 1 || req.K > maxAskK {
+			http.Error(w, fmt.Sprintf("k must be between 1 and %d", maxAskK), http.StatusBadRequest)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		opt := store.QueryOpts{
+			Repositories:        req.Repositories,
+			Languages:           req.Languages,
+			Ref:                 req.Ref,
+			PathContains:        req.PathContains,
+			Principals:          principals(authn, r),
+			AllowedRepositories: allowedRepositories(r),
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.ChatTimeout)
+		defer cancel()
+		var history []models.ChatTurn
+		if req.SessionID == "" {
+			req.SessionID = newID()
+		} else {
+			var err error
+			if history, err = chatHistory(ctx, st, r, req.SessionID); errors.Is(err, errSessionNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			} else if err != nil {
 				http.Error(w, err.Error(), 500)
 				return
 			}
-			w.Header().Set("Content-Type", "application/json")
-			if err := json.NewEncoder(w).Encode(refs); err != nil {
-				http.Error(w, "Failed to encode refs", 500)
+		}
+
+		// The stream starts once the sources are known, so errors before
+		// then get a status code.
+		started := false
+		send := func(event string, v any) error {
+			if err := writeEvent(w, event, v); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		}
+		stream := search.ChatStream{
+			Sources: func(query string, sources []models.Citation) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Header().Set("Cache-Control", "no-cache")
+				w.Header().Set("X-Accel-Buffering", "no")
+				started = true
+				_ = send("sources", ChatSources{SessionID: req.SessionID, Query: query, Sources: sources})
+			},
+			Delta: func(text string) error {
+				return send("delta", map[string]string{"text": text})
+			},
+		}
+		answer, query, err := svc.Chat(ctx, history, req.Message, req.K, opt, stream)
+		if err != nil {
+			switch {
+			case started:
+				_ = send("error", map[string]string{"error": err.Error()})
+			case errors.Is(err, search.ErrAskUnsupported):
+				http.Error(w, err.Error(), http.StatusNotImplemented)
+			default:
+				http.Error(w, err.Error(), 500)
 			}
 			return
 		}
 
-		http.NotFound(w, r)
+		var login string
+		if u := auth.GetUserFromContext(r); u != nil {
+			login = u.Login
+		}
+		now := time.Now()
+		turns := []models.ChatTurn{
+			{Role: "user", Content: req.Message, Query: query, CreatedAt: now},
+			{Role: "assistant", Content: answer.Answer, Sources: answer.Sources, CreatedAt: now},
+		}
+		if err := st.AppendChatTurns(ctx, req.SessionID, login, len(history), turns); err != nil {
+			_ = send("error", map[string]string{"error": "failed to save chat turn: " + err.Error()})
+			return
+		}
+		_ = send("done", answer)
+		hlog.FromRequest(r).Info().Str("path", "/chat").Str("session", req.SessionID).Int("turn", len(history)/2+1).Int("sources", len(answer.Sources)).Dur("dur", time.Since(start)).Msg("answered")
+		if cfg.Auth.Enabled {
+			auditSearch(st, r, query, opt, len(answer.Sources), citedRepositories(answer.Sources))
+		}
+	})))
+	// GET /chat/{session} returns the turns of a conversation.
+	mux.HandleFunc("GET /chat/{session}", authn.OptionalAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("session")
+		turns, err := chatHistory(r.Context(), st, r, id)
+		if err == nil && len(turns) == 0 {
+			err = errSessionNotFound
+		}
+		if errors.Is(err, errSessionNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(ChatSession{SessionID: id, Turns: turns}); err != nil {
+			log.Printf("failed to encode chat session: %v", err)
+		}
 	}))
-	mux.HandleFunc("/search", auth.OptionalAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	// GET /search returns JSON by default; format=csv|jsonl|markdown, or an
+	// Accept header naming one of them, exports chunk results instead.
+	// fields=path,score,... reduces JSON results to the named fields.
+	mux.HandleFunc("GET /search", authn.OptionalAuthMiddleware(quota(st, limits, quotaSearch, func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		q := r.URL.Query().Get("q")
-		k := 5
-		if v := r.URL.Query().Get("k"); v != "" {
-			if n, err := strconv.Atoi(v); err == nil {
-				k = n
-			}
+		q, k, opt, expand, err := searchParams(authn, r, cfg.SearchDefaultK, cfg.SearchMaxK)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		format, err := resultFormat(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fields, err := parseFields(r.URL.Query().Get("fields"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		if q == "" {
-			http.Error(w, "missing query parameter q", http.StatusBadRequest)
+		if fields != nil && (format == formatCSV || format == formatMarkdown) {
+			http.Error(w, "fields only applies to json and jsonl results", http.StatusBadRequest)
 			return
 		}
 
-		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.Server.RequestTimeout)
 		defer cancel()
-		opt := store.QueryOpts{
-			Language:     r.URL.Query().Get("language"), // e.g. "shell"
-			PathContains: r.URL.Query().Get("path_contains"),
-			Repository:   r.URL.Query().Get("repository"),
-			Ref:          r.URL.Query().Get("ref"),
+
+		// level=file|dir searches file or directory rollup summaries instead of chunks
+		switch level := r.URL.Query().Get("level"); level {
+		case "", "chunk":
+		case store.RollupFile, store.RollupDir:
+			if format != formatJSON {
+				http.Error(w, "only chunk searches can be exported as "+format, http.StatusBadRequest)
+				return
+			}
+			if fields != nil {
+				http.Error(w, "fields only applies to chunk searches", http.StatusBadRequest)
+				return
+			}
+			res, err := svc.QueryRollups(ctx, q, k, level, opt)
+			if err != nil {
+				searchError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(res); err != nil {
+				log.Printf("failed to encode response: %v", err)
+			}
+			hlog.FromRequest(r).Info().Str("path", "/search").Str("q", q).Str("level", level).Int("k", k).Dur("dur", time.Since(start)).Msg("served")
+			if cfg.QueryLog {
+				logQuery(st, r, cfg.QueryLogText, q, k, len(res), start)
+			}
+			if cfg.Auth.Enabled {
+				repos := make([]string, len(res))
+				for i := range res {
+					repos[i] = res[i].Rollup.Repository
+				}
+				slices.Sort(repos)
+				auditSearch(st, r, q, opt, len(res), slices.Compact(repos))
+			}
+			return
+		default:
+			http.Error(w, "level must be one of chunk, file or dir", http.StatusBadRequest)
+			return
+		}
+
+		// offset or cursor pages through results; the total and the cursor of
+		// the next page are returned in headers so the body stays an array.
+		page, err := svc.QueryPage(ctx, q, k, r.URL.Query().Get("cursor"), opt)
+		if errors.Is(err, search.ErrInvalidCursor) || errors.Is(err, search.ErrPagingUnsupported) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		res, err := svc.Query(ctx, q, k, opt)
 		if err != nil {
-			http.Error(w, err.Error(), 500)
+			searchError(w, err)
+			return
+		}
+		res := page.Results
+		for i := 0; expand > 0 && i < len(res); i++ {
+			res[i].Before, res[i].After, err = st.GetNeighbors(ctx, res[i].Chunk, expand)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+		}
+		// content=false drops the full chunk content, leaving the snippet
+		var ptrs []*models.Chunk
+		for i := range res {
+			ptrs = append(ptrs, &res[i].Chunk)
+			for j := range res[i].Before {
+				ptrs = append(ptrs, &res[i].Before[j])
+			}
+			for j := range res[i].After {
+				ptrs = append(ptrs, &res[i].After[j])
+			}
+		}
+		// CSV and Markdown only show summaries, so content is not fetched,
+		// nor is it when the selected fields leave it out.
+		if r.URL.Query().Get("content") == "false" || format == formatCSV || format == formatMarkdown || !fieldsNeedContent(fields) {
+			for _, c := range ptrs {
+				c.Content = ""
+			}
+		} else {
+			fillContent(ctx, sources, ptrs...)
+			fillSnippets(sources, q, res)
+		}
+		w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+		if page.NextCursor != "" {
+			w.Header().Set("X-Next-Cursor", page.NextCursor)
+		}
+		for i := range res {
+			if math.IsNaN(res[i].Score) || math.IsInf(res[i].Score, 0) {
+				res[i].Score = 0
+			}
+			res[i].Permalink = chunkPermalink(res[i].Chunk)
+		}
+
+		if format != formatJSON {
+			if err := writeResults(w, format, res, fields); err != nil {
+				log.Printf("failed to write %s results: %v", format, err)
+			}
+			hlog.FromRequest(r).Info().Str("path", "/search").Str("q", q).Str("format", format).Int("k", k).Dur("dur", time.Since(start)).Msg("served")
+			if cfg.QueryLog {
+				logQuery(st, r, cfg.QueryLogText, q, k, page.Total, start)
+			}
+			if cfg.Auth.Enabled {
+				auditSearch(st, r, q, opt, len(res), resultRepositories(res))
+			}
 			return
 		}
 
@@ -385,12 +2539,7 @@ func main() {
 				return
 			}
 		} else {
-			for i := range res {
-				if math.IsNaN(res[i].Score) || math.IsInf(res[i].Score, 0) {
-					res[i].Score = 0
-				}
-			}
-			if err := json.NewEncoder(w).Encode(res); err != nil {
+			if err := json.NewEncoder(w).Encode(projectResults(res, fields)); err != nil {
 				log.Printf("failed to encode response: %v", err)
 				// fallback to an empty JSON array if encoding or writing fails
 				_, _ = w.Write([]byte("[]"))
@@ -398,16 +2547,192 @@ func main() {
 		}
 
 		hlog.FromRequest(r).Info().Str("path", "/search").Str("q", q).Int("k", k).Dur("dur", time.Since(start)).Msg("served")
-	}))
+		if cfg.QueryLog {
+			logQuery(st, r, cfg.QueryLogText, q, k, page.Total, start)
+		}
+		if cfg.Auth.Enabled {
+			auditSearch(st, r, q, opt, len(res), resultRepositories(res))
+		}
+	})))
+
+	// The embedded web frontend takes every path no API route claims.
+	if cfg.ServeUI {
+		mux.Handle("/", webui.Handler())
+	}
 
 	handler := hlog.NewHandler(logger)(
 		hlog.AccessHandler(func(r *http.Request, status, size int, dur time.Duration) {
 			logger.Info().Str("method", r.Method).Str("path", r.URL.Path).Int("status", status).Int("size", size).Dur("dur", dur).Msg("http")
-		})(mux),
+		})(httpcompress.Handler(mux)),
 	)
 
 	address := fmt.Sprintf(":%d", cfg.Port)
-	s := &http.Server{Addr: address, Handler: handler}
+	s := &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
 	logger.Info().Str("addr", s.Addr).Msg("api server listening")
 	log.Fatal(s.ListenAndServe())
 }
+
+// searchParams parses the query, result count, filters, tuning parameters
+// and context expansion shared by /search and /search/stream. k defaults to
+// defaultK and may be at most maxK.
+func searchParams(authn *auth.Service, r *http.Request, defaultK, maxK int) (q string, k int, opt store.QueryOpts, expand int, err error) {
+	q = r.URL.Query().Get("q")
+	if q == "" {
+		return "", 0, opt, 0, errors.New("missing query parameter q")
+	}
+	k = defaultK
+	if v := r.URL.Query().Get("k"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxK {
+			return "", 0, opt, 0, fmt.Errorf("k must be between 1 and %d", maxK)
+		}
+		k = n
+	}
+	if opt, err = queryFilters(authn, r); err != nil {
+		return "", 0, opt, 0, err
+	}
+	if v := r.URL.Query().Get("ef_search"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > store.MaxEfSearch {
+			return "", 0, opt, 0, fmt.Errorf("ef_search must be between 1 and %d", store.MaxEfSearch)
+		}
+		opt.EfSearch = n
+	}
+	if v := r.URL.Query().Get("probes"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return "", 0, opt, 0, errors.New("probes must be a positive integer")
+		}
+		opt.Probes = n // at most the index's lists are probed
+	}
+	if opt.Fusion, err = store.ParseFusion(r.URL.Query().Get("fusion")); err != nil {
+		return "", 0, opt, 0, errors.New("fusion must be one of weighted or rrf")
+	}
+	// explain=true returns the signals behind each score, for debugging
+	// rankings.
+	opt.Explain = r.URL.Query().Get("explain") == "true"
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return "", 0, opt, 0, errors.New("offset must be a non-negative integer")
+		}
+		opt.Offset = n
+	}
+	// expand=n returns up to n adjacent chunks of the same file on each
+	// side of every hit.
+	if v := r.URL.Query().Get("expand"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxExpand {
+			return "", 0, opt, 0, fmt.Errorf("expand must be between 0 and %d", maxExpand)
+		}
+		expand = n
+	}
+	return q, k, opt, expand, nil
+}
+
+// searchError writes the error of a search or other filtered query: a bad
+// request for a path_regex the database rejects, else an internal error.
+func searchError(w http.ResponseWriter, err error) {
+	if store.IsInvalidPathRegex(err) {
+		http.Error(w, "invalid path_regex: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, err.Error(), 500)
+}
+
+// filterOpts converts the filters of a request body to query options.
+func filterOpts(f models.SearchFilters) (store.QueryOpts, error) {
+	if _, err := regexp.Compile(f.PathRegex); err != nil {
+		return store.QueryOpts{}, fmt.Errorf("invalid path_regex: %w", err)
+	}
+	return store.QueryOpts{
+		Repositories:    f.Repositories,
+		Languages:       f.Languages,
+		Ref:             f.Ref,
+		Path:            f.Path,
+		PathContains:    f.PathContains,
+		PathNotContains: f.PathNotContains,
+		PathRegex:       f.PathRegex,
+	}, nil
+}
+
+// queryFilters parses the filter parameters shared by /search and
+// /search/facets, and limits them to the repositories the user may read.
+func queryFilters(authn *auth.Service, r *http.Request) (store.QueryOpts, error) {
+	opt := store.QueryOpts{
+		// repository, language and path_not_contains may be repeated or
+		// comma-separated, e.g. language=go,shell
+		Repositories:        queryList(r, "repository"),
+		Languages:           queryList(r, "language"),
+		Path:                r.URL.Query().Get("path"),
+		PathContains:        r.URL.Query().Get("path_contains"),
+		PathNotContains:     queryList(r, "path_not_contains"),
+		PathRegex:           r.URL.Query().Get("path_regex"), // e.g. cmd/.*/main\.go
+		Ref:                 r.URL.Query().Get("ref"),
+		Principals:          principals(authn, r),
+		AllowedRepositories: allowedRepositories(r),
+	}
+	// Postgres regexes are close enough to RE2 to reject most bad patterns
+	// up front; searchError turns those only Postgres rejects into a 400.
+	if _, err := regexp.Compile(opt.PathRegex); err != nil {
+		return store.QueryOpts{}, fmt.Errorf("invalid path_regex: %w", err)
+	}
+	return opt, nil
+}
+
+// writeEvent writes one Server-Sent Event with v encoded as JSON.
+func writeEvent(w http.ResponseWriter, event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
+// queryList returns every value of a query parameter, accepting both
+// repeated parameters (?a=x&a=y) and comma-separated values (?a=x,y).
+func queryList(r *http.Request, name string) []string {
+	var out []string
+	for _, v := range r.URL.Query()[name] {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				out = append(out, p)
+			}
+		}
+	}
+	return out
+}
+
+// filterValues encodes the filters of opt as /search parameters.
+func filterValues(opt store.QueryOpts) url.Values {
+	v := url.Values{}
+	for _, name := range opt.Repositories {
+		v.Add("repository", name)
+	}
+	for _, lang := range opt.Languages {
+		v.Add("language", lang)
+	}
+	for _, p := range opt.PathNotContains {
+		v.Add("path_not_contains", p)
+	}
+	for name, s := range map[string]string{
+		"ref":           opt.Ref,
+		"path":          opt.Path,
+		"path_contains": opt.PathContains,
+		"path_regex":    opt.PathRegex,
+		"symbol":        opt.Symbol,
+	} {
+		if s != "" {
+			v.Set(name, s)
+		}
+	}
+	return v
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/seanblong/reposearch/internal/auth"
+)
+
+func TestNotModified(t *testing.T) {
+	st := newTestStore(t)
+	ctx := context.Background()
+	limited := func(repos ...string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/repositories", nil)
+		return r.WithContext(context.WithValue(r.Context(), auth.UserContextKey, &auth.GithubUser{Login: "api-key/k", Repositories: repos}))
+	}
+
+	w := httptest.NewRecorder()
+	r := limited("a", "b")
+	if notModified(ctx, w, r, st, visibilityVariant(nil, r)) {
+		t.Fatal("expected a first request to be served")
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" || w.Header().Get("Cache-Control") != "private, no-cache" || w.Header().Get("Vary") != "Authorization, Cookie" {
+		t.Fatalf("unexpected headers: %v", w.Header())
+	}
+
+	// The same repositories in another order revalidate; other callers don't.
+	for _, c := range []struct {
+		r    *http.Request
+		want bool
+	}{
+		{limited("b", "a"), true},
+		{limited("a"), false},
+		{httptest.NewRequest(http.MethodGet, "/repositories", nil), false},
+	} {
+		c.r.Header.Set("If-None-Match", etag)
+		w := httptest.NewRecorder()
+		if got := notModified(ctx, w, c.r, st, visibilityVariant(nil, c.r)); got != c.want {
+			t.Errorf("notModified for %v = %v, want %v", auth.GetUserFromContext(c.r), got, c.want)
+		}
+	}
+}
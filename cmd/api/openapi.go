@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/seanblong/reposearch/internal/auth"
+	"github.com/seanblong/reposearch/internal/graphql"
+	"github.com/seanblong/reposearch/internal/indexer"
+	"github.com/seanblong/reposearch/internal/openapi"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+// filterParams are the filters parsed by queryFilters.
+var filterParams = []openapi.Param{
+	{Name: "repository", In: "query", Type: []string{}, Description: "Only search these repositories; repeated or comma-separated."},
+	{Name: "language", In: "query", Type: []string{}, Description: "Only search these languages; repeated or comma-separated."},
+	{Name: "ref", In: "query", Description: "Only search this ref."},
+	{Name: "path", In: "query", Description: "Only search the file at this exact path."},
+	{Name: "path_contains", In: "query", Description: "Only match paths containing this substring."},
+	{Name: "path_not_contains", In: "query", Type: []string{}, Description: "Exclude paths containing any of these substrings."},
+	{Name: "path_regex", In: "query", Description: "Only match paths matching this regular expression, e.g. cmd/.*/main\\.go. It must be valid both as a Go (RE2) and a POSIX regular expression; otherwise the request fails with 400."},
+}
+
+var repoParam = openapi.Param{Name: "repo", In: "path", Description: "Repository name, URL-encoded when it contains '/'."}
+
+// apiSpec documents the routes registered in main. Keep it in step with the
+// handlers: the schemas are generated from the types they encode.
+func apiSpec(authn *auth.Service) *openapi.Spec {
+	spec := openapi.New("reposearch API", version, "Semantic code search over indexed repositories.")
+	spec.CookieName = authn.CookieName()
+	spec.Add(openapi.Operation{Method: "GET", Path: "/livez", Summary: "Liveness check", Tags: []string{"meta"},
+		Description: "Replies 200 while the server is running, without checking its dependencies. /healthz is an alias."})
+	spec.Add(openapi.Operation{Method: "GET", Path: "/healthz", Summary: "Liveness check (alias of /livez)", Tags: []string{"meta"}})
+	spec.Add(openapi.Operation{Method: "GET", Path: "/readyz", Summary: "Readiness check", Tags: []string{"meta"},
+		Description: "Checks that the database answers and, with readyzProvider set, that the AI provider does. Replies 503 with the same body when a check fails.",
+		Response:    Readiness{}})
+	spec.Add(openapi.Operation{Method: "GET", Path: "/auth/status", Summary: "Whether authentication is enabled", Tags: []string{"auth"},
+		Description: "providers lists the configured login providers (github, gitlab, google, saml); each has a /auth/{provider} login flow.",
+		Response:    authStatus{}})
+	if authn.IsAuthEnabled() {
+		if authn.GithubEnabled() {
+			spec.Add(openapi.Operation{Method: "GET", Path: "/auth/github", Summary: "Start the GitHub login flow", Tags: []string{"auth"}, Status: http.StatusTemporaryRedirect})
+			spec.Add(openapi.Operation{Method: "GET", Path: "/auth/callback", Summary: "Complete the GitHub login flow", Tags: []string{"auth"},
+				Params: []openapi.Param{
+					{Name: "code", In: "query", Required: true, Description: "OAuth code returned by GitHub."},
+					{Name: "state", In: "query", Required: true, Description: "OAuth state, checked against the oauth_state cookie."},
+				},
+				Response: auth.AuthResponse{}})
+		}
+		if authn.GitlabEnabled() {
+			spec.Add(openapi.Operation{Method: "GET", Path: "/auth/gitlab", Summary: "Start the GitLab login flow", Tags: []string{"auth"}, Status: http.StatusTemporaryRedirect})
+			spec.Add(openapi.Operation{Method: "GET", Path: "/auth/gitlab/callback", Summary: "Complete the GitLab login flow", Tags: []string{"auth"},
+				Params: []openapi.Param{
+					{Name: "code", In: "query", Required: true, Description: "OAuth code returned by GitLab."},
+					{Name: "state", In: "query", Required: true, Description: "OAuth state, checked against the oauth_state cookie."},
+				},
+				Response: auth.AuthResponse{}})
+		}
+		if authn.GoogleEnabled() {
+			spec.Add(openapi.Operation{Method: "GET", Path: "/auth/google", Summary: "Start the Google login flow", Tags: []string{"auth"}, Status: http.StatusTemporaryRedirect})
+			spec.Add(openapi.Operation{Method: "GET", Path: "/auth/google/callback", Summary: "Complete the Google login flow", Tags: []string{"auth"},
+				Params: []openapi.Param{
+					{Name: "code", In: "query", Required: true, Description: "OAuth code returned by Google."},
+					{Name: "state", In: "query", Required: true, Description: "OAuth state, checked against the oauth_state cookie."},
+				},
+				Response: auth.AuthResponse{}})
+		}
+		if authn.SAMLEnabled() {
+			spec.Add(openapi.Operation{Method: "GET", Path: "/auth/saml", Summary: "Start the SAML login flow", Tags: []string{"auth"}, Status: http.StatusFound,
+				Description: "Redirects to the identity provider, which posts its response to " + auth.SAMLACSPath + "."})
+			spec.Add(openapi.Operation{Method: "GET", Path: auth.SAMLMetadataPath, Summary: "SAML service provider metadata", Tags: []string{"auth"},
+				Description: "XML metadata to register reposearch with the identity provider."})
+			spec.Add(openapi.Operation{Method: "POST", Path: auth.SAMLACSPath, Summary: "Complete the SAML login flow", Tags: []string{"auth"}, Status: http.StatusSeeOther,
+				Description: "Assertion consumer service. Verifies the identity provider's SAMLResponse form field, signs the user in with session cookies and redirects to samlReturnURL."})
+		}
+		spec.Add(openapi.Operation{Method: "GET", Path: "/auth/me", Summary: "The signed-in user", Tags: []string{"auth"}, Auth: openapi.AuthRequired,
+			Response: auth.AuthResponse{}})
+		spec.Add(openapi.Operation{Method: "POST", Path: "/auth/refresh", Summary: "Renew the session", Tags: []string{"auth"},
+			Description: "Trades the refresh_token cookie for a new access token and a new refresh token. " +
+				"Reusing a refresh token that was already traded ends the session.",
+			Response: auth.AuthResponse{}})
+		spec.Add(openapi.Operation{Method: "POST", Path: "/auth/logout", Summary: "End the session, revoking its tokens, and clear its cookies", Tags: []string{"auth"}})
+	}
+
+	// Listings that carry the index version as their ETag.
+	etagParams := []openapi.Param{{Name: "If-None-Match", In: "header", Description: "ETag of a cached copy; 304 is returned while it is current."}}
+	etagHeaders := []openapi.Param{{Name: "ETag", Description: "Changes whenever the index is written."}}
+	spec.Add(openapi.Operation{Method: "GET", Path: "/repositories", Summary: "List indexed repositories", Tags: []string{"repositories"}, Auth: openapi.AuthOptional,
+		Params: etagParams, Response: []string{}, Headers: etagHeaders})
+	spec.Add(openapi.Operation{Method: "DELETE", Path: "/repositories/{repo}", Summary: "Delete every ref of a repository",
+		Description: "Returns the number of chunks deleted. Deletes are soft until the indexer runs in vacuum mode.", Tags: []string{"repositories"}, Auth: openapi.AuthRequired,
+		Params: []openapi.Param{repoParam}, Response: DeleteResponse{}})
+	spec.Add(openapi.Operation{Method: "POST", Path: "/repositories/{repo}/restore", Summary: "Undo a repository delete", Tags: []string{"repositories"}, Auth: openapi.AuthRequired,
+		Params: []openapi.Param{repoParam}, Status: http.StatusNoContent})
+	spec.Add(openapi.Operation{Method: "GET", Path: "/repositories/{repo}/refs", Summary: "List the indexed refs of a repository", Tags: []string{"repositories"}, Auth: openapi.AuthOptional,
+		Params: append([]openapi.Param{repoParam}, etagParams...), Response: []string{}, Headers: etagHeaders})
+	refParams := []openapi.Param{repoParam, {Name: "ref", In: "path", Description: "Ref name, URL-encoded when it contains '/'."}}
+	spec.Add(openapi.Operation{Method: "DELETE", Path: "/repositories/{repo}/refs/{ref}", Summary: "Delete a ref", Tags: []string{"repositories"}, Auth: openapi.AuthRequired,
+		Params: refParams, Status: http.StatusNoContent})
+	spec.Add(openapi.Operation{Method: "POST", Path: "/repositories/{repo}/refs/{ref}/restore", Summary: "Undo a ref delete", Tags: []string{"repositories"}, Auth: openapi.AuthRequired,
+		Params: refParams, Status: http.StatusNoContent})
+	if authn.IsAuthEnabled() {
+		grantParams := []openapi.Param{repoParam, {Name: "principal", In: "path", Description: "A user login, or api-key/<id> for an API key."}}
+		spec.Add(openapi.Operation{Method: "GET", Path: "/repositories/{repo}/grants", Summary: "List the grants of a repository", Tags: []string{"repositories"}, Auth: openapi.AuthRequired,
+			Description: "A repository with grants is private to its grantees; one without is open to every user. Requires the admin role on the repository.",
+			Params:      []openapi.Param{repoParam}, Response: []models.RepositoryGrant{}})
+		spec.Add(openapi.Operation{Method: "PUT", Path: "/repositories/{repo}/grants/{principal}", Summary: "Give a principal a role on a repository", Tags: []string{"repositories"}, Auth: openapi.AuthRequired,
+			Description: "Requires the admin role on the repository. The first grant on an open repository must make the caller its admin; 409 when a change would leave it without an admin.",
+			Params:      grantParams, Request: GrantRequest{}, Response: models.RepositoryGrant{}})
+		spec.Add(openapi.Operation{Method: "DELETE", Path: "/repositories/{repo}/grants/{principal}", Summary: "Revoke a principal's role on a repository", Tags: []string{"repositories"}, Auth: openapi.AuthRequired,
+			Description: "Revoking the last grant makes the repository open again; 409 when other grantees would be left without an admin.",
+			Params:      grantParams, Status: http.StatusNoContent})
+	}
+	spec.Add(openapi.Operation{Method: "GET", Path: "/repositories/{repo}/status", Summary: "Indexing state of each ref of a repository", Tags: []string{"repositories"}, Auth: openapi.AuthOptional,
+		Description: "Chunk counts, the last successfully indexed commit and the outcome of the most recent indexing run.",
+		Params:      []openapi.Param{repoParam}, Response: models.RepositoryStatus{}})
+	spec.Add(openapi.Operation{Method: "GET", Path: "/repositories/{repo}/files", Summary: "Every chunk of a file, ordered by line range", Tags: []string{"repositories"}, Auth: openapi.AuthOptional,
+		Params:   []openapi.Param{repoParam, {Name: "ref", In: "query", Required: true}, {Name: "path", In: "query", Required: true}},
+		Response: []models.Chunk{}})
+	spec.Add(openapi.Operation{Method: "GET", Path: "/repositories/{repo}/file", Summary: "A whole file assembled from its chunks", Tags: []string{"repositories"}, Auth: openapi.AuthOptional,
+		Description: "With raw=true the file is returned as text/plain instead.",
+		Params: []openapi.Param{repoParam, {Name: "ref", In: "query", Required: true}, {Name: "path", In: "query", Required: true},
+			{Name: "raw", In: "query", Type: true}},
+		Response: models.File{}})
+
+	spec.Add(openapi.Operation{Method: "GET", Path: "/chunks/{id}", Summary: "A single chunk", Tags: []string{"chunks"}, Auth: openapi.AuthOptional,
+		Params: []openapi.Param{{Name: "id", In: "path"}}, Response: models.Chunk{}})
+	spec.Add(openapi.Operation{Method: "GET", Path: "/chunks/{id}/siblings", Summary: "The other chunks of a chunk's file, ordered by line range", Tags: []string{"chunks"}, Auth: openapi.AuthOptional,
+		Params: []openapi.Param{
+			{Name: "id", In: "path"},
+			{Name: "content", In: "query", Type: true, Description: "false leaves out the chunk content."},
+		},
+		Response: []models.Chunk{}})
+	spec.Add(openapi.Operation{Method: "GET", Path: "/chunks/{id}/similar", Summary: "Chunks similar to a chunk", Tags: []string{"chunks"}, Auth: openapi.AuthOptional,
+		Params: []openapi.Param{
+			{Name: "id", In: "path"},
+			{Name: "k", In: "query", Type: 0, Description: "Number of results, 1 to 100 (default 10)."},
+			filterParams[0], filterParams[1], filterParams[2],
+		},
+		Response: []models.SearchResult{}})
+
+	searchQuery := append([]openapi.Param{
+		{Name: "q", In: "query", Required: true, Description: "Query; may contain qualifiers such as lang:go or symbol:Name."},
+		{Name: "k", In: "query", Type: 0, Description: "Number of results (default 5, at most 100 unless configured otherwise)."},
+		{Name: "level", In: "query", Description: "chunk (default), file or dir; file and dir search rollup summaries."},
+		{Name: "offset", In: "query", Type: 0},
+		{Name: "cursor", In: "query", Description: "X-Next-Cursor of the previous page."},
+		{Name: "expand", In: "query", Type: 0, Description: "Adjacent chunks to return on each side of every hit."},
+		{Name: "content", In: "query", Type: true, Description: "false drops the chunk content, leaving the snippet when the index stores content."},
+		{Name: "fusion", In: "query", Description: "weighted or rrf."},
+		{Name: "explain", In: "query", Type: true, Description: "true adds the signals and weights behind each chunk score as explain."},
+		{Name: "fields", In: "query", Description: "Comma-separated chunk result fields to return, e.g. path,score,summary; " +
+			"any of id, repository, ref, path, language, line_start, line_end, summary, content, score, permalink, snippet, highlights, before, after and explain."},
+		{Name: "ef_search", In: "query", Type: 0, Description: "HNSW candidate list size, 1 to 400."},
+		{Name: "probes", In: "query", Type: 0, Description: "ivfflat lists probed, capped at the index's lists."},
+	}, filterParams...)
+	spec.Add(openapi.Operation{Method: "GET", Path: "/search", Summary: "Search chunks or rollup summaries", Tags: []string{"search"}, Auth: openapi.AuthOptional,
+		Description: "Chunk results can be exported with format=csv, jsonl or markdown, or an Accept header of text/csv, application/x-ndjson " +
+			"or text/markdown. CSV and Markdown have one row per result with its repository, ref, path, lines, score and summary; " +
+			"JSON lines have one SearchResult per line. fields reduces each JSON or JSON lines result to a flat object of the named fields.",
+		Params:   append(searchQuery, openapi.Param{Name: "format", In: "query", Description: "json (default), csv, jsonl or markdown."}),
+		Response: openapi.OneOf([]models.SearchResult{}, []models.RollupResult{}),
+		Headers: []openapi.Param{
+			{Name: "X-Total-Count", Type: 0, Description: "Total number of matching chunks."},
+			{Name: "X-Next-Cursor", Description: "Cursor of the next page, when there is one."},
+		}})
+	var streamQuery []openapi.Param
+	for _, p := range searchQuery {
+		if p.Name != "level" {
+			streamQuery = append(streamQuery, p)
+		}
+	}
+	spec.Add(openapi.Operation{Method: "GET", Path: "/search/stream", Summary: "Search chunks, streaming results as Server-Sent Events", Tags: []string{"search"}, Auth: openapi.AuthOptional,
+		Description: "Takes the /search parameters except level. Sends a meta event with the total and next cursor, one result event per hit " +
+			"(a SearchResult), then a done event with the count; failures after the stream starts are sent as an error event.",
+		Params: streamQuery, Response: openapi.Text("text/event-stream")})
+	fileQuery := []openapi.Param{repoParam,
+		{Name: "ref", In: "query", Required: true},
+		{Name: "path", In: "query", Required: true, Description: "Path of the file to search."},
+	}
+	for _, p := range searchQuery {
+		switch p.Name {
+		case "level", "cursor", "offset", "repository", "ref", "path":
+		default:
+			fileQuery = append(fileQuery, p)
+		}
+	}
+	spec.Add(openapi.Operation{Method: "GET", Path: "/repositories/{repo}/files/search", Summary: "Search the chunks of one file", Tags: []string{"search"}, Auth: openapi.AuthOptional,
+		Description: "Ranks only the chunks of the file, e.g. to find where in it something is configured. Takes the /search parameters other than level and paging.",
+		Params:      fileQuery, Response: []models.SearchResult{}})
+	spec.Add(openapi.Operation{Method: "GET", Path: "/search/facets", Summary: "Counts of matching chunks by repository, language, ref and directory", Tags: []string{"search"}, Auth: openapi.AuthOptional,
+		Params: append([]openapi.Param{{Name: "q", In: "query"}}, filterParams...), Response: models.Facets{}})
+	spec.Add(openapi.Operation{Method: "POST", Path: "/graphql", Summary: "Query repositories, refs, chunks and search with GraphQL", Tags: []string{"search"}, Auth: openapi.AuthOptional,
+		Description: "Supports queries with variables, aliases and fragments; GET /graphql returns the schema. Errors in the query itself return 400 with null data.",
+		Request:     graphql.Request{}, Response: graphql.Response{}})
+	spec.Add(openapi.Operation{Method: "GET", Path: "/graphql", Summary: "Run a GraphQL query, or get the schema", Tags: []string{"search"}, Auth: openapi.AuthOptional,
+		Params: []openapi.Param{
+			{Name: "query", In: "query", Description: "Without a query the schema is returned as SDL."},
+			{Name: "operationName", In: "query"},
+			{Name: "variables", In: "query", Description: "Variables as a JSON object."},
+		},
+		Response: openapi.OneOf(graphql.Response{}, openapi.Text("text/plain"))})
+
+	spec.Add(openapi.Operation{Method: "GET", Path: "/suggest", Summary: "Complete a partial query with paths, symbols and the caller's earlier searches", Tags: []string{"search"}, Auth: openapi.AuthOptional,
+		Description: "Paths contain q, symbol names and earlier searches that returned results start with it. The filters narrow paths and symbols.",
+		Params:      append([]openapi.Param{{Name: "q", In: "query", Required: true}, {Name: "limit", In: "query", Description: "Suggestions of each kind (default 5, at most 20).", Type: 0}}, filterParams...),
+		Response:    models.Suggestions{}})
+	spec.Add(openapi.Operation{Method: "GET", Path: "/search/live", Summary: "Type-ahead search over a WebSocket", Tags: []string{"search"}, Auth: openapi.AuthOptional,
+		Description: "Upgrades to a WebSocket. The client sends {\"seq\", \"q\", \"k\"} messages as the user types; once no message has arrived for 250ms " +
+			"the latest query runs, cancelling any search still running, and the server replies with {\"seq\", \"q\", \"results\", \"error\"}. " +
+			"Results omit chunk content. Filters in the URL apply to every query.",
+		Params: filterParams, Status: http.StatusSwitchingProtocols})
+	spec.Add(openapi.Operation{Method: "POST", Path: "/search/batch", Summary: "Run several searches with shared filters", Tags: []string{"search"}, Auth: openapi.AuthOptional,
+		Description: fmt.Sprintf("Runs up to %d queries, embedding them in one provider call where supported, and returns their results "+
+			"in the order of the queries. k is at most %d. Chunk content is omitted unless content is true.", maxBatchQueries, maxBatchK),
+		Request: BatchSearchRequest{}, Response: []BatchResult{}})
+	spec.Add(openapi.Operation{Method: "POST", Path: "/ask", Summary: "Answer a question from the indexed code, with citations", Tags: []string{"search"}, Auth: openapi.AuthOptional,
+		Description: "Searches for the question, gives the top k chunks to the summary model as numbered sources and returns its answer " +
+			"split into sentences, each citing the sources it was drawn from. Returns 501 when the provider cannot generate text.",
+		Request: AskRequest{}, Response: models.Answer{}})
+	spec.Add(openapi.Operation{Method: "POST", Path: "/chat", Summary: "Continue a conversation about the indexed code", Tags: []string{"search"}, Auth: openapi.AuthOptional,
+		Description: "Answers a message like /ask, rewriting follow-up questions into standalone searches using the earlier turns of the session, " +
+			"and streams the answer as Server-Sent Events: \"sources\" ({\"session_id\", \"query\", \"sources\"}), \"delta\" ({\"text\"}) events as text is generated, " +
+			"then \"done\" (Answer) or \"error\" ({\"error\"}). Omit session_id to start a new session; the id is in the sources event.",
+		Request: ChatRequest{}, Response: openapi.Text("text/event-stream")})
+	spec.Add(openapi.Operation{Method: "GET", Path: "/chat/{session}", Summary: "Get the turns of a conversation", Tags: []string{"search"}, Auth: openapi.AuthOptional,
+		Params: []openapi.Param{{Name: "session", In: "path"}}, Response: ChatSession{}})
+
+	idParam := openapi.Param{Name: "id", In: "path"}
+	spec.Add(openapi.Operation{Method: "GET", Path: "/saved-searches", Summary: "List your saved searches", Tags: []string{"saved searches"}, Auth: openapi.AuthRequired,
+		Response: []models.SavedSearch{}})
+	spec.Add(openapi.Operation{Method: "POST", Path: "/saved-searches", Summary: "Save a search", Tags: []string{"saved searches"}, Auth: openapi.AuthRequired,
+		Description: "The id and times in the body are ignored. The filters are named after the /search parameters.",
+		Request:     models.SavedSearch{}, Response: models.SavedSearch{}, Status: http.StatusCreated,
+		Headers: []openapi.Param{{Name: "Location", Description: "URL of the saved search."}}})
+	spec.Add(openapi.Operation{Method: "GET", Path: "/saved-searches/{id}", Summary: "Get a saved search", Tags: []string{"saved searches"}, Auth: openapi.AuthRequired,
+		Params: []openapi.Param{idParam}, Response: models.SavedSearch{}})
+	spec.Add(openapi.Operation{Method: "PUT", Path: "/saved-searches/{id}", Summary: "Replace a saved search", Tags: []string{"saved searches"}, Auth: openapi.AuthRequired,
+		Params: []openapi.Param{idParam}, Request: models.SavedSearch{}, Response: models.SavedSearch{}})
+	spec.Add(openapi.Operation{Method: "DELETE", Path: "/saved-searches/{id}", Summary: "Delete a saved search", Tags: []string{"saved searches"}, Auth: openapi.AuthRequired,
+		Params: []openapi.Param{idParam}, Status: http.StatusNoContent})
+
+	spec.Add(openapi.Operation{Method: "GET", Path: "/stats", Summary: "Index statistics", Tags: []string{"admin"}, Auth: openapi.AuthOptional,
+		Response: models.IndexStats{}})
+	spec.Add(openapi.Operation{Method: "GET", Path: "/analytics/queries", Summary: "Search analytics", Tags: []string{"admin"}, Auth: openapi.AuthRequired,
+		Params:   []openapi.Param{{Name: "since", In: "query", Description: "Window to summarize, e.g. 24h (default 168h)."}},
+		Response: models.QueryStats{}})
+	spec.Add(openapi.Operation{Method: "GET", Path: "/admin/audit", Summary: "Search audit trail, newest first", Tags: []string{"admin"}, Auth: openapi.AuthRequired,
+		Description: "Every search is recorded with its user, query, filters, result count and the repositories of its results while auth is enabled.",
+		Params: []openapi.Param{
+			{Name: "user", In: "query", Description: "Only searches by this user."},
+			{Name: "repository", In: "query", Description: "Only searches with results from this repository."},
+			{Name: "since", In: "query", Description: "RFC 3339 time or duration before now, e.g. 24h."},
+			{Name: "until", In: "query", Description: "RFC 3339 time or duration before now."},
+			{Name: "limit", In: "query", Type: 0, Description: "Maximum records (default 100, max 1000)."},
+		},
+		Response: []models.SearchAudit{}})
+	spec.Add(openapi.Operation{Method: "GET", Path: "/admin/auth-events", Summary: "Auth event log, newest first", Tags: []string{"admin"}, Auth: openapi.AuthRequired,
+		Description: "Logins, refused tokens and API keys, session refreshes, logouts and session revocations, with the client's address and user agent and whether they succeeded.",
+		Params: []openapi.Param{
+			{Name: "user", In: "query", Description: "Only events of this user."},
+			{Name: "event", In: "query", Description: "Only events of this type: login, validation, refresh, logout or revocation."},
+			{Name: "outcome", In: "query", Description: "Only successes or failures: success or failure."},
+			{Name: "since", In: "query", Description: "RFC 3339 time or duration before now, e.g. 24h."},
+			{Name: "until", In: "query", Description: "RFC 3339 time or duration before now."},
+			{Name: "limit", In: "query", Type: 0, Description: "Maximum events (default 100, max 1000)."},
+		},
+		Response: []models.AuthEvent{}})
+	spec.Add(openapi.Operation{Method: "POST", Path: "/admin/reload", Summary: "Reload the configuration", Tags: []string{"admin"}, Auth: openapi.AuthRequired,
+		Description: "Like SIGHUP, reads the configuration again and applies the log level and quotas without a restart. An invalid configuration is refused and changes nothing; other settings need a restart.",
+		Response:    ReloadResult{}})
+	spec.Add(openapi.Operation{Method: "POST", Path: "/admin/optimize", Summary: "Refresh statistics and optionally rebuild vector indexes", Tags: []string{"admin"}, Auth: openapi.AuthRequired,
+		Params: []openapi.Param{{Name: "reindex", In: "query", Type: true}}, Status: http.StatusAccepted})
+	spec.Add(openapi.Operation{Method: "POST", Path: "/admin/index", Summary: "Clone and index a repository in the background", Tags: []string{"admin"}, Auth: openapi.AuthRequired,
+		Description: "The ref defaults to the configured git ref. Returns 409 while the same repository and ref are being indexed.",
+		Request:     indexer.JobRequest{}, Response: models.Job{}, Status: http.StatusAccepted,
+		Headers: []openapi.Param{{Name: "Location", Description: "Status URL of the job."}}})
+	spec.Add(openapi.Operation{Method: "GET", Path: "/admin/index", Summary: "Running and recent indexing jobs, newest first", Tags: []string{"admin"}, Auth: openapi.AuthRequired,
+		Response: []models.Job{}})
+	spec.Add(openapi.Operation{Method: "GET", Path: "/admin/index/{id}", Summary: "An indexing job", Tags: []string{"admin"}, Auth: openapi.AuthRequired,
+		Params: []openapi.Param{{Name: "id", In: "path"}}, Response: models.Job{}})
+	spec.Add(openapi.Operation{Method: "POST", Path: "/admin/jobs", Summary: "Enqueue a background job", Tags: []string{"admin"}, Auth: openapi.AuthRequired,
+		Description: "Kinds are index, resummarize, reembed and vacuum; index jobs take the parameters of POST /admin/index. Returns 409 while an equivalent job is queued or running.",
+		Request:     EnqueueJobRequest{}, Response: models.Job{}, Status: http.StatusAccepted,
+		Headers: []openapi.Param{{Name: "Location", Description: "Status URL of the job."}}})
+	spec.Add(openapi.Operation{Method: "GET", Path: "/admin/jobs", Summary: "Queued, running and recent jobs, newest first", Tags: []string{"admin"}, Auth: openapi.AuthRequired,
+		Params: []openapi.Param{{Name: "kind", In: "query", Description: "Only jobs of this kind."}}, Response: []models.Job{}})
+	spec.Add(openapi.Operation{Method: "GET", Path: "/admin/jobs/{id}", Summary: "A job and its progress", Tags: []string{"admin"}, Auth: openapi.AuthRequired,
+		Params: []openapi.Param{{Name: "id", In: "path"}}, Response: models.Job{}})
+	spec.Add(openapi.Operation{Method: "GET", Path: "/admin/jobs/{id}/logs", Summary: "Log lines of a job, oldest first", Tags: []string{"admin"}, Auth: openapi.AuthRequired,
+		Params: []openapi.Param{{Name: "id", In: "path"}}, Response: []models.JobLog{}})
+	spec.Add(openapi.Operation{Method: "POST", Path: "/admin/jobs/{id}/cancel", Summary: "Cancel a job", Tags: []string{"admin"}, Auth: openapi.AuthRequired,
+		Description: "A queued job is canceled at once; a running job stops at its next checkpoint. Returns 409 if the job already finished.",
+		Params:      []openapi.Param{{Name: "id", In: "path"}}, Response: models.Job{}, Status: http.StatusAccepted})
+	spec.Add(openapi.Operation{Method: "GET", Path: "/admin/api-keys", Summary: "API keys, including revoked ones, newest first", Tags: []string{"admin"}, Auth: openapi.AuthRequired,
+		Response: []models.APIKey{}})
+	spec.Add(openapi.Operation{Method: "POST", Path: "/admin/api-keys", Summary: "Create an API key", Tags: []string{"admin"}, Auth: openapi.AuthRequired,
+		Description: "The key is returned only in this response; send it in the X-API-Key header. Scopes are search (the endpoints open to any signed-in user), index (/admin/index) and admin (the other authenticated endpoints), and default to search. A key with repositories can only search and read those repositories. An expired key is refused like a revoked one. API keys cannot manage API keys.",
+		Request:     APIKeyRequest{}, Response: NewAPIKey{}, Status: http.StatusCreated})
+	spec.Add(openapi.Operation{Method: "DELETE", Path: "/admin/api-keys/{id}", Summary: "Revoke an API key", Tags: []string{"admin"}, Auth: openapi.AuthRequired,
+		Params: []openapi.Param{{Name: "id", In: "path"}}, Status: http.StatusNoContent})
+	spec.Add(openapi.Operation{Method: "DELETE", Path: "/admin/users/{login}/sessions", Summary: "Revoke every session of a user", Tags: []string{"admin"}, Auth: openapi.AuthRequired,
+		Description: "Every access and refresh token issued to the user so far stops working; the user has to log in again.",
+		Params:      []openapi.Param{{Name: "login", In: "path"}}, Status: http.StatusNoContent})
+	return spec
+}
+
+// docsPage renders Swagger UI for /openapi.json.
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>reposearch API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+// registerDocs serves the OpenAPI document at /openapi.json and Swagger UI
+// at /docs.
+func registerDocs(mux *http.ServeMux, authn *auth.Service) {
+	doc, err := json.Marshal(apiSpec(authn))
+	if err != nil {
+		panic(err) // the spec only contains maps, slices and strings
+	}
+	mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(doc)
+	})
+	mux.HandleFunc("GET /docs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(docsPage))
+	})
+}
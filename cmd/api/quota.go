@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+	"github.com/seanblong/reposearch/internal/auth"
+	"github.com/seanblong/reposearch/internal/config"
+	"github.com/seanblong/reposearch/internal/store"
+)
+
+// Metered endpoint kinds, each with its own daily quota. Asking covers the
+// endpoints that call the language model.
+const (
+	quotaSearch = "search"
+	quotaAsk    = "ask"
+)
+
+// quotas holds the daily limit of each metered kind, which reloading the
+// configuration may change while the server runs.
+type quotas struct {
+	search, ask atomic.Int64
+}
+
+// newQuotas returns the quotas of spec.
+func newQuotas(spec config.QuotaSpecification) *quotas {
+	q := &quotas{}
+	q.set(spec)
+	return q
+}
+
+// set replaces the limits with those of spec.
+func (q *quotas) set(spec config.QuotaSpecification) {
+	q.search.Store(int64(spec.SearchPerDay))
+	q.ask.Store(int64(spec.AskPerDay))
+}
+
+// limit returns the daily limit of kind, 0 meaning none.
+func (q *quotas) limit(kind string) int {
+	if kind == quotaAsk {
+		return int(q.ask.Load())
+	}
+	return int(q.search.Load())
+}
+
+// quota wraps the handler of an endpoint metered as kind so that each
+// signed-in user gets at most the quotas' limit of requests of that kind per
+// UTC day; a limit of 0 means no quota. Endpoints that run several searches
+// per request charge them with charge instead.
+func quota(st store.Backend, quotas *quotas, kind string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := charge(w, r, st, quotas, kind, 1); err != nil {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// quotaExceededError is returned by charge once a user is over quota.
+type quotaExceededError struct {
+	kind  string
+	limit int
+}
+
+func (e *quotaExceededError) Error() string {
+	return fmt.Sprintf("Daily %s quota of %d requests exceeded", e.kind, e.limit)
+}
+
+// charge counts n requests of kind against the daily quota of the user of
+// r, and returns a *quotaExceededError once it is exceeded. When w is not
+// nil, the quota is reported in its X-RateLimit-* headers. Requests are let
+// through when usage cannot be counted.
+func charge(w http.ResponseWriter, r *http.Request, st store.Backend, quotas *quotas, kind string, n int) error {
+	limit := quotas.limit(kind)
+	user := auth.GetUserFromContext(r)
+	if limit <= 0 || user == nil {
+		return nil
+	}
+	now := time.Now().UTC()
+	var used int
+	for range n {
+		u, err := st.IncrementUsage(r.Context(), user.Login, kind, now)
+		if err != nil {
+			hlog.FromRequest(r).Error().Err(err).Str("user", user.Login).Msg("failed to count usage")
+			return nil
+		}
+		used = u
+	}
+	reset := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	if w != nil {
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(max(limit-used, 0)))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	}
+	if used > limit {
+		if w != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(int(reset.Sub(now).Seconds())+1))
+		}
+		return &quotaExceededError{kind: kind, limit: limit}
+	}
+	return nil
+}
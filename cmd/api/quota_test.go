@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/seanblong/reposearch/internal/auth"
+	"github.com/seanblong/reposearch/internal/config"
+	"github.com/seanblong/reposearch/internal/store"
+)
+
+func newTestStore(t *testing.T) store.Backend {
+	t.Helper()
+	ctx := context.Background()
+	s, err := store.OpenSQLite(ctx, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	t.Cleanup(s.Close)
+	if err := s.Migrate(ctx, 3); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return s
+}
+
+// signedIn returns a request made by the user login, or anonymously when
+// login is empty.
+func signedIn(login string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/search?q=x", nil)
+	if login == "" {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), auth.UserContextKey, &auth.GithubUser{Login: login}))
+}
+
+func TestQuota(t *testing.T) {
+	st := newTestStore(t)
+	limits := newQuotas(config.QuotaSpecification{SearchPerDay: 2, AskPerDay: 1})
+	served := 0
+	h := quota(st, limits, quotaSearch, func(w http.ResponseWriter, r *http.Request) { served++ })
+
+	for i, remaining := range []string{"1", "0"} {
+		w := httptest.NewRecorder()
+		h(w, signedIn("alice"))
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i+1, w.Code)
+		}
+		if got := w.Header().Get("X-RateLimit-Limit"); got != "2" {
+			t.Errorf("request %d: X-RateLimit-Limit = %q", i+1, got)
+		}
+		if got := w.Header().Get("X-RateLimit-Remaining"); got != remaining {
+			t.Errorf("request %d: X-RateLimit-Remaining = %q, want %q", i+1, got, remaining)
+		}
+		reset, err := strconv.ParseInt(w.Header().Get("X-RateLimit-Reset"), 10, 64)
+		if until := time.Until(time.Unix(reset, 0)); err != nil || until <= 0 || until > 24*time.Hour {
+			t.Errorf("request %d: X-RateLimit-Reset = %q", i+1, w.Header().Get("X-RateLimit-Reset"))
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h(w, signedIn("alice"))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 over quota, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("unexpected headers over quota: %v", w.Header())
+	}
+	if served != 2 {
+		t.Errorf("expected 2 requests served, got %d", served)
+	}
+
+	// Other users, other kinds and anonymous requests are counted apart.
+	for _, r := range []*http.Request{signedIn("bob"), signedIn("")} {
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("status %d", w.Code)
+		}
+	}
+	w = httptest.NewRecorder()
+	quota(st, limits, quotaAsk, func(http.ResponseWriter, *http.Request) {})(w, signedIn("alice"))
+	if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "1" {
+		t.Errorf("ask: status %d, headers %v", w.Code, w.Header())
+	}
+
+	// A limit of 0, as after a reload, lifts the quota.
+	limits.set(config.QuotaSpecification{})
+	w = httptest.NewRecorder()
+	h(w, signedIn("alice"))
+	if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "" {
+		t.Errorf("unlimited: status %d, headers %v", w.Code, w.Header())
+	}
+}
+
+func TestCharge(t *testing.T) {
+	st := newTestStore(t)
+	limits := newQuotas(config.QuotaSpecification{SearchPerDay: 3})
+
+	// A batch is charged per query.
+	w := httptest.NewRecorder()
+	if err := charge(w, signedIn("alice"), st, limits, quotaSearch, 2); err != nil {
+		t.Fatalf("charge: %v", err)
+	}
+	if got := w.Header().Get("X-RateLimit-Remaining"); got != "1" {
+		t.Errorf("X-RateLimit-Remaining = %q", got)
+	}
+	err := charge(w, signedIn("alice"), st, limits, quotaSearch, 2)
+	var exceeded *quotaExceededError
+	if !errors.As(err, &exceeded) || exceeded.limit != 3 {
+		t.Fatalf("expected the quota to be exceeded, got %v", err)
+	}
+
+	// Without a response, as for GraphQL and live searches, only the error
+	// reports the quota.
+	if err := charge(nil, signedIn("alice"), st, limits, quotaSearch, 1); err == nil {
+		t.Error("expected the quota to stay exceeded")
+	}
+	if err := charge(nil, signedIn("bob"), st, limits, quotaSearch, 1); err != nil {
+		t.Errorf("charge for another user: %v", err)
+	}
+}
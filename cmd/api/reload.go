@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rs/zerolog"
+	"github.com/seanblong/reposearch/internal/config"
+	"github.com/spf13/pflag"
+)
+
+// ReloadResult is the response of POST /admin/reload: the settings now in
+// effect.
+type ReloadResult struct {
+	LogLevel string                    `json:"log_level"`
+	Quota    config.QuotaSpecification `json:"quota"`
+}
+
+// reloadConfig loads the configuration again, as at startup, and applies the
+// settings that can change while the server runs: the log level and the
+// daily quotas. The configuration is validated in full before anything is
+// applied, so an invalid one changes nothing. Other settings keep their
+// startup values until a restart.
+func reloadConfig(quotas *quotas) (ReloadResult, error) {
+	cfg, err := config.LoadAPI("", pflag.NewFlagSet("reposearch-api", pflag.ContinueOnError))
+	if err != nil {
+		return ReloadResult{}, err
+	}
+	// The secrets stay those read at startup, so any read again from Vault
+	// are given back at once.
+	if err := cfg.RevokeVaultLeases(context.Background()); err != nil {
+		log.Printf("failed to revoke vault leases of the reloaded configuration: %v", err)
+	}
+	level, err := zerolog.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return ReloadResult{}, fmt.Errorf("invalid log level %q: %w", cfg.LogLevel, err)
+	}
+	zerolog.SetGlobalLevel(level)
+	quotas.set(cfg.Quota)
+	return ReloadResult{LogLevel: level.String(), Quota: cfg.Quota}, nil
+}
+
+// reloadOnSIGHUP reloads the configuration each time the process receives
+// SIGHUP, until ctx is done.
+func reloadOnSIGHUP(ctx context.Context, logger zerolog.Logger, quotas *quotas) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				res, err := reloadConfig(quotas)
+				if err != nil {
+					logger.Error().Err(err).Msg("config reload failed; keeping the current settings")
+					continue
+				}
+				logger.Info().Str("log_level", res.LogLevel).Int("search_per_day", res.Quota.SearchPerDay).Int("ask_per_day", res.Quota.AskPerDay).Msg("config reloaded")
+			}
+		}
+	}()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/seanblong/reposearch/internal/auth"
+	"github.com/seanblong/reposearch/internal/store"
+)
+
+// samlRequestCookie holds the ID of a pending SAML login request, which the
+// identity provider's response must answer.
+const samlRequestCookie = "saml_request"
+
+// samlMetadata serves the service provider metadata, to register reposearch
+// with the identity provider.
+func samlMetadata(authn *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, err := authn.SAMLMetadata()
+		if err != nil {
+			http.Error(w, "Failed to build SAML metadata", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/samlmetadata+xml")
+		_, _ = w.Write(doc)
+	}
+}
+
+// samlStart begins a SAML login: it remembers the request in a cookie and
+// redirects to the identity provider.
+func samlStart(authn *auth.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		loginURL, requestID, err := authn.SAMLLoginURL()
+		if err != nil {
+			http.Error(w, "Failed to start SAML login", http.StatusInternalServerError)
+			return
+		}
+
+		// The identity provider posts its response from its own site, so
+		// over HTTPS the cookie must be sent with cross-site requests.
+		cookie := authn.Cookie(r, samlRequestCookie, requestID, auth.SAMLACSPath, 600) // 10 minutes
+		if cookie.Secure {
+			cookie.SameSite = http.SameSiteNoneMode
+		}
+		http.SetCookie(w, cookie)
+
+		http.Redirect(w, r, loginURL, http.StatusFound)
+	}
+}
+
+// samlACS is the assertion consumer service: it completes a SAML login begun
+// by samlStart, signing the user in with a new session, and sends the
+// browser on to returnURL.
+func samlACS(authn *auth.Service, st store.Backend, returnURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(samlRequestCookie)
+		if err != nil || cookie.Value == "" {
+			authn.RecordEvent(r, auth.EventLogin, "", errors.New("no pending SAML request"))
+			http.Error(w, "No pending SAML login", http.StatusBadRequest)
+			return
+		}
+		http.SetCookie(w, authn.Cookie(r, samlRequestCookie, "", auth.SAMLACSPath, -1))
+
+		user, err := authn.GetSAMLUser(r, cookie.Value)
+		if err != nil {
+			authn.RecordEvent(r, auth.EventLogin, "", err)
+			http.Error(w, "Invalid SAML response", http.StatusForbidden)
+			return
+		}
+
+		_, err = issueSession(r.Context(), w, r, authn, st, user, newID(), time.Time{})
+		authn.RecordEvent(r, auth.EventLogin, user.Login, err)
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, returnURL, http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+	"github.com/seanblong/reposearch/internal/auth"
+	"github.com/seanblong/reposearch/internal/config"
+	"github.com/seanblong/reposearch/internal/store"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// refreshCookiePath limits the refresh cookie to the endpoints that use it,
+// relative to the configured cookie path.
+const refreshCookiePath = "auth"
+
+// cookieConfig returns the cookie attributes of c, which config.LoadAPI has
+// checked.
+func cookieConfig(c config.CookieSpecification) auth.CookieConfig {
+	cc := auth.CookieConfig{
+		Name:     c.Name,
+		Domain:   c.Domain,
+		Path:     c.Path,
+		SameSite: map[string]http.SameSite{"lax": http.SameSiteLaxMode, "strict": http.SameSiteStrictMode, "none": http.SameSiteNoneMode}[c.SameSite],
+	}
+	if c.Secure != "auto" {
+		secure := c.Secure == "true"
+		cc.Secure = &secure
+	}
+	return cc
+}
+
+// issueSession signs user in for a session: it sets a short-lived access
+// token and a new refresh token of family as cookies, and returns the access
+// token. started is when the session signed in, or zero for a new session;
+// its refresh tokens record it as their creation time and expire at the
+// latest the session's maximum age after it.
+func issueSession(ctx context.Context, w http.ResponseWriter, r *http.Request, authn *auth.Service, st store.Backend, user *auth.GithubUser, family string, started time.Time) (string, error) {
+	token, err := authn.GenerateJWT(user)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(user)
+	if err != nil {
+		return "", err
+	}
+	refresh, hash, err := auth.NewRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	if started.IsZero() {
+		started = now
+	}
+	expires := now.Add(authn.RefreshTokenTTL())
+	if end := started.Add(authn.SessionMaxAge()); end.Before(expires) {
+		expires = end
+	}
+	err = st.CreateRefreshToken(ctx, models.RefreshToken{
+		Family:    family,
+		User:      string(data),
+		CreatedAt: started,
+		ExpiresAt: expires,
+	}, hash)
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, authn.Cookie(r, authn.CookieName(), token, "", int(authn.AccessTokenTTL().Seconds())))
+	http.SetCookie(w, refreshCookie(authn, r, refresh, int(expires.Sub(now).Seconds())))
+	return token, nil
+}
+
+// refreshCookie returns the refresh cookie. It is SameSite=Strict unless
+// cookies are configured to be sent cross-site.
+func refreshCookie(authn *auth.Service, r *http.Request, value string, maxAge int) *http.Cookie {
+	c := authn.Cookie(r, auth.RefreshCookie, value, refreshCookiePath, maxAge)
+	if c.SameSite != http.SameSiteNoneMode {
+		c.SameSite = http.SameSiteStrictMode
+	}
+	return c
+}
+
+// clearSession removes the session cookies.
+func clearSession(w http.ResponseWriter, r *http.Request, authn *auth.Service) {
+	http.SetCookie(w, authn.Cookie(r, authn.CookieName(), "", "", -1))
+	http.SetCookie(w, refreshCookie(authn, r, "", -1))
+}
+
+// errSessionExpired rejects refreshes of a session older than its maximum
+// age.
+var errSessionExpired = errors.New("session expired")
+
+// refreshSession handles POST /auth/refresh: it trades the refresh cookie
+// for a new access token and a new refresh token. A refresh token that was
+// already used ends its whole session, since it may have been stolen. So
+// does the session's maximum age, after which the user signs in again and
+// their organizations and teams are checked anew.
+func refreshSession(authn *auth.Service, st store.Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(auth.RefreshCookie)
+		if err != nil || cookie.Value == "" {
+			authn.RecordEvent(r, auth.EventRefresh, "", errors.New("no refresh token"))
+			http.Error(w, "No refresh token", http.StatusUnauthorized)
+			return
+		}
+		t, ok, err := st.UseRefreshToken(r.Context(), auth.HashRefreshToken(cookie.Value), time.Now().UTC())
+		if errors.Is(err, store.ErrRefreshTokenReused) {
+			hlog.FromRequest(r).Warn().Msg("refresh token reused; session revoked")
+			authn.RecordEvent(r, auth.EventRefresh, "", err)
+			clearSession(w, r, authn)
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to check refresh token", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			authn.RecordEvent(r, auth.EventRefresh, "", errors.New("invalid refresh token"))
+			clearSession(w, r, authn)
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+
+		var user auth.GithubUser
+		if err := json.Unmarshal([]byte(t.User), &user); err != nil {
+			http.Error(w, "Failed to read refresh token", http.StatusInternalServerError)
+			return
+		}
+		if time.Since(t.CreatedAt) > authn.SessionMaxAge() {
+			authn.RecordEvent(r, auth.EventRefresh, user.Login, errSessionExpired)
+			clearSession(w, r, authn)
+			http.Error(w, "Session expired; sign in again", http.StatusUnauthorized)
+			return
+		}
+		// Tokens record when their session signed in, so revoking a user's
+		// sessions ends those that signed in before.
+		revoked, err := st.TokenRevoked(r.Context(), "", user.Login, t.CreatedAt)
+		if err != nil {
+			http.Error(w, "Failed to check refresh token", http.StatusInternalServerError)
+			return
+		}
+		if revoked {
+			authn.RecordEvent(r, auth.EventRefresh, user.Login, auth.ErrTokenRevoked)
+			clearSession(w, r, authn)
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+		token, err := issueSession(r.Context(), w, r, authn, st, &user, t.Family, t.CreatedAt)
+		authn.RecordEvent(r, auth.EventRefresh, user.Login, err)
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(auth.AuthResponse{User: user, Token: token})
+		if err != nil {
+			http.Error(w, "Failed to encode response", 500)
+		}
+	}
+}
+
+// logout handles POST /auth/logout: it revokes the request's access token
+// and the session's refresh tokens, and clears its cookies.
+func logout(authn *auth.Service, st store.Backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if claims, err := authn.ParseJWT(authn.TokenFromRequest(r)); err == nil {
+			if claims.ID != "" && claims.ExpiresAt != nil {
+				if err := st.RevokeToken(r.Context(), claims.ID, claims.ExpiresAt.Time); err != nil {
+					hlog.FromRequest(r).Error().Err(err).Msg("failed to revoke access token")
+				}
+			}
+			authn.RecordEvent(r, auth.EventLogout, claims.Login, nil)
+		}
+		if cookie, err := r.Cookie(auth.RefreshCookie); err == nil && cookie.Value != "" {
+			now := time.Now().UTC()
+			t, ok, err := st.UseRefreshToken(r.Context(), auth.HashRefreshToken(cookie.Value), now)
+			if err == nil && ok {
+				err = st.RevokeRefreshTokens(r.Context(), t.Family, now)
+			}
+			if err != nil && !errors.Is(err, store.ErrRefreshTokenReused) {
+				hlog.FromRequest(r).Error().Err(err).Msg("failed to revoke refresh tokens")
+			}
+		}
+		clearSession(w, r, authn)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// authEventRecorder records authentication events in the auth event log,
+// in the background like auditSearch.
+func authEventRecorder(st store.Backend) auth.EventRecorder {
+	return func(r *http.Request, event, login string, err error) {
+		e := models.AuthEvent{
+			Event:     event,
+			User:      login,
+			IP:        clientIP(r),
+			UserAgent: r.UserAgent(),
+			Outcome:   "success",
+			At:        time.Now(),
+		}
+		if err != nil {
+			e.Outcome, e.Detail = "failure", err.Error()
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := st.RecordAuthEvent(ctx, e); err != nil {
+				log.Printf("failed to record auth event: %v", err)
+			}
+		}()
+	}
+}
+
+// clientIP returns the address of the client of r: the first address of
+// X-Forwarded-For when behind a proxy, else the connection's.
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		ip, _, _ := strings.Cut(fwd, ",")
+		return strings.TrimSpace(ip)
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
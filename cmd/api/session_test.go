@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/seanblong/reposearch/internal/auth"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+func TestRefreshSession_MaxAge(t *testing.T) {
+	st := newTestStore(t)
+	ctx := context.Background()
+	authn := auth.NewService(auth.AuthConfig{
+		Enabled:   true,
+		JwtSecret: []byte("test-secret"),
+		Lifetimes: auth.Lifetimes{RefreshToken: 24 * time.Hour, Session: time.Hour},
+	})
+	user := &auth.GithubUser{Login: "alice"}
+
+	// refresh posts the refresh token and returns the response and its new
+	// refresh cookie, if any.
+	refresh := func(token string) (*httptest.ResponseRecorder, string) {
+		r := httptest.NewRequest(http.MethodPost, "/auth/refresh", nil)
+		r.AddCookie(&http.Cookie{Name: auth.RefreshCookie, Value: token})
+		w := httptest.NewRecorder()
+		refreshSession(authn, st)(w, r)
+		for _, c := range w.Result().Cookies() {
+			if c.Name == auth.RefreshCookie && c.Value != "" {
+				return w, c.Value
+			}
+		}
+		return w, ""
+	}
+
+	// A refresh keeps the sign-in time, and the new token expires with the
+	// session rather than a refresh token lifetime later.
+	started := time.Now().UTC().Add(-30 * time.Minute).Truncate(time.Second)
+	w := httptest.NewRecorder()
+	if _, err := issueSession(ctx, w, httptest.NewRequest(http.MethodPost, "/auth/login", nil), authn, st, user, "family", started); err != nil {
+		t.Fatalf("issueSession: %v", err)
+	}
+	var token string
+	for _, c := range w.Result().Cookies() {
+		if c.Name == auth.RefreshCookie {
+			token = c.Value
+			if c.MaxAge > int((31 * time.Minute).Seconds()) {
+				t.Errorf("expected the refresh cookie to end with the session, got max age %d", c.MaxAge)
+			}
+		}
+	}
+	rw, next := refresh(token)
+	if rw.Code != http.StatusOK || next == "" {
+		t.Fatalf("refresh: status %d, %s", rw.Code, rw.Body)
+	}
+	rt, ok, err := st.UseRefreshToken(ctx, auth.HashRefreshToken(next), time.Now().UTC())
+	if err != nil || !ok {
+		t.Fatalf("UseRefreshToken: %v, %v", ok, err)
+	}
+	if !rt.CreatedAt.Equal(started) || rt.ExpiresAt.After(started.Add(time.Hour)) {
+		t.Errorf("expected the session's sign-in time and end, got %v and %v", rt.CreatedAt, rt.ExpiresAt)
+	}
+
+	// A token of a session past its maximum age, as issued before the age
+	// was lowered, requires a new sign-in.
+	old, hash, err := auth.NewRefreshToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	err = st.CreateRefreshToken(ctx, models.RefreshToken{
+		Family: "old", User: `{"login":"alice"}`, CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(time.Hour),
+	}, hash)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	if rw, next := refresh(old); rw.Code != http.StatusUnauthorized || next != "" {
+		t.Errorf("expected an expired session to be refused, got %d", rw.Code)
+	}
+}
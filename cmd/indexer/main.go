@@ -2,34 +2,91 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/seanblong/reposearch/internal/ai"
 	"github.com/seanblong/reposearch/internal/config"
 	"github.com/seanblong/reposearch/internal/indexer"
 	"github.com/seanblong/reposearch/internal/store"
+	"github.com/seanblong/reposearch/internal/storeconfig"
 	"github.com/spf13/pflag"
 )
 
 func main() {
 	fs := pflag.NewFlagSet("reposearch-api", pflag.ExitOnError)
 
-	cfg, err := config.Load("", fs)
+	cfg, err := config.LoadIndexer("", fs)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 	fs.Usage = cfg.Usage
 
+	// Cancel the run on Ctrl-C or SIGTERM so in-flight work can wind down,
+	// the report is flushed and the temporary clone is removed.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	cfg.RenewVaultLeases(ctx, log.Printf)
+
+	if err := run(ctx, cfg); err != nil {
+		stop()
+		log.Fatal(err)
+	}
+}
+
+// run executes a single indexing run. Resources are released via defers
+// before returning, including when the run is interrupted.
+func run(ctx context.Context, cfg config.Specification) error {
+	subpath, err := indexer.CleanSubpath(cfg.RepoSubpath)
+	if err != nil {
+		return err
+	}
+	lfsMode, err := indexer.ParseLFSMode(cfg.LFSMode)
+	if err != nil {
+		return err
+	}
+	mode, err := indexer.ParseMode(cfg.Mode)
+	if err != nil {
+		return err
+	}
+	optimize, err := store.ParseOptimize(cfg.Optimize)
+	if err != nil {
+		return err
+	}
+	if err := indexer.CheckRepoConfigFields(cfg.RepoConfigFields); err != nil {
+		return err
+	}
+	if mode == indexer.ModeVacuum {
+		return runVacuum(ctx, cfg)
+	}
+	if mode == indexer.ModeOptimize {
+		if optimize == "" {
+			optimize = store.OptimizeAnalyze
+		}
+		return runOptimize(ctx, cfg, optimize)
+	}
+	if mode != indexer.ModeIndex {
+		return runMaintenance(ctx, cfg, mode)
+	}
+
 	repo := cfg.RepoRoot
 	if cfg.RepoURL != "" {
 		var err error
-		repo, err = cloneToTemp(cfg.RepoURL, cfg.GitRef, cfg.GithubToken)
+		repo, err = indexer.CloneToTemp(ctx, indexer.CloneOptions{
+			URL:     cfg.RepoURL,
+			Ref:     cfg.GitRef,
+			Token:   cfg.GithubToken,
+			Subpath: subpath,
+			Timeout: cfg.CloneTimeout,
+		})
 		if err != nil {
-			log.Fatalf("clone failed: %v", err)
+			return fmt.Errorf("clone failed: %w", err)
 		}
 		defer func() {
 			if err := os.RemoveAll(repo); err != nil {
@@ -40,49 +97,33 @@ func main() {
 		cfg.RepoURL = "local"
 	}
 
-	provider := strings.ToLower(cfg.Provider)
-	log.Printf("using provider: %s", provider)
-	var clientConfig *ai.ClientConfig
-	switch provider {
-	case "openai":
-		clientConfig = &ai.ClientConfig{
-			APIKey:       cfg.APIKey,
-			EmbedModel:   cfg.EmbedModel,
-			SummaryModel: cfg.SummaryModel,
-			Dim:          cfg.Dim,
-			ProjectID:    cfg.ProjectID,
-			Provider:     ai.ProviderOpenAI,
-		}
-	case "vertexai":
-		clientConfig = &ai.ClientConfig{
-			APIKey:       cfg.APIKey,
-			EmbedModel:   cfg.EmbedModel,
-			SummaryModel: cfg.SummaryModel,
-			Dim:          cfg.Dim,
-			ProjectID:    cfg.ProjectID,
-			Provider:     ai.ProviderVertexAI,
-		}
-	case "stub":
-		clientConfig = &ai.ClientConfig{
-			Dim:      cfg.Dim,
-			Provider: ai.ProviderStub,
-		}
-	default:
-		log.Fatalf("unsupported provider: %s", provider)
+	clientConfig, err := ai.ClientConfigFor(cfg)
+	if err != nil {
+		return err
 	}
-
-	ctx := context.Background()
+	log.Printf("using provider: %s", clientConfig.Provider)
 
 	// Initialize store
-	st, err := store.New(ctx, cfg.Database)
+	st, err := storeconfig.Open(ctx, cfg)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer st.Close()
 
 	ix, err := indexer.New(st, repo, cfg.RepoURL, clientConfig)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	ix.Subpath = subpath
+	ix.LFSMode = lfsMode
+	ix.Dedup = cfg.Dedup
+	ix.DirSummaries = cfg.DirSummaries
+	ix.SummaryOnly = !cfg.StoreContent
+	ix.WriteBatchSize = cfg.BatchSize
+	ix.MaxLFSObjectSize = int64(cfg.MaxLFSObjectSize)
+	ix.RepoConfigFields = cfg.RepoConfigFields
+	if lfsMode == indexer.LFSModeFetch && cfg.RepoURL != "local" {
+		ix.LFSFetcher = indexer.NewHTTPLFSFetcher(cfg.RepoURL, cfg.GithubToken)
 	}
 
 	// if pulling in a local directory set ref to directory name
@@ -94,35 +135,93 @@ func main() {
 	}
 
 	if ix.Client.Dim() == 0 {
-		log.Fatal("embedding dimension must be set")
+		return errors.New("embedding dimension must be set")
 	}
 
 	if err := st.Migrate(ctx, ix.Client.Dim()); err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	if err := ix.Run(ctx); err != nil {
-		log.Fatal(err)
+	runErr := ix.Run(ctx)
+	if errors.Is(runErr, context.Canceled) {
+		log.Printf("indexing interrupted, stopped after in-flight chunks completed")
+	}
+	if runErr == nil && optimize != "" {
+		start := time.Now()
+		if err := st.Optimize(ctx, optimize == store.OptimizeReindex); err != nil {
+			log.Printf("failed to optimize the store: %v", err)
+		} else {
+			log.Printf("%s optimization took %s", optimize, time.Since(start).Round(time.Millisecond))
+		}
 	}
+	if cfg.ReportPath != "" {
+		if err := ix.WriteReportFile(cfg.ReportPath); err != nil {
+			log.Printf("failed to write index report: %v", err)
+		}
+	}
+	return runErr
 }
 
-// cloneToTemp clones the given repo URL at the specified ref to a temporary directory
-func cloneToTemp(repoURL, ref, token string) (string, error) {
-	dir, err := os.MkdirTemp("", "reposearch-*")
+// runMaintenance refreshes summaries or vectors of chunks already in the store
+// without cloning or walking a repository.
+func runMaintenance(ctx context.Context, cfg config.Specification, mode indexer.Mode) error {
+	clientConfig, err := ai.ClientConfigFor(cfg)
+	if err != nil {
+		return err
+	}
+	log.Printf("using provider: %s", clientConfig.Provider)
+
+	st, err := storeconfig.Open(ctx, cfg)
 	if err != nil {
-		return "", err
+		return err
 	}
-	url := repoURL
-	if token != "" && strings.HasPrefix(url, "https://") {
-		url = "https://" + token + ":x-oauth-basic@" + strings.TrimPrefix(url, "https://")
+	defer st.Close()
+
+	m, err := indexer.NewMaintainer(st, clientConfig)
+	if err != nil {
+		return err
 	}
-	cmd := exec.Command("git", "clone", "--depth", "1", "--branch", ref, url, dir)
-	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
-	if err := cmd.Run(); err != nil {
-		if rmErr := os.RemoveAll(dir); rmErr != nil {
-			log.Printf("Failed to remove temp directory %s: %v", dir, rmErr)
-		}
-		return "", fmt.Errorf("git clone: %w", err)
+	if cfg.BatchSize > 0 {
+		m.BatchSize = cfg.BatchSize
+	}
+	if m.Client.Dim() == 0 {
+		return errors.New("embedding dimension must be set")
+	}
+	if err := st.Migrate(ctx, m.Client.Dim()); err != nil {
+		return err
+	}
+
+	n, err := m.Run(ctx, mode)
+	log.Printf("%s refreshed %d chunks", mode, n)
+	return err
+}
+
+// runVacuum permanently removes deleted repositories and refs from the store.
+func runVacuum(ctx context.Context, cfg config.Specification) error {
+	st, err := storeconfig.Open(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	n, err := st.Vacuum(ctx, "")
+	log.Printf("vacuum removed %d chunks", n)
+	return err
+}
+
+// runOptimize refreshes table statistics and, at the reindex level, rebuilds
+// the vector indexes.
+func runOptimize(ctx context.Context, cfg config.Specification, level string) error {
+	st, err := storeconfig.Open(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	start := time.Now()
+	if err := st.Optimize(ctx, level == store.OptimizeReindex); err != nil {
+		return err
 	}
-	return dir, nil
+	log.Printf("%s optimization took %s", level, time.Since(start).Round(time.Millisecond))
+	return nil
 }
@@ -0,0 +1,240 @@
+// Command reposearch holds operational commands that work on the index
+// directly, without cloning repositories. Only reembed calls the AI
+// provider.
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/seanblong/reposearch/internal/config"
+	"github.com/seanblong/reposearch/internal/store"
+	"github.com/seanblong/reposearch/internal/storeconfig"
+	"github.com/seanblong/reposearch/pkg/models"
+	"github.com/spf13/pflag"
+)
+
+const usage = `Usage: reposearch <command> [flags]
+
+Commands:
+  export   write the chunks of one or every repository, with their vectors, as JSON lines (gzipped for .gz)
+  import   load chunks written by export, gzipped or not, into the configured store
+  prune    delete the chunks of a repository, one of its refs, its dead refs or its removed files
+  reembed  re-embed every stored summary with the configured embedding model, even of another dimension
+  stats    print the chunks, files, languages, last index time and estimated size of each repository
+
+Flags:
+`
+
+func main() {
+	fs := pflag.NewFlagSet("reposearch", pflag.ExitOnError)
+	repo := fs.String("repo", "", "export: only export this repository (default all); prune: the repository to prune")
+	output := fs.StringP("output", "o", "-", "export: file to write (\"-\" for stdout), gzip-compressed if it ends in .gz")
+	input := fs.StringP("input", "i", "-", "import: file to read (\"-\" for stdin)")
+	asJSON := fs.Bool("json", false, "stats: print JSON instead of a table")
+	var prune pruneOptions
+	fs.StringVar(&prune.Ref, "ref", "", "prune: only delete this ref, or the files removed from it with --removed")
+	fs.BoolVar(&prune.Removed, "removed", false, "prune: delete the files of --ref that are no longer under --repo-root, a checkout of --repo")
+	fs.BoolVar(&prune.DeadRefs, "dead-refs", false, "prune: delete the refs no longer on the repository's remote")
+	fs.BoolVar(&prune.DryRun, "dry-run", false, "prune: list what would be deleted without deleting it")
+	fs.BoolVarP(&prune.Yes, "yes", "y", false, "prune: do not ask for confirmation")
+	fs.BoolVar(&prune.Vacuum, "vacuum", false, "prune: permanently remove the deleted chunks of --repo right away")
+	abort := fs.Bool("abort", false, "reembed: discard an unfinished migration instead of continuing it")
+
+	cfg, err := config.LoadIndexer("", fs)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		cfg.Usage()
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	cfg.RenewVaultLeases(ctx, log.Printf)
+
+	switch cmd := fs.Arg(0); cmd {
+	case "export":
+		err = runExport(ctx, cfg, *repo, *output)
+	case "import":
+		err = runImport(ctx, cfg, *input)
+	case "prune":
+		prune.Repo = *repo
+		err = runPrune(ctx, cfg, prune)
+	case "reembed":
+		err = runReembed(ctx, cfg, *abort)
+	case "stats":
+		err = runStats(ctx, cfg, *asJSON)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", cmd)
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		stop()
+		log.Fatal(err)
+	}
+}
+
+// runExport writes the chunks of repo, or of every repository, to path,
+// gzip-compressed when path ends in .gz.
+func runExport(ctx context.Context, cfg config.Specification, repo, path string) error {
+	st, err := storeconfig.Open(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if path != "-" {
+		if f, err = os.Create(path); err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		w = f
+	}
+	var zw *gzip.Writer
+	if strings.HasSuffix(path, ".gz") {
+		zw = gzip.NewWriter(w)
+		w = zw
+	}
+	n, err := store.ExportJSONL(ctx, st, repo, w)
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	}
+	if f != nil {
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	log.Printf("exported %d chunks", n)
+	return nil
+}
+
+// runImport upserts the chunks exported to path, which may be
+// gzip-compressed.
+func runImport(ctx context.Context, cfg config.Specification, path string) error {
+	st, err := storeconfig.Open(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		r = f
+	}
+	if r, err = decompress(r); err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	n, err := store.ImportJSONL(ctx, st, r)
+	log.Printf("imported %d chunks", n)
+	if err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	return nil
+}
+
+// decompress returns r, decompressed when it starts with the gzip magic
+// number, so compressed exports can be piped in as well.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		// Too short to be gzip; let the JSON decoder judge it.
+		return br, nil
+	}
+	return gzip.NewReader(br)
+}
+
+// runStats prints the index statistics of each repository, as a table or
+// as JSON.
+func runStats(ctx context.Context, cfg config.Specification, asJSON bool) error {
+	st, err := storeconfig.Open(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	stats, err := st.Stats(ctx)
+	if err != nil {
+		return fmt.Errorf("stats: %w", err)
+	}
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPOSITORY\tCHUNKS\tFILES\tLANGUAGES\tLAST INDEXED\tSIZE")
+	for _, r := range stats.Repositories {
+		last := "-"
+		if !r.LastIndexedAt.IsZero() {
+			last = r.LastIndexedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\n",
+			r.Repository, r.Chunks, r.Files, languages(r), last, humanBytes(r.StorageBytes))
+	}
+	fmt.Fprintf(tw, "TOTAL\t%d\t%d\t\t\t%s\n", stats.Chunks, stats.Files, humanBytes(stats.StorageBytes))
+	return tw.Flush()
+}
+
+// languages lists the languages of r with their chunk counts, most chunks
+// first.
+func languages(r models.RepositoryStats) string {
+	langs := slices.SortedFunc(maps.Keys(r.Languages), func(a, b string) int {
+		return cmp.Or(cmp.Compare(r.Languages[b], r.Languages[a]), strings.Compare(a, b))
+	})
+	parts := make([]string, len(langs))
+	for i, l := range langs {
+		if l == "" {
+			l = "unknown"
+		}
+		parts[i] = fmt.Sprintf("%s %d", l, r.Languages[langs[i]])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// humanBytes formats n bytes with a binary unit, e.g. "1.5 MiB".
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
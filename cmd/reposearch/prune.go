@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/seanblong/reposearch/internal/config"
+	"github.com/seanblong/reposearch/internal/indexer"
+	"github.com/seanblong/reposearch/internal/store"
+	"github.com/seanblong/reposearch/internal/storeconfig"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// pruneOptions selects what prune deletes. Without Ref, Removed or DeadRefs
+// the whole repository goes.
+type pruneOptions struct {
+	Repo     string
+	Ref      string
+	Removed  bool // files of Ref no longer under the repository root
+	DeadRefs bool // refs no longer on the remote
+	DryRun   bool
+	Yes      bool
+	Vacuum   bool
+}
+
+// pruneTarget is one deletion prune is about to make.
+type pruneTarget struct {
+	what   string
+	chunks int64
+	delete func(ctx context.Context) (int64, error)
+}
+
+// commitHash matches refs that name a commit, which a remote does not list.
+var commitHash = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// runPrune soft-deletes the chunks selected by opts, after listing them and
+// asking for confirmation. Deleted chunks can be restored until they are
+// vacuumed, which opts.Vacuum does right away for the deleted chunks of
+// opts.Repo; those of other repositories are left for the indexer's vacuum.
+func runPrune(ctx context.Context, cfg config.Specification, opts pruneOptions) error {
+	if opts.Repo == "" {
+		return errors.New("prune: --repo is required")
+	}
+	if opts.Removed && opts.Ref == "" {
+		return errors.New("prune: --removed requires --ref, the ref checked out at --repo-root")
+	}
+	st, err := storeconfig.Open(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	targets, err := pruneTargets(ctx, st, cfg, opts)
+	if err != nil {
+		return fmt.Errorf("prune: %w", err)
+	}
+	var total int64
+	for _, t := range targets {
+		fmt.Printf("%s (%d chunks)\n", t.what, t.chunks)
+		total += t.chunks
+	}
+	if len(targets) == 0 || total == 0 {
+		fmt.Println("nothing to prune")
+		return nil
+	}
+	if opts.DryRun {
+		fmt.Printf("dry run: would delete %d chunks\n", total)
+		return nil
+	}
+	if !opts.Yes && !confirm(os.Stdin, fmt.Sprintf("Delete %d chunks?", total)) {
+		return errors.New("prune: aborted")
+	}
+
+	var deleted int64
+	for _, t := range targets {
+		n, err := t.delete(ctx)
+		deleted += n
+		if err != nil {
+			return fmt.Errorf("prune: %s: %w", t.what, err)
+		}
+	}
+	log.Printf("deleted %d chunks", deleted)
+	if opts.Vacuum {
+		n, err := st.Vacuum(ctx, opts.Repo)
+		if err != nil {
+			return fmt.Errorf("vacuum: %w", err)
+		}
+		log.Printf("vacuumed %d chunks", n)
+	}
+	return nil
+}
+
+// pruneTargets lists the deletions opts selects, with the number of chunks
+// each would delete.
+func pruneTargets(ctx context.Context, st store.Backend, cfg config.Specification, opts pruneOptions) ([]pruneTarget, error) {
+	if !opts.Removed && !opts.DeadRefs {
+		if opts.Ref != "" {
+			n, err := refChunks(ctx, st, opts.Repo, opts.Ref)
+			if err != nil {
+				return nil, err
+			}
+			return []pruneTarget{{
+				what:   fmt.Sprintf("ref %s of %s", opts.Ref, opts.Repo),
+				chunks: n,
+				delete: func(ctx context.Context) (int64, error) { return st.DeleteRef(ctx, opts.Repo, opts.Ref) },
+			}}, nil
+		}
+		stats, err := st.Stats(ctx)
+		if err != nil {
+			return nil, err
+		}
+		i := slices.IndexFunc(stats.Repositories, func(r models.RepositoryStats) bool { return r.Repository == opts.Repo })
+		if i < 0 {
+			return nil, nil
+		}
+		return []pruneTarget{{
+			what:   "repository " + opts.Repo,
+			chunks: stats.Repositories[i].Chunks,
+			delete: func(ctx context.Context) (int64, error) { return st.DeleteRepository(ctx, opts.Repo) },
+		}}, nil
+	}
+
+	var targets []pruneTarget
+	if opts.DeadRefs {
+		dead, err := deadRefs(ctx, st, opts.Repo, cfg.GithubToken)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range dead {
+			n, err := refChunks(ctx, st, opts.Repo, ref)
+			if err != nil {
+				return nil, err
+			}
+			targets = append(targets, pruneTarget{
+				what:   fmt.Sprintf("dead ref %s of %s", ref, opts.Repo),
+				chunks: n,
+				delete: func(ctx context.Context) (int64, error) { return st.DeleteRef(ctx, opts.Repo, ref) },
+			})
+		}
+	}
+	if opts.Removed {
+		if err := checkCheckout(cfg.RepoRoot, opts.Repo); err != nil {
+			return nil, err
+		}
+		paths, err := st.GetPaths(ctx, opts.Repo, opts.Ref)
+		if err != nil {
+			return nil, err
+		}
+		var removed []string
+		var n int64
+		for p, chunks := range paths {
+			if _, err := os.Stat(filepath.Join(cfg.RepoRoot, filepath.FromSlash(p))); errors.Is(err, fs.ErrNotExist) {
+				removed = append(removed, p)
+				n += chunks
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		if len(paths) > 0 && len(removed) == len(paths) {
+			return nil, fmt.Errorf("none of the %d indexed files of ref %s exist under %s; is it a checkout of %s?", len(paths), opts.Ref, cfg.RepoRoot, opts.Repo)
+		}
+		slices.Sort(removed)
+		for _, p := range removed {
+			fmt.Printf("  removed: %s\n", p)
+		}
+		if len(removed) > 0 {
+			targets = append(targets, pruneTarget{
+				what:   fmt.Sprintf("%d removed files of ref %s of %s", len(removed), opts.Ref, opts.Repo),
+				chunks: n,
+				delete: func(ctx context.Context) (int64, error) { return st.DeletePaths(ctx, opts.Repo, opts.Ref, removed) },
+			})
+		}
+	}
+	return targets, nil
+}
+
+// checkCheckout returns an error unless root is a git checkout with a remote
+// of repo, so that files missing from another directory are not taken for
+// removed ones. Repositories indexed from a local directory ("local") have
+// no remote to compare and are not checked.
+func checkCheckout(root, repo string) error {
+	if repo == "local" {
+		return nil
+	}
+	r, err := git.PlainOpenWithOptions(root, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return fmt.Errorf("--repo-root %s is not a git checkout of %s: %w", root, repo, err)
+	}
+	remotes, err := r.Remotes()
+	if err != nil {
+		return fmt.Errorf("--repo-root %s: %w", root, err)
+	}
+	want := repositoryKey(repo)
+	for _, remote := range remotes {
+		if slices.ContainsFunc(remote.Config().URLs, func(u string) bool { return repositoryKey(u) == want }) {
+			return nil
+		}
+	}
+	return fmt.Errorf("--repo-root %s is not a checkout of %s: no remote has its URL", root, repo)
+}
+
+// repositoryKey reduces a clone URL to its host and path, without
+// credentials or ".git" and in lower case, so that its HTTPS and SSH forms
+// compare equal.
+func repositoryKey(repo string) string {
+	host, path := "", repo
+	if u, err := url.Parse(repo); err == nil && u.Scheme != "" && u.Host != "" {
+		host, path = u.Hostname(), u.Path
+	} else if before, after, ok := strings.Cut(repo, ":"); ok && !strings.Contains(before, "/") && len(before) > 1 {
+		// scp-like syntax, user@host:path; "C:" is a drive instead.
+		_, host, _ = strings.Cut(before, "@")
+		if host == "" {
+			host = before
+		}
+		path = after
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	return strings.ToLower(host + "/" + path)
+}
+
+// deadRefs returns the indexed refs of repo that its remote no longer has.
+// Refs that name a commit are kept, as they cannot be checked.
+func deadRefs(ctx context.Context, st store.Backend, repo, token string) ([]string, error) {
+	indexed, err := st.GetRefs(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	live, err := indexer.RemoteRefs(ctx, repo, token)
+	if err != nil {
+		return nil, err
+	}
+	var dead []string
+	for _, ref := range indexed {
+		if !slices.Contains(live, ref) && !commitHash.MatchString(ref) {
+			dead = append(dead, ref)
+		}
+	}
+	return dead, nil
+}
+
+// refChunks returns the number of chunks indexed for a ref.
+func refChunks(ctx context.Context, st store.Backend, repo, ref string) (int64, error) {
+	paths, err := st.GetPaths(ctx, repo, ref)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, c := range paths {
+		n += c
+	}
+	return n, nil
+}
+
+// confirm asks question on stderr and reports whether the answer read from
+// r is yes.
+func confirm(r io.Reader, question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(r).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/seanblong/reposearch/internal/ai"
+	"github.com/seanblong/reposearch/internal/config"
+	"github.com/seanblong/reposearch/internal/indexer"
+	"github.com/seanblong/reposearch/internal/storeconfig"
+)
+
+// runReembed moves every stored vector to the configured embedding model,
+// which may produce a different dimension than the one the index was built
+// with. Summaries are re-embedded as they are; nothing is cloned or
+// summarized again. With abort, an unfinished migration is discarded
+// instead.
+func runReembed(ctx context.Context, cfg config.Specification, abort bool) error {
+	st, err := storeconfig.Open(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	if abort {
+		if err := st.AbortVectorMigration(ctx); err != nil {
+			return fmt.Errorf("reembed: %w", err)
+		}
+		log.Printf("vector migration aborted")
+		return nil
+	}
+
+	clientConfig, err := ai.ClientConfigFor(cfg)
+	if err != nil {
+		return err
+	}
+	log.Printf("using provider: %s", clientConfig.Provider)
+	m, err := indexer.NewMaintainer(st, clientConfig)
+	if err != nil {
+		return err
+	}
+	if cfg.BatchSize > 0 {
+		m.BatchSize = cfg.BatchSize
+	}
+	dim := m.Client.Dim()
+	if dim == 0 {
+		return errors.New("reembed: embedding dimension must be set")
+	}
+	// The store is not migrated first: its vectors have the old dimension,
+	// which Migrate rejects.
+	n, err := m.MigrateVectors(ctx, dim)
+	log.Printf("re-embedded %d summaries with %s (%d dimensions)", n, m.EmbedModel, dim)
+	if err != nil {
+		return fmt.Errorf("reembed: %w", err)
+	}
+	log.Printf("vectors swapped; search with the same embedding model and dimension from now on")
+	return nil
+}
@@ -25,6 +25,7 @@ interface SimpleResult {
   summary?: string;
   ref?: string; // The ref/branch this result came from
   repository?: string; // The repository this result came from
+  permalink?: string; // Link to the lines on the repository's host, when known
 }
 
 // Minimal CSS (no Tailwind required).
@@ -86,6 +87,7 @@ export default function App() {
 
   // Authentication state
   const [authEnabled, setAuthEnabled] = useState<boolean | null>(null); // null = checking
+  const [authProviders, setAuthProviders] = useState<string[]>(["github"]);
   const [user, setUser] = useState<GitHubUser | null>(null);
   const [authLoading, setAuthLoading] = useState(true);
   const [token, setToken] = useState<string | null>(null);
@@ -227,8 +229,8 @@ export default function App() {
   }
 
   // Authentication functions
-  const login = () => {
-    window.location.href = `${API_BASE}/auth/github`;
+  const login = (provider: string) => {
+    window.location.href = `${API_BASE}/auth/${provider}`;
   };
 
   const logout = async () => {
@@ -253,6 +255,9 @@ export default function App() {
         const statusResponse = await fetch(`${API_BASE}/auth/status`);
         const statusData = await statusResponse.json();
         setAuthEnabled(statusData.enabled);
+        if (statusData.providers?.length) {
+          setAuthProviders(statusData.providers);
+        }
 
         if (!statusData.enabled) {
           // Auth is disabled, skip user auth check
@@ -266,10 +271,17 @@ export default function App() {
           setToken(storedToken);
         }
 
-        const response = await fetch(`${API_BASE}/auth/me`, {
+        let response = await fetch(`${API_BASE}/auth/me`, {
           credentials: 'include',
           headers: storedToken ? { 'Authorization': `Bearer ${storedToken}` } : {}
         });
+        if (response.status === 401) {
+          // The access token expired; renew it with the refresh cookie.
+          response = await fetch(`${API_BASE}/auth/refresh`, {
+            method: 'POST',
+            credentials: 'include'
+          });
+        }
 
         if (response.ok) {
           const userData = await response.json();
@@ -295,6 +307,31 @@ export default function App() {
     checkAuthStatus();
   }, []);
 
+  // Renew the access token before it expires while signed in
+  React.useEffect(() => {
+    if (!user) return;
+    const id = window.setInterval(async () => {
+      try {
+        const response = await fetch(`${API_BASE}/auth/refresh`, {
+          method: 'POST',
+          credentials: 'include'
+        });
+        if (response.ok) {
+          const data = await response.json();
+          localStorage.setItem('auth_token', data.token);
+          setToken(data.token);
+        } else if (response.status === 401) {
+          localStorage.removeItem('auth_token');
+          setToken(null);
+          setUser(null);
+        }
+      } catch (e) {
+        console.warn("Session refresh failed:", e);
+      }
+    }, 10 * 60 * 1000);
+    return () => window.clearInterval(id);
+  }, [user]);
+
   // Handle OAuth callback
   React.useEffect(() => {
     const urlParams = new URLSearchParams(window.location.search);
@@ -304,7 +341,10 @@ export default function App() {
     if (code && authEnabled) {
       async function handleCallback() {
         try {
-          const response = await fetch(`${API_BASE}/auth/callback?code=${code}&state=${state || ''}`, {
+          // GitLab and Google redirect back to /auth/{provider}/callback, GitHub to /auth/callback.
+          const provider = window.location.pathname.match(/\/auth\/(gitlab|google)\/callback$/)?.[1];
+          const callback = provider ? `${provider}/callback` : 'callback';
+          const response = await fetch(`${API_BASE}/auth/${callback}?code=${code}&state=${state || ''}`, {
             credentials: 'include'
           });
 
@@ -509,7 +549,8 @@ export default function App() {
               preview: item.chunk.content,
               summary: item.chunk.summary,
               ref: item.chunk.ref,
-              repository: item.chunk.repository
+              repository: item.chunk.repository,
+              permalink: item.permalink
             } as SimpleResult;
           }
           // Fallback to direct properties (old format)
@@ -547,10 +588,12 @@ export default function App() {
           <p style={{ color: "#aaa", marginBottom: 32 }}>
             Natural language search of your codebase
           </p>
-          <button className="login-btn" onClick={login}>
-            <LogIn width={20} height={20} />
-            Sign in with GitHub
-          </button>
+          {authProviders.map(provider => (
+            <button key={provider} className="login-btn" style={{ margin: 4 }} onClick={() => login(provider)}>
+              <LogIn width={20} height={20} />
+              Sign in with {({ gitlab: "GitLab", google: "Google", saml: "SSO" } as Record<string, string>)[provider] ?? "GitHub"}
+            </button>
+          ))}
           {error && (
             <div className="err" style={{ marginTop: 16 }}>
               {error}
@@ -809,7 +852,7 @@ export default function App() {
                         <a
                           href={(() => {
                             try {
-                              return toGitHubUrl(r.path || '', r.line_start, r.line_end, r.repository || undefined, r.ref || undefined);
+                              return r.permalink || toGitHubUrl(r.path || '', r.line_start, r.line_end, r.repository || undefined, r.ref || undefined);
                             } catch (e) {
                               console.error('Error generating GitHub URL:', e, 'for result:', r);
                               return '#';
@@ -843,7 +886,7 @@ export default function App() {
                       </button>
                     ) : (
                       <a
-                        href={toGitHubUrl(r.path, r.line_start, r.line_end, r.repository || undefined, r.ref || undefined)}
+                        href={r.permalink || toGitHubUrl(r.path, r.line_start, r.line_end, r.repository || undefined, r.ref || undefined)}
                         target="_blank"
                         rel="noreferrer"
                         title="Open on GitHub"
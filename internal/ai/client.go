@@ -3,7 +3,11 @@ package ai
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strings"
+	"time"
+
+	"github.com/seanblong/reposearch/internal/config"
 )
 
 // Client provides both embedding and summarization capabilities
@@ -13,6 +17,48 @@ type Client interface {
 	Dim() int
 }
 
+// BatchEmbedder is implemented by clients that can embed several texts in
+// one provider call. The vectors are returned in the order of texts.
+type BatchEmbedder interface {
+	EmbedBatch(texts []string) ([][]float32, error)
+}
+
+// EmbedAll embeds texts in one call when c is a BatchEmbedder, and with one
+// call per text otherwise.
+func EmbedAll(c Client, texts []string) ([][]float32, error) {
+	if b, ok := c.(BatchEmbedder); ok {
+		return b.EmbedBatch(texts)
+	}
+	out := make([][]float32, len(texts))
+	for i, t := range texts {
+		v, err := c.Embed(t)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+// Generator is implemented by clients that can answer a free-form prompt
+// with the summary model, e.g. to synthesize an answer from search results.
+type Generator interface {
+	Generate(ctx context.Context, system, prompt string, maxTokens int) (string, error)
+}
+
+// StreamGenerator is implemented by clients that can stream generated text.
+// GenerateStream calls fn with each piece of text as it arrives and returns
+// the whole text.
+type StreamGenerator interface {
+	GenerateStream(ctx context.Context, system, prompt string, maxTokens int, fn func(delta string) error) (string, error)
+}
+
+// UsageReporter is implemented by clients that can report how many provider
+// tokens they have consumed since they were created.
+type UsageReporter interface {
+	TokensUsed() int64
+}
+
 // Provider is enumeration of supported AI providers
 type Provider string
 
@@ -31,6 +77,44 @@ type ClientConfig struct {
 	ProjectID    string
 	Provider     Provider
 	Location     string
+	Timeout      time.Duration // of each provider request; 0 for the provider default
+}
+
+// ClientConfigFor builds the client configuration for the provider of cfg,
+// so every binary reads the provider settings the same way. "google" is
+// accepted as another name for vertexai.
+func ClientConfigFor(cfg config.Specification) (*ClientConfig, error) {
+	var c *ClientConfig
+	switch strings.ToLower(cfg.Provider) {
+	case "openai":
+		c = &ClientConfig{
+			APIKey:       cfg.APIKey,
+			EmbedModel:   cfg.EmbedModel,
+			SummaryModel: cfg.SummaryModel,
+			Dim:          cfg.Dim,
+			ProjectID:    cfg.ProjectID,
+			Provider:     ProviderOpenAI,
+		}
+	case "vertexai", "google":
+		c = &ClientConfig{
+			APIKey:       cfg.APIKey,
+			EmbedModel:   cfg.EmbedModel,
+			SummaryModel: cfg.SummaryModel,
+			Dim:          cfg.Dim,
+			ProjectID:    cfg.ProjectID,
+			Location:     cfg.Location,
+			Provider:     ProviderVertexAI,
+		}
+	case "stub":
+		c = &ClientConfig{
+			Dim:      cfg.Dim,
+			Provider: ProviderStub,
+		}
+	default:
+		return nil, fmt.Errorf("unsupported provider: %s", cfg.Provider)
+	}
+	c.Timeout = cfg.ProviderTimeout
+	return c, nil
 }
 
 // NewClient creates a new AI client based on configuration
@@ -82,6 +166,41 @@ func (s *StubClient) Summarize(ctx context.Context, filePath, language, content
 	return "Code file: " + filePath, nil
 }
 
+// Generate implements Generator by citing every numbered source, in the
+// "[n]" form, that appears in the prompt.
+func (s *StubClient) Generate(ctx context.Context, system, prompt string, maxTokens int) (string, error) {
+	var cites []string
+	for _, line := range strings.Split(prompt, "\n") {
+		if strings.HasPrefix(line, "[") {
+			if n, _, ok := strings.Cut(line[1:], "]"); ok {
+				cites = append(cites, "["+n+"]")
+			}
+		}
+	}
+	if len(cites) == 0 {
+		return "I could not find an answer in the indexed code.", nil
+	}
+	return "The answer is in the cited sources " + strings.Join(cites, "") + ".", nil
+}
+
+// GenerateStream implements StreamGenerator by sending the text of Generate
+// one word at a time.
+func (s *StubClient) GenerateStream(ctx context.Context, system, prompt string, maxTokens int, fn func(delta string) error) (string, error) {
+	text, err := s.Generate(ctx, system, prompt, maxTokens)
+	if err != nil {
+		return "", err
+	}
+	for i, w := range strings.SplitAfter(text, " ") {
+		if i > 0 && w == "" {
+			continue
+		}
+		if err := fn(w); err != nil {
+			return "", err
+		}
+	}
+	return text, nil
+}
+
 // Dim returns the embedding dimension
 func (s *StubClient) Dim() int {
 	return s.dim
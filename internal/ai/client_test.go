@@ -4,6 +4,9 @@ import (
 	"context"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/seanblong/reposearch/internal/config"
 )
 
 // Test Provider constants
@@ -512,3 +515,47 @@ func TestStubClientConcurrency(t *testing.T) {
 		}
 	})
 }
+
+func TestStubClient_Generate(t *testing.T) {
+	client := NewStubClient(8)
+	got, err := client.Generate(context.Background(), "", "Sources:\n[1] a.go\ncode\n[2] b.go\n\nQuestion: why?", 100)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if got != "The answer is in the cited sources [1][2]." {
+		t.Errorf("unexpected answer %q", got)
+	}
+	var _ Generator = client
+}
+
+func TestStubClient_GenerateStream(t *testing.T) {
+	client := NewStubClient(8)
+	var deltas []string
+	got, err := client.GenerateStream(context.Background(), "", "[1] a.go", 100, func(d string) error {
+		deltas = append(deltas, d)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("GenerateStream: %v", err)
+	}
+	if strings.Join(deltas, "") != got || len(deltas) < 2 {
+		t.Errorf("deltas %q do not add up to %q", deltas, got)
+	}
+	var _ StreamGenerator = client
+}
+
+func TestClientConfigFor(t *testing.T) {
+	cfg := config.Specification{Provider: "Google", APIKey: "key", EmbedModel: "embed", Dim: 768, ProjectID: "p", Location: "us-central1", ProviderTimeout: time.Minute}
+	c, err := ClientConfigFor(cfg)
+	if err != nil || c.Provider != ProviderVertexAI || c.Location != "us-central1" || c.APIKey != "key" || c.Dim != 768 || c.Timeout != time.Minute {
+		t.Errorf("ClientConfigFor(google) = %+v, %v", c, err)
+	}
+	cfg.Provider = "stub"
+	if c, err := ClientConfigFor(cfg); err != nil || c.Provider != ProviderStub || c.APIKey != "" || c.Timeout != time.Minute {
+		t.Errorf("ClientConfigFor(stub) = %+v, %v", c, err)
+	}
+	cfg.Provider = "other"
+	if _, err := ClientConfigFor(cfg); err == nil {
+		t.Error("expected an error for an unsupported provider")
+	}
+}
@@ -1,22 +1,30 @@
 package ai
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
+// defaultOpenAITimeout bounds OpenAI requests when ClientConfig.Timeout is
+// not set.
+const defaultOpenAITimeout = 20 * time.Second
+
 type OpenAIClient struct {
 	config *ClientConfig
 	http   *http.Client
+	tokens atomic.Int64
 }
 
 func NewOpenAIClient(config *ClientConfig) *OpenAIClient {
@@ -52,8 +60,12 @@ func NewOpenAIClient(config *ClientConfig) *OpenAIClient {
 		}
 	}
 
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultOpenAITimeout
+	}
 	httpClient := &http.Client{
-		Timeout:   20 * time.Second,
+		Timeout:   timeout,
 		Transport: transport,
 	}
 
@@ -65,12 +77,29 @@ func NewOpenAIClient(config *ClientConfig) *OpenAIClient {
 
 // Embed implements the embedding functionality
 func (c *OpenAIClient) Embed(text string) ([]float32, error) {
+	vecs, err := c.embed(text, 1)
+	if err != nil {
+		return nil, err
+	}
+	return vecs[0], nil
+}
+
+// EmbedBatch implements BatchEmbedder with a single embeddings request.
+func (c *OpenAIClient) EmbedBatch(texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return [][]float32{}, nil
+	}
+	return c.embed(texts, len(texts))
+}
+
+// embed requests the embeddings of input, a string or a slice of n strings.
+func (c *OpenAIClient) embed(input any, n int) ([][]float32, error) {
 	if c.config.APIKey == "" {
 		return nil, errors.New("PROVIDER_API_KEY unset")
 	}
 
-	payload := map[string]string{
-		"input": text,
+	payload := map[string]any{
+		"input": input,
 		"model": c.config.EmbedModel,
 	}
 
@@ -96,24 +125,33 @@ func (c *OpenAIClient) Embed(text string) ([]float32, error) {
 
 	var out struct {
 		Data []struct {
+			Index     int       `json:"index"`
 			Embedding []float32 `json:"embedding"`
 		} `json:"data"`
+		Usage openAIUsage `json:"usage"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
 		return nil, err
 	}
+	c.tokens.Add(out.Usage.TotalTokens)
 	if len(out.Data) == 0 {
 		return nil, errors.New("no embedding")
 	}
-	return out.Data[0].Embedding, nil
+	if len(out.Data) != n {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", n, len(out.Data))
+	}
+	vecs := make([][]float32, n)
+	for _, d := range out.Data {
+		if d.Index < 0 || d.Index >= n || vecs[d.Index] != nil {
+			return nil, fmt.Errorf("unexpected embedding index %d", d.Index)
+		}
+		vecs[d.Index] = d.Embedding
+	}
+	return vecs, nil
 }
 
 // Summarize implements the summarization functionality
 func (c *OpenAIClient) Summarize(ctx context.Context, filePath, language, content string) (string, error) {
-	if c.config.APIKey == "" {
-		return "", errors.New("PROVIDER_API_KEY unset")
-	}
-
 	// Keep request small; the model only needs a taste
 	const maxInput = 8000
 	if len(content) > maxInput {
@@ -123,6 +161,107 @@ func (c *OpenAIClient) Summarize(ctx context.Context, filePath, language, conten
 	sys := "You are a concise code summarizer. Write at most 240 characters, 1–2 sentences, no code blocks, no backticks. Mention the file's purpose and notable actions. Prefer verbs. If the text is configuration, say what it configures."
 	user := "Path: " + filePath + "\nLanguage: " + language + "\n---\n" + content
 
+	s, err := c.chat(ctx, sys, user, 120)
+	if err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(s, "\n", " "), nil
+}
+
+// Generate implements Generator with the summary model.
+func (c *OpenAIClient) Generate(ctx context.Context, system, prompt string, maxTokens int) (string, error) {
+	return c.chat(ctx, system, prompt, maxTokens)
+}
+
+// GenerateStream implements StreamGenerator using a streamed chat
+// completion.
+func (c *OpenAIClient) GenerateStream(ctx context.Context, system, prompt string, maxTokens int, fn func(delta string) error) (string, error) {
+	if c.config.APIKey == "" {
+		return "", errors.New("PROVIDER_API_KEY unset")
+	}
+	payload := map[string]any{
+		"model": c.config.SummaryModel,
+		"messages": []map[string]string{
+			{"role": "system", "content": system},
+			{"role": "user", "content": prompt},
+		},
+		"temperature":    0.2,
+		"max_tokens":     maxTokens,
+		"stream":         true,
+		"stream_options": map[string]bool{"include_usage": true},
+	}
+	var buf bytes.Buffer
+	_ = json.NewEncoder(&buf).Encode(payload)
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/chat/completions", &buf)
+	if err != nil {
+		return "", err
+	}
+	c.setHeaders(req)
+
+	// The client timeout bounds the whole body, which is too short for a
+	// long answer; the context bounds the stream instead.
+	hc := *c.http
+	hc.Timeout = 0
+	resp, err := hc.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var e struct{ Error struct{ Message string } }
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		if e.Error.Message != "" {
+			return "", errors.New(e.Error.Message)
+		}
+		return "", errors.New(resp.Status)
+	}
+
+	var text strings.Builder
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			break
+		}
+		var ev struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+			Usage *openAIUsage `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return "", err
+		}
+		if ev.Usage != nil {
+			c.tokens.Add(ev.Usage.TotalTokens)
+		}
+		if len(ev.Choices) == 0 || ev.Choices[0].Delta.Content == "" {
+			continue
+		}
+		text.WriteString(ev.Choices[0].Delta.Content)
+		if err := fn(ev.Choices[0].Delta.Content); err != nil {
+			return "", err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(text.String()), nil
+}
+
+// chat sends a system and user message to the chat completions endpoint and
+// returns the trimmed reply.
+func (c *OpenAIClient) chat(ctx context.Context, sys, user string, maxTokens int) (string, error) {
+	if c.config.APIKey == "" {
+		return "", errors.New("PROVIDER_API_KEY unset")
+	}
+
 	payload := map[string]any{
 		"model": c.config.SummaryModel,
 		"messages": []map[string]string{
@@ -130,7 +269,7 @@ func (c *OpenAIClient) Summarize(ctx context.Context, filePath, language, conten
 			{"role": "user", "content": user},
 		},
 		"temperature": 0.2,
-		"max_tokens":  120,
+		"max_tokens":  maxTokens,
 	}
 
 	var buf bytes.Buffer
@@ -169,23 +308,33 @@ func (c *OpenAIClient) Summarize(ctx context.Context, filePath, language, conten
 				Content string `json:"content"`
 			} `json:"message"`
 		} `json:"choices"`
+		Usage openAIUsage `json:"usage"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
 		return "", err
 	}
+	c.tokens.Add(out.Usage.TotalTokens)
 	if len(out.Choices) == 0 {
 		return "", errors.New("no choices")
 	}
 
-	s := strings.TrimSpace(out.Choices[0].Message.Content)
-	s = strings.ReplaceAll(s, "\n", " ")
-	return s, nil
+	return strings.TrimSpace(out.Choices[0].Message.Content), nil
 }
 
 func (c *OpenAIClient) Dim() int {
 	return c.config.Dim
 }
 
+// openAIUsage is the token accounting block returned by OpenAI endpoints.
+type openAIUsage struct {
+	TotalTokens int64 `json:"total_tokens"`
+}
+
+// TokensUsed returns the total tokens reported by OpenAI across all calls.
+func (c *OpenAIClient) TokensUsed() int64 {
+	return c.tokens.Load()
+}
+
 // setHeaders sets common headers for OpenAI requests
 func (c *OpenAIClient) setHeaders(req *http.Request) {
 	req.Header.Set("Content-Type", "application/json")
@@ -342,6 +342,41 @@ func TestOpenAIClient_Embed(t *testing.T) {
 	}
 }
 
+// Test OpenAIClient.EmbedBatch method
+func TestOpenAIClient_EmbedBatch(t *testing.T) {
+	transport := NewMockTransport()
+	// Embeddings may come back out of order; index places them.
+	transport.AddResponse("POST", "https://api.openai.com/v1/embeddings", 200,
+		`{"data": [{"index": 1, "embedding": [0.2]}, {"index": 0, "embedding": [0.1]}], "usage": {"total_tokens": 4}}`)
+	client := createMockClient(transport)
+
+	vecs, err := client.EmbedBatch([]string{"a", "b"})
+	if err != nil {
+		t.Fatalf("EmbedBatch: %v", err)
+	}
+	if len(vecs) != 2 || vecs[0][0] != 0.1 || vecs[1][0] != 0.2 {
+		t.Errorf("unexpected embeddings %v", vecs)
+	}
+	requests := transport.GetRequests()
+	if len(requests) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(requests))
+	}
+	var body struct {
+		Input []string `json:"input"`
+	}
+	if err := json.NewDecoder(requests[0].Body).Decode(&body); err != nil || len(body.Input) != 2 {
+		t.Errorf("unexpected request input %v: %v", body.Input, err)
+	}
+	if client.TokensUsed() != 4 {
+		t.Errorf("TokensUsed = %d", client.TokensUsed())
+	}
+
+	// A response missing embeddings is an error.
+	if _, err := client.EmbedBatch([]string{"a", "b", "c"}); err == nil || !strings.Contains(err.Error(), "expected 3 embeddings") {
+		t.Errorf("expected a count mismatch error, got %v", err)
+	}
+}
+
 // Test OpenAIClient.Summarize method
 func TestOpenAIClient_Summarize(t *testing.T) {
 	tests := []struct {
@@ -944,3 +979,97 @@ func TestOpenAIClient_EdgeCases(t *testing.T) {
 		}
 	})
 }
+
+func TestOpenAIClient_TokensUsed(t *testing.T) {
+	transport := NewMockTransport()
+	client := createMockClient(transport)
+
+	transport.AddResponse("POST", "https://api.openai.com/v1/embeddings", 200,
+		`{"data": [{"embedding": [0.1, 0.2]}], "usage": {"prompt_tokens": 7, "total_tokens": 7}}`)
+	transport.AddResponse("POST", "https://api.openai.com/v1/chat/completions", 200,
+		`{"choices": [{"message": {"content": "A summary"}}], "usage": {"prompt_tokens": 40, "completion_tokens": 12, "total_tokens": 52}}`)
+
+	if _, err := client.Embed("hello"); err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if _, err := client.Summarize(context.Background(), "main.go", "go", "package main"); err != nil {
+		t.Fatalf("Summarize failed: %v", err)
+	}
+
+	if got := client.TokensUsed(); got != 59 {
+		t.Errorf("Expected 59 tokens used, got %d", got)
+	}
+
+	var _ UsageReporter = client
+}
+
+func TestOpenAIClient_Generate(t *testing.T) {
+	transport := NewMockTransport()
+	client := createMockClient(transport)
+	transport.AddResponse("POST", "https://api.openai.com/v1/chat/completions", 200,
+		`{"choices": [{"message": {"content": "  First line.\nSecond line [1].  "}}]}`)
+
+	got, err := client.Generate(context.Background(), "system", "question", 500)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	// Unlike summaries, generated text keeps its line breaks.
+	if got != "First line.\nSecond line [1]." {
+		t.Errorf("unexpected answer %q", got)
+	}
+
+	reqs := transport.GetRequests()
+	var body struct {
+		MaxTokens int `json:"max_tokens"`
+		Messages  []struct {
+			Role, Content string
+		} `json:"messages"`
+	}
+	if err := json.NewDecoder(reqs[len(reqs)-1].Body).Decode(&body); err != nil {
+		t.Fatalf("decoding request: %v", err)
+	}
+	if body.MaxTokens != 500 || len(body.Messages) != 2 || body.Messages[0].Content != "system" || body.Messages[1].Content != "question" {
+		t.Errorf("unexpected request %+v", body)
+	}
+
+	var _ Generator = client
+}
+
+func TestOpenAIClient_GenerateStream(t *testing.T) {
+	transport := NewMockTransport()
+	client := createMockClient(transport)
+	transport.AddResponse("POST", "https://api.openai.com/v1/chat/completions", 200, strings.Join([]string{
+		`data: {"choices":[{"delta":{"role":"assistant"}}]}`,
+		``,
+		`data: {"choices":[{"delta":{"content":"Hello"}}]}`,
+		``,
+		`data: {"choices":[{"delta":{"content":" world [1]."}}]}`,
+		``,
+		`data: {"choices":[],"usage":{"total_tokens":30}}`,
+		``,
+		`data: [DONE]`,
+		``,
+	}, "\n"))
+
+	var deltas []string
+	got, err := client.GenerateStream(context.Background(), "system", "prompt", 100, func(d string) error {
+		deltas = append(deltas, d)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("GenerateStream: %v", err)
+	}
+	if got != "Hello world [1]." || strings.Join(deltas, "|") != "Hello| world [1]." {
+		t.Errorf("got %q from deltas %q", got, deltas)
+	}
+	if client.TokensUsed() != 30 {
+		t.Errorf("TokensUsed = %d, want 30", client.TokensUsed())
+	}
+
+	transport.AddResponse("POST", "https://api.openai.com/v1/chat/completions", 429, `{"error": {"message": "Rate limited"}}`)
+	if _, err := client.GenerateStream(context.Background(), "s", "p", 100, func(string) error { return nil }); err == nil || err.Error() != "Rate limited" {
+		t.Errorf("expected the API error, got %v", err)
+	}
+
+	var _ StreamGenerator = client
+}
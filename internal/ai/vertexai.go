@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync/atomic"
 
 	"google.golang.org/genai"
 )
@@ -12,6 +13,7 @@ import (
 type VertexAIClient struct {
 	config *ClientConfig
 	client *genai.Client
+	tokens atomic.Int64
 }
 
 // NewVertexAIClient creates a new client for the Google Gemini API.
@@ -49,6 +51,10 @@ func NewVertexAIClient(ctx context.Context, config *ClientConfig) (*VertexAIClie
 	if strings.TrimSpace(config.Location) != "" {
 		cc.Location = config.Location
 	}
+	if config.Timeout > 0 {
+		timeout := config.Timeout
+		cc.HTTPOptions.Timeout = &timeout
+	}
 
 	client, err = genai.NewClient(ctx, &cc)
 	if err != nil {
@@ -86,6 +92,33 @@ func (c *VertexAIClient) Embed(text string) ([]float32, error) {
 	return res.Embeddings[0].Values, nil
 }
 
+// EmbedBatch implements BatchEmbedder with a single EmbedContent call.
+func (c *VertexAIClient) EmbedBatch(texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return [][]float32{}, nil
+	}
+	contents := make([]*genai.Content, len(texts))
+	for i, t := range texts {
+		contents[i] = genai.NewContentFromText(t, genai.RoleUser)
+	}
+	cfg := genai.EmbedContentConfig{
+		TaskType: "RETRIEVAL_DOCUMENT",
+	}
+
+	res, err := c.client.Models.EmbedContent(context.Background(), c.config.EmbedModel, contents, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("embedding failed: %w", err)
+	}
+	if res == nil || len(res.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings", len(texts))
+	}
+	out := make([][]float32, len(texts))
+	for i, e := range res.Embeddings {
+		out[i] = e.Values
+	}
+	return out, nil
+}
+
 // Summarize implements the summarization functionality using the Gemini API
 func (c *VertexAIClient) Summarize(ctx context.Context, filePath, language, content string) (string, error) {
 	// Keep request small; the model only needs a taste
@@ -94,33 +127,91 @@ func (c *VertexAIClient) Summarize(ctx context.Context, filePath, language, cont
 		content = content[:maxInput]
 	}
 
-	prompt := genai.Text("You are a concise code summarizer. Write at most 240 characters, 1–2 sentences, no code blocks, no backticks. Mention the file's purpose and notable actions. Prefer verbs. If the text is configuration, say what it configures.")
+	sys := "You are a concise code summarizer. Write at most 240 characters, 1–2 sentences, no code blocks, no backticks. Mention the file's purpose and notable actions. Prefer verbs. If the text is configuration, say what it configures."
+	userPrompt := "Path: " + filePath + "\nLanguage: " + language + "\n---\n" + content
+	summary, err := c.generate(ctx, sys, userPrompt, 120)
+	if err != nil {
+		return "", fmt.Errorf("summarization failed: %w", err)
+	}
+	return strings.ReplaceAll(summary, "\n", " "), nil
+}
+
+// Generate implements Generator with the summary model.
+func (c *VertexAIClient) Generate(ctx context.Context, system, prompt string, maxTokens int) (string, error) {
+	s, err := c.generate(ctx, system, prompt, maxTokens)
+	if err != nil {
+		return "", fmt.Errorf("generation failed: %w", err)
+	}
+	return s, nil
+}
+
+// GenerateStream implements StreamGenerator with the summary model.
+func (c *VertexAIClient) GenerateStream(ctx context.Context, system, prompt string, maxTokens int, fn func(delta string) error) (string, error) {
 	temp := float32(0.2)
-	maxTokens := int32(120)
 	cfg := genai.GenerateContentConfig{
 		Temperature:       &temp,
-		MaxOutputTokens:   maxTokens,
-		SystemInstruction: prompt[0],
+		MaxOutputTokens:   int32(maxTokens),
+		SystemInstruction: genai.Text(system)[0],
+	}
+	var text strings.Builder
+	var tokens int64
+	// Each streamed response reports the usage of the request so far.
+	defer func() { c.tokens.Add(tokens) }()
+	for resp, err := range c.client.Models.GenerateContentStream(ctx, c.config.SummaryModel, genai.Text(prompt), &cfg) {
+		if err != nil {
+			return "", fmt.Errorf("generation failed: %w", err)
+		}
+		if resp.UsageMetadata != nil {
+			tokens = int64(resp.UsageMetadata.TotalTokenCount)
+		}
+		if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+			continue
+		}
+		for _, part := range resp.Candidates[0].Content.Parts {
+			if part.Text == "" {
+				continue
+			}
+			text.WriteString(part.Text)
+			if err := fn(part.Text); err != nil {
+				return "", err
+			}
+		}
+	}
+	return strings.TrimSpace(text.String()), nil
+}
+
+// generate runs the summary model with a system instruction and returns the
+// trimmed text of the first candidate.
+func (c *VertexAIClient) generate(ctx context.Context, system, prompt string, maxTokens int) (string, error) {
+	temp := float32(0.2)
+	cfg := genai.GenerateContentConfig{
+		Temperature:       &temp,
+		MaxOutputTokens:   int32(maxTokens),
+		SystemInstruction: genai.Text(system)[0],
 	}
 
-	userPrompt := "Path: " + filePath + "\nLanguage: " + language + "\n---\n" + content
-	resp, err := c.client.Models.GenerateContent(ctx, c.config.SummaryModel, genai.Text(userPrompt), &cfg)
+	resp, err := c.client.Models.GenerateContent(ctx, c.config.SummaryModel, genai.Text(prompt), &cfg)
 	if err != nil {
-		return "", fmt.Errorf("summarization failed: %w", err)
+		return "", err
+	}
+	if resp.UsageMetadata != nil {
+		c.tokens.Add(int64(resp.UsageMetadata.TotalTokenCount))
 	}
 
 	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
-		return "", errors.New("no summary returned")
+		return "", errors.New("no text returned")
 	}
 
 	// Extract text from the first part
 	part := resp.Candidates[0].Content.Parts[0]
-
-	summary := strings.TrimSpace(string(part.Text))
-	summary = strings.ReplaceAll(summary, "\n", " ")
-	return summary, nil
+	return strings.TrimSpace(part.Text), nil
 }
 
 func (c *VertexAIClient) Dim() int {
 	return c.config.Dim
 }
+
+// TokensUsed returns the total tokens reported by Gemini for summarization calls.
+func (c *VertexAIClient) TokensUsed() int64 {
+	return c.tokens.Load()
+}
@@ -0,0 +1,15 @@
+package auth
+
+import "context"
+
+// RepositoryFilter returns the repositories a signed-in user may see, e.g.
+// those the user can see on GitHub. It should return an empty, not nil,
+// slice for a user who may see none.
+type RepositoryFilter func(ctx context.Context, user *GithubUser) ([]string, error)
+
+// SetRepositoryFilter limits the requests of signed-in users to the
+// repositories filter returns, recorded in GithubUser.Repositories. API keys
+// are not filtered. A nil filter leaves users unlimited.
+func (s *Service) SetRepositoryFilter(filter RepositoryFilter) {
+	s.repositoryFilter = filter
+}
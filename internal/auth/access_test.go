@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestRepositoryFilter(t *testing.T) {
+	s := NewService(AuthConfig{JwtSecret: []byte("secret"), Enabled: true})
+	s.SetAPIKeyLookup(func(ctx context.Context, hash string) (*GithubUser, []string, error) {
+		return &GithubUser{Login: "api-key/1"}, []string{ScopeSearch}, nil
+	})
+	s.SetRepositoryFilter(func(ctx context.Context, user *GithubUser) ([]string, error) {
+		if user.Provider != ProviderGithub {
+			return []string{}, nil
+		}
+		return []string{"org/" + user.Login}, nil
+	})
+
+	var got *GithubUser
+	h := s.OptionalAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		got = GetUserFromContext(r)
+	})
+	serve := func(user *GithubUser, apiKey string) {
+		t.Helper()
+		got = nil
+		req := httptest.NewRequest("GET", "/search", nil)
+		if apiKey != "" {
+			req.Header.Set(APIKeyHeader, apiKey)
+		} else {
+			token, err := s.GenerateJWT(user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		h(httptest.NewRecorder(), req)
+		if got == nil {
+			t.Fatal("request was refused")
+		}
+	}
+
+	serve(&GithubUser{Login: "alice", Provider: ProviderGithub}, "")
+	if !slices.Equal(got.Repositories, []string{"org/alice"}) {
+		t.Errorf("GitHub user repositories = %v", got.Repositories)
+	}
+	serve(&GithubUser{Login: "alice", Provider: ProviderGoogle}, "")
+	if got.Repositories == nil || len(got.Repositories) != 0 {
+		t.Errorf("Google user repositories = %#v, want none", got.Repositories)
+	}
+
+	// API keys are not filtered.
+	key, _, _ := NewAPIKey()
+	serve(nil, key)
+	if got.Repositories != nil {
+		t.Errorf("API key repositories = %v", got.Repositories)
+	}
+}
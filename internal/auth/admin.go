@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// normalizeAdmins returns the admin principals, as logins or GitHub teams
+// ("team:org/team-slug"), trimmed and lowercased.
+func normalizeAdmins(principals []string) []string {
+	admins := make([]string, 0, len(principals))
+	for _, p := range principals {
+		if p = strings.TrimSpace(p); p != "" {
+			admins = append(admins, strings.ToLower(p))
+		}
+	}
+	return admins
+}
+
+// AdminsConfigured returns whether admins are configured. Without them,
+// signed-in users may only call the admin endpoints when AllowAllAdmins is
+// set.
+func (s *Service) AdminsConfigured() bool {
+	return s != nil && len(s.cfg.Admins) > 0
+}
+
+// allAdmins reports whether every signed-in user is an admin.
+func (s *Service) allAdmins() bool {
+	return s != nil && s.cfg.AllowAllAdmins && !s.AdminsConfigured()
+}
+
+// isAdmin returns whether user is one of the configured admins, by login or
+// by team, or every user is.
+func (s *Service) isAdmin(user *GithubUser) bool {
+	if s.allAdmins() {
+		return true
+	}
+	if !s.AdminsConfigured() {
+		return false
+	}
+	if slices.Contains(s.cfg.Admins, strings.ToLower(user.Login)) {
+		return true
+	}
+	for _, t := range user.Teams {
+		if slices.Contains(s.cfg.Admins, "team:"+strings.ToLower(t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminAuthMiddleware is RequireAuthMiddleware for privileged endpoints:
+// signed-in users must be one of the configured admins, and are all refused
+// when there are none unless AllowAllAdmins is set. API keys need the admin
+// scope.
+func (s *Service) AdminAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return s.AdminScopeMiddleware(ScopeAdmin, next)
+}
+
+// AdminScopeMiddleware is AdminAuthMiddleware for privileged endpoints that
+// API keys may call with the given scope.
+func (s *Service) AdminScopeMiddleware(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return s.RequireScopeMiddleware(scope, func(w http.ResponseWriter, r *http.Request) {
+		if !s.allAdmins() && !IsAPIKeyRequest(r) {
+			if !s.AdminsConfigured() {
+				http.Error(w, "This endpoint requires the admin role, and no admins are configured", http.StatusForbidden)
+				return
+			}
+			if user := GetUserFromContext(r); user == nil || !user.Admin {
+				http.Error(w, "This endpoint requires the admin role", http.StatusForbidden)
+				return
+			}
+		}
+		next(w, r)
+	})
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminAuthMiddleware(t *testing.T) {
+	original := defaultService
+	defer func() { defaultService = original }()
+	defer SetAPIKeyLookup(nil)
+
+	InitializeAuth("secret", "client", "secret", "url", "", true)
+	handler := AdminAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	call := func(user *GithubUser, apiKey string) int {
+		req := httptest.NewRequest("POST", "/admin/optimize", nil)
+		if user != nil {
+			token, err := GenerateJWT(user)
+			if err != nil {
+				t.Fatalf("GenerateJWT: %v", err)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if apiKey != "" {
+			req.Header.Set(APIKeyHeader, apiKey)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr.Code
+	}
+
+	// Without admins, signed-in users are refused unless every one of them
+	// is allowed explicitly.
+	if code := call(&GithubUser{Login: "bob"}, ""); code != http.StatusForbidden {
+		t.Errorf("without admins: status %d, want 403", code)
+	}
+	ConfigureAllowAllAdmins(true)
+	if code := call(&GithubUser{Login: "bob"}, ""); code != http.StatusOK {
+		t.Errorf("without admins, allowing all: status %d, want 200", code)
+	}
+
+	ConfigureAdmins([]string{"Alice", " team:acme/SRE ", ""})
+	for _, tc := range []struct {
+		user *GithubUser
+		want int
+	}{
+		{&GithubUser{Login: "alice"}, http.StatusOK},
+		{&GithubUser{Login: "carol", Teams: []string{"acme/sre"}}, http.StatusOK},
+		{&GithubUser{Login: "bob", Teams: []string{"acme/backend"}}, http.StatusForbidden},
+		{nil, http.StatusUnauthorized},
+	} {
+		// Configured admins take precedence over allowing all.
+		if code := call(tc.user, ""); code != tc.want {
+			t.Errorf("user %+v: status %d, want %d", tc.user, code, tc.want)
+		}
+	}
+
+	// API keys need the admin scope rather than an admin user.
+	key, hash, _ := NewAPIKey()
+	SetAPIKeyLookup(func(ctx context.Context, h string) (*GithubUser, []string, error) {
+		if h != hash {
+			return nil, nil, nil
+		}
+		return &GithubUser{Login: "api-key/ci"}, []string{ScopeAdmin}, nil
+	})
+	if code := call(nil, key); code != http.StatusOK {
+		t.Errorf("admin API key: status %d, want 200", code)
+	}
+
+	token, _ := GenerateJWT(&GithubUser{Login: "alice"})
+	if user, err := ValidateJWT(token); err != nil || !user.Admin {
+		t.Errorf("ValidateJWT = %+v, %v; want an admin", user, err)
+	}
+}
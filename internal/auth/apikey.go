@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// APIKeyHeader is the request header carrying an API key.
+const APIKeyHeader = "X-API-Key"
+
+// apiKeyPrefix starts every API key, so that leaked keys are easy to spot.
+const apiKeyPrefix = "rsk_"
+
+// APIKeyPrefixLen is the number of leading characters of a key kept to
+// identify it once the key itself is no longer known.
+const APIKeyPrefixLen = len(apiKeyPrefix) + 8
+
+// API key scopes. A key may only call the endpoints its scopes cover;
+// signed-in users are not limited by scopes.
+const (
+	// ScopeSearch covers the endpoints open to any signed-in user: search,
+	// browsing and asking.
+	ScopeSearch = "search"
+	// ScopeIndex covers starting and following indexing runs.
+	ScopeIndex = "index"
+	// ScopeAdmin covers the other endpoints that require authentication.
+	ScopeAdmin = "admin"
+)
+
+// Scopes lists the valid API key scopes.
+var Scopes = []string{ScopeSearch, ScopeIndex, ScopeAdmin}
+
+// APIKeyLookup resolves the hash of an API key to the user the request acts
+// as and the key's scopes. It returns a nil user when no valid key has that
+// hash.
+type APIKeyLookup func(ctx context.Context, hash string) (*GithubUser, []string, error)
+
+// SetAPIKeyLookup enables API key authentication through the X-API-Key
+// header, resolving keys with lookup. A nil lookup disables it.
+func (s *Service) SetAPIKeyLookup(lookup APIKeyLookup) {
+	s.apiKeyLookup = lookup
+}
+
+// NewAPIKey generates a random API key and returns it along with the hash
+// under which it is stored.
+func NewAPIKey() (key, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key = apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the hex-encoded SHA-256 hash of an API key. Keys carry
+// enough entropy that a fast unsalted hash is safe to store.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyContextKey holds the scopes of requests authenticated with an API
+// key.
+const apiKeyContextKey ContextKey = "api_key"
+
+// IsAPIKeyRequest reports whether r was authenticated with an API key rather
+// than a signed-in user's token.
+func IsAPIKeyRequest(r *http.Request) bool {
+	_, ok := r.Context().Value(apiKeyContextKey).([]string)
+	return ok
+}
+
+// validAPIKey reports whether key looks like a key made by NewAPIKey, to
+// avoid store lookups for arbitrary header values.
+func validAPIKey(key string) bool {
+	return strings.HasPrefix(key, apiKeyPrefix) && len(key) > APIKeyPrefixLen
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewAPIKey(t *testing.T) {
+	key, hash, err := NewAPIKey()
+	if err != nil {
+		t.Fatalf("NewAPIKey: %v", err)
+	}
+	if !validAPIKey(key) || len(key) != len(apiKeyPrefix)+43 {
+		t.Errorf("unexpected key %q", key)
+	}
+	if hash != HashAPIKey(key) || len(hash) != 64 {
+		t.Errorf("hash = %q", hash)
+	}
+	if other, _, _ := NewAPIKey(); other == key {
+		t.Error("NewAPIKey returned the same key twice")
+	}
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	InitializeAuth("secret", "client", "secret", "url", "", true)
+	key, hash, _ := NewAPIKey()
+	var lookupErr error
+	SetAPIKeyLookup(func(ctx context.Context, h string) (*GithubUser, []string, error) {
+		if lookupErr != nil {
+			return nil, nil, lookupErr
+		}
+		if h == hash {
+			return &GithubUser{Login: "api-key/ci", Name: "ci"}, []string{ScopeAdmin}, nil
+		}
+		return nil, nil, nil
+	})
+	defer SetAPIKeyLookup(nil)
+
+	var got *GithubUser
+	var viaKey bool
+	handler := RequireAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		got, viaKey = GetUserFromContext(r), IsAPIKeyRequest(r)
+	})
+	serve := func(key string) *httptest.ResponseRecorder {
+		got, viaKey = nil, false
+		req := httptest.NewRequest("GET", "/admin/index", nil)
+		req.Header.Set(APIKeyHeader, key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve(key); w.Code != http.StatusOK || got == nil || got.Login != "api-key/ci" || !viaKey {
+		t.Errorf("valid key: status %d, user %+v, via key %v", w.Code, got, viaKey)
+	}
+	for _, bad := range []string{"rsk_wrong-key-of-some-length", "not-a-key"} {
+		if w := serve(bad); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "Invalid API key") {
+			t.Errorf("key %q: status %d, body %q", bad, w.Code, w.Body.String())
+		}
+	}
+	lookupErr = errors.New("database down")
+	if w := serve(key); w.Code != http.StatusInternalServerError {
+		t.Errorf("failed lookup: status %d", w.Code)
+	}
+
+	// A JWT still authenticates, and is not an API key request.
+	lookupErr = nil
+	token, _ := GenerateJWT(&GithubUser{Login: "alice"})
+	req := httptest.NewRequest("GET", "/admin/index", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got == nil || got.Login != "alice" || viaKey {
+		t.Errorf("JWT: user %+v, via key %v", got, viaKey)
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	InitializeAuth("secret", "client", "secret", "url", "", true)
+	key, hash, _ := NewAPIKey()
+	SetAPIKeyLookup(func(ctx context.Context, h string) (*GithubUser, []string, error) {
+		if h == hash {
+			return &GithubUser{Login: "api-key/ci"}, []string{ScopeSearch, ScopeIndex}, nil
+		}
+		return nil, nil, nil
+	})
+	defer SetAPIKeyLookup(nil)
+
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	for _, tt := range []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+	}{
+		{"optional auth needs search", OptionalAuthMiddleware(ok), http.StatusOK},
+		{"index scope", RequireScopeMiddleware(ScopeIndex, ok), http.StatusOK},
+		{"admin scope", RequireAuthMiddleware(ok), http.StatusForbidden},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(APIKeyHeader, key)
+		w := httptest.NewRecorder()
+		tt.handler.ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, body %q", tt.name, w.Code, w.Body.String())
+		}
+	}
+
+	// Signed-in users are not limited by scopes.
+	token, _ := GenerateJWT(&GithubUser{Login: "alice"})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	RequireAuthMiddleware(ok).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("JWT: status %d", w.Code)
+	}
+}
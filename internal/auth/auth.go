@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -21,10 +22,18 @@ type ContextKey string
 const UserContextKey ContextKey = "user"
 
 type GithubUser struct {
-	Login     string `json:"login"`
-	Name      string `json:"name"`
-	Email     string `json:"email"`
-	AvatarURL string `json:"avatar_url"`
+	Login     string   `json:"login"`
+	Name      string   `json:"name"`
+	Email     string   `json:"email"`
+	AvatarURL string   `json:"avatar_url"`
+	Teams     []string `json:"teams,omitempty"`    // GitHub teams as "org/team-slug"
+	Orgs      []string `json:"orgs,omitempty"`     // allowed GitHub organizations the user is a member of
+	Admin     bool     `json:"admin,omitempty"`    // whether the user is one of the configured admins
+	Provider  string   `json:"provider,omitempty"` // login provider, e.g. "github"
+	// Repositories, when not nil, limits the user's requests to these
+	// repositories: those of a limited API key, or those a RepositoryFilter
+	// lets the user see.
+	Repositories []string `json:"-"`
 }
 
 type AuthResponse struct {
@@ -33,36 +42,49 @@ type AuthResponse struct {
 }
 
 type Claims struct {
-	Login     string `json:"login"`
-	Name      string `json:"name"`
-	Email     string `json:"email"`
-	AvatarURL string `json:"avatar_url"`
+	Login     string   `json:"login"`
+	Name      string   `json:"name"`
+	Email     string   `json:"email"`
+	AvatarURL string   `json:"avatar_url"`
+	Teams     []string `json:"teams,omitempty"`
+	Orgs      []string `json:"orgs,omitempty"`
+	Admin     bool     `json:"admin,omitempty"`
+	Provider  string   `json:"provider,omitempty"`
 	jwt.RegisteredClaims
 }
 
-var (
-	authConfig *AuthConfig
-)
-
+// AuthConfig configures a Service. The GitHub client settings are at the
+// top level; GitLab and Google are only enabled by a client ID, and SAML by
+// Service.EnableSAML.
 type AuthConfig struct {
 	JwtSecret    []byte
 	ClientID     string
 	ClientSecret string
 	RedirectURL  string
 	AllowedOrg   string
-	Enabled      bool
-}
-
-// InitializeAuth sets up the auth configuration
-func InitializeAuth(jwtSecret, clientID, clientSecret, redirectURL, allowedOrg string, enabled bool) {
-	authConfig = &AuthConfig{
-		JwtSecret:    []byte(jwtSecret),
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		RedirectURL:  redirectURL,
-		AllowedOrg:   allowedOrg,
-		Enabled:      enabled,
-	}
+	// AllowedOrgs are GitHub organizations whose members may log in, in
+	// addition to AllowedOrg. Logins record the ones the user is in.
+	AllowedOrgs []string
+	// RequiredTeams, as "org/team-slug", limit GitHub login to the members
+	// of at least one of them. Logins record the ones the user is in.
+	RequiredTeams []string
+	// GithubTeams makes GitHub login record the user's team memberships in
+	// its token, so that teams can be granted access to repositories. With
+	// an allowed organization, only its teams are recorded.
+	GithubTeams bool
+	Enabled     bool
+	Gitlab      GitlabConfig
+	Google      GoogleConfig
+	SAML        SAMLConfig
+	Cookie      CookieConfig
+	Lifetimes   Lifetimes
+	// Admins are the users allowed to call the admin endpoints, as logins or
+	// GitHub teams ("team:org/team-slug"). Without admins, only API keys with
+	// the admin scope are allowed, unless AllowAllAdmins is set.
+	Admins []string
+	// AllowAllAdmins makes every signed-in user an admin when no Admins are
+	// configured, e.g. for single-user deployments.
+	AllowAllAdmins bool
 }
 
 func getEnv(key, defaultValue string) string {
@@ -72,12 +94,41 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-// IsAuthEnabled returns whether authentication is enabled
-func IsAuthEnabled() bool {
-	if authConfig == nil {
-		return false
+// Login providers, as listed by Providers and recorded in GithubUser.Provider.
+const (
+	ProviderGithub = "github"
+	ProviderGitlab = "gitlab"
+	ProviderGoogle = "google"
+	ProviderSAML   = "saml"
+)
+
+// Providers returns the login providers that have a client configured, in
+// the order a login page should offer them.
+func (s *Service) Providers() []string {
+	providers := []string{}
+	if s.GithubEnabled() {
+		providers = append(providers, ProviderGithub)
+	}
+	if s.GitlabEnabled() {
+		providers = append(providers, ProviderGitlab)
+	}
+	if s.GoogleEnabled() {
+		providers = append(providers, ProviderGoogle)
+	}
+	if s.SAMLEnabled() {
+		providers = append(providers, ProviderSAML)
 	}
-	return authConfig.Enabled
+	return providers
+}
+
+// GithubEnabled returns whether GitHub login is configured.
+func (s *Service) GithubEnabled() bool {
+	return s != nil && s.cfg.ClientID != ""
+}
+
+// IsAuthEnabled returns whether authentication is enabled
+func (s *Service) IsAuthEnabled() bool {
+	return s != nil && s.cfg.Enabled
 }
 
 // GenerateState creates a random state parameter for OAuth
@@ -92,28 +143,28 @@ func GenerateState() string {
 }
 
 // GetGithubLoginURL returns the Github OAuth login URL
-func GetGithubLoginURL(state string) string {
-	if authConfig == nil {
+func (s *Service) GetGithubLoginURL(state string) string {
+	if s == nil {
 		return ""
 	}
 	scope := "read:user,user:email"
-	if authConfig.AllowedOrg != "" {
+	if len(s.cfg.AllowedOrgs) > 0 || len(s.cfg.RequiredTeams) > 0 || s.cfg.GithubTeams {
 		scope += ",read:org"
 	}
 	return fmt.Sprintf(
 		"https://github.com/login/oauth/authorize?client_id=%s&redirect_uri=%s&scope=%s&state=%s",
-		authConfig.ClientID, authConfig.RedirectURL, scope, state,
+		s.cfg.ClientID, s.cfg.RedirectURL, scope, state,
 	)
 }
 
 // ExchangeCodeForToken exchanges OAuth code for access token
-func ExchangeCodeForToken(code string) (string, error) {
-	if authConfig == nil {
+func (s *Service) ExchangeCodeForToken(code string) (string, error) {
+	if s == nil {
 		return "", errors.New("auth not initialized")
 	}
 	data := fmt.Sprintf(
 		"client_id=%s&client_secret=%s&code=%s",
-		authConfig.ClientID, authConfig.ClientSecret, code,
+		s.cfg.ClientID, s.cfg.ClientSecret, code,
 	)
 
 	req, err := http.NewRequest("POST", "https://github.com/login/oauth/access_token", strings.NewReader(data))
@@ -148,8 +199,11 @@ func ExchangeCodeForToken(code string) (string, error) {
 }
 
 // GetGithubUser fetches user info from Github API
-func GetGithubUser(accessToken string) (*GithubUser, error) {
-	req, err := http.NewRequest("GET", "https://api.github.com/user", nil)
+func (s *Service) GetGithubUser(accessToken string) (*GithubUser, error) {
+	if s == nil {
+		return nil, errors.New("auth not initialized")
+	}
+	req, err := http.NewRequest("GET", githubAPIURL+"/user", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -177,20 +231,47 @@ func GetGithubUser(accessToken string) (*GithubUser, error) {
 	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
 		return nil, err
 	}
+	user.Provider = ProviderGithub
 
 	// Check org membership if required
-	if authConfig.AllowedOrg != "" {
-		if !isOrgMember(accessToken, user.Login, authConfig.AllowedOrg) {
+	if len(s.cfg.AllowedOrgs) > 0 {
+		for _, org := range s.cfg.AllowedOrgs {
+			if isOrgMember(accessToken, user.Login, org) {
+				user.Orgs = append(user.Orgs, org)
+			}
+		}
+		if len(user.Orgs) == 0 {
 			return nil, fmt.Errorf("user is not a member of the required organization")
 		}
 	}
+	if s.cfg.GithubTeams || len(s.cfg.RequiredTeams) > 0 {
+		teams, err := getGithubTeams(accessToken, s.cfg.AllowedOrgs)
+		if err != nil {
+			return nil, err
+		}
+		if len(s.cfg.RequiredTeams) > 0 {
+			var member []string
+			for _, t := range teams {
+				if slices.Contains(s.cfg.RequiredTeams, t) {
+					member = append(member, t)
+				}
+			}
+			if len(member) == 0 {
+				return nil, fmt.Errorf("user is not a member of a required team")
+			}
+			if !s.cfg.GithubTeams {
+				teams = member
+			}
+		}
+		user.Teams = teams
+	}
 
 	return &user, nil
 }
 
 // isOrgMember checks if user is a member of the specified organization
 func isOrgMember(accessToken, username, org string) bool {
-	url := fmt.Sprintf("https://api.github.com/orgs/%s/members/%s", org, username)
+	url := fmt.Sprintf("%s/orgs/%s/members/%s", githubAPIURL, org, username)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return false
@@ -215,8 +296,8 @@ func isOrgMember(accessToken, username, org string) bool {
 }
 
 // GenerateJWT creates a JWT token for the user
-func GenerateJWT(user *GithubUser) (string, error) {
-	if authConfig == nil {
+func (s *Service) GenerateJWT(user *GithubUser) (string, error) {
+	if s == nil {
 		return "", errors.New("auth not initialized")
 	}
 	claims := Claims{
@@ -224,84 +305,154 @@ func GenerateJWT(user *GithubUser) (string, error) {
 		Name:      user.Name,
 		Email:     user.Email,
 		AvatarURL: user.AvatarURL,
+		Teams:     user.Teams,
+		Orgs:      user.Orgs,
+		Admin:     s.isAdmin(user),
+		Provider:  user.Provider,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ID:        newTokenID(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(s.AccessTokenTTL())),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Subject:   user.Login,
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(authConfig.JwtSecret)
+	return token.SignedString(s.cfg.JwtSecret)
 }
 
-// ValidateJWT validates and parses a JWT token
-func ValidateJWT(tokenString string) (*GithubUser, error) {
-	if authConfig == nil {
+// ParseJWT verifies the signature and times of a JWT token and returns its
+// claims. Unlike ValidateJWT it does not check whether it was revoked.
+func (s *Service) ParseJWT(tokenString string) (*Claims, error) {
+	if s == nil {
 		return nil, errors.New("auth not initialized")
 	}
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method")
 		}
-		return authConfig.JwtSecret, nil
-	})
+		return s.cfg.JwtSecret, nil
+	}, jwt.WithLeeway(s.cfg.Lifetimes.ClockSkew))
 
 	if err != nil {
 		return nil, err
 	}
 
 	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return &GithubUser{
-			Login:     claims.Login,
-			Name:      claims.Name,
-			Email:     claims.Email,
-			AvatarURL: claims.AvatarURL,
-		}, nil
+		return claims, nil
 	}
 
 	return nil, fmt.Errorf("invalid token")
 }
 
+// ValidateJWT validates and parses a JWT token, refusing revoked tokens.
+// ctx bounds the revocation check, typically that of the request.
+func (s *Service) ValidateJWT(ctx context.Context, tokenString string) (*GithubUser, error) {
+	claims, err := s.ParseJWT(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkRevoked(ctx, claims); err != nil {
+		return nil, err
+	}
+	return &GithubUser{
+		Login:     claims.Login,
+		Name:      claims.Name,
+		Email:     claims.Email,
+		AvatarURL: claims.AvatarURL,
+		Teams:     claims.Teams,
+		Orgs:      claims.Orgs,
+		Admin:     claims.Admin,
+		Provider:  claims.Provider,
+	}, nil
+}
+
 // OptionalAuthMiddleware extracts and validates JWT from request if auth is enabled
 // If auth is disabled, it allows all requests through
-func OptionalAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+func (s *Service) OptionalAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// If auth is disabled, just pass through
-		if !IsAuthEnabled() {
+		if !s.IsAuthEnabled() {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Extract token from Authorization header or cookie
-		var tokenString string
-
-		// Try Authorization header first
-		authHeader := r.Header.Get("Authorization")
-		if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
-			tokenString = strings.TrimPrefix(authHeader, "Bearer ")
-		} else {
-			// Try cookie
-			if cookie, err := r.Cookie("auth_token"); err == nil {
-				tokenString = cookie.Value
-			}
-		}
+		s.authenticate(w, r, next, ScopeSearch)
+	}
+}
+
+// RequireAuthMiddleware only allows requests carrying a valid JWT, or an API
+// key with the admin scope. Endpoints using it are refused outright when auth
+// is disabled; privileged ones use AdminAuthMiddleware instead.
+func (s *Service) RequireAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return s.RequireScopeMiddleware(ScopeAdmin, next)
+}
 
-		if tokenString == "" {
-			http.Error(w, "Authentication required", http.StatusUnauthorized)
+// RequireScopeMiddleware is RequireAuthMiddleware for endpoints that API keys
+// may call with the given scope.
+func (s *Service) RequireScopeMiddleware(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.IsAuthEnabled() {
+			http.Error(w, "This endpoint requires authentication to be enabled", http.StatusForbidden)
 			return
 		}
+		s.authenticate(w, r, next, scope)
+	}
+}
 
-		user, err := ValidateJWT(tokenString)
+// authenticate validates the request token and calls next with the user in
+// the request context. Requests with an API key need scope.
+func (s *Service) authenticate(w http.ResponseWriter, r *http.Request, next http.HandlerFunc, scope string) {
+	if key := r.Header.Get(APIKeyHeader); key != "" && s.apiKeyLookup != nil {
+		var user *GithubUser
+		var scopes []string
+		var err error
+		if validAPIKey(key) {
+			user, scopes, err = s.apiKeyLookup(r.Context(), HashAPIKey(key))
+		}
 		if err != nil {
-			http.Error(w, "Invalid authentication token", http.StatusUnauthorized)
+			http.Error(w, "Failed to check API key", http.StatusInternalServerError)
+			return
+		}
+		if user == nil {
+			s.RecordEvent(r, EventValidation, "", errors.New("invalid API key"))
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if !slices.Contains(scopes, scope) {
+			http.Error(w, fmt.Sprintf("API key lacks the %q scope", scope), http.StatusForbidden)
 			return
 		}
-
-		// Add user to request context
 		ctx := context.WithValue(r.Context(), UserContextKey, user)
+		ctx = context.WithValue(ctx, apiKeyContextKey, scopes)
 		next.ServeHTTP(w, r.WithContext(ctx))
+		return
+	}
+
+	// Extract token from Authorization header or cookie
+	tokenString := s.TokenFromRequest(r)
+	if tokenString == "" {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
 	}
+
+	user, err := s.ValidateJWT(r.Context(), tokenString)
+	if err != nil {
+		s.RecordEvent(r, EventValidation, "", err)
+		http.Error(w, "Invalid authentication token", http.StatusUnauthorized)
+		return
+	}
+
+	if s.repositoryFilter != nil {
+		if user.Repositories, err = s.repositoryFilter(r.Context(), user); err != nil {
+			http.Error(w, "Failed to check repository access", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Add user to request context
+	ctx := context.WithValue(r.Context(), UserContextKey, user)
+	next.ServeHTTP(w, r.WithContext(ctx))
 }
 
 // GetUserFromContext extracts user from request context
@@ -16,35 +16,35 @@ func TestInitializeAuth(t *testing.T) {
 	// Test initialization
 	InitializeAuth("test-secret", "client-id", "client-secret", "http://localhost/callback", "test-org", true)
 
-	if authConfig == nil {
-		t.Fatal("authConfig should not be nil after initialization")
+	if defaultService == nil {
+		t.Fatal("the default service should be set after initialization")
 	}
 
-	if string(authConfig.JwtSecret) != "test-secret" {
-		t.Errorf("Expected JwtSecret 'test-secret', got %q", string(authConfig.JwtSecret))
+	if string(defaultService.cfg.JwtSecret) != "test-secret" {
+		t.Errorf("Expected JwtSecret 'test-secret', got %q", string(defaultService.cfg.JwtSecret))
 	}
-	if authConfig.ClientID != "client-id" {
-		t.Errorf("Expected ClientID 'client-id', got %q", authConfig.ClientID)
+	if defaultService.cfg.ClientID != "client-id" {
+		t.Errorf("Expected ClientID 'client-id', got %q", defaultService.cfg.ClientID)
 	}
-	if authConfig.ClientSecret != "client-secret" {
-		t.Errorf("Expected ClientSecret 'client-secret', got %q", authConfig.ClientSecret)
+	if defaultService.cfg.ClientSecret != "client-secret" {
+		t.Errorf("Expected ClientSecret 'client-secret', got %q", defaultService.cfg.ClientSecret)
 	}
-	if authConfig.RedirectURL != "http://localhost/callback" {
-		t.Errorf("Expected RedirectURL 'http://localhost/callback', got %q", authConfig.RedirectURL)
+	if defaultService.cfg.RedirectURL != "http://localhost/callback" {
+		t.Errorf("Expected RedirectURL 'http://localhost/callback', got %q", defaultService.cfg.RedirectURL)
 	}
-	if authConfig.AllowedOrg != "test-org" {
-		t.Errorf("Expected AllowedOrg 'test-org', got %q", authConfig.AllowedOrg)
+	if defaultService.cfg.AllowedOrg != "test-org" {
+		t.Errorf("Expected AllowedOrg 'test-org', got %q", defaultService.cfg.AllowedOrg)
 	}
-	if !authConfig.Enabled {
+	if !defaultService.cfg.Enabled {
 		t.Error("Expected Enabled to be true")
 	}
 }
 
 func TestIsAuthEnabled(t *testing.T) {
 	// Test when auth config is nil
-	authConfig = nil
+	defaultService = nil
 	if IsAuthEnabled() {
-		t.Error("Expected IsAuthEnabled to return false when authConfig is nil")
+		t.Error("Expected IsAuthEnabled to return false without a default service")
 	}
 
 	// Test when auth is disabled
@@ -81,11 +81,11 @@ func TestGenerateState(t *testing.T) {
 }
 
 func TestGetGithubLoginURL(t *testing.T) {
-	// Test when authConfig is nil
-	authConfig = nil
+	// Test without a default service
+	defaultService = nil
 	url := GetGithubLoginURL("test-state")
 	if url != "" {
-		t.Error("Expected empty URL when authConfig is nil")
+		t.Error("Expected empty URL without a default service")
 	}
 
 	// Test with basic config (no org)
@@ -108,11 +108,11 @@ func TestGetGithubLoginURL(t *testing.T) {
 }
 
 func TestExchangeCodeForToken(t *testing.T) {
-	// Test when authConfig is nil
-	authConfig = nil
+	// Test without a default service
+	defaultService = nil
 	_, err := ExchangeCodeForToken("test-code")
 	if err == nil {
-		t.Error("Expected error when authConfig is nil")
+		t.Error("Expected error without a default service")
 	}
 	if !strings.Contains(err.Error(), "auth not initialized") {
 		t.Errorf("Expected 'auth not initialized' error, got: %v", err)
@@ -219,12 +219,12 @@ func TestIsOrgMember(t *testing.T) {
 }
 
 func TestGenerateJWT(t *testing.T) {
-	// Test when authConfig is nil
-	authConfig = nil
+	// Test without a default service
+	defaultService = nil
 	user := &GithubUser{Login: "testuser", Name: "Test User"}
 	_, err := GenerateJWT(user)
 	if err == nil {
-		t.Error("Expected error when authConfig is nil")
+		t.Error("Expected error without a default service")
 	}
 
 	// Test successful JWT generation
@@ -248,7 +248,7 @@ func TestGenerateJWT(t *testing.T) {
 
 	// Verify the token can be parsed
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return authConfig.JwtSecret, nil
+		return defaultService.cfg.JwtSecret, nil
 	})
 
 	if err != nil {
@@ -282,11 +282,11 @@ func TestGenerateJWT(t *testing.T) {
 }
 
 func TestValidateJWT(t *testing.T) {
-	// Test when authConfig is nil
-	authConfig = nil
+	// Test without a default service
+	defaultService = nil
 	_, err := ValidateJWT("some-token")
 	if err == nil {
-		t.Error("Expected error when authConfig is nil")
+		t.Error("Expected error without a default service")
 	}
 
 	InitializeAuth("test-secret-key", "client", "secret", "url", "", true)
@@ -342,7 +342,7 @@ func TestValidateJWT(t *testing.T) {
 	}
 
 	expiredToken := jwt.NewWithClaims(jwt.SigningMethodHS256, expiredClaims)
-	expiredTokenString, err := expiredToken.SignedString(authConfig.JwtSecret)
+	expiredTokenString, err := expiredToken.SignedString(defaultService.cfg.JwtSecret)
 	if err != nil {
 		t.Fatalf("Failed to create expired token: %v", err)
 	}
@@ -466,6 +466,45 @@ func TestOptionalAuthMiddleware(t *testing.T) {
 	}
 }
 
+func TestRequireAuthMiddleware(t *testing.T) {
+	handlerCalled := false
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerCalled = true
+		if GetUserFromContext(r) == nil {
+			t.Error("Expected user in context")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// Refused when auth is disabled
+	InitializeAuth("secret", "client", "secret", "url", "", false)
+	w := httptest.NewRecorder()
+	RequireAuthMiddleware(testHandler).ServeHTTP(w, httptest.NewRequest("DELETE", "/test", nil))
+	if handlerCalled || w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without calling handler when auth is disabled, got %d", w.Code)
+	}
+
+	// Requires a token when auth is enabled
+	InitializeAuth("secret", "client", "secret", "url", "", true)
+	w = httptest.NewRecorder()
+	RequireAuthMiddleware(testHandler).ServeHTTP(w, httptest.NewRequest("DELETE", "/test", nil))
+	if handlerCalled || w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", w.Code)
+	}
+
+	tokenString, err := GenerateJWT(&GithubUser{Login: "admin"})
+	if err != nil {
+		t.Fatalf("Failed to generate JWT: %v", err)
+	}
+	req := httptest.NewRequest("DELETE", "/test", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenString)
+	w = httptest.NewRecorder()
+	RequireAuthMiddleware(testHandler).ServeHTTP(w, req)
+	if !handlerCalled || w.Code != http.StatusNoContent {
+		t.Errorf("Expected handler to run with a valid token, got %d", w.Code)
+	}
+}
+
 func TestGetUserFromContext(t *testing.T) {
 	// Test with no user in context
 	req := httptest.NewRequest("GET", "/test", nil)
@@ -508,7 +547,7 @@ func TestJWTTokenExpiration(t *testing.T) {
 
 	// Parse the token to check expiration
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return authConfig.JwtSecret, nil
+		return defaultService.cfg.JwtSecret, nil
 	})
 	if err != nil {
 		t.Fatalf("Failed to parse JWT: %v", err)
@@ -519,13 +558,13 @@ func TestJWTTokenExpiration(t *testing.T) {
 		t.Fatal("Failed to parse claims")
 	}
 
-	// Check that expiration is set to 24 hours from now (with some tolerance)
-	expectedExpiry := time.Now().Add(24 * time.Hour)
+	// Check that expiration is set to the access token lifetime (with some tolerance)
+	expectedExpiry := time.Now().Add(AccessTokenTTL())
 	actualExpiry := claims.ExpiresAt.Time
 
 	diff := actualExpiry.Sub(expectedExpiry)
 	if diff > time.Minute || diff < -time.Minute {
-		t.Errorf("Token expiry should be ~24 hours from now, got %v", actualExpiry)
+		t.Errorf("Token expiry should be ~%v from now, got %v", AccessTokenTTL(), actualExpiry)
 	}
 
 	// Check that issued at is around now
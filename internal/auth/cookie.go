@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// DefaultCookieName is the name of the access token cookie when none is
+// configured.
+const DefaultCookieName = "auth_token"
+
+// CookieConfig sets the attributes of the cookies the service sets. The
+// zero value makes host-only cookies at "/", SameSite=Lax, and Secure when
+// the request came over HTTPS.
+type CookieConfig struct {
+	Name     string        // access token cookie
+	Domain   string        // empty for host-only cookies
+	Path     string        // path under which the API is served
+	SameSite http.SameSite // SameSite of the access token and OAuth state cookies
+	// Secure forces the Secure attribute on or off. When nil it is inferred
+	// from the request's scheme or X-Forwarded-Proto.
+	Secure *bool
+}
+
+// cookieDefaults returns cfg with the defaults of the settings it leaves
+// unset.
+func cookieDefaults(cfg CookieConfig) CookieConfig {
+	if cfg.Name == "" {
+		cfg.Name = DefaultCookieName
+	}
+	if cfg.Path == "" {
+		cfg.Path = "/"
+	}
+	if cfg.SameSite == 0 {
+		cfg.SameSite = http.SameSiteLaxMode
+	}
+	return cfg
+}
+
+// CookieName returns the name of the access token cookie.
+func (s *Service) CookieName() string {
+	if s == nil {
+		return DefaultCookieName
+	}
+	return s.cfg.Cookie.Name
+}
+
+// Cookie returns an HttpOnly cookie for a response to r with the configured
+// attributes. sub is a path relative to the configured one, or "" for the
+// configured path itself; a negative maxAge deletes the cookie.
+func (s *Service) Cookie(r *http.Request, name, value, sub string, maxAge int) *http.Cookie {
+	cfg := cookieDefaults(CookieConfig{})
+	if s != nil {
+		cfg = s.cfg.Cookie
+	}
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path.Join(cfg.Path, sub),
+		Domain:   cfg.Domain,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		SameSite: cfg.SameSite,
+	}
+	if cfg.Secure != nil {
+		c.Secure = *cfg.Secure
+	} else {
+		c.Secure = r.TLS != nil || strings.HasPrefix(r.Header.Get("X-Forwarded-Proto"), "https")
+	}
+	return c
+}
+
+// TokenFromRequest returns the JWT of a request, from its Authorization
+// header or else its access token cookie, or "" when it has none.
+func (s *Service) TokenFromRequest(r *http.Request) string {
+	if authHeader := r.Header.Get("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+		return strings.TrimPrefix(authHeader, "Bearer ")
+	}
+	if cookie, err := r.Cookie(s.CookieName()); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCookie_Defaults(t *testing.T) {
+	s := NewService(AuthConfig{})
+	req := httptest.NewRequest("GET", "/", nil)
+	c := s.Cookie(req, s.CookieName(), "v", "", 60)
+	if c.Name != DefaultCookieName || c.Path != "/" || c.Domain != "" || c.SameSite != http.SameSiteLaxMode || c.Secure || !c.HttpOnly {
+		t.Errorf("default cookie = %+v", c)
+	}
+
+	req.Header.Set("X-Forwarded-Proto", "https")
+	if c := s.Cookie(req, "x", "v", "auth", 60); !c.Secure || c.Path != "/auth" {
+		t.Errorf("cookie behind an HTTPS proxy = %+v", c)
+	}
+}
+
+func TestCookie_Configured(t *testing.T) {
+	secure := false
+	s := NewService(AuthConfig{Cookie: CookieConfig{
+		Name:     "rs_session",
+		Domain:   ".example.com",
+		Path:     "/api",
+		SameSite: http.SameSiteStrictMode,
+		Secure:   &secure,
+	}})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	c := s.Cookie(req, s.CookieName(), "v", "auth", -1)
+	if c.Name != "rs_session" || c.Path != "/api/auth" || c.Domain != ".example.com" || c.SameSite != http.SameSiteStrictMode || c.Secure || c.MaxAge != -1 {
+		t.Errorf("configured cookie = %+v", c)
+	}
+
+	// Tokens are read from the configured cookie only.
+	req.AddCookie(&http.Cookie{Name: DefaultCookieName, Value: "other"})
+	if got := s.TokenFromRequest(req); got != "" {
+		t.Errorf("token from the default cookie = %q", got)
+	}
+	req.AddCookie(&http.Cookie{Name: "rs_session", Value: "token"})
+	if got := s.TokenFromRequest(req); got != "token" {
+		t.Errorf("token = %q, want the configured cookie's", got)
+	}
+	req.Header.Set("Authorization", "Bearer header")
+	if got := s.TokenFromRequest(req); got != "header" {
+		t.Errorf("token = %q, want the Authorization header's", got)
+	}
+}
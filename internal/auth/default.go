@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// The functions of this file act on a package-level default Service. They
+// remain for the callers not yet given a Service of their own; new code
+// should take a *Service instead. Like the functions they replace, the
+// middlewares look the default Service up at each request.
+
+var defaultService *Service
+
+// SetDefault makes s the Service used by the package-level functions.
+func SetDefault(s *Service) {
+	defaultService = s
+}
+
+// Default returns the Service used by the package-level functions, or nil
+// when none was set.
+func Default() *Service {
+	return defaultService
+}
+
+// InitializeAuth sets up the auth configuration of the default Service
+func InitializeAuth(jwtSecret, clientID, clientSecret, redirectURL, allowedOrg string, enabled bool) {
+	defaultService = NewService(AuthConfig{
+		JwtSecret:    []byte(jwtSecret),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		AllowedOrg:   allowedOrg,
+		Enabled:      enabled,
+	})
+}
+
+// ConfigureGithubTeams sets AuthConfig.GithubTeams of the default Service.
+// It must be called after InitializeAuth.
+func ConfigureGithubTeams(enabled bool) {
+	if defaultService != nil {
+		defaultService.cfg.GithubTeams = enabled
+	}
+}
+
+// ConfigureAdmins sets AuthConfig.Admins of the default Service. It must be
+// called after InitializeAuth.
+func ConfigureAdmins(principals []string) {
+	if defaultService != nil {
+		defaultService.cfg.Admins = normalizeAdmins(principals)
+	}
+}
+
+// ConfigureAllowAllAdmins sets AuthConfig.AllowAllAdmins of the default
+// Service. It must be called after InitializeAuth.
+func ConfigureAllowAllAdmins(allow bool) {
+	if defaultService != nil {
+		defaultService.cfg.AllowAllAdmins = allow
+	}
+}
+
+// ConfigureGitlab enables GitLab as a login provider of the default Service.
+// It must be called after InitializeAuth.
+func ConfigureGitlab(cfg GitlabConfig) {
+	if defaultService != nil {
+		defaultService.cfg.Gitlab = gitlabDefaults(cfg)
+	}
+}
+
+// ConfigureGoogle enables Google as a login provider of the default Service.
+// It must be called after InitializeAuth.
+func ConfigureGoogle(cfg GoogleConfig) {
+	if defaultService != nil {
+		defaultService.cfg.Google = cfg
+	}
+}
+
+// ConfigureLifetimes sets the lifetimes of the session tokens of the default
+// Service. It must be called after InitializeAuth; zero token lifetimes keep
+// the defaults.
+func ConfigureLifetimes(l Lifetimes) {
+	if defaultService != nil {
+		defaultService.cfg.Lifetimes = lifetimeDefaults(l)
+	}
+}
+
+// SetAPIKeyLookup calls Service.SetAPIKeyLookup on the default Service. It
+// must be called after InitializeAuth.
+func SetAPIKeyLookup(lookup APIKeyLookup) {
+	if defaultService != nil {
+		defaultService.SetAPIKeyLookup(lookup)
+	}
+}
+
+// SetRevocationCheck calls Service.SetRevocationCheck on the default
+// Service. It must be called after InitializeAuth.
+func SetRevocationCheck(check RevocationCheck) {
+	if defaultService != nil {
+		defaultService.SetRevocationCheck(check)
+	}
+}
+
+// SetEventRecorder calls Service.SetEventRecorder on the default Service. It
+// must be called after InitializeAuth.
+func SetEventRecorder(rec EventRecorder) {
+	if defaultService != nil {
+		defaultService.SetEventRecorder(rec)
+	}
+}
+
+// SetRepositoryFilter calls Service.SetRepositoryFilter on the default
+// Service. It must be called after InitializeAuth.
+func SetRepositoryFilter(filter RepositoryFilter) {
+	if defaultService != nil {
+		defaultService.SetRepositoryFilter(filter)
+	}
+}
+
+// IsAuthEnabled calls Service.IsAuthEnabled on the default Service.
+func IsAuthEnabled() bool { return defaultService.IsAuthEnabled() }
+
+// Providers calls Service.Providers on the default Service.
+func Providers() []string { return defaultService.Providers() }
+
+// GithubEnabled calls Service.GithubEnabled on the default Service.
+func GithubEnabled() bool { return defaultService.GithubEnabled() }
+
+// GitlabEnabled calls Service.GitlabEnabled on the default Service.
+func GitlabEnabled() bool { return defaultService.GitlabEnabled() }
+
+// GoogleEnabled calls Service.GoogleEnabled on the default Service.
+func GoogleEnabled() bool { return defaultService.GoogleEnabled() }
+
+// AdminsConfigured calls Service.AdminsConfigured on the default Service.
+func AdminsConfigured() bool { return defaultService.AdminsConfigured() }
+
+// AccessTokenTTL calls Service.AccessTokenTTL on the default Service.
+func AccessTokenTTL() time.Duration { return defaultService.AccessTokenTTL() }
+
+// RefreshTokenTTL calls Service.RefreshTokenTTL on the default Service.
+func RefreshTokenTTL() time.Duration { return defaultService.RefreshTokenTTL() }
+
+// SessionMaxAge calls Service.SessionMaxAge on the default Service.
+func SessionMaxAge() time.Duration { return defaultService.SessionMaxAge() }
+
+// GetGithubLoginURL calls Service.GetGithubLoginURL on the default Service.
+func GetGithubLoginURL(state string) string { return defaultService.GetGithubLoginURL(state) }
+
+// ExchangeCodeForToken calls Service.ExchangeCodeForToken on the default
+// Service.
+func ExchangeCodeForToken(code string) (string, error) {
+	return defaultService.ExchangeCodeForToken(code)
+}
+
+// GetGithubUser calls Service.GetGithubUser on the default Service.
+func GetGithubUser(accessToken string) (*GithubUser, error) {
+	return defaultService.GetGithubUser(accessToken)
+}
+
+// GetGitlabLoginURL calls Service.GetGitlabLoginURL on the default Service.
+func GetGitlabLoginURL(state string) string { return defaultService.GetGitlabLoginURL(state) }
+
+// ExchangeGitlabCode calls Service.ExchangeGitlabCode on the default Service.
+func ExchangeGitlabCode(code string) (string, error) {
+	return defaultService.ExchangeGitlabCode(code)
+}
+
+// GetGitlabUser calls Service.GetGitlabUser on the default Service.
+func GetGitlabUser(accessToken string) (*GithubUser, error) {
+	return defaultService.GetGitlabUser(accessToken)
+}
+
+// GetGoogleLoginURL calls Service.GetGoogleLoginURL on the default Service.
+func GetGoogleLoginURL(state string) string { return defaultService.GetGoogleLoginURL(state) }
+
+// ExchangeGoogleCode calls Service.ExchangeGoogleCode on the default Service.
+func ExchangeGoogleCode(code string) (string, error) {
+	return defaultService.ExchangeGoogleCode(code)
+}
+
+// GetGoogleUser calls Service.GetGoogleUser on the default Service.
+func GetGoogleUser(accessToken string) (*GithubUser, error) {
+	return defaultService.GetGoogleUser(accessToken)
+}
+
+// GenerateJWT calls Service.GenerateJWT on the default Service.
+func GenerateJWT(user *GithubUser) (string, error) { return defaultService.GenerateJWT(user) }
+
+// ParseJWT calls Service.ParseJWT on the default Service.
+func ParseJWT(tokenString string) (*Claims, error) { return defaultService.ParseJWT(tokenString) }
+
+// ValidateJWT calls Service.ValidateJWT on the default Service.
+func ValidateJWT(tokenString string) (*GithubUser, error) {
+	return defaultService.ValidateJWT(context.Background(), tokenString)
+}
+
+// TokenFromRequest calls Service.TokenFromRequest on the default Service.
+func TokenFromRequest(r *http.Request) string { return defaultService.TokenFromRequest(r) }
+
+// RecordEvent calls Service.RecordEvent on the default Service.
+func RecordEvent(r *http.Request, event, login string, err error) {
+	defaultService.RecordEvent(r, event, login, err)
+}
+
+// OptionalAuthMiddleware calls Service.OptionalAuthMiddleware on the default
+// Service.
+func OptionalAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defaultService.OptionalAuthMiddleware(next)(w, r)
+	}
+}
+
+// RequireAuthMiddleware calls Service.RequireAuthMiddleware on the default
+// Service.
+func RequireAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defaultService.RequireAuthMiddleware(next)(w, r)
+	}
+}
+
+// RequireScopeMiddleware calls Service.RequireScopeMiddleware on the default
+// Service.
+func RequireScopeMiddleware(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defaultService.RequireScopeMiddleware(scope, next)(w, r)
+	}
+}
+
+// AdminAuthMiddleware calls Service.AdminAuthMiddleware on the default
+// Service.
+func AdminAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defaultService.AdminAuthMiddleware(next)(w, r)
+	}
+}
+
+// AdminScopeMiddleware calls Service.AdminScopeMiddleware on the default
+// Service.
+func AdminScopeMiddleware(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defaultService.AdminScopeMiddleware(scope, next)(w, r)
+	}
+}
@@ -0,0 +1,30 @@
+package auth
+
+import "net/http"
+
+// Authentication event types, as passed to the EventRecorder.
+const (
+	EventLogin      = "login"      // an OAuth login
+	EventValidation = "validation" // a refused token or API key
+	EventRefresh    = "refresh"    // a session renewed with a refresh token
+	EventLogout     = "logout"
+	EventRevocation = "revocation" // an admin revoking a user's sessions
+)
+
+// EventRecorder records an authentication event of a request. login is
+// empty when the user is unknown, and err is nil when the event succeeded.
+type EventRecorder func(r *http.Request, event, login string, err error)
+
+// SetEventRecorder makes authentication events be recorded with rec. A nil
+// rec records nothing.
+func (s *Service) SetEventRecorder(rec EventRecorder) {
+	s.eventRecorder = rec
+}
+
+// RecordEvent records an authentication event with the EventRecorder, if
+// one is set.
+func (s *Service) RecordEvent(r *http.Request, event, login string, err error) {
+	if s != nil && s.eventRecorder != nil {
+		s.eventRecorder(r, event, login, err)
+	}
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecordEventOnRefusedToken(t *testing.T) {
+	s := NewService(AuthConfig{JwtSecret: []byte("secret"), Enabled: true})
+	var events []string
+	s.SetEventRecorder(func(r *http.Request, event, login string, err error) {
+		if err == nil {
+			t.Errorf("event %s of %q recorded as a success", event, login)
+		}
+		events = append(events, event)
+	})
+
+	handler := s.OptionalAuthMiddleware(func(w http.ResponseWriter, r *http.Request) {})
+	req := httptest.NewRequest("GET", "/search", nil)
+	req.Header.Set("Authorization", "Bearer not-a-token")
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	if rr.Code != http.StatusUnauthorized || len(events) != 1 || events[0] != EventValidation {
+		t.Errorf("status %d, events %v; want 401 and a validation event", rr.Code, events)
+	}
+
+	// Requests without credentials are not refused tokens.
+	events = nil
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/search", nil))
+	if len(events) != 0 {
+		t.Errorf("events %v for a request without credentials", events)
+	}
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultGitlabURL is the GitLab instance used when no URL is configured.
+const DefaultGitlabURL = "https://gitlab.com"
+
+// GitlabConfig configures login through a GitLab instance, either gitlab.com
+// or a self-hosted one. AllowedGroup is the full path of a group, such as
+// "platform/search"; when set, only its members, including those of its
+// subgroups, may log in.
+type GitlabConfig struct {
+	URL          string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	AllowedGroup string
+}
+
+type gitlabUser struct {
+	ID        int64  `json:"id"`
+	Username  string `json:"username"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+// gitlabDefaults returns cfg with its URL normalized, defaulting to
+// gitlab.com.
+func gitlabDefaults(cfg GitlabConfig) GitlabConfig {
+	cfg.URL = strings.TrimRight(cfg.URL, "/")
+	if cfg.URL == "" {
+		cfg.URL = DefaultGitlabURL
+	}
+	return cfg
+}
+
+// GitlabEnabled returns whether GitLab login is configured.
+func (s *Service) GitlabEnabled() bool {
+	return s != nil && s.cfg.Gitlab.ClientID != ""
+}
+
+// GetGitlabLoginURL returns the GitLab OAuth login URL
+func (s *Service) GetGitlabLoginURL(state string) string {
+	if !s.GitlabEnabled() {
+		return ""
+	}
+	gl := s.cfg.Gitlab
+	scope := "read_user"
+	if gl.AllowedGroup != "" {
+		scope = "read_api"
+	}
+	q := url.Values{
+		"client_id":     {gl.ClientID},
+		"redirect_uri":  {gl.RedirectURL},
+		"response_type": {"code"},
+		"scope":         {scope},
+		"state":         {state},
+	}
+	return gl.URL + "/oauth/authorize?" + q.Encode()
+}
+
+// ExchangeGitlabCode exchanges a GitLab OAuth code for an access token
+func (s *Service) ExchangeGitlabCode(code string) (string, error) {
+	if !s.GitlabEnabled() {
+		return "", errors.New("gitlab auth not configured")
+	}
+	gl := s.cfg.Gitlab
+	data := url.Values{
+		"client_id":     {gl.ClientID},
+		"client_secret": {gl.ClientSecret},
+		"code":          {code},
+		"grant_type":    {"authorization_code"},
+		"redirect_uri":  {gl.RedirectURL},
+	}
+
+	req, err := http.NewRequest("POST", gl.URL+"/oauth/token", strings.NewReader(data.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := gitlabDo(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			fmt.Printf("Failed to close response body: %v\n", err)
+		}
+	}()
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if result.AccessToken == "" {
+		return "", fmt.Errorf("failed to get access token")
+	}
+	return result.AccessToken, nil
+}
+
+// GetGitlabUser fetches user info from the GitLab API. The GitLab username
+// becomes the user's login.
+func (s *Service) GetGitlabUser(accessToken string) (*GithubUser, error) {
+	if !s.GitlabEnabled() {
+		return nil, errors.New("gitlab auth not configured")
+	}
+	gl := s.cfg.Gitlab
+	req, err := http.NewRequest("GET", gl.URL+"/api/v4/user", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := gitlabDo(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			fmt.Printf("Failed to close response body: %v\n", err)
+		}
+	}()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("GitLab API returned status %d", resp.StatusCode)
+	}
+
+	var user gitlabUser
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return nil, err
+	}
+	if user.Username == "" {
+		return nil, fmt.Errorf("GitLab API returned no username")
+	}
+
+	if gl.AllowedGroup != "" {
+		if !isGroupMember(gl.URL, accessToken, user.ID, gl.AllowedGroup) {
+			return nil, fmt.Errorf("user is not a member of the required group")
+		}
+	}
+
+	return &GithubUser{
+		Login:     user.Username,
+		Name:      user.Name,
+		Email:     user.Email,
+		AvatarURL: user.AvatarURL,
+		Provider:  ProviderGitlab,
+	}, nil
+}
+
+// isGroupMember checks if the user is a member of the group, directly or
+// through an ancestor group.
+func isGroupMember(gitlabURL, accessToken string, userID int64, group string) bool {
+	u := fmt.Sprintf("%s/api/v4/groups/%s/members/all/%d", gitlabURL, url.PathEscape(group), userID)
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := gitlabDo(req)
+	if err != nil {
+		return false
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			fmt.Printf("Failed to close response body: %v\n", err)
+		}
+	}()
+
+	// GitLab answers 404 both for non-members and for groups the user
+	// cannot see.
+	return resp.StatusCode == 200
+}
+
+func gitlabDo(req *http.Request) (*http.Response, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return client.Do(req)
+}
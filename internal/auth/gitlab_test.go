@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestGitlab serves the GitLab endpoints used for login. Only user 7 is a
+// member of the group acme/search.
+func newTestGitlab(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("ParseForm: %v", err)
+		}
+		if r.Form.Get("grant_type") != "authorization_code" || r.Form.Get("client_secret") != "gl-secret" || r.Form.Get("redirect_uri") != "http://localhost/gitlab" {
+			t.Errorf("unexpected token request %v", r.Form)
+		}
+		if r.Form.Get("code") != "good-code" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token":"gl-token","token_type":"Bearer"}`))
+	})
+	mux.HandleFunc("GET /api/v4/user", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer gl-token":
+			_ = json.NewEncoder(w).Encode(map[string]any{"id": 7, "username": "alice", "name": "Alice", "email": "alice@example.com", "avatar_url": "https://gitlab/a.png"})
+		case "Bearer outsider":
+			_ = json.NewEncoder(w).Encode(map[string]any{"id": 8, "username": "mallory"})
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+	mux.HandleFunc("GET /api/v4/groups/{group}/members/all/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("group") != "acme/search" || r.PathValue("id") != "7" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":7,"username":"alice","access_level":30}`))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func initTestGitlab(url, group string) {
+	InitializeAuth("secret", "", "", "", "", true)
+	ConfigureGitlab(GitlabConfig{URL: url + "/", ClientID: "gl-id", ClientSecret: "gl-secret", RedirectURL: "http://localhost/gitlab", AllowedGroup: group})
+}
+
+func TestGetGitlabLoginURL(t *testing.T) {
+	defaultService = nil
+	ConfigureGitlab(GitlabConfig{ClientID: "gl-id"})
+	if GitlabEnabled() || GetGitlabLoginURL("s") != "" {
+		t.Error("GitLab should not be enabled before InitializeAuth")
+	}
+
+	InitializeAuth("secret", "", "", "", "", true)
+	ConfigureGitlab(GitlabConfig{ClientID: "gl-id", RedirectURL: "http://localhost/gitlab"})
+	want := "https://gitlab.com/oauth/authorize?client_id=gl-id&redirect_uri=http%3A%2F%2Flocalhost%2Fgitlab&response_type=code&scope=read_user&state=s"
+	if got := GetGitlabLoginURL("s"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	initTestGitlab("https://git.example.com", "acme/search")
+	got := GetGitlabLoginURL("s")
+	if !strings.HasPrefix(got, "https://git.example.com/oauth/authorize?") || !strings.Contains(got, "scope=read_api") {
+		t.Errorf("a self-hosted instance with a group should ask for read_api: %q", got)
+	}
+	if p := Providers(); len(p) != 1 || p[0] != "gitlab" {
+		t.Errorf("Providers() = %v", p)
+	}
+}
+
+func TestGitlabLogin(t *testing.T) {
+	server := newTestGitlab(t)
+	initTestGitlab(server.URL, "")
+
+	token, err := ExchangeGitlabCode("good-code")
+	if err != nil || token != "gl-token" {
+		t.Fatalf("ExchangeGitlabCode = %q, %v", token, err)
+	}
+	if _, err := ExchangeGitlabCode("bad-code"); err == nil {
+		t.Error("expected an error for a rejected code")
+	}
+
+	user, err := GetGitlabUser(token)
+	if err != nil {
+		t.Fatalf("GetGitlabUser: %v", err)
+	}
+	if !reflect.DeepEqual(*user, GithubUser{Login: "alice", Name: "Alice", Email: "alice@example.com", AvatarURL: "https://gitlab/a.png", Provider: ProviderGitlab}) {
+		t.Errorf("unexpected user %+v", user)
+	}
+	if _, err := GetGitlabUser("expired"); err == nil || !strings.Contains(err.Error(), "status 401") {
+		t.Errorf("expected a status error, got %v", err)
+	}
+}
+
+func TestGitlabLogin_AllowedGroup(t *testing.T) {
+	server := newTestGitlab(t)
+	initTestGitlab(server.URL, "acme/search")
+
+	if user, err := GetGitlabUser("gl-token"); err != nil || user.Login != "alice" {
+		t.Errorf("a group member should log in: %+v, %v", user, err)
+	}
+	if _, err := GetGitlabUser("outsider"); err == nil || !strings.Contains(err.Error(), "required group") {
+		t.Errorf("expected a group error, got %v", err)
+	}
+	if isGroupMember(server.URL, "gl-token", 7, "acme") {
+		t.Error("membership of a different group should not count")
+	}
+}
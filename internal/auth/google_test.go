@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestGoogle points the Google endpoints at a fake server for the test.
+// Tokens name the account they belong to.
+func newTestGoogle(t *testing.T) {
+	t.Helper()
+	accounts := map[string]map[string]any{
+		"workspace":  {"email": "alice@example.com", "email_verified": true, "name": "Alice", "picture": "https://g/a.png", "hd": "example.com"},
+		"personal":   {"email": "bob@gmail.com", "email_verified": true, "name": "Bob"},
+		"unverified": {"email": "eve@example.com", "email_verified": false, "hd": "example.com"},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("ParseForm: %v", err)
+		}
+		if r.Form.Get("grant_type") != "authorization_code" || r.Form.Get("client_secret") != "g-secret" {
+			t.Errorf("unexpected token request %v", r.Form)
+		}
+		if _, ok := accounts[r.Form.Get("code")]; !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": r.Form.Get("code")})
+	})
+	mux.HandleFunc("GET /userinfo", func(w http.ResponseWriter, r *http.Request) {
+		account, ok := accounts[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(account)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	authURL, tokenURL, userInfoURL := googleAuthURL, googleTokenURL, googleUserInfoURL
+	googleTokenURL, googleUserInfoURL = server.URL+"/token", server.URL+"/userinfo"
+	t.Cleanup(func() { googleAuthURL, googleTokenURL, googleUserInfoURL = authURL, tokenURL, userInfoURL })
+}
+
+func initTestGoogle(domain string) {
+	InitializeAuth("secret", "gh-id", "", "", "", true)
+	ConfigureGoogle(GoogleConfig{ClientID: "g-id", ClientSecret: "g-secret", RedirectURL: "http://localhost/google", AllowedDomain: domain})
+}
+
+func TestGetGoogleLoginURL(t *testing.T) {
+	defaultService = nil
+	if GoogleEnabled() || GetGoogleLoginURL("s") != "" {
+		t.Error("Google should not be enabled before InitializeAuth")
+	}
+
+	initTestGoogle("")
+	want := "https://accounts.google.com/o/oauth2/v2/auth?client_id=g-id&redirect_uri=http%3A%2F%2Flocalhost%2Fgoogle&response_type=code&scope=openid+email+profile&state=s"
+	if got := GetGoogleLoginURL("s"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if p := Providers(); len(p) != 2 || p[0] != "github" || p[1] != "google" {
+		t.Errorf("Providers() = %v", p)
+	}
+
+	initTestGoogle("example.com")
+	if got := GetGoogleLoginURL("s"); !strings.Contains(got, "&hd=example.com&") {
+		t.Errorf("expected an hd hint: %q", got)
+	}
+}
+
+func TestGoogleLogin(t *testing.T) {
+	newTestGoogle(t)
+	initTestGoogle("")
+
+	token, err := ExchangeGoogleCode("personal")
+	if err != nil || token != "personal" {
+		t.Fatalf("ExchangeGoogleCode = %q, %v", token, err)
+	}
+	if _, err := ExchangeGoogleCode("bad-code"); err == nil {
+		t.Error("expected an error for a rejected code")
+	}
+
+	user, err := GetGoogleUser(token)
+	if err != nil {
+		t.Fatalf("GetGoogleUser: %v", err)
+	}
+	if !reflect.DeepEqual(*user, GithubUser{Login: "bob@gmail.com", Name: "Bob", Email: "bob@gmail.com", Provider: ProviderGoogle}) {
+		t.Errorf("unexpected user %+v", user)
+	}
+	if _, err := GetGoogleUser("unverified"); err == nil || !strings.Contains(err.Error(), "verified") {
+		t.Errorf("expected an unverified email error, got %v", err)
+	}
+	if _, err := GetGoogleUser("expired"); err == nil || !strings.Contains(err.Error(), "status 401") {
+		t.Errorf("expected a status error, got %v", err)
+	}
+}
+
+func TestGoogleLogin_AllowedDomain(t *testing.T) {
+	newTestGoogle(t)
+	initTestGoogle("Example.com")
+
+	if user, err := GetGoogleUser("workspace"); err != nil || user.Login != "alice@example.com" || user.AvatarURL != "https://g/a.png" {
+		t.Errorf("a workspace account should log in: %+v, %v", user, err)
+	}
+	if _, err := GetGoogleUser("personal"); err == nil || !strings.Contains(err.Error(), "required domain") {
+		t.Errorf("expected a domain error, got %v", err)
+	}
+}
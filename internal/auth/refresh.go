@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+)
+
+// Default token lifetimes, used when a configuration does not set them.
+const (
+	// DefaultAccessTokenTTL is how long a JWT signs its user in. Clients
+	// renew it before then with their refresh token.
+	DefaultAccessTokenTTL = 15 * time.Minute
+	// DefaultRefreshTokenTTL is how long a refresh token stays usable. Every
+	// refresh replaces the token with a new one, so a session lasts as long
+	// as it is used at least this often.
+	DefaultRefreshTokenTTL = 7 * 24 * time.Hour
+	// DefaultSessionMaxAge is how long a session lasts however often it is
+	// refreshed, after which its user signs in again, so that changes to the
+	// user's organizations and teams take effect.
+	DefaultSessionMaxAge = 30 * 24 * time.Hour
+)
+
+// Lifetimes holds the lifetimes of the tokens of a session.
+type Lifetimes struct {
+	AccessToken  time.Duration // JWT expiry and its cookie's lifetime
+	RefreshToken time.Duration // refresh token expiry and its cookie's lifetime
+	Session      time.Duration // maximum age of a session since its sign-in
+	ClockSkew    time.Duration // tolerated difference between the clocks of token issuers and validators
+}
+
+// lifetimeDefaults returns l with its zero token lifetimes set to the
+// defaults.
+func lifetimeDefaults(l Lifetimes) Lifetimes {
+	if l.AccessToken <= 0 {
+		l.AccessToken = DefaultAccessTokenTTL
+	}
+	if l.RefreshToken <= 0 {
+		l.RefreshToken = DefaultRefreshTokenTTL
+	}
+	if l.Session <= 0 {
+		l.Session = DefaultSessionMaxAge
+	}
+	return l
+}
+
+// AccessTokenTTL returns how long a JWT signs its user in.
+func (s *Service) AccessTokenTTL() time.Duration {
+	if s == nil || s.cfg.Lifetimes.AccessToken <= 0 {
+		return DefaultAccessTokenTTL
+	}
+	return s.cfg.Lifetimes.AccessToken
+}
+
+// RefreshTokenTTL returns how long a refresh token stays usable.
+func (s *Service) RefreshTokenTTL() time.Duration {
+	if s == nil || s.cfg.Lifetimes.RefreshToken <= 0 {
+		return DefaultRefreshTokenTTL
+	}
+	return s.cfg.Lifetimes.RefreshToken
+}
+
+// SessionMaxAge returns how long a session lasts since its sign-in.
+func (s *Service) SessionMaxAge() time.Duration {
+	if s == nil || s.cfg.Lifetimes.Session <= 0 {
+		return DefaultSessionMaxAge
+	}
+	return s.cfg.Lifetimes.Session
+}
+
+// RefreshCookie is the HttpOnly cookie carrying the refresh token. It is only
+// sent to the /auth endpoints.
+const RefreshCookie = "refresh_token"
+
+// refreshTokenPrefix starts every refresh token, so that leaked tokens are
+// easy to spot.
+const refreshTokenPrefix = "rsr_"
+
+// NewRefreshToken generates a random refresh token and returns it along with
+// the hash under which it is stored.
+func NewRefreshToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = refreshTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken returns the hash under which a refresh token is stored.
+// Like API keys, refresh tokens carry enough entropy for a fast hash.
+func HashRefreshToken(token string) string {
+	return HashAPIKey(token)
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestNewRefreshToken(t *testing.T) {
+	token, hash, err := NewRefreshToken()
+	if err != nil {
+		t.Fatalf("NewRefreshToken: %v", err)
+	}
+	if !strings.HasPrefix(token, refreshTokenPrefix) || hash != HashRefreshToken(token) {
+		t.Errorf("unexpected token %q with hash %q", token, hash)
+	}
+	if other, _, _ := NewRefreshToken(); other == token {
+		t.Error("NewRefreshToken returned the same token twice")
+	}
+}
+
+func TestConfigureLifetimes(t *testing.T) {
+	original := defaultService
+	defer func() { defaultService = original }()
+
+	InitializeAuth("secret", "client", "secret", "url", "", true)
+	if AccessTokenTTL() != DefaultAccessTokenTTL || RefreshTokenTTL() != DefaultRefreshTokenTTL {
+		t.Errorf("unexpected default lifetimes %v, %v", AccessTokenTTL(), RefreshTokenTTL())
+	}
+
+	ConfigureLifetimes(Lifetimes{AccessToken: time.Hour, ClockSkew: time.Minute})
+	if AccessTokenTTL() != time.Hour || RefreshTokenTTL() != DefaultRefreshTokenTTL {
+		t.Errorf("unexpected lifetimes %v, %v", AccessTokenTTL(), RefreshTokenTTL())
+	}
+
+	token, err := GenerateJWT(&GithubUser{Login: "alice"})
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+	claims := &Claims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		t.Fatalf("ParseUnverified: %v", err)
+	}
+	if d := time.Until(claims.ExpiresAt.Time); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("token expires in %v, want about an hour", d)
+	}
+
+	// A token that expired within the clock skew is still accepted.
+	sign := func(expired time.Duration) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+			Login:            "alice",
+			RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(-expired))},
+		})
+		s, err := token.SignedString(defaultService.cfg.JwtSecret)
+		if err != nil {
+			t.Fatalf("SignedString: %v", err)
+		}
+		return s
+	}
+	if _, err := ValidateJWT(sign(30 * time.Second)); err != nil {
+		t.Errorf("token expired within the clock skew was rejected: %v", err)
+	}
+	if _, err := ValidateJWT(sign(2 * time.Minute)); err == nil {
+		t.Error("token expired beyond the clock skew was accepted")
+	}
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+// ErrTokenRevoked is returned by ValidateJWT for a token that was revoked
+// before it expired.
+var ErrTokenRevoked = errors.New("token revoked")
+
+// revocationTimeout bounds a revocation check, so that a slow store fails
+// the request rather than holding it.
+const revocationTimeout = 5 * time.Second
+
+// RevocationCheck reports whether a token was revoked, given its id, the
+// login of its user and when it was issued.
+type RevocationCheck func(ctx context.Context, jti, login string, issuedAt time.Time) (bool, error)
+
+// SetRevocationCheck makes ValidateJWT refuse the tokens check reports as
+// revoked. A nil check accepts every token until it expires.
+func (s *Service) SetRevocationCheck(check RevocationCheck) {
+	s.revocationCheck = check
+}
+
+// checkRevoked returns ErrTokenRevoked when the token of claims was revoked.
+// A failing check refuses the token too.
+func (s *Service) checkRevoked(ctx context.Context, claims *Claims) error {
+	if s.revocationCheck == nil {
+		return nil
+	}
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+	ctx, cancel := context.WithTimeout(ctx, revocationTimeout)
+	defer cancel()
+	revoked, err := s.revocationCheck(ctx, claims.ID, claims.Login, issuedAt)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// newTokenID returns a random id for a JWT, under which it can be revoked.
+func newTokenID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
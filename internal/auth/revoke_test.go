@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidateJWTRevoked(t *testing.T) {
+	original := defaultService
+	defer func() { defaultService = original }()
+	defer SetRevocationCheck(nil)
+
+	InitializeAuth("secret", "client", "secret", "url", "", true)
+	token, err := GenerateJWT(&GithubUser{Login: "alice"})
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+	claims, err := ParseJWT(token)
+	if err != nil || claims.ID == "" {
+		t.Fatalf("ParseJWT = %+v, %v; want a token id", claims, err)
+	}
+
+	revoked := map[string]bool{}
+	SetRevocationCheck(func(ctx context.Context, jti, login string, issuedAt time.Time) (bool, error) {
+		if login != "alice" || issuedAt.IsZero() {
+			t.Errorf("unexpected check of %q issued at %v", login, issuedAt)
+		}
+		return revoked[jti], nil
+	})
+	if _, err := ValidateJWT(token); err != nil {
+		t.Fatalf("ValidateJWT: %v", err)
+	}
+	revoked[claims.ID] = true
+	if _, err := ValidateJWT(token); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("ValidateJWT of a revoked token: err = %v, want ErrTokenRevoked", err)
+	}
+	if _, err := ParseJWT(token); err != nil {
+		t.Errorf("ParseJWT of a revoked token: %v", err)
+	}
+
+	SetRevocationCheck(func(context.Context, string, string, time.Time) (bool, error) {
+		return false, errors.New("store down")
+	})
+	if _, err := ValidateJWT(token); err == nil {
+		t.Error("ValidateJWT accepted a token that could not be checked")
+	}
+}
+
+func TestValidateJWTRevocationContext(t *testing.T) {
+	s := NewService(AuthConfig{JwtSecret: []byte("secret"), Enabled: true})
+	token, err := s.GenerateJWT(&GithubUser{Login: "alice"})
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+	type key struct{}
+	s.SetRevocationCheck(func(ctx context.Context, jti, login string, issuedAt time.Time) (bool, error) {
+		if ctx.Value(key{}) != "request" {
+			t.Error("the check did not get the caller's context")
+		}
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("the check is not bounded by a timeout")
+		}
+		return false, ctx.Err()
+	})
+	ctx := context.WithValue(context.Background(), key{}, "request")
+	if _, err := s.ValidateJWT(ctx, token); err != nil {
+		t.Fatalf("ValidateJWT: %v", err)
+	}
+
+	// A request that went away does not keep the check going.
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := s.ValidateJWT(ctx, token); !errors.Is(err, context.Canceled) {
+		t.Errorf("ValidateJWT with a canceled context: err = %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+)
+
+// SAML service provider endpoints, under SAMLConfig.RootURL.
+const (
+	SAMLMetadataPath = "/auth/saml/metadata"
+	SAMLACSPath      = "/auth/saml/acs"
+)
+
+// Default SAML attributes of the user's name and email address.
+const (
+	DefaultSAMLNameAttribute  = "displayName"
+	DefaultSAMLEmailAttribute = "email"
+)
+
+// SAMLConfig configures single sign-on through a SAML 2.0 identity provider,
+// with reposearch as the service provider. Attributes are matched by name or
+// friendly name.
+type SAMLConfig struct {
+	IDPMetadataURL string // metadata of the identity provider; enables SAML login
+	RootURL        string // external URL of the API, under which the SAML endpoints are served
+	EntityID       string // service provider entity ID, the metadata URL by default
+	CertFile       string // PEM certificate of the service provider
+	KeyFile        string // PEM private key of the service provider
+	// LoginAttribute holds the user's login; without it, the subject's
+	// NameID is the login.
+	LoginAttribute string
+	NameAttribute  string
+	EmailAttribute string
+	// GroupsAttribute holds the user's groups, recorded as teams so that
+	// "team:<group>" principals match them.
+	GroupsAttribute string
+}
+
+// samlDefaults returns cfg with the default attribute names it leaves unset.
+func samlDefaults(cfg SAMLConfig) SAMLConfig {
+	cfg.RootURL = strings.TrimRight(cfg.RootURL, "/")
+	if cfg.NameAttribute == "" {
+		cfg.NameAttribute = DefaultSAMLNameAttribute
+	}
+	if cfg.EmailAttribute == "" {
+		cfg.EmailAttribute = DefaultSAMLEmailAttribute
+	}
+	return cfg
+}
+
+// EnableSAML fetches the metadata of the configured SAML identity provider
+// and enables SAML login. It does nothing without an identity provider.
+func (s *Service) EnableSAML(ctx context.Context) error {
+	cfg := s.cfg.SAML
+	if cfg.IDPMetadataURL == "" {
+		return nil
+	}
+	pair, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load SAML key pair: %w", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("parse SAML certificate: %w", err)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return errors.New("SAML private key cannot sign")
+	}
+	root, err := url.Parse(cfg.RootURL)
+	if err != nil || root.Scheme == "" || root.Host == "" {
+		return fmt.Errorf("invalid SAML root URL %q", cfg.RootURL)
+	}
+	idpURL, err := url.Parse(cfg.IDPMetadataURL)
+	if err != nil {
+		return fmt.Errorf("invalid SAML identity provider metadata URL: %w", err)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	idp, err := samlsp.FetchMetadata(ctx, client, *idpURL)
+	if err != nil {
+		return fmt.Errorf("fetch SAML identity provider metadata: %w", err)
+	}
+
+	s.sp = &saml.ServiceProvider{
+		EntityID:    cfg.EntityID,
+		Key:         key,
+		Certificate: cert,
+		HTTPClient:  client,
+		MetadataURL: *root.JoinPath(SAMLMetadataPath),
+		AcsURL:      *root.JoinPath(SAMLACSPath),
+		IDPMetadata: idp,
+	}
+	return nil
+}
+
+// SAMLEnabled returns whether SAML login is enabled.
+func (s *Service) SAMLEnabled() bool {
+	return s != nil && s.sp != nil
+}
+
+// SAMLMetadata returns the service provider metadata to register with the
+// identity provider.
+func (s *Service) SAMLMetadata() ([]byte, error) {
+	if !s.SAMLEnabled() {
+		return nil, errors.New("saml auth not configured")
+	}
+	return xml.MarshalIndent(s.sp.Metadata(), "", "  ")
+}
+
+// SAMLLoginURL returns the identity provider URL that starts a SAML login,
+// and the ID of its request, which the response must answer.
+func (s *Service) SAMLLoginURL() (loginURL, requestID string, err error) {
+	if !s.SAMLEnabled() {
+		return "", "", errors.New("saml auth not configured")
+	}
+	req, err := s.sp.MakeAuthenticationRequest(s.sp.GetSSOBindingLocation(saml.HTTPRedirectBinding), saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return "", "", err
+	}
+	u, err := req.Redirect("", s.sp)
+	if err != nil {
+		return "", "", err
+	}
+	return u.String(), req.ID, nil
+}
+
+// GetSAMLUser verifies the SAML response posted to the assertion consumer
+// service in answer to the request with requestID, and returns its user.
+func (s *Service) GetSAMLUser(r *http.Request, requestID string) (*GithubUser, error) {
+	if !s.SAMLEnabled() {
+		return nil, errors.New("saml auth not configured")
+	}
+	assertion, err := s.sp.ParseResponse(r, []string{requestID})
+	if err != nil {
+		var ire *saml.InvalidResponseError
+		if errors.As(err, &ire) {
+			err = ire.PrivateErr
+		}
+		return nil, fmt.Errorf("invalid SAML response: %w", err)
+	}
+	return s.samlUser(assertion)
+}
+
+// samlUser maps the attributes of a verified assertion to its user.
+func (s *Service) samlUser(a *saml.Assertion) (*GithubUser, error) {
+	cfg := s.cfg.SAML
+	values := func(name string) []string {
+		var vs []string
+		if name == "" {
+			return nil
+		}
+		for _, st := range a.AttributeStatements {
+			for _, attr := range st.Attributes {
+				if attr.Name != name && attr.FriendlyName != name {
+					continue
+				}
+				for _, v := range attr.Values {
+					if v.Value != "" {
+						vs = append(vs, v.Value)
+					}
+				}
+			}
+		}
+		return vs
+	}
+	first := func(name string) string {
+		if vs := values(name); len(vs) > 0 {
+			return vs[0]
+		}
+		return ""
+	}
+
+	user := &GithubUser{
+		Login:    first(cfg.LoginAttribute),
+		Name:     first(cfg.NameAttribute),
+		Email:    first(cfg.EmailAttribute),
+		Provider: ProviderSAML,
+	}
+	if cfg.LoginAttribute == "" && a.Subject != nil && a.Subject.NameID != nil {
+		user.Login = a.Subject.NameID.Value
+	}
+	if user.Login == "" {
+		return nil, errors.New("SAML assertion has no login")
+	}
+	for _, g := range values(cfg.GroupsAttribute) {
+		user.Teams = append(user.Teams, strings.ToLower(g))
+	}
+	return user, nil
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+)
+
+// newTestSAML returns a service with SAML login through a fake identity
+// provider, which only serves its metadata.
+func newTestSAML(t *testing.T, cfg SAMLConfig) *Service {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "reposearch"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	cfg.CertFile = filepath.Join(dir, "sp.crt")
+	cfg.KeyFile = filepath.Join(dir, "sp.key")
+	if err := os.WriteFile(cfg.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cfg.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata" entityID="%[1]s/metadata">
+  <IDPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <KeyDescriptor use="signing">
+      <KeyInfo xmlns="http://www.w3.org/2000/09/xmldsig#"><X509Data><X509Certificate>%[2]s</X509Certificate></X509Data></KeyInfo>
+    </KeyDescriptor>
+    <SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="%[1]s/sso"/>
+  </IDPSSODescriptor>
+</EntityDescriptor>`, srv.URL, base64.StdEncoding.EncodeToString(der))
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg.IDPMetadataURL = srv.URL + "/metadata"
+	s := NewService(AuthConfig{JwtSecret: []byte("secret"), Enabled: true, SAML: cfg})
+	if err := s.EnableSAML(context.Background()); err != nil {
+		t.Fatalf("EnableSAML: %v", err)
+	}
+	return s
+}
+
+func TestEnableSAML(t *testing.T) {
+	if s := NewService(AuthConfig{Enabled: true}); s.EnableSAML(context.Background()) != nil || s.SAMLEnabled() {
+		t.Error("SAML should stay disabled without an identity provider")
+	}
+
+	s := newTestSAML(t, SAMLConfig{RootURL: "https://search.example.com/api/"})
+	if p := s.Providers(); len(p) != 1 || p[0] != "saml" {
+		t.Errorf("providers = %v, want [saml]", p)
+	}
+
+	doc, err := s.SAMLMetadata()
+	if err != nil || !strings.Contains(string(doc), `Location="https://search.example.com/api/auth/saml/acs"`) {
+		t.Errorf("metadata lacks the ACS URL (%v):\n%s", err, doc)
+	}
+
+	loginURL, requestID, err := s.SAMLLoginURL()
+	if err != nil {
+		t.Fatalf("SAMLLoginURL: %v", err)
+	}
+	u, err := url.Parse(loginURL)
+	if err != nil || !strings.HasSuffix(u.Path, "/sso") || u.Query().Get("SAMLRequest") == "" || requestID == "" {
+		t.Errorf("login URL %q, request %q", loginURL, requestID)
+	}
+
+	// A post without a valid response is refused.
+	req := httptest.NewRequest("POST", SAMLACSPath, strings.NewReader("SAMLResponse=bogus"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, err := s.GetSAMLUser(req, requestID); err == nil {
+		t.Error("expected an invalid SAML response to be refused")
+	}
+}
+
+func TestSAMLUser(t *testing.T) {
+	attr := func(name, friendly string, values ...string) saml.Attribute {
+		a := saml.Attribute{Name: name, FriendlyName: friendly}
+		for _, v := range values {
+			a.Values = append(a.Values, saml.AttributeValue{Value: v})
+		}
+		return a
+	}
+	assertion := &saml.Assertion{
+		Subject: &saml.Subject{NameID: &saml.NameID{Value: "alice@example.com"}},
+		AttributeStatements: []saml.AttributeStatement{{Attributes: []saml.Attribute{
+			attr("urn:oid:0.9.2342.19200300.100.1.1", "uid", "alice"),
+			attr("displayName", "", "Alice"),
+			attr("email", "", "alice@example.com"),
+			attr("groups", "", "Search", "SRE"),
+		}}},
+	}
+
+	s := NewService(AuthConfig{SAML: SAMLConfig{GroupsAttribute: "groups"}})
+	user, err := s.samlUser(assertion)
+	want := &GithubUser{Login: "alice@example.com", Name: "Alice", Email: "alice@example.com", Teams: []string{"search", "sre"}, Provider: ProviderSAML}
+	if err != nil || !reflect.DeepEqual(user, want) {
+		t.Errorf("samlUser = %+v, %v; want %+v", user, err, want)
+	}
+
+	// Attributes also match by friendly name.
+	s = NewService(AuthConfig{SAML: SAMLConfig{LoginAttribute: "uid"}})
+	if user, err := s.samlUser(assertion); err != nil || user.Login != "alice" {
+		t.Errorf("samlUser with a login attribute = %+v, %v", user, err)
+	}
+
+	s = NewService(AuthConfig{SAML: SAMLConfig{LoginAttribute: "missing"}})
+	if _, err := s.samlUser(assertion); err == nil {
+		t.Error("expected an error for an assertion without a login")
+	}
+}
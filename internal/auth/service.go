@@ -0,0 +1,31 @@
+package auth
+
+import "github.com/crewjam/saml"
+
+// Service authenticates users and requests with one configuration. Handlers
+// and middleware get it from NewService rather than from package state, so
+// that services with different configurations can run side by side.
+//
+// The methods of a nil Service act as if auth was never configured: auth is
+// disabled and every provider is off.
+type Service struct {
+	cfg              AuthConfig
+	apiKeyLookup     APIKeyLookup
+	revocationCheck  RevocationCheck
+	eventRecorder    EventRecorder
+	repositoryFilter RepositoryFilter
+	sp               *saml.ServiceProvider // set by EnableSAML
+}
+
+// NewService returns a Service for cfg, filling in the defaults of the
+// settings it leaves unset.
+func NewService(cfg AuthConfig) *Service {
+	cfg.Gitlab = gitlabDefaults(cfg.Gitlab)
+	cfg.SAML = samlDefaults(cfg.SAML)
+	cfg.Cookie = cookieDefaults(cfg.Cookie)
+	cfg.Lifetimes = lifetimeDefaults(cfg.Lifetimes)
+	cfg.Admins = normalizeAdmins(cfg.Admins)
+	cfg.AllowedOrgs = allowedOrgs(cfg.AllowedOrg, cfg.AllowedOrgs)
+	cfg.RequiredTeams = requiredTeams(cfg.RequiredTeams)
+	return &Service{cfg: cfg}
+}
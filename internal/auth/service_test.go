@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewService_Defaults(t *testing.T) {
+	s := NewService(AuthConfig{Admins: []string{" Alice ", ""}})
+	if s.cfg.Gitlab.URL != DefaultGitlabURL {
+		t.Errorf("GitLab URL %q, want %q", s.cfg.Gitlab.URL, DefaultGitlabURL)
+	}
+	if s.AccessTokenTTL() != DefaultAccessTokenTTL || s.RefreshTokenTTL() != DefaultRefreshTokenTTL {
+		t.Errorf("lifetimes %v and %v, want the defaults", s.AccessTokenTTL(), s.RefreshTokenTTL())
+	}
+	if len(s.cfg.Admins) != 1 || s.cfg.Admins[0] != "alice" {
+		t.Errorf("admins %q, want [alice]", s.cfg.Admins)
+	}
+}
+
+func TestService_Independent(t *testing.T) {
+	a := NewService(AuthConfig{JwtSecret: []byte("secret-a"), ClientID: "gh", Enabled: true})
+	b := NewService(AuthConfig{JwtSecret: []byte("secret-b"), Google: GoogleConfig{ClientID: "g"}, Enabled: true})
+
+	token, err := a.GenerateJWT(&GithubUser{Login: "alice"})
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+	if user, err := a.ValidateJWT(context.Background(), token); err != nil || user.Login != "alice" {
+		t.Errorf("own token refused: %+v, %v", user, err)
+	}
+	if _, err := b.ValidateJWT(context.Background(), token); err == nil {
+		t.Error("a token of another service's secret should be refused")
+	}
+	if p := a.Providers(); len(p) != 1 || p[0] != "github" {
+		t.Errorf("providers of a: %v", p)
+	}
+	if p := b.Providers(); len(p) != 1 || p[0] != "google" {
+		t.Errorf("providers of b: %v", p)
+	}
+
+	// Neither service touches the default one.
+	if Default() == a || Default() == b {
+		t.Error("NewService should not set the default service")
+	}
+}
+
+func TestService_Nil(t *testing.T) {
+	var s *Service
+	if s.IsAuthEnabled() || len(s.Providers()) != 0 || s.GetGithubLoginURL("state") != "" {
+		t.Error("a nil service should have auth disabled")
+	}
+	if _, err := s.GenerateJWT(&GithubUser{Login: "alice"}); err == nil {
+		t.Error("a nil service should not sign tokens")
+	}
+
+	called := false
+	rr := httptest.NewRecorder()
+	s.OptionalAuthMiddleware(func(w http.ResponseWriter, r *http.Request) { called = true })(rr, httptest.NewRequest("GET", "/", nil))
+	if !called {
+		t.Error("a nil service should let requests through optional auth")
+	}
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// githubAPIURL is a variable so tests can point it at a fake server.
+var githubAPIURL = "https://api.github.com"
+
+// githubTeamPages caps the pages of teams read at login.
+const githubTeamPages = 10
+
+type githubTeam struct {
+	Slug         string `json:"slug"`
+	Organization struct {
+		Login string `json:"login"`
+	} `json:"organization"`
+}
+
+// allowedOrgs returns the organizations of org and orgs, trimmed and without
+// duplicates.
+func allowedOrgs(org string, orgs []string) []string {
+	allowed := []string{}
+	for _, o := range append([]string{org}, orgs...) {
+		o = strings.TrimSpace(o)
+		if o != "" && !slices.ContainsFunc(allowed, func(a string) bool { return strings.EqualFold(a, o) }) {
+			allowed = append(allowed, o)
+		}
+	}
+	return allowed
+}
+
+// requiredTeams returns teams as lowercase "org/team-slug", like the teams
+// read at login.
+func requiredTeams(teams []string) []string {
+	required := []string{}
+	for _, t := range teams {
+		if t = strings.TrimSpace(t); t != "" {
+			required = append(required, strings.ToLower(t))
+		}
+	}
+	return required
+}
+
+// getGithubTeams returns the teams of the user as "org/team-slug", limited
+// to those of orgs unless it is empty.
+func getGithubTeams(accessToken string, orgs []string) ([]string, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	teams := []string{}
+	for page := 1; page <= githubTeamPages; page++ {
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/user/teams?per_page=100&page=%d", githubAPIURL, page), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		req.Header.Set("Accept", "application/vnd.github.v3+json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		var list []githubTeam
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("GitHub API returned status %d for the user's teams", resp.StatusCode)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(&list)
+		}
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range list {
+			inOrg := func(org string) bool { return strings.EqualFold(t.Organization.Login, org) }
+			if len(orgs) == 0 || slices.ContainsFunc(orgs, inOrg) {
+				teams = append(teams, strings.ToLower(t.Organization.Login+"/"+t.Slug))
+			}
+		}
+		if len(list) < 100 {
+			break
+		}
+	}
+	return teams, nil
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestGithub serves the GitHub endpoints used for login. The user is in
+// 101 teams, so they take two pages.
+func newTestGithub(t *testing.T) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /user", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(GithubUser{Login: "alice"})
+	})
+	mux.HandleFunc("GET /orgs/Acme/members/alice", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /user/teams", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var teams []map[string]any
+		switch r.URL.Query().Get("page") {
+		case "1":
+			teams = append(teams, map[string]any{"slug": "Backend", "organization": map[string]any{"login": "Acme"}})
+			for i := 1; i < 100; i++ {
+				teams = append(teams, map[string]any{"slug": fmt.Sprintf("t%d", i), "organization": map[string]any{"login": "other"}})
+			}
+		case "2":
+			teams = append(teams, map[string]any{"slug": "sre", "organization": map[string]any{"login": "acme"}})
+		}
+		_ = json.NewEncoder(w).Encode(teams)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	orig := githubAPIURL
+	githubAPIURL = srv.URL
+	t.Cleanup(func() { githubAPIURL = orig })
+}
+
+func TestGetGithubUser_Teams(t *testing.T) {
+	newTestGithub(t)
+	InitializeAuth("secret", "id", "secret", "http://localhost/callback", "Acme", true)
+	ConfigureGithubTeams(true)
+	t.Cleanup(func() { defaultService = nil })
+
+	if url := GetGithubLoginURL("s"); !strings.Contains(url, "read:org") {
+		t.Errorf("login URL lacks read:org: %s", url)
+	}
+	user, err := GetGithubUser("token")
+	if err != nil {
+		t.Fatalf("GetGithubUser: %v", err)
+	}
+	if want := []string{"acme/backend", "acme/sre"}; !reflect.DeepEqual(user.Teams, want) {
+		t.Errorf("Teams = %v, want %v", user.Teams, want)
+	}
+
+	// Teams survive the round trip through the token.
+	token, err := GenerateJWT(user)
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+	got, err := ValidateJWT(token)
+	if err != nil || !reflect.DeepEqual(got.Teams, user.Teams) {
+		t.Errorf("ValidateJWT = %+v, %v", got, err)
+	}
+
+	if _, err := GetGithubUser("other"); err == nil {
+		t.Error("expected an error when the teams cannot be read")
+	}
+}
+
+func TestGetGithubTeams_AllOrgs(t *testing.T) {
+	newTestGithub(t)
+	teams, err := getGithubTeams("token", nil)
+	if err != nil || len(teams) != 101 || teams[1] != "other/t1" {
+		t.Errorf("getGithubTeams = %d teams, %v", len(teams), err)
+	}
+}
+
+func TestGetGithubUser_AllowedOrgsAndRequiredTeams(t *testing.T) {
+	newTestGithub(t)
+	s := NewService(AuthConfig{
+		JwtSecret:     []byte("secret"),
+		ClientID:      "id",
+		AllowedOrgs:   []string{"nope", "Acme", " acme "},
+		RequiredTeams: []string{"Acme/SRE", "acme/missing"},
+		Enabled:       true,
+	})
+	if want := []string{"nope", "Acme"}; !reflect.DeepEqual(s.cfg.AllowedOrgs, want) {
+		t.Errorf("AllowedOrgs = %v, want %v", s.cfg.AllowedOrgs, want)
+	}
+	if url := s.GetGithubLoginURL("s"); !strings.Contains(url, "read:org") {
+		t.Errorf("login URL lacks read:org: %s", url)
+	}
+
+	user, err := s.GetGithubUser("token")
+	if err != nil {
+		t.Fatalf("GetGithubUser: %v", err)
+	}
+	// Without GithubTeams only the required teams the user is in are kept.
+	if !reflect.DeepEqual(user.Orgs, []string{"Acme"}) || !reflect.DeepEqual(user.Teams, []string{"acme/sre"}) {
+		t.Errorf("Orgs = %v, Teams = %v", user.Orgs, user.Teams)
+	}
+
+	// The memberships survive the round trip through the token.
+	token, err := s.GenerateJWT(user)
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+	got, err := s.ValidateJWT(context.Background(), token)
+	if err != nil || !reflect.DeepEqual(got.Orgs, user.Orgs) || !reflect.DeepEqual(got.Teams, user.Teams) {
+		t.Errorf("ValidateJWT = %+v, %v", got, err)
+	}
+
+	outsider := NewService(AuthConfig{ClientID: "id", AllowedOrgs: []string{"nope"}, Enabled: true})
+	if _, err := outsider.GetGithubUser("token"); err == nil || !strings.Contains(err.Error(), "organization") {
+		t.Errorf("expected an organization error, got %v", err)
+	}
+	teamless := NewService(AuthConfig{ClientID: "id", AllowedOrgs: []string{"Acme"}, RequiredTeams: []string{"acme/missing"}, Enabled: true})
+	if _, err := teamless.GetGithubUser("token"); err == nil || !strings.Contains(err.Error(), "required team") {
+		t.Errorf("expected a team error, got %v", err)
+	}
+}
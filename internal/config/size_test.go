@@ -0,0 +1,35 @@
+package config
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	for in, want := range map[string]ByteSize{
+		"4096":        4096,
+		"512B":        512,
+		"512KB":       512 << 10,
+		"5MB":         5 << 20,
+		"5mb":         5 << 20,
+		"5 MiB":       5 << 20,
+		"1.5GB":       3 << 29,
+		"2G":          2 << 30,
+		"5.24288e+06": 5 << 20,
+	} {
+		got, err := ParseByteSize(in)
+		if err != nil || got != want {
+			t.Errorf("ParseByteSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "MB", "five", "-1MB", "5TB"} {
+		if _, err := ParseByteSize(in); err == nil {
+			t.Errorf("ParseByteSize(%q): expected an error", in)
+		}
+	}
+}
+
+func TestByteSizeString(t *testing.T) {
+	for b, want := range map[ByteSize]string{0: "0B", 100: "100B", 1024: "1KB", 5 << 20: "5MB", 3 << 29: "1536MB", 2 << 30: "2GB"} {
+		if got := b.String(); got != want {
+			t.Errorf("ByteSize(%d).String() = %q; want %q", b, got, want)
+		}
+	}
+}
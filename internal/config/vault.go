@@ -0,0 +1,240 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// vaultPrefix starts a secret that is a reference to HashiCorp Vault,
+// "vault:<path>#<key>".
+const vaultPrefix = "vault:"
+
+// vaultRef matches the references embedded in a secret, "${vault:<path>#<key>}",
+// e.g. the credentials in a database URL.
+var vaultRef = regexp.MustCompile(`\$\{vault:([^#}]+)#([^}]+)\}`)
+
+// VaultSpecification holds how to reach HashiCorp Vault, to resolve secrets
+// that reference it. Address and Token default to the VAULT_ADDR and
+// VAULT_TOKEN variables of the Vault CLI.
+type VaultSpecification struct {
+	Address string `yaml:"address"` // e.g. https://vault.example.com:8200
+	Token   string `yaml:"token"`
+}
+
+// VaultLease is the lease of a secret read from Vault, e.g. dynamic
+// database credentials, which stop working unless it is renewed.
+type VaultLease struct {
+	ID       string
+	Duration time.Duration
+}
+
+// vaultSecret is the part of a Vault read response resolveVault uses.
+type vaultSecret struct {
+	LeaseID       string         `json:"lease_id"`
+	LeaseDuration int            `json:"lease_duration"` // seconds
+	Renewable     bool           `json:"renewable"`
+	Data          map[string]any `json:"data"`
+}
+
+// vaultClient reads secrets from Vault, each path at most once, so that the
+// keys of one set of dynamic credentials come from the same lease.
+type vaultClient struct {
+	spec    VaultSpecification
+	http    *http.Client
+	secrets map[string]vaultSecret
+}
+
+func newVaultClient(spec VaultSpecification) *vaultClient {
+	if spec.Address == "" {
+		spec.Address = os.Getenv("VAULT_ADDR")
+	}
+	if spec.Token == "" {
+		spec.Token = os.Getenv("VAULT_TOKEN")
+	}
+	spec.Address = strings.TrimRight(spec.Address, "/")
+	return &vaultClient{spec: spec, http: &http.Client{Timeout: 10 * time.Second}, secrets: map[string]vaultSecret{}}
+}
+
+// resolveVault replaces the secrets of c that reference Vault, either
+// entirely ("vault:<path>#<key>") or in part ("${vault:<path>#<key>}"), with
+// the values read from Vault, and records the renewable leases of those
+// values in c.
+func resolveVault(c *Specification) error {
+	var vc *vaultClient
+	for name, dst := range secretEnv(c) {
+		if !strings.HasPrefix(*dst, vaultPrefix) && !vaultRef.MatchString(*dst) {
+			continue
+		}
+		if vc == nil {
+			vc = newVaultClient(c.Vault)
+			if vc.spec.Address == "" || vc.spec.Token == "" {
+				return fmt.Errorf("%s_%s references Vault, which requires vault.address and vault.token", envPrefix, name)
+			}
+		}
+		v, err := vc.resolve(*dst)
+		if err != nil {
+			return fmt.Errorf("%s_%s: %w", envPrefix, name, err)
+		}
+		*dst = v
+	}
+	if vc != nil {
+		c.vault = vc.spec
+		for _, s := range vc.secrets {
+			if s.Renewable && s.LeaseID != "" {
+				c.vaultLeases = append(c.vaultLeases, VaultLease{ID: s.LeaseID, Duration: time.Duration(s.LeaseDuration) * time.Second})
+			}
+		}
+	}
+	return nil
+}
+
+// resolve returns value with its Vault references replaced.
+func (vc *vaultClient) resolve(value string) (string, error) {
+	if ref, ok := strings.CutPrefix(value, vaultPrefix); ok {
+		path, key, ok := strings.Cut(ref, "#")
+		if !ok || path == "" || key == "" {
+			return "", fmt.Errorf("invalid Vault reference %q; want vault:<path>#<key>", value)
+		}
+		return vc.read(path, key)
+	}
+	var err error
+	out := vaultRef.ReplaceAllStringFunc(value, func(m string) string {
+		sub := vaultRef.FindStringSubmatch(m)
+		v, rerr := vc.read(sub[1], sub[2])
+		if rerr != nil && err == nil {
+			err = rerr
+		}
+		return v
+	})
+	return out, err
+}
+
+// read returns the value of key in the secret at path. Secrets of the KV
+// version 2 engine nest their values in a second data object.
+func (vc *vaultClient) read(path, key string) (string, error) {
+	s, ok := vc.secrets[path]
+	if !ok {
+		var err error
+		if s, err = vc.get(path); err != nil {
+			return "", err
+		}
+		vc.secrets[path] = s
+	}
+	data := s.Data
+	if inner, ok := data["data"].(map[string]any); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+	v, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no key %q", path, key)
+	}
+	if str, ok := v.(string); ok {
+		return str, nil
+	}
+	return fmt.Sprint(v), nil
+}
+
+// get reads the secret at path.
+func (vc *vaultClient) get(path string) (vaultSecret, error) {
+	var s vaultSecret
+	err := vc.do(context.Background(), http.MethodGet, "/v1/"+strings.TrimPrefix(path, "/"), nil, &s)
+	if err != nil {
+		return s, fmt.Errorf("read vault secret %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// do sends a request to the Vault API and decodes its response into out,
+// when not nil.
+func (vc *vaultClient) do(ctx context.Context, method, path string, body, out any) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, vc.spec.Address+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", vc.spec.Token)
+	resp, err := vc.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("vault returned status %d", resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// RenewVaultLeases keeps the leases of the secrets read from Vault alive
+// until ctx is done, renewing each when two thirds of it have passed. Failed
+// renewals are retried and reported through logf. It returns at once when
+// there is nothing to renew.
+func (s *Specification) RenewVaultLeases(ctx context.Context, logf func(format string, args ...any)) {
+	vc := newVaultClient(s.vault)
+	for _, l := range s.vaultLeases {
+		go vc.renew(ctx, l, logf)
+	}
+}
+
+// renew renews a lease periodically until ctx is done.
+func (vc *vaultClient) renew(ctx context.Context, l VaultLease, logf func(format string, args ...any)) {
+	wait := l.Duration * 2 / 3
+	for {
+		if wait < time.Second {
+			wait = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		var res vaultSecret
+		err := vc.do(ctx, http.MethodPut, "/v1/sys/leases/renew", map[string]any{
+			"lease_id":  l.ID,
+			"increment": int(l.Duration.Seconds()),
+		}, &res)
+		if err != nil {
+			if ctx.Err() == nil {
+				logf("failed to renew vault lease %s: %v", l.ID, err)
+			}
+			wait = min(l.Duration/10, time.Minute)
+			continue
+		}
+		if res.LeaseDuration <= 0 {
+			logf("vault lease %s can no longer be renewed", l.ID)
+			return
+		}
+		wait = time.Duration(res.LeaseDuration) * time.Second * 2 / 3
+	}
+}
+
+// RevokeVaultLeases revokes the leases of the secrets read from Vault, for
+// configurations loaded only to read other settings.
+func (s *Specification) RevokeVaultLeases(ctx context.Context) error {
+	vc := newVaultClient(s.vault)
+	for _, l := range s.vaultLeases {
+		if err := vc.do(ctx, http.MethodPut, "/v1/sys/leases/revoke", map[string]any{"lease_id": l.ID}, nil); err != nil {
+			return fmt.Errorf("revoke vault lease %s: %w", l.ID, err)
+		}
+	}
+	return nil
+}
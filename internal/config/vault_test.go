@@ -0,0 +1,133 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// fakeVault serves a KV version 2 secret and dynamic database credentials,
+// and records the leases it is asked to renew and revoke.
+type fakeVault struct {
+	mu      sync.Mutex
+	reads   map[string]int
+	renewed []string
+	revoked []string
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("X-Vault-Token") != "root" {
+		http.Error(w, "permission denied", http.StatusForbidden)
+		return
+	}
+	var body struct {
+		LeaseID string `json:"lease_id"`
+	}
+	switch r.URL.Path {
+	case "/v1/secret/data/reposearch":
+		f.reads[r.URL.Path]++
+		_, _ = w.Write([]byte(`{"data": {"data": {"jwt": "vault-jwt-secret", "openai": "sk-vault"}, "metadata": {"version": 3}}}`))
+	case "/v1/database/creds/reposearch":
+		f.reads[r.URL.Path]++
+		_, _ = w.Write([]byte(`{"lease_id": "database/creds/reposearch/abc", "lease_duration": 3, "renewable": true, "data": {"username": "v-user", "password": "v-pass"}}`))
+	case "/v1/sys/leases/renew":
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		f.renewed = append(f.renewed, body.LeaseID)
+		_, _ = w.Write([]byte(`{"lease_id": "` + body.LeaseID + `", "lease_duration": 3, "renewable": true}`))
+	case "/v1/sys/leases/revoke":
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		f.revoked = append(f.revoked, body.LeaseID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestVaultSecrets(t *testing.T) {
+	clearTestEnv(t)
+	defer clearTestEnv(t)
+
+	f := &fakeVault{reads: map[string]int{}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("REPOSEARCH_VAULT_TOKEN", "root")
+	t.Setenv("REPOSEARCH_AUTH_JWT_SECRET", "vault:secret/data/reposearch#jwt")
+	t.Setenv("REPOSEARCH_PROVIDER_API_KEY", "vault:secret/data/reposearch#openai")
+	t.Setenv("REPOSEARCH_DB_URL", "postgres://${vault:database/creds/reposearch#username}:${vault:database/creds/reposearch#password}@db/reposearch")
+
+	cfg, err := Load("", pflag.NewFlagSet("test", pflag.ContinueOnError))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Auth.JwtSecret != "vault-jwt-secret" || cfg.APIKey != "sk-vault" || cfg.Database != "postgres://v-user:v-pass@db/reposearch" {
+		t.Errorf("unexpected secrets: jwt %q, api key %q, db %q", cfg.Auth.JwtSecret, cfg.APIKey, cfg.Database)
+	}
+	// Each secret is read once, so both credentials share one lease.
+	if f.reads["/v1/secret/data/reposearch"] != 1 || f.reads["/v1/database/creds/reposearch"] != 1 {
+		t.Errorf("unexpected reads %v", f.reads)
+	}
+	if len(cfg.vaultLeases) != 1 || cfg.vaultLeases[0].ID != "database/creds/reposearch/abc" || cfg.vaultLeases[0].Duration != 3*time.Second {
+		t.Fatalf("unexpected leases %+v", cfg.vaultLeases)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg.RenewVaultLeases(ctx, t.Logf)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f.mu.Lock()
+		n := len(f.renewed)
+		f.mu.Unlock()
+		if n > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	cancel()
+	f.mu.Lock()
+	if len(f.renewed) == 0 || f.renewed[0] != "database/creds/reposearch/abc" {
+		t.Errorf("lease was not renewed: %v", f.renewed)
+	}
+	f.mu.Unlock()
+
+	if err := cfg.RevokeVaultLeases(context.Background()); err != nil || len(f.revoked) != 1 {
+		t.Errorf("RevokeVaultLeases = %v, revoked %v", err, f.revoked)
+	}
+}
+
+func TestVaultErrors(t *testing.T) {
+	clearTestEnv(t)
+	defer clearTestEnv(t)
+
+	f := &fakeVault{reads: map[string]int{}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		name, addr, token, secret, want string
+	}{
+		{"no vault", "", "", "vault:secret/data/reposearch#jwt", "requires vault.address and vault.token"},
+		{"bad reference", srv.URL, "root", "vault:secret/data/reposearch", "invalid Vault reference"},
+		{"missing key", srv.URL, "root", "vault:secret/data/reposearch#nope", `has no key "nope"`},
+		{"missing secret", srv.URL, "root", "vault:secret/data/nope#jwt", "status 404"},
+		{"bad token", srv.URL, "guest", "vault:secret/data/reposearch#jwt", "status 403"},
+	} {
+		t.Setenv("REPOSEARCH_VAULT_ADDRESS", tt.addr)
+		t.Setenv("REPOSEARCH_VAULT_TOKEN", tt.token)
+		t.Setenv("REPOSEARCH_AUTH_JWT_SECRET", tt.secret)
+		_, err := Load("", pflag.NewFlagSet("test", pflag.ContinueOnError))
+		if err == nil || !strings.Contains(err.Error(), tt.want) || !strings.Contains(err.Error(), "REPOSEARCH_AUTH_JWT_SECRET") {
+			t.Errorf("%s: expected an error containing %q, got: %v", tt.name, tt.want, err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Package graphql serves a GraphQL schema over HTTP. Queries are parsed,
+// validated and executed by github.com/graph-gophers/graphql-go; this
+// package adds the transport the API needs: GET and POST requests, the
+// schema as SDL for a GET without a query, and access to the HTTP request
+// from resolvers.
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// maxDepth bounds how deeply selections may nest, so that a single query
+// can't fan out without limit through object fields that lead back to lists.
+const maxDepth = 10
+
+// ID is the GraphQL ID scalar, for resolver arguments and results.
+type ID = graphql.ID
+
+// Schema is an executable schema and the SDL it was parsed from.
+type Schema struct {
+	sdl    string
+	schema *graphql.Schema
+}
+
+// NewSchema parses sdl and binds its Query type to the methods of resolver.
+// Descriptions are written as GraphQL strings. It reports types and fields
+// that have no matching resolver method.
+func NewSchema(sdl string, resolver any) (*Schema, error) {
+	s, err := graphql.ParseSchema(sdl, resolver, graphql.UseStringDescriptions(), graphql.MaxDepth(maxDepth))
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{sdl: sdl, schema: s}, nil
+}
+
+// SDL returns the schema in the GraphQL schema definition language.
+func (s *Schema) SDL() string { return s.sdl }
+
+// Request is a GraphQL request as POSTed in JSON.
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// Response is the result of a request. Data is null when the request could
+// not be executed at all.
+type Response struct {
+	Data   any     `json:"data"`
+	Errors []Error `json:"errors,omitempty"`
+}
+
+// Error is an error of a request, located by the response path of the
+// field that failed, if any.
+type Error struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+// Execute runs req against the schema. Errors of individual fields are
+// reported in the response, with the field set to null; errors in the
+// query itself leave Data null.
+func (s *Schema) Execute(ctx context.Context, req Request) Response {
+	res := s.schema.Exec(ctx, req.Query, req.OperationName, req.Variables)
+	var out Response
+	if len(res.Data) > 0 {
+		out.Data = res.Data
+	}
+	for _, e := range res.Errors {
+		out.Errors = append(out.Errors, Error{Message: e.Message, Path: e.Path})
+	}
+	return out
+}
+
+// Handler serves the schema over HTTP: POST with a JSON Request, or GET
+// with query, operationName and variables (JSON) parameters. A GET without
+// a query returns the schema as SDL.
+func Handler(s *Schema) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			req.Query = r.URL.Query().Get("query")
+			if req.Query == "" {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				_, _ = w.Write([]byte(s.SDL()))
+				return
+			}
+			req.OperationName = r.URL.Query().Get("operationName")
+			if v := r.URL.Query().Get("variables"); v != "" {
+				if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+					http.Error(w, "Invalid variables: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+		case http.MethodPost:
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if strings.TrimSpace(req.Query) == "" {
+			http.Error(w, "query is required", http.StatusBadRequest)
+			return
+		}
+
+		res := s.Execute(context.WithValue(r.Context(), requestKey{}, r), req)
+		w.Header().Set("Content-Type", "application/json")
+		if res.Data == nil && len(res.Errors) > 0 {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		_ = json.NewEncoder(w).Encode(res)
+	})
+}
+
+type requestKey struct{}
+
+// HTTPRequest returns the request a query is being executed for, when it is
+// executed by Handler.
+func HTTPRequest(ctx context.Context) (*http.Request, bool) {
+	r, ok := ctx.Value(requestKey{}).(*http.Request)
+	return r, ok
+}
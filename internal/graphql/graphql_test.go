@@ -0,0 +1,213 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testSDL = `
+schema {
+  query: Query
+}
+
+type Query {
+  """Books, optionally by tag."""
+  books(tags: [String!], first: Int): [Book!]!
+  book(id: ID!): Book
+}
+
+"""A book."""
+type Book {
+  id: ID!
+  title: String!
+  author: Author!
+  broken: String!
+}
+
+type Author {
+  name: String!
+  books: [Book!]!
+}
+`
+
+type book struct {
+	id, title, tag, author string
+}
+
+var books = []book{
+	{id: "1", title: "Go", tag: "lang", author: "ada"},
+	{id: "2", title: "SQL", author: "bob"},
+}
+
+type queryResolver struct{}
+
+func (*queryResolver) Books(args struct {
+	Tags  *[]string
+	First *int32
+}) []*bookResolver {
+	out := []*bookResolver{}
+	for _, b := range books {
+		if args.Tags != nil && b.tag != (*args.Tags)[0] {
+			continue
+		}
+		out = append(out, &bookResolver{b})
+	}
+	if args.First != nil && int(*args.First) < len(out) {
+		out = out[:*args.First]
+	}
+	return out
+}
+
+func (*queryResolver) Book(args struct{ ID ID }) *bookResolver {
+	for _, b := range books {
+		if ID(b.id) == args.ID {
+			return &bookResolver{b}
+		}
+	}
+	return nil
+}
+
+type bookResolver struct{ b book }
+
+func (r *bookResolver) ID() ID                  { return ID(r.b.id) }
+func (r *bookResolver) Title() string           { return r.b.title }
+func (r *bookResolver) Author() *authorResolver { return &authorResolver{r.b.author} }
+func (r *bookResolver) Broken() (string, error) { return "", errors.New("boom") }
+
+type authorResolver struct{ name string }
+
+func (r *authorResolver) Name() string { return r.name }
+func (r *authorResolver) Books() []*bookResolver {
+	var out []*bookResolver
+	for _, b := range books {
+		if b.author == r.name {
+			out = append(out, &bookResolver{b})
+		}
+	}
+	return out
+}
+
+func testSchema(t *testing.T) *Schema {
+	t.Helper()
+	s, err := NewSchema(testSDL, &queryResolver{})
+	if err != nil {
+		t.Fatalf("NewSchema: %v", err)
+	}
+	return s
+}
+
+func execute(t *testing.T, s *Schema, query string, vars map[string]any) (string, []Error) {
+	t.Helper()
+	res := s.Execute(context.Background(), Request{Query: query, Variables: vars})
+	b, err := json.Marshal(res.Data)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	return string(b), res.Errors
+}
+
+func TestExecute(t *testing.T) {
+	s := testSchema(t)
+	tests := []struct {
+		name, query string
+		vars        map[string]any
+		want        string
+	}{
+		{"arguments and aliases", `query { go: book(id: "1") { title } missing: book(id: 3) { id } }`, nil,
+			`{"go":{"title":"Go"},"missing":null}`},
+		{"nested objects", `{ book(id: "2") { author { name books { title } } } }`, nil,
+			`{"book":{"author":{"name":"bob","books":[{"title":"SQL"}]}}}`},
+		{"variables", `query Q($tag: String!, $n: Int = 5) { books(tags: [$tag], first: $n) { id } }`, map[string]any{"tag": "lang"},
+			`{"books":[{"id":"1"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, errs := execute(t, s, tt.query, tt.vars)
+			if len(errs) != 0 || got != tt.want {
+				t.Errorf("got %s, %+v\nwant %s", got, errs, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecute_FieldErrors(t *testing.T) {
+	s := testSchema(t)
+	// A failing non-null field nulls its nullable parent.
+	got, errs := execute(t, s, `{ book(id: "1") { id broken } books { id } }`, nil)
+	if got != `{"book":null,"books":[{"id":"1"},{"id":"2"}]}` {
+		t.Errorf("data = %s", got)
+	}
+	if len(errs) != 1 || errs[0].Message != "boom" || len(errs[0].Path) != 2 || errs[0].Path[1] != "broken" {
+		t.Errorf("errors = %+v", errs)
+	}
+}
+
+func TestExecute_InvalidQueries(t *testing.T) {
+	s := testSchema(t)
+	for _, query := range []string{
+		`{ books { id }`,
+		`{ nope }`,
+		`{ book { id } }`,
+		`{ b: book(id: "1") { author { books { author { books { author { books { author { books { author { books { id } } } } } } } } } } } }`,
+	} {
+		res := s.Execute(context.Background(), Request{Query: query})
+		if res.Data != nil || len(res.Errors) == 0 {
+			t.Errorf("%s: got %+v, want an error and no data", query, res)
+		}
+	}
+}
+
+func TestNewSchema_MissingResolver(t *testing.T) {
+	if _, err := NewSchema(`schema { query: Query } type Query { nope: String }`, &queryResolver{}); err == nil {
+		t.Error("expected an error for a field without a resolver")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	h := Handler(testSchema(t))
+	tests := []struct {
+		method, target, body string
+		status               int
+		want                 string
+	}{
+		{"POST", "/graphql", `{"query":"query ($id: ID!) { book(id: $id) { title } }","variables":{"id":"2"}}`, http.StatusOK, `{"data":{"book":{"title":"SQL"}}}`},
+		{"GET", "/graphql?query=%7Bbooks(first%3A1)%7Bid%7D%7D", "", http.StatusOK, `{"data":{"books":[{"id":"1"}]}}`},
+		{"GET", `/graphql?query=query($n:Int)%7Bbooks(first:$n)%7Bid%7D%7D&variables=%7B%22n%22:1%7D`, "", http.StatusOK, `{"data":{"books":[{"id":"1"}]}}`},
+		{"GET", "/graphql", "", http.StatusOK, "type Query {"},
+		{"POST", "/graphql", `{"query":"{ nope }"}`, http.StatusBadRequest, `{"data":null,"errors":[{"message":`},
+		{"POST", "/graphql", `{"query":""}`, http.StatusBadRequest, "query is required"},
+		{"PUT", "/graphql", "", http.StatusMethodNotAllowed, "Method not allowed"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("%s %s: status %d, body %q", tt.method, tt.target, w.Code, w.Body.String())
+		}
+	}
+}
+
+type pathResolver struct{ got *http.Request }
+
+func (r *pathResolver) Path(ctx context.Context) string {
+	r.got, _ = HTTPRequest(ctx)
+	return r.got.URL.Path
+}
+
+func TestHTTPRequest(t *testing.T) {
+	r := &pathResolver{}
+	s, err := NewSchema(`schema { query: Query } type Query { path: String! }`, r)
+	if err != nil {
+		t.Fatalf("NewSchema: %v", err)
+	}
+	w := httptest.NewRecorder()
+	Handler(s).ServeHTTP(w, httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query":"{ path }"}`)))
+	if r.got == nil || !strings.Contains(w.Body.String(), `"path":"/graphql"`) {
+		t.Errorf("request not available to resolvers: %s", w.Body.String())
+	}
+}
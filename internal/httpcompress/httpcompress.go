@@ -0,0 +1,208 @@
+// Package httpcompress compresses HTTP responses with gzip or deflate when
+// the client accepts it.
+package httpcompress
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MinSize is the smallest response body that is compressed; smaller bodies
+// gain little and cost a round of compression on every request.
+const MinSize = 1024
+
+// Handler compresses the responses of next, negotiated through the
+// Accept-Encoding header. Responses smaller than MinSize, responses that
+// already have a Content-Encoding, event streams, already compressed media
+// and connection upgrades such as WebSockets are left as they are.
+func Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiate(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &writer{ResponseWriter: w, encoding: encoding}
+		defer func() { _ = cw.Close() }()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiate picks gzip or deflate from an Accept-Encoding header, preferring
+// the higher quality and gzip on a tie, or returns "" for neither.
+func negotiate(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "gzip" && name != "deflate" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 && (q > bestQ || (q == bestQ && name == "gzip")) {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+var (
+	gzipPool = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+	zlibPool = sync.Pool{New: func() any { return zlib.NewWriter(io.Discard) }}
+)
+
+// compressor is the part of gzip.Writer and zlib.Writer used here.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// writer buffers the start of a response until it knows whether the body
+// is large enough to compress.
+type writer struct {
+	http.ResponseWriter
+	encoding string
+
+	status  int
+	buf     []byte
+	decided bool
+	c       compressor // nil when passing through
+}
+
+func (w *writer) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.decided {
+		if !w.eligible() {
+			w.start(false)
+		} else if len(w.buf)+len(p) < MinSize {
+			w.buf = append(w.buf, p...)
+			return len(p), nil
+		} else {
+			w.start(true)
+		}
+	}
+	if w.c != nil {
+		return w.c.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// eligible reports whether the response may be compressed, from its status
+// and headers.
+func (w *writer) eligible() bool {
+	h := w.Header()
+	if w.status < 200 || w.status == http.StatusNoContent || w.status == http.StatusNotModified || h.Get("Content-Encoding") != "" {
+		return false
+	}
+	ct := h.Get("Content-Type")
+	for _, skip := range []string{"text/event-stream", "image/", "video/", "audio/", "application/zip", "application/gzip", "font/woff"} {
+		if strings.HasPrefix(ct, skip) {
+			return false
+		}
+	}
+	return true
+}
+
+// start sends the header and any buffered body, compressing what follows
+// when compress is set.
+func (w *writer) start(compress bool) {
+	w.decided = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if compress {
+		h := w.Header()
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		if w.encoding == "gzip" {
+			w.c = gzipPool.Get().(*gzip.Writer)
+		} else {
+			w.c = zlibPool.Get().(*zlib.Writer)
+		}
+		w.c.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) > 0 {
+		buf := w.buf
+		w.buf = nil
+		if w.c != nil {
+			_, _ = w.c.Write(buf)
+		} else {
+			_, _ = w.ResponseWriter.Write(buf)
+		}
+	}
+}
+
+// Flush sends what has been written so far. A response flushed before it
+// reached MinSize is streamed uncompressed.
+func (w *writer) Flush() {
+	if !w.decided {
+		w.start(false)
+	}
+	if w.c != nil {
+		_ = w.c.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes the response, sending a small body uncompressed.
+func (w *writer) Close() error {
+	if !w.decided {
+		if w.status == 0 && len(w.buf) == 0 {
+			return nil // nothing was written; net/http sends an empty 200
+		}
+		w.start(false)
+	}
+	if w.c == nil {
+		return nil
+	}
+	err := w.c.Close()
+	if gz, ok := w.c.(*gzip.Writer); ok {
+		gzipPool.Put(gz)
+	} else {
+		zlibPool.Put(w.c)
+	}
+	w.c = nil
+	return err
+}
+
+// Hijack lets handlers take over the connection when the request was not
+// recognised as an upgrade.
+func (w *writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("httpcompress: response does not support hijacking")
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (w *writer) Unwrap() http.ResponseWriter { return w.ResponseWriter }
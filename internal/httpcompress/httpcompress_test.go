@@ -0,0 +1,103 @@
+package httpcompress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                           "",
+		"identity":                   "",
+		"gzip, deflate, br":          "gzip",
+		"deflate":                    "deflate",
+		"gzip;q=0.5, deflate":        "deflate",
+		"GZIP;q=0.8, deflate;q=0.8":  "gzip",
+		"gzip;q=0":                   "",
+		" br ;q=1, deflate ; q=0.1 ": "deflate",
+	} {
+		if got := negotiate(header); got != want {
+			t.Errorf("negotiate(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	large := strings.Repeat("reposearch ", 200)
+	h := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/large":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Length", "2200")
+			_, _ = io.WriteString(w, large[:1000])
+			_, _ = io.WriteString(w, large[1000:])
+		case "/small":
+			_, _ = io.WriteString(w, "ok")
+		case "/error":
+			http.Error(w, large, http.StatusBadRequest)
+		case "/events":
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(w, large)
+		case "/flushed":
+			_, _ = io.WriteString(w, "data: 1\n\n")
+			w.(http.Flusher).Flush()
+			_, _ = io.WriteString(w, large)
+		case "/not-modified":
+			w.WriteHeader(http.StatusNotModified)
+		}
+	}))
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", accept)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/large", "gzip")
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Content-Length") != "" || rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("unexpected headers %v", rec.Header())
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	if body, _ := io.ReadAll(zr); string(body) != large {
+		t.Errorf("gzip body does not round trip")
+	}
+
+	rec = get("/large", "deflate")
+	fr, err := zlib.NewReader(rec.Body)
+	if err != nil || rec.Header().Get("Content-Encoding") != "deflate" {
+		t.Fatalf("deflate: %v, %v", err, rec.Header())
+	}
+	if body, _ := io.ReadAll(fr); string(body) != large {
+		t.Errorf("deflate body does not round trip")
+	}
+
+	rec = get("/error", "gzip")
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("expected a compressed error with its status, got %d %v", rec.Code, rec.Header())
+	}
+
+	for _, path := range []string{"/small", "/events", "/flushed", "/not-modified"} {
+		rec := get(path, "gzip")
+		if rec.Header().Get("Content-Encoding") != "" {
+			t.Errorf("%s: expected no compression, got %v", path, rec.Header())
+		}
+	}
+	if rec := get("/small", "gzip"); rec.Body.String() != "ok" || rec.Code != http.StatusOK {
+		t.Errorf("small body = %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := get("/not-modified", "gzip"); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("not modified = %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := get("/large", ""); rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != large {
+		t.Errorf("expected an identity response without Accept-Encoding")
+	}
+}
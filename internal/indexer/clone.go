@@ -0,0 +1,194 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/rs/zerolog/log"
+)
+
+// CloneOptions controls how a remote repository is cloned for indexing.
+type CloneOptions struct {
+	URL   string // repository URL
+	Ref   string // branch or tag to check out
+	Token string // optional access token for private repositories
+
+	// Subpath, when set, limits the checkout to this directory using a
+	// sparse checkout.
+	Subpath string
+
+	// Timeout, when positive, bounds the whole clone.
+	Timeout time.Duration
+}
+
+// CloneToTemp performs a shallow, single-ref clone of the repository into a
+// new temporary directory and returns its path. The caller is responsible for
+// removing the directory.
+func CloneToTemp(ctx context.Context, opts CloneOptions) (string, error) {
+	dir, err := os.MkdirTemp("", "reposearch-*")
+	if err != nil {
+		return "", err
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	if err := clone(ctx, dir, opts); err != nil {
+		if rmErr := os.RemoveAll(dir); rmErr != nil {
+			log.Warn().Err(rmErr).Str("dir", dir).Msg("failed to remove temp directory")
+		}
+		return "", err
+	}
+	return dir, nil
+}
+
+// clone checks out opts.Ref into dir, trying it as a branch first and then as a tag.
+func clone(ctx context.Context, dir string, opts CloneOptions) error {
+	var lastErr error
+	for _, name := range candidateRefs(opts.Ref) {
+		r, err := git.PlainCloneContext(ctx, dir, false, cloneOptions(opts, name))
+		if err == nil {
+			if opts.Subpath != "" {
+				return sparseCheckout(r, opts.Subpath)
+			}
+			return nil
+		}
+		lastErr = err
+		if !isRefNotFound(err) {
+			break
+		}
+		// A failed attempt may leave a partial .git directory behind.
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("git clone: %w", lastErr)
+}
+
+// cloneOptions builds the go-git clone options for the given reference.
+func cloneOptions(opts CloneOptions, ref plumbing.ReferenceName) *git.CloneOptions {
+	co := &git.CloneOptions{
+		URL:          opts.URL,
+		Depth:        1,
+		SingleBranch: true,
+		Tags:         git.NoTags,
+		NoCheckout:   opts.Subpath != "",
+	}
+	if ref != "" {
+		co.ReferenceName = ref
+	}
+	if opts.Token != "" {
+		// The token travels in the Authorization header rather than the URL
+		// or process arguments.
+		co.Auth = &githttp.BasicAuth{Username: "x-access-token", Password: opts.Token}
+	}
+	return co
+}
+
+// RemoteRefs lists the branches and tags of the repository at url, by
+// their short names, e.g. "main" or "v1.2.0".
+func RemoteRefs(ctx context.Context, url, token string) ([]string, error) {
+	remote := git.NewRemote(memory.NewStorage(), &gitconfig.RemoteConfig{Name: "origin", URLs: []string{url}})
+	opts := &git.ListOptions{}
+	if token != "" {
+		opts.Auth = &githttp.BasicAuth{Username: "x-access-token", Password: token}
+	}
+	refs, err := remote.ListContext(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("git ls-remote: %w", err)
+	}
+	var names []string
+	for _, r := range refs {
+		if r.Name().IsBranch() || r.Name().IsTag() {
+			names = append(names, r.Name().Short())
+		}
+	}
+	return names, nil
+}
+
+// headCommit returns the hash of the commit checked out in dir, or "" when
+// dir is not inside a git repository.
+func headCommit(dir string) string {
+	r, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return ""
+	}
+	head, err := r.Head()
+	if err != nil {
+		return ""
+	}
+	return head.Hash().String()
+}
+
+// sparseCheckout populates the worktree of a NoCheckout clone with only subpath.
+func sparseCheckout(r *git.Repository, subpath string) error {
+	head, err := r.Head()
+	if err != nil {
+		return fmt.Errorf("git checkout: %w", err)
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		return fmt.Errorf("git checkout: %w", err)
+	}
+	co := &git.CheckoutOptions{SparseCheckoutDirectories: []string{subpath}}
+	if head.Name().IsBranch() {
+		co.Branch = head.Name()
+	} else {
+		co.Hash = head.Hash()
+	}
+	if err := wt.Checkout(co); err != nil {
+		return fmt.Errorf("git sparse checkout %s: %w", subpath, err)
+	}
+	return nil
+}
+
+// CleanSubpath normalizes a repository-relative subpath, rejecting absolute
+// paths and paths that escape the repository root.
+func CleanSubpath(p string) (string, error) {
+	p = strings.TrimSpace(p)
+	if p == "" {
+		return "", nil
+	}
+	p = filepath.ToSlash(filepath.Clean(p))
+	if filepath.IsAbs(p) || strings.HasPrefix(p, "/") || p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("repo subpath must be relative to the repository root: %s", p)
+	}
+	if p == "." {
+		return "", nil
+	}
+	return p, nil
+}
+
+// candidateRefs returns the reference names to try for ref, in order.
+func candidateRefs(ref string) []plumbing.ReferenceName {
+	if ref == "" {
+		return []plumbing.ReferenceName{""}
+	}
+	if plumbing.ReferenceName(ref).IsBranch() || plumbing.ReferenceName(ref).IsTag() {
+		return []plumbing.ReferenceName{plumbing.ReferenceName(ref)}
+	}
+	return []plumbing.ReferenceName{
+		plumbing.NewBranchReferenceName(ref),
+		plumbing.NewTagReferenceName(ref),
+	}
+}
+
+// isRefNotFound reports whether err indicates that the requested ref does not exist.
+func isRefNotFound(err error) bool {
+	var noMatch git.NoMatchingRefSpecError
+	return errors.Is(err, plumbing.ErrReferenceNotFound) || errors.As(err, &noMatch)
+}
@@ -0,0 +1,153 @@
+package indexer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// newTestRepo creates a local repository with a single commit and a v1 tag,
+// returning its URL and the name of the default branch.
+func newTestRepo(t *testing.T) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		t.Fatalf("worktree: %v", err)
+	}
+	for _, name := range []string{"main.go", "services/payments/pay.go"} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte("package main\n"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if _, err := wt.Add(name); err != nil {
+			t.Fatalf("add: %v", err)
+		}
+	}
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	hash, err := wt.Commit("initial", &git.CommitOptions{Author: sig})
+	if err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if _, err := r.CreateTag("v1", hash, nil); err != nil {
+		t.Fatalf("tag: %v", err)
+	}
+	head, err := r.Head()
+	if err != nil {
+		t.Fatalf("head: %v", err)
+	}
+	return "file://" + dir, head.Name().Short()
+}
+
+func TestCloneToTemp(t *testing.T) {
+	url, branch := newTestRepo(t)
+
+	for _, ref := range []string{branch, "v1"} {
+		t.Run(ref, func(t *testing.T) {
+			dir, err := CloneToTemp(context.Background(), CloneOptions{URL: url, Ref: ref})
+			if err != nil {
+				t.Fatalf("CloneToTemp(%s) failed: %v", ref, err)
+			}
+			defer func() { _ = os.RemoveAll(dir) }()
+			if _, err := os.Stat(filepath.Join(dir, "main.go")); err != nil {
+				t.Errorf("Expected main.go in clone: %v", err)
+			}
+		})
+	}
+
+	t.Run("sparse subpath", func(t *testing.T) {
+		dir, err := CloneToTemp(context.Background(), CloneOptions{URL: url, Ref: branch, Subpath: "services/payments"})
+		if err != nil {
+			t.Fatalf("CloneToTemp with subpath failed: %v", err)
+		}
+		defer func() { _ = os.RemoveAll(dir) }()
+		if _, err := os.Stat(filepath.Join(dir, "services/payments/pay.go")); err != nil {
+			t.Errorf("Expected subpath file in clone: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "main.go")); !os.IsNotExist(err) {
+			t.Errorf("Expected main.go to be excluded by sparse checkout, got %v", err)
+		}
+	})
+
+	t.Run("missing ref", func(t *testing.T) {
+		if _, err := CloneToTemp(context.Background(), CloneOptions{URL: url, Ref: "does-not-exist"}); err == nil {
+			t.Error("Expected error for missing ref")
+		}
+	})
+}
+
+func TestRemoteRefs(t *testing.T) {
+	url, branch := newTestRepo(t)
+	refs, err := RemoteRefs(context.Background(), url, "")
+	if err != nil {
+		t.Fatalf("RemoteRefs: %v", err)
+	}
+	slices.Sort(refs)
+	if want := []string{branch, "v1"}; !slices.Equal(refs, want) {
+		t.Errorf("RemoteRefs = %v; want %v", refs, want)
+	}
+	if _, err := RemoteRefs(context.Background(), "file:///does/not/exist", ""); err == nil {
+		t.Error("expected an error for a missing repository")
+	}
+}
+
+func TestCandidateRefs(t *testing.T) {
+	if got := candidateRefs("main"); len(got) != 2 || got[0] != "refs/heads/main" || got[1] != "refs/tags/main" {
+		t.Errorf("Unexpected candidates for short ref: %v", got)
+	}
+	if got := candidateRefs("refs/tags/v1"); len(got) != 1 || got[0] != "refs/tags/v1" {
+		t.Errorf("Unexpected candidates for full ref: %v", got)
+	}
+}
+
+func TestCloneOptionsToken(t *testing.T) {
+	co := cloneOptions(CloneOptions{URL: "https://github.com/o/r", Token: "secret"}, "refs/heads/main")
+	if co.Auth == nil {
+		t.Fatal("Expected auth to be set when a token is provided")
+	}
+	if co.URL != "https://github.com/o/r" {
+		t.Errorf("Token must not be embedded in the URL, got %s", co.URL)
+	}
+	if co.Depth != 1 {
+		t.Errorf("Expected shallow clone, got depth %d", co.Depth)
+	}
+}
+
+func TestCleanSubpath(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{".", "", false},
+		{"services/payments/", "services/payments", false},
+		{"./services//payments", "services/payments", false},
+		{"/etc", "", true},
+		{"../other", "", true},
+		{"services/../../other", "", true},
+	}
+	for _, tt := range tests {
+		got, err := CleanSubpath(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CleanSubpath(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("CleanSubpath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
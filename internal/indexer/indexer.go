@@ -10,6 +10,7 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/karrick/godirwalk"
 	"github.com/rs/zerolog/log"
@@ -48,9 +49,50 @@ type Indexer struct {
 	RepoRoot   string
 	Repository string
 	Ref        string
+	Subpath    string // optional: only index files below this repo-relative directory
 	Client     ai.Client
 	Walker     FileSystemWalker
 	FileReader FileReader
+	LFSMode    LFSMode    // how to treat Git LFS pointer files (default skip)
+	LFSFetcher LFSFetcher // used when LFSMode is LFSModeFetch
+	Dedup      bool       // reuse summaries/vectors of identical content from other repos or refs
+
+	// RepoConfigFields are the fields of the repository's RepoConfigFile
+	// applied to the run; others are ignored. When empty, the file is not
+	// read.
+	RepoConfigFields []string
+
+	// MaxLFSObjectSize is the size in bytes of the largest LFS object
+	// fetched; larger ones are skipped. Zero uses DefaultMaxLFSObjectSize.
+	MaxLFSObjectSize int64
+
+	// Commit is the commit being indexed, recorded with the run. When empty,
+	// Run uses the HEAD of RepoRoot if it is a git checkout.
+	Commit string
+
+	// SummaryOnly leaves chunk content out of the store, keeping only paths,
+	// summaries, vectors and hashes. The API fetches content on demand.
+	SummaryOnly bool
+
+	// DirSummaries enables directory-level rollup summaries in addition to
+	// file-level ones. Rollups are only built when Store implements
+	// store.RollupStore.
+	DirSummaries bool
+
+	// Model versions recorded with generated summaries and vectors.
+	SummaryModel string
+	EmbedModel   string
+
+	// WriteBatchSize is the number of chunks each worker buffers before
+	// writing them to the store in one round trip. When Store implements
+	// store.FileWriter, batches hold whole files and each file is written
+	// atomically.
+	WriteBatchSize int
+
+	stats      runStats
+	rollups    *rollupCollector
+	files      store.FileWriter
+	repoConfig RepoConfig
 }
 
 // hashContent returns the SHA-1 hash of the given content as a hex string.
@@ -73,6 +115,10 @@ func New(s store.ChunkStore, repoRoot string, repository string, clientConfig *a
 		Client:     client,
 		Walker:     &DefaultFileSystemWalker{},
 		FileReader: &DefaultFileReader{},
+		Dedup:      true,
+
+		SummaryModel: modelVersion(clientConfig.Provider, clientConfig.SummaryModel),
+		EmbedModel:   modelVersion(clientConfig.Provider, clientConfig.EmbedModel),
 	}, nil
 }
 
@@ -94,17 +140,29 @@ type workItem struct {
 	content string
 }
 
-// processWorkItem handles the processing of a single file
-func (ix *Indexer) processWorkItem(ctx context.Context, item workItem) error {
-	chunks := naiveChunk(item.path, item.content)
+// processWorkItem handles the processing of a single file, queueing its
+// chunks on the worker's batch.
+func (ix *Indexer) processWorkItem(ctx context.Context, item workItem, batch *chunkBatch) error {
+	relPath := rel(ix.RepoRoot, item.path)
+	lang := ix.repoConfig.language(relPath)
+	summarize := ix.repoConfig.summarize(relPath)
+	chunks := lineChunks(item.content, ix.repoConfig.ChunkLines)
+	file := &pendingFile{relPath: relPath, lang: lang}
+	defer ix.closeFile(ctx, batch, file)
+	// A chunk that has been started is always completed, even if the run is
+	// cancelled, so the store never holds a half-written chunk.
+	chunkCtx := context.WithoutCancel(ctx)
 	for _, ch := range chunks {
-		relPath := rel(ix.RepoRoot, item.path)
-		lang := guessLang(item.path)
+		if ctx.Err() != nil {
+			file.incomplete = true
+			break
+		}
+
 		hash := hashContent(ch.Content)
 
 		var needSummary, needEmbed bool
 
-		meta, found, err := ix.Store.GetChunkMeta(ctx, ix.Repository, relPath, ch.LineStart, ch.LineEnd)
+		meta, found, err := ix.Store.GetChunkMeta(chunkCtx, ix.Repository, relPath, ch.LineStart, ch.LineEnd)
 		if err != nil {
 			// If there's an error getting metadata, we need both summary and embedding
 			needSummary = true
@@ -115,57 +173,120 @@ func (ix *Indexer) processWorkItem(ctx context.Context, item workItem) error {
 			needEmbed = !found || meta.ContentHash != hash || !meta.HasSummaryVec
 		}
 
-		var summary string
+		var summary, summaryModel, embedModel string
+		var summaryVec []float32 // Only embed the summary
+		reused := false
+		if (needSummary || needEmbed) && ix.Dedup {
+			// Only summaries the current configuration would produce are
+			// reused, not heuristic fallbacks or those of other models.
+			wantSummary := ix.SummaryModel
+			if !summarize {
+				wantSummary = heuristicModel
+			}
+			if dup, ok, err := ix.Store.FindByContentHash(chunkCtx, hash, wantSummary, ix.EmbedModel); err != nil {
+				log.Warn().Err(err).Str("path", item.path).Msg("dedup lookup failed")
+			} else if ok {
+				summary, summaryVec = dup.Summary, dup.SummaryVec
+				summaryModel, embedModel = dup.SummaryModel, dup.EmbedModel
+				needSummary, needEmbed = false, false
+				reused = true
+				ix.stats.chunksDeduplicated.Add(1)
+			}
+		}
+
 		if needSummary {
-			if ix.Client != nil {
+			if !summarize {
+				summary, summaryModel = summarizeHeuristic(ch.Content), heuristicModel
+			} else if ix.Client != nil {
 				// if content is long, we can just summarize the start
-				if len(ch.Content) > 400_000 {
-					if s, err := ix.Client.Summarize(ctx, relPath, lang, ch.Content[:400_000]); err == nil && strings.TrimSpace(s) != "" {
-						summary = s
+				if len(ch.Content) > maxSummarizeBytes {
+					if s, err := ix.Client.Summarize(chunkCtx, relPath, lang, ch.Content[:maxSummarizeBytes]); err == nil && strings.TrimSpace(s) != "" {
+						summary, summaryModel = s, ix.SummaryModel
 					} else {
 						log.Warn().Err(err).Str("path", item.path).Msg("summarization failed, using heuristic")
-						summary = summarizeHeuristic(ch.Content)
+						summary, summaryModel = summarizeHeuristic(ch.Content), heuristicModel
 					}
 				} else {
-					if s, err := ix.Client.Summarize(ctx, relPath, lang, ch.Content); err == nil && strings.TrimSpace(s) != "" {
-						summary = s
+					if s, err := ix.Client.Summarize(chunkCtx, relPath, lang, ch.Content); err == nil && strings.TrimSpace(s) != "" {
+						summary, summaryModel = s, ix.SummaryModel
 					} else {
 						log.Warn().Err(err).Str("path", item.path).Msg("summarization failed, using heuristic")
-						summary = summarizeHeuristic(ch.Content)
+						summary, summaryModel = summarizeHeuristic(ch.Content), heuristicModel
 					}
 				}
 			} else {
 				log.Warn().Str("path", item.path).Msg("no summarizer client, using heuristic")
-				summary = summarizeHeuristic(ch.Content)
+				summary, summaryModel = summarizeHeuristic(ch.Content), heuristicModel
 			}
-		} else {
+			if summaryModel == heuristicModel {
+				ix.stats.summariesFallback.Add(1)
+			} else {
+				ix.stats.summariesGenerated.Add(1)
+			}
+		} else if !reused {
 			// Use existing summary if we don't need a new one
 			summary = meta.Summary
 		}
 
 		id := chunkID(relPath, ch.LineStart, ch.LineEnd)
-		var summaryVec []float32 // Only embed the summary
 		if needEmbed {
 			summaryVec, _ = ix.Client.Embed(summary)
+			if summaryVec != nil {
+				embedModel = ix.EmbedModel
+				ix.stats.embeddingsCreated.Add(1)
+			}
 		}
 		m := models.Chunk{
 			ID: id, Repository: ix.Repository, Ref: ix.Ref, Path: relPath, Language: lang,
 			Summary: summary, Content: ch.Content,
 			LineStart: ch.LineStart, LineEnd: ch.LineEnd,
+			SummaryModel: summaryModel, EmbedModel: embedModel,
+		}
+		if ix.SummaryOnly {
+			m.Content = ""
 		}
 		log.Info().Str("path", relPath).
 			Int("lines", ch.LineEnd-ch.LineStart+1).
 			Bool("need_summary", needSummary).
 			Bool("need_embed", needEmbed).
+			Bool("deduplicated", reused).
 			Msg("indexing chunk")
-		if err := ix.Store.UpsertChunk(ctx, m, summaryVec, hash); err != nil {
-			log.Error().Err(err).Str("path", item.path).Msg("upsert failed")
-		}
+		ix.add(chunkCtx, batch, pendingChunk{
+			chunk: store.ChunkWithVec{
+				Chunk: m, SummaryVec: summaryVec, ContentHash: hash,
+				Symbols: extractSymbols(lang, ch.Content, ch.LineStart),
+			},
+			file:    file,
+			section: fileSection{lineStart: ch.LineStart, lineEnd: ch.LineEnd, summary: summary, vec: summaryVec},
+		})
 	}
 	return nil
 }
 
-func (ix *Indexer) Run(ctx context.Context) error {
+// Run walks the repository and indexes every eligible file. Counters for the
+// run are available afterwards via Report.
+func (ix *Indexer) Run(ctx context.Context) (err error) {
+	ix.stats = runStats{startedAt: time.Now(), commit: ix.Commit}
+	if ix.stats.commit == "" {
+		ix.stats.commit = headCommit(ix.RepoRoot)
+	}
+	if u, ok := ix.Client.(ai.UsageReporter); ok {
+		ix.stats.tokensAtStart = u.TokensUsed()
+	}
+	defer func() {
+		ix.stats.finishedAt = time.Now()
+		ix.stats.err = err
+		ix.recordRun(context.WithoutCancel(ctx))
+	}()
+
+	rs, buildRollups := ix.Store.(store.RollupStore)
+	ix.rollups = nil
+	if buildRollups {
+		ix.rollups = newRollupCollector()
+	}
+	ix.files, _ = ix.Store.(store.FileWriter)
+	ix.repoConfig = ix.loadRepoConfig()
+
 	// Determine number of workers (default to number of CPU cores)
 	numWorkers := runtime.NumCPU()
 	if numWorkers > 8 {
@@ -186,8 +307,15 @@ func (ix *Indexer) Run(ctx context.Context) error {
 			defer wg.Done()
 			log.Debug().Int("worker", workerID).Msg("worker started")
 
+			batch := ix.newChunkBatch()
+			defer ix.flush(ctx, batch)
+
 			for item := range workChan {
-				if err := ix.processWorkItem(ctx, item); err != nil {
+				// After cancellation, drain the queue without starting new files.
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := ix.processWorkItem(ctx, item, batch); err != nil {
 					select {
 					case errorChan <- err:
 					default:
@@ -208,22 +336,33 @@ func (ix *Indexer) Run(ctx context.Context) error {
 	}()
 
 	// Walk files and send them to workers
-	walkErr := ix.Walker.Walk(ix.RepoRoot, &godirwalk.Options{
+	walkErr := ix.Walker.Walk(filepath.Join(ix.RepoRoot, ix.Subpath), &godirwalk.Options{
 		Unsorted: true,
 		Callback: func(path string, de *godirwalk.Dirent) error {
 			// Handle test case where de might be nil (for MockFileSystemWalker)
 			if de != nil && de.IsDir() {
 				return nil
 			}
-			if shouldSkip(path) {
+			if shouldSkip(path) || ix.repoConfig.excluded(rel(ix.RepoRoot, path)) {
+				ix.stats.filesSkipped.Add(1)
 				return nil
 			}
 
 			b, err := ix.FileReader.ReadFile(path)
 			if err != nil {
 				log.Warn().Err(err).Str("path", path).Msg("failed to read file")
+				ix.stats.filesFailed.Add(1)
 				return nil
 			}
+			if p, ok := parseLFSPointer(b); ok {
+				content, ok := ix.resolveLFS(ctx, path, p, b)
+				if !ok {
+					ix.stats.filesSkipped.Add(1)
+					return nil
+				}
+				b = content
+			}
+			ix.stats.filesScanned.Add(1)
 
 			// Send work item to channel
 			select {
@@ -252,6 +391,13 @@ func (ix *Indexer) Run(ctx context.Context) error {
 	default:
 	}
 
+	if walkErr == nil {
+		// Cancellation after the walk finished still means queued files were dropped.
+		walkErr = ctx.Err()
+	}
+	if walkErr == nil && buildRollups {
+		walkErr = ix.buildRollups(ctx, rs, ix.rollups)
+	}
 	return walkErr
 }
 
@@ -267,6 +413,25 @@ func naiveChunk(path, content string) []chunk {
 	return []chunk{{Content: content, LineStart: 1, LineEnd: lines}}
 }
 
+// lineChunks splits the content into chunks of at most n lines, or into a
+// single chunk when n is not positive.
+func lineChunks(content string, n int) []chunk {
+	if n <= 0 {
+		return naiveChunk("", content)
+	}
+	lines := strings.SplitAfter(content, "\n")
+	var chunks []chunk
+	for i := 0; i < len(lines); i += n {
+		end := min(i+n, len(lines))
+		c := strings.Join(lines[i:end], "")
+		if c == "" && len(chunks) > 0 {
+			break // the empty line after a final newline
+		}
+		chunks = append(chunks, chunk{Content: c, LineStart: i + 1, LineEnd: end})
+	}
+	return chunks
+}
+
 // summarizeHeuristic provides a simple heuristic summary by truncating the content.
 func summarizeHeuristic(s string) string {
 	s = strings.TrimSpace(s)
@@ -3,8 +3,10 @@ package indexer
 import (
 	"context"
 	"errors"
+	"fmt"
 	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/karrick/godirwalk"
@@ -23,6 +25,8 @@ func init() {
 type MockIndexableStore struct {
 	GetChunkMetaFunc func(ctx context.Context, repository, path string, ls, le int) (store.ChunkMeta, bool, error)
 	UpsertChunkFunc  func(ctx context.Context, c models.Chunk, summaryVec []float32, contentHash string) error
+	FindByHashFunc   func(ctx context.Context, contentHash, summaryModel, embedModel string) (store.HashMatch, bool, error)
+	UpsertChunksFunc func(ctx context.Context, chunks []store.ChunkWithVec) error
 }
 
 func (m *MockIndexableStore) Search(ctx context.Context, head []float32, k int, opt store.QueryOpts) ([]models.SearchResult, error) {
@@ -44,6 +48,13 @@ func (m *MockIndexableStore) GetChunkMeta(ctx context.Context, repository, path
 	return store.ChunkMeta{}, false, nil
 }
 
+func (m *MockIndexableStore) FindByContentHash(ctx context.Context, contentHash, summaryModel, embedModel string) (store.HashMatch, bool, error) {
+	if m.FindByHashFunc != nil {
+		return m.FindByHashFunc(ctx, contentHash, summaryModel, embedModel)
+	}
+	return store.HashMatch{}, false, nil
+}
+
 func (m *MockIndexableStore) UpsertChunk(ctx context.Context, c models.Chunk, summaryVec []float32, contentHash string) error {
 	if m.UpsertChunkFunc != nil {
 		return m.UpsertChunkFunc(ctx, c, summaryVec, contentHash)
@@ -51,6 +62,20 @@ func (m *MockIndexableStore) UpsertChunk(ctx context.Context, c models.Chunk, su
 	return nil
 }
 
+// UpsertChunks defaults to calling UpsertChunk for each chunk so tests can
+// assert on individual writes.
+func (m *MockIndexableStore) UpsertChunks(ctx context.Context, chunks []store.ChunkWithVec) error {
+	if m.UpsertChunksFunc != nil {
+		return m.UpsertChunksFunc(ctx, chunks)
+	}
+	for _, c := range chunks {
+		if err := m.UpsertChunk(ctx, c.Chunk, c.SummaryVec, c.ContentHash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // MockAIClient implements ai.Client for testing
 type MockAIClient struct {
 	EmbedFunc     func(text string) ([]float32, error)
@@ -664,3 +689,249 @@ func TestInterfaceCompliance(t *testing.T) {
 	var _ FileReader = &MockFileReader{}
 	var _ ai.Client = &MockAIClient{}
 }
+
+// tokenCountingClient is a MockAIClient that also reports token usage.
+type tokenCountingClient struct {
+	MockAIClient
+	tokens atomic.Int64
+}
+
+func (c *tokenCountingClient) Summarize(ctx context.Context, filePath, language, content string) (string, error) {
+	if strings.HasSuffix(filePath, ".sh") {
+		return "", errors.New("provider unavailable")
+	}
+	c.tokens.Add(10)
+	return "summary", nil
+}
+
+func (c *tokenCountingClient) TokensUsed() int64 { return c.tokens.Load() }
+
+func TestIndexer_Report(t *testing.T) {
+	files := map[string]string{
+		"/test/repo/main.go":   "package main",
+		"/test/repo/script.sh": "echo hi",
+		"/test/repo/broken.go": "package broken",
+	}
+	walker := &MockFileSystemWalker{FilesToProcess: []string{"/test/repo/main.go", "/test/repo/script.sh", "/test/repo/missing.go", "/test/repo/broken.go"}}
+	reader := &MockFileReader{Files: files}
+	st := &MockIndexableStore{
+		UpsertChunkFunc: func(ctx context.Context, c models.Chunk, summaryVec []float32, contentHash string) error {
+			if c.Path == "broken.go" {
+				return errors.New("upsert failed")
+			}
+			return nil
+		},
+	}
+	client := &tokenCountingClient{}
+	client.tokens.Store(5)
+
+	ix := NewWithDependencies(st, "/test/repo", "test/repo", client, walker, reader)
+	ix.Ref = "main"
+	if err := ix.Run(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	r := ix.Report()
+	if r.Repository != "test/repo" || r.Ref != "main" {
+		t.Errorf("Unexpected repository/ref: %s@%s", r.Repository, r.Ref)
+	}
+	if r.FilesScanned != 3 {
+		t.Errorf("Expected 3 files scanned, got %d", r.FilesScanned)
+	}
+	if r.FilesFailed != 2 {
+		t.Errorf("Expected 2 failed files (read + upsert), got %d", r.FilesFailed)
+	}
+	if r.ChunksUpserted != 2 {
+		t.Errorf("Expected 2 chunks upserted, got %d", r.ChunksUpserted)
+	}
+	if r.SummariesGenerated != 2 || r.SummariesFallback != 1 || r.EmbeddingsCreated != 3 {
+		t.Errorf("Expected 2 summaries, 1 heuristic summary and 3 embeddings, got %d, %d and %d", r.SummariesGenerated, r.SummariesFallback, r.EmbeddingsCreated)
+	}
+	if r.TokensUsed != 20 {
+		t.Errorf("Expected 20 tokens used during the run, got %d", r.TokensUsed)
+	}
+	if r.StartedAt.IsZero() || r.FinishedAt.Before(r.StartedAt) {
+		t.Errorf("Unexpected run timestamps: %v - %v", r.StartedAt, r.FinishedAt)
+	}
+
+	var buf strings.Builder
+	if err := ix.WriteReport(&buf); err != nil {
+		t.Fatalf("WriteReport failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"chunks_upserted": 2`) {
+		t.Errorf("Expected JSON report to contain chunk count, got %s", buf.String())
+	}
+}
+
+// runRecordingStore records the index runs written to it.
+type runRecordingStore struct {
+	MockIndexableStore
+	runs []models.IndexRun
+}
+
+func (s *runRecordingStore) RecordIndexRun(ctx context.Context, r models.IndexRun) error {
+	s.runs = append(s.runs, r)
+	return nil
+}
+
+func TestIndexer_RecordsRun(t *testing.T) {
+	st := &runRecordingStore{}
+	walker := &MockFileSystemWalker{FilesToProcess: []string{"/test/repo/main.go"}, WalkError: errors.New("walk failed")}
+	ix := NewWithDependencies(st, "/test/repo", "test/repo", &MockAIClient{}, walker, &MockFileReader{})
+	ix.Ref, ix.Commit = "main", "abc123"
+
+	if err := ix.Run(context.Background()); err == nil {
+		t.Fatal("Expected the walk error")
+	}
+	if len(st.runs) != 1 {
+		t.Fatalf("Expected 1 recorded run, got %d", len(st.runs))
+	}
+	r := st.runs[0]
+	if r.Repository != "test/repo" || r.Ref != "main" || r.Commit != "abc123" || !strings.Contains(r.Error, "walk failed") || r.FinishedAt.IsZero() {
+		t.Errorf("Unexpected recorded run: %+v", r)
+	}
+}
+
+func TestIndexer_RunSubpath(t *testing.T) {
+	var walkedRoot string
+	walker := &recordingWalker{root: &walkedRoot}
+	ix := NewWithDependencies(&MockIndexableStore{}, "/test/repo", "test/repo", &MockAIClient{}, walker, &MockFileReader{})
+	ix.Subpath = "services/payments"
+
+	if err := ix.Run(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if walkedRoot != "/test/repo/services/payments" {
+		t.Errorf("Expected walk to start at subpath, got %s", walkedRoot)
+	}
+}
+
+// recordingWalker records the root it was asked to walk.
+type recordingWalker struct {
+	root *string
+}
+
+func (w *recordingWalker) Walk(root string, options *godirwalk.Options) error {
+	*w.root = root
+	return nil
+}
+
+func TestIndexer_Dedup(t *testing.T) {
+	for _, dedup := range []bool{true, false} {
+		t.Run(fmt.Sprintf("dedup=%v", dedup), func(t *testing.T) {
+			var upserted models.Chunk
+			var upsertedVec []float32
+			var lookedUp [2]string
+			st := &MockIndexableStore{
+				FindByHashFunc: func(ctx context.Context, contentHash, summaryModel, embedModel string) (store.HashMatch, bool, error) {
+					if contentHash != hashContent("package shared") {
+						t.Errorf("Unexpected hash lookup %s", contentHash)
+					}
+					lookedUp = [2]string{summaryModel, embedModel}
+					return store.HashMatch{Summary: "shared summary", SummaryVec: []float32{0.9}}, true, nil
+				},
+				UpsertChunkFunc: func(ctx context.Context, c models.Chunk, summaryVec []float32, contentHash string) error {
+					upserted, upsertedVec = c, summaryVec
+					return nil
+				},
+			}
+			calls := 0
+			client := &MockAIClient{
+				SummarizeFunc: func(ctx context.Context, filePath, language, content string) (string, error) {
+					calls++
+					return "fresh summary", nil
+				},
+			}
+			walker := &MockFileSystemWalker{FilesToProcess: []string{"/repo/shared.go"}}
+			reader := &MockFileReader{Files: map[string]string{"/repo/shared.go": "package shared"}}
+			ix := NewWithDependencies(st, "/repo", "repo", client, walker, reader)
+			ix.Dedup = dedup
+			ix.SummaryModel, ix.EmbedModel = "openai/gpt", "openai/embed"
+
+			if err := ix.Run(context.Background()); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if dedup {
+				if calls != 0 {
+					t.Errorf("Expected no summarize calls when reusing, got %d", calls)
+				}
+				if want := [2]string{ix.SummaryModel, ix.EmbedModel}; lookedUp != want {
+					t.Errorf("Expected a lookup for the models %v, got %v", want, lookedUp)
+				}
+				if upserted.Summary != "shared summary" || !reflect.DeepEqual(upsertedVec, []float32{0.9}) {
+					t.Errorf("Expected reused artifacts, got %q %v", upserted.Summary, upsertedVec)
+				}
+				if ix.Report().ChunksDeduplicated != 1 {
+					t.Errorf("Expected 1 deduplicated chunk, got %d", ix.Report().ChunksDeduplicated)
+				}
+			} else if upserted.Summary != "fresh summary" || calls != 1 {
+				t.Errorf("Expected fresh summary when dedup is disabled, got %q (%d calls)", upserted.Summary, calls)
+			}
+		})
+	}
+}
+
+func TestIndexer_SummaryOnly(t *testing.T) {
+	var upserted models.Chunk
+	var hash string
+	st := &MockIndexableStore{
+		UpsertChunkFunc: func(ctx context.Context, c models.Chunk, summaryVec []float32, contentHash string) error {
+			upserted, hash = c, contentHash
+			return nil
+		},
+	}
+	walker := &MockFileSystemWalker{FilesToProcess: []string{"/repo/a.go"}}
+	reader := &MockFileReader{Files: map[string]string{"/repo/a.go": "package a"}}
+	ix := NewWithDependencies(st, "/repo", "repo", &MockAIClient{}, walker, reader)
+	ix.SummaryOnly = true
+
+	if err := ix.Run(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if upserted.Content != "" || upserted.Summary == "" || upserted.Path != "a.go" {
+		t.Errorf("Expected a summary without content, got %+v", upserted)
+	}
+	if hash != hashContent("package a") {
+		t.Errorf("Expected the hash of the file content, got %s", hash)
+	}
+}
+
+func TestIndexer_RunCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var upserted []string
+	st := &MockIndexableStore{
+		UpsertChunkFunc: func(ctx context.Context, c models.Chunk, summaryVec []float32, contentHash string) error {
+			if ctx.Err() != nil {
+				t.Errorf("In-flight chunk should be written with a live context")
+			}
+			upserted = append(upserted, c.Summary)
+			return nil
+		},
+	}
+	client := &MockAIClient{
+		SummarizeFunc: func(ctx context.Context, filePath, language, content string) (string, error) {
+			// Simulate SIGINT arriving while the first chunk is being summarized.
+			cancel()
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			return "ai summary", nil
+		},
+	}
+	walker := &MockFileSystemWalker{FilesToProcess: []string{"/repo/a.go"}}
+	reader := &MockFileReader{Files: map[string]string{"/repo/a.go": "package a"}}
+	ix := NewWithDependencies(st, "/repo", "repo", client, walker, reader)
+
+	err := ix.Run(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if len(upserted) != 1 || upserted[0] != "ai summary" {
+		t.Errorf("Expected the in-flight chunk to complete with its AI summary, got %v", upserted)
+	}
+	if ix.Report().Error == "" {
+		t.Error("Expected the report to record the cancellation")
+	}
+}
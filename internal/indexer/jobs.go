@@ -0,0 +1,432 @@
+package indexer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/seanblong/reposearch/internal/ai"
+	"github.com/seanblong/reposearch/internal/store"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// Settings of the job queue.
+const (
+	maxJobs           = 50                 // jobs returned by List
+	jobRetention      = 7 * 24 * time.Hour // finished jobs are deleted after this long
+	heartbeatInterval = 5 * time.Second    // how often a running job saves its progress
+	staleJobAfter     = time.Minute        // running jobs without a heartbeat for this long are failed
+	defaultPoll       = 2 * time.Second
+)
+
+// ErrJobRunning is returned by Jobs.Enqueue when a job for the same
+// repository and ref, or another maintenance job of the same kind, is
+// already queued or running.
+var ErrJobRunning = errors.New("a job for the same target is already queued or running")
+
+// ErrInvalidJob is wrapped by the errors Jobs.Enqueue returns for jobs it
+// can't run.
+var ErrInvalidJob = errors.New("invalid job")
+
+// JobRequest names a remote repository to index. It is the params of index
+// jobs.
+type JobRequest struct {
+	URL     string `json:"url"`
+	Ref     string `json:"ref,omitempty"`
+	Subpath string `json:"subpath,omitempty"`
+}
+
+// vacuumer is the store method run by vacuum jobs.
+type vacuumer interface {
+	Vacuum(ctx context.Context, repository string) (int64, error)
+}
+
+// Jobs runs background jobs from the queue kept in Queue, in the background
+// of a long-running process such as the API server. The kind of a job is the
+// indexer mode it runs: index clones and indexes the repository of a
+// JobRequest, resummarize and reembed refresh stored chunks, and vacuum
+// prunes soft-deleted ones. Each process runs one job at a time; several
+// processes may share a queue.
+type Jobs struct {
+	Store      store.ChunkStore
+	Queue      store.JobStore
+	Client     *ai.ClientConfig
+	Token      string // optional access token for private repositories
+	DefaultRef string // used when an index request has no ref
+	BatchSize  int    // chunks per batch of resummarize and reembed jobs
+
+	// CloneTimeout, when positive, bounds the clone of each index job.
+	CloneTimeout time.Duration
+
+	// Configure, when set, applies settings such as LFSMode or
+	// WriteBatchSize to each index job's Indexer before it runs.
+	Configure func(*Indexer)
+
+	// PollInterval is how often an idle Run checks for jobs enqueued by
+	// other processes. Jobs enqueued through Enqueue start at once.
+	PollInterval time.Duration
+
+	// HeartbeatInterval is how often a running job saves its progress and
+	// checks whether it was canceled.
+	HeartbeatInterval time.Duration
+
+	wakeOnce sync.Once
+	wake     chan struct{}
+}
+
+// Enqueue validates a job of the given kind and adds it to the queue.
+func (j *Jobs) Enqueue(ctx context.Context, kind Mode, params json.RawMessage, user string) (models.Job, error) {
+	params, key, err := j.prepare(kind, params)
+	if err != nil {
+		return models.Job{}, err
+	}
+	job := models.Job{ID: newJobID(), Kind: string(kind), Params: params, Status: models.JobQueued, User: user, CreatedAt: time.Now()}
+	ok, err := j.Queue.EnqueueJob(ctx, job, key)
+	if err != nil {
+		return models.Job{}, err
+	}
+	if !ok {
+		return models.Job{}, ErrJobRunning
+	}
+	select {
+	case j.wakeup() <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Index enqueues a job indexing the repository of req.
+func (j *Jobs) Index(ctx context.Context, req JobRequest, user string) (models.Job, error) {
+	params, err := json.Marshal(req)
+	if err != nil {
+		return models.Job{}, err
+	}
+	return j.Enqueue(ctx, ModeIndex, params, user)
+}
+
+// prepare validates and normalizes the params of a job and returns the key
+// of the jobs it must not run alongside.
+func (j *Jobs) prepare(kind Mode, params json.RawMessage) (json.RawMessage, string, error) {
+	switch kind {
+	case ModeIndex:
+		var req JobRequest
+		if len(params) > 0 {
+			if err := json.Unmarshal(params, &req); err != nil {
+				return nil, "", fmt.Errorf("%w: %v", ErrInvalidJob, err)
+			}
+		}
+		if req.URL == "" {
+			return nil, "", fmt.Errorf("%w: url is required", ErrInvalidJob)
+		}
+		if req.Ref == "" {
+			req.Ref = j.DefaultRef
+		}
+		subpath, err := CleanSubpath(req.Subpath)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrInvalidJob, err)
+		}
+		req.Subpath = subpath
+		b, err := json.Marshal(req)
+		return b, string(kind) + ":" + req.URL + "@" + req.Ref, err
+	case ModeResummarize, ModeReembed:
+		if _, ok := j.Store.(store.MaintenanceStore); !ok {
+			return nil, "", fmt.Errorf("%w: the store does not support %s jobs", ErrInvalidJob, kind)
+		}
+		return nil, string(kind), nil
+	case ModeVacuum:
+		if _, ok := j.Store.(vacuumer); !ok {
+			return nil, "", fmt.Errorf("%w: the store does not support %s jobs", ErrInvalidJob, kind)
+		}
+		return nil, string(kind), nil
+	}
+	return nil, "", fmt.Errorf("%w: unsupported kind %q (expected index, resummarize, reembed or vacuum)", ErrInvalidJob, kind)
+}
+
+// Get returns the job with the given id.
+func (j *Jobs) Get(ctx context.Context, id string) (models.Job, bool, error) {
+	return j.Queue.GetJob(ctx, id)
+}
+
+// List returns the most recent jobs of a kind, or of every kind when kind is
+// empty, newest first.
+func (j *Jobs) List(ctx context.Context, kind Mode) ([]models.Job, error) {
+	return j.Queue.ListJobs(ctx, string(kind), maxJobs)
+}
+
+// Logs returns the log of a job.
+func (j *Jobs) Logs(ctx context.Context, id string) ([]models.JobLog, error) {
+	return j.Queue.JobLogs(ctx, id)
+}
+
+// Cancel cancels a queued job, or asks the process running a running job to
+// stop it within HeartbeatInterval. It returns the job as updated.
+func (j *Jobs) Cancel(ctx context.Context, id string) (models.Job, bool, error) {
+	return j.Queue.CancelJob(ctx, id, time.Now())
+}
+
+// Run processes queued jobs until ctx is canceled.
+func (j *Jobs) Run(ctx context.Context) {
+	interval := j.PollInterval
+	if interval <= 0 {
+		interval = defaultPoll
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if err := j.RunPending(ctx); err != nil && ctx.Err() == nil {
+			log.Error().Err(err).Msg("job queue failed")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-j.wakeup():
+		}
+	}
+}
+
+// RunPending runs queued jobs, one after the other, until the queue is
+// empty. It also fails the jobs of processes that stopped while running
+// them, and deletes old finished jobs.
+func (j *Jobs) RunPending(ctx context.Context) error {
+	now := time.Now()
+	if n, err := j.Queue.FailStaleJobs(ctx, now.Add(-staleJobAfter), now); err != nil {
+		return err
+	} else if n > 0 {
+		log.Warn().Int64("jobs", n).Msg("failed jobs left running by a stopped process")
+	}
+	if _, err := j.Queue.DeleteFinishedJobs(ctx, now.Add(-jobRetention)); err != nil {
+		return err
+	}
+	for ctx.Err() == nil {
+		job, ok, err := j.Queue.ClaimJob(ctx, time.Now())
+		if err != nil || !ok {
+			return err
+		}
+		j.execute(ctx, job)
+	}
+	return ctx.Err()
+}
+
+func (j *Jobs) wakeup() chan struct{} {
+	j.wakeOnce.Do(func() { j.wake = make(chan struct{}, 1) })
+	return j.wake
+}
+
+// execute runs a claimed job, saving its progress and watching for
+// cancellation while it runs, and records its outcome.
+func (j *Jobs) execute(ctx context.Context, job models.Job) {
+	bg := context.WithoutCancel(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r := &jobRun{queue: j.Queue, id: job.ID}
+	r.logf(bg, "started %s job", job.Kind)
+
+	interval := j.HeartbeatInterval
+	if interval <= 0 {
+		interval = heartbeatInterval
+	}
+	var canceled atomic.Bool
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+			stop, err := j.Queue.HeartbeatJob(bg, job.ID, r.snapshot(), time.Now())
+			if err != nil {
+				log.Warn().Err(err).Str("job", job.ID).Msg("failed to save job progress")
+				continue
+			}
+			if stop && !canceled.Swap(true) {
+				r.logf(bg, "cancel requested")
+				cancel()
+			}
+		}
+	}()
+
+	err := j.run(ctx, job, r)
+	close(done)
+	<-stopped
+
+	status, msg := models.JobSucceeded, ""
+	if err != nil {
+		status, msg = models.JobFailed, err.Error()
+	}
+	if canceled.Load() {
+		status = models.JobCanceled
+	}
+	if msg != "" {
+		r.logf(bg, "%s: %s", status, msg)
+	} else {
+		r.logf(bg, "%s", status)
+	}
+	if err := j.Queue.FinishJob(bg, job.ID, status, msg, r.snapshot(), time.Now()); err != nil {
+		log.Error().Err(err).Str("job", job.ID).Msg("failed to record job outcome")
+	}
+	log.Info().Str("job", job.ID).Str("kind", job.Kind).Str("status", string(status)).Str("error", msg).Msg("job finished")
+}
+
+func (j *Jobs) run(ctx context.Context, job models.Job, r *jobRun) error {
+	switch Mode(job.Kind) {
+	case ModeIndex:
+		var req JobRequest
+		if err := json.Unmarshal(job.Params, &req); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidJob, err)
+		}
+		return j.index(ctx, req, r)
+	case ModeResummarize, ModeReembed:
+		return j.maintain(ctx, Mode(job.Kind), r)
+	case ModeVacuum:
+		return j.vacuum(ctx, r)
+	}
+	return fmt.Errorf("%w: unsupported kind %q", ErrInvalidJob, job.Kind)
+}
+
+func (j *Jobs) index(ctx context.Context, req JobRequest, r *jobRun) error {
+	r.logf(ctx, "cloning %s at %s", req.URL, req.Ref)
+	dir, err := CloneToTemp(ctx, CloneOptions{URL: req.URL, Ref: req.Ref, Token: j.Token, Subpath: req.Subpath, Timeout: j.CloneTimeout})
+	if err != nil {
+		return fmt.Errorf("clone failed: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Warn().Err(err).Str("dir", dir).Msg("failed to remove temp directory")
+		}
+	}()
+
+	ix, err := New(j.Store, dir, req.URL, j.Client)
+	if err != nil {
+		return err
+	}
+	ix.Ref = req.Ref
+	ix.Subpath = req.Subpath
+	if j.Configure != nil {
+		j.Configure(ix)
+	}
+	if ix.Client.Dim() == 0 {
+		return errors.New("embedding dimension must be set")
+	}
+	if err := j.Store.Migrate(ctx, ix.Client.Dim()); err != nil {
+		return err
+	}
+	r.setProgress(func() map[string]int64 { return reportProgress(ix.Report()) })
+	r.logf(ctx, "indexing")
+	err = ix.Run(ctx)
+	rep := ix.Report()
+	r.logf(ctx, "scanned %d files (%d skipped, %d failed), upserted %d chunks",
+		rep.FilesScanned, rep.FilesSkipped, rep.FilesFailed, rep.ChunksUpserted)
+	return err
+}
+
+func (j *Jobs) maintain(ctx context.Context, mode Mode, r *jobRun) error {
+	ms, ok := j.Store.(store.MaintenanceStore)
+	if !ok {
+		return fmt.Errorf("the store does not support %s jobs", mode)
+	}
+	m, err := NewMaintainer(ms, j.Client)
+	if err != nil {
+		return err
+	}
+	if j.BatchSize > 0 {
+		m.BatchSize = j.BatchSize
+	}
+	if m.Client.Dim() == 0 {
+		return errors.New("embedding dimension must be set")
+	}
+	if err := j.Store.Migrate(ctx, m.Client.Dim()); err != nil {
+		return err
+	}
+	var refreshed atomic.Int64
+	m.OnBatch = func(n int) { refreshed.Store(int64(n)) }
+	r.setProgress(func() map[string]int64 { return map[string]int64{"chunks_refreshed": refreshed.Load()} })
+	n, err := m.Run(ctx, mode)
+	refreshed.Store(int64(n))
+	r.logf(ctx, "refreshed %d chunks", n)
+	return err
+}
+
+func (j *Jobs) vacuum(ctx context.Context, r *jobRun) error {
+	v, ok := j.Store.(vacuumer)
+	if !ok {
+		return errors.New("the store does not support vacuum jobs")
+	}
+	n, err := v.Vacuum(ctx, "")
+	r.setProgress(func() map[string]int64 { return map[string]int64{"chunks_removed": n} })
+	r.logf(ctx, "removed %d chunks", n)
+	return err
+}
+
+// reportProgress returns the counters of an index run as job progress.
+func reportProgress(r Report) map[string]int64 {
+	return map[string]int64{
+		"files_scanned":       r.FilesScanned,
+		"files_skipped":       r.FilesSkipped,
+		"files_failed":        r.FilesFailed,
+		"chunks_upserted":     r.ChunksUpserted,
+		"summaries_generated": r.SummariesGenerated,
+		"summaries_fallback":  r.SummariesFallback,
+		"embeddings_created":  r.EmbeddingsCreated,
+		"tokens_used":         r.TokensUsed,
+	}
+}
+
+// jobRun holds the log and progress of the job being executed.
+type jobRun struct {
+	queue store.JobStore
+	id    string
+
+	mu       sync.Mutex
+	seq      int
+	progress func() map[string]int64
+}
+
+// logf appends a line to the job's log. A failure to store it is logged,
+// not returned, so that it never fails the job.
+func (r *jobRun) logf(ctx context.Context, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	r.mu.Lock()
+	seq := r.seq
+	r.seq++
+	r.mu.Unlock()
+	log.Info().Str("job", r.id).Msg(msg)
+	if err := r.queue.AppendJobLog(context.WithoutCancel(ctx), r.id, seq, models.JobLog{At: time.Now(), Message: msg}); err != nil {
+		log.Warn().Err(err).Str("job", r.id).Msg("failed to save job log")
+	}
+}
+
+func (r *jobRun) setProgress(f func() map[string]int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.progress = f
+}
+
+func (r *jobRun) snapshot() map[string]int64 {
+	r.mu.Lock()
+	f := r.progress
+	r.mu.Unlock()
+	if f == nil {
+		return nil
+	}
+	return f()
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,169 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/seanblong/reposearch/internal/ai"
+	"github.com/seanblong/reposearch/internal/store"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// newTestQueue returns a job queue in a temporary SQLite database.
+func newTestQueue(t *testing.T) store.JobStore {
+	t.Helper()
+	ctx := context.Background()
+	s, err := store.OpenSQLite(ctx, filepath.Join(t.TempDir(), "jobs.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	t.Cleanup(s.Close)
+	if err := s.Migrate(ctx, 3); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return s
+}
+
+func TestJobs(t *testing.T) {
+	ctx := context.Background()
+	url, branch := newTestRepo(t)
+
+	var mu sync.Mutex
+	var paths []string
+	st := &MockIndexableStore{UpsertChunkFunc: func(ctx context.Context, c models.Chunk, summaryVec []float32, contentHash string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, c.Path)
+		return nil
+	}}
+	configured := false
+	jobs := &Jobs{
+		Store:      st,
+		Queue:      newTestQueue(t),
+		Client:     &ai.ClientConfig{Provider: ai.ProviderStub, Dim: 3},
+		DefaultRef: branch,
+		Configure:  func(ix *Indexer) { configured = true },
+	}
+
+	if _, err := jobs.Index(ctx, JobRequest{}, "alice"); !errors.Is(err, ErrInvalidJob) {
+		t.Errorf("expected ErrInvalidJob for a request without url, got %v", err)
+	}
+	if _, err := jobs.Index(ctx, JobRequest{URL: url, Subpath: "../x"}, "alice"); !errors.Is(err, ErrInvalidJob) {
+		t.Errorf("expected ErrInvalidJob for a subpath outside the repository, got %v", err)
+	}
+	if _, err := jobs.Enqueue(ctx, "optimize", nil, "alice"); !errors.Is(err, ErrInvalidJob) {
+		t.Errorf("expected ErrInvalidJob for an unsupported kind, got %v", err)
+	}
+	if _, err := jobs.Enqueue(ctx, ModeReembed, nil, "alice"); !errors.Is(err, ErrInvalidJob) {
+		t.Errorf("expected ErrInvalidJob for a store without maintenance support, got %v", err)
+	}
+
+	job, err := jobs.Index(ctx, JobRequest{URL: url, Subpath: "services"}, "alice")
+	if err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	var req JobRequest
+	_ = json.Unmarshal(job.Params, &req)
+	if job.Status != models.JobQueued || job.Kind != "index" || req.Ref != branch || job.User != "alice" {
+		t.Errorf("unexpected job %+v", job)
+	}
+	if _, err := jobs.Index(ctx, JobRequest{URL: url, Ref: branch}, ""); !errors.Is(err, ErrJobRunning) {
+		t.Errorf("expected ErrJobRunning for a second job on the same ref, got %v", err)
+	}
+	if err := jobs.RunPending(ctx); err != nil {
+		t.Fatalf("RunPending: %v", err)
+	}
+
+	got, ok, err := jobs.Get(ctx, job.ID)
+	if err != nil || !ok {
+		t.Fatalf("job not found: %v", err)
+	}
+	if got.Status != models.JobSucceeded || got.Progress["chunks_upserted"] != 1 || got.FinishedAt == nil {
+		t.Errorf("unexpected finished job %+v", got)
+	}
+	if logs, err := jobs.Logs(ctx, job.ID); err != nil || len(logs) < 3 || logs[0].Message != "started index job" || logs[len(logs)-1].Message != "succeeded" {
+		t.Errorf("unexpected logs %+v, %v", logs, err)
+	}
+	if !configured {
+		t.Error("Configure was not called")
+	}
+	if len(paths) != 1 || paths[0] != "services/payments/pay.go" {
+		t.Errorf("indexed %v, want only the subpath", paths)
+	}
+
+	failed, err := jobs.Index(ctx, JobRequest{URL: url, Ref: "missing"}, "")
+	if err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if err := jobs.RunPending(ctx); err != nil {
+		t.Fatalf("RunPending: %v", err)
+	}
+	if got, _, _ := jobs.Get(ctx, failed.ID); got.Status != models.JobFailed || got.Error == "" {
+		t.Errorf("expected a failed job, got %+v", got)
+	}
+	if list, err := jobs.List(ctx, ModeIndex); err != nil || len(list) != 2 || list[0].ID != failed.ID {
+		t.Errorf("List should return the jobs newest first: %+v, %v", list, err)
+	}
+}
+
+func TestJobs_Cancel(t *testing.T) {
+	ctx := context.Background()
+	url, branch := newTestRepo(t)
+	queue := newTestQueue(t)
+
+	var jobs *Jobs
+	var running models.Job
+	st := &MockIndexableStore{UpsertChunkFunc: func(ctx context.Context, c models.Chunk, summaryVec []float32, contentHash string) error {
+		// Cancel the job as another request would, and wait for the runner
+		// to notice. Writes ignore cancellation, so the indexer only stops
+		// before its next file.
+		if _, _, err := jobs.Cancel(context.Background(), running.ID); err != nil {
+			t.Errorf("Cancel: %v", err)
+		}
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			logs, _ := jobs.Logs(context.Background(), running.ID)
+			for _, l := range logs {
+				if l.Message == "cancel requested" {
+					return nil
+				}
+			}
+		}
+		t.Error("the job was not canceled")
+		return nil
+	}}
+	jobs = &Jobs{
+		Store:             st,
+		Queue:             queue,
+		Client:            &ai.ClientConfig{Provider: ai.ProviderStub, Dim: 3},
+		DefaultRef:        branch,
+		HeartbeatInterval: 10 * time.Millisecond,
+	}
+
+	// A queued job is canceled without running.
+	queued, err := jobs.Index(ctx, JobRequest{URL: url, Ref: "other"}, "")
+	if err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if got, ok, err := jobs.Cancel(ctx, queued.ID); err != nil || !ok || got.Status != models.JobCanceled {
+		t.Errorf("Cancel(queued) = %+v, %v, %v", got, ok, err)
+	}
+
+	if running, err = jobs.Index(ctx, JobRequest{URL: url}, ""); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if err := jobs.RunPending(ctx); err != nil {
+		t.Fatalf("RunPending: %v", err)
+	}
+	got, _, _ := jobs.Get(ctx, running.ID)
+	if got.Status != models.JobCanceled || !got.CancelRequested {
+		t.Errorf("expected a canceled job, got %+v", got)
+	}
+	if got, _, _ := jobs.Get(ctx, queued.ID); got.StartedAt != nil {
+		t.Errorf("a canceled queued job was started: %+v", got)
+	}
+}
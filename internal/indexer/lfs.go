@@ -0,0 +1,221 @@
+package indexer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+)
+
+// LFSMode controls how Git LFS pointer files are handled during indexing.
+type LFSMode string
+
+const (
+	LFSModeSkip  LFSMode = "skip"  // ignore pointer files entirely (default)
+	LFSModeFetch LFSMode = "fetch" // download the real object for text files
+	LFSModeIndex LFSMode = "index" // index the pointer text as-is
+)
+
+// ParseLFSMode validates a configured LFS mode, defaulting to skip.
+func ParseLFSMode(s string) (LFSMode, error) {
+	switch LFSMode(strings.ToLower(strings.TrimSpace(s))) {
+	case "", LFSModeSkip:
+		return LFSModeSkip, nil
+	case LFSModeFetch:
+		return LFSModeFetch, nil
+	case LFSModeIndex:
+		return LFSModeIndex, nil
+	default:
+		return "", fmt.Errorf("unsupported lfs mode: %s (expected skip, fetch or index)", s)
+	}
+}
+
+// DefaultMaxLFSObjectSize caps the size of LFS objects fetched for indexing
+// when Indexer.MaxLFSObjectSize is not set.
+const DefaultMaxLFSObjectSize = 5 << 20
+
+// lfsPointer describes a parsed Git LFS pointer file.
+type lfsPointer struct {
+	OID  string
+	Size int64
+}
+
+// parseLFSPointer reports whether b is a Git LFS pointer file and parses it.
+func parseLFSPointer(b []byte) (lfsPointer, bool) {
+	// Pointer files are tiny; anything bigger is real content.
+	if len(b) > 1024 || !bytes.HasPrefix(b, []byte("version https://git-lfs.github.com/spec/")) {
+		return lfsPointer{}, false
+	}
+	var p lfsPointer
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		key, val, ok := strings.Cut(sc.Text(), " ")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "oid":
+			p.OID = strings.TrimPrefix(val, "sha256:")
+		case "size":
+			n, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return lfsPointer{}, false
+			}
+			p.Size = n
+		}
+	}
+	if p.OID == "" {
+		return lfsPointer{}, false
+	}
+	return p, true
+}
+
+// LFSFetcher downloads the content of Git LFS objects.
+type LFSFetcher interface {
+	Fetch(ctx context.Context, oid string, size int64) ([]byte, error)
+}
+
+// HTTPLFSFetcher fetches objects using the Git LFS batch API.
+type HTTPLFSFetcher struct {
+	Endpoint string // e.g. https://github.com/org/repo.git/info/lfs
+	Token    string
+	HTTP     *http.Client
+}
+
+// NewHTTPLFSFetcher creates a fetcher for the LFS endpoint of repoURL.
+func NewHTTPLFSFetcher(repoURL, token string) *HTTPLFSFetcher {
+	endpoint := strings.TrimSuffix(repoURL, "/")
+	if !strings.HasSuffix(endpoint, ".git") {
+		endpoint += ".git"
+	}
+	return &HTTPLFSFetcher{
+		Endpoint: endpoint + "/info/lfs",
+		Token:    token,
+		HTTP:     &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// Fetch resolves the download action for oid and returns the object content,
+// reading at most size bytes.
+func (f *HTTPLFSFetcher) Fetch(ctx context.Context, oid string, size int64) ([]byte, error) {
+	type object struct {
+		OID     string `json:"oid"`
+		Size    int64  `json:"size"`
+		Actions struct {
+			Download *struct {
+				Href   string            `json:"href"`
+				Header map[string]string `json:"header"`
+			} `json:"download"`
+		} `json:"actions"`
+		Error *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"operation": "download",
+		"transfers": []string{"basic"},
+		"objects":   []map[string]any{{"oid": oid, "size": size}},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.Endpoint+"/objects/batch", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.git-lfs+json")
+	req.Header.Set("Content-Type", "application/vnd.git-lfs+json")
+	if f.Token != "" {
+		req.SetBasicAuth("x-access-token", f.Token)
+	}
+
+	resp, err := f.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("lfs batch returned status %d", resp.StatusCode)
+	}
+
+	var batch struct {
+		Objects []object `json:"objects"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		return nil, err
+	}
+	if len(batch.Objects) == 0 {
+		return nil, errors.New("lfs batch returned no objects")
+	}
+	obj := batch.Objects[0]
+	if obj.Error != nil {
+		return nil, fmt.Errorf("lfs object %s: %s", oid, obj.Error.Message)
+	}
+	if obj.Actions.Download == nil {
+		return nil, fmt.Errorf("lfs object %s has no download action", oid)
+	}
+
+	dl, err := http.NewRequestWithContext(ctx, http.MethodGet, obj.Actions.Download.Href, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range obj.Actions.Download.Header {
+		dl.Header.Set(k, v)
+	}
+	dresp, err := f.HTTP.Do(dl)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = dresp.Body.Close() }()
+	if dresp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("lfs download returned status %d", dresp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(dresp.Body, size))
+}
+
+// maxLFSObjectSize returns the size of the largest LFS object ix fetches.
+func (ix *Indexer) maxLFSObjectSize() int64 {
+	if ix.MaxLFSObjectSize > 0 {
+		return ix.MaxLFSObjectSize
+	}
+	return DefaultMaxLFSObjectSize
+}
+
+// resolveLFS decides what content, if any, to index for an LFS pointer file.
+// It returns ok=false when the file should be skipped.
+func (ix *Indexer) resolveLFS(ctx context.Context, path string, p lfsPointer, pointer []byte) ([]byte, bool) {
+	switch ix.LFSMode {
+	case LFSModeIndex:
+		return pointer, true
+	case LFSModeFetch:
+		if ix.LFSFetcher == nil {
+			log.Warn().Str("path", path).Msg("lfs fetch requested but no fetcher configured, skipping")
+			return nil, false
+		}
+		if p.Size > ix.maxLFSObjectSize() {
+			log.Debug().Str("path", path).Int64("size", p.Size).Msg("lfs object too large, skipping")
+			return nil, false
+		}
+		b, err := ix.LFSFetcher.Fetch(ctx, p.OID, p.Size)
+		if err != nil {
+			log.Warn().Err(err).Str("path", path).Msg("lfs fetch failed, skipping")
+			return nil, false
+		}
+		if !utf8.Valid(b) {
+			log.Debug().Str("path", path).Msg("lfs object is not text, skipping")
+			return nil, false
+		}
+		return b, true
+	default:
+		return nil, false
+	}
+}
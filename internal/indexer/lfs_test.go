@@ -0,0 +1,148 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+const testLFSPointer = "version https://git-lfs.github.com/spec/v1\noid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\nsize 12345\n"
+
+func TestParseLFSPointer(t *testing.T) {
+	p, ok := parseLFSPointer([]byte(testLFSPointer))
+	if !ok {
+		t.Fatal("Expected pointer to be detected")
+	}
+	if p.OID != "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393" || p.Size != 12345 {
+		t.Errorf("Unexpected pointer: %+v", p)
+	}
+
+	for _, s := range []string{"package main", "version https://git-lfs.github.com/spec/v1\nsize 10\n", ""} {
+		if _, ok := parseLFSPointer([]byte(s)); ok {
+			t.Errorf("Did not expect %q to be detected as a pointer", s)
+		}
+	}
+}
+
+func TestParseLFSMode(t *testing.T) {
+	for in, want := range map[string]LFSMode{"": LFSModeSkip, "skip": LFSModeSkip, "FETCH": LFSModeFetch, "index": LFSModeIndex} {
+		got, err := ParseLFSMode(in)
+		if err != nil || got != want {
+			t.Errorf("ParseLFSMode(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseLFSMode("bogus"); err == nil {
+		t.Error("Expected error for unsupported mode")
+	}
+}
+
+func TestHTTPLFSFetcher(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/org/repo.git/info/lfs/objects/batch":
+			if user, pass, ok := r.BasicAuth(); !ok || user != "x-access-token" || pass != "tok" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			var req struct {
+				Operation string `json:"operation"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			if req.Operation != "download" {
+				t.Errorf("Expected download operation, got %q", req.Operation)
+			}
+			_, _ = w.Write([]byte(`{"objects":[{"oid":"abc","size":5,"actions":{"download":{"href":"` + srv.URL + `/objects/abc","header":{"X-Token":"dl"}}}}]}`))
+		case "/objects/abc":
+			if r.Header.Get("X-Token") != "dl" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write([]byte("hello"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	f := NewHTTPLFSFetcher(srv.URL+"/org/repo", "tok")
+	b, err := f.Fetch(context.Background(), "abc", 5)
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if string(b) != "hello" {
+		t.Errorf("Expected object content, got %q", b)
+	}
+
+	f.Token = "wrong"
+	if _, err := f.Fetch(context.Background(), "abc", 5); err == nil {
+		t.Error("Expected error for unauthorized batch request")
+	}
+}
+
+// mockLFSFetcher implements LFSFetcher for testing
+type mockLFSFetcher struct {
+	content []byte
+	err     error
+}
+
+func (m *mockLFSFetcher) Fetch(ctx context.Context, oid string, size int64) ([]byte, error) {
+	return m.content, m.err
+}
+
+func TestIndexer_LFSModes(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        LFSMode
+		fetcher     LFSFetcher
+		maxSize     int64
+		wantContent string // empty means the file must not be indexed
+	}{
+		{name: "skip", mode: LFSModeSkip},
+		{name: "index pointer", mode: LFSModeIndex, wantContent: testLFSPointer},
+		{name: "fetch text", mode: LFSModeFetch, fetcher: &mockLFSFetcher{content: []byte("real content")}, wantContent: "real content"},
+		{name: "fetch binary", mode: LFSModeFetch, fetcher: &mockLFSFetcher{content: []byte{0xff, 0xfe, 0x00}}},
+		{name: "fetch error", mode: LFSModeFetch, fetcher: &mockLFSFetcher{err: errors.New("boom")}},
+		{name: "fetch without fetcher", mode: LFSModeFetch},
+		{name: "fetch too large", mode: LFSModeFetch, fetcher: &mockLFSFetcher{content: []byte("real content")}, maxSize: 12344},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var indexed []string
+			st := &MockIndexableStore{
+				UpsertChunkFunc: func(ctx context.Context, c models.Chunk, summaryVec []float32, contentHash string) error {
+					indexed = append(indexed, c.Content)
+					return nil
+				},
+			}
+			walker := &MockFileSystemWalker{FilesToProcess: []string{"/repo/data.csv"}}
+			reader := &MockFileReader{Files: map[string]string{"/repo/data.csv": testLFSPointer}}
+			ix := NewWithDependencies(st, "/repo", "repo", &MockAIClient{}, walker, reader)
+			ix.LFSMode = tt.mode
+			ix.LFSFetcher = tt.fetcher
+			ix.MaxLFSObjectSize = tt.maxSize
+
+			if err := ix.Run(context.Background()); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if tt.wantContent == "" {
+				if len(indexed) != 0 {
+					t.Errorf("Expected pointer file to be skipped, indexed %q", indexed)
+				}
+				if ix.Report().FilesSkipped != 1 {
+					t.Errorf("Expected skipped file to be counted, got %d", ix.Report().FilesSkipped)
+				}
+				return
+			}
+			if len(indexed) != 1 || indexed[0] != tt.wantContent {
+				t.Errorf("Expected content %q, got %q", tt.wantContent, indexed)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/seanblong/reposearch/internal/ai"
+	"github.com/seanblong/reposearch/internal/store"
+)
+
+// Mode selects what the indexer binary does.
+type Mode string
+
+const (
+	ModeIndex       Mode = "index"       // walk a repository and index it (default)
+	ModeResummarize Mode = "resummarize" // regenerate summaries of stored chunks
+	ModeReembed     Mode = "reembed"     // regenerate summary vectors of stored chunks
+	ModeVacuum      Mode = "vacuum"      // remove soft-deleted rows and rebuild indexes
+	ModeOptimize    Mode = "optimize"    // refresh statistics and optionally rebuild vector indexes
+)
+
+// ParseMode validates a configured mode, defaulting to index.
+func ParseMode(s string) (Mode, error) {
+	switch Mode(strings.ToLower(strings.TrimSpace(s))) {
+	case "", ModeIndex:
+		return ModeIndex, nil
+	case ModeResummarize:
+		return ModeResummarize, nil
+	case ModeReembed:
+		return ModeReembed, nil
+	case ModeVacuum:
+		return ModeVacuum, nil
+	case ModeOptimize:
+		return ModeOptimize, nil
+	default:
+		return "", fmt.Errorf("unsupported mode: %s (expected index, resummarize, reembed, vacuum or optimize)", s)
+	}
+}
+
+// heuristicModel is recorded for summaries produced without a model so that
+// they are picked up by the next resummarize pass.
+const heuristicModel = "heuristic"
+
+// maxSummarizeBytes caps how much of a chunk is sent for summarization.
+const maxSummarizeBytes = 400_000
+
+// modelVersion identifies the model that produced an artifact.
+func modelVersion(p ai.Provider, model string) string {
+	if model == "" {
+		return string(p)
+	}
+	return string(p) + "/" + model
+}
+
+// defaultBatchSize is the number of chunks refreshed per store round trip.
+const defaultBatchSize = 100
+
+// Maintainer refreshes summaries or vectors of chunks already in the store
+// without re-reading the repositories they came from. Chunks are selected by
+// the model version recorded with each artifact.
+type Maintainer struct {
+	Store        store.MaintenanceStore
+	Client       ai.Client
+	SummaryModel string
+	EmbedModel   string
+	BatchSize    int
+
+	// OnBatch, when set, is called after each batch with the number of
+	// chunks refreshed so far.
+	OnBatch func(refreshed int)
+}
+
+// NewMaintainer creates a Maintainer using the configured AI provider.
+func NewMaintainer(s store.MaintenanceStore, clientConfig *ai.ClientConfig) (*Maintainer, error) {
+	client, err := ai.NewClient(clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &Maintainer{
+		Store:        s,
+		Client:       client,
+		SummaryModel: modelVersion(clientConfig.Provider, clientConfig.SummaryModel),
+		EmbedModel:   modelVersion(clientConfig.Provider, clientConfig.EmbedModel),
+		BatchSize:    defaultBatchSize,
+	}, nil
+}
+
+// Run performs the given maintenance mode and returns the number of chunks
+// refreshed.
+func (m *Maintainer) Run(ctx context.Context, mode Mode) (int, error) {
+	switch mode {
+	case ModeResummarize:
+		return m.Resummarize(ctx)
+	case ModeReembed:
+		return m.Reembed(ctx)
+	default:
+		return 0, fmt.Errorf("mode %s is not a maintenance mode", mode)
+	}
+}
+
+// Resummarize regenerates the summary of every chunk whose summary was not
+// produced by the current summary model. Updated chunks need re-embedding
+// afterwards, which Reembed picks up.
+func (m *Maintainer) Resummarize(ctx context.Context) (int, error) {
+	return m.each(ctx, m.Store.ListStaleSummaries, m.SummaryModel, func(batch []store.StaleChunk) (int, error) {
+		var updates []store.SummaryUpdate
+		for _, c := range batch {
+			content := c.Content
+			if len(content) > maxSummarizeBytes {
+				content = content[:maxSummarizeBytes]
+			}
+			s, err := m.Client.Summarize(ctx, c.Path, c.Language, content)
+			if err != nil || strings.TrimSpace(s) == "" {
+				log.Warn().Err(err).Str("id", c.ID).Str("path", c.Path).Msg("resummarize failed, leaving chunk unchanged")
+				continue
+			}
+			updates = append(updates, store.SummaryUpdate{ID: c.ID, Summary: s, Model: m.SummaryModel})
+		}
+		if len(updates) == 0 {
+			return 0, nil
+		}
+		return len(updates), m.Store.UpdateSummaries(context.WithoutCancel(ctx), updates)
+	})
+}
+
+// Reembed regenerates the summary vector of every summarized chunk whose
+// vector was not produced by the current embedding model.
+func (m *Maintainer) Reembed(ctx context.Context) (int, error) {
+	return m.each(ctx, m.Store.ListStaleVectors, m.EmbedModel, func(batch []store.StaleChunk) (int, error) {
+		var updates []store.VectorUpdate
+		for _, c := range batch {
+			v, err := m.Client.Embed(c.Summary)
+			if err != nil || v == nil {
+				log.Warn().Err(err).Str("id", c.ID).Str("path", c.Path).Msg("reembed failed, leaving chunk unchanged")
+				continue
+			}
+			updates = append(updates, store.VectorUpdate{ID: c.ID, Vector: v, Model: m.EmbedModel})
+		}
+		if len(updates) == 0 {
+			return 0, nil
+		}
+		return len(updates), m.Store.UpdateVectors(context.WithoutCancel(ctx), updates)
+	})
+}
+
+type listFunc func(ctx context.Context, model, afterID string, limit int) ([]store.StaleChunk, error)
+
+// each pages through stale chunks by id and hands each batch to fn. Chunks
+// that fail to refresh are skipped rather than retried, so the pass always
+// terminates.
+func (m *Maintainer) each(ctx context.Context, list listFunc, model string, fn func([]store.StaleChunk) (int, error)) (int, error) {
+	size := m.BatchSize
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	total := 0
+	after := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		batch, err := list(ctx, model, after, size)
+		if err != nil {
+			return total, err
+		}
+		if len(batch) == 0 {
+			return total, nil
+		}
+		n, err := fn(batch)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		after = batch[len(batch)-1].ID
+		log.Info().Int("refreshed", total).Str("model", model).Msg("maintenance batch complete")
+		if m.OnBatch != nil {
+			m.OnBatch(total)
+		}
+		if len(batch) < size {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/seanblong/reposearch/internal/ai"
+	"github.com/seanblong/reposearch/internal/store"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// fakeMaintenanceStore is an in-memory store.MaintenanceStore.
+type fakeMaintenanceStore struct {
+	chunks  map[string]*fakeChunk
+	lists   int
+	batches [][]string
+}
+
+type fakeChunk struct {
+	store.StaleChunk
+	summaryModel, embedModel string
+	vec                      []float32
+}
+
+func newFakeMaintenanceStore(n int, summaryModel, embedModel string) *fakeMaintenanceStore {
+	s := &fakeMaintenanceStore{chunks: map[string]*fakeChunk{}}
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("c%02d", i)
+		s.chunks[id] = &fakeChunk{
+			StaleChunk:   store.StaleChunk{ID: id, Path: id + ".go", Language: "go", Summary: "old " + id, Content: "package " + id},
+			summaryModel: summaryModel,
+			embedModel:   embedModel,
+		}
+	}
+	return s
+}
+
+func (s *fakeMaintenanceStore) list(afterID string, limit int, stale func(*fakeChunk) bool) []store.StaleChunk {
+	s.lists++
+	var ids []string
+	for id, c := range s.chunks {
+		if id > afterID && stale(c) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	out := make([]store.StaleChunk, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, s.chunks[id].StaleChunk)
+	}
+	return out
+}
+
+func (s *fakeMaintenanceStore) ListStaleSummaries(ctx context.Context, model, afterID string, limit int) ([]store.StaleChunk, error) {
+	return s.list(afterID, limit, func(c *fakeChunk) bool { return c.summaryModel != model }), nil
+}
+
+func (s *fakeMaintenanceStore) ListStaleVectors(ctx context.Context, model, afterID string, limit int) ([]store.StaleChunk, error) {
+	return s.list(afterID, limit, func(c *fakeChunk) bool { return c.embedModel != model && c.Summary != "" }), nil
+}
+
+func (s *fakeMaintenanceStore) UpdateSummaries(ctx context.Context, updates []store.SummaryUpdate) error {
+	var ids []string
+	for _, u := range updates {
+		c := s.chunks[u.ID]
+		c.Summary, c.summaryModel, c.embedModel = u.Summary, u.Model, ""
+		ids = append(ids, u.ID)
+	}
+	s.batches = append(s.batches, ids)
+	return nil
+}
+
+func (s *fakeMaintenanceStore) UpdateVectors(ctx context.Context, updates []store.VectorUpdate) error {
+	var ids []string
+	for _, u := range updates {
+		c := s.chunks[u.ID]
+		c.vec, c.embedModel = u.Vector, u.Model
+		ids = append(ids, u.ID)
+	}
+	s.batches = append(s.batches, ids)
+	return nil
+}
+
+func TestParseMode(t *testing.T) {
+	for in, want := range map[string]Mode{"": ModeIndex, "index": ModeIndex, "Resummarize": ModeResummarize, " reembed ": ModeReembed, "vacuum": ModeVacuum, "optimize": ModeOptimize} {
+		got, err := ParseMode(in)
+		if err != nil || got != want {
+			t.Errorf("ParseMode(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseMode("rebuild"); err == nil {
+		t.Error("Expected error for unknown mode")
+	}
+}
+
+func TestNewMaintainer(t *testing.T) {
+	m, err := NewMaintainer(&fakeMaintenanceStore{}, &ai.ClientConfig{Provider: ai.ProviderOpenAI, APIKey: "k"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The OpenAI client fills in its default models.
+	if m.SummaryModel != "openai/gpt-4o-mini" || m.EmbedModel != "openai/text-embedding-3-small" {
+		t.Errorf("Unexpected model versions %q %q", m.SummaryModel, m.EmbedModel)
+	}
+	if m.BatchSize != defaultBatchSize {
+		t.Errorf("Expected default batch size, got %d", m.BatchSize)
+	}
+}
+
+func TestMaintainer_Resummarize(t *testing.T) {
+	st := newFakeMaintenanceStore(5, "old-model", "old-embed")
+	st.chunks["c02"].summaryModel = "new-model"
+	client := &MockAIClient{
+		SummarizeFunc: func(ctx context.Context, filePath, language, content string) (string, error) {
+			if filePath == "c03.go" {
+				return "", errors.New("provider error")
+			}
+			return "new " + filePath, nil
+		},
+	}
+	m := &Maintainer{Store: st, Client: client, SummaryModel: "new-model", BatchSize: 2}
+
+	n, err := m.Run(context.Background(), ModeResummarize)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Expected 3 refreshed chunks, got %d", n)
+	}
+	for id, c := range st.chunks {
+		switch id {
+		case "c02":
+			if c.Summary != "old c02" {
+				t.Errorf("Chunk already on the current model was resummarized")
+			}
+		case "c03":
+			if c.summaryModel != "old-model" || c.embedModel != "old-embed" {
+				t.Errorf("Failed chunk should be left unchanged")
+			}
+		default:
+			if c.Summary != "new "+id+".go" || c.summaryModel != "new-model" {
+				t.Errorf("Chunk %s not resummarized: %+v", id, c)
+			}
+			if c.embedModel != "" {
+				t.Errorf("Chunk %s should be queued for re-embedding", id)
+			}
+		}
+	}
+	// Four stale chunks in batches of two, then a final empty page.
+	if st.lists != 3 {
+		t.Errorf("Expected 3 list calls, got %d", st.lists)
+	}
+}
+
+func TestMaintainer_Reembed(t *testing.T) {
+	st := newFakeMaintenanceStore(3, "sum", "old-embed")
+	st.chunks["c01"].Summary = ""
+	client := &MockAIClient{
+		EmbedFunc: func(text string) ([]float32, error) { return []float32{float32(len(text))}, nil },
+	}
+	m := &Maintainer{Store: st, Client: client, EmbedModel: "new-embed", BatchSize: 10}
+
+	n, err := m.Run(context.Background(), ModeReembed)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 refreshed chunks, got %d", n)
+	}
+	if len(st.batches) != 1 || len(st.batches[0]) != 2 {
+		t.Errorf("Expected a single batch of 2 updates, got %v", st.batches)
+	}
+	if st.chunks["c01"].vec != nil {
+		t.Error("Chunk without a summary should not be embedded")
+	}
+	if st.chunks["c00"].embedModel != "new-embed" || st.chunks["c00"].vec == nil {
+		t.Errorf("Chunk c00 not re-embedded: %+v", st.chunks["c00"])
+	}
+
+	// A second pass finds nothing left to do.
+	if n, _ := m.Reembed(context.Background()); n != 0 {
+		t.Errorf("Expected second pass to refresh nothing, got %d", n)
+	}
+}
+
+func TestMaintainer_Cancelled(t *testing.T) {
+	st := newFakeMaintenanceStore(3, "old", "old")
+	m := &Maintainer{Store: st, Client: &MockAIClient{}, SummaryModel: "new"}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := m.Resummarize(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if st.lists != 0 {
+		t.Error("Expected no work after cancellation")
+	}
+}
+
+func TestMaintainer_MigrateVectors(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.OpenSQLite(ctx, filepath.Join(t.TempDir(), "migrate.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	defer st.Close()
+	if err := st.Migrate(ctx, 3); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	for i := range 5 {
+		c := models.Chunk{ID: fmt.Sprintf("c%02d", i), Repository: "repo", Path: fmt.Sprintf("c%02d.go", i), Summary: "summary", LineStart: 1, LineEnd: 2, EmbedModel: "old"}
+		if err := st.UpsertChunk(ctx, c, []float32{1, 0, 0}, c.ID); err != nil {
+			t.Fatalf("UpsertChunk: %v", err)
+		}
+	}
+
+	failing := true
+	client := &MockAIClient{
+		EmbedFunc: func(text string) ([]float32, error) {
+			if failing {
+				failing = false
+				return nil, errors.New("provider error")
+			}
+			return []float32{0, 0, 0, 1}, nil
+		},
+	}
+	m := &Maintainer{Store: st, Client: client, EmbedModel: "new", BatchSize: 2}
+
+	// The failed summary stays pending, so the vectors are not swapped.
+	n, err := m.MigrateVectors(ctx, 4)
+	if err == nil || n != 4 {
+		t.Fatalf("Expected 4 re-embedded and an error, got %d, %v", n, err)
+	}
+	if err := st.Migrate(ctx, 3); err != nil {
+		t.Fatalf("Expected the old vectors to stay live: %v", err)
+	}
+
+	// A second run resumes with the failed summary and swaps.
+	if n, err := m.MigrateVectors(ctx, 4); err != nil || n != 1 {
+		t.Fatalf("Expected 1 re-embedded, got %d, %v", n, err)
+	}
+	if err := st.Migrate(ctx, 4); err != nil {
+		t.Fatalf("Expected 4-dimensional vectors after the swap: %v", err)
+	}
+	if stale, _ := st.ListStaleVectors(ctx, "new", "", 10); len(stale) != 0 {
+		t.Errorf("Expected every vector to be of the new model, got %+v", stale)
+	}
+}
+
+func TestMaintainer_MigrateVectorsUnsupported(t *testing.T) {
+	m := &Maintainer{Store: &fakeMaintenanceStore{}, Client: &MockAIClient{}}
+	if _, err := m.MigrateVectors(context.Background(), 4); err == nil {
+		t.Error("Expected an error for a store without vector migrations")
+	}
+}
+
+func TestIndexer_RecordsModels(t *testing.T) {
+	var upserted models.Chunk
+	st := &MockIndexableStore{
+		UpsertChunkFunc: func(ctx context.Context, c models.Chunk, summaryVec []float32, contentHash string) error {
+			upserted = c
+			return nil
+		},
+	}
+	walker := &MockFileSystemWalker{FilesToProcess: []string{"/repo/a.go"}}
+	reader := &MockFileReader{Files: map[string]string{"/repo/a.go": "package a"}}
+	ix := NewWithDependencies(st, "/repo", "repo", &MockAIClient{}, walker, reader)
+	ix.SummaryModel, ix.EmbedModel = "p/sum", "p/embed"
+
+	if err := ix.Run(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if upserted.SummaryModel != "p/sum" || upserted.EmbedModel != "p/embed" {
+		t.Errorf("Expected models to be recorded, got %q %q", upserted.SummaryModel, upserted.EmbedModel)
+	}
+}
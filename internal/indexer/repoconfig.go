@@ -0,0 +1,188 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// RepoConfigFile is the file at the root of a repository through which its
+// owners tune how it is indexed.
+const RepoConfigFile = ".reposearch.yaml"
+
+// RepoConfigFields lists the fields of RepoConfigFile, by their YAML keys.
+var RepoConfigFields = []string{"exclude", "noSummarize", "languages", "chunkLines"}
+
+// RepoConfig holds the settings of a repository's RepoConfigFile. Paths are
+// matched against globs relative to the repository root: a glob without a
+// slash matches any file or directory of that name, one with a slash
+// matches from the root, and a trailing slash matches a directory and
+// everything in it.
+type RepoConfig struct {
+	Exclude     []string          `yaml:"exclude"`     // globs of paths not indexed, besides the built-in ones
+	NoSummarize []string          `yaml:"noSummarize"` // globs of paths indexed without AI summaries
+	Languages   map[string]string `yaml:"languages"`   // globs to the language of the paths they match
+	ChunkLines  int               `yaml:"chunkLines"`  // split files into chunks of at most this many lines; 0 for one chunk per file
+}
+
+// CheckRepoConfigFields returns an error unless every field is one of
+// RepoConfigFields.
+func CheckRepoConfigFields(fields []string) error {
+	for _, f := range fields {
+		if !slices.Contains(RepoConfigFields, f) {
+			return fmt.Errorf("unknown %s field %q (want one of %s)", RepoConfigFile, f, strings.Join(RepoConfigFields, ", "))
+		}
+	}
+	return nil
+}
+
+// loadRepoConfig reads the RepoConfigFile of ix.RepoRoot, keeping only the
+// fields in ix.RepoConfigFields. A missing file, or no allowed fields, is
+// an empty RepoConfig; an invalid file is ignored with a warning, so that
+// it does not stop the run.
+func (ix *Indexer) loadRepoConfig() RepoConfig {
+	var rc RepoConfig
+	if len(ix.RepoConfigFields) == 0 {
+		return rc
+	}
+	p := filepath.Join(ix.RepoRoot, RepoConfigFile)
+	b, err := ix.FileReader.ReadFile(p)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Warn().Err(err).Str("path", p).Msg("failed to read repository config, ignoring it")
+		}
+		return rc
+	}
+	var fields map[string]yaml.Node
+	if err := yaml.Unmarshal(b, &fields); err != nil {
+		log.Warn().Err(err).Str("path", p).Msg("invalid repository config, ignoring it")
+		return rc
+	}
+	for name, n := range fields {
+		if !slices.Contains(ix.RepoConfigFields, name) {
+			log.Warn().Str("path", p).Str("field", name).Msg("repository config field not allowed by the server, ignoring it")
+			continue
+		}
+		var err error
+		switch name {
+		case "exclude":
+			err = n.Decode(&rc.Exclude)
+		case "noSummarize":
+			err = n.Decode(&rc.NoSummarize)
+		case "languages":
+			err = n.Decode(&rc.Languages)
+		case "chunkLines":
+			err = n.Decode(&rc.ChunkLines)
+			if err == nil && rc.ChunkLines < 0 {
+				err = fmt.Errorf("chunkLines (%d) must not be negative", rc.ChunkLines)
+			}
+		}
+		if err != nil {
+			log.Warn().Err(err).Str("path", p).Str("field", name).Msg("invalid repository config field, ignoring it")
+			rc = clearRepoConfigField(rc, name)
+		}
+	}
+	return rc
+}
+
+// clearRepoConfigField returns rc without the named field.
+func clearRepoConfigField(rc RepoConfig, name string) RepoConfig {
+	switch name {
+	case "exclude":
+		rc.Exclude = nil
+	case "noSummarize":
+		rc.NoSummarize = nil
+	case "languages":
+		rc.Languages = nil
+	case "chunkLines":
+		rc.ChunkLines = 0
+	}
+	return rc
+}
+
+// excluded reports whether the repository excludes relPath from indexing.
+func (rc RepoConfig) excluded(relPath string) bool {
+	return matchAny(rc.Exclude, relPath)
+}
+
+// summarize reports whether the chunks of relPath may be summarized by the
+// AI provider.
+func (rc RepoConfig) summarize(relPath string) bool {
+	return !matchAny(rc.NoSummarize, relPath)
+}
+
+// language returns the language of relPath, as the repository declares it
+// or guessed from its extension. When several globs match, the longest
+// wins.
+func (rc RepoConfig) language(relPath string) string {
+	best := ""
+	lang := ""
+	for glob, l := range rc.Languages {
+		if len(glob) > len(best) || (len(glob) == len(best) && glob < best) {
+			if matchGlob(glob, relPath) {
+				best, lang = glob, l
+			}
+		}
+	}
+	if lang != "" {
+		return lang
+	}
+	return guessLang(relPath)
+}
+
+// matchAny reports whether any of globs matches relPath.
+func matchAny(globs []string, relPath string) bool {
+	for _, g := range globs {
+		if matchGlob(g, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob reports whether glob matches relPath, a slash-separated path
+// relative to the repository root, as described on RepoConfig.
+func matchGlob(glob, relPath string) bool {
+	relPath = filepath.ToSlash(relPath)
+	if dir, ok := strings.CutSuffix(glob, "/"); ok {
+		dir = strings.TrimPrefix(dir, "/")
+		if !strings.Contains(dir, "/") {
+			// Any directory of that name.
+			elems := strings.Split(relPath, "/")
+			for _, e := range elems[:len(elems)-1] {
+				if ok, _ := path.Match(dir, e); ok {
+					return true
+				}
+			}
+			return false
+		}
+		for d := path.Dir(relPath); d != "."; d = path.Dir(d) {
+			if ok, _ := path.Match(dir, d); ok {
+				return true
+			}
+		}
+		return false
+	}
+	if !strings.Contains(glob, "/") {
+		for _, e := range strings.Split(relPath, "/") {
+			if ok, _ := path.Match(glob, e); ok {
+				return true
+			}
+		}
+		return false
+	}
+	glob = strings.TrimPrefix(glob, "/")
+	for p := relPath; p != "."; p = path.Dir(p) {
+		if ok, _ := path.Match(glob, p); ok {
+			return true
+		}
+	}
+	return false
+}
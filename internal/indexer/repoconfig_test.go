@@ -0,0 +1,141 @@
+package indexer
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		glob, path string
+		want       bool
+	}{
+		{"*.pb.go", "api/v1/service.pb.go", true},
+		{"*.pb.go", "api/v1/service.go", false},
+		{"testdata", "pkg/testdata/input.txt", true},
+		{"testdata/", "pkg/testdata/input.txt", true},
+		{"testdata/", "pkg/testdata", false},
+		{"docs/*.md", "docs/intro.md", true},
+		{"docs/*.md", "src/docs/intro.md", false},
+		{"/docs/", "docs/guide/intro.md", true},
+		{"third_party/*/", "third_party/lib/a/b.c", true},
+		{"third_party/*/", "third_party/README", false},
+		{"gen/*", "gen/a/b.go", true},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.glob, tt.path); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v; want %v", tt.glob, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestLineChunks(t *testing.T) {
+	chunks := lineChunks("a\nb\nc\nd\n", 2)
+	want := []chunk{{Content: "a\nb\n", LineStart: 1, LineEnd: 2}, {Content: "c\nd\n", LineStart: 3, LineEnd: 4}}
+	if !slices.Equal(chunks, want) {
+		t.Errorf("lineChunks = %+v; want %+v", chunks, want)
+	}
+	chunks = lineChunks("a\nb\nc", 2)
+	if len(chunks) != 2 || chunks[1] != (chunk{Content: "c", LineStart: 3, LineEnd: 3}) {
+		t.Errorf("unexpected chunks %+v", chunks)
+	}
+	if chunks := lineChunks("a\nb\n", 0); len(chunks) != 1 || chunks[0].LineEnd != 3 {
+		t.Errorf("expected one chunk, got %+v", chunks)
+	}
+}
+
+func TestCheckRepoConfigFields(t *testing.T) {
+	if err := CheckRepoConfigFields(RepoConfigFields); err != nil {
+		t.Errorf("CheckRepoConfigFields(RepoConfigFields) = %v", err)
+	}
+	if err := CheckRepoConfigFields([]string{"exclude", "secrets"}); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+}
+
+func TestIndexer_RepoConfig(t *testing.T) {
+	const repoConfig = `
+exclude: ["*.pb.go", "fixtures/"]
+noSummarize: ["internal/secret/"]
+languages:
+  "*.tmpl": "gotemplate"
+  "scripts/*": "shell"
+chunkLines: 2
+`
+	files := map[string]string{
+		"/repo/" + RepoConfigFile:      repoConfig,
+		"/repo/api/service.pb.go":      "package api\n",
+		"/repo/fixtures/data.go":       "package fixtures\n",
+		"/repo/internal/secret/key.go": "package secret\n",
+		"/repo/web/page.tmpl":          "{{ .Title }}\n",
+		"/repo/scripts/deploy":         "#!/bin/sh\necho one\necho two\n",
+	}
+
+	for _, tt := range []struct {
+		name   string
+		fields []string
+		want   map[string]string // path to language
+		chunks int
+	}{
+		{"all fields", RepoConfigFields, map[string]string{
+			RepoConfigFile: "yaml", "internal/secret/key.go": "go", "web/page.tmpl": "gotemplate", "scripts/deploy": "shell",
+		}, 8}, // chunks of two lines: four of the config, one each of key.go and page.tmpl, two of deploy
+		{"languages only", []string{"languages"}, map[string]string{
+			RepoConfigFile: "yaml", "api/service.pb.go": "go", "fixtures/data.go": "go", "internal/secret/key.go": "go",
+			"web/page.tmpl": "gotemplate", "scripts/deploy": "shell",
+		}, 6},
+		{"ignored", nil, map[string]string{
+			RepoConfigFile: "yaml", "api/service.pb.go": "go", "fixtures/data.go": "go", "internal/secret/key.go": "go",
+			"web/page.tmpl": "tmpl", "scripts/deploy": "",
+		}, 6},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			langs := map[string]string{}
+			chunks := 0
+			st := &MockIndexableStore{
+				UpsertChunkFunc: func(ctx context.Context, c models.Chunk, summaryVec []float32, contentHash string) error {
+					mu.Lock()
+					defer mu.Unlock()
+					langs[c.Path] = c.Language
+					chunks++
+					return nil
+				},
+			}
+			summarized := map[string]bool{}
+			client := &MockAIClient{SummarizeFunc: func(ctx context.Context, filePath, language, content string) (string, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				summarized[filePath] = true
+				return "mock summary", nil
+			}}
+			var paths []string
+			for p := range files {
+				paths = append(paths, p)
+			}
+			ix := NewWithDependencies(st, "/repo", "repo", client, &MockFileSystemWalker{FilesToProcess: paths}, &MockFileReader{Files: files})
+			ix.RepoConfigFields = tt.fields
+			if err := ix.Run(context.Background()); err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			for p, lang := range tt.want {
+				if got, ok := langs[p]; !ok || got != lang {
+					t.Errorf("%s: language %q (indexed %v); want %q", p, got, ok, lang)
+				}
+			}
+			if len(langs) != len(tt.want) {
+				t.Errorf("indexed %v; want %v", langs, tt.want)
+			}
+			if chunks != tt.chunks {
+				t.Errorf("indexed %d chunks; want %d", chunks, tt.chunks)
+			}
+			if noSummarize := slices.Contains(tt.fields, "noSummarize"); summarized["internal/secret/key.go"] == noSummarize {
+				t.Errorf("internal/secret/key.go summarized: %v", summarized["internal/secret/key.go"])
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/seanblong/reposearch/internal/ai"
+	"github.com/seanblong/reposearch/internal/store"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// Report is a machine-readable summary of a single indexing run.
+type Report struct {
+	Repository         string    `json:"repository"`
+	Ref                string    `json:"ref"`
+	Commit             string    `json:"commit,omitempty"`
+	FilesScanned       int64     `json:"files_scanned"`
+	FilesSkipped       int64     `json:"files_skipped"`
+	FilesFailed        int64     `json:"files_failed"`
+	ChunksUpserted     int64     `json:"chunks_upserted"`
+	SummariesGenerated int64     `json:"summaries_generated"`
+	SummariesFallback  int64     `json:"summaries_fallback"` // heuristic summaries written without the model
+	EmbeddingsCreated  int64     `json:"embeddings_created"`
+	ChunksDeduplicated int64     `json:"chunks_deduplicated"`
+	RollupsUpdated     int64     `json:"rollups_updated"`
+	TokensUsed         int64     `json:"tokens_used"`
+	StartedAt          time.Time `json:"started_at"`
+	FinishedAt         time.Time `json:"finished_at"`
+	DurationMS         int64     `json:"duration_ms"`
+	Error              string    `json:"error,omitempty"`
+}
+
+// runStats holds the counters updated concurrently by workers during Run.
+type runStats struct {
+	filesScanned       atomic.Int64
+	filesSkipped       atomic.Int64
+	filesFailed        atomic.Int64
+	chunksUpserted     atomic.Int64
+	summariesGenerated atomic.Int64
+	summariesFallback  atomic.Int64
+	embeddingsCreated  atomic.Int64
+	chunksDeduplicated atomic.Int64
+	rollupsUpdated     atomic.Int64
+	tokensAtStart      int64
+	commit             string
+	startedAt          time.Time
+	finishedAt         time.Time
+	err                error
+}
+
+// Report returns a snapshot of the most recent (or in-progress) run.
+func (ix *Indexer) Report() Report {
+	st := &ix.stats
+	r := Report{
+		Repository:         ix.Repository,
+		Ref:                ix.Ref,
+		Commit:             st.commit,
+		FilesScanned:       st.filesScanned.Load(),
+		FilesSkipped:       st.filesSkipped.Load(),
+		FilesFailed:        st.filesFailed.Load(),
+		ChunksUpserted:     st.chunksUpserted.Load(),
+		SummariesGenerated: st.summariesGenerated.Load(),
+		SummariesFallback:  st.summariesFallback.Load(),
+		EmbeddingsCreated:  st.embeddingsCreated.Load(),
+		ChunksDeduplicated: st.chunksDeduplicated.Load(),
+		RollupsUpdated:     st.rollupsUpdated.Load(),
+		StartedAt:          st.startedAt,
+		FinishedAt:         st.finishedAt,
+	}
+	if u, ok := ix.Client.(ai.UsageReporter); ok {
+		r.TokensUsed = u.TokensUsed() - st.tokensAtStart
+	}
+	end := st.finishedAt
+	if end.IsZero() {
+		end = time.Now()
+	}
+	if !st.startedAt.IsZero() {
+		r.DurationMS = end.Sub(st.startedAt).Milliseconds()
+	}
+	if st.err != nil {
+		r.Error = st.err.Error()
+	}
+	return r
+}
+
+// recordRun stores the outcome of the finished run when the store keeps a
+// history of runs. A failure to record it is logged, not returned, so that it
+// never fails the run itself.
+func (ix *Indexer) recordRun(ctx context.Context) {
+	rec, ok := ix.Store.(store.IndexRunRecorder)
+	if !ok {
+		return
+	}
+	r := ix.Report()
+	run := models.IndexRun{
+		Repository:     r.Repository,
+		Ref:            r.Ref,
+		Commit:         r.Commit,
+		StartedAt:      r.StartedAt,
+		FinishedAt:     r.FinishedAt,
+		FilesScanned:   r.FilesScanned,
+		FilesFailed:    r.FilesFailed,
+		ChunksUpserted: r.ChunksUpserted,
+		Error:          r.Error,
+	}
+	if err := rec.RecordIndexRun(ctx, run); err != nil {
+		log.Warn().Err(err).Str("repository", r.Repository).Str("ref", r.Ref).Msg("failed to record index run")
+	}
+}
+
+// WriteReport encodes the run report as indented JSON to w.
+func (ix *Indexer) WriteReport(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ix.Report())
+}
+
+// WriteReportFile writes the run report to path, or to stdout when path is "-".
+func (ix *Indexer) WriteReportFile(path string) error {
+	if path == "-" {
+		return ix.WriteReport(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := ix.WriteReport(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
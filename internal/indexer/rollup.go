@@ -0,0 +1,202 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/seanblong/reposearch/internal/store"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// fileSection is the summary of one chunk of a file.
+type fileSection struct {
+	lineStart, lineEnd int
+	summary            string
+	vec                []float32
+}
+
+// rollupInput collects the chunk summaries of a file during a run.
+type rollupInput struct {
+	lang     string
+	sections []fileSection
+}
+
+// rollupCollector gathers chunk summaries from concurrent workers.
+type rollupCollector struct {
+	mu    sync.Mutex
+	files map[string]*rollupInput
+}
+
+func newRollupCollector() *rollupCollector {
+	return &rollupCollector{files: map[string]*rollupInput{}}
+}
+
+func (c *rollupCollector) add(relPath, lang string, sections []fileSection) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files[relPath] = &rollupInput{lang: lang, sections: sections}
+}
+
+// buildRollups stores a summary for every indexed file and, when
+// DirSummaries is set, for every directory above them. Rollups whose inputs
+// have not changed since the last run are left untouched.
+func (ix *Indexer) buildRollups(ctx context.Context, rs store.RollupStore, c *rollupCollector) error {
+	paths := make([]string, 0, len(c.files))
+	for p := range c.files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	fileSummaries := make(map[string]string, len(paths))
+	for _, p := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		in := c.files[p]
+		sort.Slice(in.sections, func(i, j int) bool { return in.sections[i].lineStart < in.sections[j].lineStart })
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "Summaries of consecutive sections of %s:\n", p)
+		for _, s := range in.sections {
+			fmt.Fprintf(&b, "- lines %d-%d: %s\n", s.lineStart, s.lineEnd, s.summary)
+		}
+
+		var summary string
+		var vec []float32
+		if len(in.sections) == 1 {
+			// A single chunk already describes the whole file.
+			summary, vec = in.sections[0].summary, in.sections[0].vec
+		}
+		s, err := ix.upsertRollup(ctx, rs, store.RollupFile, p, in.lang, b.String(), summary, vec)
+		if err != nil {
+			log.Warn().Err(err).Str("path", p).Msg("file rollup failed")
+			continue
+		}
+		fileSummaries[p] = s
+	}
+
+	if !ix.DirSummaries {
+		return nil
+	}
+	return ix.buildDirRollups(ctx, rs, fileSummaries)
+}
+
+// buildDirRollups summarizes each directory from the summaries of the files
+// and subdirectories directly inside it, deepest directories first.
+func (ix *Indexer) buildDirRollups(ctx context.Context, rs store.RollupStore, fileSummaries map[string]string) error {
+	root := "."
+	if ix.Subpath != "" {
+		root = ix.Subpath
+	}
+
+	children := map[string][]string{} // dir -> direct child files and dirs ("name/")
+	for p := range fileSummaries {
+		child := p
+		for d := path.Dir(p); ; d = path.Dir(d) {
+			children[d] = append(children[d], child)
+			if d == root || d == "." {
+				break
+			}
+			child = d + "/"
+		}
+	}
+
+	dirs := make([]string, 0, len(children))
+	for d := range children {
+		dirs = append(dirs, d)
+	}
+	depth := func(d string) int {
+		if d == "." {
+			return 0
+		}
+		return strings.Count(d, "/") + 1
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		if depth(dirs[i]) != depth(dirs[j]) {
+			return depth(dirs[i]) > depth(dirs[j])
+		}
+		return dirs[i] < dirs[j]
+	})
+
+	dirSummaries := map[string]string{}
+	for _, d := range dirs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entries := children[d]
+		sort.Strings(entries)
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "Contents of directory %s:\n", d)
+		seen := map[string]bool{}
+		for _, e := range entries {
+			if seen[e] {
+				continue
+			}
+			seen[e] = true
+			name := path.Base(strings.TrimSuffix(e, "/"))
+			var s string
+			if strings.HasSuffix(e, "/") {
+				s = dirSummaries[strings.TrimSuffix(e, "/")]
+				name += "/"
+			} else {
+				s = fileSummaries[e]
+			}
+			if s == "" {
+				continue
+			}
+			fmt.Fprintf(&b, "- %s: %s\n", name, s)
+		}
+
+		s, err := ix.upsertRollup(ctx, rs, store.RollupDir, d, "directory", b.String(), "", nil)
+		if err != nil {
+			log.Warn().Err(err).Str("path", d).Msg("directory rollup failed")
+			continue
+		}
+		dirSummaries[d] = s
+	}
+	return nil
+}
+
+// upsertRollup stores the rollup for p unless its input is unchanged, and
+// returns the resulting summary. When summary is empty it is generated from
+// input.
+func (ix *Indexer) upsertRollup(ctx context.Context, rs store.RollupStore, kind, p, lang, input, summary string, vec []float32) (string, error) {
+	hash := hashContent(input)
+	meta, found, err := rs.GetRollupMeta(ctx, ix.Repository, ix.Ref, kind, p)
+	if err == nil && found && meta.InputHash == hash && meta.Summary != "" {
+		return meta.Summary, nil
+	}
+
+	if summary == "" {
+		summary = ix.summarizeRollup(ctx, p, lang, input)
+	}
+	if vec == nil && ix.Client != nil {
+		vec, _ = ix.Client.Embed(summary)
+	}
+	r := models.Rollup{Repository: ix.Repository, Ref: ix.Ref, Kind: kind, Path: p, Summary: summary}
+	if err := rs.UpsertRollup(ctx, r, vec, hash); err != nil {
+		return "", err
+	}
+	ix.stats.rollupsUpdated.Add(1)
+	return summary, nil
+}
+
+func (ix *Indexer) summarizeRollup(ctx context.Context, p, lang, input string) string {
+	if ix.Client != nil {
+		if len(input) > maxSummarizeBytes {
+			input = input[:maxSummarizeBytes]
+		}
+		s, err := ix.Client.Summarize(ctx, p, lang, input)
+		if err == nil && strings.TrimSpace(s) != "" {
+			return s
+		}
+		log.Warn().Err(err).Str("path", p).Msg("rollup summarization failed, using heuristic")
+	}
+	return summarizeHeuristic(input)
+}
@@ -0,0 +1,126 @@
+package indexer
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/seanblong/reposearch/internal/store"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// mockRollupStore adds an in-memory store.RollupStore to MockIndexableStore.
+type mockRollupStore struct {
+	MockIndexableStore
+	mu      sync.Mutex
+	rollups map[string]models.Rollup
+	hashes  map[string]string
+	upserts int
+}
+
+func newMockRollupStore() *mockRollupStore {
+	return &mockRollupStore{rollups: map[string]models.Rollup{}, hashes: map[string]string{}}
+}
+
+func (m *mockRollupStore) GetRollupMeta(ctx context.Context, repository, ref, kind, path string) (store.RollupMeta, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.rollups[kind+":"+path]
+	return store.RollupMeta{InputHash: m.hashes[kind+":"+path], Summary: r.Summary}, ok, nil
+}
+
+func (m *mockRollupStore) UpsertRollup(ctx context.Context, r models.Rollup, summaryVec []float32, inputHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollups[r.Kind+":"+r.Path] = r
+	m.hashes[r.Kind+":"+r.Path] = inputHash
+	m.upserts++
+	return nil
+}
+
+func (m *mockRollupStore) SearchRollups(ctx context.Context, summaryVec []float32, k int, kind string, opt store.QueryOpts) ([]models.RollupResult, error) {
+	return nil, nil
+}
+
+func TestIndexer_Rollups(t *testing.T) {
+	files := map[string]string{
+		"/repo/main.go":                      "package main",
+		"/repo/services/payments/pay.go":     "package payments",
+		"/repo/services/payments/refund.go":  "package payments // refunds",
+		"/repo/services/payments/api/api.go": "package api",
+	}
+	var paths []string
+	for p := range files {
+		paths = append(paths, p)
+	}
+
+	st := newMockRollupStore()
+	var mu sync.Mutex
+	var dirInputs []string
+	client := &MockAIClient{
+		SummarizeFunc: func(ctx context.Context, filePath, language, content string) (string, error) {
+			if language == "directory" {
+				mu.Lock()
+				dirInputs = append(dirInputs, content)
+				mu.Unlock()
+				return "dir " + filePath, nil
+			}
+			return "summary of " + filePath, nil
+		},
+	}
+	ix := NewWithDependencies(st, "/repo", "repo", client, &MockFileSystemWalker{FilesToProcess: paths}, &MockFileReader{Files: files})
+	ix.DirSummaries = true
+
+	if err := ix.Run(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if got := st.rollups["file:services/payments/pay.go"].Summary; got != "summary of services/payments/pay.go" {
+		t.Errorf("Single-chunk file rollup should reuse the chunk summary, got %q", got)
+	}
+	for _, d := range []string{".", "services", "services/payments", "services/payments/api"} {
+		if got := st.rollups["dir:"+d].Summary; got != "dir "+d {
+			t.Errorf("Expected rollup for directory %s, got %q", d, got)
+		}
+	}
+	// Directories are summarized bottom-up from their direct children.
+	var payments string
+	for _, in := range dirInputs {
+		if strings.HasPrefix(in, "Contents of directory services/payments:") {
+			payments = in
+		}
+	}
+	for _, want := range []string{"- api/: dir services/payments/api", "- pay.go: summary of services/payments/pay.go", "- refund.go:"} {
+		if !strings.Contains(payments, want) {
+			t.Errorf("Directory input missing %q:\n%s", want, payments)
+		}
+	}
+	if r := ix.Report(); r.RollupsUpdated != 8 {
+		t.Errorf("Expected 8 rollups updated, got %d", r.RollupsUpdated)
+	}
+
+	// An unchanged second run leaves every rollup alone.
+	st.upserts = 0
+	dirInputs = nil
+	if err := ix.Run(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if st.upserts != 0 || len(dirInputs) != 0 {
+		t.Errorf("Expected no rollup updates on unchanged run, got %d upserts and %d summarize calls", st.upserts, len(dirInputs))
+	}
+}
+
+func TestIndexer_RollupsDisabledForPlainStore(t *testing.T) {
+	walker := &MockFileSystemWalker{FilesToProcess: []string{"/repo/a.go"}}
+	reader := &MockFileReader{Files: map[string]string{"/repo/a.go": "package a"}}
+	ix := NewWithDependencies(&MockIndexableStore{}, "/repo", "repo", &MockAIClient{}, walker, reader)
+	ix.DirSummaries = true
+
+	if err := ix.Run(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ix.Report().RollupsUpdated != 0 {
+		t.Error("Expected no rollups when the store does not support them")
+	}
+}
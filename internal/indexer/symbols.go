@@ -0,0 +1,86 @@
+package indexer
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/seanblong/reposearch/internal/store"
+)
+
+// symbolRule recognises a definition on a single line. The submatches of re
+// are joined with "." to form the symbol name, e.g. a Terraform resource
+// type and name.
+type symbolRule struct {
+	kind string
+	re   *regexp.Regexp
+}
+
+func rule(kind, pattern string) symbolRule {
+	return symbolRule{kind: kind, re: regexp.MustCompile(pattern)}
+}
+
+// symbolRules lists the definitions recognised per language, most specific
+// first; each line yields at most one symbol.
+var symbolRules = map[string][]symbolRule{
+	"go": {
+		rule("method", `^func\s+\([^)]*\)\s*([A-Za-z_]\w*)`),
+		rule("func", `^func\s+([A-Za-z_]\w*)`),
+		rule("type", `^type\s+([A-Za-z_]\w*)`),
+		rule("const", `^const\s+([A-Za-z_]\w*)`),
+		rule("var", `^var\s+([A-Za-z_]\w*)`),
+	},
+	"python": {
+		rule("class", `^\s*class\s+([A-Za-z_]\w*)`),
+		rule("func", `^\s*(?:async\s+)?def\s+([A-Za-z_]\w*)`),
+	},
+	"javascript": jsRules,
+	"typescript": append([]symbolRule{
+		rule("type", `^\s*(?:export\s+)?(?:declare\s+)?(?:interface|type|enum)\s+([A-Za-z_$][\w$]*)`),
+	}, jsRules...),
+	"java": {
+		rule("class", `^\s*(?:(?:public|protected|private|abstract|final|static|sealed)\s+)*(?:class|interface|enum|record)\s+([A-Za-z_]\w*)`),
+		rule("method", `^\s*(?:(?:public|protected|private|static|final|abstract|synchronized)\s+)+[\w<>\[\],.?\s]+?\s+([A-Za-z_]\w*)\s*\(`),
+	},
+	"ruby": {
+		rule("class", `^\s*(?:class|module)\s+([A-Z]\w*(?:::[A-Z]\w*)*)`),
+		rule("func", `^\s*def\s+(?:self\.)?([A-Za-z_]\w*[?!=]?)`),
+	},
+	"shell": {
+		rule("func", `^\s*function\s+([A-Za-z_][\w-]*)`),
+		rule("func", `^\s*([A-Za-z_][\w-]*)\s*\(\)`),
+	},
+	"terraform": {
+		rule("resource", `^\s*resource\s+"([^"]+)"\s+"([^"]+)"`),
+		rule("data", `^\s*data\s+"([^"]+)"\s+"([^"]+)"`),
+		rule("module", `^\s*module\s+"([^"]+)"`),
+		rule("variable", `^\s*variable\s+"([^"]+)"`),
+		rule("output", `^\s*output\s+"([^"]+)"`),
+	},
+}
+
+var jsRules = []symbolRule{
+	rule("class", `^\s*(?:export\s+)?(?:default\s+)?(?:abstract\s+)?class\s+([A-Za-z_$][\w$]*)`),
+	rule("func", `^\s*(?:export\s+)?(?:default\s+)?(?:async\s+)?function\*?\s+([A-Za-z_$][\w$]*)`),
+	rule("func", `^\s*(?:export\s+)?const\s+([A-Za-z_$][\w$]*)\s*=\s*(?:async\s+)?(?:\([^)]*\)|[A-Za-z_$][\w$]*)\s*=>`),
+}
+
+// extractSymbols returns the symbols defined in content, a chunk of a file in
+// lang that starts at line lineStart.
+func extractSymbols(lang, content string, lineStart int) []store.Symbol {
+	rules := symbolRules[lang]
+	if len(rules) == 0 {
+		return nil
+	}
+	var out []store.Symbol
+	for i, line := range strings.Split(content, "\n") {
+		for _, r := range rules {
+			m := r.re.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			out = append(out, store.Symbol{Name: strings.Join(m[1:], "."), Kind: r.kind, Line: lineStart + i})
+			break
+		}
+	}
+	return out
+}
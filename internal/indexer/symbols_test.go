@@ -0,0 +1,65 @@
+package indexer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/seanblong/reposearch/internal/store"
+)
+
+func TestExtractSymbols(t *testing.T) {
+	tests := []struct {
+		lang, content string
+		want          []store.Symbol
+	}{
+		{
+			lang:    "go",
+			content: "package a\n\ntype Server struct{}\n\nfunc (s *Server) Start() error {\n\treturn nil\n}\n\nfunc NewServer() *Server { return nil }\n\nconst maxConns = 10",
+			want: []store.Symbol{
+				{Name: "Server", Kind: "type", Line: 3},
+				{Name: "Start", Kind: "method", Line: 5},
+				{Name: "NewServer", Kind: "func", Line: 9},
+				{Name: "maxConns", Kind: "const", Line: 11},
+			},
+		},
+		{
+			lang:    "python",
+			content: "class Parser:\n    async def parse(self):\n        pass",
+			want: []store.Symbol{
+				{Name: "Parser", Kind: "class", Line: 1},
+				{Name: "parse", Kind: "func", Line: 2},
+			},
+		},
+		{
+			lang:    "typescript",
+			content: "export interface Options {}\nexport const load = async (path: string) => {}\nexport default function main() {}",
+			want: []store.Symbol{
+				{Name: "Options", Kind: "type", Line: 1},
+				{Name: "load", Kind: "func", Line: 2},
+				{Name: "main", Kind: "func", Line: 3},
+			},
+		},
+		{
+			lang:    "terraform",
+			content: `resource "aws_s3_bucket" "logs" {` + "\n}\nvariable \"region\" {}",
+			want: []store.Symbol{
+				{Name: "aws_s3_bucket.logs", Kind: "resource", Line: 1},
+				{Name: "region", Kind: "variable", Line: 3},
+			},
+		},
+		{lang: "shell", content: "deploy() {\n  echo hi\n}", want: []store.Symbol{{Name: "deploy", Kind: "func", Line: 1}}},
+		{lang: "markdown", content: "# func main()", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.lang, func(t *testing.T) {
+			if got := extractSymbols(tt.lang, tt.content, 1); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extractSymbols() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	got := extractSymbols("go", "\nfunc later() {}", 40)
+	if len(got) != 1 || got[0].Line != 41 {
+		t.Errorf("expected line offset by the chunk start, got %+v", got)
+	}
+}
@@ -0,0 +1,71 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/seanblong/reposearch/internal/store"
+)
+
+// MigrateVectors re-embeds the summary of every chunk and rollup with the
+// embedding model, which produces vectors of dim dimensions, and swaps the
+// new vectors in once all are written. Summaries are not regenerated. The
+// old vectors keep serving searches until the swap; an interrupted or
+// partially failed migration is resumed by running it again.
+func (m *Maintainer) MigrateVectors(ctx context.Context, dim int) (int, error) {
+	st, ok := m.Store.(store.VectorMigrationStore)
+	if !ok {
+		return 0, errors.New("the store does not support vector migrations")
+	}
+	if err := st.BeginVectorMigration(ctx, dim); err != nil {
+		return 0, err
+	}
+	size := m.BatchSize
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	total := 0
+	var after store.PendingVector
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		batch, err := st.ListPendingVectors(ctx, after, size)
+		if err != nil {
+			return total, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		var done []store.PendingVector
+		for _, v := range batch {
+			vec, err := m.Client.Embed(v.Summary)
+			if err == nil && len(vec) != dim {
+				err = fmt.Errorf("embedding model produced %d dimensions, expected %d", len(vec), dim)
+			}
+			if err != nil {
+				log.Warn().Err(err).Str("id", v.ID).Str("path", v.Path).Msg("reembed failed, leaving summary pending")
+				continue
+			}
+			v.Vector = vec
+			done = append(done, v)
+		}
+		if len(done) > 0 {
+			if err := st.WritePendingVectors(context.WithoutCancel(ctx), done); err != nil {
+				return total, err
+			}
+		}
+		total += len(done)
+		after = batch[len(batch)-1]
+		log.Info().Int("refreshed", total).Str("model", m.EmbedModel).Msg("vector migration batch complete")
+		if m.OnBatch != nil {
+			m.OnBatch(total)
+		}
+		if len(batch) < size {
+			break
+		}
+	}
+	return total, st.CommitVectorMigration(ctx, m.EmbedModel)
+}
@@ -0,0 +1,173 @@
+package indexer
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+	"github.com/seanblong/reposearch/internal/store"
+)
+
+// defaultWriteBatchSize is the number of chunks buffered per worker before
+// they are written to the store.
+const defaultWriteBatchSize = 100
+
+// pendingFile tracks the chunks of one file until all of them are written.
+type pendingFile struct {
+	relPath, lang string
+	sections      []fileSection
+	chunks        []pendingChunk // held until the file is closed, for a FileWriter
+	remaining     int            // queued chunks not yet written
+	closed        bool           // no more chunks will be queued
+	incomplete    bool           // processing stopped before every chunk was queued
+	failed        bool
+}
+
+// pendingChunk is a chunk waiting in a chunkBatch.
+type pendingChunk struct {
+	chunk   store.ChunkWithVec
+	file    *pendingFile
+	section fileSection
+}
+
+// chunkBatch buffers chunk writes for a single worker. It is not safe for
+// concurrent use; each worker owns one.
+type chunkBatch struct {
+	size    int
+	pending []pendingChunk
+	files   []*pendingFile // whole files, for a FileWriter
+	queued  int            // chunks in files
+}
+
+func (ix *Indexer) newChunkBatch() *chunkBatch {
+	size := ix.WriteBatchSize
+	if size <= 0 {
+		size = defaultWriteBatchSize
+	}
+	return &chunkBatch{size: size, pending: make([]pendingChunk, 0, size)}
+}
+
+// add queues a chunk and flushes the batch once it is full. With a
+// FileWriter the chunk is held on its file until the file is closed.
+func (ix *Indexer) add(ctx context.Context, b *chunkBatch, p pendingChunk) {
+	if ix.files != nil {
+		p.file.chunks = append(p.file.chunks, p)
+		return
+	}
+	p.file.remaining++
+	b.pending = append(b.pending, p)
+	if len(b.pending) >= b.size {
+		ix.flush(ctx, b)
+	}
+}
+
+// flush writes all buffered chunks. If the bulk write fails the chunks are
+// retried one by one so that a single bad chunk only fails its own file.
+func (ix *Indexer) flush(ctx context.Context, b *chunkBatch) {
+	if len(b.files) > 0 {
+		ix.flushFiles(ctx, b)
+	}
+	if len(b.pending) == 0 {
+		return
+	}
+	// Buffered chunks are always written, even if the run is cancelled.
+	ctx = context.WithoutCancel(ctx)
+
+	items := make([]store.ChunkWithVec, len(b.pending))
+	for i, p := range b.pending {
+		items[i] = p.chunk
+	}
+
+	errs := make([]error, len(b.pending))
+	if err := ix.Store.UpsertChunks(ctx, items); err != nil {
+		log.Warn().Err(err).Int("chunks", len(items)).Msg("bulk upsert failed, retrying chunks individually")
+		for i, it := range items {
+			errs[i] = ix.Store.UpsertChunk(ctx, it.Chunk, it.SummaryVec, it.ContentHash)
+		}
+	}
+
+	for i, p := range b.pending {
+		if errs[i] != nil {
+			log.Error().Err(errs[i]).Str("path", p.file.relPath).Msg("upsert failed")
+			p.file.failed = true
+		} else {
+			ix.stats.chunksUpserted.Add(1)
+			p.file.sections = append(p.file.sections, p.section)
+		}
+		p.file.remaining--
+		if p.file.remaining == 0 && p.file.closed {
+			ix.finishFile(p.file)
+		}
+	}
+	b.pending = b.pending[:0]
+}
+
+// flushFiles writes the buffered files in one transaction. If that fails the
+// files are retried one by one so that a single bad file only fails itself.
+func (ix *Indexer) flushFiles(ctx context.Context, b *chunkBatch) {
+	// Buffered files are always written, even if the run is cancelled.
+	ctx = context.WithoutCancel(ctx)
+
+	items := make([]store.FileChunks, len(b.files))
+	for i, f := range b.files {
+		items[i] = store.FileChunks{Repository: ix.Repository, Ref: ix.Ref, Path: f.relPath}
+		for _, p := range f.chunks {
+			items[i].Chunks = append(items[i].Chunks, p.chunk)
+		}
+	}
+
+	errs := make([]error, len(items))
+	if err := ix.files.WriteFiles(ctx, items); err != nil {
+		log.Warn().Err(err).Int("files", len(items)).Msg("bulk file write failed, retrying files individually")
+		for i, it := range items {
+			errs[i] = ix.files.WriteFiles(ctx, []store.FileChunks{it})
+		}
+	}
+
+	for i, f := range b.files {
+		if errs[i] != nil {
+			log.Error().Err(errs[i]).Str("path", f.relPath).Msg("file write failed")
+			f.failed = true
+		} else {
+			ix.stats.chunksUpserted.Add(int64(len(f.chunks)))
+			for _, p := range f.chunks {
+				f.sections = append(f.sections, p.section)
+			}
+		}
+		f.chunks = nil
+		ix.finishFile(f)
+	}
+	b.files, b.queued = b.files[:0], 0
+}
+
+// closeFile marks that all chunks of f have been queued. With a FileWriter
+// the complete file joins the batch; a file cut short by cancellation is not
+// written at all, leaving its previously indexed chunks in place.
+func (ix *Indexer) closeFile(ctx context.Context, b *chunkBatch, f *pendingFile) {
+	f.closed = true
+	if ix.files != nil {
+		if f.incomplete {
+			log.Info().Str("path", f.relPath).Msg("indexing interrupted, leaving file unchanged")
+			return
+		}
+		b.files = append(b.files, f)
+		b.queued += len(f.chunks)
+		if b.queued >= b.size {
+			ix.flush(ctx, b)
+		}
+		return
+	}
+	if f.remaining == 0 {
+		ix.finishFile(f)
+	}
+}
+
+// finishFile records the outcome of a file once all its chunks are written.
+func (ix *Indexer) finishFile(f *pendingFile) {
+	if f.failed {
+		ix.stats.filesFailed.Add(1)
+		return
+	}
+	if ix.rollups != nil && !f.incomplete {
+		ix.rollups.add(f.relPath, f.lang, f.sections)
+	}
+}
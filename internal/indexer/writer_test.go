@@ -0,0 +1,145 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/seanblong/reposearch/internal/store"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+func newBatchTestIndexer(st store.ChunkStore, n int) *Indexer {
+	files := map[string]string{}
+	var paths []string
+	for i := 0; i < n; i++ {
+		p := fmt.Sprintf("/repo/f%d.go", i)
+		files[p] = fmt.Sprintf("package f%d", i)
+		paths = append(paths, p)
+	}
+	return NewWithDependencies(st, "/repo", "repo", &MockAIClient{}, &MockFileSystemWalker{FilesToProcess: paths}, &MockFileReader{Files: files})
+}
+
+func TestIndexer_BatchedWrites(t *testing.T) {
+	var mu sync.Mutex
+	var batches []int
+	total := 0
+	st := &MockIndexableStore{
+		UpsertChunksFunc: func(ctx context.Context, chunks []store.ChunkWithVec) error {
+			mu.Lock()
+			defer mu.Unlock()
+			batches = append(batches, len(chunks))
+			total += len(chunks)
+			return nil
+		},
+		UpsertChunkFunc: func(ctx context.Context, c models.Chunk, summaryVec []float32, contentHash string) error {
+			t.Error("Single upserts should not be used when the bulk write succeeds")
+			return nil
+		},
+	}
+	ix := newBatchTestIndexer(st, 10)
+	ix.WriteBatchSize = 3
+
+	if err := ix.Run(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if total != 10 {
+		t.Errorf("Expected 10 chunks written, got %d", total)
+	}
+	for _, n := range batches {
+		if n > 3 {
+			t.Errorf("Batch of %d exceeds the batch size", n)
+		}
+	}
+	if r := ix.Report(); r.ChunksUpserted != 10 || r.FilesFailed != 0 {
+		t.Errorf("Unexpected report %+v", r)
+	}
+}
+
+func TestIndexer_BatchFallback(t *testing.T) {
+	st := &MockIndexableStore{
+		UpsertChunksFunc: func(ctx context.Context, chunks []store.ChunkWithVec) error {
+			return errors.New("batch rejected")
+		},
+		UpsertChunkFunc: func(ctx context.Context, c models.Chunk, summaryVec []float32, contentHash string) error {
+			if c.Path == "f3.go" {
+				return errors.New("bad chunk")
+			}
+			return nil
+		},
+	}
+	ix := newBatchTestIndexer(st, 5)
+	ix.WriteBatchSize = 10
+
+	if err := ix.Run(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if r := ix.Report(); r.ChunksUpserted != 4 || r.FilesFailed != 1 {
+		t.Errorf("Expected 4 chunks upserted and 1 failed file, got %+v", r)
+	}
+}
+
+// fileWriterStore is a MockIndexableStore that also implements
+// store.FileWriter.
+type fileWriterStore struct {
+	MockIndexableStore
+	mu      sync.Mutex
+	calls   [][]string // paths per WriteFiles call
+	failFor string
+}
+
+func (s *fileWriterStore) WriteFiles(ctx context.Context, files []store.FileChunks) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var paths []string
+	for _, f := range files {
+		if f.Path == s.failFor {
+			return errors.New("bad file")
+		}
+		paths = append(paths, f.Path)
+	}
+	s.calls = append(s.calls, paths)
+	return nil
+}
+
+func TestIndexer_FileWrites(t *testing.T) {
+	st := &fileWriterStore{}
+	st.UpsertChunksFunc = func(ctx context.Context, chunks []store.ChunkWithVec) error {
+		t.Error("Chunk upserts should not be used with a FileWriter")
+		return nil
+	}
+	ix := newBatchTestIndexer(st, 7)
+	ix.WriteBatchSize = 2
+
+	if err := ix.Run(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	files := 0
+	for _, c := range st.calls {
+		if len(c) > 2 {
+			t.Errorf("Write of %d files exceeds the batch size", len(c))
+		}
+		files += len(c)
+	}
+	if files != 7 {
+		t.Errorf("Expected 7 files written, got %d", files)
+	}
+	if r := ix.Report(); r.ChunksUpserted != 7 || r.FilesFailed != 0 {
+		t.Errorf("Unexpected report %+v", r)
+	}
+}
+
+func TestIndexer_FileWriteFallback(t *testing.T) {
+	st := &fileWriterStore{failFor: "f2.go"}
+	ix := newBatchTestIndexer(st, 4)
+	ix.WriteBatchSize = 10
+
+	if err := ix.Run(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if r := ix.Report(); r.ChunksUpserted != 3 || r.FilesFailed != 1 {
+		t.Errorf("Expected 3 chunks upserted and 1 failed file, got %+v", r)
+	}
+}
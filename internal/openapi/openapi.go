@@ -0,0 +1,312 @@
+// Package openapi builds an OpenAPI 3 document from Go types, so the
+// published API contract follows the structs the handlers actually encode.
+package openapi
+
+import (
+	"encoding/json"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version is the OpenAPI version of the generated documents.
+const Version = "3.0.3"
+
+// Auth is the authentication an operation accepts.
+type Auth int
+
+const (
+	AuthNone     Auth = iota // no credentials are read
+	AuthOptional             // credentials are checked when auth is enabled
+	AuthRequired             // credentials are always required
+)
+
+// Param is a path, query or header parameter. Type is a value of the Go type
+// the handler parses it into, e.g. 0 for an integer or []string{} for a
+// repeated parameter; nil means a string.
+type Param struct {
+	Name        string
+	In          string // "path", "query" or "header"
+	Description string
+	Required    bool
+	Type        any
+}
+
+// Operation describes one method on one path. Request and Response are
+// values of the JSON body types, or nil when there is no body. Responses
+// other than Status are plain-text errors.
+type Operation struct {
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	Tags        []string
+	Auth        Auth
+	Params      []Param
+	Request     any
+	Response    any
+	Status      int     // defaults to 200
+	Headers     []Param // response headers
+}
+
+// OneOf is a body that is one of several types, e.g. a response whose shape
+// depends on a parameter.
+func OneOf(types ...any) any { return oneOf(types) }
+
+type oneOf []any
+
+// Text is a body of the given media type, such as text/plain or
+// text/event-stream, rather than JSON.
+func Text(mediaType string) any { return text(mediaType) }
+
+type text string
+
+// Spec collects operations and the schemas of the types they use.
+type Spec struct {
+	title, version, description string
+
+	// CookieName is the name of the cookie carrying the access token.
+	CookieName string
+
+	paths   map[string]map[string]any
+	schemas map[string]any
+	names   map[reflect.Type]string
+}
+
+// New creates an empty Spec.
+func New(title, version, description string) *Spec {
+	return &Spec{
+		title:       title,
+		version:     version,
+		description: description,
+		CookieName:  "auth_token",
+		paths:       map[string]map[string]any{},
+		schemas:     map[string]any{},
+		names:       map[reflect.Type]string{},
+	}
+}
+
+// Add documents an operation.
+func (s *Spec) Add(op Operation) {
+	o := map[string]any{"summary": op.Summary}
+	if op.Description != "" {
+		o["description"] = op.Description
+	}
+	if len(op.Tags) > 0 {
+		o["tags"] = op.Tags
+	}
+	if len(op.Params) > 0 {
+		params := make([]any, len(op.Params))
+		for i, p := range op.Params {
+			params[i] = s.param(p)
+		}
+		o["parameters"] = params
+	}
+	if op.Request != nil {
+		o["requestBody"] = map[string]any{"required": true, "content": s.content(op.Request)}
+	}
+	switch op.Auth {
+	case AuthOptional:
+		o["security"] = []any{map[string]any{}, map[string]any{"bearerAuth": []string{}}, map[string]any{"cookieAuth": []string{}}, map[string]any{"apiKeyAuth": []string{}}}
+	case AuthRequired:
+		o["security"] = []any{map[string]any{"bearerAuth": []string{}}, map[string]any{"cookieAuth": []string{}}, map[string]any{"apiKeyAuth": []string{}}}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = 200
+	}
+	ok := map[string]any{"description": "Success"}
+	if op.Response != nil {
+		ok["content"] = s.content(op.Response)
+	}
+	if len(op.Headers) > 0 {
+		headers := map[string]any{}
+		for _, h := range op.Headers {
+			headers[h.Name] = map[string]any{"description": h.Description, "schema": s.schema(typeOf(h.Type))}
+		}
+		ok["headers"] = headers
+	}
+	o["responses"] = map[string]any{
+		strconv.Itoa(status): ok,
+		"default": map[string]any{
+			"description": "Error",
+			"content":     map[string]any{"text/plain": map[string]any{"schema": map[string]any{"type": "string"}}},
+		},
+	}
+
+	if s.paths[op.Path] == nil {
+		s.paths[op.Path] = map[string]any{}
+	}
+	s.paths[op.Path][strings.ToLower(op.Method)] = o
+}
+
+// MarshalJSON encodes the OpenAPI document.
+func (s *Spec) MarshalJSON() ([]byte, error) {
+	info := map[string]any{"title": s.title, "version": s.version}
+	if s.description != "" {
+		info["description"] = s.description
+	}
+	return json.Marshal(map[string]any{
+		"openapi": Version,
+		"info":    info,
+		"paths":   s.paths,
+		"components": map[string]any{
+			"schemas": s.schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"cookieAuth": map[string]any{"type": "apiKey", "in": "cookie", "name": s.CookieName},
+				"apiKeyAuth": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key", "description": "An API key; its scopes limit the endpoints it may call."},
+			},
+		},
+	})
+}
+
+func (s *Spec) param(p Param) map[string]any {
+	out := map[string]any{"name": p.Name, "in": p.In, "schema": s.schema(typeOf(p.Type))}
+	if p.Description != "" {
+		out["description"] = p.Description
+	}
+	if p.Required || p.In == "path" {
+		out["required"] = true
+	}
+	if t := typeOf(p.Type); t.Kind() == reflect.Slice {
+		// Repeated parameters; the API also accepts comma-separated values.
+		out["style"], out["explode"] = "form", true
+	}
+	return out
+}
+
+func (s *Spec) content(body any) map[string]any {
+	var schema any
+	if t, ok := body.(text); ok {
+		return map[string]any{string(t): map[string]any{"schema": map[string]any{"type": "string"}}}
+	}
+	if alts, ok := body.(oneOf); ok {
+		var refs []any
+		for _, a := range alts {
+			refs = append(refs, s.schema(reflect.TypeOf(a)))
+		}
+		schema = map[string]any{"oneOf": refs}
+	} else {
+		schema = s.schema(reflect.TypeOf(body))
+	}
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+)
+
+func typeOf(v any) reflect.Type {
+	if v == nil {
+		return reflect.TypeOf("")
+	}
+	return reflect.TypeOf(v)
+}
+
+// schema returns the schema of t. Named structs are added to the components
+// and referenced, so shared types such as models.Chunk appear once.
+func (s *Spec) schema(t reflect.Type) map[string]any {
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	if t == rawType {
+		return map[string]any{"type": "object"} // arbitrary JSON
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		out := s.schema(t.Elem())
+		if _, ref := out["$ref"]; ref {
+			return map[string]any{"allOf": []any{out}, "nullable": true}
+		}
+		out["nullable"] = true
+		return out
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32:
+		return map[string]any{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]any{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		name, ok := s.names[t]
+		if !ok {
+			name = s.name(t)
+			s.names[t] = name
+			s.schemas[name] = map[string]any{} // placeholder for recursive types
+			s.schemas[name] = s.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+// name picks a component name for t, qualifying it with its package when
+// another package already uses the bare name.
+func (s *Spec) name(t reflect.Type) string {
+	name := t.Name()
+	if _, taken := s.schemas[name]; taken {
+		pkg := path.Base(t.PkgPath())
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	return name
+}
+
+// object builds the schema of a struct from its JSON encoding: fields
+// without omitempty are required, and embedded structs are flattened.
+func (s *Spec) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	var required []string
+	s.fields(t, props, &required)
+	out := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		sort.Strings(required)
+		out["required"] = required
+	}
+	return out
+}
+
+func (s *Spec) fields(t reflect.Type, props map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			s.fields(f.Type, props, required)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = s.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
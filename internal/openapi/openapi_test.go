@@ -0,0 +1,140 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type Inner struct {
+	Value string `json:"value"`
+}
+
+type Embedded struct {
+	Note string `json:"note,omitempty"`
+}
+
+type outer struct {
+	Embedded
+	Name    string           `json:"name"`
+	Count   int64            `json:"count"`
+	Tags    []string         `json:"tags,omitempty"`
+	Scores  map[string]int   `json:"scores"`
+	At      time.Time        `json:"at"`
+	Inner   Inner            `json:"inner"`
+	Next    *Inner           `json:"next,omitempty"`
+	Raw     []byte           `json:"raw,omitempty"`
+	JSON    json.RawMessage  `json:"json,omitempty"`
+	Skipped string           `json:"-"`
+	Nested  struct{ A bool } `json:"nested"`
+}
+
+func decode(t *testing.T, s *Spec) map[string]any {
+	t.Helper()
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	return doc
+}
+
+func TestSchema(t *testing.T) {
+	s := New("test", "1", "")
+	s.Add(Operation{Method: "GET", Path: "/x", Summary: "x", Response: []outer{}})
+	doc := decode(t, s)
+
+	schemas := doc["components"].(map[string]any)["schemas"].(map[string]any)
+	got := schemas["outer"].(map[string]any)
+	props := got["properties"].(map[string]any)
+	for _, name := range []string{"note", "name", "count", "tags", "scores", "at", "inner", "next", "raw", "json", "nested"} {
+		if _, ok := props[name]; !ok {
+			t.Errorf("missing property %q", name)
+		}
+	}
+	if len(props) != 11 {
+		t.Errorf("got %d properties, want 11: %v", len(props), props)
+	}
+	wantRequired := []any{"at", "count", "inner", "name", "nested", "scores"}
+	if !reflect.DeepEqual(got["required"], wantRequired) {
+		t.Errorf("required = %v, want %v", got["required"], wantRequired)
+	}
+	checks := map[string]map[string]any{
+		"count":  {"type": "integer", "format": "int64"},
+		"at":     {"type": "string", "format": "date-time"},
+		"raw":    {"type": "string", "format": "byte"},
+		"json":   {"type": "object"},
+		"inner":  {"$ref": "#/components/schemas/Inner"},
+		"tags":   {"type": "array", "items": map[string]any{"type": "string"}},
+		"scores": {"type": "object", "additionalProperties": map[string]any{"type": "integer", "format": "int32"}},
+		"next":   {"allOf": []any{map[string]any{"$ref": "#/components/schemas/Inner"}}, "nullable": true},
+	}
+	for name, want := range checks {
+		if !reflect.DeepEqual(props[name], map[string]any(want)) {
+			t.Errorf("%s = %v, want %v", name, props[name], want)
+		}
+	}
+	if _, ok := schemas["Inner"]; !ok {
+		t.Error("Inner was not added to the components")
+	}
+
+	resp := doc["paths"].(map[string]any)["/x"].(map[string]any)["get"].(map[string]any)["responses"].(map[string]any)
+	schema := resp["200"].(map[string]any)["content"].(map[string]any)["application/json"].(map[string]any)["schema"]
+	want := map[string]any{"type": "array", "items": map[string]any{"$ref": "#/components/schemas/outer"}}
+	if !reflect.DeepEqual(schema, want) {
+		t.Errorf("response schema = %v, want %v", schema, want)
+	}
+	if _, ok := resp["default"]; !ok {
+		t.Error("missing default error response")
+	}
+}
+
+func TestOperation(t *testing.T) {
+	s := New("test", "1", "desc")
+	s.Add(Operation{
+		Method: "DELETE", Path: "/items/{id}", Summary: "delete", Auth: AuthRequired, Status: 204,
+		Params: []Param{{Name: "id", In: "path"}, {Name: "tag", In: "query", Type: []string{}}, {Name: "k", In: "query", Type: 0}},
+	})
+	s.Add(Operation{Method: "GET", Path: "/items/{id}", Summary: "get", Auth: AuthOptional, Response: OneOf(Inner{}, Embedded{})})
+	s.Add(Operation{Method: "GET", Path: "/items/{id}/raw", Summary: "raw", Response: Text("text/plain")})
+	doc := decode(t, s)
+
+	if doc["openapi"] != Version || doc["info"].(map[string]any)["description"] != "desc" {
+		t.Errorf("unexpected header: %v %v", doc["openapi"], doc["info"])
+	}
+	item := doc["paths"].(map[string]any)["/items/{id}"].(map[string]any)
+	del := item["delete"].(map[string]any)
+	if _, ok := del["responses"].(map[string]any)["204"]; !ok {
+		t.Errorf("missing 204 response: %v", del["responses"])
+	}
+	if len(del["security"].([]any)) != 3 {
+		t.Errorf("required auth should list the three schemes: %v", del["security"])
+	}
+	params := del["parameters"].([]any)
+	if params[0].(map[string]any)["required"] != true {
+		t.Error("path parameters must be required")
+	}
+	if params[1].(map[string]any)["explode"] != true {
+		t.Error("slice parameters should be repeated")
+	}
+	if params[2].(map[string]any)["schema"].(map[string]any)["type"] != "integer" {
+		t.Errorf("k should be an integer: %v", params[2])
+	}
+
+	get := item["get"].(map[string]any)
+	if sec := get["security"].([]any); len(sec) != 4 || len(sec[0].(map[string]any)) != 0 {
+		t.Errorf("optional auth should allow anonymous access: %v", sec)
+	}
+	raw := doc["paths"].(map[string]any)["/items/{id}/raw"].(map[string]any)["get"].(map[string]any)["responses"].(map[string]any)["200"].(map[string]any)
+	if _, ok := raw["content"].(map[string]any)["text/plain"]; !ok {
+		t.Errorf("expected a text/plain response: %v", raw)
+	}
+	schemas := doc["components"].(map[string]any)["schemas"].(map[string]any)
+	if _, ok := schemas["Embedded"]; !ok {
+		t.Errorf("missing inner schema: %v", schemas)
+	}
+}
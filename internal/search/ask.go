@@ -0,0 +1,171 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/seanblong/reposearch/internal/ai"
+	"github.com/seanblong/reposearch/internal/store"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// Limits on the prompt and answer of Ask.
+const (
+	askContextBytes = 24000 // chunk content given to the model in total
+	askChunkBytes   = 6000  // content of a single chunk
+	askMaxTokens    = 800
+)
+
+// ErrAskUnsupported is returned by Ask when the AI client cannot generate
+// free-form text.
+var ErrAskUnsupported = errors.New("AI provider does not support answering questions")
+
+const askSystem = "You answer questions about a codebase using only the numbered sources provided. " +
+	"End every sentence with the numbers of the sources that support it in square brackets, e.g. \"Tokens are refreshed hourly [2][3].\" " +
+	"If the sources do not contain the answer, say so. Do not invent files, functions or behavior. Write plain text without code blocks."
+
+// citationRe matches a citation such as [2] or [2, 3].
+var citationRe = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// Ask answers a natural-language question from the top k chunks of a search
+// for it, citing the chunks each sentence was drawn from.
+func (s *Service) Ask(ctx context.Context, question string, k int, opt store.QueryOpts) (models.Answer, error) {
+	gen, ok := s.Client.(ai.Generator)
+	if !ok {
+		return models.Answer{}, ErrAskUnsupported
+	}
+	question = strings.TrimSpace(question)
+	answer := models.Answer{Question: question, Sentences: []models.AnswerSentence{}}
+	sources, err := s.askSources(ctx, question, k, opt, &answer)
+	if err != nil {
+		return models.Answer{}, err
+	}
+	if len(answer.Sources) == 0 {
+		answer.Answer = noAnswer
+		return answer, nil
+	}
+
+	text, err := gen.Generate(ctx, askSystem, sources+"Question: "+question, askMaxTokens)
+	if err != nil {
+		return models.Answer{}, err
+	}
+	setAnswer(&answer, text)
+	return answer, nil
+}
+
+// noAnswer is the answer when the search finds nothing to cite.
+const noAnswer = "No indexed code matched the question."
+
+// askSources searches for query, fills in the content of the results and
+// adds those that fit the prompt to answer.Sources. It returns the numbered
+// sources as prompt text.
+func (s *Service) askSources(ctx context.Context, query string, k int, opt store.QueryOpts, answer *models.Answer) (string, error) {
+	res, err := s.Query(ctx, query, k, opt)
+	if err != nil {
+		return "", err
+	}
+	if s.Content != nil {
+		ptrs := make([]*models.Chunk, len(res))
+		for i := range res {
+			ptrs[i] = &res[i].Chunk
+		}
+		s.Content(ctx, ptrs)
+	}
+
+	answer.Sources = []models.Citation{}
+	var prompt strings.Builder
+	prompt.WriteString("Sources:\n\n")
+	budget := askContextBytes
+	for _, r := range res {
+		c := r.Chunk
+		content := c.Content
+		if content == "" {
+			content = c.Summary
+		}
+		if len(content) > askChunkBytes {
+			content = content[:askChunkBytes]
+		}
+		if len(content) > budget {
+			break
+		}
+		budget -= len(content)
+		n := len(answer.Sources) + 1
+		answer.Sources = append(answer.Sources, models.Citation{
+			N: n, ChunkID: c.ID, Repository: c.Repository, Ref: c.Ref, Path: c.Path,
+			LineStart: c.LineStart, LineEnd: c.LineEnd, Score: r.Score,
+		})
+		fmt.Fprintf(&prompt, "[%d] %s (%s@%s, lines %d-%d)\n%s\n\n", n, c.Path, c.Repository, c.Ref, c.LineStart, c.LineEnd, content)
+	}
+	return prompt.String(), nil
+}
+
+// setAnswer sets the generated text of answer and splits it into cited
+// sentences.
+func setAnswer(answer *models.Answer, text string) {
+	answer.Answer = text
+	for _, sentence := range splitSentences(text) {
+		answer.Sentences = append(answer.Sentences, citeSentence(sentence, len(answer.Sources)))
+	}
+}
+
+// splitSentences splits text into sentences at line breaks and at '.', '!'
+// or '?' followed by whitespace, keeping citations that follow the
+// punctuation with their sentence.
+func splitSentences(text string) []string {
+	var out []string
+	for _, line := range strings.Split(text, "\n") {
+		start := 0
+		for i := 0; i < len(line); i++ {
+			if !strings.ContainsRune(".!?", rune(line[i])) {
+				continue
+			}
+			end := i + 1
+			for {
+				rest := strings.TrimLeft(line[end:], " ")
+				loc := citationRe.FindStringIndex(rest)
+				if loc == nil || loc[0] != 0 {
+					break
+				}
+				end = len(line) - len(rest) + loc[1]
+			}
+			if end < len(line) && line[end] != ' ' {
+				continue // e.g. a decimal point or file extension
+			}
+			if s := strings.TrimSpace(line[start:end]); s != "" {
+				out = append(out, s)
+			}
+			start, i = end, end-1
+		}
+		if s := strings.TrimSpace(line[start:]); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// citeSentence extracts the citations of a sentence, dropping numbers that
+// do not name a source, and removes them from its text.
+func citeSentence(sentence string, sources int) models.AnswerSentence {
+	out := models.AnswerSentence{Citations: []int{}}
+	seen := map[int]bool{}
+	for _, m := range citationRe.FindAllStringSubmatch(sentence, -1) {
+		for _, f := range strings.Split(m[1], ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(f))
+			if err == nil && n >= 1 && n <= sources && !seen[n] {
+				seen[n] = true
+				out.Citations = append(out.Citations, n)
+			}
+		}
+	}
+	text := citationRe.ReplaceAllString(sentence, "")
+	text = strings.Join(strings.Fields(text), " ")
+	for _, p := range []string{".", "!", "?", ",", ";", ":"} {
+		text = strings.ReplaceAll(text, " "+p, p)
+	}
+	out.Text = text
+	return out
+}
@@ -0,0 +1,88 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/seanblong/reposearch/internal/store"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// mockGenerator is a MockAIClient that can also generate text.
+type mockGenerator struct {
+	MockAIClient
+	prompt string
+	answer string
+}
+
+func (m *mockGenerator) Generate(ctx context.Context, system, prompt string, maxTokens int) (string, error) {
+	m.prompt = prompt
+	return m.answer, nil
+}
+
+func TestService_Ask(t *testing.T) {
+	st := &MockSearchableStore{SearchFunc: func(ctx context.Context, head []float32, k int, opt store.QueryOpts) ([]models.SearchResult, error) {
+		return []models.SearchResult{
+			{Chunk: models.Chunk{ID: "a", Repository: "r", Ref: "main", Path: "auth.go", LineStart: 1, LineEnd: 20, Content: "func Refresh() {}"}, Score: 0.9},
+			{Chunk: models.Chunk{ID: "b", Repository: "r", Ref: "main", Path: "jwt.go", LineStart: 5, LineEnd: 9, Summary: "Signs tokens."}, Score: 0.5},
+		}, nil
+	}}
+	gen := &mockGenerator{answer: "Tokens are refreshed by Refresh [1]. They are signed in jwt.go [2, 7].\nSee also v1.2 notes."}
+	svc := NewService(gen, st)
+	var filled int
+	svc.Content = func(ctx context.Context, chunks []*models.Chunk) { filled = len(chunks) }
+
+	got, err := svc.Ask(context.Background(), "  how are tokens refreshed? ", 5, store.QueryOpts{})
+	if err != nil {
+		t.Fatalf("Ask: %v", err)
+	}
+	if filled != 2 {
+		t.Errorf("Content filled %d chunks, want 2", filled)
+	}
+	if !strings.Contains(gen.prompt, "[1] auth.go (r@main, lines 1-20)\nfunc Refresh() {}") ||
+		!strings.Contains(gen.prompt, "[2] jwt.go (r@main, lines 5-9)\nSigns tokens.") ||
+		!strings.HasSuffix(gen.prompt, "Question: how are tokens refreshed?") {
+		t.Errorf("unexpected prompt:\n%s", gen.prompt)
+	}
+	wantSentences := []models.AnswerSentence{
+		{Text: "Tokens are refreshed by Refresh.", Citations: []int{1}},
+		{Text: "They are signed in jwt.go.", Citations: []int{2}},
+		{Text: "See also v1.2 notes.", Citations: []int{}},
+	}
+	if !reflect.DeepEqual(got.Sentences, wantSentences) {
+		t.Errorf("sentences = %+v, want %+v", got.Sentences, wantSentences)
+	}
+	if len(got.Sources) != 2 || got.Sources[1].N != 2 || got.Sources[1].Path != "jwt.go" || got.Sources[1].LineStart != 5 {
+		t.Errorf("unexpected sources %+v", got.Sources)
+	}
+	if got.Question != "how are tokens refreshed?" || got.Answer != gen.answer {
+		t.Errorf("unexpected answer %+v", got)
+	}
+
+	if _, err := NewService(&MockAIClient{}, st).Ask(context.Background(), "q", 5, store.QueryOpts{}); !errors.Is(err, ErrAskUnsupported) {
+		t.Errorf("expected ErrAskUnsupported, got %v", err)
+	}
+
+	empty := NewService(gen, &MockSearchableStore{})
+	gen.prompt = ""
+	if got, err := empty.Ask(context.Background(), "q", 5, store.QueryOpts{}); err != nil || len(got.Sources) != 0 || gen.prompt != "" {
+		t.Errorf("with no results the model should not be called: %+v, %v", got, err)
+	}
+}
+
+func TestSplitSentences(t *testing.T) {
+	for in, want := range map[string][]string{
+		"One [1]. Two! Three?":            {"One [1].", "Two!", "Three?"},
+		"Ends with cites. [1][2] Next.":   {"Ends with cites. [1][2]", "Next."},
+		"Reads config.yaml at 1.5x speed": {"Reads config.yaml at 1.5x speed"},
+		"Line one\n\n- item [3]":          {"Line one", "- item [3]"},
+		"":                                nil,
+	} {
+		if got := splitSentences(in); !reflect.DeepEqual(got, want) {
+			t.Errorf("splitSentences(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/seanblong/reposearch/internal/ai"
+	"github.com/seanblong/reposearch/internal/store"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// Limits on the conversation given to the model by Chat.
+const (
+	chatHistoryTurns = 10   // most recent turns included in prompts
+	chatTurnBytes    = 2000 // content of a single turn
+	chatQueryTokens  = 100
+)
+
+const chatSystem = askSystem + " Use the conversation so far to interpret the question, but cite only the numbered sources."
+
+const rewriteSystem = "You turn the last message of a conversation about a codebase into a standalone search query. " +
+	"Resolve references such as \"it\" or \"that function\" using the conversation. Reply with the query only."
+
+// ChatStream receives the progress of Chat. Either field may be nil.
+type ChatStream struct {
+	// Sources is called with the standalone query and the sources that will
+	// be cited, before the answer is generated.
+	Sources func(query string, sources []models.Citation)
+
+	// Delta is called with each piece of the answer as it is generated.
+	// Returning an error stops generation.
+	Delta func(text string) error
+}
+
+// Chat answers message as the next turn of a conversation. A follow-up
+// question is first rewritten into a standalone query, using the earlier
+// turns in history, and the top k chunks for that query are given to the
+// model along with the conversation. It returns the answer and the query
+// searched for.
+func (s *Service) Chat(ctx context.Context, history []models.ChatTurn, message string, k int, opt store.QueryOpts, stream ChatStream) (models.Answer, string, error) {
+	gen, ok := s.Client.(ai.Generator)
+	if !ok {
+		return models.Answer{}, "", ErrAskUnsupported
+	}
+	message = strings.TrimSpace(message)
+	history = recentTurns(history)
+
+	query := message
+	if len(history) > 0 {
+		rewritten, err := gen.Generate(ctx, rewriteSystem, conversation(history)+"\nLast message: "+message, chatQueryTokens)
+		if err != nil {
+			return models.Answer{}, "", fmt.Errorf("rewriting question: %w", err)
+		}
+		if rewritten = strings.Trim(strings.TrimSpace(rewritten), "\"'`"); rewritten != "" {
+			query = rewritten
+		}
+	}
+
+	answer := models.Answer{Question: message, Sentences: []models.AnswerSentence{}}
+	sources, err := s.askSources(ctx, query, k, opt, &answer)
+	if err != nil {
+		return models.Answer{}, "", err
+	}
+	if stream.Sources != nil {
+		stream.Sources(query, answer.Sources)
+	}
+	delta := stream.Delta
+	if delta == nil {
+		delta = func(string) error { return nil }
+	}
+	if len(answer.Sources) == 0 {
+		setAnswer(&answer, noAnswer)
+		return answer, query, delta(noAnswer)
+	}
+
+	var prompt strings.Builder
+	if len(history) > 0 {
+		prompt.WriteString("Conversation so far:\n")
+		prompt.WriteString(conversation(history))
+		prompt.WriteString("\n")
+	}
+	prompt.WriteString(sources)
+	prompt.WriteString("Question: " + message)
+
+	var text string
+	if sg, ok := s.Client.(ai.StreamGenerator); ok {
+		text, err = sg.GenerateStream(ctx, chatSystem, prompt.String(), askMaxTokens, delta)
+	} else if text, err = gen.Generate(ctx, chatSystem, prompt.String(), askMaxTokens); err == nil {
+		err = delta(text)
+	}
+	if err != nil {
+		return models.Answer{}, "", err
+	}
+	setAnswer(&answer, text)
+	return answer, query, nil
+}
+
+// spacedCitationRe matches a citation along with the spaces before it.
+var spacedCitationRe = regexp.MustCompile(`[ \t]*` + citationRe.String())
+
+// recentTurns returns the last turns of history that are given to the model.
+func recentTurns(history []models.ChatTurn) []models.ChatTurn {
+	if len(history) > chatHistoryTurns {
+		history = history[len(history)-chatHistoryTurns:]
+	}
+	return history
+}
+
+// conversation formats turns for a prompt. Citations are removed from
+// earlier answers because their numbers refer to sources of other turns.
+func conversation(turns []models.ChatTurn) string {
+	var b strings.Builder
+	for _, t := range turns {
+		role := "User"
+		if t.Role == "assistant" {
+			role = "Assistant"
+		}
+		content := strings.TrimSpace(spacedCitationRe.ReplaceAllString(t.Content, ""))
+		if len(content) > chatTurnBytes {
+			content = content[:chatTurnBytes] + "…"
+		}
+		fmt.Fprintf(&b, "%s: %s\n", role, content)
+	}
+	return b.String()
+}
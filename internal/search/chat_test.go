@@ -0,0 +1,101 @@
+package search
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/seanblong/reposearch/internal/store"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// mockStreamer is a mockGenerator that streams its answer in two pieces and
+// rewrites questions to a fixed query.
+type mockStreamer struct {
+	mockGenerator
+	query string
+}
+
+func (m *mockStreamer) Generate(ctx context.Context, system, prompt string, maxTokens int) (string, error) {
+	if system == rewriteSystem {
+		return "\"" + m.query + "\"", nil
+	}
+	return m.mockGenerator.Generate(ctx, system, prompt, maxTokens)
+}
+
+func (m *mockStreamer) GenerateStream(ctx context.Context, system, prompt string, maxTokens int, fn func(string) error) (string, error) {
+	m.prompt = prompt
+	half := len(m.answer) / 2
+	for _, d := range []string{m.answer[:half], m.answer[half:]} {
+		if err := fn(d); err != nil {
+			return "", err
+		}
+	}
+	return m.answer, nil
+}
+
+func TestService_Chat(t *testing.T) {
+	var searched []string
+	st := &MockSearchableStore{SearchFunc: func(ctx context.Context, head []float32, k int, opt store.QueryOpts) ([]models.SearchResult, error) {
+		searched = append(searched, opt.QueryText)
+		return []models.SearchResult{
+			{Chunk: models.Chunk{ID: "a", Repository: "r", Ref: "main", Path: "auth.go", LineStart: 1, LineEnd: 20, Content: "func Refresh() {}"}, Score: 0.9},
+		}, nil
+	}}
+	gen := &mockStreamer{mockGenerator: mockGenerator{answer: "It runs hourly [1]."}, query: "how often does Refresh run"}
+	svc := NewService(gen, st)
+
+	// The first turn is searched as is.
+	var deltas []string
+	var sources []models.Citation
+	stream := ChatStream{
+		Sources: func(query string, s []models.Citation) { sources = s },
+		Delta:   func(text string) error { deltas = append(deltas, text); return nil },
+	}
+	got, query, err := svc.Chat(context.Background(), nil, " what refreshes tokens? ", 5, store.QueryOpts{}, stream)
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if query != "what refreshes tokens?" || !reflect.DeepEqual(searched, []string{"what refreshes tokens?"}) {
+		t.Errorf("query = %q, searched %q", query, searched)
+	}
+	if strings.Contains(gen.prompt, "Conversation so far") {
+		t.Errorf("first turn should have no conversation:\n%s", gen.prompt)
+	}
+	if strings.Join(deltas, "") != gen.answer || len(deltas) != 2 {
+		t.Errorf("deltas = %q", deltas)
+	}
+	if len(sources) != 1 || sources[0].Path != "auth.go" {
+		t.Errorf("sources = %+v", sources)
+	}
+	if want := []models.AnswerSentence{{Text: "It runs hourly.", Citations: []int{1}}}; !reflect.DeepEqual(got.Sentences, want) {
+		t.Errorf("sentences = %+v", got.Sentences)
+	}
+
+	// A follow-up is rewritten using the conversation.
+	history := []models.ChatTurn{
+		{Role: "user", Content: "what refreshes tokens?"},
+		{Role: "assistant", Content: "Refresh in auth.go [1]."},
+	}
+	searched = nil
+	if _, query, err = svc.Chat(context.Background(), history, "how often does it run?", 5, store.QueryOpts{}, ChatStream{}); err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if query != gen.query || !reflect.DeepEqual(searched, []string{gen.query}) {
+		t.Errorf("query = %q, searched %q", query, searched)
+	}
+	if !strings.Contains(gen.prompt, "Conversation so far:\nUser: what refreshes tokens?\nAssistant: Refresh in auth.go.\n") ||
+		!strings.HasSuffix(gen.prompt, "Question: how often does it run?") {
+		t.Errorf("unexpected prompt:\n%s", gen.prompt)
+	}
+
+	// Without a streaming client the whole answer is a single delta.
+	deltas = nil
+	if _, _, err := NewService(&gen.mockGenerator, st).Chat(context.Background(), nil, "q", 5, store.QueryOpts{}, stream); err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if !reflect.DeepEqual(deltas, []string{gen.answer}) {
+		t.Errorf("deltas = %q", deltas)
+	}
+}
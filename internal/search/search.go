@@ -2,17 +2,25 @@ package search
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"log"
+	"strconv"
 	"strings"
 
 	"github.com/seanblong/reposearch/internal/ai"
 	"github.com/seanblong/reposearch/internal/store"
 	"github.com/seanblong/reposearch/pkg/models"
+	"golang.org/x/sync/errgroup"
 )
 
 type Service struct {
 	Client ai.Client
 	Store  store.ChunkStore
+
+	// Content, when set, fills in the content of chunks indexed without it
+	// before they are given to the model by Ask.
+	Content func(ctx context.Context, chunks []*models.Chunk)
 }
 
 // NewService creates a new search service with the provided AI client and store
@@ -23,15 +31,37 @@ func NewService(client ai.Client, store store.ChunkStore) *Service {
 	}
 }
 
-func (s *Service) Query(ctx context.Context, q string, k int, opt store.QueryOpts) ([]models.SearchResult, error) {
+// ParseQuery trims q and moves a "symbol:Name" (or "symbol:Prefix*") term
+// into opt.Symbol, setting opt.QueryText to the rest of the query. A query
+// that is only a symbol searches for the symbol's name.
+func ParseQuery(q string, opt store.QueryOpts) (string, store.QueryOpts) {
 	q = strings.TrimSpace(q)
+	if strings.Contains(q, "symbol:") {
+		var rest []string
+		for _, f := range strings.Fields(q) {
+			if name, ok := strings.CutPrefix(f, "symbol:"); ok && name != "" {
+				opt.Symbol = name
+				continue
+			}
+			rest = append(rest, f)
+		}
+		q = strings.Join(rest, " ")
+	}
+	if q == "" && opt.Symbol != "" {
+		q = strings.TrimSuffix(opt.Symbol, "*")
+	}
 	opt.QueryText = q
+	return q, opt
+}
+
+func (s *Service) Query(ctx context.Context, q string, k int, opt store.QueryOpts) ([]models.SearchResult, error) {
+	q, opt = ParseQuery(q, opt)
 
 	head, err := s.Client.Embed(q)
 	if err != nil {
 		log.Printf("AI CLIENT ERROR: Embedding failed for query '%s': %v", q, err)
 		log.Printf("This likely indicates AI authentication issues (e.g., missing 'gcloud auth login' for Vertex AI, invalid API key, etc.)")
-		log.Printf("Proceeding with empty embedding vector - search results may be poor or empty")
+		log.Printf("Falling back to lexical-only search")
 		head = nil
 	}
 
@@ -41,3 +71,128 @@ func (s *Service) Query(ctx context.Context, q string, k int, opt store.QueryOpt
 	}
 	return res, nil
 }
+
+// QueryBatch runs several queries with the same k and filters, embedding
+// them all in one provider call when the client supports it. Results are
+// returned in the order of queries.
+func (s *Service) QueryBatch(ctx context.Context, queries []string, k int, opt store.QueryOpts) ([][]models.SearchResult, error) {
+	texts := make([]string, len(queries))
+	opts := make([]store.QueryOpts, len(queries))
+	for i, q := range queries {
+		texts[i], opts[i] = ParseQuery(q, opt)
+	}
+	heads, err := ai.EmbedAll(s.Client, texts)
+	if err != nil {
+		log.Printf("AI CLIENT ERROR: Embedding failed for a batch of %d queries: %v; falling back to lexical-only search", len(texts), err)
+		heads = make([][]float32, len(texts))
+	}
+
+	out := make([][]models.SearchResult, len(queries))
+	g, ctx := errgroup.WithContext(ctx)
+	for i := range queries {
+		g.Go(func() error {
+			res, err := s.Store.Search(ctx, heads[i], k, opts[i])
+			if err != nil {
+				return err
+			}
+			if res == nil {
+				res = []models.SearchResult{}
+			}
+			out[i] = res
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// QueryPage returns one page of results. The page starts at opt.Offset, or
+// at cursor when it is non-empty, and carries a cursor for the next page
+// when more results remain. Stores that cannot page fall back to Search and
+// report the size of the page as the total.
+func (s *Service) QueryPage(ctx context.Context, q string, k int, cursor string, opt store.QueryOpts) (models.SearchPage, error) {
+	if cursor != "" {
+		off, err := DecodeCursor(cursor)
+		if err != nil {
+			return models.SearchPage{}, err
+		}
+		opt.Offset = off
+	}
+
+	ps, ok := s.Store.(store.PagedSearcher)
+	if !ok {
+		if opt.Offset > 0 {
+			return models.SearchPage{}, ErrPagingUnsupported
+		}
+		res, err := s.Query(ctx, q, k, opt)
+		if err != nil {
+			return models.SearchPage{}, err
+		}
+		return models.SearchPage{Results: res, Total: len(res)}, nil
+	}
+
+	q, opt = ParseQuery(q, opt)
+	head, err := s.Client.Embed(q)
+	if err != nil {
+		log.Printf("AI CLIENT ERROR: Embedding failed for query '%s': %v; falling back to lexical-only search", q, err)
+		head = nil
+	}
+	page, err := ps.SearchPage(ctx, head, k, opt)
+	if err != nil {
+		return models.SearchPage{}, err
+	}
+	if page.Results == nil {
+		page.Results = []models.SearchResult{}
+	}
+	if next := opt.Offset + len(page.Results); len(page.Results) > 0 && next < page.Total {
+		page.NextCursor = EncodeCursor(next)
+	}
+	return page, nil
+}
+
+// ErrPagingUnsupported is returned by QueryPage when an offset is requested
+// from a store that cannot skip results.
+var ErrPagingUnsupported = errors.New("store does not support paging")
+
+// ErrInvalidCursor is returned for a cursor that was not produced by
+// EncodeCursor.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// EncodeCursor returns an opaque cursor for the result at offset.
+func EncodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("o:" + strconv.Itoa(offset)))
+}
+
+// DecodeCursor returns the offset encoded by EncodeCursor.
+func DecodeCursor(cursor string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(string(b), "o:"))
+	if err != nil || n < 0 || !strings.HasPrefix(string(b), "o:") {
+		return 0, ErrInvalidCursor
+	}
+	return n, nil
+}
+
+// ErrRollupsUnsupported is returned by QueryRollups when the store does not
+// keep file or directory summaries.
+var ErrRollupsUnsupported = errors.New("store does not support rollup search")
+
+// QueryRollups searches file ("file") or directory ("dir") level summaries.
+func (s *Service) QueryRollups(ctx context.Context, q string, k int, kind string, opt store.QueryOpts) ([]models.RollupResult, error) {
+	rs, ok := s.Store.(store.RollupStore)
+	if !ok {
+		return nil, ErrRollupsUnsupported
+	}
+	q = strings.TrimSpace(q)
+	head, err := s.Client.Embed(q)
+	if err != nil {
+		log.Printf("AI CLIENT ERROR: Embedding failed for query '%s': %v", q, err)
+		return []models.RollupResult{}, nil
+	}
+	return rs.SearchRollups(ctx, head, k, kind, opt)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -66,10 +67,18 @@ func (m *MockSearchableStore) UpsertChunk(ctx context.Context, c models.Chunk, s
 	return nil
 }
 
+func (m *MockSearchableStore) UpsertChunks(ctx context.Context, chunks []store.ChunkWithVec) error {
+	return nil
+}
+
 func (m *MockSearchableStore) Migrate(ctx context.Context, summaryDim int) error {
 	return nil
 }
 
+func (m *MockSearchableStore) FindByContentHash(ctx context.Context, contentHash, summaryModel, embedModel string) (store.HashMatch, bool, error) {
+	return store.HashMatch{}, false, nil
+}
+
 // TestService_Query tests the real Service.Query method with mocked dependencies
 func TestService_Query(t *testing.T) {
 	// Create test data
@@ -107,7 +116,7 @@ func TestService_Query(t *testing.T) {
 			name:  "successful query with results",
 			query: "hello world function",
 			k:     10,
-			opt:   store.QueryOpts{Repository: "test-repo"},
+			opt:   store.QueryOpts{Repositories: []string{"test-repo"}},
 			mockEmbedFunc: func(text string) ([]float32, error) {
 				if text != "hello world function" {
 					t.Errorf("Expected embedding text 'hello world function', got '%s'", text)
@@ -122,8 +131,8 @@ func TestService_Query(t *testing.T) {
 				if k != 10 {
 					t.Errorf("Expected k=10, got k=%d", k)
 				}
-				if opt.Repository != "test-repo" {
-					t.Errorf("Expected repository 'test-repo', got '%s'", opt.Repository)
+				if len(opt.Repositories) != 1 || opt.Repositories[0] != "test-repo" {
+					t.Errorf("Expected repositories [test-repo], got %v", opt.Repositories)
 				}
 				if opt.QueryText != "hello world function" {
 					t.Errorf("Expected QueryText 'hello world function', got '%s'", opt.QueryText)
@@ -190,19 +199,19 @@ func TestService_Query(t *testing.T) {
 			query: "python script",
 			k:     20,
 			opt: store.QueryOpts{
-				Repository:   "my-repo",
-				Language:     "python",
+				Repositories: []string{"my-repo", "other-repo"},
+				Languages:    []string{"python"},
 				PathContains: "scripts",
 			},
 			mockEmbedFunc: func(text string) ([]float32, error) {
 				return []float32{0.5, 0.6, 0.7}, nil
 			},
 			mockSearchFunc: func(ctx context.Context, head []float32, k int, opt store.QueryOpts) ([]models.SearchResult, error) {
-				if opt.Repository != "my-repo" {
-					t.Errorf("Expected repository 'my-repo', got '%s'", opt.Repository)
+				if len(opt.Repositories) != 2 || opt.Repositories[0] != "my-repo" || opt.Repositories[1] != "other-repo" {
+					t.Errorf("Expected repositories [my-repo other-repo], got %v", opt.Repositories)
 				}
-				if opt.Language != "python" {
-					t.Errorf("Expected language 'python', got '%s'", opt.Language)
+				if len(opt.Languages) != 1 || opt.Languages[0] != "python" {
+					t.Errorf("Expected languages [python], got %v", opt.Languages)
 				}
 				if opt.PathContains != "scripts" {
 					t.Errorf("Expected PathContains 'scripts', got '%s'", opt.PathContains)
@@ -467,6 +476,210 @@ func TestNewService(t *testing.T) {
 	}
 }
 
+// mockRollupStore adds rollup search to MockSearchableStore.
+type mockRollupStore struct {
+	MockSearchableStore
+	SearchRollupsFunc func(ctx context.Context, head []float32, k int, kind string, opt store.QueryOpts) ([]models.RollupResult, error)
+}
+
+func (m *mockRollupStore) GetRollupMeta(ctx context.Context, repository, ref, kind, path string) (store.RollupMeta, bool, error) {
+	return store.RollupMeta{}, false, nil
+}
+
+func (m *mockRollupStore) UpsertRollup(ctx context.Context, r models.Rollup, summaryVec []float32, inputHash string) error {
+	return nil
+}
+
+func (m *mockRollupStore) SearchRollups(ctx context.Context, head []float32, k int, kind string, opt store.QueryOpts) ([]models.RollupResult, error) {
+	return m.SearchRollupsFunc(ctx, head, k, kind, opt)
+}
+
+func TestService_QueryRollups(t *testing.T) {
+	t.Run("unsupported store", func(t *testing.T) {
+		svc := NewService(&MockAIClient{}, &MockSearchableStore{})
+		if _, err := svc.QueryRollups(context.Background(), "payments", 5, store.RollupDir, store.QueryOpts{}); !errors.Is(err, ErrRollupsUnsupported) {
+			t.Errorf("Expected ErrRollupsUnsupported, got %v", err)
+		}
+	})
+
+	t.Run("searches rollups", func(t *testing.T) {
+		st := &mockRollupStore{
+			SearchRollupsFunc: func(ctx context.Context, head []float32, k int, kind string, opt store.QueryOpts) ([]models.RollupResult, error) {
+				if kind != store.RollupDir || k != 3 || len(opt.Repositories) != 1 || len(head) != 3 {
+					t.Errorf("Unexpected arguments kind=%s k=%d opt=%+v head=%v", kind, k, opt, head)
+				}
+				return []models.RollupResult{{Rollup: models.Rollup{Kind: kind, Path: "services/payments"}, Score: 0.9}}, nil
+			},
+		}
+		svc := NewService(&MockAIClient{}, st)
+		res, err := svc.QueryRollups(context.Background(), "  what does payments do ", 3, store.RollupDir, store.QueryOpts{Repositories: []string{"repo"}})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(res) != 1 || res[0].Rollup.Path != "services/payments" {
+			t.Errorf("Unexpected results %+v", res)
+		}
+	})
+}
+
+// mockPagedStore adds paging to MockSearchableStore.
+type mockPagedStore struct {
+	MockSearchableStore
+	SearchPageFunc func(ctx context.Context, head []float32, k int, opt store.QueryOpts) (models.SearchPage, error)
+}
+
+func (m *mockPagedStore) SearchPage(ctx context.Context, head []float32, k int, opt store.QueryOpts) (models.SearchPage, error) {
+	return m.SearchPageFunc(ctx, head, k, opt)
+}
+
+func TestService_QueryPage(t *testing.T) {
+	st := &mockPagedStore{
+		SearchPageFunc: func(ctx context.Context, head []float32, k int, opt store.QueryOpts) (models.SearchPage, error) {
+			var res []models.SearchResult
+			for i := opt.Offset; i < opt.Offset+k && i < 5; i++ {
+				res = append(res, models.SearchResult{Chunk: models.Chunk{ID: strconv.Itoa(i)}})
+			}
+			return models.SearchPage{Results: res, Total: 5}, nil
+		},
+	}
+	svc := NewService(&MockAIClient{}, st)
+	ctx := context.Background()
+
+	var ids []string
+	cursor := ""
+	for pages := 0; pages < 5; pages++ {
+		page, err := svc.QueryPage(ctx, "q", 2, cursor, store.QueryOpts{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if page.Total != 5 {
+			t.Errorf("Expected total 5, got %d", page.Total)
+		}
+		for _, r := range page.Results {
+			ids = append(ids, r.Chunk.ID)
+		}
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if strings.Join(ids, ",") != "0,1,2,3,4" {
+		t.Errorf("Unexpected pages %v", ids)
+	}
+
+	page, err := svc.QueryPage(ctx, "q", 2, "", store.QueryOpts{Offset: 4})
+	if err != nil || len(page.Results) != 1 || page.NextCursor != "" {
+		t.Errorf("Unexpected last page %+v, %v", page, err)
+	}
+
+	if _, err := svc.QueryPage(ctx, "q", 2, "bogus!", store.QueryOpts{}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestService_QueryPage_Unpaged(t *testing.T) {
+	st := &MockSearchableStore{
+		SearchFunc: func(ctx context.Context, head []float32, k int, opt store.QueryOpts) ([]models.SearchResult, error) {
+			return []models.SearchResult{{}, {}}, nil
+		},
+	}
+	svc := NewService(&MockAIClient{}, st)
+	page, err := svc.QueryPage(context.Background(), "q", 2, "", store.QueryOpts{})
+	if err != nil || page.Total != 2 || page.NextCursor != "" {
+		t.Errorf("Unexpected page %+v, %v", page, err)
+	}
+	if _, err := svc.QueryPage(context.Background(), "q", 2, EncodeCursor(2), store.QueryOpts{}); !errors.Is(err, ErrPagingUnsupported) {
+		t.Errorf("Expected ErrPagingUnsupported, got %v", err)
+	}
+}
+
+// batchClient is a MockAIClient that also embeds in batches.
+type batchClient struct {
+	MockAIClient
+	batches [][]string
+}
+
+func (b *batchClient) EmbedBatch(texts []string) ([][]float32, error) {
+	b.batches = append(b.batches, texts)
+	out := make([][]float32, len(texts))
+	for i := range texts {
+		out[i] = []float32{float32(i)}
+	}
+	return out, nil
+}
+
+func TestService_QueryBatch(t *testing.T) {
+	st := &MockSearchableStore{
+		SearchFunc: func(ctx context.Context, head []float32, k int, opt store.QueryOpts) ([]models.SearchResult, error) {
+			if opt.QueryText == "none" {
+				return nil, nil
+			}
+			return []models.SearchResult{{Chunk: models.Chunk{ID: opt.QueryText + "/" + opt.Symbol + "/" + strconv.Itoa(int(head[0]))}}}, nil
+		},
+	}
+	client := &batchClient{}
+	svc := NewService(client, st)
+	got, err := svc.QueryBatch(context.Background(), []string{" token refresh ", "symbol:Login", "none"}, 3, store.QueryOpts{})
+	if err != nil {
+		t.Fatalf("QueryBatch: %v", err)
+	}
+	if want := [][]string{{"token refresh", "Login", "none"}}; !reflect.DeepEqual(client.batches, want) {
+		t.Errorf("batches = %q, want %q", client.batches, want)
+	}
+	if len(got) != 3 || got[0][0].Chunk.ID != "token refresh//0" || got[1][0].Chunk.ID != "Login/Login/1" || got[2] == nil || len(got[2]) != 0 {
+		t.Errorf("unexpected results %+v", got)
+	}
+
+	// Without batch support each query is embedded on its own.
+	var embedded []string
+	svc = NewService(&MockAIClient{EmbedFunc: func(text string) ([]float32, error) {
+		embedded = append(embedded, text)
+		return []float32{9}, nil
+	}}, st)
+	if _, err := svc.QueryBatch(context.Background(), []string{"a", "b"}, 3, store.QueryOpts{}); err != nil {
+		t.Fatalf("QueryBatch: %v", err)
+	}
+	if !reflect.DeepEqual(embedded, []string{"a", "b"}) {
+		t.Errorf("embedded = %q", embedded)
+	}
+
+	st.SearchFunc = func(ctx context.Context, head []float32, k int, opt store.QueryOpts) ([]models.SearchResult, error) {
+		return nil, errors.New("database down")
+	}
+	if _, err := svc.QueryBatch(context.Background(), []string{"a", "b"}, 3, store.QueryOpts{}); err == nil {
+		t.Error("expected the store error")
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	n, err := DecodeCursor(EncodeCursor(42))
+	if err != nil || n != 42 {
+		t.Errorf("Expected 42, got %d, %v", n, err)
+	}
+	for _, c := range []string{"", "Zm9v", EncodeCursor(-1)} {
+		if _, err := DecodeCursor(c); err == nil {
+			t.Errorf("Expected error for cursor %q", c)
+		}
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		in, wantQ, wantSymbol string
+	}{
+		{"  retry logic ", "retry logic", ""},
+		{"symbol:NewServer", "NewServer", "NewServer"},
+		{"symbol:New* how servers start", "how servers start", "New*"},
+		{"where symbol:parse is", "where is", "parse"},
+		{"symbol:", "symbol:", ""},
+	}
+	for _, tt := range tests {
+		q, opt := ParseQuery(tt.in, store.QueryOpts{Ref: "main"})
+		if q != tt.wantQ || opt.QueryText != tt.wantQ || opt.Symbol != tt.wantSymbol || opt.Ref != "main" {
+			t.Errorf("ParseQuery(%q) = %q, %+v", tt.in, q, opt)
+		}
+	}
+}
+
 // Benchmark tests - these test the real Service.Query method performance
 func BenchmarkService_Query(b *testing.B) {
 	mockClient := &MockAIClient{
@@ -485,7 +698,7 @@ func BenchmarkService_Query(b *testing.B) {
 
 	ctx := context.Background()
 	query := "test query for benchmarking"
-	opt := store.QueryOpts{Repository: "test-repo"}
+	opt := store.QueryOpts{Repositories: []string{"test-repo"}}
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
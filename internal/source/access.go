@@ -0,0 +1,246 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultAccessTTL is how long GitHubAccess trusts an answer.
+const DefaultAccessTTL = 10 * time.Minute
+
+// accessChecks caps the GitHub requests GitHubAccess.Visible makes at once.
+const accessChecks = 8
+
+// maxAccessEntries caps the answers GitHubAccess caches. Past it, the cache
+// is emptied rather than grown.
+const maxAccessEntries = 100_000
+
+// GitHubAccess checks which repositories GitHub users can see, through the
+// GitHub API with a token that can read every indexed repository. Answers
+// are cached for TTL; failed checks are not.
+type GitHubAccess struct {
+	APIURL string // default https://api.github.com
+	Token  string
+	HTTP   *http.Client
+	TTL    time.Duration
+
+	mu     sync.Mutex
+	cache  map[[2]string]accessEntry // by login ("" for public) and repository
+	lists  map[string]listEntry      // by login, see Repositories
+	pruned time.Time
+}
+
+type accessEntry struct {
+	ok      bool
+	checked time.Time
+}
+
+type listEntry struct {
+	repos   []string
+	checked time.Time
+}
+
+// NewGitHubAccess creates a GitHubAccess. A ttl of zero uses
+// DefaultAccessTTL.
+func NewGitHubAccess(token string, ttl time.Duration) *GitHubAccess {
+	if ttl <= 0 {
+		ttl = DefaultAccessTTL
+	}
+	return &GitHubAccess{
+		APIURL: "https://api.github.com",
+		Token:  token,
+		HTTP:   &http.Client{Timeout: 10 * time.Second},
+		TTL:    ttl,
+		cache:  map[[2]string]accessEntry{},
+		lists:  map[string]listEntry{},
+	}
+}
+
+// CanRead reports whether the GitHub user login can see repository, the
+// URL it was indexed from. Public repositories are open to everyone,
+// including an empty login; a private one needs at least read permission.
+// Repositories not hosted on GitHub are not checked and always readable.
+func (a *GitHubAccess) CanRead(ctx context.Context, login, repository string) (bool, error) {
+	owner, name, ok := parseGitHubRepo(repository)
+	if !ok {
+		return true, nil
+	}
+	public, err := a.cached(ctx, "", repository, func() (bool, error) {
+		return a.public(ctx, owner, name)
+	})
+	if err != nil || public || login == "" {
+		return public, err
+	}
+	return a.cached(ctx, login, repository, func() (bool, error) {
+		return a.permitted(ctx, owner, name, login)
+	})
+}
+
+// Visible returns the repositories of repos that login can see, in order.
+// Repositories whose check fails are left out, and the failures returned
+// alongside them.
+func (a *GitHubAccess) Visible(ctx context.Context, login string, repos []string) ([]string, error) {
+	ok := make([]bool, len(repos))
+	errs := make([]error, len(repos))
+	var g errgroup.Group
+	g.SetLimit(accessChecks)
+	for i, repo := range repos {
+		g.Go(func() error {
+			ok[i], errs[i] = a.CanRead(ctx, login, repo)
+			return nil
+		})
+	}
+	_ = g.Wait()
+	visible := make([]string, 0, len(repos))
+	for i, repo := range repos {
+		if ok[i] && errs[i] == nil {
+			visible = append(visible, repo)
+		}
+	}
+	return visible, errors.Join(errs...)
+}
+
+// Repositories returns the repositories login can see among those list
+// returns, like Visible, but caches the result for TTL so that list and the
+// checks only run once per TTL and login. Repositories indexed meanwhile
+// show up once it expires. Results with failed checks are not cached.
+func (a *GitHubAccess) Repositories(ctx context.Context, login string, list func(context.Context) ([]string, error)) ([]string, error) {
+	key := strings.ToLower(login)
+	a.mu.Lock()
+	e, found := a.lists[key]
+	a.mu.Unlock()
+	if found && time.Since(e.checked) < a.TTL {
+		return e.repos, nil
+	}
+	repos, err := list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	visible, err := a.Visible(ctx, login, repos)
+	if err != nil {
+		return visible, err
+	}
+	a.mu.Lock()
+	a.prune()
+	if a.lists == nil {
+		a.lists = map[string]listEntry{}
+	}
+	a.lists[key] = listEntry{repos: visible, checked: time.Now()}
+	a.mu.Unlock()
+	return visible, nil
+}
+
+// cached returns the cached answer for login and repository, or calls check
+// and caches its answer.
+func (a *GitHubAccess) cached(ctx context.Context, login, repository string, check func() (bool, error)) (bool, error) {
+	key := [2]string{strings.ToLower(login), repository}
+	a.mu.Lock()
+	e, found := a.cache[key]
+	a.mu.Unlock()
+	if found && time.Since(e.checked) < a.TTL {
+		return e.ok, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	ok, err := check()
+	if err != nil {
+		return false, err
+	}
+	a.mu.Lock()
+	a.prune()
+	if a.cache == nil {
+		a.cache = map[[2]string]accessEntry{}
+	}
+	a.cache[key] = accessEntry{ok: ok, checked: time.Now()}
+	a.mu.Unlock()
+	return ok, nil
+}
+
+// prune drops the expired answers, at most once per TTL, and empties the
+// caches once they hold maxAccessEntries answers anyway. a.mu must be held.
+func (a *GitHubAccess) prune() {
+	if len(a.cache)+len(a.lists) >= maxAccessEntries {
+		clear(a.cache)
+		clear(a.lists)
+	}
+	if time.Since(a.pruned) < a.TTL {
+		return
+	}
+	a.pruned = time.Now()
+	for key, e := range a.cache {
+		if time.Since(e.checked) >= a.TTL {
+			delete(a.cache, key)
+		}
+	}
+	for key, e := range a.lists {
+		if time.Since(e.checked) >= a.TTL {
+			delete(a.lists, key)
+		}
+	}
+}
+
+// public reports whether a repository is public.
+func (a *GitHubAccess) public(ctx context.Context, owner, name string) (bool, error) {
+	var repo struct {
+		Private bool `json:"private"`
+	}
+	status, err := a.get(ctx, fmt.Sprintf("/repos/%s/%s", url.PathEscape(owner), url.PathEscape(name)), &repo)
+	if err != nil {
+		return false, err
+	}
+	if status != http.StatusOK {
+		return false, fmt.Errorf("github returned status %d for %s/%s", status, owner, name)
+	}
+	return !repo.Private, nil
+}
+
+// permitted reports whether login has at least read permission on a
+// repository.
+func (a *GitHubAccess) permitted(ctx context.Context, owner, name, login string) (bool, error) {
+	var perm struct {
+		Permission string `json:"permission"`
+	}
+	status, err := a.get(ctx, fmt.Sprintf("/repos/%s/%s/collaborators/%s/permission",
+		url.PathEscape(owner), url.PathEscape(name), url.PathEscape(login)), &perm)
+	switch {
+	case err != nil:
+		return false, err
+	case status == http.StatusNotFound: // no such user
+		return false, nil
+	case status != http.StatusOK:
+		return false, fmt.Errorf("github returned status %d for the permission of %s on %s/%s", status, login, owner, name)
+	}
+	return perm.Permission != "" && perm.Permission != "none", nil
+}
+
+// get decodes the JSON response of a successful GitHub API request into
+// out, and returns its status.
+func (a *GitHubAccess) get(ctx context.Context, path string, out any) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(a.APIURL, "/")+path, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if a.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	}
+	resp, err := a.HTTP.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+}
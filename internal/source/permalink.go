@@ -0,0 +1,78 @@
+package source
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Permalink returns a link to the line range of path at ref on the web UI of
+// the repository's host, e.g.
+// https://github.com/org/repo/blob/main/a.go#L10-L42. Repositories are
+// recognised from their clone URL in HTTPS or SSH form on GitHub and GitLab,
+// including self-hosted instances with "github" or "gitlab" in their host
+// name. It returns "" for other repositories, such as local directories.
+func Permalink(repository, ref, path string, lineStart, lineEnd int) string {
+	base, host, ok := webURL(repository)
+	if !ok {
+		return ""
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+	segs := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, s := range segs {
+		segs[i] = url.PathEscape(s)
+	}
+	file := strings.Join(segs, "/")
+
+	switch {
+	case strings.Contains(host, "gitlab"):
+		return fmt.Sprintf("%s/-/blob/%s/%s%s", base, ref, file, lineAnchor(lineStart, lineEnd, ""))
+	case strings.Contains(host, "github"):
+		return fmt.Sprintf("%s/blob/%s/%s%s", base, ref, file, lineAnchor(lineStart, lineEnd, "L"))
+	}
+	return ""
+}
+
+// lineAnchor returns the fragment selecting a line range. GitHub repeats the
+// L before the end line, GitLab doesn't.
+func lineAnchor(start, end int, endPrefix string) string {
+	switch {
+	case start < 1:
+		return ""
+	case end <= start:
+		return fmt.Sprintf("#L%d", start)
+	}
+	return fmt.Sprintf("#L%d-%s%d", start, endPrefix, end)
+}
+
+// webURL turns a clone URL in HTTPS or SSH form into the https:// URL of the
+// repository's web page, and returns its lower-cased host.
+func webURL(repository string) (base, host string, ok bool) {
+	rest := ""
+	switch {
+	case strings.HasPrefix(repository, "https://"), strings.HasPrefix(repository, "http://"), strings.HasPrefix(repository, "ssh://"):
+		u, err := url.Parse(repository)
+		if err != nil || u.Host == "" {
+			return "", "", false
+		}
+		host, rest = u.Hostname(), u.Path
+		if u.Scheme != "ssh" && u.Port() != "" {
+			host = u.Host
+		}
+	case strings.HasPrefix(repository, "git@"):
+		host, rest, ok = strings.Cut(strings.TrimPrefix(repository, "git@"), ":")
+		if !ok {
+			return "", "", false
+		}
+	default:
+		return "", "", false
+	}
+	rest = strings.TrimSuffix(strings.Trim(rest, "/"), ".git")
+	if host == "" || !strings.Contains(rest, "/") {
+		return "", "", false
+	}
+	host = strings.ToLower(host)
+	return "https://" + host + "/" + rest, host, true
+}
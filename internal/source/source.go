@@ -0,0 +1,182 @@
+// Package source fetches file content from the host of an indexed
+// repository, so chunks indexed without their content can still be shown.
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// ErrUnsupported is returned for repositories the fetcher cannot reach,
+// e.g. local directories or hosts other than GitHub.
+var ErrUnsupported = errors.New("repository host not supported")
+
+// DefaultMaxFileSize caps the size of fetched files when GitHub.MaxSize is
+// not set.
+const DefaultMaxFileSize = 5 << 20
+
+// Fetcher returns the content of a file at a ref of a repository.
+type Fetcher interface {
+	Fetch(ctx context.Context, repository, ref, path string) ([]byte, error)
+}
+
+// GitHub fetches files through the GitHub contents API.
+type GitHub struct {
+	APIURL  string // default https://api.github.com
+	Token   string
+	HTTP    *http.Client
+	MaxSize int64 // bytes of a file read at most; default DefaultMaxFileSize
+}
+
+// NewGitHub creates a GitHub fetcher. token may be empty for public
+// repositories.
+func NewGitHub(token string) *GitHub {
+	return &GitHub{
+		APIURL: "https://api.github.com",
+		Token:  token,
+		HTTP:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Fetch returns the raw content of path at ref. The repository is the URL it
+// was indexed from, e.g. https://github.com/org/repo.git.
+func (g *GitHub) Fetch(ctx context.Context, repository, ref, path string) ([]byte, error) {
+	owner, name, ok := parseGitHubRepo(repository)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, repository)
+	}
+	segs := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, s := range segs {
+		segs[i] = url.PathEscape(s)
+	}
+	u := fmt.Sprintf("%s/repos/%s/%s/contents/%s?ref=%s",
+		strings.TrimRight(g.APIURL, "/"), url.PathEscape(owner), url.PathEscape(name),
+		strings.Join(segs, "/"), url.QueryEscape(ref))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github.raw")
+	if g.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.Token)
+	}
+
+	resp, err := g.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("github contents returned status %d for %s", resp.StatusCode, path)
+	}
+	limit := g.MaxSize
+	if limit <= 0 {
+		limit = DefaultMaxFileSize
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, limit))
+}
+
+// parseGitHubRepo extracts the owner and name from a github.com clone URL in
+// HTTPS or SSH form.
+func parseGitHubRepo(repository string) (owner, name string, ok bool) {
+	rest, found := "", false
+	for _, prefix := range []string{"https://github.com/", "http://github.com/", "git@github.com:", "ssh://git@github.com/"} {
+		if strings.HasPrefix(repository, prefix) {
+			rest, found = strings.TrimPrefix(repository, prefix), true
+			break
+		}
+	}
+	if !found {
+		return "", "", false
+	}
+	rest = strings.TrimSuffix(strings.TrimSuffix(rest, "/"), ".git")
+	owner, name, ok = strings.Cut(rest, "/")
+	if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		return "", "", false
+	}
+	return owner, name, true
+}
+
+// Lines returns the 1-based, inclusive line range of content.
+func Lines(content []byte, start, end int) string {
+	lines := strings.Split(string(content), "\n")
+	if start < 1 {
+		start = 1
+	}
+	if end > len(lines) {
+		end = len(lines)
+	}
+	if start > end {
+		return ""
+	}
+	return strings.Join(lines[start-1:end], "\n")
+}
+
+// Cache fills in chunk content, fetching each file at most once. It is safe
+// for concurrent use; chunks of a file being fetched wait for that fetch.
+type Cache struct {
+	f     Fetcher
+	mu    sync.Mutex
+	files map[[3]string]*fetched
+}
+
+type fetched struct {
+	once    sync.Once
+	content []byte
+	err     error
+}
+
+// NewCache creates a cache over f. Caches are meant to live for a single
+// request.
+func NewCache(f Fetcher) *Cache {
+	return &Cache{f: f, files: map[[3]string]*fetched{}}
+}
+
+// Content returns the content of ch's line range.
+func (c *Cache) Content(ctx context.Context, ch models.Chunk) (string, error) {
+	key := [3]string{ch.Repository, ch.Ref, ch.Path}
+	c.mu.Lock()
+	f, ok := c.files[key]
+	if !ok {
+		f = &fetched{}
+		c.files[key] = f
+	}
+	c.mu.Unlock()
+	f.once.Do(func() {
+		f.content, f.err = c.f.Fetch(ctx, ch.Repository, ch.Ref, ch.Path)
+	})
+	if f.err != nil {
+		return "", f.err
+	}
+	return Lines(f.content, ch.LineStart, ch.LineEnd), nil
+}
+
+// Assemble rebuilds the content of a file from its chunks, which may overlap
+// and are placed by their line ranges. Lines not covered by any chunk are
+// left empty.
+func Assemble(chunks []models.Chunk) string {
+	var lines []string
+	set := map[int]bool{}
+	for _, c := range chunks {
+		for i, l := range strings.Split(c.Content, "\n") {
+			n := c.LineStart + i
+			if n < 1 || (c.LineEnd > 0 && n > c.LineEnd) || set[n] {
+				continue
+			}
+			for len(lines) < n {
+				lines = append(lines, "")
+			}
+			lines[n-1], set[n] = l, true
+		}
+	}
+	return strings.Join(lines, "\n")
+}
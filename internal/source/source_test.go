@@ -0,0 +1,219 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+func TestGitHub_Fetch(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path != "/repos/org/repo/contents/cmd/main.go" || r.URL.Query().Get("ref") != "main" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("Accept") != "application/vnd.github.raw" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		_, _ = w.Write([]byte("package main\n\nfunc main() {}\n"))
+	}))
+	defer srv.Close()
+
+	g := NewGitHub("secret")
+	g.APIURL = srv.URL
+	c := NewCache(g)
+	ch := models.Chunk{Repository: "https://github.com/org/repo.git", Ref: "main", Path: "cmd/main.go", LineStart: 3, LineEnd: 3}
+	// Chunks of the same file may be filled concurrently.
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := c.Content(context.Background(), ch)
+			if err != nil || got != "func main() {}" {
+				t.Errorf("Content = %q, %v", got, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected the file to be fetched once, got %d", n)
+	}
+
+	g.MaxSize = 7
+	if b, err := g.Fetch(context.Background(), ch.Repository, ch.Ref, ch.Path); err != nil || string(b) != "package" {
+		t.Errorf("expected the file to be cut at MaxSize, got %q, %v", b, err)
+	}
+
+	if _, err := g.Fetch(context.Background(), "local", "main", "a.go"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestParseGitHubRepo(t *testing.T) {
+	for in, ok := range map[string]bool{
+		"https://github.com/org/repo.git": true,
+		"https://github.com/org/repo":     true,
+		"git@github.com:org/repo.git":     true,
+		"https://gitlab.com/org/repo.git": false,
+		"https://github.com/org":          false,
+		"local":                           false,
+	} {
+		owner, name, got := parseGitHubRepo(in)
+		if got != ok || (ok && (owner != "org" || name != "repo")) {
+			t.Errorf("parseGitHubRepo(%q) = %q, %q, %v", in, owner, name, got)
+		}
+	}
+}
+
+func TestPermalink(t *testing.T) {
+	tests := []struct {
+		repository, ref, path string
+		start, end            int
+		want                  string
+	}{
+		{"https://github.com/org/repo.git", "main", "cmd/a.go", 10, 42, "https://github.com/org/repo/blob/main/cmd/a.go#L10-L42"},
+		{"git@github.com:org/repo.git", "v1.2.0", "a.go", 7, 7, "https://github.com/org/repo/blob/v1.2.0/a.go#L7"},
+		{"ssh://git@github.example.com/org/repo", "", "dir/my file.go", 0, 0, "https://github.example.com/org/repo/blob/HEAD/dir/my%20file.go"},
+		{"https://gitlab.com/group/sub/repo.git", "main", "a.go", 10, 42, "https://gitlab.com/group/sub/repo/-/blob/main/a.go#L10-42"},
+		{"git@gitlab.internal:group/repo.git", "dev", "a.go", 3, 1, "https://gitlab.internal/group/repo/-/blob/dev/a.go#L3"},
+		{"https://bitbucket.org/org/repo.git", "main", "a.go", 1, 2, ""},
+		{"https://github.com/org", "main", "a.go", 1, 2, ""},
+		{"local", "main", "a.go", 1, 2, ""},
+	}
+	for _, tt := range tests {
+		if got := Permalink(tt.repository, tt.ref, tt.path, tt.start, tt.end); got != tt.want {
+			t.Errorf("Permalink(%q, %q, %q, %d, %d) = %q, want %q", tt.repository, tt.ref, tt.path, tt.start, tt.end, got, tt.want)
+		}
+	}
+}
+
+func TestLines(t *testing.T) {
+	content := []byte("a\nb\nc\n")
+	for _, tc := range []struct {
+		start, end int
+		want       string
+	}{
+		{1, 2, "a\nb"},
+		{2, 10, "b\nc\n"},
+		{0, 1, "a"},
+		{5, 6, ""},
+	} {
+		if got := Lines(content, tc.start, tc.end); got != tc.want {
+			t.Errorf("Lines(%d, %d) = %q, want %q", tc.start, tc.end, got, tc.want)
+		}
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		chunks []models.Chunk
+		want   string
+	}{
+		{"single", []models.Chunk{{Content: "a\nb\n", LineStart: 1, LineEnd: 3}}, "a\nb\n"},
+		{"overlapping", []models.Chunk{
+			{Content: "c\nd", LineStart: 3, LineEnd: 4},
+			{Content: "a\nb\nc", LineStart: 1, LineEnd: 3},
+		}, "a\nb\nc\nd"},
+		{"gap", []models.Chunk{{Content: "a", LineStart: 1, LineEnd: 1}, {Content: "d", LineStart: 4, LineEnd: 4}}, "a\n\n\nd"},
+		{"none", nil, ""},
+	} {
+		if got := Assemble(tc.chunks); got != tc.want {
+			t.Errorf("%s: Assemble = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestGitHubAccess(t *testing.T) {
+	calls := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls[r.URL.Path]++
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		switch r.URL.Path {
+		case "/repos/org/open":
+			_, _ = w.Write([]byte(`{"private": false}`))
+		case "/repos/org/private":
+			_, _ = w.Write([]byte(`{"private": true}`))
+		case "/repos/org/private/collaborators/alice/permission":
+			_, _ = w.Write([]byte(`{"permission": "read"}`))
+		case "/repos/org/private/collaborators/bob/permission":
+			_, _ = w.Write([]byte(`{"permission": "none"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	a := NewGitHubAccess("secret", 0)
+	a.APIURL = srv.URL
+	ctx := context.Background()
+	repos := []string{"https://github.com/org/open.git", "https://github.com/org/private", "/srv/local", "https://github.com/org/gone"}
+	for _, tt := range []struct {
+		login string
+		want  []string
+	}{
+		{"alice", []string{"https://github.com/org/open.git", "https://github.com/org/private", "/srv/local"}},
+		{"Alice", []string{"https://github.com/org/open.git", "https://github.com/org/private", "/srv/local"}},
+		{"bob", []string{"https://github.com/org/open.git", "/srv/local"}},
+		{"carol", []string{"https://github.com/org/open.git", "/srv/local"}},
+		{"", []string{"https://github.com/org/open.git", "/srv/local"}},
+	} {
+		got, err := a.Visible(ctx, tt.login, repos)
+		if err == nil {
+			t.Errorf("Visible(%q) did not report the repository it could not check", tt.login)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Visible(%q) = %v, want %v", tt.login, got, tt.want)
+		}
+	}
+
+	// Answers are cached; failures are not.
+	if calls["/repos/org/private"] != 1 || calls["/repos/org/private/collaborators/alice/permission"] != 1 || calls["/repos/org/gone"] != 5 {
+		t.Errorf("unexpected GitHub calls %v", calls)
+	}
+
+	// Lists without failed checks are cached per login.
+	listed := 0
+	list := func(context.Context) ([]string, error) {
+		listed++
+		return repos[:3], nil
+	}
+	for _, login := range []string{"alice", "Alice"} {
+		got, err := a.Repositories(ctx, login, list)
+		if err != nil || !slices.Equal(got, repos[:3]) {
+			t.Errorf("Repositories(%q) = %v, %v", login, got, err)
+		}
+	}
+	if listed != 1 {
+		t.Errorf("expected the list to be cached, listed %d times", listed)
+	}
+	if _, err := a.Repositories(ctx, "bob", func(context.Context) ([]string, error) { return repos, nil }); err == nil {
+		t.Error("expected the failed check to be reported")
+	}
+	if _, ok := a.lists["bob"]; ok {
+		t.Error("expected a list with failed checks not to be cached")
+	}
+
+	// Expired answers are pruned.
+	a.TTL = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	a.mu.Lock()
+	a.prune()
+	n := len(a.cache) + len(a.lists)
+	a.mu.Unlock()
+	if n != 0 {
+		t.Errorf("expected expired answers to be pruned, %d left", n)
+	}
+}
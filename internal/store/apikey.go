@@ -0,0 +1,172 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// apiKeySchema is shared by Postgres and SQLite. Only the SHA-256 hash of a
+// key is stored. scopes and repositories are comma-separated lists.
+const apiKeySchema = `
+CREATE TABLE IF NOT EXISTS api_keys (
+  id           TEXT PRIMARY KEY,
+  name         TEXT NOT NULL,
+  prefix       TEXT NOT NULL,
+  key_hash     TEXT NOT NULL UNIQUE,
+  created_by   TEXT NOT NULL,
+  created_at   TIMESTAMP NOT NULL,
+  last_used_at TIMESTAMP,
+  revoked_at   TIMESTAMP,
+  scopes       TEXT NOT NULL DEFAULT '` + apiKeyLegacyScopes + `',
+  expires_at   TIMESTAMP,
+  repositories TEXT NOT NULL DEFAULT ''
+);
+`
+
+// apiKeyColumnsPG adds the columns of apiKeySchema that tables created by
+// older versions lack. SQLite adds them in Migrate.
+const apiKeyColumnsPG = `
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS scopes TEXT NOT NULL DEFAULT '` + apiKeyLegacyScopes + `';
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS repositories TEXT NOT NULL DEFAULT '';
+`
+
+// apiKeyLegacyScopes are the scopes of keys created before keys had scopes,
+// which could call every endpoint.
+const apiKeyLegacyScopes = "search,index,admin"
+
+const apiKeyColumns = `id, name, prefix, created_by, created_at, last_used_at, revoked_at, scopes, expires_at, repositories`
+
+// ListAPIKeys returns all API keys, including revoked ones, newest first.
+func (s *Store) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	rows, err := s.pool.Query(ctx, `SELECT `+apiKeyColumns+` FROM api_keys ORDER BY created_at DESC, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanAPIKeys(rows)
+}
+
+// CreateAPIKey stores a new API key under the hash of its secret. The caller
+// sets its id, prefix and creation time.
+func (s *Store) CreateAPIKey(ctx context.Context, k models.APIKey, hash string) error {
+	_, err := s.pool.Exec(ctx, `
+      INSERT INTO api_keys (id, name, prefix, key_hash, created_by, created_at, scopes, expires_at, repositories)
+      VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		k.ID, k.Name, k.Prefix, hash, k.CreatedBy, k.CreatedAt.UTC(), strings.Join(k.Scopes, ","), nullTime(k.ExpiresAt), strings.Join(k.Repositories, ","))
+	return err
+}
+
+// RevokeAPIKey marks an API key as revoked at the given time. It reports
+// false when there is no unrevoked key with that id.
+func (s *Store) RevokeAPIKey(ctx context.Context, id string, at time.Time) (bool, error) {
+	tag, err := s.pool.Exec(ctx, `UPDATE api_keys SET revoked_at = $2 WHERE id = $1 AND revoked_at IS NULL`, id, at.UTC())
+	return tag.RowsAffected() > 0, err
+}
+
+// LookupAPIKey returns the unrevoked, unexpired API key with the given hash
+// and records that it was used. It reports false when there is none.
+func (s *Store) LookupAPIKey(ctx context.Context, hash string) (models.APIKey, bool, error) {
+	rows, err := s.pool.Query(ctx, `
+      UPDATE api_keys SET last_used_at = $2
+      WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > $2)
+      RETURNING `+apiKeyColumns, hash, time.Now().UTC())
+	if err != nil {
+		return models.APIKey{}, false, err
+	}
+	defer rows.Close()
+	return firstAPIKey(scanAPIKeys(rows))
+}
+
+// ListAPIKeys returns all API keys, including revoked ones, newest first.
+func (s *SQLiteStore) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys ORDER BY created_at DESC, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	return scanAPIKeys(rows)
+}
+
+// CreateAPIKey stores a new API key under the hash of its secret. The caller
+// sets its id, prefix and creation time.
+func (s *SQLiteStore) CreateAPIKey(ctx context.Context, k models.APIKey, hash string) error {
+	_, err := s.db.ExecContext(ctx, `
+      INSERT INTO api_keys (id, name, prefix, key_hash, created_by, created_at, scopes, expires_at, repositories)
+      VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		k.ID, k.Name, k.Prefix, hash, k.CreatedBy, k.CreatedAt.UTC(), strings.Join(k.Scopes, ","), nullTime(k.ExpiresAt), strings.Join(k.Repositories, ","))
+	return err
+}
+
+// RevokeAPIKey marks an API key as revoked at the given time. It reports
+// false when there is no unrevoked key with that id.
+func (s *SQLiteStore) RevokeAPIKey(ctx context.Context, id string, at time.Time) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, at.UTC(), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// LookupAPIKey returns the unrevoked, unexpired API key with the given hash
+// and records that it was used. It reports false when there is none.
+func (s *SQLiteStore) LookupAPIKey(ctx context.Context, hash string) (models.APIKey, bool, error) {
+	now := time.Now().UTC()
+	rows, err := s.db.QueryContext(ctx, `
+      UPDATE api_keys SET last_used_at = ?
+      WHERE key_hash = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)
+      RETURNING `+apiKeyColumns, now, hash, now)
+	if err != nil {
+		return models.APIKey{}, false, err
+	}
+	defer func() { _ = rows.Close() }()
+	return firstAPIKey(scanAPIKeys(rows))
+}
+
+func scanAPIKeys(rows rowScanner) ([]models.APIKey, error) {
+	out := []models.APIKey{}
+	for rows.Next() {
+		var k models.APIKey
+		var created, used, revoked, expires sql.NullTime
+		var scopes, repos string
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, &k.CreatedBy, &created, &used, &revoked, &scopes, &expires, &repos); err != nil {
+			return nil, err
+		}
+		k.Scopes = strings.Split(scopes, ",")
+		if repos != "" {
+			k.Repositories = strings.Split(repos, ",")
+		}
+		if expires.Valid {
+			k.ExpiresAt = &expires.Time
+		}
+		k.CreatedAt = created.Time
+		if used.Valid {
+			k.LastUsedAt = &used.Time
+		}
+		if revoked.Valid {
+			k.RevokedAt = &revoked.Time
+		}
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+func firstAPIKey(list []models.APIKey, err error) (models.APIKey, bool, error) {
+	if err != nil || len(list) == 0 {
+		return models.APIKey{}, false, err
+	}
+	return list[0], true, nil
+}
+
+// nullTime converts an optional time for a nullable TIMESTAMP column.
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
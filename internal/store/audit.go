@@ -0,0 +1,157 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// searchAuditSchema is shared by Postgres and SQLite. Repositories are
+// stored as a JSON array, so that a repository can be matched by its quoted
+// name.
+const searchAuditSchema = `
+CREATE TABLE IF NOT EXISTS search_audit (
+  user_login   TEXT NOT NULL DEFAULT '',
+  path         TEXT NOT NULL,
+  query        TEXT NOT NULL,
+  filters      TEXT NOT NULL DEFAULT '',
+  repositories TEXT NOT NULL DEFAULT '[]',
+  results      INT NOT NULL,
+  created_at   TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS search_audit_created_idx ON search_audit (created_at);
+CREATE INDEX IF NOT EXISTS search_audit_user_idx ON search_audit (user_login, created_at);
+`
+
+// AuditFilter selects search audit records. Zero fields match every record.
+type AuditFilter struct {
+	User       string    // login of the user who searched
+	Repository string    // a repository the results came from
+	Since      time.Time // records at or after this time
+	Until      time.Time // records before this time
+	Limit      int       // maximum number of records, newest first
+}
+
+const searchAuditColumns = `user_login, path, query, filters, repositories, results, created_at`
+
+// auditArgs returns the column values of a, in searchAuditColumns order.
+func auditArgs(a models.SearchAudit) ([]any, error) {
+	repos := a.Repositories
+	if repos == nil {
+		repos = []string{}
+	}
+	b, err := json.Marshal(repos)
+	if err != nil {
+		return nil, err
+	}
+	at := a.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+	return []any{a.User, a.Path, a.Query, a.Filters, string(b), a.Results, at.UTC()}, nil
+}
+
+// auditQuery builds the SELECT for f; param returns the placeholder of the
+// n-th argument.
+func auditQuery(f AuditFilter, param func(n int) string) (string, []any, error) {
+	where := ` WHERE 1 = 1`
+	var args []any
+	add := func(cond string, v any) {
+		args = append(args, v)
+		where += ` AND ` + cond + ` ` + param(len(args))
+	}
+	if f.User != "" {
+		add(`user_login =`, f.User)
+	}
+	if f.Repository != "" {
+		quoted, err := json.Marshal(f.Repository)
+		if err != nil {
+			return "", nil, err
+		}
+		add(`repositories LIKE`, "%"+likeEscape(string(quoted))+"%")
+		where += ` ESCAPE '\'`
+	}
+	if !f.Since.IsZero() {
+		add(`created_at >=`, f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		add(`created_at <`, f.Until.UTC())
+	}
+	args = append(args, f.Limit)
+	return `SELECT ` + searchAuditColumns + ` FROM search_audit` + where +
+		` ORDER BY created_at DESC LIMIT ` + param(len(args)), args, nil
+}
+
+// RecordSearchAudit appends a search to the audit trail.
+func (s *Store) RecordSearchAudit(ctx context.Context, a models.SearchAudit) error {
+	args, err := auditArgs(a)
+	if err != nil {
+		return err
+	}
+	_, err = s.pool.Exec(ctx, `
+      INSERT INTO search_audit (`+searchAuditColumns+`)
+      VALUES ($1, $2, $3, $4, $5, $6, $7)`, args...)
+	return err
+}
+
+// SearchAudit returns the audit records matching f, newest first.
+func (s *Store) SearchAudit(ctx context.Context, f AuditFilter) ([]models.SearchAudit, error) {
+	q, args, err := auditQuery(f, func(n int) string { return "$" + strconv.Itoa(n) })
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.read.Query(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanSearchAudit(rows)
+}
+
+// RecordSearchAudit appends a search to the audit trail.
+func (s *SQLiteStore) RecordSearchAudit(ctx context.Context, a models.SearchAudit) error {
+	args, err := auditArgs(a)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+      INSERT INTO search_audit (`+searchAuditColumns+`)
+      VALUES (?, ?, ?, ?, ?, ?, ?)`, args...)
+	return err
+}
+
+// SearchAudit returns the audit records matching f, newest first.
+func (s *SQLiteStore) SearchAudit(ctx context.Context, f AuditFilter) ([]models.SearchAudit, error) {
+	q, args, err := auditQuery(f, func(int) string { return "?" })
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	return scanSearchAudit(rows)
+}
+
+func scanSearchAudit(rows rowScanner) ([]models.SearchAudit, error) {
+	out := []models.SearchAudit{}
+	for rows.Next() {
+		var a models.SearchAudit
+		var repos string
+		var at sql.NullTime
+		if err := rows.Scan(&a.User, &a.Path, &a.Query, &a.Filters, &repos, &a.Results, &at); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(repos), &a.Repositories); err != nil {
+			return nil, err
+		}
+		a.At = at.Time
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// authEventSchema is shared by Postgres and SQLite.
+const authEventSchema = `
+CREATE TABLE IF NOT EXISTS auth_events (
+  event      TEXT NOT NULL,
+  user_login TEXT NOT NULL DEFAULT '',
+  ip         TEXT NOT NULL DEFAULT '',
+  user_agent TEXT NOT NULL DEFAULT '',
+  outcome    TEXT NOT NULL,
+  detail     TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS auth_events_created_idx ON auth_events (created_at);
+CREATE INDEX IF NOT EXISTS auth_events_user_idx ON auth_events (user_login, created_at);
+`
+
+// AuthEventFilter selects auth events. Zero fields match every event.
+type AuthEventFilter struct {
+	User    string    // login of the user
+	Event   string    // event type, e.g. login
+	Outcome string    // success or failure
+	Since   time.Time // events at or after this time
+	Until   time.Time // events before this time
+	Limit   int       // maximum number of events, newest first
+}
+
+const authEventColumns = `event, user_login, ip, user_agent, outcome, detail, created_at`
+
+// authEventArgs returns the column values of e, in authEventColumns order.
+func authEventArgs(e models.AuthEvent) []any {
+	at := e.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+	return []any{e.Event, e.User, e.IP, e.UserAgent, e.Outcome, e.Detail, at.UTC()}
+}
+
+// authEventQuery builds the SELECT for f; param returns the placeholder of
+// the n-th argument.
+func authEventQuery(f AuthEventFilter, param func(n int) string) (string, []any) {
+	where := ` WHERE 1 = 1`
+	var args []any
+	add := func(cond string, v any) {
+		args = append(args, v)
+		where += ` AND ` + cond + ` ` + param(len(args))
+	}
+	if f.User != "" {
+		add(`user_login =`, f.User)
+	}
+	if f.Event != "" {
+		add(`event =`, f.Event)
+	}
+	if f.Outcome != "" {
+		add(`outcome =`, f.Outcome)
+	}
+	if !f.Since.IsZero() {
+		add(`created_at >=`, f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		add(`created_at <`, f.Until.UTC())
+	}
+	args = append(args, f.Limit)
+	return `SELECT ` + authEventColumns + ` FROM auth_events` + where +
+		` ORDER BY created_at DESC LIMIT ` + param(len(args)), args
+}
+
+// RecordAuthEvent appends an event to the auth event log.
+func (s *Store) RecordAuthEvent(ctx context.Context, e models.AuthEvent) error {
+	_, err := s.pool.Exec(ctx, `
+      INSERT INTO auth_events (`+authEventColumns+`)
+      VALUES ($1, $2, $3, $4, $5, $6, $7)`, authEventArgs(e)...)
+	return err
+}
+
+// AuthEvents returns the auth events matching f, newest first.
+func (s *Store) AuthEvents(ctx context.Context, f AuthEventFilter) ([]models.AuthEvent, error) {
+	q, args := authEventQuery(f, func(n int) string { return "$" + strconv.Itoa(n) })
+	rows, err := s.read.Query(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanAuthEvents(rows)
+}
+
+// RecordAuthEvent appends an event to the auth event log.
+func (s *SQLiteStore) RecordAuthEvent(ctx context.Context, e models.AuthEvent) error {
+	_, err := s.db.ExecContext(ctx, `
+      INSERT INTO auth_events (`+authEventColumns+`)
+      VALUES (?, ?, ?, ?, ?, ?, ?)`, authEventArgs(e)...)
+	return err
+}
+
+// AuthEvents returns the auth events matching f, newest first.
+func (s *SQLiteStore) AuthEvents(ctx context.Context, f AuthEventFilter) ([]models.AuthEvent, error) {
+	q, args := authEventQuery(f, func(int) string { return "?" })
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	return scanAuthEvents(rows)
+}
+
+func scanAuthEvents(rows rowScanner) ([]models.AuthEvent, error) {
+	out := []models.AuthEvent{}
+	for rows.Next() {
+		var e models.AuthEvent
+		var at sql.NullTime
+		if err := rows.Scan(&e.Event, &e.User, &e.IP, &e.UserAgent, &e.Outcome, &e.Detail, &at); err != nil {
+			return nil, err
+		}
+		e.At = at.Time
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// Backend is the full set of operations the API and indexer need from a
+// store. Both the Postgres Store and SQLiteStore implement it.
+type Backend interface {
+	ChunkStore
+	PagedSearcher
+	RollupStore
+	MaintenanceStore
+	VectorMigrationStore
+	JobStore
+
+	GetRefs(ctx context.Context, repository string) ([]string, error)
+	IndexVersion(ctx context.Context) (int64, error)
+	GetChunkByID(ctx context.Context, id string) (models.Chunk, bool, error)
+	GetFileChunks(ctx context.Context, repository, ref, path string) ([]models.Chunk, error)
+	GetNeighbors(ctx context.Context, c models.Chunk, n int) (before, after []models.Chunk, err error)
+	SimilarChunks(ctx context.Context, id string, k int, opt QueryOpts) ([]models.SearchResult, bool, error)
+	Stats(ctx context.Context) (models.IndexStats, error)
+	Facets(ctx context.Context, opt QueryOpts) (models.Facets, error)
+	Suggest(ctx context.Context, prefix string, limit int, user string, opt QueryOpts) (models.Suggestions, error)
+	LogQuery(ctx context.Context, l QueryLog) error
+	QueryStats(ctx context.Context, since time.Time) (models.QueryStats, error)
+	RecordSearchAudit(ctx context.Context, a models.SearchAudit) error
+	SearchAudit(ctx context.Context, f AuditFilter) ([]models.SearchAudit, error)
+	RecordAuthEvent(ctx context.Context, e models.AuthEvent) error
+	AuthEvents(ctx context.Context, f AuthEventFilter) ([]models.AuthEvent, error)
+	AppendChatTurns(ctx context.Context, sessionID, user string, seq int, turns []models.ChatTurn) error
+	ChatHistory(ctx context.Context, sessionID string) ([]models.ChatTurn, string, error)
+	ListSavedSearches(ctx context.Context, user string) ([]models.SavedSearch, error)
+	GetSavedSearch(ctx context.Context, user, id string) (models.SavedSearch, bool, error)
+	CreateSavedSearch(ctx context.Context, user string, s models.SavedSearch) error
+	UpdateSavedSearch(ctx context.Context, user string, s models.SavedSearch) (bool, error)
+	DeleteSavedSearch(ctx context.Context, user, id string) (bool, error)
+	ListAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	CreateAPIKey(ctx context.Context, k models.APIKey, hash string) error
+	RevokeAPIKey(ctx context.Context, id string, at time.Time) (bool, error)
+	LookupAPIKey(ctx context.Context, hash string) (models.APIKey, bool, error)
+	CreateRefreshToken(ctx context.Context, t models.RefreshToken, hash string) error
+	UseRefreshToken(ctx context.Context, hash string, at time.Time) (models.RefreshToken, bool, error)
+	RevokeRefreshTokens(ctx context.Context, family string, at time.Time) error
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	RevokeSessions(ctx context.Context, login string, at time.Time) error
+	TokenRevoked(ctx context.Context, jti, login string, issuedAt time.Time) (bool, error)
+	IncrementUsage(ctx context.Context, login, kind string, at time.Time) (int, error)
+	GrantRepository(ctx context.Context, g models.RepositoryGrant) error
+	ReplaceRepositoryGrants(ctx context.Context, grantedBy string, grants []models.RepositoryGrant) error
+	RevokeRepositoryGrant(ctx context.Context, repository, principal string) (bool, error)
+	RepositoryGrants(ctx context.Context, repository string) ([]models.RepositoryGrant, error)
+	HiddenRepositories(ctx context.Context, principals []string) ([]string, error)
+	RecordIndexRun(ctx context.Context, r models.IndexRun) error
+	RepositoryStatus(ctx context.Context, repository string) (models.RepositoryStatus, error)
+	DeleteRepository(ctx context.Context, repository string) (int64, error)
+	DeleteRef(ctx context.Context, repository, ref string) (int64, error)
+	GetPaths(ctx context.Context, repository, ref string) (map[string]int64, error)
+	DeletePaths(ctx context.Context, repository, ref string, paths []string) (int64, error)
+	RestoreRepository(ctx context.Context, repository string) (int64, error)
+	RestoreRef(ctx context.Context, repository, ref string) (int64, error)
+	Vacuum(ctx context.Context, repository string) (int64, error)
+	Optimize(ctx context.Context, reindex bool) error
+	ExportChunks(ctx context.Context, repository string, fn func(ChunkWithVec) error) error
+	Ping(ctx context.Context) error
+	Close()
+}
+
+var (
+	_ Backend = (*Store)(nil)
+	_ Backend = (*SQLiteStore)(nil)
+)
+
+// Open connects to the store selected by the scheme of url: sqlite:// for
+// a local SQLite file, anything else for Postgres. Options only apply to
+// Postgres, except for WithRanking.
+func Open(ctx context.Context, url string, opts ...Option) (Backend, error) {
+	if path, ok := sqlitePath(url); ok {
+		s, err := OpenSQLite(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		pg := Store{ranking: s.ranking}
+		for _, o := range opts {
+			o(&pg)
+		}
+		s.ranking = pg.ranking
+		return s, nil
+	}
+	return New(ctx, url, opts...)
+}
+
+// sqlitePath extracts the database path from a sqlite:// or sqlite: URL.
+func sqlitePath(url string) (string, bool) {
+	for _, prefix := range []string{"sqlite://", "sqlite:"} {
+		if strings.HasPrefix(url, prefix) {
+			return strings.TrimPrefix(url, prefix), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,268 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// DefaultCacheTTL is how long search results are cached when no TTL is
+// configured.
+const DefaultCacheTTL = 10 * time.Minute
+
+// Cache key layout. Cached results are keyed by the search and the version
+// counters of the repositories it covers, so bumping a counter orphans the
+// entries that could have changed and they expire with their TTL.
+const (
+	cachePrefix       = "reposearch:"
+	cacheVersionAll   = cachePrefix + "v:all"   // bumped by every write
+	cacheVersionEpoch = cachePrefix + "v:epoch" // bumped by writes not tied to a repository
+	cacheVersionRepo  = cachePrefix + "v:repo:"
+)
+
+// Cached is a Backend that caches chunk search results in Redis. Writes made
+// through it bump the version of the repositories they touch, so the API and
+// the indexer must both be configured with the same cache. Redis failures
+// never fail a search; they are reported to Logf and the search goes to the
+// database.
+type Cached struct {
+	Backend
+	redis *Redis
+	ttl   time.Duration
+	Logf  func(format string, args ...any) // optional
+}
+
+var (
+	_ Backend    = (*Cached)(nil)
+	_ FileWriter = (*Cached)(nil)
+)
+
+// NewCached caches the searches of b in r for ttl, or DefaultCacheTTL when
+// ttl is zero.
+func NewCached(b Backend, r *Redis, ttl time.Duration) *Cached {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return &Cached{Backend: b, redis: r, ttl: ttl}
+}
+
+// Search returns cached results when the searched repositories have not
+// changed since they were cached.
+func (c *Cached) Search(ctx context.Context, summaryVec []float32, k int, opt QueryOpts) ([]models.SearchResult, error) {
+	var res []models.SearchResult
+	key, hit := c.lookup(ctx, "search", summaryVec, k, opt, &res)
+	if hit {
+		return res, nil
+	}
+	res, err := c.Backend.Search(ctx, summaryVec, k, opt)
+	if err == nil {
+		c.store(ctx, key, res)
+	}
+	return res, err
+}
+
+// SearchPage is Search for one page of results.
+func (c *Cached) SearchPage(ctx context.Context, summaryVec []float32, k int, opt QueryOpts) (models.SearchPage, error) {
+	var page models.SearchPage
+	key, hit := c.lookup(ctx, "page", summaryVec, k, opt, &page)
+	if hit {
+		return page, nil
+	}
+	page, err := c.Backend.SearchPage(ctx, summaryVec, k, opt)
+	if err == nil {
+		c.store(ctx, key, page)
+	}
+	return page, err
+}
+
+// lookup computes the cache key of a search and decodes a cached result
+// into out. The key is empty when the versions could not be read.
+func (c *Cached) lookup(ctx context.Context, kind string, vec []float32, k int, opt QueryOpts, out any) (string, bool) {
+	versionKeys := []string{cacheVersionAll}
+	if len(opt.Repositories) > 0 {
+		versionKeys = []string{cacheVersionEpoch}
+		for _, r := range opt.Repositories {
+			versionKeys = append(versionKeys, cacheVersionRepo+r)
+		}
+	}
+	versions, err := c.redis.MGet(ctx, versionKeys...)
+	if err != nil {
+		c.logf("cache: reading versions: %v", err)
+		return "", false
+	}
+	raw, _ := json.Marshal(struct {
+		Kind     string
+		Vec      []float32
+		K        int
+		Opt      QueryOpts
+		Versions []string
+	}{kind, vec, k, opt, versions})
+	sum := sha256.Sum256(raw)
+	key := cachePrefix + "search:" + hex.EncodeToString(sum[:])
+
+	b, ok, err := c.redis.Get(ctx, key)
+	if err != nil {
+		c.logf("cache: get: %v", err)
+		return key, false
+	}
+	if !ok {
+		return key, false
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		c.logf("cache: decoding %s: %v", key, err)
+		return key, false
+	}
+	return key, true
+}
+
+func (c *Cached) store(ctx context.Context, key string, v any) {
+	if key == "" {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err == nil {
+		err = c.redis.Set(ctx, key, b, c.ttl)
+	}
+	if err != nil {
+		c.logf("cache: set: %v", err)
+	}
+}
+
+// invalidate bumps the versions of repositories, or of everything when none
+// are given.
+func (c *Cached) invalidate(ctx context.Context, repositories ...string) {
+	keys := []string{cacheVersionAll}
+	if len(repositories) == 0 {
+		keys = append(keys, cacheVersionEpoch)
+	}
+	seen := map[string]bool{}
+	for _, r := range repositories {
+		if !seen[r] {
+			seen[r] = true
+			keys = append(keys, cacheVersionRepo+r)
+		}
+	}
+	if err := c.redis.Incr(ctx, keys...); err != nil {
+		c.logf("cache: invalidating %v: %v", repositories, err)
+	}
+}
+
+func (c *Cached) logf(format string, args ...any) {
+	if c.Logf != nil {
+		c.Logf(format, args...)
+	}
+}
+
+func (c *Cached) UpsertChunk(ctx context.Context, ch models.Chunk, summaryVec []float32, contentHash string) error {
+	err := c.Backend.UpsertChunk(ctx, ch, summaryVec, contentHash)
+	c.invalidate(ctx, ch.Repository)
+	return err
+}
+
+func (c *Cached) UpsertChunks(ctx context.Context, chunks []ChunkWithVec) error {
+	err := c.Backend.UpsertChunks(ctx, chunks)
+	repos := make([]string, len(chunks))
+	for i, ch := range chunks {
+		repos[i] = ch.Chunk.Repository
+	}
+	if len(repos) > 0 {
+		c.invalidate(ctx, repos...)
+	}
+	return err
+}
+
+// WriteFiles implements FileWriter when the wrapped store does.
+func (c *Cached) WriteFiles(ctx context.Context, files []FileChunks) error {
+	fw, ok := c.Backend.(FileWriter)
+	if !ok {
+		return errors.New("store does not support writing files")
+	}
+	err := fw.WriteFiles(ctx, files)
+	repos := make([]string, len(files))
+	for i, f := range files {
+		repos[i] = f.Repository
+	}
+	if len(repos) > 0 {
+		c.invalidate(ctx, repos...)
+	}
+	return err
+}
+
+func (c *Cached) UpdateSummaries(ctx context.Context, updates []SummaryUpdate) error {
+	err := c.Backend.UpdateSummaries(ctx, updates)
+	c.invalidate(ctx)
+	return err
+}
+
+func (c *Cached) UpdateVectors(ctx context.Context, updates []VectorUpdate) error {
+	err := c.Backend.UpdateVectors(ctx, updates)
+	c.invalidate(ctx)
+	return err
+}
+
+func (c *Cached) DeleteRepository(ctx context.Context, repository string) (int64, error) {
+	n, err := c.Backend.DeleteRepository(ctx, repository)
+	c.invalidate(ctx, repository)
+	return n, err
+}
+
+func (c *Cached) DeleteRef(ctx context.Context, repository, ref string) (int64, error) {
+	n, err := c.Backend.DeleteRef(ctx, repository, ref)
+	c.invalidate(ctx, repository)
+	return n, err
+}
+
+func (c *Cached) DeletePaths(ctx context.Context, repository, ref string, paths []string) (int64, error) {
+	n, err := c.Backend.DeletePaths(ctx, repository, ref, paths)
+	c.invalidate(ctx, repository)
+	return n, err
+}
+
+func (c *Cached) CommitVectorMigration(ctx context.Context, model string) error {
+	err := c.Backend.CommitVectorMigration(ctx, model)
+	c.invalidate(ctx)
+	return err
+}
+
+func (c *Cached) RestoreRepository(ctx context.Context, repository string) (int64, error) {
+	n, err := c.Backend.RestoreRepository(ctx, repository)
+	c.invalidate(ctx, repository)
+	return n, err
+}
+
+func (c *Cached) RestoreRef(ctx context.Context, repository, ref string) (int64, error) {
+	n, err := c.Backend.RestoreRef(ctx, repository, ref)
+	c.invalidate(ctx, repository)
+	return n, err
+}
+
+// GrantRepository invalidates the repository, as grants change which of its
+// results a search may return.
+func (c *Cached) GrantRepository(ctx context.Context, g models.RepositoryGrant) error {
+	err := c.Backend.GrantRepository(ctx, g)
+	c.invalidate(ctx, g.Repository)
+	return err
+}
+
+func (c *Cached) ReplaceRepositoryGrants(ctx context.Context, grantedBy string, grants []models.RepositoryGrant) error {
+	err := c.Backend.ReplaceRepositoryGrants(ctx, grantedBy, grants)
+	c.invalidate(ctx)
+	return err
+}
+
+func (c *Cached) RevokeRepositoryGrant(ctx context.Context, repository, principal string) (bool, error) {
+	ok, err := c.Backend.RevokeRepositoryGrant(ctx, repository, principal)
+	c.invalidate(ctx, repository)
+	return ok, err
+}
+
+// Close closes the store and the cache connections.
+func (c *Cached) Close() {
+	c.Backend.Close()
+	c.redis.Close()
+}
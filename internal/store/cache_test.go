@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+func TestCached(t *testing.T) {
+	ctx := context.Background()
+	r, err := NewRedis("redis://" + miniredis.RunT(t).Addr())
+	if err != nil {
+		t.Fatalf("NewRedis: %v", err)
+	}
+	c := NewCached(newTestSQLite(t), r, time.Minute)
+	c.Logf = t.Logf
+
+	chunk := func(id, repo, summary string) ChunkWithVec {
+		return ChunkWithVec{Chunk: models.Chunk{ID: id, Repository: repo, Path: id + ".go", Summary: summary, LineStart: 1, LineEnd: 2}, SummaryVec: []float32{1, 0, 0}, ContentHash: id}
+	}
+	if err := c.UpsertChunks(ctx, []ChunkWithVec{chunk("a", "repo", "first"), chunk("b", "other", "second")}); err != nil {
+		t.Fatalf("UpsertChunks: %v", err)
+	}
+	search := func(opt QueryOpts) int {
+		t.Helper()
+		page, err := c.SearchPage(ctx, []float32{1, 0, 0}, 10, opt)
+		if err != nil {
+			t.Fatalf("SearchPage: %v", err)
+		}
+		return page.Total
+	}
+	all, repo := QueryOpts{QueryText: "code"}, QueryOpts{QueryText: "code", Repositories: []string{"repo"}}
+	if n := search(all); n != 2 {
+		t.Fatalf("expected 2 results, got %d", n)
+	}
+	if n := search(repo); n != 1 {
+		t.Fatalf("expected 1 result, got %d", n)
+	}
+
+	// Writing behind the cache's back serves stale results...
+	if err := c.Backend.UpsertChunks(ctx, []ChunkWithVec{chunk("c", "repo", "third")}); err != nil {
+		t.Fatalf("UpsertChunks: %v", err)
+	}
+	if n := search(all); n != 2 {
+		t.Fatalf("expected the cached total 2, got %d", n)
+	}
+
+	// ...while writing through it invalidates the repositories it touched.
+	if err := c.UpsertChunks(ctx, []ChunkWithVec{chunk("d", "other", "fourth")}); err != nil {
+		t.Fatalf("UpsertChunks: %v", err)
+	}
+	if n := search(all); n != 4 {
+		t.Errorf("expected 4 results after invalidation, got %d", n)
+	}
+	if n := search(repo); n != 1 {
+		t.Errorf("expected the untouched repository to stay cached, got %d", n)
+	}
+	if _, err := c.DeleteRepository(ctx, "repo"); err != nil {
+		t.Fatalf("DeleteRepository: %v", err)
+	}
+	if n := search(repo); n != 0 {
+		t.Errorf("expected no results after deleting the repository, got %d", n)
+	}
+}
+
+func TestCached_RedisDown(t *testing.T) {
+	r, _ := NewRedis("redis://127.0.0.1:1")
+	c := NewCached(newTestSQLite(t), r, 0)
+	ctx := context.Background()
+	if err := c.UpsertChunk(ctx, models.Chunk{ID: "a", Repository: "repo", Path: "a.go", Summary: "first", LineStart: 1, LineEnd: 2}, []float32{1, 0, 0}, "a"); err != nil {
+		t.Fatalf("UpsertChunk: %v", err)
+	}
+	res, err := c.Search(ctx, []float32{1, 0, 0}, 5, QueryOpts{QueryText: "code"})
+	if err != nil || len(res) != 1 {
+		t.Errorf("expected the search to fall through to the store, got %v, %v", res, err)
+	}
+}
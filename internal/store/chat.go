@@ -0,0 +1,138 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// chatSchema is shared by Postgres and SQLite. Turns are numbered per
+// session by the application so that concurrent writes to one session
+// conflict instead of interleaving.
+const chatSchema = `
+CREATE TABLE IF NOT EXISTS chat_turns (
+  session_id TEXT NOT NULL,
+  seq        INT NOT NULL,
+  role       TEXT NOT NULL,
+  content    TEXT NOT NULL,
+  query      TEXT NOT NULL DEFAULT '',
+  sources    TEXT NOT NULL DEFAULT '',
+  user_login TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (session_id, seq)
+);
+`
+
+// chatTurnArgs returns the column values of turn, numbered seq.
+func chatTurnArgs(sessionID, user string, seq int, t models.ChatTurn) ([]any, error) {
+	var sources string
+	if len(t.Sources) > 0 {
+		b, err := json.Marshal(t.Sources)
+		if err != nil {
+			return nil, err
+		}
+		sources = string(b)
+	}
+	at := t.CreatedAt
+	if at.IsZero() {
+		at = time.Now()
+	}
+	return []any{sessionID, seq, t.Role, t.Content, t.Query, sources, user, at.UTC()}, nil
+}
+
+// AppendChatTurns adds turns to a session after the first seq turns. It
+// fails when another request has already written turns at those positions.
+func (s *Store) AppendChatTurns(ctx context.Context, sessionID, user string, seq int, turns []models.ChatTurn) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	for i, t := range turns {
+		args, err := chatTurnArgs(sessionID, user, seq+i, t)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `
+      INSERT INTO chat_turns (session_id, seq, role, content, query, sources, user_login, created_at)
+      VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`, args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// ChatHistory returns the turns of a session in order, along with the login
+// of the user who started it.
+func (s *Store) ChatHistory(ctx context.Context, sessionID string) ([]models.ChatTurn, string, error) {
+	rows, err := s.read.Query(ctx, `
+      SELECT role, content, query, sources, user_login, created_at
+      FROM chat_turns WHERE session_id = $1 ORDER BY seq`, sessionID)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	return scanChatTurns(rows)
+}
+
+// AppendChatTurns adds turns to a session after the first seq turns. It
+// fails when another request has already written turns at those positions.
+func (s *SQLiteStore) AppendChatTurns(ctx context.Context, sessionID, user string, seq int, turns []models.ChatTurn) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	for i, t := range turns {
+		args, err := chatTurnArgs(sessionID, user, seq+i, t)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+      INSERT INTO chat_turns (session_id, seq, role, content, query, sources, user_login, created_at)
+      VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ChatHistory returns the turns of a session in order, along with the login
+// of the user who started it.
+func (s *SQLiteStore) ChatHistory(ctx context.Context, sessionID string) ([]models.ChatTurn, string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+      SELECT role, content, query, sources, user_login, created_at
+      FROM chat_turns WHERE session_id = ? ORDER BY seq`, sessionID)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { _ = rows.Close() }()
+	return scanChatTurns(rows)
+}
+
+func scanChatTurns(rows rowScanner) ([]models.ChatTurn, string, error) {
+	var turns []models.ChatTurn
+	var owner string
+	for rows.Next() {
+		var t models.ChatTurn
+		var sources, user string
+		var at sql.NullTime
+		if err := rows.Scan(&t.Role, &t.Content, &t.Query, &sources, &user, &at); err != nil {
+			return nil, "", err
+		}
+		if sources != "" {
+			if err := json.Unmarshal([]byte(sources), &t.Sources); err != nil {
+				return nil, "", err
+			}
+		}
+		if len(turns) == 0 {
+			owner = user
+		}
+		t.CreatedAt = at.Time
+		turns = append(turns, t)
+	}
+	return turns, owner, rows.Err()
+}
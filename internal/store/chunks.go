@@ -0,0 +1,178 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+const chunkColumns = `id, repository, ref, path, language, COALESCE(summary, ''), content, line_start, line_end, created_at`
+
+// GetChunkByID returns a single chunk.
+func (s *Store) GetChunkByID(ctx context.Context, id string) (models.Chunk, bool, error) {
+	q := `SELECT ` + chunkColumns + ` FROM chunks WHERE id = $1 AND deleted_at IS NULL`
+	var c models.Chunk
+	err := s.read.QueryRow(ctx, q, id).Scan(
+		&c.ID, &c.Repository, &c.Ref, &c.Path, &c.Language, &c.Summary, &c.Content, &c.LineStart, &c.LineEnd, &c.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.Chunk{}, false, nil
+		}
+		return models.Chunk{}, false, err
+	}
+	return c, true, nil
+}
+
+// GetFileChunks returns every chunk of a file ordered by line range.
+func (s *Store) GetFileChunks(ctx context.Context, repository, ref, path string) ([]models.Chunk, error) {
+	q := `SELECT ` + chunkColumns + ` FROM chunks
+      WHERE repository = $1 AND ref = $2 AND path = $3 AND deleted_at IS NULL
+      ORDER BY line_start, line_end`
+	rows, err := s.read.Query(ctx, q, repository, ref, path)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []models.Chunk
+	for rows.Next() {
+		var c models.Chunk
+		if err := rows.Scan(
+			&c.ID, &c.Repository, &c.Ref, &c.Path, &c.Language, &c.Summary, &c.Content, &c.LineStart, &c.LineEnd, &c.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// GetChunkByID returns a single chunk.
+func (s *SQLiteStore) GetChunkByID(ctx context.Context, id string) (models.Chunk, bool, error) {
+	q := `SELECT ` + chunkColumns + ` FROM chunks WHERE id = ? AND deleted_at IS NULL`
+	var c models.Chunk
+	var created sql.NullTime
+	err := s.db.QueryRowContext(ctx, q, id).Scan(
+		&c.ID, &c.Repository, &c.Ref, &c.Path, &c.Language, &c.Summary, &c.Content, &c.LineStart, &c.LineEnd, &created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Chunk{}, false, nil
+		}
+		return models.Chunk{}, false, err
+	}
+	c.CreatedAt = created.Time
+	return c, true, nil
+}
+
+// GetFileChunks returns every chunk of a file ordered by line range.
+func (s *SQLiteStore) GetFileChunks(ctx context.Context, repository, ref, path string) ([]models.Chunk, error) {
+	q := `SELECT ` + chunkColumns + ` FROM chunks
+      WHERE repository = ? AND ref = ? AND path = ? AND deleted_at IS NULL
+      ORDER BY line_start, line_end`
+	rows, err := s.db.QueryContext(ctx, q, repository, ref, path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var out []models.Chunk
+	for rows.Next() {
+		var c models.Chunk
+		var created sql.NullTime
+		if err := rows.Scan(
+			&c.ID, &c.Repository, &c.Ref, &c.Path, &c.Language, &c.Summary, &c.Content, &c.LineStart, &c.LineEnd, &created,
+		); err != nil {
+			return nil, err
+		}
+		c.CreatedAt = created.Time
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// neighborsSQL selects up to n chunks of the same file on each side of a
+// line range, nearest first, tagging each with its side (0 before, 1 after).
+// The subqueries let SQLite apply ORDER BY and LIMIT to each half.
+const neighborsSQL = `
+      SELECT * FROM (
+        SELECT ` + chunkColumns + `, 0 FROM chunks
+        WHERE repository = %[1]s AND ref = %[2]s AND path = %[3]s AND deleted_at IS NULL AND line_end < %[4]s
+        ORDER BY line_start DESC LIMIT %[6]s
+      ) b
+      UNION ALL
+      SELECT * FROM (
+        SELECT ` + chunkColumns + `, 1 FROM chunks
+        WHERE repository = %[1]s AND ref = %[2]s AND path = %[3]s AND deleted_at IS NULL AND line_start > %[5]s
+        ORDER BY line_start LIMIT %[6]s
+      ) a`
+
+// GetNeighbors returns up to n chunks before and after c in the same file,
+// each in line order.
+func (s *Store) GetNeighbors(ctx context.Context, c models.Chunk, n int) (before, after []models.Chunk, err error) {
+	if n <= 0 {
+		return nil, nil, nil
+	}
+	q := fmt.Sprintf(neighborsSQL, "$1", "$2", "$3", "$4", "$5", "$6")
+	rows, err := s.read.Query(ctx, q, c.Repository, c.Ref, c.Path, c.LineStart, c.LineEnd, n)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var nc models.Chunk
+		var side int
+		if err := rows.Scan(
+			&nc.ID, &nc.Repository, &nc.Ref, &nc.Path, &nc.Language, &nc.Summary, &nc.Content, &nc.LineStart, &nc.LineEnd, &nc.CreatedAt, &side,
+		); err != nil {
+			return nil, nil, err
+		}
+		if side == 0 {
+			before = append(before, nc)
+		} else {
+			after = append(after, nc)
+		}
+	}
+	slices.Reverse(before)
+	return before, after, rows.Err()
+}
+
+// GetNeighbors returns up to n chunks before and after c in the same file,
+// each in line order.
+func (s *SQLiteStore) GetNeighbors(ctx context.Context, c models.Chunk, n int) (before, after []models.Chunk, err error) {
+	if n <= 0 {
+		return nil, nil, nil
+	}
+	q := fmt.Sprintf(neighborsSQL, "?", "?", "?", "?", "?", "?")
+	rows, err := s.db.QueryContext(ctx, q,
+		c.Repository, c.Ref, c.Path, c.LineStart, n,
+		c.Repository, c.Ref, c.Path, c.LineEnd, n)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var nc models.Chunk
+		var created sql.NullTime
+		var side int
+		if err := rows.Scan(
+			&nc.ID, &nc.Repository, &nc.Ref, &nc.Path, &nc.Language, &nc.Summary, &nc.Content, &nc.LineStart, &nc.LineEnd, &created, &side,
+		); err != nil {
+			return nil, nil, err
+		}
+		nc.CreatedAt = created.Time
+		if side == 0 {
+			before = append(before, nc)
+		} else {
+			after = append(after, nc)
+		}
+	}
+	slices.Reverse(before)
+	return before, after, rows.Err()
+}
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrDimensionMismatch is returned by Migrate when stored vectors have a
+// different dimension than the embedding model produces.
+var ErrDimensionMismatch = errors.New("embedding dimension mismatch")
+
+// dimensionMismatch describes a mismatch and how to resolve it.
+func dimensionMismatch(where string, have, want int) error {
+	return fmt.Errorf("%w: %s holds %d-dimensional vectors but the embedding model produces %d; "+
+		"either switch back to a model with %d dimensions (or set the embedding dimension to %d if the model supports it), "+
+		"or run `reposearch reembed` with the new model to migrate the stored vectors",
+		ErrDimensionMismatch, where, have, want, have, have)
+}
+
+// checkDimension compares the dimension of the existing vector columns, if
+// any, with dim.
+func (s *Store) checkDimension(ctx context.Context, dim int) error {
+	for _, table := range []string{"chunks", "rollups"} {
+		have, err := s.vectorColumnDim(ctx, table, "summary_vec")
+		if err != nil {
+			return err
+		}
+		if have > 0 && have != dim {
+			return dimensionMismatch(table+".summary_vec", have, dim)
+		}
+	}
+	return nil
+}
+
+// vectorColumnDim returns the dimension of a vector column, or 0 when the
+// column does not exist or was declared without one.
+func (s *Store) vectorColumnDim(ctx context.Context, table, column string) (int, error) {
+	// pgvector stores the dimension as the column's type modifier; -1 means
+	// the column was declared without one.
+	var have int
+	err := s.pool.QueryRow(ctx, `
+      SELECT atttypmod FROM pg_attribute
+      WHERE attrelid = to_regclass($1) AND attname = $2 AND NOT attisdropped`, table, column).Scan(&have)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	return max(have, 0), err
+}
+
+// checkDimension compares the size of a stored vector, if any, with dim.
+func (s *SQLiteStore) checkDimension(ctx context.Context, dim int) error {
+	var n int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT length(summary_vec) FROM chunks WHERE summary_vec IS NOT NULL AND length(summary_vec) > 0 LIMIT 1`).Scan(&n)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if have := n / 4; have != dim {
+		return dimensionMismatch("chunks.summary_vec", have, dim)
+	}
+	return nil
+}
@@ -0,0 +1,340 @@
+package store
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// encryptedPrefix marks a value encrypted by a Cipher. Values without it are
+// read as plaintext, so a database indexed before encryption was enabled
+// stays readable until it is reindexed.
+const encryptedPrefix = "enc:v1:"
+
+// Cipher encrypts column values with AES-256-GCM.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher creates a Cipher from a base64-encoded 32-byte key, e.g. the
+// output of `openssl rand -base64 32`.
+func NewCipher(key string) (*Cipher, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key is not valid base64: %w", err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// Encrypt returns the sealed form of s. The empty string stays empty so that
+// a missing summary or content is still recognisable.
+func (c *Cipher) Encrypt(s string) string {
+	if s == "" {
+		return ""
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(c.aead.Seal(nonce, nonce, []byte(s), nil))
+}
+
+// Decrypt opens a value sealed by Encrypt and returns any other value as is.
+func (c *Cipher) Decrypt(s string) (string, error) {
+	enc, ok := strings.CutPrefix(s, encryptedPrefix)
+	if !ok {
+		return s, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil || len(raw) < c.aead.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+	n := c.aead.NonceSize()
+	plain, err := c.aead.Open(nil, raw[:n], raw[n:], nil)
+	if err != nil {
+		return "", fmt.Errorf("decrypting value (wrong encryption key?): %w", err)
+	}
+	return string(plain), nil
+}
+
+// Encrypted is a Backend that encrypts chunk content and summaries, and
+// rollup summaries, before they are written and decrypts them when read, so
+// the plaintext never reaches the database. Paths, vectors and content
+// hashes are stored as is. Full-text and trigram matching only see
+// ciphertext, so lexical ranking falls back to paths.
+type Encrypted struct {
+	Backend
+	c *Cipher
+}
+
+var (
+	_ Backend    = (*Encrypted)(nil)
+	_ FileWriter = (*Encrypted)(nil)
+)
+
+// NewEncrypted encrypts the text columns written to b with c.
+func NewEncrypted(b Backend, c *Cipher) *Encrypted {
+	return &Encrypted{Backend: b, c: c}
+}
+
+func (e *Encrypted) sealChunk(ch models.Chunk) models.Chunk {
+	ch.Summary, ch.Content = e.c.Encrypt(ch.Summary), e.c.Encrypt(ch.Content)
+	return ch
+}
+
+func (e *Encrypted) sealChunks(chunks []ChunkWithVec) []ChunkWithVec {
+	out := make([]ChunkWithVec, len(chunks))
+	for i, c := range chunks {
+		c.Chunk = e.sealChunk(c.Chunk)
+		out[i] = c
+	}
+	return out
+}
+
+func (e *Encrypted) openChunk(ch *models.Chunk) error {
+	var err error
+	if ch.Summary, err = e.c.Decrypt(ch.Summary); err != nil {
+		return err
+	}
+	ch.Content, err = e.c.Decrypt(ch.Content)
+	return err
+}
+
+func (e *Encrypted) openChunks(chunks []models.Chunk) error {
+	for i := range chunks {
+		if err := e.openChunk(&chunks[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openResults decrypts results and rebuilds their snippets, which the store
+// computed from ciphertext.
+func (e *Encrypted) openResults(res []models.SearchResult, query string) error {
+	for i := range res {
+		if err := e.openChunk(&res[i].Chunk); err != nil {
+			return err
+		}
+	}
+	withSnippets(res, query)
+	return nil
+}
+
+func (e *Encrypted) UpsertChunk(ctx context.Context, c models.Chunk, summaryVec []float32, contentHash string) error {
+	return e.Backend.UpsertChunk(ctx, e.sealChunk(c), summaryVec, contentHash)
+}
+
+func (e *Encrypted) UpsertChunks(ctx context.Context, chunks []ChunkWithVec) error {
+	return e.Backend.UpsertChunks(ctx, e.sealChunks(chunks))
+}
+
+// WriteFiles implements FileWriter when the wrapped store does.
+func (e *Encrypted) WriteFiles(ctx context.Context, files []FileChunks) error {
+	fw, ok := e.Backend.(FileWriter)
+	if !ok {
+		return errors.New("store does not support writing files")
+	}
+	sealed := make([]FileChunks, len(files))
+	for i, f := range files {
+		f.Chunks = e.sealChunks(f.Chunks)
+		sealed[i] = f
+	}
+	return fw.WriteFiles(ctx, sealed)
+}
+
+func (e *Encrypted) UpdateSummaries(ctx context.Context, updates []SummaryUpdate) error {
+	sealed := make([]SummaryUpdate, len(updates))
+	for i, u := range updates {
+		u.Summary = e.c.Encrypt(u.Summary)
+		sealed[i] = u
+	}
+	return e.Backend.UpdateSummaries(ctx, sealed)
+}
+
+func (e *Encrypted) UpsertRollup(ctx context.Context, r models.Rollup, summaryVec []float32, inputHash string) error {
+	r.Summary = e.c.Encrypt(r.Summary)
+	return e.Backend.UpsertRollup(ctx, r, summaryVec, inputHash)
+}
+
+func (e *Encrypted) Search(ctx context.Context, summaryVec []float32, k int, opt QueryOpts) ([]models.SearchResult, error) {
+	res, err := e.Backend.Search(ctx, summaryVec, k, opt)
+	if err != nil {
+		return nil, err
+	}
+	return res, e.openResults(res, opt.QueryText)
+}
+
+func (e *Encrypted) SearchPage(ctx context.Context, summaryVec []float32, k int, opt QueryOpts) (models.SearchPage, error) {
+	page, err := e.Backend.SearchPage(ctx, summaryVec, k, opt)
+	if err != nil {
+		return page, err
+	}
+	return page, e.openResults(page.Results, opt.QueryText)
+}
+
+func (e *Encrypted) SimilarChunks(ctx context.Context, id string, k int, opt QueryOpts) ([]models.SearchResult, bool, error) {
+	res, ok, err := e.Backend.SimilarChunks(ctx, id, k, opt)
+	if err != nil || !ok {
+		return res, ok, err
+	}
+	return res, ok, e.openResults(res, "")
+}
+
+func (e *Encrypted) SearchRollups(ctx context.Context, summaryVec []float32, k int, kind string, opt QueryOpts) ([]models.RollupResult, error) {
+	res, err := e.Backend.SearchRollups(ctx, summaryVec, k, kind, opt)
+	if err != nil {
+		return nil, err
+	}
+	for i := range res {
+		if res[i].Rollup.Summary, err = e.c.Decrypt(res[i].Rollup.Summary); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func (e *Encrypted) GetRollupMeta(ctx context.Context, repository, ref, kind, path string) (RollupMeta, bool, error) {
+	m, ok, err := e.Backend.GetRollupMeta(ctx, repository, ref, kind, path)
+	if err != nil || !ok {
+		return m, ok, err
+	}
+	m.Summary, err = e.c.Decrypt(m.Summary)
+	return m, ok, err
+}
+
+func (e *Encrypted) GetChunkByID(ctx context.Context, id string) (models.Chunk, bool, error) {
+	c, ok, err := e.Backend.GetChunkByID(ctx, id)
+	if err != nil || !ok {
+		return c, ok, err
+	}
+	return c, ok, e.openChunk(&c)
+}
+
+func (e *Encrypted) GetFileChunks(ctx context.Context, repository, ref, path string) ([]models.Chunk, error) {
+	chunks, err := e.Backend.GetFileChunks(ctx, repository, ref, path)
+	if err != nil {
+		return nil, err
+	}
+	return chunks, e.openChunks(chunks)
+}
+
+func (e *Encrypted) GetNeighbors(ctx context.Context, c models.Chunk, n int) (before, after []models.Chunk, err error) {
+	if before, after, err = e.Backend.GetNeighbors(ctx, c, n); err != nil {
+		return nil, nil, err
+	}
+	if err := e.openChunks(before); err != nil {
+		return nil, nil, err
+	}
+	return before, after, e.openChunks(after)
+}
+
+func (e *Encrypted) GetChunkMeta(ctx context.Context, repository, path string, ls, le int) (ChunkMeta, bool, error) {
+	m, ok, err := e.Backend.GetChunkMeta(ctx, repository, path, ls, le)
+	if err != nil || !ok {
+		return m, ok, err
+	}
+	m.Summary, err = e.c.Decrypt(m.Summary)
+	return m, ok, err
+}
+
+func (e *Encrypted) FindByContentHash(ctx context.Context, contentHash, summaryModel, embedModel string) (HashMatch, bool, error) {
+	m, ok, err := e.Backend.FindByContentHash(ctx, contentHash, summaryModel, embedModel)
+	if err != nil || !ok {
+		return m, ok, err
+	}
+	m.Summary, err = e.c.Decrypt(m.Summary)
+	return m, ok, err
+}
+
+// ExportChunks exports plaintext, so the export can be imported into an
+// instance with another key or none.
+func (e *Encrypted) ExportChunks(ctx context.Context, repository string, fn func(ChunkWithVec) error) error {
+	return e.Backend.ExportChunks(ctx, repository, func(c ChunkWithVec) error {
+		if err := e.openChunk(&c.Chunk); err != nil {
+			return err
+		}
+		return fn(c)
+	})
+}
+
+func (e *Encrypted) ListStaleSummaries(ctx context.Context, model, afterID string, limit int) ([]StaleChunk, error) {
+	return e.openStale(e.Backend.ListStaleSummaries(ctx, model, afterID, limit))
+}
+
+func (e *Encrypted) ListStaleVectors(ctx context.Context, model, afterID string, limit int) ([]StaleChunk, error) {
+	return e.openStale(e.Backend.ListStaleVectors(ctx, model, afterID, limit))
+}
+
+func (e *Encrypted) ListPendingVectors(ctx context.Context, after PendingVector, limit int) ([]PendingVector, error) {
+	vecs, err := e.Backend.ListPendingVectors(ctx, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range vecs {
+		if vecs[i].Summary, err = e.c.Decrypt(vecs[i].Summary); err != nil {
+			return nil, err
+		}
+	}
+	return vecs, nil
+}
+
+func (e *Encrypted) openStale(chunks []StaleChunk, err error) ([]StaleChunk, error) {
+	if err != nil {
+		return nil, err
+	}
+	for i := range chunks {
+		if chunks[i].Summary, err = e.c.Decrypt(chunks[i].Summary); err != nil {
+			return nil, err
+		}
+		if chunks[i].Content, err = e.c.Decrypt(chunks[i].Content); err != nil {
+			return nil, err
+		}
+	}
+	return chunks, nil
+}
+
+// AppendChatTurns encrypts the messages of a conversation, which quote the
+// indexed code.
+func (e *Encrypted) AppendChatTurns(ctx context.Context, sessionID, user string, seq int, turns []models.ChatTurn) error {
+	sealed := make([]models.ChatTurn, len(turns))
+	for i, t := range turns {
+		t.Content, t.Query = e.c.Encrypt(t.Content), e.c.Encrypt(t.Query)
+		sealed[i] = t
+	}
+	return e.Backend.AppendChatTurns(ctx, sessionID, user, seq, sealed)
+}
+
+func (e *Encrypted) ChatHistory(ctx context.Context, sessionID string) ([]models.ChatTurn, string, error) {
+	turns, owner, err := e.Backend.ChatHistory(ctx, sessionID)
+	if err != nil {
+		return nil, "", err
+	}
+	for i := range turns {
+		if turns[i].Content, err = e.c.Decrypt(turns[i].Content); err != nil {
+			return nil, "", err
+		}
+		if turns[i].Query, err = e.c.Decrypt(turns[i].Query); err != nil {
+			return nil, "", err
+		}
+	}
+	return turns, owner, nil
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), 32)))
+}
+
+func TestCipher(t *testing.T) {
+	c, err := NewCipher(testKey('a'))
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	enc := c.Encrypt("func main() {}")
+	if !strings.HasPrefix(enc, encryptedPrefix) || strings.Contains(enc, "main") {
+		t.Errorf("unexpected ciphertext %q", enc)
+	}
+	if enc == c.Encrypt("func main() {}") {
+		t.Error("expected a fresh nonce per value")
+	}
+	if got, err := c.Decrypt(enc); err != nil || got != "func main() {}" {
+		t.Errorf("Decrypt = %q, %v", got, err)
+	}
+	if got, err := c.Decrypt("plain text"); err != nil || got != "plain text" {
+		t.Errorf("expected plaintext to pass through, got %q, %v", got, err)
+	}
+	if c.Encrypt("") != "" {
+		t.Error("expected the empty string to stay empty")
+	}
+
+	other, _ := NewCipher(testKey('b'))
+	if _, err := other.Decrypt(enc); err == nil {
+		t.Error("expected decrypting with the wrong key to fail")
+	}
+	for _, k := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := NewCipher(k); err == nil {
+			t.Errorf("expected an error for key %q", k)
+		}
+	}
+}
+
+func TestEncrypted(t *testing.T) {
+	ctx := context.Background()
+	raw := newTestSQLite(t)
+	c, _ := NewCipher(testKey('k'))
+	s := NewEncrypted(raw, c)
+
+	chunk := models.Chunk{ID: "1", Repository: "repo", Path: "db/migrate.go", Summary: "runs database migrations",
+		Content: "package db\n\n// Migrate applies the schema\nfunc Migrate() {}", LineStart: 1, LineEnd: 4}
+	if err := s.WriteFiles(ctx, []FileChunks{{Repository: "repo", Path: "db/migrate.go", Chunks: []ChunkWithVec{{Chunk: chunk, SummaryVec: []float32{1, 0, 0}, ContentHash: "h"}}}}); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+
+	var summary, content string
+	if err := raw.db.QueryRowContext(ctx, `SELECT summary, content FROM chunks`).Scan(&summary, &content); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if strings.Contains(summary, "database") || strings.Contains(content, "Migrate") {
+		t.Errorf("plaintext stored in the database: %q %q", summary, content)
+	}
+
+	got, ok, err := s.GetChunkByID(ctx, "1")
+	if err != nil || !ok || got.Summary != chunk.Summary || got.Content != chunk.Content {
+		t.Errorf("GetChunkByID = %+v, %v, %v", got, ok, err)
+	}
+	meta, ok, err := s.GetChunkMeta(ctx, "repo", "db/migrate.go", 1, 4)
+	if err != nil || !ok || meta.Summary != chunk.Summary {
+		t.Errorf("GetChunkMeta = %+v, %v, %v", meta, ok, err)
+	}
+
+	res, err := s.Search(ctx, []float32{1, 0, 0}, 5, QueryOpts{QueryText: "migrate schema"})
+	if err != nil || len(res) != 1 {
+		t.Fatalf("Search = %v, %v", res, err)
+	}
+	if res[0].Chunk.Content != chunk.Content || !strings.Contains(res[0].Snippet, "Migrate applies the schema") {
+		t.Errorf("expected decrypted content and snippet, got %+v", res[0])
+	}
+
+	if err := s.UpsertRollup(ctx, models.Rollup{Repository: "repo", Kind: RollupFile, Path: "db/migrate.go", Summary: "database migrations"}, []float32{1, 0, 0}, "in"); err != nil {
+		t.Fatalf("UpsertRollup: %v", err)
+	}
+	rm, ok, err := s.GetRollupMeta(ctx, "repo", "", RollupFile, "db/migrate.go")
+	if err != nil || !ok || rm.Summary != "database migrations" {
+		t.Errorf("GetRollupMeta = %+v, %v, %v", rm, ok, err)
+	}
+	if m, _, _ := raw.GetRollupMeta(ctx, "repo", "", RollupFile, "db/migrate.go"); !strings.HasPrefix(m.Summary, encryptedPrefix) {
+		t.Errorf("expected an encrypted rollup summary, got %q", m.Summary)
+	}
+}
@@ -0,0 +1,204 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	pgvector "github.com/pgvector/pgvector-go"
+)
+
+// exportColumns are the columns of a chunk written to an export, in the
+// order scanned by ExportChunks.
+const exportColumns = chunkColumns + `, summary_vec, COALESCE(content_hash, ''), COALESCE(summary_model, ''), COALESCE(embed_model, '')`
+
+// ExportChunks calls fn with every chunk of repository, or of every
+// repository when it is empty, along with its vector, content hash, models
+// and symbols, so the index can be loaded into another instance with
+// UpsertChunks without calling the AI provider again. Deleted chunks are
+// skipped.
+func (s *Store) ExportChunks(ctx context.Context, repository string, fn func(ChunkWithVec) error) error {
+	symbols, err := s.exportSymbols(ctx, repository)
+	if err != nil {
+		return err
+	}
+	rows, err := s.read.Query(ctx, `
+      SELECT `+exportColumns+`
+      FROM chunks
+      WHERE deleted_at IS NULL AND ($1 = '' OR repository = $1)
+      ORDER BY repository, ref, path, line_start, line_end`, repository)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c ChunkWithVec
+		var v *pgvector.Vector
+		m := &c.Chunk
+		if err := rows.Scan(&m.ID, &m.Repository, &m.Ref, &m.Path, &m.Language, &m.Summary, &m.Content, &m.LineStart, &m.LineEnd, &m.CreatedAt,
+			&v, &c.ContentHash, &m.SummaryModel, &m.EmbedModel); err != nil {
+			return err
+		}
+		if v != nil {
+			c.SummaryVec = v.Slice()
+		} else if s.vectors != nil && m.EmbedModel != "" {
+			if c.SummaryVec, _, err = s.vectors.Get(ctx, m.ID); err != nil {
+				return err
+			}
+		}
+		c.Symbols = symbols[m.ID]
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *Store) exportSymbols(ctx context.Context, repository string) (map[string][]Symbol, error) {
+	rows, err := s.read.Query(ctx, `
+      SELECT chunk_id, name, kind, line FROM symbols
+      WHERE $1 = '' OR repository = $1
+      ORDER BY chunk_id, line`, repository)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string][]Symbol{}
+	for rows.Next() {
+		var id string
+		var sym Symbol
+		if err := rows.Scan(&id, &sym.Name, &sym.Kind, &sym.Line); err != nil {
+			return nil, err
+		}
+		out[id] = append(out[id], sym)
+	}
+	return out, rows.Err()
+}
+
+// ExportChunks calls fn with every chunk of repository, or of every
+// repository when it is empty, along with its vector, content hash, models
+// and symbols. Deleted chunks are skipped.
+func (s *SQLiteStore) ExportChunks(ctx context.Context, repository string, fn func(ChunkWithVec) error) error {
+	symbols, err := s.exportSymbols(ctx, repository)
+	if err != nil {
+		return err
+	}
+	rows, err := s.db.QueryContext(ctx, `
+      SELECT `+exportColumns+`
+      FROM chunks
+      WHERE deleted_at IS NULL AND (? = '' OR repository = ?)
+      ORDER BY repository, ref, path, line_start, line_end`, repository, repository)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var c ChunkWithVec
+		var created sql.NullTime
+		var vec []byte
+		m := &c.Chunk
+		if err := rows.Scan(&m.ID, &m.Repository, &m.Ref, &m.Path, &m.Language, &m.Summary, &m.Content, &m.LineStart, &m.LineEnd, &created,
+			&vec, &c.ContentHash, &m.SummaryModel, &m.EmbedModel); err != nil {
+			return err
+		}
+		m.CreatedAt = created.Time
+		c.SummaryVec = decodeVector(vec)
+		c.Symbols = symbols[m.ID]
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *SQLiteStore) exportSymbols(ctx context.Context, repository string) (map[string][]Symbol, error) {
+	rows, err := s.db.QueryContext(ctx, `
+      SELECT chunk_id, name, kind, line FROM symbols
+      WHERE ? = '' OR repository = ?
+      ORDER BY chunk_id, line`, repository, repository)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	out := map[string][]Symbol{}
+	for rows.Next() {
+		var id string
+		var sym Symbol
+		if err := rows.Scan(&id, &sym.Name, &sym.Kind, &sym.Line); err != nil {
+			return nil, err
+		}
+		out[id] = append(out[id], sym)
+	}
+	return out, rows.Err()
+}
+
+// importBatch is the number of chunks written per round trip by ImportJSONL.
+const importBatch = 500
+
+// ExportJSONL writes the chunks of repository, or of every repository when it
+// is empty, to w as JSON lines and returns the number written.
+func ExportJSONL(ctx context.Context, b Backend, repository string, w io.Writer) (int, error) {
+	enc := json.NewEncoder(w)
+	n := 0
+	err := b.ExportChunks(ctx, repository, func(c ChunkWithVec) error {
+		n++
+		return enc.Encode(c)
+	})
+	return n, err
+}
+
+// ImportJSONL upserts the chunks written by ExportJSONL and returns the
+// number imported. The schema is migrated for the dimension of the exported
+// vectors, which fails with ErrDimensionMismatch when the store was created
+// for another embedding model.
+func ImportJSONL(ctx context.Context, b Backend, r io.Reader) (int, error) {
+	dec := json.NewDecoder(r)
+	var batch []ChunkWithVec
+	n, migrated := 0, false
+	flush := func() error {
+		if !migrated {
+			for _, c := range batch {
+				if len(c.SummaryVec) > 0 {
+					if err := b.Migrate(ctx, len(c.SummaryVec)); err != nil {
+						return err
+					}
+					migrated = true
+					break
+				}
+			}
+		}
+		if err := b.UpsertChunks(ctx, batch); err != nil {
+			return err
+		}
+		n += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	for {
+		var c ChunkWithVec
+		err := dec.Decode(&c)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return n, fmt.Errorf("chunk %d: %w", n+len(batch)+1, err)
+		}
+		if c.Chunk.ID == "" || c.Chunk.Repository == "" || c.Chunk.Path == "" {
+			return n, fmt.Errorf("chunk %d: missing id, repository or path", n+len(batch)+1)
+		}
+		if batch = append(batch, c); len(batch) == importBatch {
+			if err := flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	if len(batch) == 0 {
+		return n, nil
+	}
+	return n, flush()
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+func TestExportImportJSONL(t *testing.T) {
+	ctx := context.Background()
+	src := newTestSQLite(t)
+	chunks := []ChunkWithVec{
+		{Chunk: models.Chunk{ID: "1", Repository: "repo", Ref: "main", Path: "a.go", Language: "go", Summary: "starts the server", Content: "func Start() {}",
+			LineStart: 1, LineEnd: 1, SummaryModel: "gpt", EmbedModel: "emb"}, SummaryVec: []float32{1, 0.5, 0}, ContentHash: "h1",
+			Symbols: []Symbol{{Name: "Start", Kind: "func", Line: 1}}},
+		{Chunk: models.Chunk{ID: "2", Repository: "other", Path: "b.go", Summary: "other repo", LineStart: 1, LineEnd: 3}, SummaryVec: []float32{0, 1, 0}, ContentHash: "h2"},
+	}
+	if err := src.UpsertChunks(ctx, chunks); err != nil {
+		t.Fatalf("UpsertChunks: %v", err)
+	}
+	if _, err := src.DeleteRepository(ctx, "other"); err != nil {
+		t.Fatalf("DeleteRepository: %v", err)
+	}
+
+	var buf bytes.Buffer
+	n, err := ExportJSONL(ctx, src, "", &buf)
+	if err != nil || n != 1 {
+		t.Fatalf("ExportJSONL = %d, %v (deleted chunks should be skipped)", n, err)
+	}
+
+	// Importing into a fresh store migrates it for the exported dimension.
+	dst, err := OpenSQLite(ctx, filepath.Join(t.TempDir(), "dst.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	t.Cleanup(dst.Close)
+	if n, err := ImportJSONL(ctx, dst, bytes.NewReader(buf.Bytes())); err != nil || n != 1 {
+		t.Fatalf("ImportJSONL = %d, %v", n, err)
+	}
+
+	var got []ChunkWithVec
+	if err := dst.ExportChunks(ctx, "repo", func(c ChunkWithVec) error {
+		c.Chunk.CreatedAt = chunks[0].Chunk.CreatedAt
+		got = append(got, c)
+		return nil
+	}); err != nil {
+		t.Fatalf("ExportChunks: %v", err)
+	}
+	if !reflect.DeepEqual(got, chunks[:1]) {
+		t.Errorf("round trip mismatch:\n got %+v\nwant %+v", got, chunks[:1])
+	}
+
+	// A store created for another dimension refuses the import.
+	other := newTestSQLite(t)
+	if err := other.UpsertChunk(ctx, models.Chunk{ID: "x", Repository: "r", Path: "x", LineStart: 1, LineEnd: 1}, []float32{1, 0}, "x"); err != nil {
+		t.Fatalf("UpsertChunk: %v", err)
+	}
+	if _, err := ImportJSONL(ctx, other, bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrDimensionMismatch) {
+		t.Errorf("expected ErrDimensionMismatch, got %v", err)
+	}
+
+	if _, err := ImportJSONL(ctx, dst, strings.NewReader(`{"chunk":{"id":"z"}}`)); err == nil {
+		t.Error("expected an error for a chunk without repository or path")
+	}
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// facetLimit caps the values returned per facet.
+const facetLimit = 20
+
+// Facet fields.
+const (
+	facetRepository = "repository"
+	facetLanguage   = "language"
+	facetRef        = "ref"
+	facetDirectory  = "directory"
+)
+
+// Facets counts the chunks matching opt by repository, language, ref and
+// top-level directory. A chunk matches when its path, summary or content
+// contains any term of opt.QueryText; with no query text every chunk that
+// passes the filters is counted.
+func (s *Store) Facets(ctx context.Context, opt QueryOpts) (models.Facets, error) {
+	where, args := filterSQL("deleted_at IS NULL", nil, opt, true)
+	if q := strings.TrimSpace(opt.QueryText); q != "" {
+		// Any term matches, as in search ranking; the cast keeps the lexemes
+		// from being stemmed twice.
+		args = append(args, q)
+		where += fmt.Sprintf(
+			" AND ts_fielded @@ replace(plainto_tsquery('%s', $%d)::text, ' & ', ' | ')::tsquery", s.tsConfig, len(args))
+	}
+	q := fmt.Sprintf(`
+      WITH m AS (
+        SELECT repository, COALESCE(language, '') AS language, COALESCE(ref, '') AS ref,
+               CASE WHEN position('/' IN path) > 0 THEN split_part(path, '/', 1) ELSE '.' END AS dir
+        FROM chunks
+        WHERE %s
+      )
+      SELECT '%[2]s', repository, COUNT(*) FROM m GROUP BY repository
+      UNION ALL
+      SELECT '%[3]s', language, COUNT(*) FROM m GROUP BY language
+      UNION ALL
+      SELECT '%[4]s', ref, COUNT(*) FROM m GROUP BY ref
+      UNION ALL
+      SELECT '%[5]s', dir, COUNT(*) FROM m GROUP BY dir`, where, facetRepository, facetLanguage, facetRef, facetDirectory)
+	rows, err := s.read.Query(ctx, q, args...)
+	if err != nil {
+		return models.Facets{}, err
+	}
+	defer rows.Close()
+
+	counts := map[string][]models.FacetCount{}
+	for rows.Next() {
+		var field string
+		var fc models.FacetCount
+		if err := rows.Scan(&field, &fc.Value, &fc.Count); err != nil {
+			return models.Facets{}, err
+		}
+		counts[field] = append(counts[field], fc)
+	}
+	if err := rows.Err(); err != nil {
+		return models.Facets{}, err
+	}
+	return newFacets(counts), nil
+}
+
+// Facets counts the chunks matching opt by repository, language, ref and
+// top-level directory. A chunk matches when its path, summary or content
+// contains any term of opt.QueryText; with no query text every chunk that
+// passes the filters is counted.
+func (s *SQLiteStore) Facets(ctx context.Context, opt QueryOpts) (models.Facets, error) {
+	where, args := sqliteFilters(opt, true)
+	rows, err := s.db.QueryContext(ctx, `
+      SELECT repository, COALESCE(language, ''), COALESCE(ref, ''), path, COALESCE(summary, ''), COALESCE(content, '')
+      FROM chunks WHERE `+where, args...)
+	if err != nil {
+		return models.Facets{}, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	terms := queryTerms(opt.QueryText)
+	tally := map[string]map[string]int64{facetRepository: {}, facetLanguage: {}, facetRef: {}, facetDirectory: {}}
+	for rows.Next() {
+		var repo, lang, ref, path, summary, content string
+		if err := rows.Scan(&repo, &lang, &ref, &path, &summary, &content); err != nil {
+			return models.Facets{}, err
+		}
+		if len(terms) > 0 && !containsAnyTerm(terms, path, summary, content) {
+			continue
+		}
+		dir := "."
+		if i := strings.Index(path, "/"); i > 0 {
+			dir = path[:i]
+		}
+		tally[facetRepository][repo]++
+		tally[facetLanguage][lang]++
+		tally[facetRef][ref]++
+		tally[facetDirectory][dir]++
+	}
+	if err := rows.Err(); err != nil {
+		return models.Facets{}, err
+	}
+
+	counts := map[string][]models.FacetCount{}
+	for field, values := range tally {
+		for v, n := range values {
+			counts[field] = append(counts[field], models.FacetCount{Value: v, Count: n})
+		}
+	}
+	return newFacets(counts), nil
+}
+
+// containsAnyTerm reports whether any of the texts contains one of terms as
+// a word.
+func containsAnyTerm(terms []string, texts ...string) bool {
+	for _, t := range texts {
+		for _, w := range wordRe.FindAllString(strings.ToLower(t), -1) {
+			for _, term := range terms {
+				if w == term {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// newFacets sorts each facet by descending count, then value, and keeps the
+// top facetLimit values.
+func newFacets(counts map[string][]models.FacetCount) models.Facets {
+	top := func(fcs []models.FacetCount) []models.FacetCount {
+		sort.Slice(fcs, func(i, j int) bool {
+			if fcs[i].Count != fcs[j].Count {
+				return fcs[i].Count > fcs[j].Count
+			}
+			return fcs[i].Value < fcs[j].Value
+		})
+		if len(fcs) > facetLimit {
+			fcs = fcs[:facetLimit]
+		}
+		if fcs == nil {
+			fcs = []models.FacetCount{}
+		}
+		return fcs
+	}
+	return models.Facets{
+		Repositories: top(counts[facetRepository]),
+		Languages:    top(counts[facetLanguage]),
+		Refs:         top(counts[facetRef]),
+		Directories:  top(counts[facetDirectory]),
+	}
+}
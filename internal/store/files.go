@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// FileChunks is the complete set of chunks of one file.
+type FileChunks struct {
+	Repository string
+	Ref        string
+	Path       string
+	Chunks     []ChunkWithVec
+}
+
+// FileWriter is implemented by stores that can replace every chunk of a file
+// at once, so a file is never left half-indexed.
+type FileWriter interface {
+	// WriteFiles upserts the chunks of each file and soft-deletes any other
+	// chunks stored for the same repository, ref and path, all in a single
+	// transaction.
+	WriteFiles(ctx context.Context, files []FileChunks) error
+}
+
+var (
+	_ FileWriter = (*Store)(nil)
+	_ FileWriter = (*SQLiteStore)(nil)
+)
+
+// staleChunksSQL soft-deletes the chunks of a file that were not just written.
+const staleChunksSQL = `
+		UPDATE chunks SET deleted_at = now()
+		WHERE repository = $1 AND ref = $2 AND path = $3
+		  AND deleted_at IS NULL AND NOT (id = ANY($4))`
+
+// WriteFiles replaces the chunks of files in one transaction. Vectors held
+// in an external index are written first; any left behind by a failed
+// transaction are overwritten on the next run.
+func (s *Store) WriteFiles(ctx context.Context, files []FileChunks) error {
+	if len(files) == 0 {
+		return nil
+	}
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	b := &pgx.Batch{}
+	for _, f := range files {
+		chunks := f.Chunks
+		if s.vectors != nil {
+			if chunks, err = s.upsertVectors(ctx, chunks); err != nil {
+				return err
+			}
+		}
+		ids := make([]string, len(chunks))
+		for i, c := range chunks {
+			b.Queue(upsertChunkSQL, upsertChunkArgs(c.Chunk, c.SummaryVec, c.ContentHash)...)
+			queueSymbols(b, c)
+			ids[i] = c.Chunk.ID
+		}
+		b.Queue(staleChunksSQL, f.Repository, f.Ref, f.Path, ids)
+	}
+	if err := tx.SendBatch(ctx, b).Close(); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// WriteFiles replaces the chunks of files in one transaction.
+func (s *SQLiteStore) WriteFiles(ctx context.Context, files []FileChunks) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+		for _, f := range files {
+			args := []any{now, f.Repository, f.Ref, f.Path}
+			for _, c := range f.Chunks {
+				if err := sqliteWriteChunk(ctx, tx, c); err != nil {
+					return err
+				}
+				args = append(args, c.Chunk.ID)
+			}
+			q := `UPDATE chunks SET deleted_at = ? WHERE repository = ? AND ref = ? AND path = ? AND deleted_at IS NULL`
+			if len(f.Chunks) > 0 {
+				q += ` AND id NOT IN (?` + strings.Repeat(", ?", len(f.Chunks)-1) + `)`
+			}
+			if _, err := tx.ExecContext(ctx, q, args...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// IsInvalidPathRegex reports whether err is Postgres rejecting the regular
+// expression of QueryOpts.PathRegex. Callers check patterns as Go regexps
+// first, but Postgres regexps differ enough that some still fail here.
+func IsInvalidPathRegex(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "2201B" // invalid_regular_expression
+}
+
+// filterSQL appends the WHERE conditions for the filters in opt to where,
+// numbering bind parameters after those already in args. withLanguage is
+// false for the rollups table, which has neither languages nor symbols.
+func filterSQL(where string, args []any, opt QueryOpts, withLanguage bool) (string, []any) {
+	add := func(cond string, v any) {
+		args = append(args, v)
+		where += " AND " + fmt.Sprintf(cond, len(args))
+	}
+	if len(opt.Repositories) > 0 {
+		add("repository = ANY($%d)", opt.Repositories)
+	}
+	if opt.AllowedRepositories != nil {
+		add("repository = ANY($%d)", opt.AllowedRepositories)
+	}
+	if opt.Principals != nil {
+		add("(repository NOT IN (SELECT repository FROM repository_grants)"+
+			" OR repository IN (SELECT repository FROM repository_grants WHERE principal = ANY($%d)))", opt.Principals)
+	}
+	if withLanguage && len(opt.Languages) > 0 {
+		add("language = ANY($%d)", opt.Languages)
+	}
+	if opt.Path != "" {
+		add("path = $%d", opt.Path)
+	}
+	if opt.PathContains != "" {
+		add("path ILIKE '%%' || $%d || '%%'", opt.PathContains)
+	}
+	if opt.PathRegex != "" {
+		add("path ~ $%d", opt.PathRegex)
+	}
+	for _, p := range opt.PathNotContains {
+		if p != "" {
+			add("path NOT ILIKE '%%' || $%d || '%%'", p)
+		}
+	}
+	if opt.Ref != "" {
+		add("ref = $%d", opt.Ref)
+	}
+	if withLanguage && opt.Symbol != "" {
+		if name, prefix := symbolPattern(opt.Symbol); prefix {
+			add("id IN (SELECT chunk_id FROM symbols WHERE lower(name) LIKE lower($%d) || '%%')", likeEscape(name))
+		} else {
+			add("id IN (SELECT chunk_id FROM symbols WHERE lower(name) = lower($%d))", name)
+		}
+	}
+	return where, args
+}
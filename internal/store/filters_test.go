@@ -0,0 +1,59 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestFilterSQL(t *testing.T) {
+	where, args := filterSQL("TRUE", []any{"vec"}, QueryOpts{
+		Repositories:    []string{"repo", "other"},
+		Languages:       []string{"go"},
+		PathContains:    "cmd/",
+		PathRegex:       `^cmd/.*/main\.go$`,
+		PathNotContains: []string{"_test.go", "", "vendor/"},
+		Ref:             "main",
+	}, true)
+
+	want := "TRUE AND repository = ANY($2) AND language = ANY($3) AND path ILIKE '%' || $4 || '%' AND path ~ $5" +
+		" AND path NOT ILIKE '%' || $6 || '%' AND path NOT ILIKE '%' || $7 || '%' AND ref = $8"
+	if where != want {
+		t.Errorf("where =\n%s\nwant\n%s", where, want)
+	}
+	if !reflect.DeepEqual(args, []any{"vec", []string{"repo", "other"}, []string{"go"}, "cmd/", `^cmd/.*/main\.go$`, "_test.go", "vendor/", "main"}) {
+		t.Errorf("unexpected args %v", args)
+	}
+
+	where, args = filterSQL("kind = $2", []any{"vec", "file"}, QueryOpts{Languages: []string{"go"}}, false)
+	if where != "kind = $2" || len(args) != 2 {
+		t.Errorf("language should be skipped, got %q %v", where, args)
+	}
+
+	where, args = filterSQL("TRUE", nil, QueryOpts{Path: "cmd/api/main.go"}, false)
+	if where != "TRUE AND path = $1" || !reflect.DeepEqual(args, []any{"cmd/api/main.go"}) {
+		t.Errorf("unexpected path filter %q %v", where, args)
+	}
+
+	where, args = filterSQL("TRUE", nil, QueryOpts{Symbol: "new_*"}, true)
+	if where != "TRUE AND id IN (SELECT chunk_id FROM symbols WHERE lower(name) LIKE lower($1) || '%')" ||
+		!reflect.DeepEqual(args, []any{`new\_`}) {
+		t.Errorf("unexpected symbol prefix filter %q %v", where, args)
+	}
+}
+
+func TestIsInvalidPathRegex(t *testing.T) {
+	// What Postgres returns for `path ~ '(?P<x>a)'`, a valid Go regexp.
+	pgErr := &pgconn.PgError{Code: "2201B", Message: "invalid regular expression: quantifier operand invalid"}
+	if !IsInvalidPathRegex(fmt.Errorf("search: %w", pgErr)) {
+		t.Error("expected a wrapped invalid_regular_expression error to be recognized")
+	}
+	for _, err := range []error{nil, errors.New("2201B"), &pgconn.PgError{Code: "57014"}} {
+		if IsInvalidPathRegex(err) {
+			t.Errorf("IsInvalidPathRegex(%v) = true", err)
+		}
+	}
+}
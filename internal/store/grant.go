@@ -0,0 +1,193 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// repositoryGrantSchema is shared by Postgres and SQLite.
+const repositoryGrantSchema = `
+CREATE TABLE IF NOT EXISTS repository_grants (
+  repository TEXT NOT NULL,
+  principal  TEXT NOT NULL,
+  role       TEXT NOT NULL,
+  granted_by TEXT NOT NULL,
+  granted_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (repository, principal)
+);
+CREATE INDEX IF NOT EXISTS repository_grants_principal_idx ON repository_grants (principal);
+`
+
+const repositoryGrantColumns = `repository, principal, role, granted_by, granted_at`
+
+// GrantRepository gives a principal a role on a repository, replacing any
+// role it had.
+func (s *Store) GrantRepository(ctx context.Context, g models.RepositoryGrant) error {
+	_, err := s.pool.Exec(ctx, `
+      INSERT INTO repository_grants (`+repositoryGrantColumns+`)
+      VALUES ($1, $2, $3, $4, $5)
+      ON CONFLICT (repository, principal) DO UPDATE
+        SET role = excluded.role, granted_by = excluded.granted_by, granted_at = excluded.granted_at`,
+		g.Repository, g.Principal, g.Role, g.GrantedBy, g.GrantedAt.UTC())
+	return err
+}
+
+// ReplaceRepositoryGrants replaces every grant made by grantedBy with
+// grants, e.g. to sync the grants kept in configuration.
+func (s *Store) ReplaceRepositoryGrants(ctx context.Context, grantedBy string, grants []models.RepositoryGrant) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	if _, err := tx.Exec(ctx, `DELETE FROM repository_grants WHERE granted_by = $1`, grantedBy); err != nil {
+		return err
+	}
+	for _, g := range grants {
+		if _, err := tx.Exec(ctx, `
+      INSERT INTO repository_grants (`+repositoryGrantColumns+`)
+      VALUES ($1, $2, $3, $4, $5)
+      ON CONFLICT (repository, principal) DO UPDATE
+        SET role = excluded.role, granted_by = excluded.granted_by, granted_at = excluded.granted_at`,
+			g.Repository, g.Principal, g.Role, grantedBy, g.GrantedAt.UTC()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// RevokeRepositoryGrant removes a principal's role on a repository. It
+// reports false when the principal had none.
+func (s *Store) RevokeRepositoryGrant(ctx context.Context, repository, principal string) (bool, error) {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM repository_grants WHERE repository = $1 AND principal = $2`, repository, principal)
+	return tag.RowsAffected() > 0, err
+}
+
+// RepositoryGrants returns the grants of a repository, by principal.
+func (s *Store) RepositoryGrants(ctx context.Context, repository string) ([]models.RepositoryGrant, error) {
+	rows, err := s.pool.Query(ctx, `
+      SELECT `+repositoryGrantColumns+` FROM repository_grants
+      WHERE repository = $1 ORDER BY principal`, repository)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanRepositoryGrants(rows)
+}
+
+// HiddenRepositories returns the private repositories none of principals
+// has a role on.
+func (s *Store) HiddenRepositories(ctx context.Context, principals []string) ([]string, error) {
+	rows, err := s.pool.Query(ctx, `
+      SELECT DISTINCT repository FROM repository_grants
+      WHERE repository NOT IN (SELECT repository FROM repository_grants WHERE principal = ANY($1))
+      ORDER BY repository`, principals)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []string{}
+	for rows.Next() {
+		var r string
+		if err := rows.Scan(&r); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// GrantRepository gives a principal a role on a repository, replacing any
+// role it had.
+func (s *SQLiteStore) GrantRepository(ctx context.Context, g models.RepositoryGrant) error {
+	_, err := s.db.ExecContext(ctx, `
+      INSERT INTO repository_grants (`+repositoryGrantColumns+`)
+      VALUES (?, ?, ?, ?, ?)
+      ON CONFLICT (repository, principal) DO UPDATE
+        SET role = excluded.role, granted_by = excluded.granted_by, granted_at = excluded.granted_at`,
+		g.Repository, g.Principal, g.Role, g.GrantedBy, g.GrantedAt.UTC())
+	return err
+}
+
+// ReplaceRepositoryGrants replaces every grant made by grantedBy with
+// grants, e.g. to sync the grants kept in configuration.
+func (s *SQLiteStore) ReplaceRepositoryGrants(ctx context.Context, grantedBy string, grants []models.RepositoryGrant) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM repository_grants WHERE granted_by = ?`, grantedBy); err != nil {
+		return err
+	}
+	for _, g := range grants {
+		if _, err := tx.ExecContext(ctx, `
+      INSERT INTO repository_grants (`+repositoryGrantColumns+`)
+      VALUES (?, ?, ?, ?, ?)
+      ON CONFLICT (repository, principal) DO UPDATE
+        SET role = excluded.role, granted_by = excluded.granted_by, granted_at = excluded.granted_at`,
+			g.Repository, g.Principal, g.Role, grantedBy, g.GrantedAt.UTC()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RevokeRepositoryGrant removes a principal's role on a repository. It
+// reports false when the principal had none.
+func (s *SQLiteStore) RevokeRepositoryGrant(ctx context.Context, repository, principal string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM repository_grants WHERE repository = ? AND principal = ?`, repository, principal)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RepositoryGrants returns the grants of a repository, by principal.
+func (s *SQLiteStore) RepositoryGrants(ctx context.Context, repository string) ([]models.RepositoryGrant, error) {
+	rows, err := s.db.QueryContext(ctx, `
+      SELECT `+repositoryGrantColumns+` FROM repository_grants
+      WHERE repository = ? ORDER BY principal`, repository)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	return scanRepositoryGrants(rows)
+}
+
+// HiddenRepositories returns the private repositories none of principals
+// has a role on.
+func (s *SQLiteStore) HiddenRepositories(ctx context.Context, principals []string) ([]string, error) {
+	cond, args := sqliteGrantedTo(principals)
+	return s.strings(ctx, `
+      SELECT DISTINCT repository FROM repository_grants
+      WHERE repository NOT IN (SELECT repository FROM repository_grants WHERE `+cond+`)
+      ORDER BY repository`, args...)
+}
+
+// sqliteGrantedTo matches the grants of any of principals.
+func sqliteGrantedTo(principals []string) (string, []any) {
+	args := make([]any, len(principals))
+	for i, p := range principals {
+		args[i] = p
+	}
+	return "principal IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(principals)), ", ") + ")", args
+}
+
+func scanRepositoryGrants(rows rowScanner) ([]models.RepositoryGrant, error) {
+	out := []models.RepositoryGrant{}
+	for rows.Next() {
+		var g models.RepositoryGrant
+		var granted sql.NullTime
+		if err := rows.Scan(&g.Repository, &g.Principal, &g.Role, &g.GrantedBy, &granted); err != nil {
+			return nil, err
+		}
+		g.GrantedAt = granted.Time
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
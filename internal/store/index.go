@@ -0,0 +1,190 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Vector index types.
+const (
+	IndexHNSW    = "hnsw"    // approximate nearest neighbour graph (default)
+	IndexIVFFlat = "ivfflat" // inverted lists; faster to build, needs data before creation
+	IndexNone    = "none"    // no vector index; exact but slow scans
+)
+
+// IndexOptions controls the vector index created by Migrate and how it is
+// queried.
+type IndexOptions struct {
+	Type string // IndexHNSW, IndexIVFFlat or IndexNone
+
+	// HNSW build parameters, applied when the index is (re)created.
+	M              int
+	EfConstruction int
+
+	// EfSearch sets hnsw.ef_search for each query. Zero keeps the server
+	// default. QueryOpts.EfSearch overrides it per query.
+	EfSearch int
+
+	// Lists is the number of ivfflat lists, applied when the index is
+	// (re)created.
+	Lists int
+
+	// Probes sets ivfflat.probes for each query. Zero keeps the server
+	// default. QueryOpts.Probes overrides it per query.
+	Probes int
+}
+
+// DefaultIndexOptions returns the index settings used when none are given.
+func DefaultIndexOptions() IndexOptions {
+	return IndexOptions{Type: IndexHNSW, M: 16, EfConstruction: 64, Lists: 100}
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithIndexOptions sets the vector index options. Zero fields fall back to
+// DefaultIndexOptions.
+func WithIndexOptions(o IndexOptions) Option {
+	return func(s *Store) {
+		def := DefaultIndexOptions()
+		if o.Type == "" {
+			o.Type = def.Type
+		}
+		if o.M <= 0 {
+			o.M = def.M
+		}
+		if o.EfConstruction <= 0 {
+			o.EfConstruction = def.EfConstruction
+		}
+		if o.Lists <= 0 {
+			o.Lists = def.Lists
+		}
+		s.index = o
+	}
+}
+
+// ParseIndexType validates a configured vector index type.
+func ParseIndexType(t string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(t)) {
+	case "", IndexHNSW:
+		return IndexHNSW, nil
+	case IndexIVFFlat:
+		return IndexIVFFlat, nil
+	case IndexNone:
+		return IndexNone, nil
+	default:
+		return "", fmt.Errorf("unsupported vector index: %s (expected hnsw, ivfflat or none)", t)
+	}
+}
+
+// vectorIndexDef returns the access method and WITH clause for the index.
+func (s *Store) vectorIndexDef() string {
+	if s.index.Type == IndexIVFFlat {
+		return fmt.Sprintf("ivfflat (summary_vec vector_cosine_ops) WITH (lists = %d)", s.index.Lists)
+	}
+	return fmt.Sprintf("hnsw (summary_vec vector_cosine_ops) WITH (m = %d, ef_construction = %d)",
+		s.index.M, s.index.EfConstruction)
+}
+
+// vectorIndexMatch returns the fragments pg_indexes shows for an index built
+// with the current options, e.g. m='16', ef_construction='64'.
+func (s *Store) vectorIndexMatch() (method, with string) {
+	if s.index.Type == IndexIVFFlat {
+		return "USING ivfflat", fmt.Sprintf("lists='%d'", s.index.Lists)
+	}
+	return "USING hnsw", fmt.Sprintf("m='%d', ef_construction='%d'", s.index.M, s.index.EfConstruction)
+}
+
+// ensureVectorIndex creates the summary_vec index on table, rebuilding it when
+// its type or build parameters no longer match the configured options.
+func (s *Store) ensureVectorIndex(ctx context.Context, table, name string) error {
+	var existing string
+	err := s.pool.QueryRow(ctx, `SELECT indexdef FROM pg_indexes WHERE tablename = $1 AND indexname = $2`, table, name).Scan(&existing)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	found := err == nil
+
+	if s.index.Type == IndexNone {
+		if found {
+			_, err := s.pool.Exec(ctx, "DROP INDEX IF EXISTS "+name)
+			return err
+		}
+		return nil
+	}
+
+	method, with := s.vectorIndexMatch()
+	if found && strings.Contains(existing, method) && strings.Contains(existing, with) {
+		return nil
+	}
+	if found {
+		if _, err := s.pool.Exec(ctx, "DROP INDEX IF EXISTS "+name); err != nil {
+			return err
+		}
+	}
+	_, err = s.pool.Exec(ctx, fmt.Sprintf("CREATE INDEX %s ON %s USING %s", name, table, s.vectorIndexDef()))
+	return err
+}
+
+// MaxEfSearch bounds hnsw.ef_search: longer candidate lists cost latency
+// and memory for little recall.
+const MaxEfSearch = 400
+
+// searchSetting returns the SET LOCAL assignment tuning recall for a query,
+// e.g. "hnsw.ef_search = 100", or "" to keep the server default. ef_search
+// is capped at MaxEfSearch and probes at the number of lists, as probing
+// more than every list only adds work.
+func (s *Store) searchSetting(opt QueryOpts) string {
+	switch s.index.Type {
+	case IndexHNSW:
+		ef := s.index.EfSearch
+		if opt.EfSearch > 0 {
+			ef = opt.EfSearch
+		}
+		if ef > 0 {
+			return fmt.Sprintf("hnsw.ef_search = %d", min(ef, MaxEfSearch))
+		}
+	case IndexIVFFlat:
+		probes := s.index.Probes
+		if opt.Probes > 0 {
+			probes = opt.Probes
+		}
+		if s.index.Lists > 0 {
+			probes = min(probes, s.index.Lists)
+		}
+		if probes > 0 {
+			return fmt.Sprintf("ivfflat.probes = %d", probes)
+		}
+	}
+	return ""
+}
+
+// queryWithSetting runs q on the read pool, applying setting (see searchSetting) for the
+// duration of the query when it is non-empty. The returned release func must
+// be called after the rows have been consumed.
+func (s *Store) queryWithSetting(ctx context.Context, setting string, q string, args ...any) (pgx.Rows, func(), error) {
+	if setting == "" {
+		rows, err := s.read.Query(ctx, q, args...)
+		return rows, func() {}, err
+	}
+	tx, err := s.read.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	release := func() { _ = tx.Rollback(ctx) }
+	// SET LOCAL does not accept bind parameters; setting is built from ints.
+	if _, err := tx.Exec(ctx, "SET LOCAL "+setting); err != nil {
+		release()
+		return nil, nil, err
+	}
+	rows, err := tx.Query(ctx, q, args...)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return rows, release, nil
+}
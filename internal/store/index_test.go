@@ -0,0 +1,46 @@
+package store
+
+import "testing"
+
+func TestSearchSetting(t *testing.T) {
+	tests := []struct {
+		name  string
+		index IndexOptions
+		opt   QueryOpts
+		want  string
+	}{
+		{"hnsw default", IndexOptions{Type: IndexHNSW}, QueryOpts{}, ""},
+		{"hnsw configured", IndexOptions{Type: IndexHNSW, EfSearch: 80}, QueryOpts{}, "hnsw.ef_search = 80"},
+		{"hnsw per query", IndexOptions{Type: IndexHNSW, EfSearch: 80}, QueryOpts{EfSearch: 200, Probes: 5}, "hnsw.ef_search = 200"},
+		{"ivfflat configured", IndexOptions{Type: IndexIVFFlat, Probes: 4}, QueryOpts{EfSearch: 200}, "ivfflat.probes = 4"},
+		{"ivfflat per query", IndexOptions{Type: IndexIVFFlat, Probes: 4}, QueryOpts{Probes: 12}, "ivfflat.probes = 12"},
+		{"hnsw capped", IndexOptions{Type: IndexHNSW, EfSearch: 80}, QueryOpts{EfSearch: 1000}, "hnsw.ef_search = 400"},
+		{"ivfflat capped at lists", IndexOptions{Type: IndexIVFFlat, Lists: 100, Probes: 4}, QueryOpts{Probes: 5000}, "ivfflat.probes = 100"},
+		{"ivfflat configured above lists", IndexOptions{Type: IndexIVFFlat, Lists: 10, Probes: 40}, QueryOpts{}, "ivfflat.probes = 10"},
+		{"none", IndexOptions{Type: IndexNone, EfSearch: 80, Probes: 4}, QueryOpts{EfSearch: 1}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Store{index: tt.index}
+			if got := s.searchSetting(tt.opt); got != tt.want {
+				t.Errorf("searchSetting() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVectorIndexDef(t *testing.T) {
+	s := &Store{}
+	WithIndexOptions(IndexOptions{Type: IndexIVFFlat, Lists: 250})(s)
+	if got := s.vectorIndexDef(); got != "ivfflat (summary_vec vector_cosine_ops) WITH (lists = 250)" {
+		t.Errorf("unexpected ivfflat definition %q", got)
+	}
+	if method, with := s.vectorIndexMatch(); method != "USING ivfflat" || with != "lists='250'" {
+		t.Errorf("unexpected match %q %q", method, with)
+	}
+
+	WithIndexOptions(IndexOptions{})(s)
+	if got := s.vectorIndexDef(); got != "hnsw (summary_vec vector_cosine_ops) WITH (m = 16, ef_construction = 64)" {
+		t.Errorf("unexpected default definition %q", got)
+	}
+}
@@ -0,0 +1,188 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// indexRunSchema is shared by Postgres and SQLite. A row is written at the
+// end of every indexing run, successful or not.
+const indexRunSchema = `
+CREATE TABLE IF NOT EXISTS index_runs (
+  repository      TEXT NOT NULL,
+  ref             TEXT NOT NULL,
+  commit_sha      TEXT NOT NULL DEFAULT '',
+  started_at      TIMESTAMP NOT NULL,
+  finished_at     TIMESTAMP NOT NULL,
+  files_scanned   BIGINT NOT NULL DEFAULT 0,
+  files_failed    BIGINT NOT NULL DEFAULT 0,
+  chunks_upserted BIGINT NOT NULL DEFAULT 0,
+  error           TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS index_runs_repo_ref_idx ON index_runs (repository, ref, started_at);
+`
+
+// IndexRunRecorder is implemented by stores that keep a history of indexing
+// runs. The indexer records each run when its store implements it.
+type IndexRunRecorder interface {
+	RecordIndexRun(ctx context.Context, r models.IndexRun) error
+}
+
+var (
+	_ IndexRunRecorder = (*Store)(nil)
+	_ IndexRunRecorder = (*SQLiteStore)(nil)
+)
+
+const indexRunColumns = `repository, ref, commit_sha, started_at, finished_at, files_scanned, files_failed, chunks_upserted, error`
+
+// lastRunsQuery selects the most recent run of each ref of the repository
+// bound to param, or the most recent successful one when succeeded is set.
+func lastRunsQuery(param string, succeeded bool) string {
+	cond := ""
+	if succeeded {
+		cond = ` AND error = ''`
+	}
+	return `SELECT ` + indexRunColumns + ` FROM index_runs r
+      WHERE repository = ` + param + cond + ` AND started_at = (
+        SELECT MAX(started_at) FROM index_runs
+        WHERE repository = r.repository AND ref = r.ref` + cond + `)`
+}
+
+// RecordIndexRun stores the outcome of an indexing run.
+func (s *Store) RecordIndexRun(ctx context.Context, r models.IndexRun) error {
+	_, err := s.pool.Exec(ctx, `
+      INSERT INTO index_runs (`+indexRunColumns+`)
+      VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		r.Repository, r.Ref, r.Commit, r.StartedAt.UTC(), r.FinishedAt.UTC(),
+		r.FilesScanned, r.FilesFailed, r.ChunksUpserted, r.Error)
+	return err
+}
+
+// RepositoryStatus returns the indexing state of each ref of a repository,
+// ordered by ref. It returns no refs for an unknown repository.
+func (s *Store) RepositoryStatus(ctx context.Context, repository string) (models.RepositoryStatus, error) {
+	rows, err := s.read.Query(ctx, `
+      SELECT ref, COUNT(*) FROM chunks
+      WHERE repository = $1 AND deleted_at IS NULL GROUP BY ref`, repository)
+	if err != nil {
+		return models.RepositoryStatus{}, err
+	}
+	counts, err := scanRefCounts(rows)
+	rows.Close()
+	if err != nil {
+		return models.RepositoryStatus{}, err
+	}
+	var runs [2][]models.IndexRun
+	for i, succeeded := range []bool{false, true} {
+		rows, err := s.read.Query(ctx, lastRunsQuery("$1", succeeded), repository)
+		if err != nil {
+			return models.RepositoryStatus{}, err
+		}
+		runs[i], err = scanIndexRuns(rows)
+		rows.Close()
+		if err != nil {
+			return models.RepositoryStatus{}, err
+		}
+	}
+	return repositoryStatus(repository, counts, runs[0], runs[1]), nil
+}
+
+// RecordIndexRun stores the outcome of an indexing run.
+func (s *SQLiteStore) RecordIndexRun(ctx context.Context, r models.IndexRun) error {
+	_, err := s.db.ExecContext(ctx, `
+      INSERT INTO index_runs (`+indexRunColumns+`)
+      VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.Repository, r.Ref, r.Commit, r.StartedAt.UTC(), r.FinishedAt.UTC(),
+		r.FilesScanned, r.FilesFailed, r.ChunksUpserted, r.Error)
+	return err
+}
+
+// RepositoryStatus returns the indexing state of each ref of a repository,
+// ordered by ref. It returns no refs for an unknown repository.
+func (s *SQLiteStore) RepositoryStatus(ctx context.Context, repository string) (models.RepositoryStatus, error) {
+	rows, err := s.db.QueryContext(ctx, `
+      SELECT ref, COUNT(*) FROM chunks
+      WHERE repository = ? AND deleted_at IS NULL GROUP BY ref`, repository)
+	if err != nil {
+		return models.RepositoryStatus{}, err
+	}
+	counts, err := scanRefCounts(rows)
+	_ = rows.Close()
+	if err != nil {
+		return models.RepositoryStatus{}, err
+	}
+	var runs [2][]models.IndexRun
+	for i, succeeded := range []bool{false, true} {
+		rows, err := s.db.QueryContext(ctx, lastRunsQuery("?", succeeded), repository)
+		if err != nil {
+			return models.RepositoryStatus{}, err
+		}
+		runs[i], err = scanIndexRuns(rows)
+		_ = rows.Close()
+		if err != nil {
+			return models.RepositoryStatus{}, err
+		}
+	}
+	return repositoryStatus(repository, counts, runs[0], runs[1]), nil
+}
+
+func scanRefCounts(rows rowScanner) (map[string]int64, error) {
+	counts := map[string]int64{}
+	for rows.Next() {
+		var ref string
+		var n int64
+		if err := rows.Scan(&ref, &n); err != nil {
+			return nil, err
+		}
+		counts[ref] = n
+	}
+	return counts, rows.Err()
+}
+
+func scanIndexRuns(rows rowScanner) ([]models.IndexRun, error) {
+	var out []models.IndexRun
+	for rows.Next() {
+		var r models.IndexRun
+		var started, finished sql.NullTime
+		if err := rows.Scan(&r.Repository, &r.Ref, &r.Commit, &started, &finished,
+			&r.FilesScanned, &r.FilesFailed, &r.ChunksUpserted, &r.Error); err != nil {
+			return nil, err
+		}
+		r.StartedAt, r.FinishedAt = started.Time, finished.Time
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// repositoryStatus merges chunk counts with the last run and last successful
+// run of each ref.
+func repositoryStatus(repository string, counts map[string]int64, last, succeeded []models.IndexRun) models.RepositoryStatus {
+	byRef := map[string]*models.RefStatus{}
+	ref := func(name string) *models.RefStatus {
+		if byRef[name] == nil {
+			byRef[name] = &models.RefStatus{Ref: name, Chunks: counts[name]}
+		}
+		return byRef[name]
+	}
+	for name := range counts {
+		ref(name)
+	}
+	for i := range last {
+		ref(last[i].Ref).LastRun = &last[i]
+	}
+	for _, r := range succeeded {
+		st := ref(r.Ref)
+		finished := r.FinishedAt
+		st.LastCommit, st.LastIndexedAt = r.Commit, &finished
+	}
+
+	out := models.RepositoryStatus{Repository: repository, Refs: []models.RefStatus{}}
+	for _, st := range byRef {
+		out.Refs = append(out.Refs, *st)
+	}
+	sort.Slice(out.Refs, func(i, j int) bool { return out.Refs[i].Ref < out.Refs[j].Ref })
+	return out
+}
@@ -0,0 +1,415 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// jobSchema is shared by Postgres and SQLite. dedup_key is set for jobs of
+// which only one may be queued or running at a time, which jobs_dedup_idx
+// enforces across processes; heartbeat_at is updated
+// by the process running a job so that jobs of crashed processes can be told
+// apart from live ones.
+const jobSchema = `
+CREATE TABLE IF NOT EXISTS jobs (
+  id               TEXT PRIMARY KEY,
+  kind             TEXT NOT NULL,
+  params           TEXT NOT NULL DEFAULT '{}',
+  dedup_key        TEXT NOT NULL DEFAULT '',
+  status           TEXT NOT NULL,
+  created_by       TEXT NOT NULL DEFAULT '',
+  created_at       TIMESTAMP NOT NULL,
+  started_at       TIMESTAMP,
+  finished_at      TIMESTAMP,
+  heartbeat_at     TIMESTAMP,
+  progress         TEXT NOT NULL DEFAULT '{}',
+  error            TEXT NOT NULL DEFAULT '',
+  cancel_requested BOOLEAN NOT NULL DEFAULT FALSE
+);
+CREATE INDEX IF NOT EXISTS jobs_status_idx ON jobs (status, created_at);
+CREATE UNIQUE INDEX IF NOT EXISTS jobs_dedup_idx ON jobs (dedup_key)
+  WHERE dedup_key <> '' AND status IN ('queued', 'running');
+CREATE TABLE IF NOT EXISTS job_logs (
+  job_id  TEXT NOT NULL,
+  seq     INTEGER NOT NULL,
+  at      TIMESTAMP NOT NULL,
+  message TEXT NOT NULL,
+  PRIMARY KEY (job_id, seq)
+);
+`
+
+// JobStore persists the background job queue. Jobs are claimed atomically,
+// so several processes can share a queue.
+type JobStore interface {
+	EnqueueJob(ctx context.Context, j models.Job, dedupKey string) (bool, error)
+	ClaimJob(ctx context.Context, at time.Time) (models.Job, bool, error)
+	HeartbeatJob(ctx context.Context, id string, progress map[string]int64, at time.Time) (bool, error)
+	FinishJob(ctx context.Context, id string, status models.JobStatus, errMsg string, progress map[string]int64, at time.Time) error
+	CancelJob(ctx context.Context, id string, at time.Time) (models.Job, bool, error)
+	GetJob(ctx context.Context, id string) (models.Job, bool, error)
+	ListJobs(ctx context.Context, kind string, limit int) ([]models.Job, error)
+	AppendJobLog(ctx context.Context, id string, seq int, l models.JobLog) error
+	JobLogs(ctx context.Context, id string) ([]models.JobLog, error)
+	FailStaleJobs(ctx context.Context, before, at time.Time) (int64, error)
+	DeleteFinishedJobs(ctx context.Context, before time.Time) (int64, error)
+}
+
+var (
+	_ JobStore = (*Store)(nil)
+	_ JobStore = (*SQLiteStore)(nil)
+)
+
+// jobDedupConflict turns an insert that would break jobs_dedup_idx into a
+// no-op, so that a job already queued or running under the same dedup key
+// is reported rather than failing the insert.
+const jobDedupConflict = `ON CONFLICT (dedup_key) WHERE dedup_key <> '' AND status IN ('queued', 'running') DO NOTHING`
+
+const jobColumns = `id, kind, params, status, created_by, created_at, started_at, finished_at, progress, error, cancel_requested`
+
+// staleJobError is recorded for jobs failed by FailStaleJobs.
+const staleJobError = "interrupted: the process running the job stopped"
+
+// EnqueueJob adds a queued job. When dedupKey is set it reports false, adding
+// nothing, if a queued or running job has the same key.
+func (s *Store) EnqueueJob(ctx context.Context, j models.Job, dedupKey string) (bool, error) {
+	tag, err := s.pool.Exec(ctx, `
+      INSERT INTO jobs (id, kind, params, dedup_key, status, created_by, created_at)
+      VALUES ($1, $2, $3, $4, $5, $6, $7) `+jobDedupConflict,
+		j.ID, j.Kind, jobParams(j.Params), dedupKey, string(models.JobQueued), j.User, j.CreatedAt.UTC())
+	return tag.RowsAffected() > 0, err
+}
+
+// ClaimJob marks the oldest queued job as running and returns it. It reports
+// false when the queue is empty.
+func (s *Store) ClaimJob(ctx context.Context, at time.Time) (models.Job, bool, error) {
+	rows, err := s.pool.Query(ctx, `
+      UPDATE jobs SET status = 'running', started_at = $1, heartbeat_at = $1
+      WHERE id = (
+        SELECT id FROM jobs WHERE status = 'queued'
+        ORDER BY created_at, id LIMIT 1 FOR UPDATE SKIP LOCKED)
+      RETURNING `+jobColumns, at.UTC())
+	if err != nil {
+		return models.Job{}, false, err
+	}
+	defer rows.Close()
+	return firstJob(scanJobs(rows))
+}
+
+// HeartbeatJob records the progress of a running job and reports whether it
+// was asked to cancel.
+func (s *Store) HeartbeatJob(ctx context.Context, id string, progress map[string]int64, at time.Time) (bool, error) {
+	var cancel bool
+	err := s.pool.QueryRow(ctx, `
+      UPDATE jobs SET progress = $2, heartbeat_at = $3 WHERE id = $1
+      RETURNING cancel_requested`, id, jobProgress(progress), at.UTC()).Scan(&cancel)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	return cancel, err
+}
+
+// FinishJob records the outcome of a job.
+func (s *Store) FinishJob(ctx context.Context, id string, status models.JobStatus, errMsg string, progress map[string]int64, at time.Time) error {
+	_, err := s.pool.Exec(ctx, `
+      UPDATE jobs SET status = $2, error = $3, progress = $4, finished_at = $5 WHERE id = $1`,
+		id, string(status), errMsg, jobProgress(progress), at.UTC())
+	return err
+}
+
+// CancelJob cancels a queued job at once and asks the process running a
+// running job to stop it. It returns the job as updated, and reports false
+// when there is none with that id.
+func (s *Store) CancelJob(ctx context.Context, id string, at time.Time) (models.Job, bool, error) {
+	if _, err := s.pool.Exec(ctx, `
+      UPDATE jobs SET
+        status = CASE WHEN status = 'queued' THEN 'canceled' ELSE status END,
+        finished_at = CASE WHEN status = 'queued' THEN $2 ELSE finished_at END,
+        cancel_requested = TRUE
+      WHERE id = $1 AND status IN ('queued', 'running')`, id, at.UTC()); err != nil {
+		return models.Job{}, false, err
+	}
+	return s.GetJob(ctx, id)
+}
+
+// GetJob returns the job with the given id.
+func (s *Store) GetJob(ctx context.Context, id string) (models.Job, bool, error) {
+	rows, err := s.pool.Query(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id)
+	if err != nil {
+		return models.Job{}, false, err
+	}
+	defer rows.Close()
+	return firstJob(scanJobs(rows))
+}
+
+// ListJobs returns up to limit jobs, newest first, of the given kind or of
+// any kind when it is empty.
+func (s *Store) ListJobs(ctx context.Context, kind string, limit int) ([]models.Job, error) {
+	rows, err := s.pool.Query(ctx, `
+      SELECT `+jobColumns+` FROM jobs WHERE $1::text = '' OR kind = $1
+      ORDER BY created_at DESC, id DESC LIMIT $2`, kind, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanJobs(rows)
+}
+
+// AppendJobLog adds line seq to the log of a job.
+func (s *Store) AppendJobLog(ctx context.Context, id string, seq int, l models.JobLog) error {
+	_, err := s.pool.Exec(ctx, `INSERT INTO job_logs (job_id, seq, at, message) VALUES ($1, $2, $3, $4)`,
+		id, seq, l.At.UTC(), l.Message)
+	return err
+}
+
+// JobLogs returns the log of a job, oldest line first.
+func (s *Store) JobLogs(ctx context.Context, id string) ([]models.JobLog, error) {
+	rows, err := s.pool.Query(ctx, `SELECT at, message FROM job_logs WHERE job_id = $1 ORDER BY seq`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanJobLogs(rows)
+}
+
+// FailStaleJobs fails running jobs whose last heartbeat is older than before,
+// left behind by a process that stopped, and returns how many there were.
+func (s *Store) FailStaleJobs(ctx context.Context, before, at time.Time) (int64, error) {
+	tag, err := s.pool.Exec(ctx, `
+      UPDATE jobs SET status = 'failed', error = $3, finished_at = $2
+      WHERE status = 'running' AND heartbeat_at < $1`, before.UTC(), at.UTC(), staleJobError)
+	return tag.RowsAffected(), err
+}
+
+// DeleteFinishedJobs removes the jobs that finished before the given time,
+// along with their logs.
+func (s *Store) DeleteFinishedJobs(ctx context.Context, before time.Time) (int64, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	const finished = `SELECT id FROM jobs WHERE status IN ('succeeded', 'failed', 'canceled') AND finished_at < $1`
+	if _, err := tx.Exec(ctx, `DELETE FROM job_logs WHERE job_id IN (`+finished+`)`, before.UTC()); err != nil {
+		return 0, err
+	}
+	tag, err := tx.Exec(ctx, `DELETE FROM jobs WHERE id IN (`+finished+`)`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), tx.Commit(ctx)
+}
+
+// EnqueueJob adds a queued job. When dedupKey is set it reports false, adding
+// nothing, if a queued or running job has the same key.
+func (s *SQLiteStore) EnqueueJob(ctx context.Context, j models.Job, dedupKey string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+      INSERT INTO jobs (id, kind, params, dedup_key, status, created_by, created_at)
+      VALUES (?, ?, ?, ?, ?, ?, ?) `+jobDedupConflict,
+		j.ID, j.Kind, jobParams(j.Params), dedupKey, string(models.JobQueued), j.User, j.CreatedAt.UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ClaimJob marks the oldest queued job as running and returns it. It reports
+// false when the queue is empty.
+func (s *SQLiteStore) ClaimJob(ctx context.Context, at time.Time) (models.Job, bool, error) {
+	rows, err := s.db.QueryContext(ctx, `
+      UPDATE jobs SET status = 'running', started_at = ?1, heartbeat_at = ?1
+      WHERE id = (
+        SELECT id FROM jobs WHERE status = 'queued'
+        ORDER BY created_at, id LIMIT 1)
+      RETURNING `+jobColumns, at.UTC())
+	if err != nil {
+		return models.Job{}, false, err
+	}
+	defer func() { _ = rows.Close() }()
+	return firstJob(scanJobs(rows))
+}
+
+// HeartbeatJob records the progress of a running job and reports whether it
+// was asked to cancel.
+func (s *SQLiteStore) HeartbeatJob(ctx context.Context, id string, progress map[string]int64, at time.Time) (bool, error) {
+	var cancel bool
+	err := s.db.QueryRowContext(ctx, `
+      UPDATE jobs SET progress = ?, heartbeat_at = ? WHERE id = ?
+      RETURNING cancel_requested`, jobProgress(progress), at.UTC(), id).Scan(&cancel)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return cancel, err
+}
+
+// FinishJob records the outcome of a job.
+func (s *SQLiteStore) FinishJob(ctx context.Context, id string, status models.JobStatus, errMsg string, progress map[string]int64, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+      UPDATE jobs SET status = ?, error = ?, progress = ?, finished_at = ? WHERE id = ?`,
+		string(status), errMsg, jobProgress(progress), at.UTC(), id)
+	return err
+}
+
+// CancelJob cancels a queued job at once and asks the process running a
+// running job to stop it. It returns the job as updated, and reports false
+// when there is none with that id.
+func (s *SQLiteStore) CancelJob(ctx context.Context, id string, at time.Time) (models.Job, bool, error) {
+	if _, err := s.db.ExecContext(ctx, `
+      UPDATE jobs SET
+        status = CASE WHEN status = 'queued' THEN 'canceled' ELSE status END,
+        finished_at = CASE WHEN status = 'queued' THEN ?2 ELSE finished_at END,
+        cancel_requested = TRUE
+      WHERE id = ?1 AND status IN ('queued', 'running')`, id, at.UTC()); err != nil {
+		return models.Job{}, false, err
+	}
+	return s.GetJob(ctx, id)
+}
+
+// GetJob returns the job with the given id.
+func (s *SQLiteStore) GetJob(ctx context.Context, id string) (models.Job, bool, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = ?`, id)
+	if err != nil {
+		return models.Job{}, false, err
+	}
+	defer func() { _ = rows.Close() }()
+	return firstJob(scanJobs(rows))
+}
+
+// ListJobs returns up to limit jobs, newest first, of the given kind or of
+// any kind when it is empty.
+func (s *SQLiteStore) ListJobs(ctx context.Context, kind string, limit int) ([]models.Job, error) {
+	rows, err := s.db.QueryContext(ctx, `
+      SELECT `+jobColumns+` FROM jobs WHERE ?1 = '' OR kind = ?1
+      ORDER BY created_at DESC, id DESC LIMIT ?2`, kind, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	return scanJobs(rows)
+}
+
+// AppendJobLog adds line seq to the log of a job.
+func (s *SQLiteStore) AppendJobLog(ctx context.Context, id string, seq int, l models.JobLog) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO job_logs (job_id, seq, at, message) VALUES (?, ?, ?, ?)`,
+		id, seq, l.At.UTC(), l.Message)
+	return err
+}
+
+// JobLogs returns the log of a job, oldest line first.
+func (s *SQLiteStore) JobLogs(ctx context.Context, id string) ([]models.JobLog, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT at, message FROM job_logs WHERE job_id = ? ORDER BY seq`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	return scanJobLogs(rows)
+}
+
+// FailStaleJobs fails running jobs whose last heartbeat is older than before,
+// left behind by a process that stopped, and returns how many there were.
+func (s *SQLiteStore) FailStaleJobs(ctx context.Context, before, at time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+      UPDATE jobs SET status = 'failed', error = ?, finished_at = ?
+      WHERE status = 'running' AND heartbeat_at < ?`, staleJobError, at.UTC(), before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteFinishedJobs removes the jobs that finished before the given time,
+// along with their logs.
+func (s *SQLiteStore) DeleteFinishedJobs(ctx context.Context, before time.Time) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	const finished = `SELECT id FROM jobs WHERE status IN ('succeeded', 'failed', 'canceled') AND finished_at < ?`
+	if _, err := tx.ExecContext(ctx, `DELETE FROM job_logs WHERE job_id IN (`+finished+`)`, before.UTC()); err != nil {
+		return 0, err
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM jobs WHERE id IN (`+finished+`)`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// jobParams returns the stored form of a job's params.
+func jobParams(p json.RawMessage) string {
+	if len(p) == 0 {
+		return "{}"
+	}
+	return string(p)
+}
+
+// jobProgress returns the stored form of a job's progress counters.
+func jobProgress(p map[string]int64) string {
+	if len(p) == 0 {
+		return "{}"
+	}
+	b, _ := json.Marshal(p)
+	return string(b)
+}
+
+func scanJobs(rows rowScanner) ([]models.Job, error) {
+	out := []models.Job{}
+	for rows.Next() {
+		var j models.Job
+		var params, status, progress string
+		var created, started, finished sql.NullTime
+		if err := rows.Scan(&j.ID, &j.Kind, &params, &status, &j.User, &created, &started, &finished,
+			&progress, &j.Error, &j.CancelRequested); err != nil {
+			return nil, err
+		}
+		j.Status, j.CreatedAt = models.JobStatus(status), created.Time
+		if params != "{}" {
+			j.Params = json.RawMessage(params)
+		}
+		if started.Valid {
+			j.StartedAt = &started.Time
+		}
+		if finished.Valid {
+			j.FinishedAt = &finished.Time
+		}
+		if err := json.Unmarshal([]byte(progress), &j.Progress); err != nil {
+			return nil, err
+		}
+		if len(j.Progress) == 0 {
+			j.Progress = nil
+		}
+		out = append(out, j)
+	}
+	return out, rows.Err()
+}
+
+func firstJob(list []models.Job, err error) (models.Job, bool, error) {
+	if err != nil || len(list) == 0 {
+		return models.Job{}, false, err
+	}
+	return list[0], true, nil
+}
+
+func scanJobLogs(rows rowScanner) ([]models.JobLog, error) {
+	out := []models.JobLog{}
+	for rows.Next() {
+		var l models.JobLog
+		var at sql.NullTime
+		if err := rows.Scan(&at, &l.Message); err != nil {
+			return nil, err
+		}
+		l.At = at.Time
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,159 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	pgvector "github.com/pgvector/pgvector-go"
+)
+
+// MaintenanceStore defines the methods used to refresh derived artifacts of
+// chunks already in the store without re-reading repositories.
+type MaintenanceStore interface {
+	ListStaleSummaries(ctx context.Context, model, afterID string, limit int) ([]StaleChunk, error)
+	ListStaleVectors(ctx context.Context, model, afterID string, limit int) ([]StaleChunk, error)
+	UpdateSummaries(ctx context.Context, updates []SummaryUpdate) error
+	UpdateVectors(ctx context.Context, updates []VectorUpdate) error
+}
+
+// StaleChunk is a chunk whose summary or vector was produced by a model other
+// than the current one.
+type StaleChunk struct {
+	ID       string
+	Path     string
+	Language string
+	Summary  string
+	Content  string
+}
+
+// SummaryUpdate replaces the summary of a chunk.
+type SummaryUpdate struct {
+	ID      string
+	Summary string
+	Model   string
+}
+
+// VectorUpdate replaces the summary vector of a chunk.
+type VectorUpdate struct {
+	ID     string
+	Vector []float32
+	Model  string
+}
+
+// ListStaleSummaries returns up to limit chunks, ordered by id and starting
+// after afterID, whose summary was not produced by model.
+func (s *Store) ListStaleSummaries(ctx context.Context, model, afterID string, limit int) ([]StaleChunk, error) {
+	const q = `
+      SELECT id, path, COALESCE(language, ''), COALESCE(summary, ''), COALESCE(content, '')
+      FROM chunks
+      WHERE summary_model IS DISTINCT FROM $1
+        AND deleted_at IS NULL
+        AND id > $2
+      ORDER BY id
+      LIMIT $3`
+	return s.listStale(ctx, q, model, afterID, limit)
+}
+
+// ListStaleVectors returns up to limit summarized chunks, ordered by id and
+// starting after afterID, whose summary vector was not produced by model.
+func (s *Store) ListStaleVectors(ctx context.Context, model, afterID string, limit int) ([]StaleChunk, error) {
+	const q = `
+      SELECT id, path, COALESCE(language, ''), summary, COALESCE(content, '')
+      FROM chunks
+      WHERE embed_model IS DISTINCT FROM $1
+        AND summary IS NOT NULL AND summary <> ''
+        AND deleted_at IS NULL
+        AND id > $2
+      ORDER BY id
+      LIMIT $3`
+	return s.listStale(ctx, q, model, afterID, limit)
+}
+
+func (s *Store) listStale(ctx context.Context, q, model, afterID string, limit int) ([]StaleChunk, error) {
+	rows, err := s.pool.Query(ctx, q, model, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []StaleChunk
+	for rows.Next() {
+		var c StaleChunk
+		if err := rows.Scan(&c.ID, &c.Path, &c.Language, &c.Summary, &c.Content); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// UpdateSummaries writes new summaries in a single batch. Because the summary
+// vector no longer matches, its embed model is cleared so the chunk is picked
+// up by the next re-embed pass.
+func (s *Store) UpdateSummaries(ctx context.Context, updates []SummaryUpdate) error {
+	const q = `
+      UPDATE chunks
+      SET summary = $2, summary_model = $3, summarized_at = now(), embed_model = NULL
+      WHERE id = $1`
+	b := &pgx.Batch{}
+	for _, u := range updates {
+		b.Queue(q, u.ID, u.Summary, u.Model)
+	}
+	return s.pool.SendBatch(ctx, b).Close()
+}
+
+// UpdateVectors writes new summary vectors in a single batch.
+func (s *Store) UpdateVectors(ctx context.Context, updates []VectorUpdate) error {
+	if s.vectors != nil {
+		return s.updateExternalVectors(ctx, updates)
+	}
+	const q = `
+      UPDATE chunks
+      SET summary_vec = $2, embed_model = $3
+      WHERE id = $1`
+	b := &pgx.Batch{}
+	for _, u := range updates {
+		b.Queue(q, u.ID, pgvector.NewVector(u.Vector), u.Model)
+	}
+	return s.pool.SendBatch(ctx, b).Close()
+}
+
+// updateExternalVectors writes vectors to the external index and records
+// their embed model in Postgres.
+func (s *Store) updateExternalVectors(ctx context.Context, updates []VectorUpdate) error {
+	ids := make([]string, len(updates))
+	vecs := make(map[string][]float32, len(updates))
+	for i, u := range updates {
+		ids[i] = u.ID
+		vecs[u.ID] = u.Vector
+	}
+	rows, err := s.pool.Query(ctx,
+		`SELECT id, repository, ref, path, COALESCE(language, '') FROM chunks WHERE id = ANY($1)`, ids)
+	if err != nil {
+		return err
+	}
+	var points []VectorPoint
+	for rows.Next() {
+		var p VectorPoint
+		if err := rows.Scan(&p.ID, &p.Repository, &p.Ref, &p.Path, &p.Language); err != nil {
+			rows.Close()
+			return err
+		}
+		p.Vector = vecs[p.ID]
+		points = append(points, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := s.vectors.Upsert(ctx, points); err != nil {
+		return fmt.Errorf("vector index: %w", err)
+	}
+
+	b := &pgx.Batch{}
+	for _, u := range updates {
+		b.Queue(`UPDATE chunks SET embed_model = $2 WHERE id = $1`, u.ID, u.Model)
+	}
+	return s.pool.SendBatch(ctx, b).Close()
+}
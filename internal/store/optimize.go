@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Optimization levels for Optimize.
+const (
+	OptimizeAnalyze = "analyze" // refresh planner statistics and reclaim space
+	OptimizeReindex = "reindex" // also rebuild the vector indexes
+)
+
+// ParseOptimize validates an optimization level. The empty string means no
+// optimization.
+func ParseOptimize(level string) (string, error) {
+	switch l := strings.ToLower(strings.TrimSpace(level)); l {
+	case "", "none":
+		return "", nil
+	case OptimizeAnalyze, OptimizeReindex:
+		return l, nil
+	default:
+		return "", fmt.Errorf("unsupported optimize level: %s (expected none, analyze or reindex)", level)
+	}
+}
+
+// Optimize refreshes table statistics and reclaims space left by updates
+// after large indexing runs. With reindex the vector indexes are rebuilt as
+// well, which restores the recall of an ivfflat index whose lists were
+// trained on a much smaller table. Unlike Vacuum it removes nothing.
+func (s *Store) Optimize(ctx context.Context, reindex bool) error {
+	qs := []string{
+		`VACUUM (ANALYZE) chunks`,
+		`VACUUM (ANALYZE) rollups`,
+		`VACUUM (ANALYZE) symbols`,
+	}
+	if reindex {
+		for _, name := range []string{"chunks_summary_vec_idx", "rollups_summary_vec_idx"} {
+			var exists bool
+			if err := s.pool.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL`, name).Scan(&exists); err != nil {
+				return err
+			}
+			if exists {
+				qs = append(qs, `REINDEX INDEX CONCURRENTLY `+name)
+			}
+		}
+	}
+	// Neither statement can run in a transaction, so each is sent on its own.
+	for _, q := range qs {
+		if _, err := s.pool.Exec(ctx, q); err != nil {
+			return fmt.Errorf("%s: %w", q, err)
+		}
+	}
+	return nil
+}
+
+// Optimize refreshes the query planner statistics and, with reindex,
+// rebuilds every index.
+func (s *SQLiteStore) Optimize(ctx context.Context, reindex bool) error {
+	q := `ANALYZE`
+	if reindex {
+		q = `REINDEX; ANALYZE`
+	}
+	_, err := s.db.ExecContext(ctx, q)
+	return err
+}
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PoolOptions tunes the Postgres connection pools. Zero fields keep the
+// value from the DSN, or the pgx default.
+type PoolOptions struct {
+	MaxConns          int
+	MinConns          int
+	MaxConnLifetime   time.Duration
+	HealthCheckPeriod time.Duration
+	// StatementTimeout aborts any statement running longer than this.
+	StatementTimeout time.Duration
+}
+
+// WithPoolOptions tunes the primary pool and the read replica pool, if any.
+func WithPoolOptions(o PoolOptions) Option {
+	return func(s *Store) { s.poolOpts = o }
+}
+
+// apply sets the non-zero options on cfg.
+func (o PoolOptions) apply(cfg *pgxpool.Config) {
+	if o.MaxConns > 0 {
+		cfg.MaxConns = int32(o.MaxConns)
+	}
+	if o.MinConns > 0 {
+		cfg.MinConns = int32(o.MinConns)
+	}
+	if o.MaxConnLifetime > 0 {
+		cfg.MaxConnLifetime = o.MaxConnLifetime
+	}
+	if o.HealthCheckPeriod > 0 {
+		cfg.HealthCheckPeriod = o.HealthCheckPeriod
+	}
+	if o.StatementTimeout > 0 {
+		cfg.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(o.StatementTimeout.Milliseconds(), 10)
+	}
+}
@@ -0,0 +1,235 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultQdrantCollection is the collection used when none is configured.
+const DefaultQdrantCollection = "reposearch_chunks"
+
+// Qdrant is a VectorIndex backed by a Qdrant collection, accessed through
+// its REST API.
+type Qdrant struct {
+	baseURL    string
+	apiKey     string
+	collection string
+	http       *http.Client
+}
+
+// NewQdrant creates a client for the collection at baseURL, e.g.
+// "http://localhost:6333". apiKey may be empty.
+func NewQdrant(baseURL, apiKey, collection string) (*Qdrant, error) {
+	if baseURL == "" {
+		return nil, errors.New("qdrant url is required")
+	}
+	if collection == "" {
+		collection = DefaultQdrantCollection
+	}
+	return &Qdrant{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		collection: collection,
+		http:       &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// EnsureCollection creates the collection with cosine distance if it does
+// not exist yet, or checks that an existing one holds vectors of size dim.
+func (q *Qdrant) EnsureCollection(ctx context.Context, dim int) error {
+	var out struct {
+		Result struct {
+			Config struct {
+				Params struct {
+					Vectors struct {
+						Size int `json:"size"`
+					} `json:"vectors"`
+				} `json:"params"`
+			} `json:"config"`
+		} `json:"result"`
+	}
+	err := q.do(ctx, http.MethodGet, "", nil, &out)
+	if err == nil {
+		if have := out.Result.Config.Params.Vectors.Size; have > 0 && have != dim {
+			return dimensionMismatch("qdrant collection "+q.collection, have, dim)
+		}
+		return nil
+	}
+	var se *qdrantError
+	if !errors.As(err, &se) || se.status != http.StatusNotFound {
+		return err
+	}
+	body := map[string]any{"vectors": map[string]any{"size": dim, "distance": "Cosine"}}
+	return q.do(ctx, http.MethodPut, "", body, nil)
+}
+
+// Upsert writes points, replacing any with the same chunk ID.
+func (q *Qdrant) Upsert(ctx context.Context, points []VectorPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+	ps := make([]map[string]any, len(points))
+	for i, p := range points {
+		ps[i] = map[string]any{
+			"id":     qdrantPointID(p.ID),
+			"vector": p.Vector,
+			"payload": map[string]any{
+				"chunk_id":   p.ID,
+				"repository": p.Repository,
+				"ref":        p.Ref,
+				"path":       p.Path,
+				"language":   p.Language,
+			},
+		}
+	}
+	return q.do(ctx, http.MethodPut, "/points?wait=true", map[string]any{"points": ps}, nil)
+}
+
+// Get returns the vector of a chunk.
+func (q *Qdrant) Get(ctx context.Context, id string) ([]float32, bool, error) {
+	var out struct {
+		Result []struct {
+			Vector []float32 `json:"vector"`
+		} `json:"result"`
+	}
+	body := map[string]any{"ids": []string{qdrantPointID(id)}, "with_vector": true, "with_payload": false}
+	if err := q.do(ctx, http.MethodPost, "/points", body, &out); err != nil {
+		return nil, false, err
+	}
+	if len(out.Result) == 0 || len(out.Result[0].Vector) == 0 {
+		return nil, false, nil
+	}
+	return out.Result[0].Vector, true, nil
+}
+
+// Search returns the k nearest chunks to vec.
+func (q *Qdrant) Search(ctx context.Context, vec []float32, k int, f VectorFilter) ([]VectorHit, error) {
+	body := map[string]any{"vector": vec, "limit": k, "with_payload": []string{"chunk_id"}}
+	if filter := qdrantFilter(f); filter != nil {
+		body["filter"] = filter
+	}
+	var out struct {
+		Result []struct {
+			Score   float64 `json:"score"`
+			Payload struct {
+				ChunkID string `json:"chunk_id"`
+			} `json:"payload"`
+		} `json:"result"`
+	}
+	if err := q.do(ctx, http.MethodPost, "/points/search", body, &out); err != nil {
+		return nil, err
+	}
+	hits := make([]VectorHit, 0, len(out.Result))
+	for _, r := range out.Result {
+		hits = append(hits, VectorHit{ID: r.Payload.ChunkID, Score: r.Score})
+	}
+	return hits, nil
+}
+
+// Delete removes every point matching f.
+func (q *Qdrant) Delete(ctx context.Context, f VectorFilter) error {
+	filter := qdrantFilter(f)
+	if filter == nil {
+		return errors.New("refusing to delete all points without a filter")
+	}
+	return q.do(ctx, http.MethodPost, "/points/delete?wait=true", map[string]any{"filter": filter}, nil)
+}
+
+func qdrantFilter(f VectorFilter) map[string]any {
+	var must []map[string]any
+	match := func(key string, values []string) {
+		switch len(values) {
+		case 0:
+		case 1:
+			must = append(must, map[string]any{"key": key, "match": map[string]any{"value": values[0]}})
+		default:
+			must = append(must, map[string]any{"key": key, "match": map[string]any{"any": values}})
+		}
+	}
+	match("repository", f.Repositories)
+	if f.Ref != "" {
+		match("ref", []string{f.Ref})
+	}
+	match("language", f.Languages)
+	if f.Path != "" {
+		match("path", []string{f.Path})
+	}
+	if len(f.IDs) > 0 {
+		ids := make([]string, len(f.IDs))
+		for i, id := range f.IDs {
+			ids[i] = qdrantPointID(id)
+		}
+		must = append(must, map[string]any{"has_id": ids})
+	}
+	if must == nil {
+		return nil
+	}
+	return map[string]any{"must": must}
+}
+
+// qdrantPointID maps a chunk ID onto a UUID, as Qdrant only accepts
+// unsigned integers and UUIDs as point IDs.
+func qdrantPointID(id string) string {
+	h := sha1.Sum([]byte(id))
+	return fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
+
+// qdrantError is a non-2xx response from Qdrant.
+type qdrantError struct {
+	status int
+	msg    string
+}
+
+func (e *qdrantError) Error() string { return fmt.Sprintf("qdrant: %d %s", e.status, e.msg) }
+
+// do sends a request to the collection endpoint plus path and decodes the
+// response into out when it is non-nil.
+func (q *Qdrant) do(ctx context.Context, method, path string, body, out any) error {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+	u := q.baseURL + "/collections/" + url.PathEscape(q.collection) + path
+	req, err := http.NewRequestWithContext(ctx, method, u, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if q.apiKey != "" {
+		req.Header.Set("api-key", q.apiKey)
+	}
+
+	resp, err := q.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var e struct {
+			Status struct {
+				Error string `json:"error"`
+			} `json:"status"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		msg := e.Status.Error
+		if msg == "" {
+			msg = http.StatusText(resp.StatusCode)
+		}
+		return &qdrantError{status: resp.StatusCode, msg: msg}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,130 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQdrant_EnsureCollection(t *testing.T) {
+	var created map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/collections/chunks" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("api-key") != "secret" {
+			t.Errorf("missing api key header")
+		}
+		switch r.Method {
+		case http.MethodGet:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"status":{"error":"Not found"}}`))
+		case http.MethodPut:
+			_ = json.NewDecoder(r.Body).Decode(&created)
+			_, _ = w.Write([]byte(`{"result":true,"status":"ok"}`))
+		}
+	}))
+	defer srv.Close()
+
+	q, err := NewQdrant(srv.URL+"/", "secret", "chunks")
+	if err != nil {
+		t.Fatalf("NewQdrant: %v", err)
+	}
+	if err := q.EnsureCollection(context.Background(), 3); err != nil {
+		t.Fatalf("EnsureCollection: %v", err)
+	}
+	vectors, _ := created["vectors"].(map[string]any)
+	if vectors["size"] != float64(3) || vectors["distance"] != "Cosine" {
+		t.Errorf("unexpected collection config: %v", created)
+	}
+}
+
+func TestQdrant_EnsureCollectionDimensionMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("unexpected %s", r.Method)
+		}
+		_, _ = w.Write([]byte(`{"result":{"config":{"params":{"vectors":{"size":768,"distance":"Cosine"}}}},"status":"ok"}`))
+	}))
+	defer srv.Close()
+
+	q, _ := NewQdrant(srv.URL, "", "chunks")
+	if err := q.EnsureCollection(context.Background(), 768); err != nil {
+		t.Fatalf("EnsureCollection: %v", err)
+	}
+	if err := q.EnsureCollection(context.Background(), 1536); !errors.Is(err, ErrDimensionMismatch) {
+		t.Fatalf("expected ErrDimensionMismatch, got %v", err)
+	}
+}
+
+func TestQdrant_UpsertSearchDelete(t *testing.T) {
+	var upserted, searched, deleted map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/collections/reposearch_chunks/points":
+			_ = json.NewDecoder(r.Body).Decode(&upserted)
+			_, _ = w.Write([]byte(`{"status":"ok"}`))
+		case "/collections/reposearch_chunks/points/search":
+			_ = json.NewDecoder(r.Body).Decode(&searched)
+			_, _ = w.Write([]byte(`{"result":[{"id":"x","score":0.9,"payload":{"chunk_id":"c1"}}],"status":"ok"}`))
+		case "/collections/reposearch_chunks/points/delete":
+			_ = json.NewDecoder(r.Body).Decode(&deleted)
+			_, _ = w.Write([]byte(`{"status":"ok"}`))
+		default:
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	q, _ := NewQdrant(srv.URL, "", "")
+
+	err := q.Upsert(ctx, []VectorPoint{{ID: "c1", Repository: "repo", Ref: "main", Path: "a.go", Vector: []float32{1, 0}}})
+	if err != nil {
+		t.Fatalf("Upsert: %v", err)
+	}
+	points, _ := upserted["points"].([]any)
+	if len(points) != 1 || points[0].(map[string]any)["id"] != qdrantPointID("c1") {
+		t.Errorf("unexpected upsert body: %v", upserted)
+	}
+
+	hits, err := q.Search(ctx, []float32{1, 0}, 5, VectorFilter{Repositories: []string{"repo"}, Languages: []string{"go", "shell"}})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(hits) != 1 || hits[0].ID != "c1" || hits[0].Score != 0.9 {
+		t.Errorf("unexpected hits: %+v", hits)
+	}
+	if searched["filter"] == nil || searched["limit"] != float64(5) {
+		t.Errorf("unexpected search body: %v", searched)
+	}
+
+	if err := q.Delete(ctx, VectorFilter{}); err == nil {
+		t.Error("expected an unfiltered delete to be refused")
+	}
+	if err := q.Delete(ctx, VectorFilter{Repositories: []string{"repo"}, Ref: "main"}); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	must, _ := deleted["filter"].(map[string]any)["must"].([]any)
+	if len(must) != 2 {
+		t.Errorf("unexpected delete filter: %v", deleted)
+	}
+
+	if err := q.Delete(ctx, VectorFilter{IDs: []string{"c1"}}); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	must, _ = deleted["filter"].(map[string]any)["must"].([]any)
+	if ids, _ := must[0].(map[string]any)["has_id"].([]any); len(ids) != 1 || ids[0] != qdrantPointID("c1") {
+		t.Errorf("unexpected delete by id filter: %v", deleted)
+	}
+}
+
+func TestQdrantPointID(t *testing.T) {
+	id := qdrantPointID("abc")
+	if len(id) != 36 || id != qdrantPointID("abc") || id == qdrantPointID("abd") {
+		t.Errorf("unexpected point id %q", id)
+	}
+}
@@ -0,0 +1,178 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// QueryLog is one search served by the API. Only the hash of Query is
+// stored unless KeepText is set.
+type QueryLog struct {
+	Query    string
+	KeepText bool
+	Filters  string // the other request parameters, URL-encoded
+	K        int
+	Latency  time.Duration
+	Results  int
+	User     string // login of the user, empty when anonymous
+	At       time.Time
+}
+
+// queryLogLimit caps the queries returned per list by QueryStats.
+const queryLogLimit = 20
+
+// queryLogSchema is shared by Postgres and SQLite; created_at is always
+// written by the application, in UTC.
+const queryLogSchema = `
+CREATE TABLE IF NOT EXISTS query_logs (
+  query_hash TEXT NOT NULL,
+  query      TEXT NOT NULL, -- empty unless the text is kept
+  filters    TEXT NOT NULL DEFAULT '',
+  k          INT NOT NULL,
+  latency_ms INT NOT NULL,
+  results    INT NOT NULL,
+  user_login TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE INDEX IF NOT EXISTS query_logs_created_idx ON query_logs (created_at);
+`
+
+// queryHash identifies a query regardless of case and spacing, so that the
+// same search typed differently is counted once.
+func queryHash(q string) string {
+	h := sha256.Sum256([]byte(strings.ToLower(strings.Join(strings.Fields(q), " "))))
+	return hex.EncodeToString(h[:16])
+}
+
+func (l QueryLog) args() []any {
+	at := l.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+	text := ""
+	if l.KeepText {
+		text = l.Query
+	}
+	return []any{queryHash(l.Query), text, l.Filters, l.K, l.Latency.Milliseconds(), l.Results, l.User, at.UTC()}
+}
+
+// LogQuery records a served search.
+func (s *Store) LogQuery(ctx context.Context, l QueryLog) error {
+	_, err := s.pool.Exec(ctx, `
+      INSERT INTO query_logs (query_hash, query, filters, k, latency_ms, results, user_login, created_at)
+      VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`, l.args()...)
+	return err
+}
+
+// QueryStats aggregates the searches logged since the given time: totals,
+// the most frequent queries and the most frequent queries that returned
+// nothing. Queries logged without their text are listed by hash.
+func (s *Store) QueryStats(ctx context.Context, since time.Time) (models.QueryStats, error) {
+	stats := models.QueryStats{Since: since.UTC()}
+	var avg *float64
+	err := s.read.QueryRow(ctx, `
+      SELECT COUNT(*), COUNT(*) FILTER (WHERE results = 0), COUNT(DISTINCT user_login) FILTER (WHERE user_login <> ''), AVG(latency_ms)
+      FROM query_logs WHERE created_at >= $1`, since.UTC()).Scan(&stats.Queries, &stats.ZeroResults, &stats.Users, &avg)
+	if err != nil {
+		return stats, err
+	}
+	if avg != nil {
+		stats.AvgLatencyMS = *avg
+	}
+	for _, zero := range []bool{false, true} {
+		rows, err := s.read.Query(ctx, `
+      SELECT COALESCE(NULLIF(MAX(query), ''), query_hash), COUNT(*)
+      FROM query_logs
+      WHERE created_at >= $1 AND (NOT $2 OR results = 0)
+      GROUP BY query_hash
+      ORDER BY 2 DESC, 1
+      LIMIT $3`, since.UTC(), zero, queryLogLimit)
+		if err != nil {
+			return stats, err
+		}
+		counts, err := scanQueryCounts(rows)
+		rows.Close()
+		if err != nil {
+			return stats, err
+		}
+		if zero {
+			stats.TopZeroResults = counts
+		} else {
+			stats.TopQueries = counts
+		}
+	}
+	return stats, nil
+}
+
+// LogQuery records a served search.
+func (s *SQLiteStore) LogQuery(ctx context.Context, l QueryLog) error {
+	_, err := s.db.ExecContext(ctx, `
+      INSERT INTO query_logs (query_hash, query, filters, k, latency_ms, results, user_login, created_at)
+      VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, l.args()...)
+	return err
+}
+
+// QueryStats aggregates the searches logged since the given time: totals,
+// the most frequent queries and the most frequent queries that returned
+// nothing. Queries logged without their text are listed by hash.
+func (s *SQLiteStore) QueryStats(ctx context.Context, since time.Time) (models.QueryStats, error) {
+	stats := models.QueryStats{Since: since.UTC()}
+	var avg *float64
+	err := s.db.QueryRowContext(ctx, `
+      SELECT COUNT(*), COUNT(CASE WHEN results = 0 THEN 1 END), COUNT(DISTINCT NULLIF(user_login, '')), AVG(latency_ms)
+      FROM query_logs WHERE created_at >= ?`, since.UTC()).Scan(&stats.Queries, &stats.ZeroResults, &stats.Users, &avg)
+	if err != nil {
+		return stats, err
+	}
+	if avg != nil {
+		stats.AvgLatencyMS = *avg
+	}
+	for _, zero := range []bool{false, true} {
+		rows, err := s.db.QueryContext(ctx, `
+      SELECT COALESCE(NULLIF(MAX(query), ''), query_hash), COUNT(*)
+      FROM query_logs
+      WHERE created_at >= ? AND (NOT ? OR results = 0)
+      GROUP BY query_hash
+      ORDER BY 2 DESC, 1
+      LIMIT ?`, since.UTC(), zero, queryLogLimit)
+		if err != nil {
+			return stats, err
+		}
+		counts, err := scanQueryCounts(rows)
+		_ = rows.Close()
+		if err != nil {
+			return stats, err
+		}
+		if zero {
+			stats.TopZeroResults = counts
+		} else {
+			stats.TopQueries = counts
+		}
+	}
+	return stats, nil
+}
+
+// rowScanner is the part of pgx.Rows and *sql.Rows used to read results.
+type rowScanner interface {
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+}
+
+// scanQueryCounts reads (query, count) rows.
+func scanQueryCounts(rows rowScanner) ([]models.QueryCount, error) {
+	counts := []models.QueryCount{}
+	for rows.Next() {
+		var c models.QueryCount
+		if err := rows.Scan(&c.Query, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
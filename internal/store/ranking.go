@@ -0,0 +1,122 @@
+package store
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// Ranking tunes weighted fusion: the weights of its signals, with and
+// without a query embedding, and what the script bias and noise penalty
+// signals look for. A query containing any of ScriptTerms asks for scripts,
+// which lifts chunks in ScriptLanguages and sinks those in
+// NonScriptLanguages; paths matching NoisePattern are penalized.
+type Ranking struct {
+	Hybrid             models.ScoreWeights
+	Lexical            models.ScoreWeights
+	ScriptTerms        []string
+	ScriptLanguages    []string
+	NonScriptLanguages []string
+	NoisePattern       string
+
+	noise *regexp.Regexp
+}
+
+// DefaultRanking returns the ranking used when none is configured.
+func DefaultRanking() Ranking {
+	r, _ := NewRanking(Ranking{
+		Hybrid:             hybridWeights,
+		Lexical:            lexicalWeights,
+		ScriptTerms:        []string{"script", "bash", "shell", "code", "program", "python", "cli"},
+		ScriptLanguages:    []string{"shell", "bash", "sh", "python", "py", "go"},
+		NonScriptLanguages: []string{"yaml", "terraform", "tf", "json"},
+		NoisePattern:       `(?:(^|.*/))(sample|example|test|mock|fixture|tmp|temp|sandbox)(/|\.|$)`,
+	})
+	return r
+}
+
+// NewRanking validates r and compiles its noise pattern. Terms and languages
+// are matched case-insensitively; an empty NoisePattern penalizes nothing.
+func NewRanking(r Ranking) (Ranking, error) {
+	for name, w := range map[string]models.ScoreWeights{"hybrid": r.Hybrid, "lexical": r.Lexical} {
+		if w.Semantic < 0 || w.Lexical < 0 || w.Trigram < 0 || w.ScriptBias < 0 || w.NoisePenalty < 0 {
+			return Ranking{}, fmt.Errorf("%s ranking weights must not be negative: %+v", name, w)
+		}
+	}
+	r.ScriptTerms = lowerAll(r.ScriptTerms)
+	r.ScriptLanguages = lowerAll(r.ScriptLanguages)
+	r.NonScriptLanguages = lowerAll(r.NonScriptLanguages)
+	r.noise = nil
+	if r.NoisePattern != "" {
+		re, err := regexp.Compile(r.NoisePattern)
+		if err != nil {
+			return Ranking{}, fmt.Errorf("invalid noise pattern: %w", err)
+		}
+		r.noise = re
+	}
+	return r, nil
+}
+
+// WithRanking sets the ranking of weighted fusion. Unlike the other
+// options it applies to SQLite too.
+func WithRanking(r Ranking) Option {
+	return func(s *Store) { s.ranking = r }
+}
+
+// weights returns the weights of weighted fusion.
+func (r Ranking) weights(lexicalOnly bool) models.ScoreWeights {
+	if lexicalOnly {
+		return r.Lexical
+	}
+	return r.Hybrid
+}
+
+// askedForScript reports whether the lowercased query lq asks for scripts.
+func (r Ranking) askedForScript(lq string) bool {
+	for _, t := range r.ScriptTerms {
+		if strings.Contains(lq, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// scriptBias is the script bias signal of a chunk in language.
+func (r Ranking) scriptBias(asked bool, language string) float64 {
+	if !asked {
+		return 0
+	}
+	language = strings.ToLower(language)
+	for _, l := range r.ScriptLanguages {
+		if l == language {
+			return 1
+		}
+	}
+	for _, l := range r.NonScriptLanguages {
+		if l == language {
+			return -1
+		}
+	}
+	return 0
+}
+
+// noisePenalty is the noise penalty signal of a chunk at path.
+func (r Ranking) noisePenalty(path string) float64 {
+	if r.noise != nil && r.noise.MatchString(strings.ToLower(path)) {
+		return 1
+	}
+	return 0
+}
+
+// lowerAll returns the non-empty values of vs, trimmed and lowercased.
+func lowerAll(vs []string) []string {
+	out := make([]string, 0, len(vs))
+	for _, v := range vs {
+		if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
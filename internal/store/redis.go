@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisIdleConns is the number of idle connections kept for reuse.
+const redisIdleConns = 8
+
+// redisTimeout bounds dialing and each command, so that a slow cache
+// degrades to the backend rather than holding up requests.
+const redisTimeout = 2 * time.Second
+
+// Redis is a client for the handful of Redis commands used by the result
+// cache.
+type Redis struct {
+	client *redis.Client
+}
+
+// NewRedis creates a client for a URL like
+// redis://[[user]:password@]host:port[/db], or rediss:// for TLS. A user
+// authenticates as that ACL user; without one, the password is that of the
+// default user. Connections are opened lazily.
+func NewRedis(rawURL string) (*Redis, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+	if (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+		return nil, fmt.Errorf("invalid redis url %q (expected redis://host:port/db or rediss://host:port/db)", rawURL)
+	}
+	opt, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+	opt.DialTimeout = redisTimeout
+	opt.ReadTimeout = redisTimeout
+	opt.WriteTimeout = redisTimeout
+	opt.MaxIdleConns = redisIdleConns
+	opt.DisableIdentity = true
+	return &Redis{client: redis.NewClient(opt)}, nil
+}
+
+// Get returns the value of key, or false when it does not exist.
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	b, err := r.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// Set stores value under key for ttl.
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, key, value, ttl).Err()
+}
+
+// Incr increments each counter in keys.
+func (r *Redis) Incr(ctx context.Context, keys ...string) error {
+	_, err := r.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, k := range keys {
+			p.Incr(ctx, k)
+		}
+		return nil
+	})
+	return err
+}
+
+// MGet returns the values of keys, with "" for missing ones.
+func (r *Redis) MGet(ctx context.Context, keys ...string) ([]string, error) {
+	vs, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, len(vs))
+	for i, v := range vs {
+		if s, ok := v.(string); ok {
+			out[i] = s
+		}
+	}
+	return out, nil
+}
+
+// Close closes the client's connections.
+func (r *Redis) Close() {
+	_ = r.client.Close()
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestRedis(t *testing.T) {
+	m := miniredis.RunT(t)
+	m.RequireUserAuth("cache", "secret")
+	ctx := context.Background()
+
+	r, err := NewRedis("redis://cache:secret@" + m.Addr() + "/2")
+	if err != nil {
+		t.Fatalf("NewRedis: %v", err)
+	}
+	defer r.Close()
+
+	if _, ok, err := r.Get(ctx, "missing"); ok || err != nil {
+		t.Errorf("Get(missing) = %v, %v", ok, err)
+	}
+	if err := r.Set(ctx, "k", []byte("v\r\nwith newline"), time.Minute); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if b, ok, err := r.Get(ctx, "k"); !ok || err != nil || string(b) != "v\r\nwith newline" {
+		t.Errorf("Get(k) = %q, %v, %v", b, ok, err)
+	}
+	if err := r.Incr(ctx, "a", "a", "b"); err != nil {
+		t.Fatalf("Incr: %v", err)
+	}
+	vs, err := r.MGet(ctx, "a", "b", "c")
+	if err != nil || len(vs) != 3 || vs[0] != "2" || vs[1] != "1" || vs[2] != "" {
+		t.Errorf("MGet = %v, %v", vs, err)
+	}
+	if v, _ := m.DB(2).Get("a"); v != "2" {
+		t.Errorf("expected the counter in database 2, got %q", v)
+	}
+	if ttl := m.DB(2).TTL("k"); ttl != time.Minute {
+		t.Errorf("TTL(k) = %v", ttl)
+	}
+
+	for _, u := range []string{"redis://cache:wrong@", "redis://:secret@"} {
+		bad, _ := NewRedis(u + m.Addr())
+		if err := bad.Set(ctx, "k", nil, time.Minute); err == nil {
+			t.Errorf("%s: expected an authentication error", u)
+		}
+		bad.Close()
+	}
+
+	for _, u := range []string{"http://localhost:6379", "redis://", "redis://localhost/x", "unix:///tmp/redis.sock"} {
+		if _, err := NewRedis(u); err == nil {
+			t.Errorf("expected an error for %q", u)
+		}
+	}
+}
+
+func TestRedis_TLS(t *testing.T) {
+	// Borrow the test certificate for 127.0.0.1 that httptest serves.
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	cert := srv.TLS.Certificates
+	roots := srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	srv.Close()
+
+	m, err := miniredis.RunTLS(&tls.Config{Certificates: cert})
+	if err != nil {
+		t.Fatalf("RunTLS: %v", err)
+	}
+	defer m.Close()
+	m.RequireAuth("secret")
+
+	r, err := NewRedis("rediss://:secret@" + m.Addr())
+	if err != nil {
+		t.Fatalf("NewRedis: %v", err)
+	}
+	defer r.Close()
+	r.client.Options().TLSConfig.RootCAs = roots
+
+	ctx := context.Background()
+	if err := r.Set(ctx, "k", []byte("v"), time.Minute); err != nil {
+		t.Fatalf("Set over TLS: %v", err)
+	}
+	if b, ok, err := r.Get(ctx, "k"); !ok || err != nil || string(b) != "v" {
+		t.Errorf("Get(k) = %q, %v, %v", b, ok, err)
+	}
+}
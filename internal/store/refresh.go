@@ -0,0 +1,162 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// refreshTokenSchema is shared by Postgres and SQLite. Only the SHA-256
+// hash of a token is stored.
+const refreshTokenSchema = `
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+  token_hash TEXT PRIMARY KEY,
+  family     TEXT NOT NULL,
+  user_data  TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP NOT NULL,
+  used_at    TIMESTAMP,
+  revoked_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON refresh_tokens (family);
+`
+
+const refreshTokenColumns = `family, user_data, created_at, expires_at, used_at, revoked_at`
+
+// ErrRefreshTokenReused is returned by UseRefreshToken for a token that was
+// already used. Its family has been revoked, since either the token or its
+// replacement may be in the wrong hands.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// CreateRefreshToken stores a new refresh token under the hash of its
+// secret.
+func (s *Store) CreateRefreshToken(ctx context.Context, t models.RefreshToken, hash string) error {
+	_, err := s.pool.Exec(ctx, `
+      INSERT INTO refresh_tokens (token_hash, family, user_data, created_at, expires_at)
+      VALUES ($1, $2, $3, $4, $5)`,
+		hash, t.Family, t.User, t.CreatedAt.UTC(), t.ExpiresAt.UTC())
+	return err
+}
+
+// UseRefreshToken marks the unused, unrevoked, unexpired refresh token with
+// the given hash as used at the given time and returns it. It reports false
+// when there is none, and returns ErrRefreshTokenReused, revoking the
+// token's family, when the token was already used.
+func (s *Store) UseRefreshToken(ctx context.Context, hash string, at time.Time) (models.RefreshToken, bool, error) {
+	rows, err := s.pool.Query(ctx, `
+      UPDATE refresh_tokens SET used_at = $2
+      WHERE token_hash = $1 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > $2
+      RETURNING `+refreshTokenColumns, hash, at.UTC())
+	if err != nil {
+		return models.RefreshToken{}, false, err
+	}
+	t, ok, err := firstRefreshToken(scanRefreshTokens(rows))
+	rows.Close()
+	if err != nil || ok {
+		return t, ok, err
+	}
+	var family string
+	err = s.pool.QueryRow(ctx, `
+      SELECT family FROM refresh_tokens
+      WHERE token_hash = $1 AND used_at IS NOT NULL AND revoked_at IS NULL`, hash).Scan(&family)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.RefreshToken{}, false, nil
+	}
+	if err != nil {
+		return models.RefreshToken{}, false, err
+	}
+	if err := s.RevokeRefreshTokens(ctx, family, at); err != nil {
+		return models.RefreshToken{}, false, err
+	}
+	return models.RefreshToken{}, false, ErrRefreshTokenReused
+}
+
+// RevokeRefreshTokens revokes every token of a refresh token family, e.g.
+// when its user signs out.
+func (s *Store) RevokeRefreshTokens(ctx context.Context, family string, at time.Time) error {
+	_, err := s.pool.Exec(ctx, `UPDATE refresh_tokens SET revoked_at = $2 WHERE family = $1 AND revoked_at IS NULL`, family, at.UTC())
+	return err
+}
+
+// CreateRefreshToken stores a new refresh token under the hash of its
+// secret.
+func (s *SQLiteStore) CreateRefreshToken(ctx context.Context, t models.RefreshToken, hash string) error {
+	_, err := s.db.ExecContext(ctx, `
+      INSERT INTO refresh_tokens (token_hash, family, user_data, created_at, expires_at)
+      VALUES (?, ?, ?, ?, ?)`,
+		hash, t.Family, t.User, t.CreatedAt.UTC(), t.ExpiresAt.UTC())
+	return err
+}
+
+// UseRefreshToken marks the unused, unrevoked, unexpired refresh token with
+// the given hash as used at the given time and returns it. It reports false
+// when there is none, and returns ErrRefreshTokenReused, revoking the
+// token's family, when the token was already used.
+func (s *SQLiteStore) UseRefreshToken(ctx context.Context, hash string, at time.Time) (models.RefreshToken, bool, error) {
+	at = at.UTC()
+	rows, err := s.db.QueryContext(ctx, `
+      UPDATE refresh_tokens SET used_at = ?
+      WHERE token_hash = ? AND used_at IS NULL AND revoked_at IS NULL AND expires_at > ?
+      RETURNING `+refreshTokenColumns, at, hash, at)
+	if err != nil {
+		return models.RefreshToken{}, false, err
+	}
+	t, ok, err := firstRefreshToken(scanRefreshTokens(rows))
+	_ = rows.Close()
+	if err != nil || ok {
+		return t, ok, err
+	}
+	var family string
+	err = s.db.QueryRowContext(ctx, `
+      SELECT family FROM refresh_tokens
+      WHERE token_hash = ? AND used_at IS NOT NULL AND revoked_at IS NULL`, hash).Scan(&family)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.RefreshToken{}, false, nil
+	}
+	if err != nil {
+		return models.RefreshToken{}, false, err
+	}
+	if err := s.RevokeRefreshTokens(ctx, family, at); err != nil {
+		return models.RefreshToken{}, false, err
+	}
+	return models.RefreshToken{}, false, ErrRefreshTokenReused
+}
+
+// RevokeRefreshTokens revokes every token of a refresh token family, e.g.
+// when its user signs out.
+func (s *SQLiteStore) RevokeRefreshTokens(ctx context.Context, family string, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE refresh_tokens SET revoked_at = ? WHERE family = ? AND revoked_at IS NULL`, at.UTC(), family)
+	return err
+}
+
+func scanRefreshTokens(rows rowScanner) ([]models.RefreshToken, error) {
+	out := []models.RefreshToken{}
+	for rows.Next() {
+		var t models.RefreshToken
+		var created, expires, used, revoked sql.NullTime
+		if err := rows.Scan(&t.Family, &t.User, &created, &expires, &used, &revoked); err != nil {
+			return nil, err
+		}
+		t.CreatedAt = created.Time
+		t.ExpiresAt = expires.Time
+		if used.Valid {
+			t.UsedAt = &used.Time
+		}
+		if revoked.Valid {
+			t.RevokedAt = &revoked.Time
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func firstRefreshToken(list []models.RefreshToken, err error) (models.RefreshToken, bool, error) {
+	if err != nil || len(list) == 0 {
+		return models.RefreshToken{}, false, err
+	}
+	return list[0], true, nil
+}
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// revocationSchema is shared by Postgres and SQLite. revoked_tokens holds
+// single access tokens by id until they expire; revoked_sessions holds, per
+// user, the time before which all of the user's tokens were revoked.
+const revocationSchema = `
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+  jti        TEXT PRIMARY KEY,
+  expires_at TIMESTAMP NOT NULL
+);
+CREATE TABLE IF NOT EXISTS revoked_sessions (
+  login      TEXT PRIMARY KEY,
+  revoked_at TIMESTAMP NOT NULL
+);
+`
+
+// RevokeToken revokes the access token with the given id until it expires,
+// forgetting tokens revoked earlier that have expired since.
+func (s *Store) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	if _, err := s.pool.Exec(ctx, `DELETE FROM revoked_tokens WHERE expires_at < $1`, time.Now().UTC()); err != nil {
+		return err
+	}
+	_, err := s.pool.Exec(ctx, `
+      INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2)
+      ON CONFLICT (jti) DO NOTHING`, jti, expiresAt.UTC())
+	return err
+}
+
+// RevokeSessions revokes every token issued to a user up to the given time.
+func (s *Store) RevokeSessions(ctx context.Context, login string, at time.Time) error {
+	_, err := s.pool.Exec(ctx, `
+      INSERT INTO revoked_sessions (login, revoked_at) VALUES ($1, $2)
+      ON CONFLICT (login) DO UPDATE SET revoked_at = excluded.revoked_at`, login, at.UTC())
+	return err
+}
+
+// TokenRevoked reports whether the token with the given id, issued to login
+// at issuedAt, has been revoked by RevokeToken or RevokeSessions.
+func (s *Store) TokenRevoked(ctx context.Context, jti, login string, issuedAt time.Time) (bool, error) {
+	var revoked bool
+	err := s.pool.QueryRow(ctx, `
+      SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+          OR EXISTS (SELECT 1 FROM revoked_sessions WHERE login = $2 AND revoked_at >= $3)`,
+		jti, login, issuedAt.UTC()).Scan(&revoked)
+	return revoked, err
+}
+
+// RevokeToken revokes the access token with the given id until it expires,
+// forgetting tokens revoked earlier that have expired since.
+func (s *SQLiteStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at < ?`, time.Now().UTC()); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `
+      INSERT INTO revoked_tokens (jti, expires_at) VALUES (?, ?)
+      ON CONFLICT (jti) DO NOTHING`, jti, expiresAt.UTC())
+	return err
+}
+
+// RevokeSessions revokes every token issued to a user up to the given time.
+func (s *SQLiteStore) RevokeSessions(ctx context.Context, login string, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+      INSERT INTO revoked_sessions (login, revoked_at) VALUES (?, ?)
+      ON CONFLICT (login) DO UPDATE SET revoked_at = excluded.revoked_at`, login, at.UTC())
+	return err
+}
+
+// TokenRevoked reports whether the token with the given id, issued to login
+// at issuedAt, has been revoked by RevokeToken or RevokeSessions.
+func (s *SQLiteStore) TokenRevoked(ctx context.Context, jti, login string, issuedAt time.Time) (bool, error) {
+	var revoked bool
+	err := s.db.QueryRowContext(ctx, `
+      SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = ?)
+          OR EXISTS (SELECT 1 FROM revoked_sessions WHERE login = ? AND revoked_at >= ?)`,
+		jti, login, issuedAt.UTC()).Scan(&revoked)
+	return revoked, err
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	pgvector "github.com/pgvector/pgvector-go"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// Rollup kinds.
+const (
+	RollupFile = "file"
+	RollupDir  = "dir"
+)
+
+// RollupStore defines the methods for file- and directory-level summaries.
+type RollupStore interface {
+	GetRollupMeta(ctx context.Context, repository, ref, kind, path string) (RollupMeta, bool, error)
+	UpsertRollup(ctx context.Context, r models.Rollup, summaryVec []float32, inputHash string) error
+	SearchRollups(ctx context.Context, summaryVec []float32, k int, kind string, opt QueryOpts) ([]models.RollupResult, error)
+}
+
+// RollupMeta holds the stored state of a rollup used to skip unchanged ones.
+type RollupMeta struct {
+	InputHash string
+	Summary   string
+}
+
+// GetRollupMeta returns the input hash and summary of a stored rollup.
+func (s *Store) GetRollupMeta(ctx context.Context, repository, ref, kind, path string) (RollupMeta, bool, error) {
+	const q = `
+      SELECT COALESCE(input_hash, ''), COALESCE(summary, '')
+      FROM rollups
+      WHERE repository = $1 AND ref = $2 AND kind = $3 AND path = $4
+        AND deleted_at IS NULL`
+	var m RollupMeta
+	err := s.pool.QueryRow(ctx, q, repository, ref, kind, path).Scan(&m.InputHash, &m.Summary)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return RollupMeta{}, false, nil
+		}
+		return RollupMeta{}, false, err
+	}
+	return m, true, nil
+}
+
+// UpsertRollup inserts or updates a file or directory summary.
+func (s *Store) UpsertRollup(ctx context.Context, r models.Rollup, summaryVec []float32, inputHash string) error {
+	var sv any
+	if len(summaryVec) > 0 {
+		sv = pgvector.NewVector(summaryVec)
+	}
+	const q = `
+		INSERT INTO rollups (repository, ref, kind, path, summary, summary_vec, input_hash, updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7, now())
+		ON CONFLICT (repository, ref, kind, path) DO UPDATE SET
+			summary     = EXCLUDED.summary,
+			summary_vec = COALESCE(EXCLUDED.summary_vec, rollups.summary_vec),
+			input_hash  = EXCLUDED.input_hash,
+			updated_at  = now(),
+			deleted_at  = NULL;`
+	_, err := s.pool.Exec(ctx, q, r.Repository, r.Ref, r.Kind, r.Path, r.Summary, sv, inputHash)
+	return err
+}
+
+// SearchRollups ranks file or directory summaries by semantic similarity to
+// summaryVec. All filters except Language apply.
+func (s *Store) SearchRollups(ctx context.Context, summaryVec []float32, k int, kind string, opt QueryOpts) ([]models.RollupResult, error) {
+	if len(summaryVec) == 0 {
+		return []models.RollupResult{}, nil
+	}
+	args := []any{pgvector.NewVector(summaryVec), kind}
+	where, args := filterSQL("kind = $2 AND summary_vec IS NOT NULL AND deleted_at IS NULL", args, opt, false)
+	args = append(args, k)
+
+	q := fmt.Sprintf(`
+      SELECT repository, ref, kind, path, COALESCE(summary, ''), updated_at,
+             1.0 - (summary_vec <=> $1) AS score
+      FROM rollups
+      WHERE %s
+      ORDER BY summary_vec <=> $1
+      LIMIT $%d`, where, len(args))
+
+	rows, release, err := s.queryWithSetting(ctx, s.searchSetting(opt), q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer rows.Close()
+
+	out := []models.RollupResult{}
+	for rows.Next() {
+		var r models.RollupResult
+		if err := rows.Scan(
+			&r.Rollup.Repository, &r.Rollup.Ref, &r.Rollup.Kind, &r.Rollup.Path, &r.Rollup.Summary, &r.Rollup.UpdatedAt,
+			&r.Score,
+		); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,178 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// savedSearchSchema is shared by Postgres and SQLite. Filters are stored as
+// JSON.
+const savedSearchSchema = `
+CREATE TABLE IF NOT EXISTS saved_searches (
+  id         TEXT PRIMARY KEY,
+  user_login TEXT NOT NULL,
+  name       TEXT NOT NULL,
+  query      TEXT NOT NULL,
+  k          INT NOT NULL DEFAULT 0,
+  filters    TEXT NOT NULL DEFAULT '{}',
+  created_at TIMESTAMP NOT NULL,
+  updated_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS saved_searches_user_idx ON saved_searches (user_login, name);
+`
+
+const savedSearchColumns = `id, name, query, k, filters, created_at, updated_at`
+
+// ListSavedSearches returns the saved searches of user, by name.
+func (s *Store) ListSavedSearches(ctx context.Context, user string) ([]models.SavedSearch, error) {
+	rows, err := s.read.Query(ctx, `
+      SELECT `+savedSearchColumns+` FROM saved_searches
+      WHERE user_login = $1 ORDER BY lower(name), id`, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanSavedSearches(rows)
+}
+
+// GetSavedSearch returns the saved search of user with the given id.
+func (s *Store) GetSavedSearch(ctx context.Context, user, id string) (models.SavedSearch, bool, error) {
+	rows, err := s.read.Query(ctx, `
+      SELECT `+savedSearchColumns+` FROM saved_searches
+      WHERE user_login = $1 AND id = $2`, user, id)
+	if err != nil {
+		return models.SavedSearch{}, false, err
+	}
+	defer rows.Close()
+	return firstSavedSearch(scanSavedSearches(rows))
+}
+
+// CreateSavedSearch stores a new saved search for user. The caller sets its
+// id and times.
+func (s *Store) CreateSavedSearch(ctx context.Context, user string, ss models.SavedSearch) error {
+	filters, err := json.Marshal(ss.Filters)
+	if err != nil {
+		return err
+	}
+	_, err = s.pool.Exec(ctx, `
+      INSERT INTO saved_searches (id, user_login, name, query, k, filters, created_at, updated_at)
+      VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		ss.ID, user, ss.Name, ss.Query, ss.K, string(filters), ss.CreatedAt.UTC(), ss.UpdatedAt.UTC())
+	return err
+}
+
+// UpdateSavedSearch replaces the name, query, k and filters of a saved search
+// of user. It reports false when user has no saved search with that id.
+func (s *Store) UpdateSavedSearch(ctx context.Context, user string, ss models.SavedSearch) (bool, error) {
+	filters, err := json.Marshal(ss.Filters)
+	if err != nil {
+		return false, err
+	}
+	tag, err := s.pool.Exec(ctx, `
+      UPDATE saved_searches SET name = $3, query = $4, k = $5, filters = $6, updated_at = $7
+      WHERE user_login = $1 AND id = $2`,
+		user, ss.ID, ss.Name, ss.Query, ss.K, string(filters), ss.UpdatedAt.UTC())
+	return tag.RowsAffected() > 0, err
+}
+
+// DeleteSavedSearch removes a saved search of user. It reports false when
+// user has no saved search with that id.
+func (s *Store) DeleteSavedSearch(ctx context.Context, user, id string) (bool, error) {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM saved_searches WHERE user_login = $1 AND id = $2`, user, id)
+	return tag.RowsAffected() > 0, err
+}
+
+// ListSavedSearches returns the saved searches of user, by name.
+func (s *SQLiteStore) ListSavedSearches(ctx context.Context, user string) ([]models.SavedSearch, error) {
+	rows, err := s.db.QueryContext(ctx, `
+      SELECT `+savedSearchColumns+` FROM saved_searches
+      WHERE user_login = ? ORDER BY lower(name), id`, user)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	return scanSavedSearches(rows)
+}
+
+// GetSavedSearch returns the saved search of user with the given id.
+func (s *SQLiteStore) GetSavedSearch(ctx context.Context, user, id string) (models.SavedSearch, bool, error) {
+	rows, err := s.db.QueryContext(ctx, `
+      SELECT `+savedSearchColumns+` FROM saved_searches
+      WHERE user_login = ? AND id = ?`, user, id)
+	if err != nil {
+		return models.SavedSearch{}, false, err
+	}
+	defer func() { _ = rows.Close() }()
+	return firstSavedSearch(scanSavedSearches(rows))
+}
+
+// CreateSavedSearch stores a new saved search for user. The caller sets its
+// id and times.
+func (s *SQLiteStore) CreateSavedSearch(ctx context.Context, user string, ss models.SavedSearch) error {
+	filters, err := json.Marshal(ss.Filters)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+      INSERT INTO saved_searches (id, user_login, name, query, k, filters, created_at, updated_at)
+      VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		ss.ID, user, ss.Name, ss.Query, ss.K, string(filters), ss.CreatedAt.UTC(), ss.UpdatedAt.UTC())
+	return err
+}
+
+// UpdateSavedSearch replaces the name, query, k and filters of a saved search
+// of user. It reports false when user has no saved search with that id.
+func (s *SQLiteStore) UpdateSavedSearch(ctx context.Context, user string, ss models.SavedSearch) (bool, error) {
+	filters, err := json.Marshal(ss.Filters)
+	if err != nil {
+		return false, err
+	}
+	res, err := s.db.ExecContext(ctx, `
+      UPDATE saved_searches SET name = ?, query = ?, k = ?, filters = ?, updated_at = ?
+      WHERE user_login = ? AND id = ?`,
+		ss.Name, ss.Query, ss.K, string(filters), ss.UpdatedAt.UTC(), user, ss.ID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteSavedSearch removes a saved search of user. It reports false when
+// user has no saved search with that id.
+func (s *SQLiteStore) DeleteSavedSearch(ctx context.Context, user, id string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM saved_searches WHERE user_login = ? AND id = ?`, user, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func scanSavedSearches(rows rowScanner) ([]models.SavedSearch, error) {
+	out := []models.SavedSearch{}
+	for rows.Next() {
+		var ss models.SavedSearch
+		var filters string
+		var created, updated sql.NullTime
+		if err := rows.Scan(&ss.ID, &ss.Name, &ss.Query, &ss.K, &filters, &created, &updated); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(filters), &ss.Filters); err != nil {
+			return nil, err
+		}
+		ss.CreatedAt, ss.UpdatedAt = created.Time, updated.Time
+		out = append(out, ss)
+	}
+	return out, rows.Err()
+}
+
+func firstSavedSearch(list []models.SavedSearch, err error) (models.SavedSearch, bool, error) {
+	if err != nil || len(list) == 0 {
+		return models.SavedSearch{}, false, err
+	}
+	return list[0], true, nil
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pgvector/pgvector-go"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// SimilarChunks returns the k chunks whose summaries are closest to that of
+// chunk id, excluding the chunk itself, scored by cosine similarity. ok is
+// false when the chunk does not exist or has no vector.
+func (s *Store) SimilarChunks(ctx context.Context, id string, k int, opt QueryOpts) ([]models.SearchResult, bool, error) {
+	if s.vectors != nil {
+		return s.similarChunksExternal(ctx, id, k, opt)
+	}
+	var vec pgvector.Vector
+	err := s.read.QueryRow(ctx,
+		`SELECT summary_vec FROM chunks WHERE id = $1 AND deleted_at IS NULL AND summary_vec IS NOT NULL`, id).Scan(&vec)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	where, args := filterSQL("deleted_at IS NULL AND summary_vec IS NOT NULL AND id <> $2", []any{vec, id}, opt, true)
+	args = append(args, k)
+	q := fmt.Sprintf(`
+      SELECT `+chunkColumns+`, 1.0 - (summary_vec <=> $1) AS score
+      FROM chunks
+      WHERE %s
+      ORDER BY summary_vec <=> $1
+      LIMIT $%d`, where, len(args))
+	rows, release, err := s.queryWithSetting(ctx, s.searchSetting(opt), q, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer release()
+	defer rows.Close()
+
+	out := []models.SearchResult{}
+	for rows.Next() {
+		var r models.SearchResult
+		c := &r.Chunk
+		if err := rows.Scan(
+			&c.ID, &c.Repository, &c.Ref, &c.Path, &c.Language, &c.Summary, &c.Content, &c.LineStart, &c.LineEnd, &c.CreatedAt, &r.Score,
+		); err != nil {
+			return nil, false, err
+		}
+		out = append(out, r)
+	}
+	return out, true, rows.Err()
+}
+
+// similarChunksExternal is SimilarChunks for vectors held in an external
+// index: the neighbours are found there and their chunks loaded from
+// Postgres, which also applies the path filters.
+func (s *Store) similarChunksExternal(ctx context.Context, id string, k int, opt QueryOpts) ([]models.SearchResult, bool, error) {
+	vec, ok, err := s.vectors.Get(ctx, id)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	n := max(k+1, vectorCandidates)
+	hits, err := s.vectors.Search(ctx, vec, n, VectorFilter{
+		Repositories: opt.Repositories, Ref: opt.Ref, Languages: opt.Languages, Path: opt.Path,
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("vector index: %w", err)
+	}
+	scores := make(map[string]float64, len(hits))
+	ids := make([]string, 0, len(hits))
+	for _, h := range hits {
+		if h.ID != id {
+			scores[h.ID] = h.Score
+			ids = append(ids, h.ID)
+		}
+	}
+
+	where, args := filterSQL("deleted_at IS NULL AND id = ANY($1)", []any{ids}, opt, true)
+	rows, err := s.read.Query(ctx, `SELECT `+chunkColumns+` FROM chunks WHERE `+where, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	out := []models.SearchResult{}
+	for rows.Next() {
+		var c models.Chunk
+		if err := rows.Scan(
+			&c.ID, &c.Repository, &c.Ref, &c.Path, &c.Language, &c.Summary, &c.Content, &c.LineStart, &c.LineEnd, &c.CreatedAt,
+		); err != nil {
+			return nil, false, err
+		}
+		out = append(out, models.SearchResult{Chunk: c, Score: scores[c.ID]})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+	if len(out) > k {
+		out = out[:k]
+	}
+	return out, true, nil
+}
+
+// SimilarChunks returns the k chunks whose summaries are closest to that of
+// chunk id, excluding the chunk itself, scored by cosine similarity. ok is
+// false when the chunk does not exist or has no vector.
+func (s *SQLiteStore) SimilarChunks(ctx context.Context, id string, k int, opt QueryOpts) ([]models.SearchResult, bool, error) {
+	var b []byte
+	err := s.db.QueryRowContext(ctx,
+		`SELECT summary_vec FROM chunks WHERE id = ? AND deleted_at IS NULL AND summary_vec IS NOT NULL`, id).Scan(&b)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	vec := decodeVector(b)
+	if len(vec) == 0 {
+		return nil, false, nil
+	}
+
+	where, args := sqliteFilters(opt, true)
+	rows, err := s.db.QueryContext(ctx, `SELECT `+chunkColumns+`, summary_vec FROM chunks
+      WHERE `+where+` AND summary_vec IS NOT NULL AND id <> ?`, append(args, id)...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	out := []models.SearchResult{}
+	for rows.Next() {
+		var r models.SearchResult
+		c := &r.Chunk
+		var created sql.NullTime
+		var v []byte
+		if err := rows.Scan(
+			&c.ID, &c.Repository, &c.Ref, &c.Path, &c.Language, &c.Summary, &c.Content, &c.LineStart, &c.LineEnd, &created, &v,
+		); err != nil {
+			return nil, false, err
+		}
+		c.CreatedAt = created.Time
+		r.Score = cosine(vec, decodeVector(v))
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+	if len(out) > k {
+		out = out[:k]
+	}
+	return out, true, nil
+}
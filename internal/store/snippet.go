@@ -0,0 +1,100 @@
+package store
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+const (
+	snippetLines    = 5   // lines of content in a snippet
+	snippetMaxBytes = 400 // snippets are cut at this length
+)
+
+// Snippet picks the lines of content that best match the query terms and
+// locates each term within them. Without matches it returns the start of
+// the content.
+func Snippet(content, query string) (string, []models.Highlight) {
+	if content == "" {
+		return "", nil
+	}
+	terms := queryTerms(query)
+	lines := strings.Split(content, "\n")
+
+	best, bestHits := 0, 0
+	for i, l := range lines {
+		ll := asciiLower(l)
+		hits := 0
+		for _, t := range terms {
+			hits += strings.Count(ll, t)
+		}
+		if hits > bestHits {
+			best, bestHits = i, hits
+		}
+	}
+	// Keep a line of context above the best match.
+	start := max(best-1, 0)
+	end := min(start+snippetLines, len(lines))
+	start = max(end-snippetLines, 0)
+
+	s := strings.Trim(strings.Join(lines[start:end], "\n"), "\n")
+	if len(s) > snippetMaxBytes {
+		cut := snippetMaxBytes
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		s = s[:cut]
+	}
+	return s, highlights(s, terms)
+}
+
+// highlights returns the sorted, merged ranges of s matching any term.
+func highlights(s string, terms []string) []models.Highlight {
+	ls := asciiLower(s)
+	var hs []models.Highlight
+	for _, t := range terms {
+		for from := 0; ; {
+			i := strings.Index(ls[from:], t)
+			if i < 0 {
+				break
+			}
+			hs = append(hs, models.Highlight{Start: from + i, End: from + i + len(t)})
+			from += i + len(t)
+		}
+	}
+	if len(hs) == 0 {
+		return nil
+	}
+	sort.Slice(hs, func(i, j int) bool { return hs[i].Start < hs[j].Start })
+	out := hs[:1]
+	for _, h := range hs[1:] {
+		last := &out[len(out)-1]
+		if h.Start <= last.End {
+			last.End = max(last.End, h.End)
+			continue
+		}
+		out = append(out, h)
+	}
+	return out
+}
+
+// asciiLower lowercases ASCII letters only, so byte offsets into the result
+// are valid in s. Query terms are ASCII.
+func asciiLower(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+// withSnippets fills in the snippet of each result.
+func withSnippets(results []models.SearchResult, query string) {
+	for i := range results {
+		results[i].Snippet, results[i].Highlights = Snippet(results[i].Chunk.Content, query)
+	}
+}
@@ -0,0 +1,51 @@
+package store
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSnippet(t *testing.T) {
+	content := strings.Join([]string{
+		"package db",
+		"",
+		"import \"context\"",
+		"",
+		"// Migrate applies the schema.",
+		"func Migrate(ctx context.Context) error {",
+		"\treturn migrate(ctx)",
+		"}",
+		"",
+		"func unrelated() {}",
+	}, "\n")
+
+	s, hs := Snippet(content, "how does the database migrate")
+	if !strings.HasPrefix(s, "// Migrate applies") || !strings.HasSuffix(s, "}") {
+		t.Fatalf("unexpected snippet %q", s)
+	}
+	if len(hs) != 3 {
+		t.Fatalf("expected 3 highlights, got %+v", hs)
+	}
+	for _, h := range hs {
+		if got := strings.ToLower(s[h.Start:h.End]); got != "migrate" {
+			t.Errorf("highlight %+v covers %q", h, got)
+		}
+	}
+
+	s, hs = Snippet(content, "nothing matches")
+	if !strings.HasPrefix(s, "package db") || hs != nil {
+		t.Errorf("expected the start of the content, got %q %+v", s, hs)
+	}
+
+	long := strings.Repeat("é", snippetMaxBytes)
+	if s, _ := Snippet(long, "x"); len(s) > snippetMaxBytes || !strings.HasPrefix(long, s) {
+		t.Errorf("snippet not cut on a rune boundary: %d bytes", len(s))
+	}
+}
+
+func TestHighlightsMerge(t *testing.T) {
+	hs := highlights("ParseConfig", []string{"parse", "parseconfig", "config"})
+	if len(hs) != 1 || hs[0].Start != 0 || hs[0].End != len("ParseConfig") {
+		t.Errorf("expected one merged highlight, got %+v", hs)
+	}
+}
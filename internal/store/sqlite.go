@@ -0,0 +1,920 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/seanblong/reposearch/pkg/models"
+	"modernc.org/sqlite" // also registers the "sqlite" database/sql driver
+)
+
+// SQLite parses "x REGEXP y" as regexp(y, x) but ships no implementation.
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction("regexp", 2, sqliteRegexp)
+}
+
+// sqliteRegexps caches compiled patterns, as the function runs once per row.
+var sqliteRegexps sync.Map
+
+func sqliteRegexp(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	pattern, _ := args[0].(string)
+	s, _ := args[1].(string)
+	re, ok := sqliteRegexps.Load(pattern)
+	if !ok {
+		c, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path regex: %w", err)
+		}
+		re, _ = sqliteRegexps.LoadOrStore(pattern, c)
+	}
+	return re.(*regexp.Regexp).MatchString(s), nil
+}
+
+// SQLiteStore is a single-file store for local use. Vectors are kept as
+// blobs and searched by brute force, which is fine for a handful of
+// repositories but does not scale like pgvector.
+type SQLiteStore struct {
+	db      *sql.DB
+	ranking Ranking
+}
+
+// OpenSQLite opens (creating if needed) the SQLite database at path.
+// ":memory:" gives a throwaway in-memory database.
+func OpenSQLite(ctx context.Context, path string) (*SQLiteStore, error) {
+	if path == "" {
+		return nil, errors.New("sqlite database path is required")
+	}
+	// Store times in a sortable format so MAX() over them is meaningful.
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	db, err := sql.Open("sqlite", path+sep+"_time_format=sqlite")
+	if err != nil {
+		return nil, err
+	}
+	// A single connection serializes writers (avoiding SQLITE_BUSY) and keeps
+	// an in-memory database alive for the lifetime of the store.
+	db.SetMaxOpenConns(1)
+	if _, err := db.ExecContext(ctx, `PRAGMA journal_mode = WAL; PRAGMA busy_timeout = 5000;`); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db: db, ranking: DefaultRanking()}, nil
+}
+
+// Close closes the database.
+func (s *SQLiteStore) Close() { _ = s.db.Close() }
+
+// Ping checks the database connection.
+func (s *SQLiteStore) Ping(ctx context.Context) error { return s.db.PingContext(ctx) }
+
+// Migrate creates the schema. SQLite does not type vectors, so the embedding
+// dimension is checked against a stored vector instead.
+func (s *SQLiteStore) Migrate(ctx context.Context, summaryDim int) error {
+	const q = `
+CREATE TABLE IF NOT EXISTS chunks (
+  id            TEXT PRIMARY KEY,
+  repository    TEXT NOT NULL,
+  ref           TEXT NOT NULL DEFAULT '',
+  path          TEXT NOT NULL,
+  language      TEXT,
+  summary       TEXT,
+  content       TEXT,
+  line_start    INTEGER,
+  line_end      INTEGER,
+  summary_vec   BLOB,
+  content_hash  TEXT,
+  summarized_at TIMESTAMP,
+  created_at    TIMESTAMP,
+  summary_model TEXT,
+  embed_model   TEXT,
+  deleted_at    TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS chunks_repo_path_span_ref_uidx
+  ON chunks (repository, ref, path, line_start, line_end);
+CREATE INDEX IF NOT EXISTS chunks_repository_idx ON chunks (repository);
+CREATE INDEX IF NOT EXISTS chunks_hash_idx ON chunks (content_hash);
+
+CREATE TABLE IF NOT EXISTS rollups (
+  repository  TEXT NOT NULL,
+  ref         TEXT NOT NULL DEFAULT '',
+  kind        TEXT NOT NULL,
+  path        TEXT NOT NULL,
+  summary     TEXT,
+  summary_vec BLOB,
+  input_hash  TEXT,
+  updated_at  TIMESTAMP,
+  deleted_at  TIMESTAMP,
+  PRIMARY KEY (repository, ref, kind, path)
+);
+` + symbolsSchema + symbolsNameIndexSQLite + queryLogSchema + chatSchema + savedSearchSchema + apiKeySchema + repositoryGrantSchema + refreshTokenSchema + revocationSchema + usageSchema + indexRunSchema + jobSchema + searchAuditSchema + authEventSchema + indexVersionSchemaSQLite
+	if _, err := s.db.ExecContext(ctx, q); err != nil {
+		return err
+	}
+	for _, table := range []string{"chunks", "rollups"} {
+		if err := s.addColumn(ctx, table, "deleted_at", "TIMESTAMP"); err != nil {
+			return err
+		}
+	}
+	if err := s.addColumn(ctx, "api_keys", "scopes", "TEXT NOT NULL DEFAULT '"+apiKeyLegacyScopes+"'"); err != nil {
+		return err
+	}
+	if err := s.addColumn(ctx, "api_keys", "expires_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+	if err := s.addColumn(ctx, "api_keys", "repositories", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	return s.checkDimension(ctx, summaryDim)
+}
+
+// addColumn adds a column to a table created before the column existed, as
+// SQLite has no ADD COLUMN IF NOT EXISTS.
+func (s *SQLiteStore) addColumn(ctx context.Context, table, column, decl string) error {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&n)
+	if err != nil || n > 0 {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `ALTER TABLE `+table+` ADD COLUMN `+column+` `+decl)
+	return err
+}
+
+// GetRepositories returns a list of all distinct repositories.
+func (s *SQLiteStore) GetRepositories(ctx context.Context) ([]string, error) {
+	return s.strings(ctx, `SELECT DISTINCT repository FROM chunks WHERE deleted_at IS NULL ORDER BY repository`)
+}
+
+// sqliteTimeFormat is the layout written by the driver's "sqlite" time format.
+const sqliteTimeFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+// Stats returns chunk, file, ref and language counts per repository, and an
+// estimate of their storage size.
+func (s *SQLiteStore) Stats(ctx context.Context) (models.IndexStats, error) {
+	stats := models.IndexStats{Repositories: []models.RepositoryStats{}}
+	rows, err := s.db.QueryContext(ctx, `
+      SELECT repository, COUNT(*), COUNT(DISTINCT path),
+             MAX(MAX(COALESCE(created_at, '')), MAX(COALESCE(summarized_at, ''))),
+             SUM(COALESCE(LENGTH(CAST(summary AS BLOB)), 0) + COALESCE(LENGTH(CAST(content AS BLOB)), 0) +
+                 COALESCE(LENGTH(summary_vec), 0))
+      FROM chunks
+      WHERE deleted_at IS NULL
+      GROUP BY repository
+      ORDER BY repository`)
+	if err != nil {
+		return stats, err
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var r models.RepositoryStats
+		var last string
+		if err := rows.Scan(&r.Repository, &r.Chunks, &r.Files, &last, &r.StorageBytes); err != nil {
+			return stats, err
+		}
+		r.LastIndexedAt, _ = time.Parse(sqliteTimeFormat, last)
+		r.Languages = map[string]int64{}
+		stats.Chunks += r.Chunks
+		stats.Files += r.Files
+		stats.StorageBytes += r.StorageBytes
+		stats.Repositories = append(stats.Repositories, r)
+	}
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	for i := range stats.Repositories {
+		r := &stats.Repositories[i]
+		if r.Refs, err = s.GetRefs(ctx, r.Repository); err != nil {
+			return stats, err
+		}
+		lrows, err := s.db.QueryContext(ctx,
+			`SELECT COALESCE(language, ''), COUNT(*) FROM chunks WHERE repository = ? AND deleted_at IS NULL GROUP BY 1`, r.Repository)
+		if err != nil {
+			return stats, err
+		}
+		for lrows.Next() {
+			var lang string
+			var n int64
+			if err := lrows.Scan(&lang, &n); err != nil {
+				_ = lrows.Close()
+				return stats, err
+			}
+			r.Languages[lang] = n
+		}
+		_ = lrows.Close()
+		if err := lrows.Err(); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// GetRefs returns distinct refs for a given repository.
+func (s *SQLiteStore) GetRefs(ctx context.Context, repository string) ([]string, error) {
+	return s.strings(ctx, `SELECT DISTINCT ref FROM chunks WHERE repository = ? AND deleted_at IS NULL ORDER BY ref`, repository)
+}
+
+func (s *SQLiteStore) strings(ctx context.Context, q string, args ...any) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var out []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+const sqliteUpsertChunkSQL = `
+	INSERT INTO chunks (
+		id, repository, ref, path, language, summary, content,
+		line_start, line_end, summary_vec, content_hash, summarized_at, created_at,
+		summary_model, embed_model
+	) VALUES (?,?,?,?,?,?,?,?,?,?,?, CASE WHEN ?6 <> '' THEN ?12 END, ?12, NULLIF(?13, ''), NULLIF(?14, ''))
+	ON CONFLICT (repository, ref, path, line_start, line_end) DO UPDATE SET
+		language      = excluded.language,
+		content       = excluded.content,
+		content_hash  = excluded.content_hash,
+		summary       = COALESCE(NULLIF(excluded.summary, ''), chunks.summary),
+		summarized_at = COALESCE(excluded.summarized_at, chunks.summarized_at),
+		summary_vec   = COALESCE(excluded.summary_vec, chunks.summary_vec),
+		summary_model = COALESCE(excluded.summary_model, chunks.summary_model),
+		embed_model   = COALESCE(excluded.embed_model, chunks.embed_model),
+		deleted_at    = NULL`
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func sqliteUpsertChunk(ctx context.Context, e execer, c models.Chunk, summaryVec []float32, contentHash string) error {
+	_, err := e.ExecContext(ctx, sqliteUpsertChunkSQL,
+		c.ID, c.Repository, c.Ref, c.Path, c.Language, c.Summary, c.Content,
+		c.LineStart, c.LineEnd, encodeVector(summaryVec), contentHash, time.Now().UTC(),
+		c.SummaryModel, c.EmbedModel,
+	)
+	return err
+}
+
+// sqliteWriteChunk upserts c and replaces its symbols.
+func sqliteWriteChunk(ctx context.Context, e execer, c ChunkWithVec) error {
+	if err := sqliteUpsertChunk(ctx, e, c.Chunk, c.SummaryVec, c.ContentHash); err != nil {
+		return err
+	}
+	return sqliteWriteSymbols(ctx, e, c)
+}
+
+// UpsertChunk inserts or updates a chunk.
+func (s *SQLiteStore) UpsertChunk(ctx context.Context, c models.Chunk, summaryVec []float32, contentHash string) error {
+	return s.UpsertChunks(ctx, []ChunkWithVec{{Chunk: c, SummaryVec: summaryVec, ContentHash: contentHash}})
+}
+
+// UpsertChunks inserts or updates many chunks in one transaction.
+func (s *SQLiteStore) UpsertChunks(ctx context.Context, chunks []ChunkWithVec) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, c := range chunks {
+			if err := sqliteWriteChunk(ctx, tx, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQLiteStore) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetChunkMeta returns metadata for a specific chunk.
+func (s *SQLiteStore) GetChunkMeta(ctx context.Context, repository, path string, ls, le int) (ChunkMeta, bool, error) {
+	const q = `
+      SELECT COALESCE(content_hash, ''), COALESCE(summary, ''), summary_vec IS NOT NULL
+      FROM chunks
+      WHERE repository = ? AND path = ? AND line_start = ? AND line_end = ? AND deleted_at IS NULL
+      LIMIT 1`
+	var m ChunkMeta
+	err := s.db.QueryRowContext(ctx, q, repository, path, ls, le).Scan(&m.ContentHash, &m.Summary, &m.HasSummaryVec)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ChunkMeta{}, false, nil
+	}
+	if err != nil {
+		return ChunkMeta{}, false, err
+	}
+	return m, true, nil
+}
+
+// FindByContentHash returns the artifacts of any live chunk with the same
+// content made by the given models, like Store.FindByContentHash.
+func (s *SQLiteStore) FindByContentHash(ctx context.Context, contentHash, summaryModel, embedModel string) (HashMatch, bool, error) {
+	const q = `
+      SELECT summary, summary_vec, COALESCE(summary_model, ''), COALESCE(embed_model, '')
+      FROM chunks
+      WHERE content_hash = ?
+        AND deleted_at IS NULL
+        AND summary IS NOT NULL AND summary <> ''
+        AND summary_vec IS NOT NULL
+        AND COALESCE(summary_model, '') = ? AND COALESCE(embed_model, '') = ?
+      LIMIT 1`
+	var m HashMatch
+	var v []byte
+	err := s.db.QueryRowContext(ctx, q, contentHash, summaryModel, embedModel).Scan(&m.Summary, &v, &m.SummaryModel, &m.EmbedModel)
+	if errors.Is(err, sql.ErrNoRows) {
+		return HashMatch{}, false, nil
+	}
+	if err != nil {
+		return HashMatch{}, false, err
+	}
+	m.SummaryVec = decodeVector(v)
+	return m, true, nil
+}
+
+// Search ranks chunks with the same signals and weights as the Postgres
+// store, computed in Go over every chunk matching the filters.
+func (s *SQLiteStore) Search(ctx context.Context, summaryVec []float32, k int, opt QueryOpts) ([]models.SearchResult, error) {
+	p, err := s.SearchPage(ctx, summaryVec, k, opt)
+	return p.Results, err
+}
+
+// SearchPage is Search with opt.Offset applied and the ranked total reported.
+func (s *SQLiteStore) SearchPage(ctx context.Context, summaryVec []float32, k int, opt QueryOpts) (models.SearchPage, error) {
+	qtext := strings.TrimSpace(opt.QueryText)
+	if qtext == "" {
+		return models.SearchPage{Results: []models.SearchResult{}}, nil
+	}
+
+	where, args := sqliteFilters(opt, true)
+	rows, err := s.db.QueryContext(ctx, `
+      SELECT id, repository, ref, path, COALESCE(language, ''), COALESCE(summary, ''), COALESCE(content, ''),
+             line_start, line_end, created_at, summary_vec
+      FROM chunks WHERE `+where, args...)
+	if err != nil {
+		return models.SearchPage{}, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	type cand struct {
+		chunk                models.Chunk
+		sem, lex, tri        float64
+		scriptBias, noisePen float64
+	}
+	terms := queryTerms(qtext)
+	lq := strings.ToLower(qtext)
+	triTerm := longestToken(qtext)
+	asked := s.ranking.askedForScript(lq)
+
+	// Without a query vector (the embedding failed) ranking is purely lexical.
+	lexicalOnly := len(summaryVec) == 0
+
+	var cands []cand
+	var maxSem, maxLex, maxTri float64
+	for rows.Next() {
+		var c cand
+		var created sql.NullTime
+		var vec []byte
+		if err := rows.Scan(&c.chunk.ID, &c.chunk.Repository, &c.chunk.Ref, &c.chunk.Path, &c.chunk.Language,
+			&c.chunk.Summary, &c.chunk.Content, &c.chunk.LineStart, &c.chunk.LineEnd, &created, &vec); err != nil {
+			return models.SearchPage{}, err
+		}
+		c.chunk.CreatedAt = created.Time
+		c.sem = math.Min(math.Max(cosine(summaryVec, decodeVector(vec)), 0), 1)
+		c.lex = lexicalScore(terms, lq, c.chunk.Summary)
+		if triTerm != "" {
+			c.tri = trigramSimilarity(strings.ToLower(c.chunk.Path), triTerm)
+		}
+		c.scriptBias = s.ranking.scriptBias(asked, c.chunk.Language)
+		c.noisePen = s.ranking.noisePenalty(c.chunk.Path)
+		if lexicalOnly && c.lex == 0 && c.tri < lexicalMinTrigram {
+			continue
+		}
+		maxSem, maxLex, maxTri = math.Max(maxSem, c.sem), math.Max(maxLex, c.lex), math.Max(maxTri, c.tri)
+		cands = append(cands, c)
+	}
+	if err := rows.Err(); err != nil {
+		return models.SearchPage{}, err
+	}
+
+	var semRank, lexRank []int
+	if opt.Fusion == FusionRRF {
+		sems, lexs := make([]float64, len(cands)), make([]float64, len(cands))
+		for i, c := range cands {
+			sems[i], lexs[i] = c.sem, c.lex
+		}
+		semRank, lexRank = rank(sems), rank(lexs)
+	}
+
+	out := make([]models.SearchResult, 0, len(cands))
+	for i, c := range cands {
+		var score float64
+		if opt.Fusion == FusionRRF {
+			if c.sem > 0 {
+				score += 1.0 / float64(rrfK+semRank[i])
+			}
+			if c.lex > 0 {
+				score += 1.0 / float64(rrfK+lexRank[i])
+			}
+		} else {
+			w := s.ranking.weights(lexicalOnly)
+			score = w.Semantic*normalize(c.sem, maxSem) +
+				w.Lexical*normalize(c.lex, maxLex) +
+				w.Trigram*normalize(c.tri, maxTri) +
+				w.ScriptBias*c.scriptBias -
+				w.NoisePenalty*c.noisePen
+		}
+		r := models.SearchResult{Chunk: c.chunk, Score: score}
+		if opt.Explain {
+			e := models.ScoreExplanation{
+				SemSim: c.sem, LexSum: c.lex, Trigram: c.tri, ScriptBias: c.scriptBias, NoisePenalty: c.noisePen,
+				MaxSem: maxSem, MaxLex: maxLex, MaxTrigram: maxTri,
+			}
+			if semRank != nil {
+				e.SemRank, e.LexRank = semRank[i], lexRank[i]
+			}
+			r.Explain = explanation(opt, s.ranking, lexicalOnly, e)
+		}
+		out = append(out, r)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+	page := models.SearchPage{Total: len(out)}
+	if opt.Offset > 0 {
+		out = out[min(opt.Offset, len(out)):]
+	}
+	if k >= 0 && len(out) > k {
+		out = out[:k]
+	}
+	withSnippets(out, qtext)
+	page.Results = out
+	return page, nil
+}
+
+// sqliteFilters builds the WHERE clause for the common query options.
+func sqliteFilters(opt QueryOpts, withLanguage bool) (string, []any) {
+	where := "deleted_at IS NULL"
+	var args []any
+	in := func(col string, vs []string) {
+		where += " AND " + col + " IN (?" + strings.Repeat(", ?", len(vs)-1) + ")"
+		for _, v := range vs {
+			args = append(args, v)
+		}
+	}
+	if len(opt.Repositories) > 0 {
+		in("repository", opt.Repositories)
+	}
+	if opt.AllowedRepositories != nil {
+		if len(opt.AllowedRepositories) == 0 {
+			where += " AND 0"
+		} else {
+			in("repository", opt.AllowedRepositories)
+		}
+	}
+	if opt.Principals != nil {
+		cond, granted := sqliteGrantedTo(opt.Principals)
+		where += " AND (repository NOT IN (SELECT repository FROM repository_grants)" +
+			" OR repository IN (SELECT repository FROM repository_grants WHERE " + cond + "))"
+		args = append(args, granted...)
+	}
+	if withLanguage && len(opt.Languages) > 0 {
+		in("language", opt.Languages)
+	}
+	if opt.Path != "" {
+		where += " AND path = ?"
+		args = append(args, opt.Path)
+	}
+	if opt.PathContains != "" {
+		where += " AND instr(lower(path), lower(?)) > 0"
+		args = append(args, opt.PathContains)
+	}
+	if opt.PathRegex != "" {
+		where += " AND path REGEXP ?"
+		args = append(args, opt.PathRegex)
+	}
+	for _, p := range opt.PathNotContains {
+		if p != "" {
+			where += " AND instr(lower(path), lower(?)) = 0"
+			args = append(args, p)
+		}
+	}
+	if opt.Ref != "" {
+		where += " AND ref = ?"
+		args = append(args, opt.Ref)
+	}
+	if withLanguage && opt.Symbol != "" {
+		if name, prefix := symbolPattern(opt.Symbol); prefix {
+			where += ` AND id IN (SELECT chunk_id FROM symbols WHERE name LIKE ? ESCAPE '\')`
+			args = append(args, likeEscape(name)+"%")
+		} else {
+			where += " AND id IN (SELECT chunk_id FROM symbols WHERE name = ? COLLATE NOCASE)"
+			args = append(args, name)
+		}
+	}
+	return where, args
+}
+
+// DeleteRepository soft-deletes every chunk and rollup of a repository.
+func (s *SQLiteStore) DeleteRepository(ctx context.Context, repository string) (int64, error) {
+	return s.setDeleted(ctx, true, "repository = ?", repository)
+}
+
+// DeleteRef soft-deletes the chunks and rollups of a single ref.
+func (s *SQLiteStore) DeleteRef(ctx context.Context, repository, ref string) (int64, error) {
+	return s.setDeleted(ctx, true, "repository = ? AND ref = ?", repository, ref)
+}
+
+// GetPaths returns the paths indexed for a ref with their chunk counts.
+func (s *SQLiteStore) GetPaths(ctx context.Context, repository, ref string) (map[string]int64, error) {
+	rows, err := s.db.QueryContext(ctx, `
+      SELECT path, COUNT(*) FROM chunks
+      WHERE repository = ? AND ref = ? AND deleted_at IS NULL
+      GROUP BY path`, repository, ref)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	paths := map[string]int64{}
+	for rows.Next() {
+		var p string
+		var n int64
+		if err := rows.Scan(&p, &n); err != nil {
+			return nil, err
+		}
+		paths[p] = n
+	}
+	return paths, rows.Err()
+}
+
+// DeletePaths soft-deletes the chunks and rollups of the given paths of a
+// ref, in batches that stay under SQLite's limit on bound parameters.
+func (s *SQLiteStore) DeletePaths(ctx context.Context, repository, ref string, paths []string) (int64, error) {
+	var total int64
+	for batch := range slices.Chunk(paths, 500) {
+		args := []any{repository, ref}
+		for _, p := range batch {
+			args = append(args, p)
+		}
+		n, err := s.setDeleted(ctx, true, "repository = ? AND ref = ? AND path IN (?"+strings.Repeat(", ?", len(batch)-1)+")", args...)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// RestoreRepository undoes DeleteRepository for rows not yet vacuumed.
+func (s *SQLiteStore) RestoreRepository(ctx context.Context, repository string) (int64, error) {
+	return s.setDeleted(ctx, false, "repository = ?", repository)
+}
+
+// RestoreRef undoes DeleteRef for rows not yet vacuumed.
+func (s *SQLiteStore) RestoreRef(ctx context.Context, repository, ref string) (int64, error) {
+	return s.setDeleted(ctx, false, "repository = ? AND ref = ?", repository, ref)
+}
+
+// setDeleted marks or unmarks the chunks and rollups matching cond as
+// deleted and returns the number of chunks changed.
+func (s *SQLiteStore) setDeleted(ctx context.Context, deleted bool, cond string, args ...any) (int64, error) {
+	var at any
+	was := "deleted_at IS NOT NULL"
+	if deleted {
+		at, was = time.Now().UTC(), "deleted_at IS NULL"
+	}
+	var n int64
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `UPDATE chunks SET deleted_at = ? WHERE `+was+` AND `+cond, append([]any{at}, args...)...)
+		if err != nil {
+			return err
+		}
+		n, _ = res.RowsAffected()
+		_, err = tx.ExecContext(ctx, `UPDATE rollups SET deleted_at = ? WHERE `+was+` AND `+cond, append([]any{at}, args...)...)
+		return err
+	})
+	return n, err
+}
+
+// Vacuum permanently removes soft-deleted chunks and rollups, of repository
+// only unless it is empty, then compacts the database file and rebuilds its
+// indexes.
+func (s *SQLiteStore) Vacuum(ctx context.Context, repository string) (int64, error) {
+	cond, args := "deleted_at IS NOT NULL", []any{}
+	if repository != "" {
+		cond, args = cond+" AND repository = ?", append(args, repository)
+	}
+	var n int64
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM chunks WHERE `+cond, args...)
+		if err != nil {
+			return err
+		}
+		n, _ = res.RowsAffected()
+		if _, err := tx.ExecContext(ctx, orphanSymbolsSQL); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM rollups WHERE `+cond, args...)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.db.ExecContext(ctx, `VACUUM; REINDEX;`)
+	return n, err
+}
+
+// GetRollupMeta returns the input hash and summary of a stored rollup.
+func (s *SQLiteStore) GetRollupMeta(ctx context.Context, repository, ref, kind, path string) (RollupMeta, bool, error) {
+	const q = `
+      SELECT COALESCE(input_hash, ''), COALESCE(summary, '')
+      FROM rollups
+      WHERE repository = ? AND ref = ? AND kind = ? AND path = ? AND deleted_at IS NULL`
+	var m RollupMeta
+	err := s.db.QueryRowContext(ctx, q, repository, ref, kind, path).Scan(&m.InputHash, &m.Summary)
+	if errors.Is(err, sql.ErrNoRows) {
+		return RollupMeta{}, false, nil
+	}
+	if err != nil {
+		return RollupMeta{}, false, err
+	}
+	return m, true, nil
+}
+
+// UpsertRollup inserts or updates a file or directory summary.
+func (s *SQLiteStore) UpsertRollup(ctx context.Context, r models.Rollup, summaryVec []float32, inputHash string) error {
+	const q = `
+      INSERT INTO rollups (repository, ref, kind, path, summary, summary_vec, input_hash, updated_at)
+      VALUES (?,?,?,?,?,?,?,?)
+      ON CONFLICT (repository, ref, kind, path) DO UPDATE SET
+        summary     = excluded.summary,
+        summary_vec = COALESCE(excluded.summary_vec, rollups.summary_vec),
+        input_hash  = excluded.input_hash,
+        updated_at  = excluded.updated_at,
+        deleted_at  = NULL`
+	_, err := s.db.ExecContext(ctx, q, r.Repository, r.Ref, r.Kind, r.Path, r.Summary,
+		encodeVector(summaryVec), inputHash, time.Now().UTC())
+	return err
+}
+
+// SearchRollups ranks file or directory summaries by cosine similarity.
+func (s *SQLiteStore) SearchRollups(ctx context.Context, summaryVec []float32, k int, kind string, opt QueryOpts) ([]models.RollupResult, error) {
+	if len(summaryVec) == 0 {
+		return []models.RollupResult{}, nil
+	}
+	where, args := sqliteFilters(opt, false)
+	rows, err := s.db.QueryContext(ctx, `
+      SELECT repository, ref, kind, path, COALESCE(summary, ''), updated_at, summary_vec
+      FROM rollups WHERE summary_vec IS NOT NULL AND kind = ? AND `+where, append([]any{kind}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	out := []models.RollupResult{}
+	for rows.Next() {
+		var r models.RollupResult
+		var updated sql.NullTime
+		var vec []byte
+		if err := rows.Scan(&r.Rollup.Repository, &r.Rollup.Ref, &r.Rollup.Kind, &r.Rollup.Path, &r.Rollup.Summary, &updated, &vec); err != nil {
+			return nil, err
+		}
+		r.Rollup.UpdatedAt = updated.Time
+		r.Score = cosine(summaryVec, decodeVector(vec))
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+	if len(out) > k {
+		out = out[:k]
+	}
+	return out, nil
+}
+
+// ListStaleSummaries returns chunks whose summary was not produced by model.
+func (s *SQLiteStore) ListStaleSummaries(ctx context.Context, model, afterID string, limit int) ([]StaleChunk, error) {
+	return s.listStale(ctx, `
+      SELECT id, path, COALESCE(language, ''), COALESCE(summary, ''), COALESCE(content, '')
+      FROM chunks
+      WHERE summary_model IS NOT ? AND deleted_at IS NULL AND id > ?
+      ORDER BY id LIMIT ?`, model, afterID, limit)
+}
+
+// ListStaleVectors returns summarized chunks whose vector was not produced by model.
+func (s *SQLiteStore) ListStaleVectors(ctx context.Context, model, afterID string, limit int) ([]StaleChunk, error) {
+	return s.listStale(ctx, `
+      SELECT id, path, COALESCE(language, ''), summary, COALESCE(content, '')
+      FROM chunks
+      WHERE embed_model IS NOT ? AND summary IS NOT NULL AND summary <> '' AND deleted_at IS NULL AND id > ?
+      ORDER BY id LIMIT ?`, model, afterID, limit)
+}
+
+func (s *SQLiteStore) listStale(ctx context.Context, q string, args ...any) ([]StaleChunk, error) {
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var out []StaleChunk
+	for rows.Next() {
+		var c StaleChunk
+		if err := rows.Scan(&c.ID, &c.Path, &c.Language, &c.Summary, &c.Content); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// UpdateSummaries writes new summaries and queues the chunks for re-embedding.
+func (s *SQLiteStore) UpdateSummaries(ctx context.Context, updates []SummaryUpdate) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, u := range updates {
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE chunks SET summary = ?, summary_model = ?, summarized_at = ?, embed_model = NULL WHERE id = ?`,
+				u.Summary, u.Model, time.Now().UTC(), u.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UpdateVectors writes new summary vectors.
+func (s *SQLiteStore) UpdateVectors(ctx context.Context, updates []VectorUpdate) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, u := range updates {
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE chunks SET summary_vec = ?, embed_model = ? WHERE id = ?`,
+				encodeVector(u.Vector), u.Model, u.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// encodeVector stores a vector as little-endian float32s; nil stays NULL.
+func encodeVector(v []float32) any {
+	if v == nil {
+		return nil
+	}
+	b := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(f))
+	}
+	return b
+}
+
+func decodeVector(b []byte) []float32 {
+	if len(b) == 0 {
+		return nil
+	}
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v
+}
+
+// cosine returns the cosine similarity of a and b, or 0 if they cannot be
+// compared.
+func cosine(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// rank returns the 1-based descending rank of each value, with ties sharing
+// a rank like SQL's RANK().
+func rank(vals []float64) []int {
+	idx := make([]int, len(vals))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool { return vals[idx[a]] > vals[idx[b]] })
+	out := make([]int, len(vals))
+	for pos, i := range idx {
+		if pos > 0 && vals[i] == vals[idx[pos-1]] {
+			out[i] = out[idx[pos-1]]
+		} else {
+			out[i] = pos + 1
+		}
+	}
+	return out
+}
+
+func normalize(v, max float64) float64 {
+	if max == 0 {
+		return 0
+	}
+	return v / max
+}
+
+var (
+	wordRe    = regexp.MustCompile(`[a-z0-9]+`)
+	stopWords = map[string]bool{
+		"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+		"do": true, "does": true, "for": true, "from": true, "how": true, "in": true, "is": true, "it": true,
+		"of": true, "on": true, "or": true, "that": true, "the": true, "this": true, "to": true, "what": true,
+		"where": true, "which": true, "with": true,
+	}
+)
+
+// queryTerms returns the distinct non-stopword terms of a query.
+func queryTerms(q string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, w := range wordRe.FindAllString(strings.ToLower(q), -1) {
+		if stopWords[w] || seen[w] {
+			continue
+		}
+		seen[w] = true
+		out = append(out, w)
+	}
+	return out
+}
+
+// lexicalScore approximates ts_rank_cd on the summary: the fraction of query
+// terms present, plus a bonus when the whole query appears as a phrase.
+func lexicalScore(terms []string, lowerQuery, summary string) float64 {
+	if len(terms) == 0 || summary == "" {
+		return 0
+	}
+	ls := strings.ToLower(summary)
+	words := map[string]bool{}
+	for _, w := range wordRe.FindAllString(ls, -1) {
+		words[w] = true
+	}
+	hits := 0
+	for _, t := range terms {
+		if words[t] {
+			hits++
+		}
+	}
+	score := float64(hits) / float64(len(terms))
+	if len(terms) > 1 && strings.Contains(ls, lowerQuery) {
+		score += 0.5
+	}
+	return score
+}
+
+// trigramSimilarity mirrors pg_trgm's similarity().
+func trigramSimilarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ta)+len(tb)-shared)
+}
+
+func trigrams(s string) map[string]bool {
+	out := map[string]bool{}
+	for _, w := range wordRe.FindAllString(strings.ToLower(s), -1) {
+		p := "  " + w + " "
+		for i := 0; i+3 <= len(p); i++ {
+			out[p[i:i+3]] = true
+		}
+	}
+	return out
+}
@@ -0,0 +1,1772 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+func newTestSQLite(t *testing.T) *SQLiteStore {
+	t.Helper()
+	ctx := context.Background()
+	s, err := OpenSQLite(ctx, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	t.Cleanup(s.Close)
+	if err := s.Migrate(ctx, 3); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return s
+}
+
+func TestOpen_SQLiteURL(t *testing.T) {
+	b, err := Open(context.Background(), "sqlite://"+filepath.Join(t.TempDir(), "x.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer b.Close()
+	if _, ok := b.(*SQLiteStore); !ok {
+		t.Fatalf("expected *SQLiteStore, got %T", b)
+	}
+	if err := b.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+}
+
+func TestSQLiteStore_MigrateDimensionMismatch(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	c := models.Chunk{ID: "1", Repository: "repo", Ref: "main", Path: "a.go", LineStart: 1, LineEnd: 2}
+	if err := s.UpsertChunk(ctx, c, []float32{1, 0, 0}, "h1"); err != nil {
+		t.Fatalf("UpsertChunk: %v", err)
+	}
+	if err := s.Migrate(ctx, 3); err != nil {
+		t.Fatalf("Migrate with the same dimension: %v", err)
+	}
+	if err := s.Migrate(ctx, 4); !errors.Is(err, ErrDimensionMismatch) {
+		t.Fatalf("expected ErrDimensionMismatch, got %v", err)
+	}
+}
+
+func TestSQLiteStore_GetChunks(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	for _, c := range []models.Chunk{
+		{ID: "b", Repository: "repo", Ref: "main", Path: "a.go", Content: "second", LineStart: 11, LineEnd: 20},
+		{ID: "a", Repository: "repo", Ref: "main", Path: "a.go", Content: "first", LineStart: 1, LineEnd: 10},
+		{ID: "c", Repository: "repo", Ref: "dev", Path: "a.go", Content: "other ref", LineStart: 1, LineEnd: 10},
+		{ID: "d", Repository: "repo", Ref: "main", Path: "a.go", Content: "third", LineStart: 21, LineEnd: 30},
+	} {
+		if err := s.UpsertChunk(ctx, c, nil, c.ID); err != nil {
+			t.Fatalf("UpsertChunk: %v", err)
+		}
+	}
+
+	c, ok, err := s.GetChunkByID(ctx, "b")
+	if err != nil || !ok || c.Content != "second" || c.LineStart != 11 {
+		t.Fatalf("GetChunkByID: %+v ok=%v err=%v", c, ok, err)
+	}
+	if _, ok, _ := s.GetChunkByID(ctx, "missing"); ok {
+		t.Error("expected a missing chunk not to be found")
+	}
+
+	chunks, err := s.GetFileChunks(ctx, "repo", "main", "a.go")
+	if err != nil {
+		t.Fatalf("GetFileChunks: %v", err)
+	}
+	if len(chunks) != 3 || chunks[0].ID != "a" || chunks[1].ID != "b" || chunks[2].ID != "d" {
+		t.Errorf("unexpected file chunks: %+v", chunks)
+	}
+
+	before, after, err := s.GetNeighbors(ctx, c, 1)
+	if err != nil {
+		t.Fatalf("GetNeighbors: %v", err)
+	}
+	if len(before) != 1 || before[0].ID != "a" || len(after) != 1 || after[0].ID != "d" {
+		t.Errorf("unexpected neighbors: %+v %+v", before, after)
+	}
+	before, after, _ = s.GetNeighbors(ctx, chunks[2], 5)
+	if len(before) != 2 || before[0].ID != "a" || before[1].ID != "b" || len(after) != 0 {
+		t.Errorf("unexpected neighbors of the last chunk: %+v %+v", before, after)
+	}
+
+	if _, err := s.DeleteRef(ctx, "repo", "main"); err != nil {
+		t.Fatalf("DeleteRef: %v", err)
+	}
+	if _, ok, _ := s.GetChunkByID(ctx, "a"); ok {
+		t.Error("expected a deleted chunk not to be found")
+	}
+}
+
+func TestSQLiteStore_UpsertAndMeta(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	c := models.Chunk{
+		ID: "1", Repository: "repo", Ref: "main", Path: "a.go", Language: "go",
+		Summary: "parses config", Content: "package a", LineStart: 1, LineEnd: 10,
+		SummaryModel: "openai/gpt", EmbedModel: "openai/embed",
+	}
+	if err := s.UpsertChunk(ctx, c, []float32{1, 0, 0}, "h1"); err != nil {
+		t.Fatalf("UpsertChunk: %v", err)
+	}
+
+	m, ok, err := s.GetChunkMeta(ctx, "repo", "a.go", 1, 10)
+	if err != nil || !ok {
+		t.Fatalf("GetChunkMeta: ok=%v err=%v", ok, err)
+	}
+	if m.ContentHash != "h1" || m.Summary != "parses config" || !m.HasSummaryVec {
+		t.Errorf("unexpected meta: %+v", m)
+	}
+
+	// An empty summary and nil vector keep the stored ones.
+	c.Summary, c.SummaryModel, c.EmbedModel = "", "", ""
+	if err := s.UpsertChunk(ctx, c, nil, "h2"); err != nil {
+		t.Fatalf("UpsertChunk: %v", err)
+	}
+	hm, ok, err := s.FindByContentHash(ctx, "h2", "openai/gpt", "openai/embed")
+	if err != nil || !ok {
+		t.Fatalf("FindByContentHash: ok=%v err=%v", ok, err)
+	}
+	if hm.Summary != "parses config" || len(hm.SummaryVec) != 3 || hm.SummaryModel != "openai/gpt" || hm.EmbedModel != "openai/embed" {
+		t.Errorf("unexpected match: %+v", hm)
+	}
+	// Artifacts of other models, such as heuristic fallback summaries, are
+	// not reused.
+	for _, models := range [][2]string{{"heuristic", "openai/embed"}, {"openai/gpt", "openai/embed-v2"}} {
+		if _, ok, err := s.FindByContentHash(ctx, "h2", models[0], models[1]); ok || err != nil {
+			t.Errorf("FindByContentHash(%v) = %v, %v; want no match", models, ok, err)
+		}
+	}
+	// Nor are those of deleted chunks, which are going away.
+	if _, err := s.DeleteRef(ctx, "repo", "main"); err != nil {
+		t.Fatalf("DeleteRef: %v", err)
+	}
+	if _, ok, err := s.FindByContentHash(ctx, "h2", "openai/gpt", "openai/embed"); ok || err != nil {
+		t.Errorf("FindByContentHash of a deleted chunk = %v, %v; want no match", ok, err)
+	}
+	if _, err := s.RestoreRef(ctx, "repo", "main"); err != nil {
+		t.Fatalf("RestoreRef: %v", err)
+	}
+
+	if _, ok, _ := s.GetChunkMeta(ctx, "repo", "missing.go", 1, 10); ok {
+		t.Error("expected no meta for missing chunk")
+	}
+}
+
+func TestSQLiteStore_Search(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	err := s.UpsertChunks(ctx, []ChunkWithVec{
+		{Chunk: models.Chunk{ID: "1", Repository: "repo", Ref: "main", Path: "db/migrate.go", Language: "go", Summary: "runs database migrations", LineStart: 1, LineEnd: 5}, SummaryVec: []float32{1, 0, 0}, ContentHash: "a"},
+		{Chunk: models.Chunk{ID: "2", Repository: "repo", Ref: "main", Path: "http/server.go", Language: "go", Summary: "starts the http server", LineStart: 1, LineEnd: 5}, SummaryVec: []float32{0, 1, 0}, ContentHash: "b"},
+		{Chunk: models.Chunk{ID: "3", Repository: "other", Ref: "main", Path: "db/schema.go", Language: "go", Summary: "database schema", LineStart: 1, LineEnd: 5}, SummaryVec: []float32{0.9, 0.1, 0}, ContentHash: "c"},
+	})
+	if err != nil {
+		t.Fatalf("UpsertChunks: %v", err)
+	}
+
+	res, err := s.Search(ctx, []float32{1, 0, 0}, 2, QueryOpts{QueryText: "database migrations"})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res) != 2 || res[0].Chunk.ID != "1" || res[1].Chunk.ID != "3" {
+		t.Fatalf("unexpected ranking: %+v", res)
+	}
+	if res[0].Chunk.CreatedAt.IsZero() {
+		t.Error("expected created_at to be set")
+	}
+
+	res, err = s.Search(ctx, []float32{1, 0, 0}, 10, QueryOpts{QueryText: "database", Repositories: []string{"repo"}, PathContains: "HTTP"})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res) != 1 || res[0].Chunk.ID != "2" {
+		t.Fatalf("filters not applied: %+v", res)
+	}
+
+	res, err = s.Search(ctx, []float32{1, 0, 0}, 10, QueryOpts{QueryText: "database", Repositories: []string{"other", "missing"}, Languages: []string{"go", "shell"}})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res) != 1 || res[0].Chunk.ID != "3" {
+		t.Fatalf("multi-value filters not applied: %+v", res)
+	}
+
+	res, err = s.Search(ctx, []float32{1, 0, 0}, 10, QueryOpts{QueryText: "database", PathRegex: `^db/.*\.go$`})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res) != 2 || res[0].Chunk.Path == "http/server.go" || res[1].Chunk.Path == "http/server.go" {
+		t.Fatalf("path regex not applied: %+v", res)
+	}
+
+	res, err = s.Search(ctx, []float32{1, 0, 0}, 10, QueryOpts{QueryText: "database", Path: "http/server.go"})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res) != 1 || res[0].Chunk.Path != "http/server.go" {
+		t.Fatalf("path not applied: %+v", res)
+	}
+
+	res, err = s.Search(ctx, []float32{1, 0, 0}, 10, QueryOpts{QueryText: "database", PathNotContains: []string{"DB/", "nope"}})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res) != 1 || res[0].Chunk.ID != "2" {
+		t.Fatalf("exclusions not applied: %+v", res)
+	}
+
+	page, err := s.SearchPage(ctx, []float32{1, 0, 0}, 1, QueryOpts{QueryText: "database migrations", Offset: 1})
+	if err != nil {
+		t.Fatalf("SearchPage: %v", err)
+	}
+	if page.Total != 3 || len(page.Results) != 1 || page.Results[0].Chunk.ID != "3" {
+		t.Fatalf("unexpected page: %+v", page)
+	}
+	// The total does not depend on the page, even past the end.
+	if page, _ := s.SearchPage(ctx, []float32{1, 0, 0}, 1, QueryOpts{QueryText: "x", Offset: 10}); len(page.Results) != 0 || page.Total != 3 {
+		t.Fatalf("expected an empty page past the end, got %+v", page)
+	}
+
+	res, err = s.Search(ctx, []float32{1, 0, 0}, 10, QueryOpts{})
+	if err != nil || len(res) != 0 {
+		t.Fatalf("expected no results for empty query, got %v, %v", res, err)
+	}
+
+	repos, err := s.GetRepositories(ctx)
+	if err != nil || len(repos) != 2 || repos[0] != "other" {
+		t.Fatalf("GetRepositories: %v, %v", repos, err)
+	}
+}
+
+func TestSQLiteStore_SimilarChunks(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	err := s.UpsertChunks(ctx, []ChunkWithVec{
+		{Chunk: models.Chunk{ID: "1", Repository: "repo", Path: "a.go", Language: "go", LineStart: 1, LineEnd: 5}, SummaryVec: []float32{1, 0, 0}, ContentHash: "a"},
+		{Chunk: models.Chunk{ID: "2", Repository: "other", Path: "b.go", Language: "go", LineStart: 1, LineEnd: 5}, SummaryVec: []float32{0.9, 0.1, 0}, ContentHash: "b"},
+		{Chunk: models.Chunk{ID: "3", Repository: "repo", Path: "c.py", Language: "python", LineStart: 1, LineEnd: 5}, SummaryVec: []float32{0, 1, 0}, ContentHash: "c"},
+		{Chunk: models.Chunk{ID: "4", Repository: "repo", Path: "d.go", Language: "go", LineStart: 1, LineEnd: 5}, ContentHash: "d"},
+	})
+	if err != nil {
+		t.Fatalf("UpsertChunks: %v", err)
+	}
+
+	res, ok, err := s.SimilarChunks(ctx, "1", 5, QueryOpts{})
+	if err != nil || !ok {
+		t.Fatalf("SimilarChunks: ok=%v err=%v", ok, err)
+	}
+	if len(res) != 2 || res[0].Chunk.ID != "2" || res[1].Chunk.ID != "3" || res[0].Score <= res[1].Score {
+		t.Fatalf("unexpected similar chunks: %+v", res)
+	}
+
+	res, _, _ = s.SimilarChunks(ctx, "1", 5, QueryOpts{Languages: []string{"python"}})
+	if len(res) != 1 || res[0].Chunk.ID != "3" {
+		t.Errorf("filters not applied: %+v", res)
+	}
+
+	for _, id := range []string{"4", "missing"} {
+		if _, ok, err := s.SimilarChunks(ctx, id, 5, QueryOpts{}); ok || err != nil {
+			t.Errorf("SimilarChunks(%q): expected not found, got ok=%v err=%v", id, ok, err)
+		}
+	}
+}
+
+func TestSQLiteStore_Facets(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	err := s.UpsertChunks(ctx, []ChunkWithVec{
+		{Chunk: models.Chunk{ID: "1", Repository: "repo", Ref: "main", Path: "db/migrate.go", Language: "go", Summary: "runs database migrations", LineStart: 1, LineEnd: 5}, ContentHash: "a"},
+		{Chunk: models.Chunk{ID: "2", Repository: "repo", Ref: "v1", Path: "db/schema.sql", Language: "sql", Summary: "database schema", LineStart: 1, LineEnd: 5}, ContentHash: "b"},
+		{Chunk: models.Chunk{ID: "3", Repository: "other", Ref: "main", Path: "main.go", Language: "go", Content: "connects to the database", LineStart: 1, LineEnd: 5}, ContentHash: "c"},
+		{Chunk: models.Chunk{ID: "4", Repository: "other", Path: "http/server.go", Language: "go", Summary: "starts the http server", LineStart: 1, LineEnd: 5}, ContentHash: "d"},
+	})
+	if err != nil {
+		t.Fatalf("UpsertChunks: %v", err)
+	}
+
+	f, err := s.Facets(ctx, QueryOpts{QueryText: "database"})
+	if err != nil {
+		t.Fatalf("Facets: %v", err)
+	}
+	want := models.Facets{
+		Repositories: []models.FacetCount{{Value: "repo", Count: 2}, {Value: "other", Count: 1}},
+		Languages:    []models.FacetCount{{Value: "go", Count: 2}, {Value: "sql", Count: 1}},
+		Refs:         []models.FacetCount{{Value: "main", Count: 2}, {Value: "v1", Count: 1}},
+		Directories:  []models.FacetCount{{Value: "db", Count: 2}, {Value: ".", Count: 1}},
+	}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("unexpected facets:\n got %+v\nwant %+v", f, want)
+	}
+
+	f, err = s.Facets(ctx, QueryOpts{Repositories: []string{"other"}})
+	if err != nil {
+		t.Fatalf("Facets: %v", err)
+	}
+	if len(f.Repositories) != 1 || f.Repositories[0].Count != 2 || len(f.Directories) != 2 {
+		t.Errorf("unexpected filtered facets: %+v", f)
+	}
+}
+
+func TestSQLiteStore_SymbolFilter(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	err := s.UpsertChunks(ctx, []ChunkWithVec{
+		{Chunk: models.Chunk{ID: "1", Repository: "repo", Path: "server.go", Language: "go", Summary: "starts the server", LineStart: 1, LineEnd: 9}, SummaryVec: []float32{1, 0}, ContentHash: "a",
+			Symbols: []Symbol{{Name: "NewServer", Kind: "func", Line: 3}, {Name: "new_conn", Kind: "func", Line: 7}}},
+		{Chunk: models.Chunk{ID: "2", Repository: "repo", Path: "client.go", Language: "go", Summary: "creates a server client", LineStart: 1, LineEnd: 9}, SummaryVec: []float32{1, 0}, ContentHash: "b",
+			Symbols: []Symbol{{Name: "NewServerClient", Kind: "func", Line: 2}, {Name: "newXconn", Kind: "func", Line: 5}}},
+	})
+	if err != nil {
+		t.Fatalf("UpsertChunks: %v", err)
+	}
+
+	ids := func(symbol string) []string {
+		t.Helper()
+		res, err := s.Search(ctx, []float32{1, 0}, 10, QueryOpts{QueryText: "server", Symbol: symbol})
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		var out []string
+		for _, r := range res {
+			out = append(out, r.Chunk.ID)
+		}
+		sort.Strings(out)
+		return out
+	}
+	if got := ids("newserver"); !reflect.DeepEqual(got, []string{"1"}) {
+		t.Errorf("exact match = %v", got)
+	}
+	if got := ids("NewServer*"); !reflect.DeepEqual(got, []string{"1", "2"}) {
+		t.Errorf("prefix match = %v", got)
+	}
+	// An underscore in a prefix is literal rather than a LIKE wildcard.
+	if got := ids("new_*"); !reflect.DeepEqual(got, []string{"1"}) {
+		t.Errorf("escaped prefix match = %v", got)
+	}
+
+	// Re-indexing a chunk replaces its symbols.
+	err = s.UpsertChunks(ctx, []ChunkWithVec{
+		{Chunk: models.Chunk{ID: "1", Repository: "repo", Path: "server.go", Language: "go", Summary: "starts the server", LineStart: 1, LineEnd: 9}, SummaryVec: []float32{1, 0}, ContentHash: "c",
+			Symbols: []Symbol{{Name: "Start", Kind: "func", Line: 3}}},
+	})
+	if err != nil {
+		t.Fatalf("UpsertChunks: %v", err)
+	}
+	if got := ids("NewServer"); got != nil {
+		t.Errorf("expected stale symbols to be replaced, got %v", got)
+	}
+
+	if _, err := s.DeleteRepository(ctx, "repo"); err != nil {
+		t.Fatalf("DeleteRepository: %v", err)
+	}
+	if _, err := s.Vacuum(ctx, ""); err != nil {
+		t.Fatalf("Vacuum: %v", err)
+	}
+	var n int
+	if err := s.db.QueryRowContext(ctx, `SELECT count(*) FROM symbols`).Scan(&n); err != nil || n != 0 {
+		t.Errorf("expected vacuum to remove orphaned symbols, got %d, %v", n, err)
+	}
+}
+
+func TestSQLiteStore_QueryStats(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	now := time.Now()
+	for _, l := range []QueryLog{
+		{Query: "database migrations", KeepText: true, K: 5, Latency: 100 * time.Millisecond, Results: 3, User: "alice", At: now},
+		{Query: "Database  Migrations", K: 5, Latency: 300 * time.Millisecond, Results: 2, User: "bob", At: now},
+		{Query: "kafka consumer", K: 5, Latency: 200 * time.Millisecond, Results: 0, At: now},
+		{Query: "old query", KeepText: true, K: 5, Latency: time.Second, Results: 0, User: "alice", At: now.Add(-48 * time.Hour)},
+	} {
+		if err := s.LogQuery(ctx, l); err != nil {
+			t.Fatalf("LogQuery: %v", err)
+		}
+	}
+
+	stats, err := s.QueryStats(ctx, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("QueryStats: %v", err)
+	}
+	if stats.Queries != 3 || stats.ZeroResults != 1 || stats.Users != 2 || stats.AvgLatencyMS != 200 {
+		t.Errorf("unexpected totals: %+v", stats)
+	}
+	// Queries differing only in case and spacing are counted together.
+	// Without its text, a query is listed by hash.
+	if len(stats.TopQueries) != 2 || stats.TopQueries[0] != (models.QueryCount{Query: "database migrations", Count: 2}) || stats.TopQueries[1].Query != queryHash("kafka consumer") {
+		t.Errorf("unexpected top queries: %+v", stats.TopQueries)
+	}
+	if !reflect.DeepEqual(stats.TopZeroResults, []models.QueryCount{{Query: queryHash("kafka consumer"), Count: 1}}) {
+		t.Errorf("unexpected zero result queries: %+v", stats.TopZeroResults)
+	}
+
+	stats, err = s.QueryStats(ctx, now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("QueryStats: %v", err)
+	}
+	if stats.Queries != 0 || stats.AvgLatencyMS != 0 || len(stats.TopQueries) != 0 || stats.TopQueries == nil {
+		t.Errorf("expected empty stats, got %+v", stats)
+	}
+}
+
+func TestSQLiteStore_SearchAudit(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	now := time.Now().Truncate(time.Second)
+	for _, a := range []models.SearchAudit{
+		{User: "alice", Path: "/search", Query: "old", Results: 1, Repositories: []string{"org/a"}, At: now.Add(-48 * time.Hour)},
+		{User: "alice", Path: "/search", Query: "payments", Filters: "language=go", Results: 2, Repositories: []string{"org/a", "org/b"}, At: now.Add(-time.Hour)},
+		{User: "bob", Path: "/search/stream", Query: "kafka", Results: 0, At: now},
+		{User: "bob", Path: "/search", Query: "wildcard", Results: 1, Repositories: []string{"org_a"}, At: now},
+	} {
+		if err := s.RecordSearchAudit(ctx, a); err != nil {
+			t.Fatalf("RecordSearchAudit: %v", err)
+		}
+	}
+
+	queries := func(f AuditFilter) []string {
+		t.Helper()
+		f.Limit = 10
+		list, err := s.SearchAudit(ctx, f)
+		if err != nil {
+			t.Fatalf("SearchAudit: %v", err)
+		}
+		var out []string
+		for _, a := range list {
+			out = append(out, a.Query)
+		}
+		return out
+	}
+	if got := queries(AuditFilter{User: "alice"}); !reflect.DeepEqual(got, []string{"payments", "old"}) {
+		t.Errorf("by user: %v", got)
+	}
+	// The repository is matched exactly, not as a LIKE pattern.
+	if got := queries(AuditFilter{Repository: "org/b"}); !reflect.DeepEqual(got, []string{"payments"}) {
+		t.Errorf("by repository: %v", got)
+	}
+	if got := queries(AuditFilter{Repository: "org_a"}); !reflect.DeepEqual(got, []string{"wildcard"}) {
+		t.Errorf("by repository with a wildcard: %v", got)
+	}
+	if got := queries(AuditFilter{Since: now.Add(-2 * time.Hour), Until: now}); !reflect.DeepEqual(got, []string{"payments"}) {
+		t.Errorf("by time: %v", got)
+	}
+
+	list, err := s.SearchAudit(ctx, AuditFilter{User: "bob", Limit: 1})
+	if err != nil || len(list) != 1 {
+		t.Fatalf("SearchAudit = %+v, %v", list, err)
+	}
+	if a := list[0]; a.Path == "" || a.Repositories == nil || !a.At.Equal(now) {
+		t.Errorf("unexpected record %+v", a)
+	}
+	list, _ = s.SearchAudit(ctx, AuditFilter{Repository: "org/a", Limit: 10})
+	if len(list) != 2 || list[0].Filters != "language=go" || !reflect.DeepEqual(list[0].Repositories, []string{"org/a", "org/b"}) {
+		t.Errorf("unexpected records %+v", list)
+	}
+}
+
+func TestSQLiteStore_Optimize(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+	if err := s.UpsertChunk(ctx, models.Chunk{ID: "1", Repository: "repo", Path: "a.go", LineStart: 1, LineEnd: 2}, []float32{1, 0, 0}, "a"); err != nil {
+		t.Fatalf("UpsertChunk: %v", err)
+	}
+	for _, reindex := range []bool{false, true} {
+		if err := s.Optimize(ctx, reindex); err != nil {
+			t.Errorf("Optimize(%v): %v", reindex, err)
+		}
+	}
+	if _, ok, err := s.GetChunkByID(ctx, "1"); !ok || err != nil {
+		t.Errorf("expected the chunk to survive optimization, got %v, %v", ok, err)
+	}
+}
+
+func TestParseOptimize(t *testing.T) {
+	for in, want := range map[string]string{"": "", "none": "", "Analyze": OptimizeAnalyze, " reindex ": OptimizeReindex} {
+		if got, err := ParseOptimize(in); err != nil || got != want {
+			t.Errorf("ParseOptimize(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseOptimize("full"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
+
+func TestSQLiteStore_SearchLexicalOnly(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	err := s.UpsertChunks(ctx, []ChunkWithVec{
+		{Chunk: models.Chunk{ID: "1", Repository: "repo", Path: "db/migrate.go", Summary: "runs database migrations", LineStart: 1, LineEnd: 5}, SummaryVec: []float32{1, 0, 0}, ContentHash: "a"},
+		{Chunk: models.Chunk{ID: "2", Repository: "repo", Path: "db/schema.go", Summary: "database schema", LineStart: 1, LineEnd: 5}, SummaryVec: []float32{1, 0, 0}, ContentHash: "b"},
+		{Chunk: models.Chunk{ID: "3", Repository: "repo", Path: "http/server.go", Summary: "starts the http server", LineStart: 1, LineEnd: 5}, SummaryVec: []float32{1, 0, 0}, ContentHash: "c"},
+	})
+	if err != nil {
+		t.Fatalf("UpsertChunks: %v", err)
+	}
+
+	// Without a query vector, chunks are ranked by the query text alone and
+	// chunks that do not match it are left out.
+	page, err := s.SearchPage(ctx, nil, 10, QueryOpts{QueryText: "database migrations"})
+	if err != nil {
+		t.Fatalf("SearchPage: %v", err)
+	}
+	if page.Total != 2 || page.Results[0].Chunk.ID != "1" || page.Results[1].Chunk.ID != "2" {
+		t.Fatalf("unexpected lexical ranking: %+v", page)
+	}
+	if page.Results[0].Score <= 0 {
+		t.Errorf("expected a positive score, got %v", page.Results[0].Score)
+	}
+}
+
+func TestSQLiteStore_SearchRRF(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	err := s.UpsertChunks(ctx, []ChunkWithVec{
+		{Chunk: models.Chunk{ID: "1", Repository: "repo", Path: "a.go", Summary: "retry logic", LineStart: 1, LineEnd: 5}, SummaryVec: []float32{1, 0, 0}, ContentHash: "a"},
+		{Chunk: models.Chunk{ID: "2", Repository: "repo", Path: "b.go", Summary: "token bucket rate limiter", LineStart: 1, LineEnd: 5}, SummaryVec: []float32{0.8, 0.6, 0}, ContentHash: "b"},
+		{Chunk: models.Chunk{ID: "3", Repository: "repo", Path: "c.go", Summary: "unrelated", LineStart: 1, LineEnd: 5}, SummaryVec: []float32{0, 0, 1}, ContentHash: "c"},
+	})
+	if err != nil {
+		t.Fatalf("UpsertChunks: %v", err)
+	}
+
+	// Chunk 2 is second semantically but the only lexical match, so RRF
+	// puts it first; chunk 3 matches neither signal.
+	res, err := s.Search(ctx, []float32{1, 0, 0}, 3, QueryOpts{QueryText: "rate limiter", Fusion: FusionRRF})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res) != 3 || res[0].Chunk.ID != "2" || res[1].Chunk.ID != "1" || res[2].Score != 0 {
+		t.Fatalf("unexpected RRF ranking: %+v", res)
+	}
+	want := 1.0/float64(rrfK+2) + 1.0/float64(rrfK+1)
+	if res[0].Score != want {
+		t.Errorf("expected score %v, got %v", want, res[0].Score)
+	}
+	if res[0].Explain != nil {
+		t.Errorf("explanation without explain: %+v", res[0].Explain)
+	}
+
+	res, err = s.Search(ctx, []float32{1, 0, 0}, 3, QueryOpts{QueryText: "rate limiter", Fusion: FusionRRF, Explain: true})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if e := res[0].Explain; e == nil || e.Fusion != FusionRRF || e.RRFK != rrfK || e.SemRank != 2 || e.LexRank != 1 || e.Weights != nil {
+		t.Errorf("unexpected RRF explanation: %+v", e)
+	}
+}
+
+func TestSQLiteStore_SearchExplain(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	err := s.UpsertChunks(ctx, []ChunkWithVec{
+		{Chunk: models.Chunk{ID: "1", Repository: "repo", Path: "limiter.go", Language: "go", Summary: "token bucket rate limiter", LineStart: 1, LineEnd: 5}, SummaryVec: []float32{1, 0, 0}, ContentHash: "a"},
+		{Chunk: models.Chunk{ID: "2", Repository: "repo", Path: "test/limiter_test.go", Language: "go", Summary: "tests", LineStart: 1, LineEnd: 5}, SummaryVec: []float32{0.6, 0.8, 0}, ContentHash: "b"},
+	})
+	if err != nil {
+		t.Fatalf("UpsertChunks: %v", err)
+	}
+
+	// The score is rebuilt from the explanation.
+	res, err := s.Search(ctx, []float32{1, 0, 0}, 2, QueryOpts{QueryText: "rate limiter", Explain: true})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res) != 2 {
+		t.Fatalf("expected two results, got %+v", res)
+	}
+	for _, r := range res {
+		e := r.Explain
+		if e == nil || e.Fusion != FusionWeighted || e.Weights == nil || *e.Weights != hybridWeights {
+			t.Fatalf("unexpected explanation: %+v", e)
+		}
+		w := e.Weights
+		score := w.Semantic*normalize(e.SemSim, e.MaxSem) + w.Lexical*normalize(e.LexSum, e.MaxLex) +
+			w.Trigram*normalize(e.Trigram, e.MaxTrigram) + w.ScriptBias*e.ScriptBias - w.NoisePenalty*e.NoisePenalty
+		if math.Abs(score-r.Score) > 1e-9 {
+			t.Errorf("%s: score %v, explained %v", r.Chunk.ID, r.Score, score)
+		}
+	}
+	if e := res[1].Explain; res[1].Chunk.ID != "2" || e.NoisePenalty != 1 || math.Abs(e.SemSim-0.6) > 1e-6 || e.MaxSem != 1 {
+		t.Errorf("unexpected explanation of the test file: %+v", e)
+	}
+
+	res, err = s.Search(ctx, nil, 2, QueryOpts{QueryText: "rate limiter", Explain: true})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if e := res[0].Explain; !e.LexicalOnly || *e.Weights != lexicalWeights {
+		t.Errorf("unexpected lexical-only explanation: %+v", e)
+	}
+}
+
+func TestSQLiteStore_SearchRanking(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	err := s.UpsertChunks(ctx, []ChunkWithVec{
+		{Chunk: models.Chunk{ID: "1", Repository: "repo", Path: "deploy.ps1", Language: "powershell", Summary: "deploy the service", LineStart: 1, LineEnd: 5}, SummaryVec: []float32{1, 0, 0}, ContentHash: "a"},
+		{Chunk: models.Chunk{ID: "2", Repository: "repo", Path: "vendor/deploy.sh", Language: "shell", Summary: "deploy the service", LineStart: 1, LineEnd: 5}, SummaryVec: []float32{1, 0, 0}, ContentHash: "b"},
+	})
+	if err != nil {
+		t.Fatalf("UpsertChunks: %v", err)
+	}
+
+	// By default the shell script is lifted over the PowerShell one.
+	res, err := s.Search(ctx, []float32{1, 0, 0}, 2, QueryOpts{QueryText: "deploy script", Explain: true})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res) != 2 || res[0].Chunk.ID != "2" || res[0].Explain.ScriptBias != 1 || res[1].Explain.ScriptBias != 0 {
+		t.Fatalf("unexpected default ranking: %+v", res)
+	}
+
+	ranking := DefaultRanking()
+	ranking.Hybrid.NoisePenalty = 0.5
+	ranking.ScriptTerms = []string{"Automation"}
+	ranking.ScriptLanguages = []string{"PowerShell"}
+	ranking.NoisePattern = `(^|/)vendor/`
+	if ranking, err = NewRanking(ranking); err != nil {
+		t.Fatalf("NewRanking: %v", err)
+	}
+	b, err := Open(ctx, "sqlite://"+filepath.Join(t.TempDir(), "x.db"), WithRanking(ranking))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer b.Close()
+	s = b.(*SQLiteStore)
+	if err := s.Migrate(ctx, 3); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	err = s.UpsertChunks(ctx, []ChunkWithVec{
+		{Chunk: models.Chunk{ID: "1", Repository: "repo", Path: "deploy.ps1", Language: "powershell", Summary: "deploy the service", LineStart: 1, LineEnd: 5}, SummaryVec: []float32{1, 0, 0}, ContentHash: "a"},
+		{Chunk: models.Chunk{ID: "2", Repository: "repo", Path: "vendor/deploy.sh", Language: "shell", Summary: "deploy the service", LineStart: 1, LineEnd: 5}, SummaryVec: []float32{1, 0, 0}, ContentHash: "b"},
+	})
+	if err != nil {
+		t.Fatalf("UpsertChunks: %v", err)
+	}
+	res, err = s.Search(ctx, []float32{1, 0, 0}, 2, QueryOpts{QueryText: "deploy automation", Explain: true})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res) != 2 || res[0].Chunk.ID != "1" || res[0].Explain.ScriptBias != 1 || res[1].Explain.NoisePenalty != 1 {
+		t.Fatalf("unexpected configured ranking: %+v", res)
+	}
+	if w := res[0].Explain.Weights; w == nil || *w != ranking.Hybrid {
+		t.Errorf("explained weights %+v; want %+v", w, ranking.Hybrid)
+	}
+}
+
+func TestNewRanking(t *testing.T) {
+	r := DefaultRanking()
+	r.Lexical.Trigram = -1
+	if _, err := NewRanking(r); err == nil {
+		t.Error("expected an error for a negative weight")
+	}
+	r = DefaultRanking()
+	r.NoisePattern = "(test"
+	if _, err := NewRanking(r); err == nil {
+		t.Error("expected an error for an invalid noise pattern")
+	}
+	r.NoisePattern = ""
+	if r, err := NewRanking(r); err != nil || r.noisePenalty("test/a.go") != 0 {
+		t.Errorf("NewRanking without a noise pattern = %+v, %v", r, err)
+	}
+}
+
+func TestParseFusion(t *testing.T) {
+	for in, want := range map[string]string{"": FusionWeighted, "RRF": FusionRRF, "weighted": FusionWeighted} {
+		if got, err := ParseFusion(in); err != nil || got != want {
+			t.Errorf("ParseFusion(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := ParseFusion("max"); err == nil {
+		t.Error("expected error for unknown fusion")
+	}
+}
+
+func TestSQLiteStore_Stats(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	for i, c := range []models.Chunk{
+		{ID: "1", Repository: "repo", Ref: "main", Path: "a.go", Language: "go", Summary: "s", Content: "package a"},
+		{ID: "2", Repository: "repo", Ref: "main", Path: "a.go", Language: "go", LineStart: 10},
+		{ID: "3", Repository: "repo", Ref: "dev", Path: "run.sh", Language: "shell"},
+		{ID: "4", Repository: "other", Ref: "main", Path: "b.py", Language: "python"},
+	} {
+		if err := s.UpsertChunk(ctx, c, nil, strconv.Itoa(i)); err != nil {
+			t.Fatalf("UpsertChunk: %v", err)
+		}
+	}
+
+	stats, err := s.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	if stats.Chunks != 4 || stats.Files != 3 || len(stats.Repositories) != 2 {
+		t.Fatalf("unexpected totals: %+v", stats)
+	}
+	r := stats.Repositories[1]
+	if r.Repository != "repo" || r.Chunks != 3 || r.Files != 2 {
+		t.Errorf("unexpected repo stats: %+v", r)
+	}
+	if len(r.Refs) != 2 || r.Refs[0] != "dev" || r.Languages["go"] != 2 || r.Languages["shell"] != 1 {
+		t.Errorf("unexpected refs or languages: %+v", r)
+	}
+	if time.Since(r.LastIndexedAt) > time.Minute {
+		t.Errorf("unexpected last indexed time %v", r.LastIndexedAt)
+	}
+	if r.StorageBytes != 10 || stats.StorageBytes != 10 {
+		t.Errorf("unexpected storage estimate %d of %d", r.StorageBytes, stats.StorageBytes)
+	}
+}
+
+func TestSQLiteStore_Delete(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	for i, ref := range []string{"main", "dev"} {
+		c := models.Chunk{ID: ref, Repository: "repo", Ref: ref, Path: "a.go", LineStart: 1, LineEnd: 2 + i}
+		if err := s.UpsertChunk(ctx, c, nil, ref); err != nil {
+			t.Fatalf("UpsertChunk: %v", err)
+		}
+	}
+	if err := s.UpsertRollup(ctx, models.Rollup{Repository: "repo", Ref: "dev", Kind: RollupFile, Path: "a.go", Summary: "s"}, []float32{1, 0, 0}, "h"); err != nil {
+		t.Fatalf("UpsertRollup: %v", err)
+	}
+
+	n, err := s.DeleteRef(ctx, "repo", "dev")
+	if err != nil || n != 1 {
+		t.Fatalf("DeleteRef: n=%d err=%v", n, err)
+	}
+	if _, ok, _ := s.GetRollupMeta(ctx, "repo", "dev", RollupFile, "a.go"); ok {
+		t.Error("expected rollup of deleted ref to be removed")
+	}
+	refs, _ := s.GetRefs(ctx, "repo")
+	if len(refs) != 1 || refs[0] != "main" {
+		t.Errorf("unexpected refs: %v", refs)
+	}
+
+	n, err = s.DeleteRepository(ctx, "repo")
+	if err != nil || n != 1 {
+		t.Fatalf("DeleteRepository: n=%d err=%v", n, err)
+	}
+	if repos, _ := s.GetRepositories(ctx); len(repos) != 0 {
+		t.Errorf("expected deleted repository to be hidden, got %v", repos)
+	}
+
+	// Deletes are soft until vacuumed.
+	n, err = s.RestoreRef(ctx, "repo", "dev")
+	if err != nil || n != 1 {
+		t.Fatalf("RestoreRef: n=%d err=%v", n, err)
+	}
+	if _, ok, _ := s.GetRollupMeta(ctx, "repo", "dev", RollupFile, "a.go"); !ok {
+		t.Error("expected rollup of restored ref to be back")
+	}
+	refs, _ = s.GetRefs(ctx, "repo")
+	if len(refs) != 1 || refs[0] != "dev" {
+		t.Errorf("unexpected refs after restore: %v", refs)
+	}
+
+	// Vacuuming a repository leaves the deleted chunks of others.
+	if err := s.UpsertChunk(ctx, models.Chunk{ID: "other", Repository: "other", Ref: "main", Path: "a.go", LineStart: 1, LineEnd: 2}, nil, "other"); err != nil {
+		t.Fatalf("UpsertChunk: %v", err)
+	}
+	if _, err := s.DeleteRepository(ctx, "other"); err != nil {
+		t.Fatalf("DeleteRepository: %v", err)
+	}
+	n, err = s.Vacuum(ctx, "repo")
+	if err != nil || n != 1 {
+		t.Fatalf("Vacuum: n=%d err=%v", n, err)
+	}
+	if n, _ := s.RestoreRepository(ctx, "repo"); n != 0 {
+		t.Errorf("expected vacuumed chunks to be gone, restored %d", n)
+	}
+	if n, _ := s.RestoreRepository(ctx, "other"); n != 1 {
+		t.Errorf("expected the other repository to be restorable, restored %d", n)
+	}
+	if _, err := s.DeleteRepository(ctx, "other"); err != nil {
+		t.Fatalf("DeleteRepository: %v", err)
+	}
+	if n, err := s.Vacuum(ctx, ""); err != nil || n != 1 {
+		t.Fatalf("Vacuum all: n=%d err=%v", n, err)
+	}
+
+	// Re-indexing a deleted chunk brings it back.
+	if _, err := s.DeleteRepository(ctx, "repo"); err != nil {
+		t.Fatalf("DeleteRepository: %v", err)
+	}
+	if err := s.UpsertChunk(ctx, models.Chunk{ID: "dev", Repository: "repo", Ref: "dev", Path: "a.go", LineStart: 1, LineEnd: 3}, nil, "dev"); err != nil {
+		t.Fatalf("UpsertChunk: %v", err)
+	}
+	if _, ok, _ := s.GetChunkMeta(ctx, "repo", "a.go", 1, 3); !ok {
+		t.Error("expected re-indexed chunk to be live")
+	}
+}
+
+func TestSQLiteStore_DeletePaths(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	for i, c := range []models.Chunk{
+		{ID: "1", Repository: "repo", Ref: "main", Path: "a.go", LineStart: 1, LineEnd: 5},
+		{ID: "2", Repository: "repo", Ref: "main", Path: "a.go", LineStart: 6, LineEnd: 9},
+		{ID: "3", Repository: "repo", Ref: "main", Path: "gone.go", LineStart: 1, LineEnd: 5},
+		{ID: "4", Repository: "repo", Ref: "dev", Path: "gone.go", LineStart: 1, LineEnd: 5},
+	} {
+		if err := s.UpsertChunk(ctx, c, nil, strconv.Itoa(i)); err != nil {
+			t.Fatalf("UpsertChunk: %v", err)
+		}
+	}
+	paths, err := s.GetPaths(ctx, "repo", "main")
+	if err != nil || len(paths) != 2 || paths["a.go"] != 2 || paths["gone.go"] != 1 {
+		t.Fatalf("GetPaths = %v, %v", paths, err)
+	}
+
+	if n, err := s.DeletePaths(ctx, "repo", "main", []string{"gone.go", "never.go"}); err != nil || n != 1 {
+		t.Fatalf("DeletePaths: n=%d err=%v", n, err)
+	}
+	if paths, _ := s.GetPaths(ctx, "repo", "main"); len(paths) != 1 || paths["a.go"] != 2 {
+		t.Errorf("unexpected paths after delete: %v", paths)
+	}
+	// Other refs keep the path.
+	if paths, _ := s.GetPaths(ctx, "repo", "dev"); paths["gone.go"] != 1 {
+		t.Errorf("unexpected dev paths: %v", paths)
+	}
+	if n, err := s.DeletePaths(ctx, "repo", "main", nil); err != nil || n != 0 {
+		t.Errorf("DeletePaths(nil): n=%d err=%v", n, err)
+	}
+}
+
+func TestSQLiteStore_Rollups(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	for _, r := range []struct {
+		path string
+		vec  []float32
+	}{{"a.go", []float32{1, 0, 0}}, {"b.go", []float32{0, 1, 0}}} {
+		err := s.UpsertRollup(ctx, models.Rollup{Repository: "repo", Ref: "main", Kind: RollupFile, Path: r.path, Summary: r.path}, r.vec, "h-"+r.path)
+		if err != nil {
+			t.Fatalf("UpsertRollup: %v", err)
+		}
+	}
+
+	m, ok, err := s.GetRollupMeta(ctx, "repo", "main", RollupFile, "a.go")
+	if err != nil || !ok || m.InputHash != "h-a.go" {
+		t.Fatalf("GetRollupMeta: %+v ok=%v err=%v", m, ok, err)
+	}
+
+	res, err := s.SearchRollups(ctx, []float32{0, 1, 0}, 1, RollupFile, QueryOpts{Repositories: []string{"repo"}})
+	if err != nil {
+		t.Fatalf("SearchRollups: %v", err)
+	}
+	if len(res) != 1 || res[0].Rollup.Path != "b.go" || res[0].Rollup.UpdatedAt.IsZero() {
+		t.Fatalf("unexpected rollups: %+v", res)
+	}
+	if res, _ := s.SearchRollups(ctx, []float32{0, 1, 0}, 5, RollupDir, QueryOpts{}); len(res) != 0 {
+		t.Errorf("expected no dir rollups, got %+v", res)
+	}
+}
+
+func TestSQLiteStore_Maintenance(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	for i, id := range []string{"a", "b", "c"} {
+		c := models.Chunk{ID: id, Repository: "repo", Path: id + ".go", Summary: "s", LineStart: 1, LineEnd: 1 + i, SummaryModel: "old", EmbedModel: "old"}
+		if id == "c" {
+			c.SummaryModel, c.EmbedModel = "new", "new"
+		}
+		if err := s.UpsertChunk(ctx, c, []float32{1, 0, 0}, id); err != nil {
+			t.Fatalf("UpsertChunk: %v", err)
+		}
+	}
+
+	stale, err := s.ListStaleSummaries(ctx, "new", "", 10)
+	if err != nil || len(stale) != 2 {
+		t.Fatalf("ListStaleSummaries: %+v, %v", stale, err)
+	}
+	page, err := s.ListStaleSummaries(ctx, "new", "a", 10)
+	if err != nil || len(page) != 1 || page[0].ID != "b" {
+		t.Fatalf("paging: %+v, %v", page, err)
+	}
+
+	if err := s.UpdateSummaries(ctx, []SummaryUpdate{{ID: "a", Summary: "fresh", Model: "new"}}); err != nil {
+		t.Fatalf("UpdateSummaries: %v", err)
+	}
+	vecs, err := s.ListStaleVectors(ctx, "new", "", 10)
+	if err != nil || len(vecs) != 2 || vecs[0].ID != "a" || vecs[0].Summary != "fresh" {
+		t.Fatalf("ListStaleVectors: %+v, %v", vecs, err)
+	}
+
+	if err := s.UpdateVectors(ctx, []VectorUpdate{{ID: "a", Vector: []float32{0, 0, 1}, Model: "new"}}); err != nil {
+		t.Fatalf("UpdateVectors: %v", err)
+	}
+	vecs, _ = s.ListStaleVectors(ctx, "new", "", 10)
+	if len(vecs) != 1 || vecs[0].ID != "b" {
+		t.Fatalf("expected only b to be stale, got %+v", vecs)
+	}
+}
+
+func TestSQLiteStore_VectorMigration(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	for i, id := range []string{"a", "b", "c"} {
+		c := models.Chunk{ID: id, Repository: "repo", Ref: "main", Path: id + ".go", Summary: "s-" + id, LineStart: 1, LineEnd: 1 + i, EmbedModel: "old"}
+		if err := s.UpsertChunk(ctx, c, []float32{1, 0, 0}, id); err != nil {
+			t.Fatalf("UpsertChunk: %v", err)
+		}
+	}
+	if err := s.UpsertRollup(ctx, models.Rollup{Repository: "repo", Ref: "main", Kind: RollupFile, Path: "a.go", Summary: "r"}, []float32{1, 0, 0}, "h"); err != nil {
+		t.Fatalf("UpsertRollup: %v", err)
+	}
+
+	if err := s.BeginVectorMigration(ctx, 4); err != nil {
+		t.Fatalf("BeginVectorMigration: %v", err)
+	}
+	first, err := s.ListPendingVectors(ctx, PendingVector{}, 2)
+	if err != nil || len(first) != 2 || first[0].ID != "a" || first[1].Summary != "s-b" {
+		t.Fatalf("ListPendingVectors: %+v, %v", first, err)
+	}
+	// The second page ends the chunks and starts the rollups.
+	rest, err := s.ListPendingVectors(ctx, first[1], 2)
+	if err != nil || len(rest) != 2 || rest[0].ID != "c" || rest[1].Path != "a.go" || rest[1].Summary != "r" {
+		t.Fatalf("ListPendingVectors: %+v, %v", rest, err)
+	}
+	if last, err := s.ListPendingVectors(ctx, rest[1], 2); err != nil || len(last) != 0 {
+		t.Fatalf("ListPendingVectors after the last rollup: %+v, %v", last, err)
+	}
+
+	var vecs []PendingVector
+	for _, v := range append(first, rest[0]) {
+		v.Vector = []float32{0, 0, 0, 1}
+		vecs = append(vecs, v)
+	}
+	if err := s.WritePendingVectors(ctx, vecs); err != nil {
+		t.Fatalf("WritePendingVectors: %v", err)
+	}
+	if err := s.CommitVectorMigration(ctx, "new"); err == nil {
+		t.Fatal("expected the pending rollup to block the commit")
+	}
+	// A rerun with the same dimension resumes; another dimension is refused.
+	if err := s.BeginVectorMigration(ctx, 4); err != nil {
+		t.Fatalf("resuming BeginVectorMigration: %v", err)
+	}
+	if err := s.BeginVectorMigration(ctx, 5); err == nil {
+		t.Fatal("expected a migration to another dimension to be refused")
+	}
+	if pending, _ := s.ListPendingVectors(ctx, PendingVector{}, 10); len(pending) != 1 || pending[0].Kind != RollupFile {
+		t.Fatalf("expected only the rollup pending, got %+v", pending)
+	}
+
+	// Re-summarizing, by UpdateSummaries or an upsert, makes a chunk pending
+	// again, and a vector listed before the change is not written.
+	if err := s.UpdateSummaries(ctx, []SummaryUpdate{{ID: "a", Summary: "s-a2", Model: "m"}}); err != nil {
+		t.Fatalf("UpdateSummaries: %v", err)
+	}
+	b := models.Chunk{ID: "b", Repository: "repo", Ref: "main", Path: "b.go", Summary: "s-b2", LineStart: 1, LineEnd: 2}
+	if err := s.UpsertChunk(ctx, b, nil, "b2"); err != nil {
+		t.Fatalf("UpsertChunk: %v", err)
+	}
+	if err := s.WritePendingVectors(ctx, vecs[:2]); err != nil {
+		t.Fatalf("WritePendingVectors: %v", err)
+	}
+	pending, err := s.ListPendingVectors(ctx, PendingVector{}, 10)
+	if err != nil || len(pending) != 3 || pending[0].Summary != "s-a2" || pending[1].Summary != "s-b2" {
+		t.Fatalf("expected the re-summarized chunks pending again, got %+v, %v", pending, err)
+	}
+	if err := s.CommitVectorMigration(ctx, "new"); err == nil {
+		t.Fatal("expected the re-summarized chunks to block the commit")
+	}
+	for i := range pending {
+		pending[i].Vector = []float32{0, 0, 0, 1}
+	}
+	pending[0].Summary = "s-a"
+	if err := s.WritePendingVectors(ctx, pending[:1]); err != nil {
+		t.Fatalf("WritePendingVectors: %v", err)
+	}
+	if again, _ := s.ListPendingVectors(ctx, PendingVector{}, 10); len(again) != 3 {
+		t.Fatalf("expected a vector of the old summary to be dropped, got %+v", again)
+	}
+	pending[0].Summary = "s-a2"
+	if err := s.WritePendingVectors(ctx, pending); err != nil {
+		t.Fatalf("WritePendingVectors: %v", err)
+	}
+	if err := s.CommitVectorMigration(ctx, "new"); err != nil {
+		t.Fatalf("CommitVectorMigration: %v", err)
+	}
+
+	if err := s.Migrate(ctx, 4); err != nil {
+		t.Fatalf("Migrate with the new dimension: %v", err)
+	}
+	if err := s.Migrate(ctx, 3); !errors.Is(err, ErrDimensionMismatch) {
+		t.Fatalf("expected ErrDimensionMismatch for the old dimension, got %v", err)
+	}
+	if stale, err := s.ListStaleVectors(ctx, "new", "", 10); err != nil || len(stale) != 0 {
+		t.Fatalf("expected every vector to be of the new model: %+v, %v", stale, err)
+	}
+	res, err := s.Search(ctx, []float32{0, 0, 0, 1}, 1, QueryOpts{QueryText: "s-a"})
+	if err != nil || len(res) != 1 {
+		t.Fatalf("Search with a new vector: %+v, %v", res, err)
+	}
+
+	// An aborted migration leaves the live vectors alone.
+	if err := s.BeginVectorMigration(ctx, 3); err != nil {
+		t.Fatalf("BeginVectorMigration: %v", err)
+	}
+	if err := s.AbortVectorMigration(ctx); err != nil {
+		t.Fatalf("AbortVectorMigration: %v", err)
+	}
+	if err := s.AbortVectorMigration(ctx); err != nil {
+		t.Fatalf("AbortVectorMigration without a migration: %v", err)
+	}
+	if err := s.Migrate(ctx, 4); err != nil {
+		t.Fatalf("Migrate after abort: %v", err)
+	}
+}
+
+func TestSQLiteStore_WriteFiles(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	chunk := func(id string, ls, le int) ChunkWithVec {
+		return ChunkWithVec{Chunk: models.Chunk{ID: id, Repository: "repo", Ref: "main", Path: "a.go", LineStart: ls, LineEnd: le, Summary: id}}
+	}
+	f := FileChunks{Repository: "repo", Ref: "main", Path: "a.go", Chunks: []ChunkWithVec{chunk("a", 1, 10), chunk("b", 11, 20)}}
+	if err := s.WriteFiles(ctx, []FileChunks{f}); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+
+	// The file shrank: the second chunk goes away and the first changes span.
+	f.Chunks = []ChunkWithVec{chunk("c", 1, 12)}
+	if err := s.WriteFiles(ctx, []FileChunks{f}); err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	for _, span := range [][2]int{{1, 10}, {11, 20}} {
+		if _, ok, _ := s.GetChunkMeta(ctx, "repo", "a.go", span[0], span[1]); ok {
+			t.Errorf("expected stale chunk %v to be removed", span)
+		}
+	}
+	if _, ok, _ := s.GetChunkMeta(ctx, "repo", "a.go", 1, 12); !ok {
+		t.Error("expected new chunk to be written")
+	}
+
+	// A failing file rolls back the whole write.
+	bad := FileChunks{Repository: "repo", Ref: "main", Path: "b.go", Chunks: []ChunkWithVec{
+		{Chunk: models.Chunk{ID: "d", Repository: "repo", Ref: "main", Path: "b.go", LineStart: 1, LineEnd: 2}},
+		{Chunk: models.Chunk{ID: "d", Repository: "repo", Ref: "main", Path: "b.go", LineStart: 3, LineEnd: 4}},
+	}}
+	f.Chunks = nil
+	if err := s.WriteFiles(ctx, []FileChunks{f, bad}); err == nil {
+		t.Fatal("expected duplicate chunk IDs to fail")
+	}
+	if _, ok, _ := s.GetChunkMeta(ctx, "repo", "a.go", 1, 12); !ok {
+		t.Error("expected the failed write to be rolled back")
+	}
+}
+
+func TestSQLiteStore_ChatTurns(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	turns := []models.ChatTurn{
+		{Role: "user", Content: "How are tokens refreshed?", Query: "token refresh"},
+		{Role: "assistant", Content: "Hourly [1].", Sources: []models.Citation{{N: 1, ChunkID: "c1", Path: "auth.go"}}},
+	}
+	if err := s.AppendChatTurns(ctx, "s1", "alice", 0, turns); err != nil {
+		t.Fatalf("AppendChatTurns: %v", err)
+	}
+	if err := s.AppendChatTurns(ctx, "s1", "bob", 1, turns[:1]); err == nil {
+		t.Fatal("expected a conflicting turn to fail")
+	}
+
+	got, owner, err := s.ChatHistory(ctx, "s1")
+	if err != nil {
+		t.Fatalf("ChatHistory: %v", err)
+	}
+	if owner != "alice" || len(got) != 2 {
+		t.Fatalf("got %d turns owned by %q", len(got), owner)
+	}
+	if got[0].Query != "token refresh" || got[1].Role != "assistant" || len(got[1].Sources) != 1 || got[1].Sources[0].ChunkID != "c1" {
+		t.Errorf("unexpected turns: %+v", got)
+	}
+	if got[0].CreatedAt.IsZero() {
+		t.Error("expected created_at to be set")
+	}
+
+	if got, _, err := s.ChatHistory(ctx, "missing"); err != nil || len(got) != 0 {
+		t.Errorf("ChatHistory(missing) = %v, %v", got, err)
+	}
+}
+
+func TestSQLiteStore_Suggest(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	err := s.UpsertChunks(ctx, []ChunkWithVec{
+		{Chunk: models.Chunk{ID: "1", Repository: "repo", Path: "internal/parser/lexer.go", LineStart: 1, LineEnd: 5}, ContentHash: "a",
+			Symbols: []Symbol{{Name: "ParseFile", Kind: "func", Line: 2}, {Name: "parseExpr", Kind: "func", Line: 4}}},
+		{Chunk: models.Chunk{ID: "2", Repository: "repo", Path: "cmd/parse.go", LineStart: 1, LineEnd: 5}, ContentHash: "b",
+			Symbols: []Symbol{{Name: "ParseFile", Kind: "func", Line: 1}}},
+		{Chunk: models.Chunk{ID: "3", Repository: "repo", Path: "cmd/parse.go", LineStart: 6, LineEnd: 9}, ContentHash: "c"},
+		{Chunk: models.Chunk{ID: "4", Repository: "other", Path: "sparse/matrix.go", LineStart: 1, LineEnd: 5}, ContentHash: "d",
+			Symbols: []Symbol{{Name: "Parser", Kind: "type", Line: 1}}},
+	})
+	if err != nil {
+		t.Fatalf("UpsertChunks: %v", err)
+	}
+	for _, l := range []QueryLog{
+		{Query: "parse errors", Results: 3}, {Query: "Parse  errors", Results: 1}, {Query: "parser state", Results: 2},
+		{Query: "parse nothing", Results: 0}, {Query: "lexer", Results: 1},
+		{Query: "parse private secrets", Results: 4, User: "alice"},
+	} {
+		l.KeepText = true
+		if err := s.LogQuery(ctx, l); err != nil {
+			t.Fatalf("LogQuery: %v", err)
+		}
+	}
+
+	got, err := s.Suggest(ctx, "Pars", 5, "", QueryOpts{})
+	if err != nil {
+		t.Fatalf("Suggest: %v", err)
+	}
+	want := models.Suggestions{
+		Paths: []models.Suggestion{
+			{Text: "cmd/parse.go", Count: 2},
+			{Text: "internal/parser/lexer.go", Count: 1},
+			{Text: "sparse/matrix.go", Count: 1},
+		},
+		Symbols: []models.Suggestion{
+			{Text: "ParseFile", Detail: "func", Count: 2},
+			{Text: "Parser", Detail: "type", Count: 1},
+			{Text: "parseExpr", Detail: "func", Count: 1},
+		},
+		Queries: []models.Suggestion{
+			{Text: "Parse  errors", Count: 2},
+			{Text: "parser state", Count: 1},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected suggestions:\n got %+v\nwant %+v", got, want)
+	}
+
+	got, err = s.Suggest(ctx, "pars", 1, "", QueryOpts{Repositories: []string{"other"}})
+	if err != nil {
+		t.Fatalf("Suggest: %v", err)
+	}
+	if len(got.Paths) != 1 || got.Paths[0].Text != "sparse/matrix.go" || len(got.Symbols) != 1 || got.Symbols[0].Text != "Parser" || len(got.Queries) != 1 {
+		t.Errorf("unexpected filtered suggestions: %+v", got)
+	}
+
+	// Another user never sees alice's searches, which may have matched
+	// repositories only she can read.
+	got, err = s.Suggest(ctx, "parse", 5, "bob", QueryOpts{})
+	if err != nil || len(got.Queries) != 0 {
+		t.Errorf("expected no query suggestions for bob, got %+v, %v", got.Queries, err)
+	}
+	got, err = s.Suggest(ctx, "parse", 5, "alice", QueryOpts{})
+	if err != nil || !reflect.DeepEqual(got.Queries, []models.Suggestion{{Text: "parse private secrets", Count: 1}}) {
+		t.Errorf("unexpected query suggestions for alice: %+v, %v", got.Queries, err)
+	}
+
+	if got, err := s.Suggest(ctx, "  ", 5, "", QueryOpts{}); err != nil || len(got.Paths)+len(got.Symbols)+len(got.Queries) != 0 {
+		t.Errorf("Suggest(blank) = %+v, %v", got, err)
+	}
+}
+
+func TestSQLiteStore_SavedSearches(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	now := time.Now().Truncate(time.Second)
+	iam := models.SavedSearch{ID: "1", Name: "terraform IAM", Query: "terraform modules touching IAM", K: 20,
+		Filters: models.SearchFilters{Languages: []string{"hcl"}, PathContains: "modules/"}, CreatedAt: now, UpdatedAt: now}
+	for _, c := range []struct {
+		user string
+		ss   models.SavedSearch
+	}{
+		{"alice", iam},
+		{"alice", models.SavedSearch{ID: "2", Name: "Auth", Query: "token refresh", CreatedAt: now, UpdatedAt: now}},
+		{"bob", models.SavedSearch{ID: "3", Name: "mine", Query: "q", CreatedAt: now, UpdatedAt: now}},
+	} {
+		if err := s.CreateSavedSearch(ctx, c.user, c.ss); err != nil {
+			t.Fatalf("CreateSavedSearch: %v", err)
+		}
+	}
+
+	list, err := s.ListSavedSearches(ctx, "alice")
+	if err != nil {
+		t.Fatalf("ListSavedSearches: %v", err)
+	}
+	if len(list) != 2 || list[0].Name != "Auth" || !reflect.DeepEqual(list[1].Filters, iam.Filters) || list[1].K != 20 || !list[1].CreatedAt.Equal(now) {
+		t.Errorf("unexpected saved searches: %+v", list)
+	}
+
+	if _, ok, err := s.GetSavedSearch(ctx, "bob", "1"); err != nil || ok {
+		t.Errorf("another user's saved search was returned: %v, %v", ok, err)
+	}
+	iam.Query, iam.Filters = "iam policies", models.SearchFilters{}
+	if ok, err := s.UpdateSavedSearch(ctx, "bob", iam); err != nil || ok {
+		t.Errorf("another user's saved search was updated: %v, %v", ok, err)
+	}
+	if ok, err := s.UpdateSavedSearch(ctx, "alice", iam); err != nil || !ok {
+		t.Fatalf("UpdateSavedSearch = %v, %v", ok, err)
+	}
+	got, ok, err := s.GetSavedSearch(ctx, "alice", "1")
+	if err != nil || !ok || got.Query != "iam policies" || len(got.Filters.Languages) != 0 {
+		t.Errorf("GetSavedSearch = %+v, %v, %v", got, ok, err)
+	}
+
+	if ok, err := s.DeleteSavedSearch(ctx, "bob", "1"); err != nil || ok {
+		t.Errorf("another user's saved search was deleted: %v, %v", ok, err)
+	}
+	if ok, err := s.DeleteSavedSearch(ctx, "alice", "1"); err != nil || !ok {
+		t.Errorf("DeleteSavedSearch = %v, %v", ok, err)
+	}
+	if list, _ := s.ListSavedSearches(ctx, "alice"); len(list) != 1 {
+		t.Errorf("expected one saved search left, got %+v", list)
+	}
+}
+
+func TestSQLiteStore_IndexVersion(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	v0, err := s.IndexVersion(ctx)
+	if err != nil {
+		t.Fatalf("IndexVersion: %v", err)
+	}
+	if err := s.UpsertChunk(ctx, models.Chunk{ID: "1", Repository: "repo", Path: "a.go", LineStart: 1, LineEnd: 2}, []float32{1, 0, 0}, "a"); err != nil {
+		t.Fatalf("UpsertChunk: %v", err)
+	}
+	v1, _ := s.IndexVersion(ctx)
+	if v1 == v0 {
+		t.Error("expected a write to change the version")
+	}
+	if v, _ := s.IndexVersion(ctx); v != v1 {
+		t.Errorf("version changed without a write: %d, then %d", v1, v)
+	}
+	if _, err := s.DeleteRepository(ctx, "repo"); err != nil {
+		t.Fatalf("DeleteRepository: %v", err)
+	}
+	if v, _ := s.IndexVersion(ctx); v == v1 {
+		t.Error("expected a delete to change the version")
+	}
+}
+
+func TestSQLiteStore_APIKeys(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	now := time.Now().Truncate(time.Second)
+	for i, name := range []string{"ci", "slack bot"} {
+		k := models.APIKey{ID: name, Name: name, Prefix: "rsk_" + name, Scopes: []string{"index", "search"}, CreatedBy: "alice", CreatedAt: now.Add(time.Duration(i) * time.Minute)}
+		if err := s.CreateAPIKey(ctx, k, "hash-"+name); err != nil {
+			t.Fatalf("CreateAPIKey: %v", err)
+		}
+	}
+
+	k, ok, err := s.LookupAPIKey(ctx, "hash-ci")
+	if err != nil || !ok || k.ID != "ci" || k.LastUsedAt == nil || k.RevokedAt != nil || k.ExpiresAt != nil ||
+		len(k.Scopes) != 2 || k.Scopes[0] != "index" || k.Scopes[1] != "search" {
+		t.Fatalf("LookupAPIKey = %+v, %v, %v", k, ok, err)
+	}
+	if _, ok, err := s.LookupAPIKey(ctx, "hash-unknown"); err != nil || ok {
+		t.Errorf("unknown key was found: %v, %v", ok, err)
+	}
+
+	if ok, err := s.RevokeAPIKey(ctx, "ci", now); err != nil || !ok {
+		t.Fatalf("RevokeAPIKey = %v, %v", ok, err)
+	}
+	if ok, err := s.RevokeAPIKey(ctx, "ci", now); err != nil || ok {
+		t.Errorf("key was revoked twice: %v, %v", ok, err)
+	}
+	if _, ok, err := s.LookupAPIKey(ctx, "hash-ci"); err != nil || ok {
+		t.Errorf("revoked key was found: %v, %v", ok, err)
+	}
+
+	list, err := s.ListAPIKeys(ctx)
+	if err != nil {
+		t.Fatalf("ListAPIKeys: %v", err)
+	}
+	if len(list) != 2 || list[0].Name != "slack bot" || list[0].LastUsedAt != nil ||
+		list[1].RevokedAt == nil || !list[1].RevokedAt.Equal(now) || list[1].CreatedBy != "alice" {
+		t.Errorf("unexpected keys: %+v", list)
+	}
+
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+	for _, k := range []models.APIKey{
+		{ID: "expired", Name: "expired", Prefix: "rsk_x", Scopes: []string{"search"}, CreatedBy: "alice", CreatedAt: now, ExpiresAt: &past},
+		{ID: "expiring", Name: "expiring", Prefix: "rsk_y", Scopes: []string{"search"}, CreatedBy: "alice", CreatedAt: now, ExpiresAt: &future},
+	} {
+		if err := s.CreateAPIKey(ctx, k, "hash-"+k.ID); err != nil {
+			t.Fatalf("CreateAPIKey: %v", err)
+		}
+	}
+	if _, ok, err := s.LookupAPIKey(ctx, "hash-expired"); err != nil || ok {
+		t.Errorf("expired key was found: %v, %v", ok, err)
+	}
+	if k, ok, err := s.LookupAPIKey(ctx, "hash-expiring"); err != nil || !ok || k.ExpiresAt == nil || !k.ExpiresAt.Equal(future) || k.Repositories != nil {
+		t.Errorf("LookupAPIKey(expiring) = %+v, %v, %v", k, ok, err)
+	}
+
+	bot := models.APIKey{ID: "bot", Name: "bot", Prefix: "rsk_z", Scopes: []string{"search"}, Repositories: []string{"org/api", "org/docs"}, CreatedBy: "alice", CreatedAt: now}
+	if err := s.CreateAPIKey(ctx, bot, "hash-bot"); err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if k, ok, err := s.LookupAPIKey(ctx, "hash-bot"); err != nil || !ok || len(k.Repositories) != 2 || k.Repositories[1] != "org/docs" {
+		t.Errorf("LookupAPIKey(bot) = %+v, %v, %v", k, ok, err)
+	}
+}
+
+func TestSQLiteStore_AllowedRepositories(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	var chunks []ChunkWithVec
+	for i, repo := range []string{"org/api", "org/docs", "org/infra"} {
+		chunks = append(chunks, ChunkWithVec{Chunk: models.Chunk{ID: repo, Repository: repo, Ref: "main", Path: "db.go", Language: "go", Summary: "database", LineStart: 1, LineEnd: 5}, SummaryVec: []float32{1, 0, 0}, ContentHash: string(rune('a' + i))})
+	}
+	if err := s.UpsertChunks(ctx, chunks); err != nil {
+		t.Fatalf("UpsertChunks: %v", err)
+	}
+
+	// AllowedRepositories holds whatever repositories are asked for.
+	for _, tt := range []struct {
+		allowed, repos []string
+		want           int
+	}{
+		{nil, nil, 3},
+		{[]string{}, nil, 0},
+		{[]string{"org/api", "org/docs"}, nil, 2},
+		{[]string{"org/api", "org/docs"}, []string{"org/infra"}, 0},
+		{[]string{"org/api", "org/docs"}, []string{"org/docs", "org/infra"}, 1},
+	} {
+		opt := QueryOpts{QueryText: "database", AllowedRepositories: tt.allowed, Repositories: tt.repos}
+		res, err := s.Search(ctx, []float32{1, 0, 0}, 10, opt)
+		if err != nil || len(res) != tt.want {
+			t.Errorf("Search(%v, %v) = %d results, %v; want %d", tt.allowed, tt.repos, len(res), err, tt.want)
+		}
+		f, err := s.Facets(ctx, opt)
+		if err != nil || len(f.Repositories) != tt.want {
+			t.Errorf("Facets(%v, %v) = %+v, %v", tt.allowed, tt.repos, f.Repositories, err)
+		}
+	}
+}
+
+func TestSQLiteStore_RefreshTokens(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	now := time.Now().Truncate(time.Second)
+	for _, hash := range []string{"hash-1", "hash-expired"} {
+		expires := now.Add(time.Hour)
+		if hash == "hash-expired" {
+			expires = now.Add(-time.Minute)
+		}
+		tok := models.RefreshToken{Family: "f-" + hash, User: `{"login":"alice"}`, CreatedAt: now, ExpiresAt: expires}
+		if err := s.CreateRefreshToken(ctx, tok, hash); err != nil {
+			t.Fatalf("CreateRefreshToken: %v", err)
+		}
+	}
+
+	tok, ok, err := s.UseRefreshToken(ctx, "hash-1", now)
+	if err != nil || !ok || tok.Family != "f-hash-1" || tok.User != `{"login":"alice"}` || tok.UsedAt == nil {
+		t.Fatalf("UseRefreshToken = %+v, %v, %v", tok, ok, err)
+	}
+	if _, ok, err := s.UseRefreshToken(ctx, "hash-expired", now); err != nil || ok {
+		t.Errorf("expired token was used: %v, %v", ok, err)
+	}
+	if _, ok, err := s.UseRefreshToken(ctx, "hash-unknown", now); err != nil || ok {
+		t.Errorf("unknown token was used: %v, %v", ok, err)
+	}
+
+	// The rotated token works until the first one is replayed.
+	next := models.RefreshToken{Family: tok.Family, User: tok.User, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	if err := s.CreateRefreshToken(ctx, next, "hash-2"); err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	if _, _, err := s.UseRefreshToken(ctx, "hash-1", now); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reused token: err = %v, want ErrRefreshTokenReused", err)
+	}
+	if _, ok, err := s.UseRefreshToken(ctx, "hash-2", now); err != nil || ok {
+		t.Errorf("token of a revoked family was used: %v, %v", ok, err)
+	}
+
+	if err := s.CreateRefreshToken(ctx, models.RefreshToken{Family: "f-3", User: "{}", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}, "hash-3"); err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	if err := s.RevokeRefreshTokens(ctx, "f-3", now); err != nil {
+		t.Fatalf("RevokeRefreshTokens: %v", err)
+	}
+	if _, ok, err := s.UseRefreshToken(ctx, "hash-3", now); err != nil || ok {
+		t.Errorf("revoked token was used: %v, %v", ok, err)
+	}
+}
+
+func TestSQLiteStore_TokenRevocation(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	now := time.Now()
+	if revoked, err := s.TokenRevoked(ctx, "t1", "alice", now); err != nil || revoked {
+		t.Fatalf("TokenRevoked before revocation = %v, %v", revoked, err)
+	}
+	if err := s.RevokeToken(ctx, "t1", now.Add(time.Hour)); err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+	if err := s.RevokeToken(ctx, "t1", now.Add(time.Hour)); err != nil {
+		t.Fatalf("RevokeToken twice: %v", err)
+	}
+	if revoked, err := s.TokenRevoked(ctx, "t1", "alice", now); err != nil || !revoked {
+		t.Errorf("revoked token: TokenRevoked = %v, %v", revoked, err)
+	}
+	if revoked, err := s.TokenRevoked(ctx, "t2", "alice", now); err != nil || revoked {
+		t.Errorf("other token: TokenRevoked = %v, %v", revoked, err)
+	}
+
+	if err := s.RevokeSessions(ctx, "alice", now); err != nil {
+		t.Fatalf("RevokeSessions: %v", err)
+	}
+	if revoked, err := s.TokenRevoked(ctx, "t2", "alice", now.Add(-time.Minute)); err != nil || !revoked {
+		t.Errorf("token issued before the sessions were revoked: TokenRevoked = %v, %v", revoked, err)
+	}
+	if revoked, err := s.TokenRevoked(ctx, "t3", "alice", now.Add(time.Minute)); err != nil || revoked {
+		t.Errorf("token issued after the sessions were revoked: TokenRevoked = %v, %v", revoked, err)
+	}
+	if revoked, err := s.TokenRevoked(ctx, "t2", "bob", now.Add(-time.Minute)); err != nil || revoked {
+		t.Errorf("token of another user: TokenRevoked = %v, %v", revoked, err)
+	}
+}
+
+func TestSQLiteStore_IncrementUsage(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	day := time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC)
+	for i, tc := range []struct {
+		login, kind string
+		at          time.Time
+		want        int
+	}{
+		{"alice", "search", day, 1},
+		{"alice", "search", day.Add(30 * time.Minute), 2},
+		{"alice", "ask", day, 1},
+		{"bob", "search", day, 1},
+		{"alice", "search", day.Add(2 * time.Hour), 1}, // the next day
+	} {
+		n, err := s.IncrementUsage(ctx, tc.login, tc.kind, tc.at)
+		if err != nil || n != tc.want {
+			t.Errorf("%d: IncrementUsage(%s, %s) = %d, %v; want %d", i, tc.login, tc.kind, n, err, tc.want)
+		}
+	}
+}
+
+func TestSQLiteStore_AuthEvents(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	now := time.Now().Truncate(time.Second)
+	for _, e := range []models.AuthEvent{
+		{Event: "login", User: "alice", IP: "10.0.0.1", UserAgent: "curl", Outcome: "success", At: now.Add(-48 * time.Hour)},
+		{Event: "validation", IP: "10.0.0.9", Outcome: "failure", Detail: "token is expired", At: now.Add(-time.Hour)},
+		{Event: "refresh", User: "alice", IP: "10.0.0.1", Outcome: "success", At: now},
+	} {
+		if err := s.RecordAuthEvent(ctx, e); err != nil {
+			t.Fatalf("RecordAuthEvent: %v", err)
+		}
+	}
+
+	events := func(f AuthEventFilter) []string {
+		t.Helper()
+		f.Limit = 10
+		list, err := s.AuthEvents(ctx, f)
+		if err != nil {
+			t.Fatalf("AuthEvents: %v", err)
+		}
+		var out []string
+		for _, e := range list {
+			out = append(out, e.Event)
+		}
+		return out
+	}
+	if got := events(AuthEventFilter{User: "alice"}); !reflect.DeepEqual(got, []string{"refresh", "login"}) {
+		t.Errorf("by user: %v", got)
+	}
+	if got := events(AuthEventFilter{Outcome: "failure"}); !reflect.DeepEqual(got, []string{"validation"}) {
+		t.Errorf("by outcome: %v", got)
+	}
+	if got := events(AuthEventFilter{Event: "login", Since: now.Add(-time.Hour)}); got != nil {
+		t.Errorf("by event and time: %v", got)
+	}
+
+	list, err := s.AuthEvents(ctx, AuthEventFilter{Event: "validation", Limit: 1})
+	if err != nil || len(list) != 1 || list[0].IP != "10.0.0.9" || list[0].Detail != "token is expired" || !list[0].At.Equal(now.Add(-time.Hour)) {
+		t.Errorf("AuthEvents = %+v, %v", list, err)
+	}
+}
+
+func TestSQLiteStore_RepositoryStatus(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	for i, path := range []string{"a.go", "b.go"} {
+		c := models.Chunk{ID: path, Repository: "repo", Ref: "main", Path: path, LineStart: 1, LineEnd: 2}
+		if err := s.UpsertChunk(ctx, c, nil, strconv.Itoa(i)); err != nil {
+			t.Fatalf("UpsertChunk: %v", err)
+		}
+	}
+	start := time.Now().Truncate(time.Second)
+	runs := []models.IndexRun{
+		{Repository: "repo", Ref: "main", Commit: "c1", StartedAt: start, FinishedAt: start.Add(time.Minute), ChunksUpserted: 2},
+		{Repository: "repo", Ref: "main", Commit: "c2", StartedAt: start.Add(time.Hour), FinishedAt: start.Add(time.Hour + time.Minute), Error: "rate limited"},
+		{Repository: "repo", Ref: "dev", Commit: "d1", StartedAt: start, FinishedAt: start.Add(time.Minute), Error: "clone failed"},
+		{Repository: "other", Ref: "main", Commit: "o1", StartedAt: start.Add(2 * time.Hour), FinishedAt: start.Add(2 * time.Hour)},
+	}
+	for _, r := range runs {
+		if err := s.RecordIndexRun(ctx, r); err != nil {
+			t.Fatalf("RecordIndexRun: %v", err)
+		}
+	}
+
+	status, err := s.RepositoryStatus(ctx, "repo")
+	if err != nil {
+		t.Fatalf("RepositoryStatus: %v", err)
+	}
+	if len(status.Refs) != 2 || status.Refs[0].Ref != "dev" || status.Refs[1].Ref != "main" {
+		t.Fatalf("unexpected refs: %+v", status.Refs)
+	}
+	dev, main := status.Refs[0], status.Refs[1]
+	if dev.Chunks != 0 || dev.LastIndexedAt != nil || dev.LastRun == nil || dev.LastRun.Error != "clone failed" {
+		t.Errorf("dev = %+v", dev)
+	}
+	if main.Chunks != 2 || main.LastCommit != "c1" || main.LastIndexedAt == nil || !main.LastIndexedAt.Equal(start.Add(time.Minute)) ||
+		main.LastRun == nil || main.LastRun.Commit != "c2" || main.LastRun.Error != "rate limited" {
+		t.Errorf("main = %+v (last run %+v)", main, main.LastRun)
+	}
+
+	if status, err := s.RepositoryStatus(ctx, "unknown"); err != nil || len(status.Refs) != 0 {
+		t.Errorf("unknown repository: %+v, %v", status, err)
+	}
+}
+
+func TestSQLiteStore_Jobs(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	now := time.Now().Truncate(time.Second)
+	enqueue := func(id, key string, at time.Time) bool {
+		t.Helper()
+		ok, err := s.EnqueueJob(ctx, models.Job{ID: id, Kind: "index", Params: json.RawMessage(`{"url":"u"}`), User: "alice", CreatedAt: at}, key)
+		if err != nil {
+			t.Fatalf("EnqueueJob: %v", err)
+		}
+		return ok
+	}
+	if !enqueue("a", "u@main", now) || !enqueue("b", "", now.Add(time.Second)) || !enqueue("c", "", now.Add(2*time.Second)) {
+		t.Fatal("EnqueueJob refused a job")
+	}
+	if enqueue("dup", "u@main", now) {
+		t.Error("a second job with an active dedup key was queued")
+	}
+	// Concurrent enqueues of the same key queue one job.
+	var queued atomic.Int32
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := s.EnqueueJob(ctx, models.Job{ID: fmt.Sprintf("race%d", i), Kind: "index", CreatedAt: now.Add(3 * time.Second)}, "v@main")
+			if err != nil {
+				t.Errorf("EnqueueJob: %v", err)
+			}
+			if ok {
+				queued.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := queued.Load(); n != 1 {
+		t.Errorf("%d concurrent jobs with the same dedup key were queued", n)
+	}
+	if _, err := s.db.ExecContext(ctx, `INSERT INTO jobs (id, kind, dedup_key, status, created_at) VALUES ('raw', 'index', 'v@main', 'queued', ?)`, now); err == nil {
+		t.Error("expected the dedup index to reject a second active job")
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM jobs WHERE dedup_key = 'v@main'`); err != nil {
+		t.Fatal(err)
+	}
+
+	job, ok, err := s.ClaimJob(ctx, now)
+	if err != nil || !ok || job.ID != "a" || job.Status != models.JobRunning || job.StartedAt == nil || string(job.Params) != `{"url":"u"}` {
+		t.Fatalf("ClaimJob = %+v, %v, %v", job, ok, err)
+	}
+	if cancel, err := s.HeartbeatJob(ctx, "a", map[string]int64{"files_scanned": 3}, now); err != nil || cancel {
+		t.Errorf("HeartbeatJob = %v, %v", cancel, err)
+	}
+	for i, msg := range []string{"cloning", "indexing"} {
+		if err := s.AppendJobLog(ctx, "a", i, models.JobLog{At: now, Message: msg}); err != nil {
+			t.Fatalf("AppendJobLog: %v", err)
+		}
+	}
+
+	// Canceling a queued job finishes it; a running job is only asked to stop.
+	if job, ok, err := s.CancelJob(ctx, "b", now); err != nil || !ok || job.Status != models.JobCanceled || job.FinishedAt == nil {
+		t.Errorf("CancelJob(queued) = %+v, %v, %v", job, ok, err)
+	}
+	if job, ok, err := s.CancelJob(ctx, "a", now); err != nil || !ok || job.Status != models.JobRunning || !job.CancelRequested {
+		t.Errorf("CancelJob(running) = %+v, %v, %v", job, ok, err)
+	}
+	if _, ok, err := s.CancelJob(ctx, "missing", now); err != nil || ok {
+		t.Errorf("CancelJob(missing) = %v, %v", ok, err)
+	}
+	if cancel, err := s.HeartbeatJob(ctx, "a", nil, now); err != nil || !cancel {
+		t.Errorf("HeartbeatJob after cancel = %v, %v", cancel, err)
+	}
+	if err := s.FinishJob(ctx, "a", models.JobCanceled, "context canceled", map[string]int64{"files_scanned": 5}, now.Add(time.Minute)); err != nil {
+		t.Fatalf("FinishJob: %v", err)
+	}
+	if !enqueue("d", "u@main", now.Add(3*time.Second)) {
+		t.Error("dedup key still blocked after the job finished")
+	}
+
+	got, ok, err := s.GetJob(ctx, "a")
+	if err != nil || !ok || got.Status != models.JobCanceled || got.Progress["files_scanned"] != 5 || got.Error != "context canceled" {
+		t.Errorf("GetJob = %+v, %v, %v", got, ok, err)
+	}
+	logs, err := s.JobLogs(ctx, "a")
+	if err != nil || len(logs) != 2 || logs[0].Message != "cloning" || logs[1].Message != "indexing" {
+		t.Errorf("JobLogs = %+v, %v", logs, err)
+	}
+
+	// The next claim takes the oldest queued job, skipping canceled ones.
+	if job, ok, err := s.ClaimJob(ctx, now); err != nil || !ok || job.ID != "c" {
+		t.Errorf("second ClaimJob = %+v, %v, %v", job, ok, err)
+	}
+	if n, err := s.FailStaleJobs(ctx, now.Add(time.Second), now.Add(time.Hour)); err != nil || n != 1 {
+		t.Errorf("FailStaleJobs = %d, %v", n, err)
+	}
+	if got, _, _ := s.GetJob(ctx, "c"); got.Status != models.JobFailed || got.Error == "" {
+		t.Errorf("stale job = %+v", got)
+	}
+
+	list, err := s.ListJobs(ctx, "index", 10)
+	if err != nil || len(list) != 4 || list[0].ID != "d" || list[3].ID != "a" {
+		t.Errorf("ListJobs = %+v, %v", list, err)
+	}
+	if list, err := s.ListJobs(ctx, "reembed", 10); err != nil || len(list) != 0 {
+		t.Errorf("ListJobs(reembed) = %+v, %v", list, err)
+	}
+
+	// Only finished jobs are deleted, with their logs.
+	if n, err := s.DeleteFinishedJobs(ctx, now.Add(2*time.Hour)); err != nil || n != 3 {
+		t.Errorf("DeleteFinishedJobs = %d, %v", n, err)
+	}
+	if logs, err := s.JobLogs(ctx, "a"); err != nil || len(logs) != 0 {
+		t.Errorf("logs of a deleted job: %+v, %v", logs, err)
+	}
+	if list, _ := s.ListJobs(ctx, "", 10); len(list) != 1 || list[0].ID != "d" {
+		t.Errorf("jobs left: %+v", list)
+	}
+}
+
+func TestSQLiteStore_RepositoryGrants(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLite(t)
+
+	err := s.UpsertChunks(ctx, []ChunkWithVec{
+		{Chunk: models.Chunk{ID: "1", Repository: "open", Ref: "main", Path: "db.go", Language: "go", Summary: "database", LineStart: 1, LineEnd: 5}, SummaryVec: []float32{1, 0, 0}, ContentHash: "a"},
+		{Chunk: models.Chunk{ID: "2", Repository: "private", Ref: "main", Path: "db.go", Language: "go", Summary: "database", LineStart: 1, LineEnd: 5}, SummaryVec: []float32{1, 0, 0}, ContentHash: "b"},
+	})
+	if err != nil {
+		t.Fatalf("UpsertChunks: %v", err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	for _, g := range []models.RepositoryGrant{
+		{Repository: "private", Principal: "bob", Role: models.RoleRead, GrantedBy: "alice", GrantedAt: now},
+		{Repository: "private", Principal: "alice", Role: models.RoleAdmin, GrantedBy: "alice", GrantedAt: now},
+		{Repository: "private", Principal: "bob", Role: models.RoleAdmin, GrantedBy: "alice", GrantedAt: now},
+	} {
+		if err := s.GrantRepository(ctx, g); err != nil {
+			t.Fatalf("GrantRepository: %v", err)
+		}
+	}
+	grants, err := s.RepositoryGrants(ctx, "private")
+	if err != nil || len(grants) != 2 || grants[0].Principal != "alice" || grants[1].Role != models.RoleAdmin || !grants[1].GrantedAt.Equal(now) {
+		t.Fatalf("RepositoryGrants = %+v, %v", grants, err)
+	}
+	if hidden, err := s.HiddenRepositories(ctx, []string{"carol"}); err != nil || len(hidden) != 1 || hidden[0] != "private" {
+		t.Errorf("HiddenRepositories(carol) = %v, %v", hidden, err)
+	}
+	if hidden, err := s.HiddenRepositories(ctx, []string{"carol", "bob"}); err != nil || len(hidden) != 0 {
+		t.Errorf("HiddenRepositories(bob) = %v, %v", hidden, err)
+	}
+
+	// Without principals searches are unrestricted; with them private
+	// repositories are limited to their grantees.
+	for _, tt := range []struct {
+		principals []string
+		want       int
+	}{{nil, 2}, {[]string{}, 1}, {[]string{"carol"}, 1}, {[]string{"bob"}, 2}} {
+		res, err := s.Search(ctx, []float32{1, 0, 0}, 10, QueryOpts{QueryText: "database", Principals: tt.principals})
+		if err != nil || len(res) != tt.want {
+			t.Errorf("Search(%v) = %d results, %v; want %d", tt.principals, len(res), err, tt.want)
+		}
+		f, err := s.Facets(ctx, QueryOpts{Principals: tt.principals})
+		if err != nil || len(f.Repositories) != tt.want {
+			t.Errorf("Facets(%v) = %+v, %v", tt.principals, f.Repositories, err)
+		}
+	}
+
+	if ok, err := s.RevokeRepositoryGrant(ctx, "private", "bob"); err != nil || !ok {
+		t.Fatalf("RevokeRepositoryGrant = %v, %v", ok, err)
+	}
+	if ok, err := s.RevokeRepositoryGrant(ctx, "private", "bob"); err != nil || ok {
+		t.Errorf("grant was revoked twice: %v, %v", ok, err)
+	}
+	if res, err := s.Search(ctx, []float32{1, 0, 0}, 10, QueryOpts{QueryText: "database", Principals: []string{"bob"}}); err != nil || len(res) != 1 || res[0].Chunk.Repository != "open" {
+		t.Errorf("Search after revoke = %+v, %v", res, err)
+	}
+
+	// Replacing the grants of a grantor leaves the others alone.
+	team := models.RepositoryGrant{Repository: "open", Principal: "team:acme/backend", Role: models.RoleRead, GrantedAt: now}
+	if err := s.ReplaceRepositoryGrants(ctx, "config", []models.RepositoryGrant{team}); err != nil {
+		t.Fatalf("ReplaceRepositoryGrants: %v", err)
+	}
+	if grants, err := s.RepositoryGrants(ctx, "open"); err != nil || len(grants) != 1 || grants[0].GrantedBy != "config" {
+		t.Errorf("RepositoryGrants(open) = %+v, %v", grants, err)
+	}
+	if err := s.ReplaceRepositoryGrants(ctx, "config", nil); err != nil {
+		t.Fatalf("ReplaceRepositoryGrants: %v", err)
+	}
+	if hidden, err := s.HiddenRepositories(ctx, []string{"carol"}); err != nil || len(hidden) != 1 || hidden[0] != "private" {
+		t.Errorf("HiddenRepositories after replace = %v, %v", hidden, err)
+	}
+}
@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// Stats returns chunk, file, ref and language counts per repository along
+// with when each was last written to and an estimate of its storage size.
+func (s *Store) Stats(ctx context.Context) (models.IndexStats, error) {
+	rows, err := s.read.Query(ctx, `
+      SELECT repository, COUNT(*), COUNT(DISTINCT path),
+             ARRAY_AGG(DISTINCT ref ORDER BY ref),
+             MAX(GREATEST(created_at, summarized_at)),
+             SUM(COALESCE(octet_length(summary), 0) + COALESCE(octet_length(content), 0) +
+                 COALESCE(pg_column_size(summary_vec), 0))
+      FROM chunks
+      WHERE deleted_at IS NULL
+      GROUP BY repository
+      ORDER BY repository`)
+	if err != nil {
+		return models.IndexStats{}, err
+	}
+	defer rows.Close()
+
+	stats := models.IndexStats{Repositories: []models.RepositoryStats{}}
+	byRepo := map[string]*models.RepositoryStats{}
+	for rows.Next() {
+		var r models.RepositoryStats
+		var last *time.Time
+		if err := rows.Scan(&r.Repository, &r.Chunks, &r.Files, &r.Refs, &last, &r.StorageBytes); err != nil {
+			return models.IndexStats{}, err
+		}
+		if last != nil {
+			r.LastIndexedAt = *last
+		}
+		r.Languages = map[string]int64{}
+		stats.Repositories = append(stats.Repositories, r)
+	}
+	if err := rows.Err(); err != nil {
+		return models.IndexStats{}, err
+	}
+	for i := range stats.Repositories {
+		r := &stats.Repositories[i]
+		byRepo[r.Repository] = r
+		stats.Chunks += r.Chunks
+		stats.Files += r.Files
+		stats.StorageBytes += r.StorageBytes
+	}
+
+	rows, err = s.read.Query(ctx, `
+      SELECT repository, COALESCE(language, ''), COUNT(*)
+      FROM chunks
+      WHERE deleted_at IS NULL
+      GROUP BY 1, 2`)
+	if err != nil {
+		return models.IndexStats{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var repo, lang string
+		var n int64
+		if err := rows.Scan(&repo, &lang, &n); err != nil {
+			return models.IndexStats{}, err
+		}
+		if r, ok := byRepo[repo]; ok {
+			r.Languages[lang] = n
+		}
+	}
+	return stats, rows.Err()
+}
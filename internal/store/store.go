@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"regexp"
@@ -16,7 +17,14 @@ import (
 
 // Store provides methods to interact with the database.
 type Store struct {
-	pool *pgxpool.Pool
+	pool       *pgxpool.Pool
+	read       *pgxpool.Pool // searches and listings; pool unless a replica is set
+	replicaURL string
+	poolOpts   PoolOptions
+	index      IndexOptions
+	vectors    VectorIndex // optional external home for chunk vectors
+	tsConfig   string      // text search configuration, e.g. "english"
+	ranking    Ranking
 }
 
 // ChunkStore defines the methods that the Store must implement.
@@ -24,28 +32,58 @@ type ChunkStore interface {
 	GetRepositories(ctx context.Context) ([]string, error)
 	Migrate(ctx context.Context, summaryDim int) error
 	UpsertChunk(ctx context.Context, c models.Chunk, summaryVec []float32, contentHash string) error
+	UpsertChunks(ctx context.Context, chunks []ChunkWithVec) error
 	Search(ctx context.Context, summaryVec []float32, k int, opt QueryOpts) ([]models.SearchResult, error)
 	GetChunkMeta(ctx context.Context, repository, path string, ls, le int) (ChunkMeta, bool, error)
+	FindByContentHash(ctx context.Context, contentHash, summaryModel, embedModel string) (HashMatch, bool, error)
 }
 
 // New creates a new Store instance connected to the given database URL.
-func New(ctx context.Context, url string) (*Store, error) {
-	cfg, err := pgxpool.ParseConfig(url)
+func New(ctx context.Context, url string, opts ...Option) (*Store, error) {
+	s := &Store{index: DefaultIndexOptions(), tsConfig: DefaultTextSearchConfig, ranking: DefaultRanking()}
+	for _, o := range opts {
+		o(s)
+	}
+	p, err := s.newPool(ctx, url)
 	if err != nil {
 		return nil, err
 	}
-	p, err := pgxpool.NewWithConfig(ctx, cfg)
+	s.pool, s.read = p, p
+	if s.replicaURL != "" {
+		if s.read, err = s.newPool(ctx, s.replicaURL); err != nil {
+			p.Close()
+			return nil, fmt.Errorf("read replica: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// newPool opens a pool to url with the configured pool options.
+func (s *Store) newPool(ctx context.Context, url string) (*pgxpool.Pool, error) {
+	cfg, err := pgxpool.ParseConfig(url)
 	if err != nil {
 		return nil, err
 	}
-	return &Store{pool: p}, nil
+	s.poolOpts.apply(cfg)
+	return pgxpool.NewWithConfig(ctx, cfg)
+}
+
+// WithReadReplica sends searches and repository listings to the database at
+// url, leaving writes and the reads the indexer depends on to the primary.
+func WithReadReplica(url string) Option {
+	return func(s *Store) { s.replicaURL = url }
 }
 
-func (s *Store) Close() { s.pool.Close() }
+func (s *Store) Close() {
+	if s.read != s.pool {
+		s.read.Close()
+	}
+	s.pool.Close()
+}
 
 // GetRepositories returns a list of all unique repositories in the database.
 func (s *Store) GetRepositories(ctx context.Context) ([]string, error) {
-	rows, err := s.pool.Query(ctx, "SELECT DISTINCT repository FROM chunks ORDER BY repository")
+	rows, err := s.read.Query(ctx, "SELECT DISTINCT repository FROM chunks WHERE deleted_at IS NULL ORDER BY repository")
 	if err != nil {
 		return nil, err
 	}
@@ -79,22 +117,18 @@ CREATE TABLE IF NOT EXISTS chunks (
   content       TEXT,
   line_start    INT,
   line_end      INT,
-  summary_vec   vector(%d),
+  summary_vec   vector(%[1]d),
   content_hash  TEXT,
   summarized_at TIMESTAMP WITH TIME ZONE,
   created_at    TIMESTAMP WITH TIME ZONE DEFAULT now(),
-  ts_fielded    tsvector GENERATED ALWAYS AS (
-	setweight(
-	  to_tsvector('english',
-		regexp_replace(coalesce(path,''), '[^A-Za-z0-9]+', ' ', 'g')
-	  ),
-	  'A'
-	) ||
-	setweight(to_tsvector('english', coalesce(summary,'')), 'B') ||
-	setweight(to_tsvector('english', coalesce(content,'')), 'C')
+  ts_fielded    tsvector GENERATED ALWAYS AS (%[2]s
   ) STORED
 );
 
+ALTER TABLE chunks ADD COLUMN IF NOT EXISTS summary_model TEXT;
+ALTER TABLE chunks ADD COLUMN IF NOT EXISTS embed_model TEXT;
+ALTER TABLE chunks ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+
 CREATE UNIQUE INDEX IF NOT EXISTS chunks_repo_path_span_ref_uidx
   ON chunks (repository, ref, path, line_start, line_end);
 
@@ -105,36 +139,53 @@ CREATE INDEX IF NOT EXISTS chunks_hash_idx
   ON chunks (content_hash);
 CREATE INDEX IF NOT EXISTS chunks_ts_fielded_gin
   ON chunks USING GIN (ts_fielded);
+CREATE INDEX IF NOT EXISTS chunks_path_trgm_gin
+  ON chunks USING GIN (path gin_trgm_ops);
+
+CREATE TABLE IF NOT EXISTS rollups (
+  repository  TEXT NOT NULL,
+  ref         TEXT NOT NULL DEFAULT '',
+  kind        TEXT NOT NULL,
+  path        TEXT NOT NULL,
+  summary     TEXT,
+  summary_vec vector(%[1]d),
+  input_hash  TEXT,
+  updated_at  TIMESTAMP WITH TIME ZONE DEFAULT now(),
+  PRIMARY KEY (repository, ref, kind, path)
+);
 
-CREATE INDEX IF NOT EXISTS chunks_summary_vec_idx
-  ON chunks USING hnsw (summary_vec vector_cosine_ops) WITH (m = 16, ef_construction = 64);
-`
-	_, err := s.pool.Exec(ctx, fmt.Sprintf(q, summaryDim))
-	return err
-}
-
-// UpsertChunk inserts or updates a chunk.
-func (s *Store) UpsertChunk(
-	ctx context.Context,
-	c models.Chunk,
-	summaryVec []float32, // Only summary vector now
-	contentHash string,
-) error {
-	var sv any
-	if summaryVec != nil {
-		sv = pgvector.NewVector(summaryVec)
-	} else {
-		sv = (*pgvector.Vector)(nil)
+ALTER TABLE rollups ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+` + symbolsSchema + symbolsNameIndexPG + queryLogSchema + chatSchema + savedSearchSchema + apiKeySchema + apiKeyColumnsPG + repositoryGrantSchema + refreshTokenSchema + revocationSchema + usageSchema + indexRunSchema + jobSchema + searchAuditSchema + authEventSchema + indexVersionSchemaPG
+	if err := s.checkDimension(ctx, summaryDim); err != nil {
+		return err
+	}
+	if _, err := s.pool.Exec(ctx, fmt.Sprintf(q, summaryDim, tsFieldedExpr(s.tsConfig))); err != nil {
+		return err
 	}
+	if err := s.ensureTextSearchConfig(ctx); err != nil {
+		return fmt.Errorf("text search config %q: %w", s.tsConfig, err)
+	}
+	if s.vectors != nil {
+		if err := s.vectors.EnsureCollection(ctx, summaryDim); err != nil {
+			return fmt.Errorf("vector index: %w", err)
+		}
+	}
+	if err := s.ensureVectorIndex(ctx, "chunks", "chunks_summary_vec_idx"); err != nil {
+		return err
+	}
+	return s.ensureVectorIndex(ctx, "rollups", "rollups_summary_vec_idx")
+}
 
-	const q = `
+const upsertChunkSQL = `
 		INSERT INTO chunks (
 			id, repository, ref, path, language, summary, content,
-			line_start, line_end, summary_vec, content_hash, summarized_at, created_at
+			line_start, line_end, summary_vec, content_hash, summarized_at, created_at,
+			summary_model, embed_model
 		) VALUES (
 			$1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,
 			CASE WHEN $6 <> '' THEN now() ELSE NULL END,
-			now()
+			now(),
+			NULLIF($12, ''), NULLIF($13, '')
 		)
 		ON CONFLICT (repository, ref, path, line_start, line_end) DO UPDATE SET
 			language     = EXCLUDED.language,
@@ -143,21 +194,191 @@ func (s *Store) UpsertChunk(
 			summary      = COALESCE(NULLIF(EXCLUDED.summary, ''), chunks.summary),
 			summarized_at = COALESCE(EXCLUDED.summarized_at, chunks.summarized_at),
 			summary_vec  = COALESCE(EXCLUDED.summary_vec, chunks.summary_vec),
-			created_at   = chunks.created_at;`
+			summary_model = COALESCE(EXCLUDED.summary_model, chunks.summary_model),
+			embed_model  = COALESCE(EXCLUDED.embed_model, chunks.embed_model),
+			created_at   = chunks.created_at,
+			deleted_at   = NULL;`
 
-	_, err := s.pool.Exec(ctx, q,
+// upsertChunkArgs returns the parameters of upsertChunkSQL.
+func upsertChunkArgs(c models.Chunk, summaryVec []float32, contentHash string) []any {
+	var sv any
+	if summaryVec != nil {
+		sv = pgvector.NewVector(summaryVec)
+	} else {
+		sv = (*pgvector.Vector)(nil)
+	}
+	return []any{
 		c.ID, c.Repository, c.Ref, c.Path, c.Language, c.Summary, c.Content,
-		c.LineStart, c.LineEnd, sv, contentHash,
-	)
+		c.LineStart, c.LineEnd, sv, contentHash, c.SummaryModel, c.EmbedModel,
+	}
+}
+
+// UpsertChunk inserts or updates a chunk.
+func (s *Store) UpsertChunk(
+	ctx context.Context,
+	c models.Chunk,
+	summaryVec []float32, // Only summary vector now
+	contentHash string,
+) error {
+	if s.vectors != nil {
+		return s.UpsertChunks(ctx, []ChunkWithVec{{Chunk: c, SummaryVec: summaryVec, ContentHash: contentHash}})
+	}
+	_, err := s.pool.Exec(ctx, upsertChunkSQL, upsertChunkArgs(c, summaryVec, contentHash)...)
 	return err
 }
 
+// ChunkWithVec is a chunk queued for a bulk upsert.
+type ChunkWithVec struct {
+	Chunk       models.Chunk `json:"chunk"`
+	SummaryVec  []float32    `json:"summary_vec,omitempty"`
+	ContentHash string       `json:"content_hash"`
+	Symbols     []Symbol     `json:"symbols,omitempty"` // replaces the symbols stored for the chunk
+}
+
+// UpsertChunks inserts or updates many chunks in one round trip. The batch
+// runs in a single transaction, so either every chunk is written or none is.
+func (s *Store) UpsertChunks(ctx context.Context, chunks []ChunkWithVec) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	if s.vectors != nil {
+		var err error
+		if chunks, err = s.upsertVectors(ctx, chunks); err != nil {
+			return err
+		}
+	}
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	b := &pgx.Batch{}
+	for _, c := range chunks {
+		b.Queue(upsertChunkSQL, upsertChunkArgs(c.Chunk, c.SummaryVec, c.ContentHash)...)
+		queueSymbols(b, c)
+	}
+	if err := tx.SendBatch(ctx, b).Close(); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// upsertVectors writes the vectors of chunks to the external index and
+// returns the chunks with their vectors removed, so none are stored in
+// Postgres.
+func (s *Store) upsertVectors(ctx context.Context, chunks []ChunkWithVec) ([]ChunkWithVec, error) {
+	var points []VectorPoint
+	out := make([]ChunkWithVec, len(chunks))
+	for i, c := range chunks {
+		if c.SummaryVec != nil {
+			points = append(points, VectorPoint{
+				ID: c.Chunk.ID, Repository: c.Chunk.Repository, Ref: c.Chunk.Ref,
+				Path: c.Chunk.Path, Language: c.Chunk.Language, Vector: c.SummaryVec,
+			})
+		}
+		c.SummaryVec = nil
+		out[i] = c
+	}
+	if err := s.vectors.Upsert(ctx, points); err != nil {
+		return nil, fmt.Errorf("vector index: %w", err)
+	}
+	return out, nil
+}
+
 type QueryOpts struct {
-	Repository   string // optional: filter by specific repository
-	Ref          string // optional: filter by specific repository reference, e.g., branch
-	Language     string // optional: "shell"|"python"|"go"|...
-	PathContains string // optional substring filter
-	QueryText    string // raw q for BM25/tsquery
+	Repositories []string // optional: match any of these repositories
+	Ref          string   // optional: filter by specific repository reference, e.g., branch
+	Languages    []string // optional: match any of "shell"|"python"|"go"|...
+	Path         string   // optional: exact path, to search within one file
+	PathContains string   // optional substring filter
+	PathRegex    string   // optional: POSIX regular expression the path must match, e.g. `cmd/.*/main\.go`
+	// PathNotContains excludes paths containing any of these substrings,
+	// e.g. "_test.go" or "vendor/".
+	PathNotContains []string
+	QueryText       string // raw q for BM25/tsquery
+	// Symbol restricts results to chunks defining this identifier, matched
+	// case-insensitively; a trailing "*" matches it as a prefix.
+	Symbol   string
+	EfSearch int    // optional: hnsw.ef_search override for this query
+	Probes   int    // optional: ivfflat.probes override for this query
+	Fusion   string // optional: FusionWeighted (default) or FusionRRF
+	Offset   int    // optional: number of ranked results to skip
+	Explain  bool   // optional: set SearchResult.Explain on each result
+	// Principals, when not nil, restricts results to the repositories these
+	// principals may read: those without grants and those granted to one of
+	// them. See models.RepositoryGrant.
+	Principals []string
+	// AllowedRepositories, when not nil, restricts results to these
+	// repositories whatever the other filters, e.g. for an API key limited
+	// to some repositories.
+	AllowedRepositories []string
+}
+
+// PagedSearcher is implemented by stores that can skip results and report
+// how many chunks were ranked.
+type PagedSearcher interface {
+	SearchPage(ctx context.Context, summaryVec []float32, k int, opt QueryOpts) (models.SearchPage, error)
+}
+
+// Fusion strategies for combining the semantic and lexical rankings.
+const (
+	// FusionWeighted blends max-normalized scores with fixed weights.
+	FusionWeighted = "weighted"
+	// FusionRRF ranks the semantic and lexical signals separately and sums
+	// their reciprocal ranks, which is stable even for tiny result sets.
+	FusionRRF = "rrf"
+)
+
+// rrfK is the rank constant of reciprocal rank fusion.
+const rrfK = 60
+
+// Default weights of weighted fusion, with and without a query embedding.
+var (
+	hybridWeights  = models.ScoreWeights{Semantic: 0.80, Lexical: 0.15, Trigram: 0.05, ScriptBias: 0.10, NoisePenalty: 0.07}
+	lexicalWeights = models.ScoreWeights{Lexical: 0.75, Trigram: 0.25, ScriptBias: 0.10, NoisePenalty: 0.07}
+)
+
+// weightedScoreSQL is the weighted fusion score over the columns of the
+// ranked CTE of SearchPage.
+func weightedScoreSQL(w models.ScoreWeights) string {
+	return fmt.Sprintf(`
+      %g * COALESCE(sem_sim / NULLIF(max_sem,0), 0) +
+      %g * COALESCE(lex_sum / NULLIF(max_lex,0), 0) +
+      %g * COALESCE(tri     / NULLIF(max_tri,0), 0) +
+      %g * script_bias -
+      %g * noise_penalty`, w.Semantic, w.Lexical, w.Trigram, w.ScriptBias, w.NoisePenalty)
+}
+
+// explanation returns the explanation of a result with the given signals,
+// filling in the fusion and RRF constant from opt and the weights from r.
+func explanation(opt QueryOpts, r Ranking, lexicalOnly bool, e models.ScoreExplanation) *models.ScoreExplanation {
+	e.LexicalOnly = lexicalOnly
+	if opt.Fusion == FusionRRF {
+		e.Fusion, e.RRFK = FusionRRF, rrfK
+		return &e
+	}
+	e.SemRank, e.LexRank = 0, 0
+	e.Fusion = FusionWeighted
+	w := r.weights(lexicalOnly)
+	e.Weights = &w
+	return &e
+}
+
+// lexicalMinTrigram is the path similarity a chunk without lexical matches
+// needs to be returned by a lexical-only search (pg_trgm's default threshold).
+const lexicalMinTrigram = 0.3
+
+// ParseFusion validates a fusion strategy.
+func ParseFusion(f string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(f)) {
+	case "", FusionWeighted:
+		return FusionWeighted, nil
+	case FusionRRF:
+		return FusionRRF, nil
+	default:
+		return "", fmt.Errorf("unsupported fusion: %s (expected weighted or rrf)", f)
+	}
 }
 
 func (s *Store) Search(
@@ -166,61 +387,172 @@ func (s *Store) Search(
 	k int,
 	opt QueryOpts,
 ) ([]models.SearchResult, error) {
-	qtext := strings.TrimSpace(opt.QueryText)
-	if qtext == "" {
-		return []models.SearchResult{}, nil
+	p, err := s.SearchPage(ctx, summaryVec, k, opt)
+	return p.Results, err
+}
+
+// SearchPage ranks chunks like Search, skipping the first opt.Offset results,
+// and reports the total number of chunks ranked.
+func (s *Store) SearchPage(ctx context.Context, summaryVec []float32, k int, opt QueryOpts) (models.SearchPage, error) {
+	if strings.TrimSpace(opt.QueryText) == "" {
+		return models.SearchPage{Results: []models.SearchResult{}}, nil
+	}
+	if opt.Offset < 0 {
+		opt.Offset = 0
+	}
+
+	// With an external vector index the semantic scores of its nearest
+	// neighbours are passed in as a JSON object of id -> similarity. Without
+	// a query vector (the embedding failed) $1 is NULL and ranking is purely
+	// lexical.
+	lexicalOnly := len(summaryVec) == 0
+	var sv any = pgvector.NewVector(summaryVec)
+	switch {
+	case lexicalOnly:
+		sv = nil
+	case s.vectors != nil:
+		scores, err := s.vectorScores(ctx, summaryVec, k+opt.Offset, opt)
+		if err != nil {
+			return models.SearchPage{}, err
+		}
+		sv = scores
 	}
+	ranked, q, args := s.searchPageSQL(sv, lexicalOnly, k, opt)
 
-	sv := pgvector.NewVector(summaryVec)
+	rows, release, err := s.queryWithSetting(ctx, s.searchSetting(opt), q, args...)
+	if err != nil {
+		return models.SearchPage{}, err
+	}
+	defer release()
+	defer rows.Close()
+
+	var page models.SearchPage
+	for rows.Next() {
+		var c models.Chunk
+		var score float64
+		dest := []any{
+			&c.ID, &c.Repository, &c.Ref, &c.Path, &c.Language, &c.Summary, &c.Content, &c.LineStart, &c.LineEnd, &c.CreatedAt,
+			&score, &page.Total,
+		}
+		var e models.ScoreExplanation
+		var semRank, lexRank int64
+		if opt.Explain {
+			dest = append(dest, &e.SemSim, &e.LexSum, &e.Trigram, &e.ScriptBias, &e.NoisePenalty,
+				&e.MaxSem, &e.MaxLex, &e.MaxTrigram, &semRank, &lexRank)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return models.SearchPage{}, err
+		}
+		r := models.SearchResult{Chunk: c, Score: score}
+		if opt.Explain {
+			e.SemRank, e.LexRank = int(semRank), int(lexRank)
+			r.Explain = explanation(opt, s.ranking, lexicalOnly, e)
+		}
+		page.Results = append(page.Results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return models.SearchPage{}, err
+	}
+	// A page past the last result has no rows to carry the total, so it is
+	// counted on its own.
+	if len(page.Results) == 0 && opt.Offset > 0 {
+		if err := s.read.QueryRow(ctx, ranked+`SELECT COUNT(*) FROM ranked`, args...).Scan(&page.Total); err != nil {
+			return models.SearchPage{}, err
+		}
+	}
+	withSnippets(page.Results, opt.QueryText)
+	return page, nil
+}
+
+// searchPageSQL builds the query of SearchPage: ranked defines a CTE of
+// every matching chunk, and page selects the requested page from it. sv is
+// the query vector, the scores of an external index, or nil when the
+// ranking is lexical only.
+func (s *Store) searchPageSQL(sv any, lexicalOnly bool, k int, opt QueryOpts) (ranked, page string, args []any) {
+	qtext := strings.TrimSpace(opt.QueryText)
 	longest := longestToken(qtext)
 
 	// Light "did they ask for scripts" nudge
-	lq := strings.ToLower(qtext)
-	askedForScript := strings.Contains(lq, "script") ||
-		strings.Contains(lq, "scripts") ||
-		strings.Contains(lq, "bash") ||
-		strings.Contains(lq, "shell") ||
-		strings.Contains(lq, "code") ||
-		strings.Contains(lq, "program") ||
-		strings.Contains(lq, "programs") ||
-		strings.Contains(lq, "python") ||
-		strings.Contains(lq, "cli")
+	askedForScript := s.ranking.askedForScript(strings.ToLower(qtext))
+
+	// Without a noise pattern no path is penalized.
+	var noise any
+	if s.ranking.NoisePattern != "" {
+		noise = s.ranking.NoisePattern
+	}
 
 	// Build params
-	args := []any{
-		sv,             // $1 summary vector
-		qtext,          // $2 raw query text
-		longest,        // $3 trigram token
-		askedForScript, // $4 bool
-	}
-	ai := 5
-
-	where := "TRUE"
-	if opt.Repository != "" {
-		where += fmt.Sprintf(" AND repository = $%d", ai)
-		args = append(args, opt.Repository)
-		ai++
-	}
-	if opt.Language != "" {
-		where += fmt.Sprintf(" AND language = $%d", ai)
-		args = append(args, opt.Language)
-		ai++
-	}
-	if opt.PathContains != "" {
-		where += fmt.Sprintf(" AND path ILIKE '%%' || $%d || '%%'", ai)
-		args = append(args, opt.PathContains)
-		ai++
-	}
-	if opt.Ref != "" {
-		where += fmt.Sprintf(" AND ref = $%d", ai)
-		args = append(args, opt.Ref)
-		// Note: ai++ removed as it's not needed after this point
-	}
-
-	q := fmt.Sprintf(`
-WITH parsed AS (
+	args = []any{
+		sv,                           // $1 summary vector or external scores
+		qtext,                        // $2 raw query text
+		longest,                      // $3 trigram token
+		askedForScript,               // $4 bool
+		s.ranking.ScriptLanguages,    // $5 languages lifted when scripts are asked for
+		s.ranking.NonScriptLanguages, // $6 languages sunk when scripts are asked for
+		noise,                        // $7 noise path pattern
+	}
+	where, args := filterSQL("deleted_at IS NULL", args, opt, true)
+
+	// The semantic signal of the nearest neighbours, from the external
+	// index or else from the vector index, which ef_search or probes tune,
+	// rather than from comparing the query with every chunk. Other chunks
+	// are ranked on their lexical signals alone.
+	extCTE, semExpr, from := "", "", ""
+	switch {
+	case lexicalOnly:
+		semExpr = "COALESCE($1::float8, 0)"
+		from = "chunks"
+	case s.vectors != nil:
+		extCTE = "ext AS (\n  SELECT key AS id, value::float8 AS sem FROM jsonb_each_text($1::jsonb)\n),\n"
+		semExpr = "LEAST(GREATEST(COALESCE(ext.sem, 0), 0), 1)"
+		from = "chunks LEFT JOIN ext USING (id)"
+	default:
+		extCTE = fmt.Sprintf(`ann AS MATERIALIZED (
+  SELECT id, 1.0 - (summary_vec <=> $1::vector) AS sem
+  FROM chunks
+  WHERE %s AND summary_vec IS NOT NULL
+  ORDER BY summary_vec <=> $1::vector
+  LIMIT %d
+),
+`, where, vectorCandidateCount(k+opt.Offset))
+		semExpr = "LEAST(GREATEST(COALESCE(ann.sem, 0), 0), 1)"
+		from = "chunks LEFT JOIN ann USING (id)"
+	}
+
+	// Weighted fusion normalizes each signal by its window MAX(); RRF ranks
+	// the semantic and lexical signals independently instead.
+	score := weightedScoreSQL(s.ranking.weights(lexicalOnly))
+	ranks, matched := "", ""
+	if lexicalOnly {
+		// Only chunks matching the query text, or whose path resembles it.
+		matched = fmt.Sprintf("\n  WHERE lex_sum > 0 OR tri >= %g", lexicalMinTrigram)
+	}
+	if opt.Fusion == FusionRRF {
+		ranks = `,
+         RANK() OVER (ORDER BY sem_sim DESC) AS sem_rank,
+         RANK() OVER (ORDER BY lex_sum DESC) AS lex_rank`
+		score = fmt.Sprintf(`
+      CASE WHEN sem_sim > 0 THEN 1.0 / (%[1]d + sem_rank) ELSE 0 END +
+      CASE WHEN lex_sum > 0 THEN 1.0 / (%[1]d + lex_rank) ELSE 0 END`, rrfK)
+	}
+	// explain=true also returns the signals behind each score.
+	explainCols := ""
+	if opt.Explain {
+		explainCols = `,
+  sem_sim::float8, lex_sum::float8, tri::float8, script_bias::float8, noise_penalty::float8,
+  max_sem::float8, max_lex::float8, max_tri::float8`
+		if opt.Fusion == FusionRRF {
+			explainCols += ", sem_rank, lex_rank"
+		} else {
+			explainCols += ", 0::bigint, 0::bigint"
+		}
+	}
+
+	// ranked holds every matching chunk; the page is selected from it.
+	ranked = fmt.Sprintf(`
+WITH %[1]sparsed AS (
   SELECT lower(x) AS lx
-  FROM ts_debug('english', $2) d, unnest(d.lexemes) AS x
+  FROM ts_debug('%[6]s', $2) d, unnest(d.lexemes) AS x
   WHERE d.alias NOT IN ('StopWord','Space','Blank','Punct','Num')
 ),
 terms AS (
@@ -229,14 +561,13 @@ terms AS (
 ),
 q AS (
   SELECT
-    $1::vector AS sv,
-    to_tsquery('english',
+    to_tsquery('%[6]s',
       (SELECT CASE WHEN cardinality(all_terms) > 0
                    THEN array_to_string(all_terms, ' | ')
                    ELSE NULL END
        FROM terms)
     ) AS tq_any,
-    phraseto_tsquery('english',
+    phraseto_tsquery('%[6]s',
       (SELECT CASE WHEN cardinality(all_terms) > 0
                    THEN array_to_string(all_terms, ' ')
                    ELSE NULL END
@@ -250,12 +581,12 @@ cand AS (
     id, repository, ref, path, language, summary, content, line_start, line_end, created_at,
 
     -- Summary embedding similarity (now the primary signal)
-    LEAST(GREATEST((1.0 - cosine_distance(summary_vec, (SELECT sv FROM q))), 0), 1) AS sem_sim,
+    %[2]s AS sem_sim,
 
     -- Lexical similarity of summary
     LEAST(GREATEST(
       ts_rank_cd(
-        setweight(to_tsvector('english', coalesce(summary,'')), 'B'),
+        setweight(to_tsvector('%[6]s', coalesce(summary,'')), 'B'),
         (COALESCE((SELECT tq_any FROM q), ''::tsquery)
          || COALESCE((SELECT tq_phrase FROM q), ''::tsquery))
       ), 0), 1) AS lex_sum,
@@ -266,8 +597,8 @@ cand AS (
     CASE
       WHEN (SELECT asked_script FROM q) THEN
         CASE
-          WHEN language IN ('shell','bash','sh','python','py','go') THEN 1
-          WHEN language IN ('yaml','terraform','tf','json')         THEN -1
+          WHEN lower(language) = ANY($5::text[]) THEN 1
+          WHEN lower(language) = ANY($6::text[]) THEN -1
           ELSE 0
         END
       ELSE 0
@@ -275,52 +606,48 @@ cand AS (
 
     -- Noise penalty
     CASE
-      WHEN lower(path) ~ '(?:(^|.*/))(sample|example|test|mock|fixture|tmp|temp|sandbox)(/|\\.|$)' THEN 1
+      WHEN lower(path) ~ $7::text THEN 1
       ELSE 0
     END AS noise_penalty
-  FROM chunks
-  WHERE %s
+  FROM %[3]s
+  WHERE %[4]s
 ),
 ranked AS (
   SELECT *,
          MAX(sem_sim) OVER()  AS max_sem,
          MAX(lex_sum) OVER()  AS max_lex,
-         MAX(tri)     OVER()  AS max_tri
-  FROM cand
+         MAX(tri)     OVER()  AS max_tri,
+         COUNT(*)     OVER()  AS total%[5]s
+  FROM cand%[7]s
 )
-SELECT
+`, extCTE, semExpr, from, where, ranks, s.tsConfig, matched)
+	page = ranked + fmt.Sprintf(`SELECT
   id, repository, ref, path, language, summary, content, line_start, line_end, created_at,
-  (
-      0.80 * COALESCE(sem_sim / NULLIF(max_sem,0), 0) +
-      0.15 * COALESCE(lex_sum / NULLIF(max_lex,0), 0) +
-      0.05 * COALESCE(tri     / NULLIF(max_tri,0), 0) +
-      0.10 * script_bias -
-      0.07 * noise_penalty
-  ) AS score
+  (%s
+  ) AS score,
+  total%s
 FROM ranked
 ORDER BY score DESC
-LIMIT %d;
-`, where, k)
+LIMIT %d OFFSET %d;
+`, score, explainCols, k, opt.Offset)
+	return ranked, page, args
+}
 
-	rows, err := s.pool.Query(ctx, q, args...)
+// vectorScores searches the external vector index and returns the
+// similarity of each neighbour as a JSON object keyed by chunk ID.
+func (s *Store) vectorScores(ctx context.Context, summaryVec []float32, k int, opt QueryOpts) (string, error) {
+	hits, err := s.vectors.Search(ctx, summaryVec, vectorCandidateCount(k), VectorFilter{
+		Repositories: opt.Repositories, Ref: opt.Ref, Languages: opt.Languages, Path: opt.Path,
+	})
 	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("vector index: %w", err)
 	}
-	defer rows.Close()
-
-	var out []models.SearchResult
-	for rows.Next() {
-		var c models.Chunk
-		var score float64
-		if err := rows.Scan(
-			&c.ID, &c.Repository, &c.Ref, &c.Path, &c.Language, &c.Summary, &c.Content, &c.LineStart, &c.LineEnd, &c.CreatedAt,
-			&score,
-		); err != nil {
-			return nil, err
-		}
-		out = append(out, models.SearchResult{Chunk: c, Score: score})
+	scores := make(map[string]float64, len(hits))
+	for _, h := range hits {
+		scores[h.ID] = h.Score
 	}
-	return out, nil
+	b, err := json.Marshal(scores)
+	return string(b), err
 }
 
 // longestToken extracts the longest alphanumeric token from the input string.
@@ -336,11 +663,20 @@ func longestToken(s string) string {
 	return longest
 }
 
-// Ping checks the database connectivity.
+// Ping checks the connectivity of the database and of the read replica, if
+// any.
 func (s *Store) Ping(ctx context.Context) error {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	return s.pool.Ping(ctx)
+	if err := s.pool.Ping(ctx); err != nil {
+		return err
+	}
+	if s.read != s.pool {
+		if err := s.read.Ping(ctx); err != nil {
+			return fmt.Errorf("read replica: %w", err)
+		}
+	}
+	return nil
 }
 
 // ChunkMeta holds metadata about a chunk.
@@ -355,12 +691,15 @@ func (s *Store) GetChunkMeta(ctx context.Context, repository, path string, ls, l
 	const q = `
       SELECT content_hash,
              COALESCE(summary, ''),
-             summary_vec IS NOT NULL
+             summary_vec IS NOT NULL OR ($5 AND embed_model IS NOT NULL)
       FROM chunks
       WHERE repository = $1 AND path = $2 AND line_start = $3 AND line_end = $4
+        AND deleted_at IS NULL
       LIMIT 1`
+	// Chunks whose vector lives in an external index have no summary_vec;
+	// their embed model records that one was written.
 	var m ChunkMeta
-	err := s.pool.QueryRow(ctx, q, repository, path, ls, le).
+	err := s.pool.QueryRow(ctx, q, repository, path, ls, le, s.vectors != nil).
 		Scan(&m.ContentHash, &m.Summary, &m.HasSummaryVec)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -371,9 +710,76 @@ func (s *Store) GetChunkMeta(ctx context.Context, repository, path string, ls, l
 	return m, true, nil
 }
 
+// HashMatch holds the reusable artifacts of a chunk with identical content.
+type HashMatch struct {
+	Summary      string
+	SummaryVec   []float32
+	SummaryModel string
+	EmbedModel   string
+}
+
+// FindByContentHash returns the summary and summary vector of any live
+// chunk, in any repository or ref, whose content hash matches and which has
+// both artifacts populated by the given models, so that chunks summarized by
+// the heuristic fallback or an older model are not copied.
+func (s *Store) FindByContentHash(ctx context.Context, contentHash, summaryModel, embedModel string) (HashMatch, bool, error) {
+	if s.vectors != nil {
+		return s.findByContentHashExternal(ctx, contentHash, summaryModel, embedModel)
+	}
+	const q = `
+      SELECT summary, summary_vec, COALESCE(summary_model, ''), COALESCE(embed_model, '')
+      FROM chunks
+      WHERE content_hash = $1
+        AND deleted_at IS NULL
+        AND summary IS NOT NULL AND summary <> ''
+        AND summary_vec IS NOT NULL
+        AND COALESCE(summary_model, '') = $2 AND COALESCE(embed_model, '') = $3
+      LIMIT 1`
+	var m HashMatch
+	var v pgvector.Vector
+	err := s.pool.QueryRow(ctx, q, contentHash, summaryModel, embedModel).Scan(&m.Summary, &v, &m.SummaryModel, &m.EmbedModel)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return HashMatch{}, false, nil
+		}
+		return HashMatch{}, false, err
+	}
+	m.SummaryVec = v.Slice()
+	return m, true, nil
+}
+
+// findByContentHashExternal is FindByContentHash for vectors held in an
+// external index.
+func (s *Store) findByContentHashExternal(ctx context.Context, contentHash, summaryModel, embedModel string) (HashMatch, bool, error) {
+	const q = `
+      SELECT id, summary, COALESCE(summary_model, ''), embed_model
+      FROM chunks
+      WHERE content_hash = $1
+        AND deleted_at IS NULL
+        AND summary IS NOT NULL AND summary <> ''
+        AND embed_model IS NOT NULL
+        AND COALESCE(summary_model, '') = $2 AND embed_model = $3
+      LIMIT 1`
+	var id string
+	var m HashMatch
+	err := s.pool.QueryRow(ctx, q, contentHash, summaryModel, embedModel).Scan(&id, &m.Summary, &m.SummaryModel, &m.EmbedModel)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return HashMatch{}, false, nil
+		}
+		return HashMatch{}, false, err
+	}
+	vec, ok, err := s.vectors.Get(ctx, id)
+	if err != nil || !ok {
+		return HashMatch{}, false, err
+	}
+	m.SummaryVec = vec
+	return m, true, nil
+}
+
 // GetRefs returns distinct refs for a given repository.
 func (s *Store) GetRefs(ctx context.Context, repository string) ([]string, error) {
-	rows, err := s.pool.Query(ctx, `SELECT DISTINCT ref FROM chunks WHERE repository = $1 ORDER BY ref`, repository)
+	rows, err := s.read.Query(ctx, `SELECT DISTINCT ref FROM chunks WHERE repository = $1 AND deleted_at IS NULL ORDER BY ref`, repository)
 	if err != nil {
 		return nil, err
 	}
@@ -389,3 +795,61 @@ func (s *Store) GetRefs(ctx context.Context, repository string) ([]string, error
 	}
 	return refs, rows.Err()
 }
+
+// DeleteRepository soft-deletes every chunk and rollup of a repository,
+// across all refs, and returns the number of chunks deleted. Deleted rows are
+// hidden from search until restored or removed by Vacuum.
+func (s *Store) DeleteRepository(ctx context.Context, repository string) (int64, error) {
+	return s.setDeleted(ctx, true, "repository = $1", repository)
+}
+
+// DeleteRef soft-deletes the chunks and rollups of a single ref of a
+// repository and returns the number of chunks deleted.
+func (s *Store) DeleteRef(ctx context.Context, repository, ref string) (int64, error) {
+	return s.setDeleted(ctx, true, "repository = $1 AND ref = $2", repository, ref)
+}
+
+// GetPaths returns the paths indexed for a ref of a repository, with the
+// number of chunks of each.
+func (s *Store) GetPaths(ctx context.Context, repository, ref string) (map[string]int64, error) {
+	rows, err := s.read.Query(ctx, `
+      SELECT path, COUNT(*) FROM chunks
+      WHERE repository = $1 AND ref = $2 AND deleted_at IS NULL
+      GROUP BY path`, repository, ref)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := map[string]int64{}
+	for rows.Next() {
+		var p string
+		var n int64
+		if err := rows.Scan(&p, &n); err != nil {
+			return nil, err
+		}
+		paths[p] = n
+	}
+	return paths, rows.Err()
+}
+
+// DeletePaths soft-deletes the chunks and rollups of the given paths of a
+// ref, e.g. files removed from the repository, and returns the number of
+// chunks deleted.
+func (s *Store) DeletePaths(ctx context.Context, repository, ref string, paths []string) (int64, error) {
+	if len(paths) == 0 {
+		return 0, nil
+	}
+	return s.setDeleted(ctx, true, "repository = $1 AND ref = $2 AND path = ANY($3)", repository, ref, paths)
+}
+
+// deleteVectors removes vectors matching f from the external index, if any.
+func (s *Store) deleteVectors(ctx context.Context, f VectorFilter) error {
+	if s.vectors == nil {
+		return nil
+	}
+	if err := s.vectors.Delete(ctx, f); err != nil {
+		return fmt.Errorf("vector index: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestNew_ReadReplica(t *testing.T) {
+	ctx := context.Background()
+	// Pools connect lazily, so no database is needed.
+	s, err := New(ctx, "postgres://localhost:1/primary")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if s.read != s.pool {
+		t.Error("expected reads to use the primary without a replica")
+	}
+	s.Close()
+
+	s, err = New(ctx, "postgres://localhost:1/primary", WithReadReplica("postgres://localhost:2/replica"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if s.read == s.pool || s.read.Config().ConnConfig.Port != 2 {
+		t.Error("expected reads to use the replica")
+	}
+	s.Close()
+
+	if _, err := New(ctx, "postgres://localhost:1/primary", WithReadReplica("postgres://host:notaport/db")); err == nil {
+		t.Error("expected an invalid replica url to fail")
+	}
+}
+
+func TestPoolOptions(t *testing.T) {
+	cfg, err := pgxpool.ParseConfig("postgres://localhost/db?pool_max_conns=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	PoolOptions{MinConns: 2, MaxConnLifetime: time.Hour, StatementTimeout: 1500 * time.Millisecond}.apply(cfg)
+	if cfg.MaxConns != 7 {
+		t.Errorf("expected the DSN max conns to be kept, got %d", cfg.MaxConns)
+	}
+	if cfg.MinConns != 2 || cfg.MaxConnLifetime != time.Hour {
+		t.Errorf("unexpected pool config %d %v", cfg.MinConns, cfg.MaxConnLifetime)
+	}
+	if got := cfg.ConnConfig.RuntimeParams["statement_timeout"]; got != "1500" {
+		t.Errorf("unexpected statement_timeout %q", got)
+	}
+}
+
+func TestSearchPageSQL(t *testing.T) {
+	s := &Store{}
+	opt := QueryOpts{QueryText: "retry backoff", Repositories: []string{"repo"}, Offset: 20}
+
+	// The semantic signal comes from an index scan of the nearest
+	// neighbours, under the same filters, rather than from every chunk.
+	_, page, args := s.searchPageSQL([]float32{1, 0}, false, 10, opt)
+	for _, want := range []string{
+		"ann AS MATERIALIZED (",
+		"ORDER BY summary_vec <=> $1::vector\n  LIMIT 300\n",
+		"AND summary_vec IS NOT NULL",
+		"FROM chunks LEFT JOIN ann USING (id)",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("expected %q in the query:\n%s", want, page)
+		}
+	}
+	if strings.Contains(page, "cosine_distance") {
+		t.Errorf("expected no per-chunk vector comparison:\n%s", page)
+	}
+	if len(args) != 8 || args[7] == nil {
+		t.Errorf("expected the repository filter to follow the ranking parameters, got %v", args)
+	}
+
+	// Lexical-only and external rankings don't scan the vector index.
+	for _, c := range []struct {
+		sv          any
+		lexicalOnly bool
+		vectors     VectorIndex
+	}{{nil, true, nil}, {`{}`, false, &Qdrant{}}} {
+		s.vectors = c.vectors
+		if _, page, _ := s.searchPageSQL(c.sv, c.lexicalOnly, 10, opt); strings.Contains(page, "ann AS") {
+			t.Errorf("expected no index scan:\n%s", page)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// suggestPathCandidates caps the paths read before ranking by Suggest.
+const suggestPathCandidates = 200
+
+// Suggest completes prefix with up to limit paths containing it, symbol names
+// starting with it and earlier searches of user starting with it. Paths and
+// symbols are limited to the chunks matching opt; only searches that
+// returned results are suggested, and never those of other users, which may
+// name repositories the caller cannot read.
+func (s *Store) Suggest(ctx context.Context, prefix string, limit int, user string, opt QueryOpts) (models.Suggestions, error) {
+	out := models.Suggestions{Paths: []models.Suggestion{}, Symbols: []models.Suggestion{}, Queries: []models.Suggestion{}}
+	if prefix = strings.TrimSpace(prefix); prefix == "" {
+		return out, nil
+	}
+	where, args := filterSQL("deleted_at IS NULL", nil, opt, true)
+
+	// The trigram index serves the substring match.
+	pathArgs := append(args, likeEscape(prefix), suggestPathCandidates)
+	rows, err := s.read.Query(ctx, fmt.Sprintf(`
+      SELECT path, COUNT(*) FROM chunks
+      WHERE %s AND path ILIKE '%%' || $%d || '%%'
+      GROUP BY path
+      ORDER BY length(path), path
+      LIMIT $%d`, where, len(args)+1, len(args)+2), pathArgs...)
+	if err != nil {
+		return out, err
+	}
+	paths, err := scanSuggestions(rows, false)
+	rows.Close()
+	if err != nil {
+		return out, err
+	}
+	out.Paths = rankPaths(prefix, paths, limit)
+
+	symArgs := append(args, likeEscape(prefix), limit)
+	rows, err = s.read.Query(ctx, fmt.Sprintf(`
+      SELECT name, MIN(kind), COUNT(*) FROM symbols
+      WHERE lower(name) LIKE lower($%d) || '%%'
+        AND chunk_id IN (SELECT id FROM chunks WHERE %s)
+      GROUP BY name
+      ORDER BY 3 DESC, length(name), name
+      LIMIT $%d`, len(args)+1, where, len(args)+2), symArgs...)
+	if err != nil {
+		return out, err
+	}
+	out.Symbols, err = scanSuggestions(rows, true)
+	rows.Close()
+	if err != nil {
+		return out, err
+	}
+
+	rows, err = s.read.Query(ctx, `
+      SELECT MIN(query), COUNT(*) FROM query_logs
+      WHERE results > 0 AND user_login = $1 AND lower(query) LIKE lower($2) || '%'
+      GROUP BY query_hash
+      ORDER BY 2 DESC, 1
+      LIMIT $3`, user, likeEscape(prefix), limit)
+	if err != nil {
+		return out, err
+	}
+	out.Queries, err = scanSuggestions(rows, false)
+	rows.Close()
+	return out, err
+}
+
+// Suggest completes prefix with up to limit paths containing it, symbol names
+// starting with it and earlier searches of user starting with it, like
+// Store.Suggest.
+func (s *SQLiteStore) Suggest(ctx context.Context, prefix string, limit int, user string, opt QueryOpts) (models.Suggestions, error) {
+	out := models.Suggestions{Paths: []models.Suggestion{}, Symbols: []models.Suggestion{}, Queries: []models.Suggestion{}}
+	if prefix = strings.TrimSpace(prefix); prefix == "" {
+		return out, nil
+	}
+	where, args := sqliteFilters(opt, true)
+
+	rows, err := s.db.QueryContext(ctx, `
+      SELECT path, COUNT(*) FROM chunks
+      WHERE `+where+` AND instr(lower(path), lower(?)) > 0
+      GROUP BY path
+      ORDER BY length(path), path
+      LIMIT ?`, append(args, prefix, suggestPathCandidates)...)
+	if err != nil {
+		return out, err
+	}
+	paths, err := scanSuggestions(rows, false)
+	_ = rows.Close()
+	if err != nil {
+		return out, err
+	}
+	out.Paths = rankPaths(prefix, paths, limit)
+
+	// LIKE is case-insensitive for ASCII in SQLite.
+	rows, err = s.db.QueryContext(ctx, `
+      SELECT name, MIN(kind), COUNT(*) FROM symbols
+      WHERE name LIKE ? ESCAPE '\'
+        AND chunk_id IN (SELECT id FROM chunks WHERE `+where+`)
+      GROUP BY name
+      ORDER BY 3 DESC, length(name), name
+      LIMIT ?`, append(append([]any{likeEscape(prefix) + "%"}, args...), limit)...)
+	if err != nil {
+		return out, err
+	}
+	out.Symbols, err = scanSuggestions(rows, true)
+	_ = rows.Close()
+	if err != nil {
+		return out, err
+	}
+
+	rows, err = s.db.QueryContext(ctx, `
+      SELECT MIN(query), COUNT(*) FROM query_logs
+      WHERE results > 0 AND user_login = ? AND query LIKE ? ESCAPE '\'
+      GROUP BY query_hash
+      ORDER BY 2 DESC, 1
+      LIMIT ?`, user, likeEscape(prefix)+"%", limit)
+	if err != nil {
+		return out, err
+	}
+	out.Queries, err = scanSuggestions(rows, false)
+	_ = rows.Close()
+	return out, err
+}
+
+// scanSuggestions reads (text, count) rows, or (text, detail, count) rows
+// when withDetail is set.
+func scanSuggestions(rows rowScanner, withDetail bool) ([]models.Suggestion, error) {
+	out := []models.Suggestion{}
+	for rows.Next() {
+		var sg models.Suggestion
+		dest := []any{&sg.Text, &sg.Count}
+		if withDetail {
+			dest = []any{&sg.Text, &sg.Detail, &sg.Count}
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		out = append(out, sg)
+	}
+	return out, rows.Err()
+}
+
+// rankPaths orders paths matching prefix so that file names starting with it
+// come first, then paths with a directory starting with it, then any other
+// match, shorter paths first within each group, and keeps the first limit.
+func rankPaths(prefix string, paths []models.Suggestion, limit int) []models.Suggestion {
+	lp := strings.ToLower(prefix)
+	rank := func(p string) int {
+		p = strings.ToLower(p)
+		switch {
+		case strings.HasPrefix(path.Base(p), lp):
+			return 0
+		case strings.HasPrefix(p, lp) || strings.Contains(p, "/"+lp):
+			return 1
+		}
+		return 2
+	}
+	sort.SliceStable(paths, func(i, j int) bool {
+		ri, rj := rank(paths[i].Text), rank(paths[j].Text)
+		if ri != rj {
+			return ri < rj
+		}
+		return len(paths[i].Text) < len(paths[j].Text)
+	})
+	if len(paths) > limit {
+		paths = paths[:limit]
+	}
+	return paths
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Symbol is an identifier defined in a chunk, e.g. a function or type.
+type Symbol struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"` // e.g. "func", "type", "class"
+	Line int    `json:"line"` // 1-based line within the file
+}
+
+const symbolsSchema = `
+CREATE TABLE IF NOT EXISTS symbols (
+  chunk_id   TEXT NOT NULL,
+  repository TEXT NOT NULL,
+  ref        TEXT NOT NULL DEFAULT '',
+  path       TEXT NOT NULL,
+  name       TEXT NOT NULL,
+  kind       TEXT NOT NULL,
+  line       INT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS symbols_chunk_idx ON symbols (chunk_id);
+`
+
+// symbolsNameIndexPG supports both exact and prefix matches on lower(name).
+const symbolsNameIndexPG = `
+CREATE INDEX IF NOT EXISTS symbols_name_idx ON symbols (lower(name) text_pattern_ops);`
+
+// symbolsNameIndexSQLite is the SQLite equivalent; LIKE is case-insensitive
+// for ASCII there.
+const symbolsNameIndexSQLite = `
+CREATE INDEX IF NOT EXISTS symbols_name_idx ON symbols (name COLLATE NOCASE);`
+
+// orphanSymbolsSQL removes the symbols of chunks that no longer exist.
+const orphanSymbolsSQL = `
+	DELETE FROM symbols WHERE NOT EXISTS (SELECT 1 FROM chunks c WHERE c.id = symbols.chunk_id)`
+
+// queueSymbols replaces the symbols of c in the batch.
+func queueSymbols(b *pgx.Batch, c ChunkWithVec) {
+	b.Queue(`DELETE FROM symbols WHERE chunk_id = $1`, c.Chunk.ID)
+	if len(c.Symbols) == 0 {
+		return
+	}
+	names := make([]string, len(c.Symbols))
+	kinds := make([]string, len(c.Symbols))
+	lines := make([]int32, len(c.Symbols))
+	for i, sym := range c.Symbols {
+		names[i], kinds[i], lines[i] = sym.Name, sym.Kind, int32(sym.Line)
+	}
+	b.Queue(`
+		INSERT INTO symbols (chunk_id, repository, ref, path, name, kind, line)
+		SELECT $1, $2, $3, $4, n, k, l
+		FROM unnest($5::text[], $6::text[], $7::int[]) AS t(n, k, l)`,
+		c.Chunk.ID, c.Chunk.Repository, c.Chunk.Ref, c.Chunk.Path, names, kinds, lines)
+}
+
+// sqliteWriteSymbols replaces the symbols of c.
+func sqliteWriteSymbols(ctx context.Context, e execer, c ChunkWithVec) error {
+	if _, err := e.ExecContext(ctx, `DELETE FROM symbols WHERE chunk_id = ?`, c.Chunk.ID); err != nil {
+		return err
+	}
+	for _, sym := range c.Symbols {
+		_, err := e.ExecContext(ctx,
+			`INSERT INTO symbols (chunk_id, repository, ref, path, name, kind, line) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			c.Chunk.ID, c.Chunk.Repository, c.Chunk.Ref, c.Chunk.Path, sym.Name, sym.Kind, sym.Line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// symbolPattern splits a symbol filter into the name and whether it is a
+// prefix match ("Name*").
+func symbolPattern(symbol string) (name string, prefix bool) {
+	return strings.CutSuffix(symbol, "*")
+}
+
+// likeEscape escapes the LIKE wildcards in s with a backslash, as
+// identifiers commonly contain underscores.
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DefaultTextSearchConfig is the Postgres text search configuration used for
+// lexical ranking when none is configured.
+const DefaultTextSearchConfig = "english"
+
+var textSearchConfigRe = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// ParseTextSearchConfig validates the name of a text search configuration,
+// e.g. "english", "german" or "simple". The name is interpolated into DDL, so
+// only plain identifiers are accepted; whether the configuration exists is
+// checked by Postgres at migration time.
+func ParseTextSearchConfig(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return DefaultTextSearchConfig, nil
+	}
+	if !textSearchConfigRe.MatchString(name) {
+		return "", fmt.Errorf("invalid text search config: %q", name)
+	}
+	return name, nil
+}
+
+// WithTextSearchConfig sets the text search configuration used by the
+// ts_fielded column and lexical ranking. Changing it regenerates ts_fielded
+// on the next Migrate.
+func WithTextSearchConfig(name string) Option {
+	return func(s *Store) {
+		if name != "" {
+			s.tsConfig = name
+		}
+	}
+}
+
+// tsFieldedExpr is the generated expression of the ts_fielded column.
+func tsFieldedExpr(cfg string) string {
+	return fmt.Sprintf(`
+	setweight(
+	  to_tsvector('%[1]s',
+		regexp_replace(coalesce(path,''), '[^A-Za-z0-9]+', ' ', 'g')
+	  ),
+	  'A'
+	) ||
+	setweight(to_tsvector('%[1]s', coalesce(summary,'')), 'B') ||
+	setweight(to_tsvector('%[1]s', coalesce(content,'')), 'C')`, cfg)
+}
+
+// ensureTextSearchConfig regenerates ts_fielded and its index when the
+// column was built with a different text search configuration.
+func (s *Store) ensureTextSearchConfig(ctx context.Context) error {
+	var expr string
+	err := s.pool.QueryRow(ctx, `
+      SELECT pg_get_expr(d.adbin, d.adrelid)
+      FROM pg_attrdef d
+      JOIN pg_attribute a ON a.attrelid = d.adrelid AND a.attnum = d.adnum
+      WHERE d.adrelid = 'chunks'::regclass AND a.attname = 'ts_fielded'`).Scan(&expr)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	// pg_get_expr renders the configuration as 'english'::regconfig.
+	if err == nil && strings.Contains(expr, fmt.Sprintf("'%s'::regconfig", s.tsConfig)) {
+		return nil
+	}
+	q := fmt.Sprintf(`
+ALTER TABLE chunks DROP COLUMN IF EXISTS ts_fielded;
+ALTER TABLE chunks ADD COLUMN ts_fielded tsvector GENERATED ALWAYS AS (%s
+  ) STORED;
+CREATE INDEX IF NOT EXISTS chunks_ts_fielded_gin
+  ON chunks USING GIN (ts_fielded);`, tsFieldedExpr(s.tsConfig))
+	_, err = s.pool.Exec(ctx, q)
+	return err
+}
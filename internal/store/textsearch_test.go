@@ -0,0 +1,26 @@
+package store
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTextSearchConfig(t *testing.T) {
+	for in, want := range map[string]string{"": "english", " German ": "german", "simple": "simple", "my_cfg2": "my_cfg2"} {
+		if got, err := ParseTextSearchConfig(in); err != nil || got != want {
+			t.Errorf("ParseTextSearchConfig(%q) = %q, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"english'); DROP TABLE chunks; --", "pg_catalog.english", "1abc"} {
+		if _, err := ParseTextSearchConfig(in); err == nil {
+			t.Errorf("expected error for %q", in)
+		}
+	}
+}
+
+func TestTsFieldedExpr(t *testing.T) {
+	expr := tsFieldedExpr("german")
+	if strings.Count(expr, "to_tsvector('german',") != 3 || strings.Contains(expr, "english") {
+		t.Errorf("unexpected expression %s", expr)
+	}
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// usageSchema is shared by Postgres and SQLite. day is a UTC date
+// (YYYY-MM-DD); kind names the metered endpoints, e.g. search or ask.
+const usageSchema = `
+CREATE TABLE IF NOT EXISTS usage_counts (
+  login TEXT NOT NULL,
+  day   TEXT NOT NULL,
+  kind  TEXT NOT NULL,
+  count INTEGER NOT NULL,
+  PRIMARY KEY (login, day, kind)
+);
+`
+
+// usageDay is the day of the usage counted at t.
+func usageDay(t time.Time) string {
+	return t.UTC().Format(time.DateOnly)
+}
+
+// IncrementUsage counts one request of kind by login on the UTC day of at
+// and returns the number counted that day so far.
+func (s *Store) IncrementUsage(ctx context.Context, login, kind string, at time.Time) (int, error) {
+	var n int
+	err := s.pool.QueryRow(ctx, `
+      INSERT INTO usage_counts (login, day, kind, count) VALUES ($1, $2, $3, 1)
+      ON CONFLICT (login, day, kind) DO UPDATE SET count = usage_counts.count + 1
+      RETURNING count`, login, usageDay(at), kind).Scan(&n)
+	return n, err
+}
+
+// IncrementUsage counts one request of kind by login on the UTC day of at
+// and returns the number counted that day so far.
+func (s *SQLiteStore) IncrementUsage(ctx context.Context, login, kind string, at time.Time) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `
+      INSERT INTO usage_counts (login, day, kind, count) VALUES (?, ?, ?, 1)
+      ON CONFLICT (login, day, kind) DO UPDATE SET count = usage_counts.count + 1
+      RETURNING count`, login, usageDay(at), kind).Scan(&n)
+	return n, err
+}
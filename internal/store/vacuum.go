@@ -0,0 +1,127 @@
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+// vacuumBatch is the number of chunk IDs removed from an external vector
+// index per request.
+const vacuumBatch = 1000
+
+// RestoreRepository undoes DeleteRepository for rows not yet vacuumed and
+// returns the number of chunks restored.
+func (s *Store) RestoreRepository(ctx context.Context, repository string) (int64, error) {
+	return s.setDeleted(ctx, false, "repository = $1", repository)
+}
+
+// RestoreRef undoes DeleteRef for rows not yet vacuumed and returns the
+// number of chunks restored.
+func (s *Store) RestoreRef(ctx context.Context, repository, ref string) (int64, error) {
+	return s.setDeleted(ctx, false, "repository = $1 AND ref = $2", repository, ref)
+}
+
+// setDeleted marks or unmarks the chunks and rollups matching cond as
+// deleted and returns the number of chunks changed.
+func (s *Store) setDeleted(ctx context.Context, deleted bool, cond string, args ...any) (int64, error) {
+	set, was := "now()", "deleted_at IS NULL"
+	if !deleted {
+		set, was = "NULL", "deleted_at IS NOT NULL"
+	}
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	tag, err := tx.Exec(ctx, fmt.Sprintf(`UPDATE chunks SET deleted_at = %s WHERE %s AND %s`, set, cond, was), args...)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, fmt.Sprintf(`UPDATE rollups SET deleted_at = %s WHERE %s AND %s`, set, cond, was), args...); err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), tx.Commit(ctx)
+}
+
+// Vacuum permanently removes soft-deleted chunks and rollups, along with
+// their external vectors, then reclaims space and rebuilds the indexes. A
+// non-empty repository limits the removal to its rows; an empty one removes
+// those of every repository. It returns the number of chunks removed.
+func (s *Store) Vacuum(ctx context.Context, repository string) (int64, error) {
+	cond, args := "deleted_at IS NOT NULL", []any{}
+	if repository != "" {
+		cond, args = cond+" AND repository = $1", append(args, repository)
+	}
+	if s.vectors != nil {
+		if err := s.vacuumVectors(ctx, cond, args); err != nil {
+			return 0, err
+		}
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	tag, err := tx.Exec(ctx, `DELETE FROM chunks WHERE `+cond, args...)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, orphanSymbolsSQL); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM rollups WHERE `+cond, args...); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	// VACUUM and REINDEX CONCURRENTLY cannot run in a transaction, so each
+	// is sent on its own.
+	for _, q := range []string{
+		`VACUUM (ANALYZE) chunks`,
+		`VACUUM (ANALYZE) rollups`,
+		`VACUUM (ANALYZE) symbols`,
+		`REINDEX TABLE CONCURRENTLY chunks`,
+		`REINDEX TABLE CONCURRENTLY rollups`,
+		`REINDEX TABLE CONCURRENTLY symbols`,
+	} {
+		if _, err := s.pool.Exec(ctx, q); err != nil {
+			return tag.RowsAffected(), fmt.Errorf("%s: %w", q, err)
+		}
+	}
+	return tag.RowsAffected(), nil
+}
+
+// vacuumVectors removes the external vectors of the chunks matching cond. It
+// runs before the rows are removed so that an interrupted vacuum can be
+// retried.
+func (s *Store) vacuumVectors(ctx context.Context, cond string, args []any) error {
+	rows, err := s.pool.Query(ctx, `SELECT id FROM chunks WHERE `+cond, args...)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for len(ids) > 0 {
+		n := min(len(ids), vacuumBatch)
+		if err := s.deleteVectors(ctx, VectorFilter{IDs: ids[:n]}); err != nil {
+			return err
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Vector store types.
+const (
+	VectorStorePostgres = "postgres" // vectors live in the chunks table (default)
+	VectorStoreQdrant   = "qdrant"   // vectors live in a Qdrant collection
+)
+
+// VectorIndex holds chunk summary vectors outside of Postgres. When a Store
+// has one, Postgres keeps chunk metadata and lexical search while vectors are
+// written to and searched in the index. Rollup vectors stay in Postgres.
+type VectorIndex interface {
+	// EnsureCollection creates the collection for vectors of size dim.
+	EnsureCollection(ctx context.Context, dim int) error
+	Upsert(ctx context.Context, points []VectorPoint) error
+	// Get returns the vector stored for a chunk ID.
+	Get(ctx context.Context, id string) ([]float32, bool, error)
+	Search(ctx context.Context, vec []float32, k int, f VectorFilter) ([]VectorHit, error)
+	Delete(ctx context.Context, f VectorFilter) error
+}
+
+// VectorPoint is a chunk vector with the metadata used to filter searches.
+type VectorPoint struct {
+	ID         string
+	Repository string
+	Ref        string
+	Path       string
+	Language   string
+	Vector     []float32
+}
+
+// VectorHit is a chunk returned by a vector search with its cosine similarity.
+type VectorHit struct {
+	ID    string
+	Score float64
+}
+
+// VectorFilter restricts a vector search or delete. Empty fields match all.
+type VectorFilter struct {
+	Repositories []string
+	Ref          string
+	Languages    []string
+	Path         string
+	IDs          []string // chunk IDs
+}
+
+// vectorCandidates is the minimum number of nearest neighbours fetched from
+// a vector index before hybrid ranking in Postgres.
+const vectorCandidates = 200
+
+// vectorCandidateCount returns the number of nearest neighbours fetched to
+// rank the top k results.
+func vectorCandidateCount(k int) int {
+	return max(k*10, vectorCandidates)
+}
+
+// WithVectorIndex stores chunk vectors in v instead of Postgres.
+func WithVectorIndex(v VectorIndex) Option {
+	return func(s *Store) { s.vectors = v }
+}
+
+// ParseVectorStore validates a configured vector store type.
+func ParseVectorStore(t string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(t)) {
+	case "", VectorStorePostgres:
+		return VectorStorePostgres, nil
+	case VectorStoreQdrant:
+		return VectorStoreQdrant, nil
+	default:
+		return "", fmt.Errorf("unsupported vector store: %s (expected postgres or qdrant)", t)
+	}
+}
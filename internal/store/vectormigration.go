@@ -0,0 +1,391 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	pgvector "github.com/pgvector/pgvector-go"
+)
+
+// VectorMigrationStore moves the summary vectors of chunks and rollups to
+// an embedding model of another dimension without touching the summaries.
+// BeginVectorMigration adds summary_vec_next columns of the new dimension
+// next to the live ones, which keep serving searches while the pending
+// summaries are embedded; CommitVectorMigration then swaps the columns in
+// one transaction. A migration left unfinished is resumed by beginning it
+// again with the same dimension.
+type VectorMigrationStore interface {
+	BeginVectorMigration(ctx context.Context, dim int) error
+	ListPendingVectors(ctx context.Context, after PendingVector, limit int) ([]PendingVector, error)
+	WritePendingVectors(ctx context.Context, vecs []PendingVector) error
+	CommitVectorMigration(ctx context.Context, model string) error
+	AbortVectorMigration(ctx context.Context) error
+}
+
+// PendingVector is a chunk or rollup whose summary has no vector of the
+// migration's model yet. Chunks are identified by ID; rollups, which have
+// none, by Repository, Ref, Kind and Path.
+type PendingVector struct {
+	ID         string
+	Repository string
+	Ref        string
+	Kind       string
+	Path       string
+	Summary    string
+	Vector     []float32 // set before WritePendingVectors
+}
+
+// rollup reports whether v is a rollup rather than a chunk.
+func (v PendingVector) rollup() bool { return v.ID == "" && v.Kind != "" }
+
+// Pending chunks and rollups: live, summarized and not yet embedded.
+const (
+	pendingChunksWhere  = `summary_vec_next IS NULL AND summary IS NOT NULL AND summary <> '' AND deleted_at IS NULL`
+	pendingRollupsWhere = pendingChunksWhere
+)
+
+// While a migration is in progress, triggers clear the new vector of a
+// chunk or rollup whose summary changes, however it is written, so that
+// the summary is embedded again before the migration can be committed.
+const vectorMigrationTriggersPG = `
+CREATE OR REPLACE FUNCTION clear_summary_vec_next() RETURNS trigger AS $$
+BEGIN
+  NEW.summary_vec_next := NULL;
+  RETURN NEW;
+END $$ LANGUAGE plpgsql;
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'chunks_clear_summary_vec_next') THEN
+    CREATE TRIGGER chunks_clear_summary_vec_next
+      BEFORE UPDATE OF summary ON chunks
+      FOR EACH ROW WHEN (OLD.summary IS DISTINCT FROM NEW.summary)
+      EXECUTE FUNCTION clear_summary_vec_next();
+  END IF;
+  IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'rollups_clear_summary_vec_next') THEN
+    CREATE TRIGGER rollups_clear_summary_vec_next
+      BEFORE UPDATE OF summary ON rollups
+      FOR EACH ROW WHEN (OLD.summary IS DISTINCT FROM NEW.summary)
+      EXECUTE FUNCTION clear_summary_vec_next();
+  END IF;
+END $$;
+`
+
+// dropVectorMigrationTriggersPG runs before the summary_vec_next columns
+// are dropped or renamed.
+const dropVectorMigrationTriggersPG = `
+DROP TRIGGER IF EXISTS chunks_clear_summary_vec_next ON chunks;
+DROP TRIGGER IF EXISTS rollups_clear_summary_vec_next ON rollups;
+`
+
+// SQLite has no BEFORE triggers that can assign NEW, so they update the
+// row again instead.
+const vectorMigrationTriggersSQLite = `
+CREATE TRIGGER IF NOT EXISTS chunks_clear_summary_vec_next AFTER UPDATE OF summary ON chunks
+WHEN OLD.summary IS NOT NEW.summary
+BEGIN UPDATE chunks SET summary_vec_next = NULL WHERE id = NEW.id; END;
+CREATE TRIGGER IF NOT EXISTS rollups_clear_summary_vec_next AFTER UPDATE OF summary ON rollups
+WHEN OLD.summary IS NOT NEW.summary
+BEGIN UPDATE rollups SET summary_vec_next = NULL
+      WHERE repository = NEW.repository AND ref = NEW.ref AND kind = NEW.kind AND path = NEW.path; END;
+`
+
+const dropVectorMigrationTriggersSQLite = `
+DROP TRIGGER IF EXISTS chunks_clear_summary_vec_next;
+DROP TRIGGER IF EXISTS rollups_clear_summary_vec_next;
+`
+
+// errExternalVectors rejects migrations of vectors kept in a VectorIndex.
+var errExternalVectors = errors.New("vector migrations are not supported with an external vector store; index into a new collection instead")
+
+// BeginVectorMigration adds the summary_vec_next columns for dim-dimensional
+// vectors, unless a migration to that dimension is already in progress.
+func (s *Store) BeginVectorMigration(ctx context.Context, dim int) error {
+	if s.vectors != nil {
+		return errExternalVectors
+	}
+	for _, table := range []string{"chunks", "rollups"} {
+		have, err := s.vectorColumnDim(ctx, table, "summary_vec_next")
+		if err != nil {
+			return err
+		}
+		if have > 0 && have != dim {
+			return migrationInProgress(have, dim)
+		}
+		if _, err := s.pool.Exec(ctx, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS summary_vec_next vector(%d)`, table, dim)); err != nil {
+			return err
+		}
+	}
+	_, err := s.pool.Exec(ctx, vectorMigrationTriggersPG)
+	return err
+}
+
+// migrationInProgress describes a migration begun for another dimension.
+func migrationInProgress(have, want int) error {
+	return fmt.Errorf("a migration to %d-dimensional vectors is in progress; finish it with that model or abort it before migrating to %d dimensions", have, want)
+}
+
+// ListPendingVectors returns up to limit pending chunks, ordered by ID and
+// starting after the given one, followed by pending rollups ordered by key.
+// The zero PendingVector starts from the beginning.
+func (s *Store) ListPendingVectors(ctx context.Context, after PendingVector, limit int) ([]PendingVector, error) {
+	var out []PendingVector
+	if !after.rollup() {
+		rows, err := s.pool.Query(ctx, `
+      SELECT id, summary FROM chunks
+      WHERE `+pendingChunksWhere+` AND id > $1
+      ORDER BY id
+      LIMIT $2`, after.ID, limit)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var v PendingVector
+			if err := rows.Scan(&v.ID, &v.Summary); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		if len(out) == limit {
+			return out, nil
+		}
+		after = PendingVector{}
+	}
+	rows, err := s.pool.Query(ctx, `
+      SELECT repository, ref, kind, path, summary FROM rollups
+      WHERE `+pendingRollupsWhere+` AND (repository, ref, kind, path) > ($1, $2, $3, $4)
+      ORDER BY repository, ref, kind, path
+      LIMIT $5`, after.Repository, after.Ref, after.Kind, after.Path, limit-len(out))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v PendingVector
+		if err := rows.Scan(&v.Repository, &v.Ref, &v.Kind, &v.Path, &v.Summary); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+// WritePendingVectors stores the new vectors in a single batch. A vector
+// whose summary has changed since it was listed is dropped, leaving the
+// chunk or rollup pending.
+func (s *Store) WritePendingVectors(ctx context.Context, vecs []PendingVector) error {
+	b := &pgx.Batch{}
+	for _, v := range vecs {
+		if v.rollup() {
+			b.Queue(`UPDATE rollups SET summary_vec_next = $5 WHERE repository = $1 AND ref = $2 AND kind = $3 AND path = $4 AND summary = $6`,
+				v.Repository, v.Ref, v.Kind, v.Path, pgvector.NewVector(v.Vector), v.Summary)
+			continue
+		}
+		b.Queue(`UPDATE chunks SET summary_vec_next = $2 WHERE id = $1 AND summary = $3`, v.ID, pgvector.NewVector(v.Vector), v.Summary)
+	}
+	return s.pool.SendBatch(ctx, b).Close()
+}
+
+// CommitVectorMigration swaps the new vectors in, recording model as their
+// embed model, and rebuilds the vector indexes. It fails, changing nothing,
+// while summaries are still pending.
+func (s *Store) CommitVectorMigration(ctx context.Context, model string) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var pending int64
+	err = tx.QueryRow(ctx, `SELECT (SELECT COUNT(*) FROM chunks WHERE `+pendingChunksWhere+`) +
+	                               (SELECT COUNT(*) FROM rollups WHERE `+pendingRollupsWhere+`)`).Scan(&pending)
+	if err != nil {
+		return err
+	}
+	if pending > 0 {
+		return pendingVectors(pending)
+	}
+	if _, err := tx.Exec(ctx, `UPDATE chunks SET embed_model = CASE WHEN summary_vec_next IS NULL THEN NULL ELSE $1 END`, model); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, dropVectorMigrationTriggersPG); err != nil {
+		return err
+	}
+	for _, table := range []string{"chunks", "rollups"} {
+		if _, err := tx.Exec(ctx, fmt.Sprintf(`
+ALTER TABLE %[1]s DROP COLUMN summary_vec;
+ALTER TABLE %[1]s RENAME COLUMN summary_vec_next TO summary_vec;`, table)); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureVectorIndex(ctx, "chunks", "chunks_summary_vec_idx"); err != nil {
+		return err
+	}
+	return s.ensureVectorIndex(ctx, "rollups", "rollups_summary_vec_idx")
+}
+
+// pendingVectors reports summaries still waiting for a vector.
+func pendingVectors(n int64) error {
+	return fmt.Errorf("%d summaries have no vector of the new model yet; run the migration again to retry them", n)
+}
+
+// AbortVectorMigration drops the vectors of an unfinished migration.
+func (s *Store) AbortVectorMigration(ctx context.Context) error {
+	_, err := s.pool.Exec(ctx, dropVectorMigrationTriggersPG+`
+ALTER TABLE chunks DROP COLUMN IF EXISTS summary_vec_next;
+ALTER TABLE rollups DROP COLUMN IF EXISTS summary_vec_next;`)
+	return err
+}
+
+// BeginVectorMigration adds the summary_vec_next columns, unless a
+// migration to another dimension already wrote some of them.
+func (s *SQLiteStore) BeginVectorMigration(ctx context.Context, dim int) error {
+	for _, table := range []string{"chunks", "rollups"} {
+		if err := s.addColumn(ctx, table, "summary_vec_next", "BLOB"); err != nil {
+			return err
+		}
+		var n int
+		err := s.db.QueryRowContext(ctx,
+			`SELECT length(summary_vec_next) FROM `+table+` WHERE length(summary_vec_next) > 0 LIMIT 1`).Scan(&n)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if have := n / 4; err == nil && have != dim {
+			return migrationInProgress(have, dim)
+		}
+	}
+	_, err := s.db.ExecContext(ctx, vectorMigrationTriggersSQLite)
+	return err
+}
+
+// ListPendingVectors returns pending chunks by ID, then pending rollups by
+// key, like Store.ListPendingVectors.
+func (s *SQLiteStore) ListPendingVectors(ctx context.Context, after PendingVector, limit int) ([]PendingVector, error) {
+	var out []PendingVector
+	if !after.rollup() {
+		rows, err := s.db.QueryContext(ctx, `
+      SELECT id, summary FROM chunks
+      WHERE `+pendingChunksWhere+` AND id > ?
+      ORDER BY id
+      LIMIT ?`, after.ID, limit)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var v PendingVector
+			if err := rows.Scan(&v.ID, &v.Summary); err != nil {
+				_ = rows.Close()
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		_ = rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		if len(out) == limit {
+			return out, nil
+		}
+		after = PendingVector{}
+	}
+	rows, err := s.db.QueryContext(ctx, `
+      SELECT repository, ref, kind, path, summary FROM rollups
+      WHERE `+pendingRollupsWhere+` AND (repository, ref, kind, path) > (?, ?, ?, ?)
+      ORDER BY repository, ref, kind, path
+      LIMIT ?`, after.Repository, after.Ref, after.Kind, after.Path, limit-len(out))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var v PendingVector
+		if err := rows.Scan(&v.Repository, &v.Ref, &v.Kind, &v.Path, &v.Summary); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+// WritePendingVectors stores the new vectors in one transaction, dropping
+// those whose summary has changed since it was listed.
+func (s *SQLiteStore) WritePendingVectors(ctx context.Context, vecs []PendingVector) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, v := range vecs {
+			var err error
+			if v.rollup() {
+				_, err = tx.ExecContext(ctx, `UPDATE rollups SET summary_vec_next = ? WHERE repository = ? AND ref = ? AND kind = ? AND path = ? AND summary = ?`,
+					encodeVector(v.Vector), v.Repository, v.Ref, v.Kind, v.Path, v.Summary)
+			} else {
+				_, err = tx.ExecContext(ctx, `UPDATE chunks SET summary_vec_next = ? WHERE id = ? AND summary = ?`, encodeVector(v.Vector), v.ID, v.Summary)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CommitVectorMigration swaps the new vectors in, recording model as their
+// embed model. It fails, changing nothing, while summaries are still
+// pending.
+func (s *SQLiteStore) CommitVectorMigration(ctx context.Context, model string) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		var pending int64
+		err := tx.QueryRowContext(ctx, `SELECT (SELECT COUNT(*) FROM chunks WHERE `+pendingChunksWhere+`) +
+		                                       (SELECT COUNT(*) FROM rollups WHERE `+pendingRollupsWhere+`)`).Scan(&pending)
+		if err != nil {
+			return err
+		}
+		if pending > 0 {
+			return pendingVectors(pending)
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE chunks SET embed_model = CASE WHEN summary_vec_next IS NULL THEN NULL ELSE ? END`, model); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, dropVectorMigrationTriggersSQLite); err != nil {
+			return err
+		}
+		for _, table := range []string{"chunks", "rollups"} {
+			if _, err := tx.ExecContext(ctx, `ALTER TABLE `+table+` DROP COLUMN summary_vec`); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `ALTER TABLE `+table+` RENAME COLUMN summary_vec_next TO summary_vec`); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AbortVectorMigration drops the vectors of an unfinished migration.
+func (s *SQLiteStore) AbortVectorMigration(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, dropVectorMigrationTriggersSQLite); err != nil {
+		return err
+	}
+	for _, table := range []string{"chunks", "rollups"} {
+		var n int
+		err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = 'summary_vec_next'`, table).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+		if _, err := s.db.ExecContext(ctx, `ALTER TABLE `+table+` DROP COLUMN summary_vec_next`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package store
+
+import "context"
+
+// The index version changes whenever chunks are written, so that listings
+// derived from them, such as repositories and refs, can be revalidated
+// cheaply. Triggers bump it, which also covers writes by other processes
+// such as the indexer. Repository grants bump it too, as they change which
+// repositories a user sees.
+
+// indexVersionSchemaPG uses a sequence, which is not transactional, so
+// concurrent writers never wait on each other to bump it. A rolled back
+// write still bumps it, which only costs a client a refetch.
+const indexVersionSchemaPG = `
+CREATE SEQUENCE IF NOT EXISTS index_version_seq;
+CREATE OR REPLACE FUNCTION bump_index_version() RETURNS trigger AS $$
+BEGIN
+  PERFORM nextval('index_version_seq');
+  RETURN NULL;
+END $$ LANGUAGE plpgsql;
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'chunks_bump_index_version') THEN
+    CREATE TRIGGER chunks_bump_index_version
+      AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON chunks
+      FOR EACH STATEMENT EXECUTE FUNCTION bump_index_version();
+  END IF;
+  IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'repository_grants_bump_index_version') THEN
+    CREATE TRIGGER repository_grants_bump_index_version
+      AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON repository_grants
+      FOR EACH STATEMENT EXECUTE FUNCTION bump_index_version();
+  END IF;
+END $$;
+`
+
+// indexVersionSchemaSQLite keeps the version in a single row; SQLite only
+// has row-level triggers, but it also only has one writer at a time.
+const indexVersionSchemaSQLite = `
+CREATE TABLE IF NOT EXISTS index_version (
+  id      INTEGER PRIMARY KEY CHECK (id = 1),
+  version INTEGER NOT NULL
+);
+INSERT OR IGNORE INTO index_version (id, version) VALUES (1, 0);
+CREATE TRIGGER IF NOT EXISTS chunks_insert_index_version AFTER INSERT ON chunks
+BEGIN UPDATE index_version SET version = version + 1; END;
+CREATE TRIGGER IF NOT EXISTS chunks_update_index_version AFTER UPDATE ON chunks
+BEGIN UPDATE index_version SET version = version + 1; END;
+CREATE TRIGGER IF NOT EXISTS chunks_delete_index_version AFTER DELETE ON chunks
+BEGIN UPDATE index_version SET version = version + 1; END;
+CREATE TRIGGER IF NOT EXISTS repository_grants_insert_index_version AFTER INSERT ON repository_grants
+BEGIN UPDATE index_version SET version = version + 1; END;
+CREATE TRIGGER IF NOT EXISTS repository_grants_update_index_version AFTER UPDATE ON repository_grants
+BEGIN UPDATE index_version SET version = version + 1; END;
+CREATE TRIGGER IF NOT EXISTS repository_grants_delete_index_version AFTER DELETE ON repository_grants
+BEGIN UPDATE index_version SET version = version + 1; END;
+`
+
+// IndexVersion returns a number that changes whenever chunks or grants are
+// written. It reads the primary: replicas only see sequence values in batches.
+func (s *Store) IndexVersion(ctx context.Context) (int64, error) {
+	var v int64
+	err := s.pool.QueryRow(ctx, `SELECT last_value FROM index_version_seq`).Scan(&v)
+	return v, err
+}
+
+// IndexVersion returns a number that changes whenever chunks or grants are
+// written.
+func (s *SQLiteStore) IndexVersion(ctx context.Context) (int64, error) {
+	var v int64
+	err := s.db.QueryRowContext(ctx, `SELECT version FROM index_version`).Scan(&v)
+	return v, err
+}
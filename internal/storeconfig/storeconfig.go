@@ -0,0 +1,103 @@
+// Package storeconfig opens the store described by the configuration, so
+// every binary wires up the same options, cache and encryption.
+package storeconfig
+
+import (
+	"context"
+	"log"
+
+	"github.com/seanblong/reposearch/internal/config"
+	"github.com/seanblong/reposearch/internal/store"
+	"github.com/seanblong/reposearch/pkg/models"
+)
+
+// Open connects to the configured database. The vector index, vector
+// store, read replica, pool and text search options only apply to Postgres;
+// the ranking applies to both stores.
+// Search results are cached in Redis when a cache URL is configured, and
+// chunk text is encrypted when an encryption key is.
+func Open(ctx context.Context, cfg config.Specification) (store.Backend, error) {
+	indexType, err := store.ParseIndexType(cfg.VectorIndex)
+	if err != nil {
+		return nil, err
+	}
+	opts := []store.Option{store.WithIndexOptions(store.IndexOptions{
+		Type:           indexType,
+		M:              cfg.HNSW.M,
+		EfConstruction: cfg.HNSW.EfConstruction,
+		EfSearch:       cfg.HNSW.EfSearch,
+		Lists:          cfg.IVFFlat.Lists,
+		Probes:         cfg.IVFFlat.Probes,
+	})}
+
+	tsConfig, err := store.ParseTextSearchConfig(cfg.TextSearchConfig)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, store.WithTextSearchConfig(tsConfig))
+	ranking, err := store.NewRanking(store.Ranking{
+		Hybrid:             scoreWeights(cfg.Ranking.Hybrid),
+		Lexical:            scoreWeights(cfg.Ranking.Lexical),
+		ScriptTerms:        cfg.Ranking.ScriptTerms,
+		ScriptLanguages:    cfg.Ranking.ScriptLanguages,
+		NonScriptLanguages: cfg.Ranking.NonScriptLanguages,
+		NoisePattern:       cfg.Ranking.NoisePattern,
+	})
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, store.WithRanking(ranking))
+	if cfg.DatabaseReplica != "" {
+		opts = append(opts, store.WithReadReplica(cfg.DatabaseReplica))
+	}
+	opts = append(opts, store.WithPoolOptions(store.PoolOptions{
+		MaxConns:          cfg.Pool.MaxConns,
+		MinConns:          cfg.Pool.MinConns,
+		MaxConnLifetime:   cfg.Pool.MaxConnLifetime,
+		HealthCheckPeriod: cfg.Pool.HealthCheckPeriod,
+		StatementTimeout:  cfg.Pool.StatementTimeout,
+	}))
+
+	vectorStore, err := store.ParseVectorStore(cfg.VectorStore)
+	if err != nil {
+		return nil, err
+	}
+	if vectorStore == store.VectorStoreQdrant {
+		q, err := store.NewQdrant(cfg.Qdrant.URL, cfg.Qdrant.APIKey, cfg.Qdrant.Collection)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, store.WithVectorIndex(q))
+	}
+	st, err := store.Open(ctx, cfg.Database, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Cache.URL != "" {
+		r, err := store.NewRedis(cfg.Cache.URL)
+		if err != nil {
+			st.Close()
+			return nil, err
+		}
+		cached := store.NewCached(st, r, cfg.Cache.TTL)
+		cached.Logf = log.Printf
+		st = cached
+	}
+	// Encryption wraps the cache so that cached results are ciphertext too.
+	if cfg.EncryptionKey != "" {
+		c, err := store.NewCipher(cfg.EncryptionKey)
+		if err != nil {
+			st.Close()
+			return nil, err
+		}
+		st = store.NewEncrypted(st, c)
+	}
+	return st, nil
+}
+
+// scoreWeights converts configured ranking weights to the store's.
+func scoreWeights(w config.WeightsSpecification) models.ScoreWeights {
+	return models.ScoreWeights{
+		Semantic: w.Semantic, Lexical: w.Lexical, Trigram: w.Trigram, ScriptBias: w.ScriptBias, NoisePenalty: w.NoisePenalty,
+	}
+}
@@ -0,0 +1,73 @@
+// Package webui serves the single-page web frontend embedded in the API
+// binary.
+//
+// The embedded files are those in dist, which holds a placeholder page in
+// the source tree. Release builds replace it with the output of the frontend
+// build before compiling the API.
+package webui
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+//go:embed all:dist
+var dist embed.FS
+
+// Handler serves the embedded frontend.
+func Handler() http.Handler {
+	files, err := fs.Sub(dist, "dist")
+	if err != nil {
+		panic(err) // dist is embedded, so this can't happen
+	}
+	return handler(files)
+}
+
+// handler serves the files of files. Paths that name no file and have no
+// extension fall back to index.html, so that the frontend's client-side
+// routes load the app; missing assets are still a 404.
+func handler(files fs.FS) http.Handler {
+	server := http.FileServerFS(files)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if name != "" && name != "index.html" {
+			if st, err := fs.Stat(files, name); err == nil && !st.IsDir() {
+				// Vite fingerprints the names of built assets.
+				if strings.HasPrefix(name, "assets/") {
+					w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+				}
+				server.ServeHTTP(w, r)
+				return
+			}
+			if path.Ext(name) != "" {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		serveIndex(w, r, files)
+	})
+}
+
+// serveIndex serves index.html, which must be revalidated so that a new
+// release's assets are picked up.
+func serveIndex(w http.ResponseWriter, r *http.Request, files fs.FS) {
+	b, err := fs.ReadFile(files, "index.html")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,50 @@
+package webui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestHandler(t *testing.T) {
+	h := handler(fstest.MapFS{
+		"index.html":       {Data: []byte("<html>app</html>")},
+		"favicon.svg":      {Data: []byte("<svg/>")},
+		"assets/app-1.js":  {Data: []byte("console.log(1)")},
+		"assets/empty/.gk": {Data: nil},
+	})
+
+	tests := []struct {
+		method, path string
+		status       int
+		body         string
+		cache        string
+	}{
+		{"GET", "/", http.StatusOK, "app", "no-cache"},
+		{"GET", "/index.html", http.StatusOK, "app", "no-cache"},
+		{"GET", "/settings/profile", http.StatusOK, "app", "no-cache"},
+		{"GET", "/assets/empty", http.StatusOK, "app", "no-cache"},
+		{"GET", "/favicon.svg", http.StatusOK, "<svg/>", ""},
+		{"GET", "/assets/app-1.js", http.StatusOK, "console.log", "public, max-age=31536000, immutable"},
+		{"GET", "/assets/missing.js", http.StatusNotFound, "not found", ""},
+		{"HEAD", "/settings", http.StatusOK, "", "no-cache"},
+		{"POST", "/", http.StatusMethodNotAllowed, "Method not allowed", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.body) || w.Header().Get("Cache-Control") != tt.cache {
+			t.Errorf("%s %s: status %d, Cache-Control %q, body %q", tt.method, tt.path, w.Code, w.Header().Get("Cache-Control"), w.Body.String())
+		}
+	}
+}
+
+func TestHandler_Embedded(t *testing.T) {
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/search/anything", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<html") {
+		t.Errorf("status %d, body %q", w.Code, w.Body.String())
+	}
+}
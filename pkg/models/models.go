@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type Chunk struct {
 	ID         string    `json:"id"`
@@ -13,9 +16,384 @@ type Chunk struct {
 	LineStart  int       `json:"line_start"`
 	LineEnd    int       `json:"line_end"`
 	CreatedAt  time.Time `json:"created_at"`
+
+	// Models that produced the summary and its embedding. Empty values leave
+	// the stored model unchanged on upsert.
+	SummaryModel string `json:"summary_model,omitempty"`
+	EmbedModel   string `json:"embed_model,omitempty"`
+}
+
+// File is the content of an indexed file assembled from its chunks, with
+// the chunks themselves, without content, for overlaying summaries.
+type File struct {
+	Repository string  `json:"repository"`
+	Ref        string  `json:"ref"`
+	Path       string  `json:"path"`
+	Language   string  `json:"language"`
+	Content    string  `json:"content"`
+	Lines      int     `json:"lines"`
+	Chunks     []Chunk `json:"chunks"`
 }
 
 type SearchResult struct {
 	Chunk Chunk   `json:"chunk"`
 	Score float64 `json:"score"`
+
+	// Permalink links to the chunk's lines on the repository's host, when
+	// the host is known.
+	Permalink string `json:"permalink,omitempty"`
+
+	// Snippet is a few lines of the chunk around the best matching query
+	// terms, with the matches located by Highlights.
+	Snippet    string      `json:"snippet,omitempty"`
+	Highlights []Highlight `json:"highlights,omitempty"`
+
+	// Before and After hold the adjacent chunks of the same file, in line
+	// order, when the search asked for context expansion.
+	Before []Chunk `json:"before,omitempty"`
+	After  []Chunk `json:"after,omitempty"`
+
+	// Explain breaks Score down into its signals when the search asked for
+	// an explanation.
+	Explain *ScoreExplanation `json:"explain,omitempty"`
+}
+
+// ScoreExplanation holds the signals a search score combines. Under
+// weighted fusion each of SemSim, LexSum and Trigram is divided by its
+// maximum over the ranked chunks before Weights are applied; under RRF the
+// score sums 1/(RRFK + rank) of the semantic and lexical ranks.
+type ScoreExplanation struct {
+	Fusion       string  `json:"fusion"`                 // "weighted" or "rrf"
+	LexicalOnly  bool    `json:"lexical_only,omitempty"` // the query could not be embedded
+	SemSim       float64 `json:"sem_sim"`                // summary embedding similarity
+	LexSum       float64 `json:"lex_sum"`                // full-text rank of the summary
+	Trigram      float64 `json:"trigram"`                // path similarity to the longest query token
+	ScriptBias   float64 `json:"script_bias"`            // +1 or -1 by language when the query asks for scripts
+	NoisePenalty float64 `json:"noise_penalty"`          // 1 for test, example and similar paths
+	MaxSem       float64 `json:"max_sem"`
+	MaxLex       float64 `json:"max_lex"`
+	MaxTrigram   float64 `json:"max_trigram"`
+
+	Weights *ScoreWeights `json:"weights,omitempty"` // weighted fusion
+	RRFK    int           `json:"rrf_k,omitempty"`   // RRF
+	SemRank int           `json:"sem_rank,omitempty"`
+	LexRank int           `json:"lex_rank,omitempty"`
+}
+
+// ScoreWeights are the weights of the signals under weighted fusion.
+// NoisePenalty is subtracted.
+type ScoreWeights struct {
+	Semantic     float64 `json:"semantic"`
+	Lexical      float64 `json:"lexical"`
+	Trigram      float64 `json:"trigram"`
+	ScriptBias   float64 `json:"script_bias"`
+	NoisePenalty float64 `json:"noise_penalty"`
+}
+
+// Highlight is a match within a snippet, as byte offsets [Start, End).
+type Highlight struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// SearchPage is one page of search results. Total is the number of chunks
+// that were ranked, which bounds how far a client can page.
+type SearchPage struct {
+	Results    []SearchResult `json:"results"`
+	Total      int            `json:"total"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// Rollup is a summary of a whole file or directory, built from the summaries
+// of the chunks or files it contains.
+type Rollup struct {
+	Repository string    `json:"repository"`
+	Ref        string    `json:"ref"`
+	Kind       string    `json:"kind"` // "file" or "dir"
+	Path       string    `json:"path"`
+	Summary    string    `json:"summary"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type RollupResult struct {
+	Rollup Rollup  `json:"rollup"`
+	Score  float64 `json:"score"`
+}
+
+// RepositoryStats describes what the index holds for one repository.
+type RepositoryStats struct {
+	Repository    string           `json:"repository"`
+	Chunks        int64            `json:"chunks"`
+	Files         int64            `json:"files"`
+	Refs          []string         `json:"refs"`
+	Languages     map[string]int64 `json:"languages"` // chunks per language
+	LastIndexedAt time.Time        `json:"last_indexed_at"`
+	StorageBytes  int64            `json:"storage_bytes"` // estimate: chunk summaries, content and vectors
+}
+
+// Facets counts the chunks matching a search by repository, language, ref
+// and top-level directory ("." for files at the repository root), most frequent
+// first.
+type Facets struct {
+	Repositories []FacetCount `json:"repositories"`
+	Languages    []FacetCount `json:"languages"`
+	Refs         []FacetCount `json:"refs"`
+	Directories  []FacetCount `json:"directories"`
+}
+
+// FacetCount is the number of matching chunks with a given value.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// Suggestions completes a partially typed query with matching file paths,
+// symbol names and earlier searches, best match first.
+type Suggestions struct {
+	Paths   []Suggestion `json:"paths"`
+	Symbols []Suggestion `json:"symbols"`
+	Queries []Suggestion `json:"queries"`
+}
+
+// Suggestion is one completion. Count is the number of matching chunks,
+// symbol definitions or past searches.
+type Suggestion struct {
+	Text   string `json:"text"`
+	Detail string `json:"detail,omitempty"` // the kind of a symbol
+	Count  int64  `json:"count"`
+}
+
+// QueryStats summarizes the searches served since a point in time.
+type QueryStats struct {
+	Since          time.Time    `json:"since"`
+	Queries        int64        `json:"queries"`
+	ZeroResults    int64        `json:"zero_results"`
+	Users          int64        `json:"users"`
+	AvgLatencyMS   float64      `json:"avg_latency_ms"`
+	TopQueries     []QueryCount `json:"top_queries"`
+	TopZeroResults []QueryCount `json:"top_zero_results"`
+}
+
+// QueryCount is the number of times a query was searched for.
+type QueryCount struct {
+	Query string `json:"query"` // the hash of the query unless its text is logged
+	Count int64  `json:"count"`
+}
+
+// IndexStats summarizes the contents of the index.
+type IndexStats struct {
+	Chunks       int64             `json:"chunks"`
+	Files        int64             `json:"files"`
+	StorageBytes int64             `json:"storage_bytes"`
+	Repositories []RepositoryStats `json:"repositories"`
+}
+
+// Answer is a synthesized answer to a question about the indexed code.
+// Each sentence cites the sources it was drawn from by their N.
+type Answer struct {
+	Question  string           `json:"question"`
+	Answer    string           `json:"answer"`
+	Sentences []AnswerSentence `json:"sentences"`
+	Sources   []Citation       `json:"sources"`
+}
+
+// AnswerSentence is one sentence of an answer and the sources it cites.
+type AnswerSentence struct {
+	Text      string `json:"text"`
+	Citations []int  `json:"citations"`
+}
+
+// Citation is a chunk given to the model as a numbered source.
+type Citation struct {
+	N          int     `json:"n"`
+	ChunkID    string  `json:"chunk_id"`
+	Repository string  `json:"repository"`
+	Ref        string  `json:"ref"`
+	Path       string  `json:"path"`
+	LineStart  int     `json:"line_start"`
+	LineEnd    int     `json:"line_end"`
+	Score      float64 `json:"score"`
+}
+
+// ChatTurn is one message of a conversation held through /chat.
+type ChatTurn struct {
+	Role    string `json:"role"` // "user" or "assistant"
+	Content string `json:"content"`
+
+	// Query is the standalone search query a user turn was rewritten to,
+	// and Sources the chunks an assistant turn could cite.
+	Query     string     `json:"query,omitempty"`
+	Sources   []Citation `json:"sources,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// SavedSearch is a named search bookmarked by a user. K is zero to use the
+// default.
+type SavedSearch struct {
+	ID        string        `json:"id"`
+	Name      string        `json:"name"`
+	Query     string        `json:"query"`
+	K         int           `json:"k,omitempty"`
+	Filters   SearchFilters `json:"filters"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// SearchFilters are the filters of a search, named after the /search
+// parameters they set.
+type SearchFilters struct {
+	Repositories    []string `json:"repository,omitempty"`
+	Languages       []string `json:"language,omitempty"`
+	Ref             string   `json:"ref,omitempty"`
+	Path            string   `json:"path,omitempty"`
+	PathContains    string   `json:"path_contains,omitempty"`
+	PathNotContains []string `json:"path_not_contains,omitempty"`
+	PathRegex       string   `json:"path_regex,omitempty"`
+}
+
+// Repository roles. Admin includes read.
+const (
+	RoleRead  = "read"
+	RoleAdmin = "admin"
+)
+
+// RepositoryGrant gives a principal a role on a repository. Principals are
+// user logins, "api-key/<id>" for API keys or "team:<org>/<team-slug>" for
+// GitHub teams. A repository with grants is private: only its grantees can
+// search or browse it. Repositories without grants stay open to every user.
+type RepositoryGrant struct {
+	Repository string    `json:"repository"`
+	Principal  string    `json:"principal"`
+	Role       string    `json:"role"` // read or admin
+	GrantedBy  string    `json:"granted_by"`
+	GrantedAt  time.Time `json:"granted_at"`
+}
+
+// SearchAudit records one search for the audit trail: who ran it, what was
+// asked and which repositories the results came from.
+type SearchAudit struct {
+	User         string    `json:"user"` // empty when anonymous
+	Path         string    `json:"path"` // the endpoint, e.g. /search
+	Query        string    `json:"query"`
+	Filters      string    `json:"filters"` // as URL-encoded /search parameters
+	Repositories []string  `json:"repositories"`
+	Results      int       `json:"results"`
+	At           time.Time `json:"at"`
+}
+
+// AuthEvent records one authentication event for security review: a login,
+// a refused token, a token refresh or a revocation. Outcome is success or
+// failure; Detail explains failures.
+type AuthEvent struct {
+	Event     string    `json:"event"` // login, validation, refresh, logout or revocation
+	User      string    `json:"user"`  // empty when unknown
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Outcome   string    `json:"outcome"`
+	Detail    string    `json:"detail,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// APIKey describes a long-lived key for calling the API without signing in.
+// The key itself is only known when it is created; Prefix, its first
+// characters, identifies it afterwards. Scopes limit the endpoints the key
+// may call; Repositories, when set, the repositories it can see. A key stops
+// working at ExpiresAt, when set.
+type APIKey struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Prefix string   `json:"prefix"`
+	Scopes []string `json:"scopes"`
+	// Repositories are the only repositories the key can search and read;
+	// empty means every repository its requests may otherwise see.
+	Repositories []string   `json:"repositories,omitempty"`
+	CreatedBy    string     `json:"created_by"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+}
+
+// RefreshToken is a long-lived token that renews a signed-in user's access
+// token. Each refresh replaces it with a new token of the same Family; a
+// token used twice revokes the family. User is the JSON-encoded user the
+// token signs in.
+type RefreshToken struct {
+	Family    string     `json:"family"`
+	User      string     `json:"user"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// IndexRun records one indexing run of a repository ref. Error is empty when
+// the run succeeded.
+type IndexRun struct {
+	Repository     string    `json:"repository"`
+	Ref            string    `json:"ref"`
+	Commit         string    `json:"commit,omitempty"`
+	StartedAt      time.Time `json:"started_at"`
+	FinishedAt     time.Time `json:"finished_at"`
+	FilesScanned   int64     `json:"files_scanned"`
+	FilesFailed    int64     `json:"files_failed"`
+	ChunksUpserted int64     `json:"chunks_upserted"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// RefStatus is the indexing state of one ref of a repository. LastCommit and
+// LastIndexedAt come from the last successful run; LastRun is the most
+// recent run, which may have failed.
+type RefStatus struct {
+	Ref           string     `json:"ref"`
+	Chunks        int64      `json:"chunks"`
+	LastCommit    string     `json:"last_commit,omitempty"`
+	LastIndexedAt *time.Time `json:"last_indexed_at,omitempty"`
+	LastRun       *IndexRun  `json:"last_run,omitempty"`
+}
+
+// RepositoryStatus is the indexing state of every ref of a repository that
+// has chunks or recorded runs.
+type RepositoryStatus struct {
+	Repository string      `json:"repository"`
+	Refs       []RefStatus `json:"refs"`
+}
+
+// JobStatus is the state of a background job.
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCanceled  JobStatus = "canceled"
+)
+
+// Finished reports whether a job with this status will not run again.
+func (s JobStatus) Finished() bool {
+	return s == JobSucceeded || s == JobFailed || s == JobCanceled
+}
+
+// Job is a background task run from the job queue, such as indexing a
+// repository. Params holds the kind-specific request and Progress the
+// counters reported while the job runs.
+type Job struct {
+	ID              string           `json:"id"`
+	Kind            string           `json:"kind"`
+	Params          json.RawMessage  `json:"params,omitempty"`
+	Status          JobStatus        `json:"status"`
+	User            string           `json:"user,omitempty"`
+	CreatedAt       time.Time        `json:"created_at"`
+	StartedAt       *time.Time       `json:"started_at,omitempty"`
+	FinishedAt      *time.Time       `json:"finished_at,omitempty"`
+	Progress        map[string]int64 `json:"progress,omitempty"`
+	Error           string           `json:"error,omitempty"`
+	CancelRequested bool             `json:"cancel_requested,omitempty"`
+}
+
+// JobLog is one line of a job's log.
+type JobLog struct {
+	At      time.Time `json:"at"`
+	Message string    `json:"message"`
 }
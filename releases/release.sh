@@ -64,6 +64,7 @@ for goos in "${GOOSS[@]}"; do
             mkdir reposearch-indexer
             pushd reposearch-indexer
             GOOS=$goos GOARCH=$goarch go build -ldflags "-X main.version=$TAG" github.com/seanblong/reposearch/cmd/indexer
+            GOOS=$goos GOARCH=$goarch go build -ldflags "-X main.version=$TAG" github.com/seanblong/reposearch/cmd/reposearch
             popd
             tar -cvzf "downloads/$TAG/reposearch.$TAG.$goos.$goarch.tar.gz" reposearch-indexer
             rm -rf reposearch-indexer